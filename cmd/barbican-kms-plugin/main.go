@@ -18,19 +18,25 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
 	"k8s.io/cloud-provider-openstack/pkg/kms/server"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 	"k8s.io/component-base/cli"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 )
 
 var (
-	socketpath  string
-	cloudconfig string
+	socketpath     string
+	cloudconfig    string
+	metricsAddress string
+	debugOpts      debug.Options
 )
 
 func main() {
@@ -55,6 +61,20 @@ func main() {
 		Use:   "barbican-kms-plugin",
 		Short: "Barbican KMS plugin for kubernetes",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := debug.Start(debugOpts); err != nil {
+				return err
+			}
+			if metricsAddress != "" {
+				metrics.RegisterKMSMetrics()
+				go func() {
+					mux := http.NewServeMux()
+					mux.Handle("/metrics", legacyregistry.Handler())
+					klog.Infof("Serving metrics on %s/metrics", metricsAddress)
+					if err := http.ListenAndServe(metricsAddress, mux); err != nil {
+						klog.Errorf("Failed to serve metrics: %v", err)
+					}
+				}()
+			}
 			sigchan := make(chan os.Signal, 1)
 			signal.Notify(sigchan, unix.SIGTERM, unix.SIGINT)
 			err := server.Run(cloudconfig, socketpath, sigchan)
@@ -74,6 +94,10 @@ func main() {
 		klog.Fatalf("Unable to mark flag cloud-config to be required: %v", err)
 	}
 
+	cmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "", "The address to serve the metrics of the encrypt and decrypt requests and of the Barbican API calls on, e.g. 127.0.0.1:9100. Not served if empty.")
+
+	debugOpts.AddFlags(cmd.PersistentFlags())
+
 	code := cli.Run(cmd)
 	os.Exit(code)
 }
@@ -19,15 +19,19 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
 	"k8s.io/cloud-provider-openstack/pkg/util/mount"
 	"k8s.io/component-base/cli"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 )
 
@@ -36,6 +40,25 @@ var (
 	nodeID      string
 	cloudconfig []string
 	cluster     string
+	journalDir  string
+	pvValidator bool
+	kubeconfig  string
+
+	attachEvents bool
+
+	deleteConcurrency int
+	deleteRetries     int
+	metricsAddress    string
+	volumeIOMetrics   bool
+
+	volumeCacheSize    int
+	volumeCacheMaxGB   int
+	volumeCacheMinUses int
+
+	volumeUsageWarning  int
+	volumeUsageCritical int
+
+	debugOpts debug.Options
 )
 
 func main() {
@@ -89,6 +112,24 @@ func main() {
 
 	cmd.PersistentFlags().StringVar(&cluster, "cluster", "", "The identifier of the cluster that the plugin is running in.")
 
+	cmd.PersistentFlags().StringVar(&journalDir, "node-journal-dir", "", "Directory where the node plugin journals the stage and publish steps of each volume, used to recover from crashes. Journaling is disabled if empty.")
+
+	cmd.PersistentFlags().BoolVar(&pvValidator, "pv-validator", false, "Validate pre-provisioned Cinder PVs when they are created. Should only be enabled on the controller plugin.")
+	cmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig used by the PV validator, the attach events, the volume usage monitor and the volume IO metrics. In-cluster config is used if empty.")
+	cmd.PersistentFlags().BoolVar(&attachEvents, "attach-events", false, "Record an event on the node each time a volume is attached to or detached from it. Should only be enabled on the controller plugin.")
+
+	cmd.PersistentFlags().IntVar(&deleteConcurrency, "delete-concurrency", 0, "Maximum number of volumes the controller plugin deletes at the same time. Deletions are not throttled if 0.")
+	cmd.PersistentFlags().IntVar(&deleteRetries, "delete-retries", 3, "Number of times a failed volume deletion is retried when deletions are throttled.")
+	cmd.PersistentFlags().IntVar(&volumeCacheSize, "volume-cache-size", 0, "Maximum number of volumes the controller plugin caches to clone the volumes created from a snapshot. Volumes are not cached if 0.")
+	cmd.PersistentFlags().IntVar(&volumeCacheMaxGB, "volume-cache-max-gb", 0, "Maximum total size in GiB of the cached volumes. The size is not limited if 0.")
+	cmd.PersistentFlags().IntVar(&volumeCacheMinUses, "volume-cache-min-uses", 2, "Number of volumes created from a snapshot before the snapshot gets a cached volume.")
+	cmd.PersistentFlags().IntVar(&volumeUsageWarning, "volume-usage-warning-threshold", 0, "Usage in percent of a filesystem volume over which the node plugin records a warning event on its PVC. Disabled if 0.")
+	cmd.PersistentFlags().IntVar(&volumeUsageCritical, "volume-usage-critical-threshold", 0, "Usage in percent of a filesystem volume over which the node plugin records a critical event on its PVC. Disabled if 0.")
+	cmd.PersistentFlags().BoolVar(&volumeIOMetrics, "volume-io-metrics", false, "Export the IO statistics of the volumes staged on the node, labeled with their PV, as metrics of the node plugin. Requires --metrics-address.")
+	cmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "", "The address to expose the metrics of the plugin on, e.g. :9808. Metrics are not exposed if empty.")
+
+	debugOpts.AddFlags(cmd.PersistentFlags())
+
 	openstack.AddExtraFlags(pflag.CommandLine)
 
 	code := cli.Run(cmd)
@@ -96,9 +137,35 @@ func main() {
 }
 
 func handle() {
+	if err := debug.Start(debugOpts); err != nil {
+		klog.Fatalf("Failed to start debug endpoint: %v", err)
+	}
 
 	// Initialize cloud
 	d := cinder.NewDriver(endpoint, cluster)
+	d.SetNodeJournalDir(journalDir)
+	d.SetDeletionQueue(deleteConcurrency, deleteRetries)
+	d.SetVolumeCache(volumeCacheSize, volumeCacheMaxGB, volumeCacheMinUses)
+	usageMonitor, err := cinder.NewVolumeUsageMonitor(kubeconfig, volumeUsageWarning, volumeUsageCritical)
+	if err != nil {
+		klog.Fatalf("Failed to create volume usage monitor: %v", err)
+	}
+	d.SetVolumeUsageMonitor(usageMonitor)
+	if attachEvents {
+		r, err := cinder.NewAttachEventRecorder(kubeconfig)
+		if err != nil {
+			klog.Fatalf("Failed to create attach event recorder: %v", err)
+		}
+		d.SetAttachEventRecorder(r)
+	}
+	if volumeIOMetrics {
+		ioCollector, err := cinder.NewVolumeIOCollector(kubeconfig)
+		if err != nil {
+			klog.Fatalf("Failed to create volume IO collector: %v", err)
+		}
+		legacyregistry.CustomMustRegister(ioCollector)
+		d.SetVolumeIOCollector(ioCollector)
+	}
 	openstack.InitOpenStackProvider(cloudconfig)
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
@@ -112,5 +179,25 @@ func handle() {
 	metadata := metadata.GetMetadataProvider(cloud.GetMetadataOpts().SearchOrder)
 
 	d.SetupDriver(cloud, mount, metadata)
+
+	if pvValidator {
+		v, err := cinder.NewPVValidator(cloud, kubeconfig)
+		if err != nil {
+			klog.Fatalf("Failed to create PV validator: %v", err)
+		}
+		go v.Run(wait.NeverStop)
+	}
+
+	if metricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", legacyregistry.Handler())
+			klog.Infof("Serving metrics on %s/metrics", metricsAddress)
+			if err := http.ListenAndServe(metricsAddress, mux); err != nil {
+				klog.Errorf("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
 	d.Run()
 }
@@ -22,6 +22,7 @@ import (
 	"k8s.io/klog/v2"
 
 	"k8s.io/cloud-provider-openstack/pkg/identity/keystone"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 	kflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/logs"
 )
@@ -54,6 +55,8 @@ func main() {
 
 	config := keystone.NewConfig()
 	config.AddFlags(pflag.CommandLine)
+	var debugOpts debug.Options
+	debugOpts.AddFlags(pflag.CommandLine)
 	kflag.InitFlags()
 
 	if err := config.ValidateFlags(); err != nil {
@@ -61,6 +64,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := debug.Start(debugOpts); err != nil {
+		klog.Errorf("%v", err)
+		os.Exit(1)
+	}
+
 	keystoneAuth, err := keystone.NewKeystoneAuth(config)
 	if err != nil {
 		klog.Errorf("%v", err)
@@ -21,14 +21,18 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/runtimeconfig"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 	"k8s.io/component-base/cli"
 	"k8s.io/klog/v2"
 )
@@ -45,6 +49,10 @@ var (
 	userAgentData         []string
 	compatibilitySettings string
 	clusterID             string
+	shareGCPeriod         time.Duration
+	kubeconfig            string
+	autoShareNetwork      bool
+	debugOpts             debug.Options
 )
 
 func validateShareProtocolSelector(v string) error {
@@ -92,6 +100,20 @@ func parseCompatOpts() (*options.CompatibilityOptions, error) {
 	return options.NewCompatibilityOptions(data)
 }
 
+func newKubeClient(kubeconfig string) (kubernetes.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes client config: %v", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	return kubeClient, nil
+}
+
 func main() {
 	if err := flag.CommandLine.Parse([]string{}); err != nil {
 		klog.Fatalf("Unable to parse flags: %v", err)
@@ -126,11 +148,23 @@ func main() {
 				klog.Fatalf(err.Error())
 			}
 
+			if err := debug.Start(debugOpts); err != nil {
+				klog.Fatalf("failed to start debug endpoint: %v", err)
+			}
+
 			compatOpts, err := parseCompatOpts()
 			if err != nil {
 				klog.Fatalf("failed to parse compatibility settings: %v", err)
 			}
 
+			var kubeClient kubernetes.Interface
+			if shareGCPeriod > 0 {
+				kubeClient, err = newKubeClient(kubeconfig)
+				if err != nil {
+					klog.Warningf("soft-deleted shares are only collected for the projects of the requests received since startup: %v", err)
+				}
+			}
+
 			manilaClientBuilder := &manilaclient.ClientBuilder{UserAgent: "manila-csi-plugin", ExtraUserAgentData: userAgentData}
 			csiClientBuilder := &csiclient.ClientBuilder{}
 
@@ -147,6 +181,9 @@ func main() {
 					CSIClientBuilder:    csiClientBuilder,
 					CompatOpts:          compatOpts,
 					ClusterID:           clusterID,
+					ShareGCPeriod:       shareGCPeriod,
+					KubeClient:          kubeClient,
+					AutoShareNetwork:    autoShareNetwork,
 				},
 			)
 
@@ -193,6 +230,14 @@ func main() {
 
 	cmd.PersistentFlags().StringVar(&clusterID, "cluster-id", "", "The identifier of the cluster that the plugin is running in.")
 
+	cmd.PersistentFlags().DurationVar(&shareGCPeriod, "share-gc-period", time.Hour, "How often the shares soft-deleted with the softDeleteRetention StorageClass parameter are checked for deletion. Zero disables their deletion.")
+
+	cmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig used to read the provisioner secrets of the StorageClasses when collecting the soft-deleted shares. In-cluster config is used if empty.")
+
+	cmd.PersistentFlags().BoolVar(&autoShareNetwork, "auto-share-network", false, "Find or create the share network of the volumes of the share types with driver_handles_share_servers=true when the shareNetworkID volume parameter is not set, from the Neutron network of the instance the controller plugin runs on.")
+
+	debugOpts.AddFlags(cmd.PersistentFlags())
+
 	code := cli.Run(cmd)
 	os.Exit(code)
 }
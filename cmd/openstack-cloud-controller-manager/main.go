@@ -40,6 +40,7 @@ import (
 	_ "k8s.io/kubernetes/pkg/features" // add the kubernetes feature gates
 
 	"k8s.io/cloud-provider-openstack/pkg/openstack"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 	"k8s.io/cloud-provider-openstack/pkg/version"
 )
 
@@ -76,6 +77,11 @@ func main() {
 }
 
 func cloudInitializer(config *config.CompletedConfig) cloudprovider.Interface {
+	// The flags are parsed, the initial log verbosity is known. The pprof
+	// profiles and the log verbosity are served on the secure port,
+	// authenticated and authorized by the API server, see --profiling
+	debug.HandleVerbositySignals()
+
 	cloudConfig := config.ComponentConfig.KubeCloudShared.CloudProvider
 
 	// initialize cloud provider with the cloud provider name and config file provided
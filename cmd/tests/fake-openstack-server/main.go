@@ -0,0 +1,92 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The fake OpenStack server serves the subsets of the OpenStack APIs used by
+// the controllers and the CSI drivers from memory, to run them with flag
+// --cloud-api-endpoint-override without a cloud.
+package main
+
+import (
+	"encoding/json"
+	goflag "flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/tests/fakeopenstack"
+)
+
+var (
+	bindAddress string
+	seedFile    string
+)
+
+func main() {
+	klog.InitFlags(nil)
+	pflag.StringVar(&bindAddress, "bind-address", "127.0.0.1:8090", "The address to serve the OpenStack APIs on.")
+	pflag.StringVar(&seedFile, "seed", "", "A JSON file of the resources to create on start, as lists by collection, e.g. {\"servers\": [{\"id\": \"...\", \"name\": \"node-1\"}], \"networks\": [...]}.")
+	pflag.CommandLine.AddGoFlagSet(goflag.CommandLine)
+	pflag.Parse()
+
+	server := fakeopenstack.NewServer()
+	if seedFile != "" {
+		if err := seed(server, seedFile); err != nil {
+			klog.Fatalf("Failed to seed the resources: %v", err)
+		}
+	}
+
+	klog.Infof("Serving the fake OpenStack APIs on http://%s/", bindAddress)
+	if err := http.ListenAndServe(bindAddress, server); err != nil {
+		klog.Fatalf("Failed to serve: %v", err)
+	}
+}
+
+// seed creates the resources of the file.
+func seed(server *fakeopenstack.Server, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var resources map[string][]fakeopenstack.Resource
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	// The networks and subnets are referenced by the other resources
+	for _, collection := range []string{"networks", "subnets"} {
+		if err := seedCollection(server, collection, resources[collection]); err != nil {
+			return err
+		}
+		delete(resources, collection)
+	}
+	for collection, list := range resources {
+		if err := seedCollection(server, collection, list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seedCollection(server *fakeopenstack.Server, collection string, list []fakeopenstack.Resource) error {
+	for _, r := range list {
+		if _, err := server.Create(collection, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
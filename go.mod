@@ -25,6 +25,7 @@ require (
 	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f
 	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd
 	golang.org/x/sys v0.0.0-20220209214540-3681064d5158
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8
 	google.golang.org/grpc v1.40.0
 	gopkg.in/gcfg.v1 v1.2.3
 	gopkg.in/godo.v2 v2.0.9
@@ -35,6 +36,7 @@ require (
 	k8s.io/client-go v0.24.0
 	k8s.io/cloud-provider v0.24.0
 	k8s.io/component-base v0.24.0
+	k8s.io/component-helpers v0.24.0
 	k8s.io/klog/v2 v2.60.1
 	k8s.io/kubernetes v1.24.0
 	k8s.io/mount-utils v0.24.0
@@ -125,7 +127,6 @@ require (
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
 	golang.org/x/text v0.3.7 // indirect
-	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20220107163113-42d7afdf6368 // indirect
@@ -137,7 +138,6 @@ require (
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 	k8s.io/apiextensions-apiserver v0.0.0 // indirect
-	k8s.io/component-helpers v0.24.0 // indirect
 	k8s.io/controller-manager v0.24.0 // indirect
 	k8s.io/csi-translation-lib v0.24.0 // indirect
 	k8s.io/klog v1.0.0 // indirect
@@ -35,11 +35,13 @@ import (
 
 	"k8s.io/cloud-provider-openstack/pkg/autohealing/config"
 	"k8s.io/cloud-provider-openstack/pkg/autohealing/controller"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 )
 
 var (
-	cfgFile string
-	conf    config.Config
+	cfgFile   string
+	conf      config.Config
+	debugOpts debug.Options
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -51,6 +53,10 @@ var rootCmd = &cobra.Command{
 		"OpenStack is supported by default.",
 
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := debug.Start(debugOpts); err != nil {
+			log.Fatalf("failed to start debug endpoint, error: %v", err)
+		}
+
 		autohealer := controller.NewController(conf)
 
 		if !conf.LeaderElect {
@@ -94,6 +100,7 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kube_autohealer_config.yaml)")
+	debugOpts.AddFlags(rootCmd.PersistentFlags())
 
 	log.InitFlags(nil)
 	_ = goflag.CommandLine.Parse(nil)
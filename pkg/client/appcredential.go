@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// applicationCredentialFilePollPeriod is how often the application
+// credential file is checked for changes.
+var applicationCredentialFilePollPeriod = 10 * time.Second
+
+// readApplicationCredentialFile reads the application credential file, a
+// JSON object with the application credential keys of the auth section of
+// clouds.yaml, e.g. {"application_credential_id": "...",
+// "application_credential_secret": "..."}.
+func readApplicationCredentialFile(path string) (*clientconfig.AuthInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the application credential file: %v", err)
+	}
+
+	var auth clientconfig.AuthInfo
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, fmt.Errorf("failed to decode the application credential file %s: %v", path, err)
+	}
+	if auth.ApplicationCredentialID == "" && auth.ApplicationCredentialName == "" {
+		return nil, fmt.Errorf("the application credential file %s has neither application_credential_id nor application_credential_name", path)
+	}
+	if auth.ApplicationCredentialSecret == "" {
+		return nil, fmt.Errorf("the application credential file %s has no application_credential_secret", path)
+	}
+	return &auth, nil
+}
+
+// withApplicationCredentialFile returns the options with the application
+// credential of the file, which replaces the one and the password of the
+// options.
+func (authOpts AuthOpts) withApplicationCredentialFile() (AuthOpts, error) {
+	auth, err := readApplicationCredentialFile(authOpts.ApplicationCredentialFile)
+	if err != nil {
+		return authOpts, err
+	}
+
+	// The application credential is only used without password
+	authOpts.Password = ""
+	authOpts.ApplicationCredentialID = auth.ApplicationCredentialID
+	authOpts.ApplicationCredentialName = auth.ApplicationCredentialName
+	authOpts.ApplicationCredentialSecret = auth.ApplicationCredentialSecret
+
+	return authOpts, nil
+}
+
+// WatchApplicationCredentialFile renews the token of the provider as soon as
+// the content of the application credential file changes, e.g. when the
+// Secret it is mounted from is updated with a rotated application
+// credential, so that the previous one can be deleted without waiting for
+// the token to expire. A file which cannot be read or decoded is ignored
+// until it is fixed, the provider keeps its token. It returns when stopCh is
+// closed.
+func WatchApplicationCredentialFile(provider *gophercloud.ProviderClient, path string, stopCh <-chan struct{}) {
+	last, _ := ioutil.ReadFile(path)
+	wait.Until(func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			klog.Warningf("Failed to read the application credential file: %v", err)
+			return
+		}
+		if bytes.Equal(data, last) {
+			return
+		}
+		if _, err := readApplicationCredentialFile(path); err != nil {
+			klog.Warningf("Ignoring the change of the application credential file: %v", err)
+			return
+		}
+
+		if err := provider.Reauthenticate(provider.Token()); err != nil {
+			klog.Errorf("Failed to authenticate with the application credential of %s: %v", path, err)
+			return
+		}
+		last = data
+		klog.Infof("Renewed the token with the application credential of %s", path)
+	}, applicationCredentialFilePollPeriod, stopCh)
+}
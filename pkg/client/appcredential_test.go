@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplicationCredentialFile(t *testing.T) {
+	creds := filepath.Join(t.TempDir(), "appcred.json")
+	err := ioutil.WriteFile(creds, []byte(`{"application_credential_id": "appcred-1", "application_credential_secret": "secret-1"}`), 0600)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var secrets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Auth struct {
+				Identity struct {
+					Methods               []string `json:"methods"`
+					ApplicationCredential struct {
+						ID     string `json:"id"`
+						Secret string `json:"secret"`
+					} `json:"application_credential"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []string{"application_credential"}, req.Auth.Identity.Methods)
+		appCred := req.Auth.Identity.ApplicationCredential
+		mu.Lock()
+		secrets = append(secrets, appCred.ID+":"+appCred.Secret)
+		token := fmt.Sprintf("token-%d", len(secrets))
+		mu.Unlock()
+
+		w.Header().Set("X-Subject-Token", token)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"token": {"catalog": []}}`)
+	}))
+	defer server.Close()
+
+	cfg := &AuthOpts{
+		AuthURL:                   server.URL + "/v3/",
+		ApplicationCredentialFile: creds,
+	}
+	provider, err := NewOpenStackClient(cfg, "test")
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", provider.Token())
+
+	applicationCredentialFilePollPeriod = 10 * time.Millisecond
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go WatchApplicationCredentialFile(provider, creds, stopCh)
+
+	// An invalid file is ignored
+	err = ioutil.WriteFile(creds, []byte(`{"application_credential_id": "appcred-2"}`), 0600)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "token-1", provider.Token())
+
+	// The rotated application credential is used at once
+	err = ioutil.WriteFile(creds, []byte(`{"application_credential_id": "appcred-2", "application_credential_secret": "secret-2"}`), 0600)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return provider.Token() == "token-2"
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"appcred-1:secret-1", "appcred-2:secret-2"}, secrets)
+}
+
+func TestReadApplicationCredentialFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"missing-secret": `{"application_credential_id": "appcred"}`,
+		"missing-id":     `{"application_credential_secret": "secret"}`,
+		"invalid":        `application_credential_id: appcred`,
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		_, err := readApplicationCredentialFile(path)
+		assert.Error(t, err, name)
+	}
+
+	_, err := readApplicationCredentialFile(filepath.Join(dir, "not-found"))
+	assert.Error(t, err)
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/gophercloud/utils/client"
 	"github.com/gophercloud/utils/openstack/clientconfig"
+	"github.com/spf13/pflag"
 
 	"k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/util/cert"
@@ -37,6 +38,16 @@ import (
 	"k8s.io/klog/v2"
 )
 
+// endpointOverride is the URL of the test mode serving all the OpenStack
+// APIs, empty outside of the test mode
+var endpointOverride string
+
+// AddTestModeFlags adds the flags of the test mode, which uses a fake
+// OpenStack API server instead of the cloud.
+func AddTestModeFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&endpointOverride, "cloud-api-endpoint-override", "", "Test mode: the URL serving all the OpenStack APIs under the path of their service type, e.g. http://127.0.0.1:8090/ for the fake server of tests/fakeopenstack. The authentication and the service catalog are skipped. Never set it with a real cloud.")
+}
+
 type AuthOpts struct {
 	AuthURL          string                   `gcfg:"auth-url" mapstructure:"auth-url" name:"os-authURL" dependsOn:"os-password|os-trustID|os-applicationCredentialSecret|os-applicationCredentialID|os-applicationCredentialName|os-clientCertPath"`
 	UserID           string                   `gcfg:"user-id" mapstructure:"user-id" name:"os-userID" value:"optional" dependsOn:"os-password"`
@@ -57,6 +68,12 @@ type AuthOpts struct {
 	EndpointType     gophercloud.Availability `gcfg:"os-endpoint-type" mapstructure:"os-endpoint-type" name:"os-endpointType" value:"optional"`
 	CAFile           string                   `gcfg:"ca-file" mapstructure:"ca-file" name:"os-certAuthorityPath" value:"optional"`
 	TLSInsecure      string                   `gcfg:"tls-insecure" mapstructure:"tls-insecure" name:"os-TLSInsecure" value:"optional" matches:"^true|false$"`
+	EndpointFailover bool                     `gcfg:"endpoint-failover" mapstructure:"endpoint-failover" name:"os-endpointFailover" value:"optional"`
+
+	// RateLimits limits the rate of the requests to each service type, see parseRateLimits
+	RateLimits string `gcfg:"rate-limits" mapstructure:"rate-limits" name:"os-rateLimits" value:"optional"`
+	// BackoffRetries is how many times a request rejected by an overloaded service is retried
+	BackoffRetries int `gcfg:"backoff-retries" mapstructure:"backoff-retries" name:"os-backoffRetries" value:"optional"`
 
 	// TLS client auth
 	CertFile string `gcfg:"cert-file" mapstructure:"cert-file" name:"os-clientCertPath" value:"optional" dependsOn:"os-clientKeyPath"`
@@ -72,6 +89,11 @@ type AuthOpts struct {
 	ApplicationCredentialID     string `gcfg:"application-credential-id" mapstructure:"application-credential-id" name:"os-applicationCredentialID" value:"optional"`
 	ApplicationCredentialName   string `gcfg:"application-credential-name" mapstructure:"application-credential-name" name:"os-applicationCredentialName" value:"optional"`
 	ApplicationCredentialSecret string `gcfg:"application-credential-secret" mapstructure:"application-credential-secret" name:"os-applicationCredentialSecret" value:"optional"`
+
+	// CredentialHelper is a command printing the credentials, run on each authentication
+	CredentialHelper string `gcfg:"credential-helper" mapstructure:"credential-helper" name:"os-credentialHelper" value:"optional"`
+	// ApplicationCredentialFile is a JSON file of the application credential, read on each authentication
+	ApplicationCredentialFile string `gcfg:"application-credential-file" mapstructure:"application-credential-file" name:"os-applicationCredentialFile" value:"optional"`
 }
 
 func LogCfg(authOpts AuthOpts) {
@@ -97,6 +119,8 @@ func LogCfg(authOpts AuthOpts) {
 	klog.V(5).Infof("Cloud: %s", authOpts.Cloud)
 	klog.V(5).Infof("ApplicationCredentialID: %s", authOpts.ApplicationCredentialID)
 	klog.V(5).Infof("ApplicationCredentialName: %s", authOpts.ApplicationCredentialName)
+	klog.V(5).Infof("CredentialHelper: %s", authOpts.CredentialHelper)
+	klog.V(5).Infof("ApplicationCredentialFile: %s", authOpts.ApplicationCredentialFile)
 }
 
 type Logger struct{}
@@ -236,11 +260,39 @@ func ReadClouds(authOpts *AuthOpts) error {
 	return nil
 }
 
+// hasResolvedCredentials returns whether the credentials are resolved on each
+// authentication, by the credential helper or from the application
+// credential file.
+func (authOpts AuthOpts) hasResolvedCredentials() bool {
+	return authOpts.CredentialHelper != "" || authOpts.ApplicationCredentialFile != ""
+}
+
+// resolveCredentials returns the options with the credentials of the
+// credential helper, then the application credential of the file.
+func (authOpts AuthOpts) resolveCredentials() (AuthOpts, error) {
+	var err error
+	if authOpts.CredentialHelper != "" {
+		if authOpts, err = authOpts.withCredentialHelper(); err != nil {
+			return authOpts, err
+		}
+	}
+	if authOpts.ApplicationCredentialFile != "" {
+		if authOpts, err = authOpts.withApplicationCredentialFile(); err != nil {
+			return authOpts, err
+		}
+	}
+	return authOpts, nil
+}
+
 // NewOpenStackClient creates a new instance of the openstack client
 func NewOpenStackClient(cfg *AuthOpts, userAgent string, extraUserAgent ...string) (*gophercloud.ProviderClient, error) {
-	provider, err := openstack.NewClient(cfg.AuthURL)
-	if err != nil {
-		return nil, err
+	helperCfg := *cfg
+	if cfg.hasResolvedCredentials() {
+		resolved, err := cfg.resolveCredentials()
+		if err != nil {
+			return nil, err
+		}
+		cfg = &resolved
 	}
 
 	ua := gophercloud.UserAgent{}
@@ -248,9 +300,24 @@ func NewOpenStackClient(cfg *AuthOpts, userAgent string, extraUserAgent ...strin
 	for _, data := range extraUserAgent {
 		ua.Prepend(data)
 	}
-	provider.UserAgent = ua
 	klog.V(4).Infof("Using user-agent %s", ua.Join())
 
+	if endpointOverride != "" {
+		klog.Warningf("Test mode: using the OpenStack APIs served on %s without authentication", endpointOverride)
+		provider, err := NewEndpointOverrideClient(endpointOverride)
+		if err != nil {
+			return nil, err
+		}
+		provider.UserAgent = ua
+		return provider, nil
+	}
+
+	provider, err := openstack.NewClient(cfg.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+	provider.UserAgent = ua
+
 	var caPool *x509.CertPool
 	if cfg.CAFile != "" {
 		// read and parse CA certificate from file
@@ -291,8 +358,68 @@ func NewOpenStackClient(cfg *AuthOpts, userAgent string, extraUserAgent ...strin
 		}
 	}
 
+	var failover *endpointFailover
+	if cfg.EndpointFailover {
+		failover = newEndpointFailover(provider.HTTPClient.Transport)
+		provider.HTTPClient.Transport = failover
+	}
+
+	var limiter *rateLimiter
+	if cfg.RateLimits != "" || cfg.BackoffRetries > 0 {
+		limits, err := parseRateLimits(cfg.RateLimits)
+		if err != nil {
+			return nil, err
+		}
+		limiter = newRateLimiter(provider.HTTPClient.Transport, limits, cfg.BackoffRetries)
+		provider.HTTPClient.Transport = limiter
+	}
+
+	err = authenticate(provider, cfg, true)
+	if err == nil && helperCfg.hasResolvedCredentials() {
+		setResolvedCredentialsReauth(provider, helperCfg)
+	}
+
+	if err == nil && failover != nil {
+		failover.setEndpointLocator(provider)
+	}
+
+	// The requests are limited by the service type of the endpoint the
+	// client is created with, even if failed over to another endpoint
+	if err == nil && limiter != nil {
+		limiter.setEndpointLocator(provider)
+	}
+
+	return provider, err
+}
+
+// NewEndpointOverrideClient returns a client of the OpenStack APIs served on
+// the endpoint under the path of their service type, e.g. the Neutron API on
+// <endpoint>/network/, as served by the fake server of tests/fakeopenstack.
+// The client is not authenticated.
+func NewEndpointOverrideClient(endpoint string) (*gophercloud.ProviderClient, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("invalid endpoint %q, it must be an http or https URL", endpoint)
+	}
+	endpoint = gophercloud.NormalizeURL(endpoint)
+
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:     endpoint,
+		IdentityEndpoint: endpoint,
+	}
+	provider.HTTPClient = http.Client{}
+	provider.SetToken("fake-token")
+	provider.EndpointLocator = func(eo gophercloud.EndpointOpts) (string, error) {
+		return endpoint + eo.Type + "/", nil
+	}
+	return provider, nil
+}
+
+// authenticate authenticates the provider with the options, with a trust if
+// set.
+func authenticate(provider *gophercloud.ProviderClient, cfg *AuthOpts, allowReauth bool) error {
 	if cfg.TrustID != "" {
 		opts := cfg.ToAuth3Options()
+		opts.AllowReauth = allowReauth
 
 		// support for the legacy manila auth
 		// if TrusteeID and TrusteePassword were defined, then use them
@@ -303,13 +430,10 @@ func NewOpenStackClient(cfg *AuthOpts, userAgent string, extraUserAgent ...strin
 			TrustID:            cfg.TrustID,
 			AuthOptionsBuilder: &opts,
 		}
-		err = openstack.AuthenticateV3(provider, authOptsExt, gophercloud.EndpointOpts{})
-
-		return provider, err
+		return openstack.AuthenticateV3(provider, authOptsExt, gophercloud.EndpointOpts{})
 	}
 
 	opts := cfg.ToAuthOptions()
-	err = openstack.Authenticate(provider, opts)
-
-	return provider, err
+	opts.AllowReauth = allowReauth
+	return openstack.Authenticate(provider, opts)
 }
@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+	"k8s.io/klog/v2"
+)
+
+// credentialHelperTimeout is the timeout of a run of the credential helper
+const credentialHelperTimeout = 30 * time.Second
+
+// runCredentialHelper runs the credential helper command and decodes the
+// credentials it prints, a JSON object with the keys of the auth section of
+// clouds.yaml, e.g. {"username": "demo", "password": "secret"}.
+func runCredentialHelper(command string) (*clientconfig.AuthInfo, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("credential helper command is empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run the credential helper %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	var auth clientconfig.AuthInfo
+	if err := json.Unmarshal(stdout.Bytes(), &auth); err != nil {
+		return nil, fmt.Errorf("failed to decode the credentials printed by the credential helper %s: %v", args[0], err)
+	}
+	return &auth, nil
+}
+
+// withCredentialHelper returns the options with the credentials printed by
+// the credential helper, which take precedence over the options.
+func (authOpts AuthOpts) withCredentialHelper() (AuthOpts, error) {
+	auth, err := runCredentialHelper(authOpts.CredentialHelper)
+	if err != nil {
+		return authOpts, err
+	}
+
+	authOpts.AuthURL = replaceEmpty(auth.AuthURL, authOpts.AuthURL)
+	authOpts.UserID = replaceEmpty(auth.UserID, authOpts.UserID)
+	authOpts.Username = replaceEmpty(auth.Username, authOpts.Username)
+	authOpts.Password = replaceEmpty(auth.Password, authOpts.Password)
+	authOpts.TenantID = replaceEmpty(auth.ProjectID, authOpts.TenantID)
+	authOpts.TenantName = replaceEmpty(auth.ProjectName, authOpts.TenantName)
+	authOpts.DomainID = replaceEmpty(auth.DomainID, authOpts.DomainID)
+	authOpts.DomainName = replaceEmpty(auth.DomainName, authOpts.DomainName)
+	authOpts.TenantDomainID = replaceEmpty(auth.ProjectDomainID, authOpts.TenantDomainID)
+	authOpts.TenantDomainName = replaceEmpty(auth.ProjectDomainName, authOpts.TenantDomainName)
+	authOpts.UserDomainID = replaceEmpty(auth.UserDomainID, authOpts.UserDomainID)
+	authOpts.UserDomainName = replaceEmpty(auth.UserDomainName, authOpts.UserDomainName)
+	authOpts.ApplicationCredentialID = replaceEmpty(auth.ApplicationCredentialID, authOpts.ApplicationCredentialID)
+	authOpts.ApplicationCredentialName = replaceEmpty(auth.ApplicationCredentialName, authOpts.ApplicationCredentialName)
+	authOpts.ApplicationCredentialSecret = replaceEmpty(auth.ApplicationCredentialSecret, authOpts.ApplicationCredentialSecret)
+
+	return authOpts, nil
+}
+
+// setResolvedCredentialsReauth makes the provider run the credential helper,
+// or read the application credential file, again each time it renews its
+// token, so that rotated credentials are used without restarting.
+func setResolvedCredentialsReauth(provider *gophercloud.ProviderClient, cfg AuthOpts) {
+	provider.ReauthFunc = func() error {
+		resolved, err := cfg.resolveCredentials()
+		if err != nil {
+			return err
+		}
+
+		// Authenticate a throw-away client, as the provider is locked while
+		// renewing its token
+		tac, err := openstack.NewClient(resolved.AuthURL)
+		if err != nil {
+			return err
+		}
+		tac.HTTPClient = provider.HTTPClient
+		tac.UserAgent = provider.UserAgent
+		tac.SetThrowaway(true)
+		if err := authenticate(tac, &resolved, false); err != nil {
+			return err
+		}
+
+		provider.CopyTokenFrom(tac)
+		klog.V(4).Info("Renewed the token with the resolved credentials")
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialHelper(t *testing.T) {
+	creds := filepath.Join(t.TempDir(), "creds.json")
+	err := ioutil.WriteFile(creds, []byte(`{"username": "demo", "password": "secret-1"}`), 0600)
+	assert.NoError(t, err)
+
+	var passwords []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Auth struct {
+				Identity struct {
+					Password struct {
+						User struct {
+							Name     string `json:"name"`
+							Password string `json:"password"`
+						} `json:"user"`
+					} `json:"password"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		user := req.Auth.Identity.Password.User
+		assert.Equal(t, "demo", user.Name)
+		passwords = append(passwords, user.Password)
+
+		w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%d", len(passwords)))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"token": {"catalog": []}}`)
+	}))
+	defer server.Close()
+
+	cfg := &AuthOpts{
+		AuthURL:          server.URL + "/v3/",
+		Username:         "ignored",
+		Password:         "ignored",
+		UserDomainName:   "Default",
+		CredentialHelper: "cat " + creds,
+	}
+	provider, err := NewOpenStackClient(cfg, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "token-1", provider.Token())
+	// The options are left as they are
+	assert.Equal(t, "ignored", cfg.Password)
+
+	// The rotated credentials are used to renew the token
+	err = ioutil.WriteFile(creds, []byte(`{"username": "demo", "password": "secret-2"}`), 0600)
+	assert.NoError(t, err)
+	assert.NoError(t, provider.Reauthenticate(provider.Token()))
+	assert.Equal(t, "token-2", provider.Token())
+	assert.Equal(t, []string{"secret-1", "secret-2"}, passwords)
+
+	// A failing helper fails the authentication
+	cfg.CredentialHelper = "cat " + filepath.Join(t.TempDir(), "missing.json")
+	_, err = NewOpenStackClient(cfg, "test")
+	assert.Error(t, err)
+}
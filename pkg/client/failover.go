@@ -0,0 +1,282 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// endpointCheckTimeout is the timeout of the health check of an endpoint
+	endpointCheckTimeout = 5 * time.Second
+	// endpointRetryPeriod is how long an unreachable endpoint is avoided
+	endpointRetryPeriod = time.Minute
+)
+
+// endpointFailover fails over between the endpoints the Keystone catalog
+// lists for a service, instead of always using the first one.
+//
+// The endpoints are used by priority, in the order of the catalog: requests go
+// to the first endpoint which is not known to be down. The requests are not
+// spread over the endpoints by weight, as the catalog has no weights. An
+// endpoint is considered down when a request to it fails without response,
+// e.g. because the API node is unreachable, and the request is then retried on
+// the next endpoint if canFailOver allows it. A down endpoint is used again
+// after endpointRetryPeriod.
+type endpointFailover struct {
+	transport http.RoundTripper
+
+	mu sync.Mutex
+	// groups maps each endpoint to all the endpoints of the same service,
+	// interface and region, in catalog order.
+	groups map[string][]string
+	// down records when each unreachable endpoint was found down.
+	down map[string]time.Time
+	now  func() time.Time
+}
+
+func newEndpointFailover(transport http.RoundTripper) *endpointFailover {
+	return &endpointFailover{
+		transport: transport,
+		groups:    make(map[string][]string),
+		down:      make(map[string]time.Time),
+		now:       time.Now,
+	}
+}
+
+// setEndpointLocator replaces the endpoint locator of the provider, so that
+// the healthy endpoint with the highest priority is used for new service
+// clients. It must be called once the provider is authenticated.
+func (f *endpointFailover) setEndpointLocator(provider *gophercloud.ProviderClient) {
+	defaultLocator := provider.EndpointLocator
+	provider.EndpointLocator = func(eo gophercloud.EndpointOpts) (string, error) {
+		// Only the Keystone v3 catalog is supported. The catalog is read
+		// from the last token, so it follows the re-authentications.
+		result, ok := provider.GetAuthResult().(tokens.CreateResult)
+		if !ok {
+			return defaultLocator(eo)
+		}
+		catalog, err := result.ExtractServiceCatalog()
+		if err != nil {
+			return defaultLocator(eo)
+		}
+
+		endpoints := matchingEndpoints(catalog, eo)
+		if len(endpoints) < 2 {
+			return defaultLocator(eo)
+		}
+		f.register(endpoints)
+		return f.pick(endpoints), nil
+	}
+}
+
+// matchingEndpoints returns the normalized URLs of the catalog endpoints
+// matching eo, in catalog order.
+func matchingEndpoints(catalog *tokens.ServiceCatalog, eo gophercloud.EndpointOpts) []string {
+	var endpoints []string
+	for _, entry := range catalog.Entries {
+		if entry.Type != eo.Type || (eo.Name != "" && entry.Name != eo.Name) {
+			continue
+		}
+		for _, endpoint := range entry.Endpoints {
+			if gophercloud.Availability(endpoint.Interface) != eo.Availability {
+				continue
+			}
+			if eo.Region != "" && endpoint.Region != eo.Region && endpoint.RegionID != eo.Region {
+				continue
+			}
+			endpoints = append(endpoints, gophercloud.NormalizeURL(endpoint.URL))
+		}
+	}
+	return endpoints
+}
+
+func (f *endpointFailover) register(endpoints []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, endpoint := range endpoints {
+		f.groups[endpoint] = endpoints
+	}
+}
+
+// pick returns the first endpoint which responds, or the first one if none does.
+func (f *endpointFailover) pick(endpoints []string) string {
+	for _, endpoint := range endpoints {
+		if f.isDown(endpoint) {
+			continue
+		}
+		if f.check(endpoint) {
+			return endpoint
+		}
+		f.markDown(endpoint)
+	}
+	klog.Warningf("None of the endpoints %v responds, using %s", endpoints, endpoints[0])
+	return endpoints[0]
+}
+
+// check reports whether the endpoint responds. Any HTTP response, including
+// an error status, means the API node is up.
+func (f *endpointFailover) check(endpoint string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := f.transport.RoundTrip(req)
+	if err != nil {
+		klog.V(3).Infof("Endpoint %s does not respond: %v", endpoint, err)
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+func (f *endpointFailover) isDown(endpoint string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	since, ok := f.down[endpoint]
+	if !ok {
+		return false
+	}
+	if f.now().Sub(since) >= endpointRetryPeriod {
+		delete(f.down, endpoint)
+		return false
+	}
+	return true
+}
+
+func (f *endpointFailover) markDown(endpoint string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.down[endpoint]; !ok {
+		klog.Warningf("Endpoint %s is unreachable, failing over to the other endpoints for %v", endpoint, endpointRetryPeriod)
+	}
+	f.down[endpoint] = f.now()
+}
+
+// lookup returns the endpoint the URL belongs to and the endpoints of its group.
+func (f *endpointFailover) lookup(u string) (string, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var base string
+	for endpoint := range f.groups {
+		// The longest match wins when endpoints are nested.
+		if strings.HasPrefix(u, endpoint) && len(endpoint) > len(base) {
+			base = endpoint
+		}
+	}
+	if base == "" {
+		return "", nil
+	}
+	return base, f.groups[base]
+}
+
+// RoundTrip sends the request to the endpoint with the highest priority which
+// is not down, and fails over to the next ones if it gets no response and
+// canFailOver allows it.
+func (f *endpointFailover) RoundTrip(req *http.Request) (*http.Response, error) {
+	base, group := f.lookup(req.URL.String())
+	if base == "" {
+		return f.transport.RoundTrip(req)
+	}
+
+	var lastErr error
+	tried := 0
+	for _, endpoint := range group {
+		if f.isDown(endpoint) {
+			continue
+		}
+		// A request with a body can only be sent again if it can be rewound.
+		if tried > 0 && req.Body != nil && req.GetBody == nil {
+			break
+		}
+
+		r, err := rewriteRequest(req, base, endpoint, tried > 0)
+		if err != nil {
+			return nil, err
+		}
+		tried++
+		resp, err := f.transport.RoundTrip(r)
+		if err == nil {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+		f.markDown(endpoint)
+		if !canFailOver(req, err) {
+			return nil, err
+		}
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	// Every endpoint is down, try the original one anyway.
+	return f.transport.RoundTrip(req)
+}
+
+// canFailOver reports whether the request can be sent to another endpoint
+// after it failed with err. The requests which are not idempotent, e.g. the
+// creation of a load balancer, are only sent again if the connection to the
+// endpoint could not be established, as the endpoint may already have
+// processed them otherwise.
+func canFailOver(req *http.Request, err error) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// rewriteRequest returns a copy of req sent to the endpoint instead of base.
+func rewriteRequest(req *http.Request, base, endpoint string, rewind bool) (*http.Request, error) {
+	if endpoint == base && !rewind {
+		return req, nil
+	}
+
+	u, err := url.Parse(endpoint + strings.TrimPrefix(req.URL.String(), base))
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.URL = u
+	r.Host = u.Host
+	if rewind && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchingEndpoints(t *testing.T) {
+	catalog := &tokens.ServiceCatalog{
+		Entries: []tokens.CatalogEntry{
+			{
+				Type: "network",
+				Endpoints: []tokens.Endpoint{
+					{Interface: "public", Region: "RegionOne", URL: "https://net1.example.com"},
+					{Interface: "internal", Region: "RegionOne", URL: "https://net-internal.example.com"},
+					{Interface: "public", Region: "RegionTwo", URL: "https://net-two.example.com"},
+					{Interface: "public", Region: "RegionOne", URL: "https://net2.example.com/"},
+				},
+			},
+			{
+				Type:      "compute",
+				Endpoints: []tokens.Endpoint{{Interface: "public", Region: "RegionOne", URL: "https://compute.example.com"}},
+			},
+		},
+	}
+
+	endpoints := matchingEndpoints(catalog, gophercloud.EndpointOpts{Type: "network", Region: "RegionOne", Availability: gophercloud.AvailabilityPublic})
+	assert.Equal(t, []string{"https://net1.example.com/", "https://net2.example.com/"}, endpoints)
+}
+
+func TestEndpointFailover(t *testing.T) {
+	var bodies []string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	now := time.Now()
+	f := newEndpointFailover(http.DefaultTransport)
+	f.now = func() time.Time { return now }
+	downURL := down.URL + "/"
+	upURL := up.URL + "/"
+	f.register([]string{downURL, upURL})
+
+	// The endpoint which responds is picked
+	assert.Equal(t, upURL, f.pick([]string{downURL, upURL}))
+	assert.True(t, f.isDown(downURL))
+	bodies = nil
+
+	// Requests to a down endpoint are sent to the next one, with their body
+	client := &http.Client{Transport: f}
+	resp, err := client.Post(downURL+"v2.0/ports", "application/json", strings.NewReader("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"/v2.0/ports {}"}, bodies)
+
+	// The down endpoint is tried again after the retry period
+	now = now.Add(endpointRetryPeriod)
+	assert.False(t, f.isDown(downURL))
+	resp, err = client.Post(downURL+"v2.0/networks", "application/json", strings.NewReader("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"/v2.0/ports {}", "/v2.0/networks {}"}, bodies)
+	assert.True(t, f.isDown(downURL))
+}
+
+func TestEndpointFailoverNotIdempotent(t *testing.T) {
+	var requests []string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+	// The broken endpoint accepts the connections, but closes them without
+	// response, as an API node crashing while processing the request
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer broken.Close()
+
+	brokenURL := broken.URL + "/"
+	upURL := up.URL + "/"
+	f := newEndpointFailover(http.DefaultTransport)
+	f.register([]string{brokenURL, upURL})
+	client := &http.Client{Transport: f}
+
+	// The creation may have been processed, it is not sent again
+	_, err := client.Post(brokenURL+"v2/lbaas/loadbalancers", "application/json", strings.NewReader("{}"))
+	assert.Error(t, err)
+	assert.Empty(t, requests)
+
+	// Reads are sent to the next endpoint
+	f.down = make(map[string]time.Time)
+	resp, err := client.Get(brokenURL + "v2/lbaas/loadbalancers")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"GET /v2/lbaas/loadbalancers"}, requests)
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultRateLimitService is the service type of the rate limit applying
+	// to each service type without a rate limit of its own
+	defaultRateLimitService = "*"
+	// backoffInitialDelay is the delay before the first retry of a request
+	// rejected by an overloaded service, doubled on each retry
+	backoffInitialDelay = time.Second
+	// backoffMaxDelay caps the delay between the retries
+	backoffMaxDelay = 30 * time.Second
+)
+
+// rateLimit is the rate limit of the requests to a service type.
+type rateLimit struct {
+	qps   float64
+	burst int
+}
+
+// parseRateLimits parses the rate-limits option, a comma-separated list of
+// <service type>=<QPS>[:<burst>], e.g. "load-balancer=5:10,*=20". The burst
+// defaults to the QPS rounded up.
+func parseRateLimits(s string) (map[string]rateLimit, error) {
+	limits := make(map[string]rateLimit)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		service := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || service == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, it must be <service type>=<QPS>[:<burst>]", item)
+		}
+		values := strings.SplitN(kv[1], ":", 2)
+		qps, err := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+		if err != nil || qps <= 0 {
+			return nil, fmt.Errorf("invalid QPS of rate limit %q, it must be a positive number", item)
+		}
+		burst := int(math.Ceil(qps))
+		if len(values) == 2 {
+			burst, err = strconv.Atoi(strings.TrimSpace(values[1]))
+			if err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid burst of rate limit %q, it must be a positive integer", item)
+			}
+		}
+		limits[service] = rateLimit{qps: qps, burst: burst}
+	}
+	return limits, nil
+}
+
+// rateLimiter limits the rate of the requests to each OpenStack service type,
+// and retries with an exponential backoff the requests rejected by overloaded
+// services, so that mass reconciliations, e.g. when many nodes are added at
+// once, don't flood the APIs.
+//
+// The requests wait for the limiter of the service type of their endpoint.
+// The requests to endpoints not located through the provider, e.g. Keystone,
+// are not limited. A request answered with 429 Too Many Requests or 503
+// Service Unavailable is sent again after a delay doubling on each retry, or
+// after its Retry-After delay if longer, up to backoffMaxDelay.
+type rateLimiter struct {
+	transport http.RoundTripper
+	limits    map[string]rateLimit
+	retries   int
+
+	mu sync.Mutex
+	// services maps each endpoint to its service type.
+	services map[string]string
+	// limiters are the limiters of the service types, created on first use.
+	limiters map[string]*rate.Limiter
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimiter(transport http.RoundTripper, limits map[string]rateLimit, retries int) *rateLimiter {
+	return &rateLimiter{
+		transport: transport,
+		limits:    limits,
+		retries:   retries,
+		services:  make(map[string]string),
+		limiters:  make(map[string]*rate.Limiter),
+		sleep:     sleepContext,
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// setEndpointLocator wraps the endpoint locator of the provider, so that the
+// service type of the endpoints of the service clients is known. It must be
+// called after the endpoint locator is set up.
+func (l *rateLimiter) setEndpointLocator(provider *gophercloud.ProviderClient) {
+	locator := provider.EndpointLocator
+	provider.EndpointLocator = func(eo gophercloud.EndpointOpts) (string, error) {
+		endpoint, err := locator(eo)
+		if err == nil {
+			l.register(gophercloud.NormalizeURL(endpoint), eo.Type)
+		}
+		return endpoint, err
+	}
+}
+
+func (l *rateLimiter) register(endpoint, service string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.services[endpoint] = service
+}
+
+// limiter returns the limiter of the service type the URL belongs to, nil if
+// the requests to it are not limited.
+func (l *rateLimiter) limiter(u string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var base string
+	for endpoint := range l.services {
+		// The longest match wins when endpoints are nested.
+		if strings.HasPrefix(u, endpoint) && len(endpoint) > len(base) {
+			base = endpoint
+		}
+	}
+	if base == "" {
+		return nil
+	}
+
+	service := l.services[base]
+	if limiter, ok := l.limiters[service]; ok {
+		return limiter
+	}
+	limit, ok := l.limits[service]
+	if !ok {
+		limit, ok = l.limits[defaultRateLimitService]
+	}
+	var limiter *rate.Limiter
+	if ok {
+		limiter = rate.NewLimiter(rate.Limit(limit.qps), limit.burst)
+	}
+	l.limiters[service] = limiter
+	return limiter
+}
+
+// RoundTrip sends the request once the rate limit of its service allows it,
+// and retries it while the service is overloaded.
+func (l *rateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := l.limiter(req.URL.String())
+	// A request with a body can only be sent again if it can be rewound.
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := l.transport.RoundTrip(r)
+		if err != nil || !isOverloaded(resp) || attempt >= l.retries || !canRetry {
+			return resp, err
+		}
+
+		delay := backoffDelay(attempt, resp.Header.Get("Retry-After"))
+		klog.V(3).Infof("%s %s: %s, retrying in %v", req.Method, req.URL, resp.Status, delay)
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err := l.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isOverloaded reports whether the response rejects the request because the
+// service is overloaded.
+func isOverloaded(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// backoffDelay returns the delay before the retry following the attempt, the
+// Retry-After delay in seconds of the response if longer.
+func backoffDelay(attempt int, retryAfter string) time.Duration {
+	delay := backoffMaxDelay
+	if attempt < 5 {
+		delay = backoffInitialDelay << uint(attempt)
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if after := time.Duration(seconds) * time.Second; after > delay {
+			delay = after
+		}
+	}
+	if delay > backoffMaxDelay {
+		delay = backoffMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expected  map[string]rateLimit
+		expectErr bool
+	}{
+		{name: "empty", input: "", expected: map[string]rateLimit{}},
+		{
+			name:     "limits",
+			input:    "load-balancer=5:10, *=2.5",
+			expected: map[string]rateLimit{"load-balancer": {qps: 5, burst: 10}, "*": {qps: 2.5, burst: 3}},
+		},
+		{name: "no QPS", input: "network", expectErr: true},
+		{name: "invalid QPS", input: "network=fast", expectErr: true},
+		{name: "zero QPS", input: "network=0", expectErr: true},
+		{name: "invalid burst", input: "network=5:0", expectErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits, err := parseRateLimits(test.input)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, limits)
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	assert.Equal(t, time.Second, backoffDelay(0, ""))
+	assert.Equal(t, 4*time.Second, backoffDelay(2, "invalid"))
+	assert.Equal(t, 10*time.Second, backoffDelay(1, "10"))
+	assert.Equal(t, backoffMaxDelay, backoffDelay(10, ""))
+	assert.Equal(t, backoffMaxDelay, backoffDelay(0, "3600"))
+}
+
+func TestRateLimiterLimiter(t *testing.T) {
+	l := newRateLimiter(http.DefaultTransport, map[string]rateLimit{"load-balancer": {qps: 5, burst: 10}, "*": {qps: 1, burst: 1}}, 0)
+	l.register("https://octavia.example.com/", "load-balancer")
+	l.register("https://neutron.example.com/", "network")
+	l.register("https://neutron.example.com/lb/", "load-balancer")
+
+	lb := l.limiter("https://octavia.example.com/v2/lbaas/loadbalancers")
+	if assert.NotNil(t, lb) {
+		assert.Equal(t, 10, lb.Burst())
+	}
+	// The endpoints of a service type share its limiter
+	assert.Same(t, lb, l.limiter("https://neutron.example.com/lb/v2/lbaas/pools"))
+	network := l.limiter("https://neutron.example.com/v2.0/ports")
+	if assert.NotNil(t, network) {
+		assert.Equal(t, 1, network.Burst())
+	}
+	assert.Nil(t, l.limiter("https://keystone.example.com/v3/auth/tokens"))
+
+	// Without default limit, the other service types are not limited
+	l = newRateLimiter(http.DefaultTransport, map[string]rateLimit{"load-balancer": {qps: 5, burst: 10}}, 0)
+	l.register("https://neutron.example.com/", "network")
+	assert.Nil(t, l.limiter("https://neutron.example.com/v2.0/ports"))
+}
+
+func TestRateLimiterBackoff(t *testing.T) {
+	var bodies []string
+	rejected := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if rejected < 2 {
+			rejected++
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var delays []time.Duration
+	l := newRateLimiter(http.DefaultTransport, nil, 3)
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	client := &http.Client{Transport: l}
+
+	// The request is retried with its body until the service accepts it
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, []string{"{}", "{}", "{}"}, bodies)
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second}, delays)
+
+	// The last response is returned once the retries are exhausted
+	rejected, bodies, delays = -10, nil, nil
+	resp, err = client.Get(srv.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+	assert.Len(t, bodies, 4)
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second, 4 * time.Second}, delays)
+}
@@ -65,6 +65,15 @@ func NewLoadBalancerV2(provider *gophercloud.ProviderClient, eo *gophercloud.End
 	return lb, nil
 }
 
+// NewDNSV2 creates a ServiceClient that may be used with the Designate v2 API
+func NewDNSV2(provider *gophercloud.ProviderClient, eo *gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error) {
+	dns, err := openstack.NewDNSV2(provider, *eo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find dns v2 %s endpoint for region %s: %v", eo.Availability, eo.Region, err)
+	}
+	return dns, nil
+}
+
 // NewKeyManagerV1 creates a ServiceClient that can be used with KeyManager v1 API
 func NewKeyManagerV1(provider *gophercloud.ProviderClient, eo *gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error) {
 	secret, err := openstack.NewKeyManagerV1(provider, *eo)
@@ -0,0 +1,160 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// AttachEventRecorder records an event on the Node a volume is attached to
+// or detached from, with the Cinder volume ID and the device path, so the
+// attach history of a node can be followed without querying the cloud.
+type AttachEventRecorder struct {
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+
+	mu sync.Mutex
+	// Nodes of the instances, found when a volume is first attached to or
+	// detached from them
+	nodes map[string]*corev1.ObjectReference
+}
+
+// NewAttachEventRecorder creates an AttachEventRecorder using the given
+// kubeconfig, or the in-cluster config if kubeconfig is empty.
+func NewAttachEventRecorder(kubeconfig string) (*AttachEventRecorder, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes client config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{
+		Interface: kubeClient.CoreV1().Events(""),
+	})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: driverName + "-attacher"})
+
+	return newAttachEventRecorder(kubeClient, recorder), nil
+}
+
+func newAttachEventRecorder(kubeClient kubernetes.Interface, recorder record.EventRecorder) *AttachEventRecorder {
+	return &AttachEventRecorder{
+		kubeClient: kubeClient,
+		recorder:   recorder,
+		nodes:      make(map[string]*corev1.ObjectReference),
+	}
+}
+
+// attached records that the volume was attached to the instance at the
+// device path.
+func (r *AttachEventRecorder) attached(instance *servers.Server, volumeID, devicePath string) {
+	if r == nil {
+		return
+	}
+
+	node, err := r.getNode(instance)
+	if err != nil {
+		klog.Warningf("Failed to find the node of instance %s, the attachment of volume %s is not recorded: %v", instance.ID, volumeID, err)
+		return
+	}
+	if node == nil {
+		klog.V(4).Infof("Instance %s has no node, the attachment of volume %s is not recorded", instance.ID, volumeID)
+		return
+	}
+	r.recorder.Eventf(node, corev1.EventTypeNormal, "VolumeAttached", "Cinder volume %s attached to instance %s at %s", volumeID, instance.ID, devicePath)
+}
+
+// detached records that the volume was detached from the instance.
+func (r *AttachEventRecorder) detached(instance *servers.Server, volumeID string) {
+	if r == nil {
+		return
+	}
+
+	node, err := r.getNode(instance)
+	if err != nil {
+		klog.Warningf("Failed to find the node of instance %s, the detachment of volume %s is not recorded: %v", instance.ID, volumeID, err)
+		return
+	}
+	if node == nil {
+		klog.V(4).Infof("Instance %s has no node, the detachment of volume %s is not recorded", instance.ID, volumeID)
+		return
+	}
+	r.recorder.Eventf(node, corev1.EventTypeNormal, "VolumeDetached", "Cinder volume %s detached from instance %s", volumeID, instance.ID)
+}
+
+// getNode returns the Node of the instance, the one whose provider ID ends
+// with the instance ID, or else the one named after the instance. It returns
+// nil if there is none.
+func (r *AttachEventRecorder) getNode(instance *servers.Server) (*corev1.ObjectReference, error) {
+	r.mu.Lock()
+	node, ok := r.nodes[instance.ID]
+	r.mu.Unlock()
+	if ok {
+		return node, nil
+	}
+
+	nodes, err := r.kubeClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var found *corev1.Node
+	for i := range nodes.Items {
+		if strings.HasSuffix(nodes.Items[i].Spec.ProviderID, "/"+instance.ID) {
+			found = &nodes.Items[i]
+			break
+		}
+	}
+	if found == nil {
+		n, err := r.kubeClient.CoreV1().Nodes().Get(context.TODO(), instance.Name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			found = n
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+
+	// The events of a node have its name as UID, as recorded by the kubelet
+	// and shown by kubectl describe
+	node = &corev1.ObjectReference{Kind: "Node", Name: found.Name, UID: types.UID(found.Name)}
+	r.mu.Lock()
+	r.nodes[instance.ID] = node
+	r.mu.Unlock()
+	return node, nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestAttachEventRecorder(t *testing.T) {
+	// The node names differ from the instance names
+	byProviderID := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Spec:       corev1.NodeSpec{ProviderID: "openstack:///" + FakeNodeID},
+	}
+	byName := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "instance-b"}}
+	recorder := record.NewFakeRecorder(10)
+	r := newAttachEventRecorder(fake.NewSimpleClientset(byProviderID, byName), recorder)
+
+	r.attached(&servers.Server{ID: FakeNodeID, Name: "instance-a"}, FakeVolID, "/dev/vdb")
+	assert.Equal(t, "Normal VolumeAttached Cinder volume "+FakeVolID+" attached to instance "+FakeNodeID+" at /dev/vdb", <-recorder.Events)
+	assert.Equal(t, "node-a", r.nodes[FakeNodeID].Name)
+
+	r.detached(&servers.Server{ID: "instance-b-id", Name: "instance-b"}, FakeVolID)
+	assert.Equal(t, "Normal VolumeDetached Cinder volume "+FakeVolID+" detached from instance instance-b-id", <-recorder.Events)
+
+	// The attachments of instances without node are not recorded
+	r.attached(&servers.Server{ID: "other", Name: "other"}, FakeVolID, "/dev/vdb")
+	assert.Empty(t, recorder.Events)
+
+	// A nil recorder is disabled
+	var disabled *AttachEventRecorder
+	disabled.attached(&servers.Server{ID: FakeNodeID}, FakeVolID, "/dev/vdb")
+	disabled.detached(&servers.Server{ID: FakeNodeID}, FakeVolID)
+}
@@ -19,8 +19,10 @@ package cinder
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	ossnapshots "github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
@@ -39,10 +41,26 @@ import (
 type controllerServer struct {
 	Driver *Driver
 	Cloud  openstack.IOpenStack
+	// Throttles the volume deletions, volumes are deleted right away if nil
+	deletions *deletionQueue
+	// Volumes cloned instead of creating volumes from snapshots, not cached if nil
+	volumeCache *volumeCache
+	// Records the attachments on the nodes, may be nil
+	attachEvents *AttachEventRecorder
 }
 
 const (
 	cinderCSIClusterIDKey = "cinder.csi.openstack.org/cluster"
+
+	// schedulerHintPrefix is the prefix of the StorageClass parameters
+	// passed as scheduler hints to Cinder: scheduler-hint-same-host and
+	// scheduler-hint-different-host are comma-separated lists of volume IDs,
+	// scheduler-hint-local-to-instance is an instance ID, and any other
+	// parameter with the prefix is passed as the custom hint named after it.
+	schedulerHintPrefix          = "scheduler-hint-"
+	schedulerHintSameHost        = schedulerHintPrefix + "same-host"
+	schedulerHintDifferentHost   = schedulerHintPrefix + "different-host"
+	schedulerHintLocalToInstance = schedulerHintPrefix + "local-to-instance"
 )
 
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -83,9 +101,28 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 	}
 
-	cloud := cs.Cloud
+	schedulerHints, err := getSchedulerHints(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "[CreateVolume] invalid scheduler hints: %v", err)
+	}
+
+	// Use the credentials of the provisioner secret of the StorageClass, if any
+	cloud, err := cs.getCloud(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
 	ignoreVolumeAZ := cloud.GetBlockStorageOpts().IgnoreVolumeAZ
 
+	// Create the volume in another project, attachments are still done
+	// with the credentials of the plugin.
+	if projectID := req.GetParameters()["project-id"]; projectID != "" {
+		cloud, err = cloud.ForProject(projectID)
+		if err != nil {
+			klog.Errorf("Failed to get the clients of project %s: %v", projectID, err)
+			return nil, status.Errorf(codes.Internal, "CreateVolume failed to use project %s: %v", projectID, err)
+		}
+	}
+
 	// Verify a volume with the provided name doesn't already exist for this tenant
 	volumes, err := cloud.GetVolumesByName(volName)
 	if err != nil {
@@ -98,7 +135,7 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 			return nil, status.Error(codes.AlreadyExists, "Volume Already exists with same name and different capacity")
 		}
 		klog.V(4).Infof("Volume %s already exists in Availability Zone: %s of size %d GiB", volumes[0].ID, volumes[0].AvailabilityZone, volumes[0].Size)
-		return getCreateVolumeResponse(&volumes[0], ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
+		return getCreateVolumeResponse(&volumes[0], req.GetVolumeContentSource(), ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
 	} else if len(volumes) > 1 {
 		klog.V(3).Infof("found multiple existing volumes with selected name (%s) during create", volName)
 		return nil, status.Error(codes.Internal, "Multiple volumes reported by Cinder with same name")
@@ -117,29 +154,54 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	var snapshotID string
 	var sourcevolID string
 
+	var cachedvolID string
+
 	if content != nil && content.GetSnapshot() != nil {
 		snapshotID = content.GetSnapshot().GetSnapshotId()
-		_, err := cloud.GetSnapshotByID(snapshotID)
+		snap, err := cloud.GetSnapshotByID(snapshotID)
 		if err != nil {
 			if cpoerrors.IsNotFound(err) {
 				return nil, status.Errorf(codes.NotFound, "VolumeContentSource Snapshot %s not found", snapshotID)
 			}
 			return nil, status.Errorf(codes.Internal, "Failed to retrieve the snapshot %s: %v", snapshotID, err)
 		}
+		// Cinder volumes cannot be shrunk, the snapshot is restored into a
+		// volume of at least its size whatever the used size of its filesystem.
+		if volSizeGB < snap.Size {
+			return nil, status.Errorf(codes.OutOfRange, "CreateVolume requested size %d GiB is smaller than the size %d GiB of snapshot %s", volSizeGB, snap.Size, snapshotID)
+		}
+
+		// The cached volumes are in the project of the plugin, and placed
+		// without scheduler hints
+		if cs.volumeCache != nil && cloud == cs.Cloud && schedulerHints == nil {
+			cachedvolID = cs.volumeCache.lookup(snapshotID, volType, volAvailability, snap.Size)
+		}
 	}
 
 	if content != nil && content.GetVolume() != nil {
 		sourcevolID = content.GetVolume().GetVolumeId()
-		_, err := cloud.GetVolume(sourcevolID)
+		sourceVol, err := cloud.GetVolume(sourcevolID)
 		if err != nil {
 			if cpoerrors.IsNotFound(err) {
 				return nil, status.Errorf(codes.NotFound, "Source Volume %s not found", sourcevolID)
 			}
 			return nil, status.Errorf(codes.Internal, "Failed to retrieve the source volume %s: %v", sourcevolID, err)
 		}
+		if volSizeGB < sourceVol.Size {
+			return nil, status.Errorf(codes.OutOfRange, "CreateVolume requested size %d GiB is smaller than the size %d GiB of source volume %s", volSizeGB, sourceVol.Size, sourcevolID)
+		}
 	}
 
-	vol, err := cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, snapshotID, sourcevolID, &properties)
+	createSnapshotID, createSourcevolID := snapshotID, sourcevolID
+	if cachedvolID != "" {
+		createSnapshotID, createSourcevolID = "", cachedvolID
+	}
+	vol, err := cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, createSnapshotID, createSourcevolID, &properties, schedulerHints)
+	if err != nil && cachedvolID != "" {
+		klog.Warningf("Failed to clone the cached volume %s of snapshot %s, creating the volume from the snapshot: %v", cachedvolID, snapshotID, err)
+		cs.volumeCache.forget(cachedvolID)
+		vol, err = cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, snapshotID, sourcevolID, &properties, schedulerHints)
+	}
 
 	if err != nil {
 		klog.Errorf("Failed to CreateVolume: %v", err)
@@ -149,7 +211,7 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	klog.V(4).Infof("CreateVolume: Successfully created volume %s in Availability Zone: %s of size %d GiB", vol.ID, vol.AvailabilityZone, vol.Size)
 
-	return getCreateVolumeResponse(vol, ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
+	return getCreateVolumeResponse(vol, content, ignoreVolumeAZ, req.GetAccessibilityRequirements()), nil
 }
 
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -160,7 +222,26 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	if len(volID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "DeleteVolume Volume ID must be provided")
 	}
-	err := cs.Cloud.DeleteVolume(volID)
+	cloud, err := cs.getCloud(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	// The deletions of the volumes of the plugin credentials are throttled
+	if cs.deletions != nil && cloud == cs.Cloud {
+		err := cs.deletions.delete(ctx, volID)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The deletion goes on, the retried call waits for it
+				return nil, status.Errorf(codes.Aborted, "DeleteVolume of volume %s is still pending", volID)
+			}
+			klog.Errorf("Failed to DeleteVolume: %v", err)
+			return nil, status.Error(codes.Internal, fmt.Sprintf("DeleteVolume failed with error %v", err))
+		}
+		klog.V(4).Infof("DeleteVolume: Successfully deleted volume %s", volID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	err = cloud.DeleteVolume(volID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			klog.V(3).Infof("Volume %s is already deleted.", volID)
@@ -201,7 +282,7 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 		return nil, status.Error(codes.Internal, fmt.Sprintf("[ControllerPublishVolume] get volume failed with error %v", err))
 	}
 
-	_, err = cs.Cloud.GetInstanceByID(instanceID)
+	instance, err := cs.Cloud.GetInstanceByID(instanceID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "[ControllerPublishVolume] Instance %s not found", instanceID)
@@ -229,6 +310,7 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 	}
 
 	klog.V(4).Infof("ControllerPublishVolume %s on %s is successful", volumeID, instanceID)
+	go cs.attachEvents.attached(instance, volumeID, devicePath)
 
 	// Publish Volume Info
 	pvInfo := map[string]string{}
@@ -249,7 +331,7 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "[ControllerUnpublishVolume] Volume ID must be provided")
 	}
-	_, err := cs.Cloud.GetInstanceByID(instanceID)
+	instance, err := cs.Cloud.GetInstanceByID(instanceID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			klog.V(3).Infof("ControllerUnpublishVolume assuming volume %s is detached, because node %s does not exist", volumeID, instanceID)
@@ -279,6 +361,7 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	}
 
 	klog.V(4).Infof("ControllerUnpublishVolume %s on %s", volumeID, instanceID)
+	go cs.attachEvents.detached(instance, volumeID)
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
@@ -337,10 +420,15 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		return nil, status.Error(codes.InvalidArgument, "VolumeID must be provided in CreateSnapshot request")
 	}
 
+	cloud, err := cs.getCloud(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify a snapshot with the provided name doesn't already exist for this tenant
 	filters := map[string]string{}
 	filters["Name"] = name
-	snapshots, _, err := cs.Cloud.ListSnapshots(filters)
+	snapshots, _, err := cloud.ListSnapshots(filters)
 	if err != nil {
 		klog.Errorf("Failed to query for existing Snapshot during CreateSnapshot: %v", err)
 		return nil, status.Error(codes.Internal, "Failed to get snapshots")
@@ -375,7 +463,7 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		}
 
 		// TODO: Delegate the check to openstack itself and ignore the conflict
-		snap, err = cs.Cloud.CreateSnapshot(name, volumeID, &properties)
+		snap, err = cloud.CreateSnapshot(name, volumeID, &properties)
 		if err != nil {
 			klog.Errorf("Failed to Create snapshot: %v", err)
 			return nil, status.Error(codes.Internal, fmt.Sprintf("CreateSnapshot failed with error %v", err))
@@ -389,7 +477,7 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 		klog.Errorf("Error to convert time to timestamp: %v", err)
 	}
 
-	err = cs.Cloud.WaitSnapshotReady(snap.ID)
+	err = cloud.WaitSnapshotReady(snap.ID)
 	if err != nil {
 		klog.Errorf("Failed to WaitSnapshotReady: %v", err)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("CreateSnapshot failed with error %v", err))
@@ -415,8 +503,21 @@ func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteS
 		return nil, status.Error(codes.InvalidArgument, "Snapshot ID must be provided in DeleteSnapshot request")
 	}
 
+	cloud, err := cs.getCloud(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	// The cached volumes are in the project of the plugin
+	if cs.volumeCache != nil && cloud == cs.Cloud {
+		if err := cs.volumeCache.evictSnapshot(id); err != nil {
+			klog.Errorf("Failed to delete the cached volumes of snapshot %s: %v", id, err)
+			return nil, status.Error(codes.Internal, fmt.Sprintf("DeleteSnapshot failed to delete the cached volumes with error %v", err))
+		}
+	}
+
 	// Delegate the check to openstack itself
-	err := cs.Cloud.DeleteSnapshot(id)
+	err = cloud.DeleteSnapshot(id)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			klog.V(3).Infof("Snapshot %s is already deleted.", id)
@@ -614,7 +715,12 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 		return nil, status.Error(codes.OutOfRange, "After round-up, volume size exceeds the limit specified")
 	}
 
-	volume, err := cs.Cloud.GetVolume(volumeID)
+	cloud, err := cs.getCloud(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+
+	volume, err := cloud.GetVolume(volumeID)
 	if err != nil {
 		if cpoerrors.IsNotFound(err) {
 			return nil, status.Error(codes.NotFound, "Volume not found")
@@ -631,14 +737,14 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 		}, nil
 	}
 
-	err = cs.Cloud.ExpandVolume(volumeID, volume.Status, volSizeGB)
+	err = cloud.ExpandVolume(volumeID, volume.Status, volSizeGB)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, fmt.Sprintf("Could not resize volume %q to size %v: %v", volumeID, volSizeGB, err))
 	}
 
 	// we need wait for the volume to be available or InUse, it might be error_extending in some scenario
 	targetStatus := []string{openstack.VolumeAvailableStatus, openstack.VolumeInUseStatus}
-	err = cs.Cloud.WaitVolumeTargetStatus(volumeID, targetStatus)
+	err = cloud.WaitVolumeTargetStatus(volumeID, targetStatus)
 	if err != nil {
 		klog.Errorf("Failed to WaitVolumeTargetStatus of volume %s: %v", volumeID, err)
 		return nil, status.Error(codes.Internal, fmt.Sprintf("[ControllerExpandVolume] Volume %s not in target state after resize operation : %v", volumeID, err))
@@ -652,6 +758,69 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	}, nil
 }
 
+// getCloud returns the clients authenticated with the OpenStack credentials
+// of the secrets of the request, if any, or the clients of the plugin.
+func (cs *controllerServer) getCloud(secrets map[string]string) (openstack.IOpenStack, error) {
+	if len(secrets) == 0 {
+		return cs.Cloud, nil
+	}
+	cloud, err := cs.Cloud.ForSecrets(secrets)
+	if err != nil {
+		klog.Errorf("Failed to get the clients of the credentials in secrets: %v", err)
+		return nil, status.Errorf(codes.InvalidArgument, "failed to use the OpenStack credentials in secrets: %v", err)
+	}
+	return cloud, nil
+}
+
+// getSchedulerHints returns the Cinder scheduler hints of the StorageClass
+// parameters, nil if there is none.
+func getSchedulerHints(parameters map[string]string) (*schedulerhints.SchedulerHints, error) {
+	var hints schedulerhints.SchedulerHints
+	found := false
+	for key, value := range parameters {
+		if !strings.HasPrefix(key, schedulerHintPrefix) {
+			continue
+		}
+		found = true
+		switch key {
+		case schedulerHintSameHost:
+			hints.SameHost = splitSchedulerHintIDs(value)
+		case schedulerHintDifferentHost:
+			hints.DifferentHost = splitSchedulerHintIDs(value)
+		case schedulerHintLocalToInstance:
+			hints.LocalToInstance = strings.TrimSpace(value)
+		default:
+			name := strings.TrimPrefix(key, schedulerHintPrefix)
+			if name == "" {
+				return nil, fmt.Errorf("parameter %s has no hint name", key)
+			}
+			if hints.AdditionalProperties == nil {
+				hints.AdditionalProperties = make(map[string]interface{})
+			}
+			hints.AdditionalProperties[name] = value
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	// The IDs are validated when the hints are built
+	if _, err := hints.ToVolumeSchedulerHintsCreateMap(); err != nil {
+		return nil, err
+	}
+	return &hints, nil
+}
+
+func splitSchedulerHintIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 func getAZFromTopology(requirement *csi.TopologyRequirement) string {
 	for _, topology := range requirement.GetPreferred() {
 		zone, exists := topology.GetSegments()[topologyKey]
@@ -669,11 +838,14 @@ func getAZFromTopology(requirement *csi.TopologyRequirement) string {
 	return ""
 }
 
-func getCreateVolumeResponse(vol *volumes.Volume, ignoreVolumeAZ bool, accessibleTopologyReq *csi.TopologyRequirement) *csi.CreateVolumeResponse {
+// getCreateVolumeResponse returns the response of the creation of the volume.
+// The content source is the one of the request, if any, as a volume created
+// from a snapshot may be cloned from a cached volume.
+func getCreateVolumeResponse(vol *volumes.Volume, content *csi.VolumeContentSource, ignoreVolumeAZ bool, accessibleTopologyReq *csi.TopologyRequirement) *csi.CreateVolumeResponse {
 
-	var volsrc *csi.VolumeContentSource
+	volsrc := content
 
-	if vol.SnapshotID != "" {
+	if volsrc == nil && vol.SnapshotID != "" {
 		volsrc = &csi.VolumeContentSource{
 			Type: &csi.VolumeContentSource_Snapshot{
 				Snapshot: &csi.VolumeContentSource_SnapshotSource{
@@ -683,7 +855,7 @@ func getCreateVolumeResponse(vol *volumes.Volume, ignoreVolumeAZ bool, accessibl
 		}
 	}
 
-	if vol.SourceVolID != "" {
+	if volsrc == nil && vol.SourceVolID != "" {
 		volsrc = &csi.VolumeContentSource{
 			Type: &csi.VolumeContentSource_Volume{
 				Volume: &csi.VolumeContentSource_VolumeSource{
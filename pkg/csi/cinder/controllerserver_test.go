@@ -20,8 +20,13 @@ import (
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
 )
 
@@ -46,7 +51,7 @@ func TestCreateVolume(t *testing.T) {
 	// mock OpenStack
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties).Return(&FakeVol, nil)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 	// Init assert
@@ -94,7 +99,7 @@ func TestCreateVolumeWithParam(t *testing.T) {
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
 	// Vol type and availability comes from CreateVolumeRequest.Parameters
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), "dummyVolType", "cinder", "", "", &properties).Return(&FakeVol, nil)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), "dummyVolType", "cinder", "", "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 	// Init assert
@@ -140,6 +145,54 @@ func TestCreateVolumeWithParam(t *testing.T) {
 
 }
 
+func TestCreateVolumeInProject(t *testing.T) {
+
+	// The volume is created with the clients of the project
+	projectMock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
+	projectMock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+	projectMock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
+	osmock.On("ForProject", "tenant-project").Return(projectMock, nil)
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: FakeVolName,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+
+		Parameters: map[string]string{
+			"type":       FakeVolType,
+			"project-id": "tenant-project",
+		},
+
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{
+				{
+					Segments: map[string]string{"topology.cinder.csi.openstack.org/zone": FakeAvailability},
+				},
+			},
+		},
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(FakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to CreateVolume: %v", err)
+	}
+
+	// Assert
+	assert.Equal(FakeVolID, actualRes.Volume.VolumeId)
+	projectMock.AssertExpectations(t)
+}
+
 func TestCreateVolumeWithExtraMetadata(t *testing.T) {
 
 	// mock OpenStack
@@ -150,7 +203,7 @@ func TestCreateVolumeWithExtraMetadata(t *testing.T) {
 		"csi.storage.k8s.io/pvc/namespace": FakePVCNamespace,
 	}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties).Return(&FakeVol, nil)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
 
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 
@@ -191,7 +244,7 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", FakeSnapshotID, "", &properties).Return(&FakeVolFromSnapshot, nil)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", FakeSnapshotID, "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVolFromSnapshot, nil)
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 
 	// Init assert
@@ -235,11 +288,60 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 
 }
 
+func TestCreateVolumeFromCachedVolume(t *testing.T) {
+	cloud := new(openstack.OpenStackMock)
+	cs := NewControllerServer(NewDriver(FakeEndpoint, FakeCluster), cloud)
+	cs.volumeCache = newVolumeCache(cloud, FakeCluster, 2, 0, 1)
+
+	cached := volumes.Volume{
+		ID:       "cached-a",
+		Size:     1,
+		Status:   "available",
+		Metadata: map[string]string{cinderCSIClusterIDKey: FakeCluster, volumeCacheSnapshotKey: FakeSnapshotID, volumeCacheTypeKey: FakeVolType},
+	}
+	cloud.On("ListVolumes", volumeCacheListLimit, "").Return([]volumes.Volume{cached}, "", nil)
+	assert.NoError(t, cs.volumeCache.load())
+
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
+	cloned := FakeVol
+	cloned.SourceVolID = "cached-a"
+	cloud.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", "cached-a", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&cloned, nil)
+	cloud.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: FakeVolName,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: FakeSnapshotID,
+				},
+			},
+		},
+	}
+
+	actualRes, err := cs.CreateVolume(FakeCtx, fakeReq)
+	if !assert.NoError(t, err) {
+		return
+	}
+	cloud.AssertCalled(t, "CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", "cached-a", &properties, (*schedulerhints.SchedulerHints)(nil))
+
+	// The content source is the requested snapshot, not the cached volume
+	assert.Equal(t, FakeSnapshotID, actualRes.Volume.ContentSource.GetSnapshot().GetSnapshotId())
+	assert.Nil(t, actualRes.Volume.ContentSource.GetVolume())
+}
+
 func TestCreateVolumeFromSourceVolume(t *testing.T) {
 
 	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
 	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, int, error)
-	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", FakeVolID, &properties).Return(&FakeVolFromSourceVolume, nil)
+	osmock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", FakeVolID, &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVolFromSourceVolume, nil)
 	osmock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
 
 	// Init assert
@@ -284,6 +386,58 @@ func TestCreateVolumeFromSourceVolume(t *testing.T) {
 }
 
 // Test CreateVolumeDuplicate
+// largeSourcesMock returns snapshots and volumes of 10 GiB.
+type largeSourcesMock struct {
+	*openstack.OpenStackMock
+}
+
+func (m largeSourcesMock) GetSnapshotByID(snapshotID string) (*snapshots.Snapshot, error) {
+	return &snapshots.Snapshot{ID: snapshotID, Status: "available", Size: 10}, nil
+}
+
+func (m largeSourcesMock) GetVolume(volumeID string) (*volumes.Volume, error) {
+	return &volumes.Volume{ID: volumeID, Status: "available", Size: 10}, nil
+}
+
+func TestCreateVolumeSmallerThanSource(t *testing.T) {
+	cloud := largeSourcesMock{new(openstack.OpenStackMock)}
+	cloud.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+	cs := NewControllerServer(NewDriver(FakeEndpoint, FakeCluster), cloud)
+
+	sources := map[string]*csi.VolumeContentSource{
+		"snapshot": {
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: FakeSnapshotID},
+			},
+		},
+		"volume": {
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: FakeVolID},
+			},
+		},
+	}
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			fakeReq := &csi.CreateVolumeRequest{
+				Name:          FakeVolName,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 5 * 1024 * 1024 * 1024},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				VolumeContentSource: src,
+			}
+
+			_, err := cs.CreateVolume(FakeCtx, fakeReq)
+			assert.Equal(t, codes.OutOfRange, status.Code(err))
+		})
+	}
+	cloud.AssertNotCalled(t, "CreateVolume", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestCreateVolumeDuplicate(t *testing.T) {
 
 	// Init assert
@@ -343,6 +497,58 @@ func TestDeleteVolume(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+func TestVolumeWithSecrets(t *testing.T) {
+
+	// The volume is created and deleted with the clients of the secrets
+	secrets := map[string]string{
+		"os-authURL":    "https://keystone.example.com/v3",
+		"os-userName":   "tenant-user",
+		"os-password":   "secret",
+		"os-projectID":  "tenant-project",
+		"os-domainName": "Default",
+		"os-region":     "RegionOne",
+	}
+	secretsMock := new(openstack.OpenStackMock)
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
+	secretsMock.On("GetVolumesByName", FakeVolName).Return(FakeVolListEmpty, nil)
+	secretsMock.On("CreateVolume", FakeVolName, mock.AnythingOfType("int"), FakeVolType, "", "", "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&FakeVol, nil)
+	secretsMock.On("DeleteVolume", FakeVolID).Return(nil)
+	osmock.On("ForSecrets", secrets).Return(secretsMock, nil)
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	createReq := &csi.CreateVolumeRequest{
+		Name: FakeVolName,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{"type": FakeVolType},
+		Secrets:    secrets,
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(FakeCtx, createReq)
+	if err != nil {
+		t.Errorf("failed to CreateVolume: %v", err)
+	}
+	assert.Equal(FakeVolID, actualRes.Volume.VolumeId)
+
+	// Invoke DeleteVolume
+	_, err = fakeCs.DeleteVolume(FakeCtx, &csi.DeleteVolumeRequest{VolumeId: FakeVolID, Secrets: secrets})
+	if err != nil {
+		t.Errorf("failed to DeleteVolume: %v", err)
+	}
+
+	// Assert
+	secretsMock.AssertExpectations(t)
+}
+
 // Test ControllerPublishVolume
 func TestControllerPublishVolume(t *testing.T) {
 
@@ -672,3 +878,84 @@ func TestValidateVolumeCapabilities(t *testing.T) {
 	assert.Equal(expectedRes2, actualRes2)
 
 }
+
+func TestGetSchedulerHints(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expected    *schedulerhints.SchedulerHints
+		expectedErr bool
+	}{
+		{
+			name:       "no hints",
+			parameters: map[string]string{"type": "ssd"},
+		},
+		{
+			name: "volume and instance hints",
+			parameters: map[string]string{
+				"scheduler-hint-same-host":         "a0cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11, ",
+				"scheduler-hint-different-host":    "b1cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11,c2cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11",
+				"scheduler-hint-local-to-instance": "d3cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11",
+			},
+			expected: &schedulerhints.SchedulerHints{
+				SameHost:        []string{"a0cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11"},
+				DifferentHost:   []string{"b1cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11", "c2cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11"},
+				LocalToInstance: "d3cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11",
+			},
+		},
+		{
+			name:       "custom hint",
+			parameters: map[string]string{"scheduler-hint-rack": "r12"},
+			expected:   &schedulerhints.SchedulerHints{AdditionalProperties: map[string]interface{}{"rack": "r12"}},
+		},
+		{
+			name:        "invalid volume ID",
+			parameters:  map[string]string{"scheduler-hint-same-host": "vol-1"},
+			expectedErr: true,
+		},
+		{
+			name:        "missing hint name",
+			parameters:  map[string]string{"scheduler-hint-": "r12"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hints, err := getSchedulerHints(test.parameters)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, hints)
+		})
+	}
+}
+
+func TestCreateVolumeWithSchedulerHints(t *testing.T) {
+	properties := map[string]string{"cinder.csi.openstack.org/cluster": FakeCluster}
+	hints := &schedulerhints.SchedulerHints{DifferentHost: []string{"b1cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11"}}
+	osmock.On("CreateVolume", "fake-hinted", mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties, hints).Return(&FakeVol, nil)
+	osmock.On("GetVolumesByName", "fake-hinted").Return(FakeVolListEmpty, nil)
+
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: "fake-hinted",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			"type":                          FakeVolType,
+			"availability":                  FakeAvailability,
+			"scheduler-hint-different-host": "b1cba4e0-e5c4-4e4b-9f5c-7c3f1b3e2d11",
+		},
+	}
+
+	_, err := fakeCs.CreateVolume(FakeCtx, fakeReq)
+	assert.NoError(t, err)
+	osmock.AssertCalled(t, "CreateVolume", "fake-hinted", mock.AnythingOfType("int"), FakeVolType, FakeAvailability, "", "", &properties, hints)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+const (
+	deletionRetryDelay    = 5 * time.Second
+	deletionMaxRetryDelay = 5 * time.Minute
+)
+
+var (
+	deletionQueueDepth = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name: "cinder_csi_volume_deletion_queue_depth",
+			Help: "Number of Cinder volumes waiting to be or being deleted",
+		})
+
+	registerDeletionQueueMetrics sync.Once
+)
+
+// volumeDeletion is the deletion of a volume in progress
+type volumeDeletion struct {
+	done chan struct{}
+	err  error
+}
+
+// deletionQueue throttles the volume deletions, so that deleting many PVCs at
+// once does not trip the Cinder API rate limits. At most concurrency volumes
+// are deleted at the same time, and a failed deletion is retried up to
+// maxRetries times with an exponential backoff.
+//
+// A deletion keeps going when the DeleteVolume call waiting for it times out,
+// the retried call then waits for the same deletion.
+type deletionQueue struct {
+	cloud      openstack.IOpenStack
+	maxRetries int
+	retryDelay time.Duration
+	slots      chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*volumeDeletion
+}
+
+// newDeletionQueue returns a queue deleting concurrency volumes at a time, or
+// nil if concurrency is not positive.
+func newDeletionQueue(cloud openstack.IOpenStack, concurrency, maxRetries int) *deletionQueue {
+	if concurrency <= 0 {
+		return nil
+	}
+	registerDeletionQueueMetrics.Do(func() {
+		legacyregistry.MustRegister(deletionQueueDepth)
+	})
+
+	return &deletionQueue{
+		cloud:      cloud,
+		maxRetries: maxRetries,
+		retryDelay: deletionRetryDelay,
+		slots:      make(chan struct{}, concurrency),
+		pending:    make(map[string]*volumeDeletion),
+	}
+}
+
+// delete queues the deletion of the volume and waits until it is done or ctx
+// is done. A volume which does not exist is considered deleted.
+func (q *deletionQueue) delete(ctx context.Context, volumeID string) error {
+	q.mu.Lock()
+	d, ok := q.pending[volumeID]
+	if !ok {
+		d = &volumeDeletion{done: make(chan struct{})}
+		q.pending[volumeID] = d
+		deletionQueueDepth.Set(float64(len(q.pending)))
+		go q.run(volumeID, d)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *deletionQueue) run(volumeID string, d *volumeDeletion) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, volumeID)
+		deletionQueueDepth.Set(float64(len(q.pending)))
+		q.mu.Unlock()
+		close(d.done)
+	}()
+
+	delay := q.retryDelay
+	for attempt := 0; ; attempt++ {
+		q.slots <- struct{}{}
+		err := q.cloud.DeleteVolume(volumeID)
+		<-q.slots
+
+		if err == nil {
+			return
+		}
+		if cpoerrors.IsNotFound(err) {
+			klog.V(3).Infof("Volume %s is already deleted.", volumeID)
+			return
+		}
+		if attempt >= q.maxRetries {
+			d.err = err
+			return
+		}
+
+		klog.Warningf("Failed to delete volume %s, retrying in %v: %v", volumeID, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > deletionMaxRetryDelay {
+			delay = deletionMaxRetryDelay
+		}
+	}
+}
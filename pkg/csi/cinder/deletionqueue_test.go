@@ -0,0 +1,45 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newDeletionQueue(osmock, 0, 3))
+
+	q := newDeletionQueue(osmock, 1, 1)
+	q.retryDelay = time.Millisecond
+
+	// A failed deletion is retried
+	osmock.On("DeleteVolume", "retried-vol").Return(errors.New("rate limited")).Once()
+	osmock.On("DeleteVolume", "retried-vol").Return(nil).Once()
+	assert.NoError(q.delete(FakeCtx, "retried-vol"))
+
+	// The error is returned once the retries are exhausted
+	osmock.On("DeleteVolume", "failed-vol").Return(errors.New("rate limited")).Twice()
+	assert.Error(q.delete(FakeCtx, "failed-vol"))
+
+	assert.Empty(q.pending)
+}
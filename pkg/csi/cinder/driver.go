@@ -59,6 +59,21 @@ type Driver struct {
 	fqVersion string //Fully qualified version in format {Version}@{CPO version}
 	endpoint  string
 	cluster   string
+	// Directory of the node journal, journaling is disabled if empty
+	journalDir string
+	// Volume deletions in parallel and their retries, deletions are not queued if not positive
+	deleteConcurrency int
+	deleteRetries     int
+	// Limits of the volume cache, volumes are not cached if volumeCacheSize is not positive
+	volumeCacheSize    int
+	volumeCacheMaxGB   int
+	volumeCacheMinUses int
+	// Reports the volumes nearing full, may be nil
+	usageMonitor *VolumeUsageMonitor
+	// Exports the IO statistics of the volumes, may be nil
+	ioCollector *VolumeIOCollector
+	// Records the attachments on the nodes, may be nil
+	attachEvents *AttachEventRecorder
 
 	ids *identityServer
 	cs  *controllerServer
@@ -155,12 +170,72 @@ func (d *Driver) GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_Access
 	return d.vcap
 }
 
+// SetNodeJournalDir enables the on-disk journal of node stage and publish
+// steps, stored in dir. It must be called before SetupDriver.
+func (d *Driver) SetNodeJournalDir(dir string) {
+	d.journalDir = dir
+}
+
+// SetDeletionQueue throttles the volume deletions of the controller plugin
+// to concurrency at a time, retrying each failed deletion up to retries
+// times. It must be called before SetupDriver.
+func (d *Driver) SetDeletionQueue(concurrency, retries int) {
+	d.deleteConcurrency = concurrency
+	d.deleteRetries = retries
+}
+
+// SetVolumeCache caches up to size volumes, and up to maxGB GiB if positive,
+// created from the snapshots used at least minUses times as volume sources,
+// and clones them to create the volumes from these snapshots. It must be
+// called before SetupDriver.
+func (d *Driver) SetVolumeCache(size, maxGB, minUses int) {
+	d.volumeCacheSize = size
+	d.volumeCacheMaxGB = maxGB
+	d.volumeCacheMinUses = minUses
+}
+
+// SetVolumeUsageMonitor reports the filesystem volumes of the node plugin
+// nearing full with the monitor, which may be nil. It must be called before
+// SetupDriver.
+func (d *Driver) SetVolumeUsageMonitor(m *VolumeUsageMonitor) {
+	d.usageMonitor = m
+}
+
+// SetVolumeIOCollector exports the IO statistics of the volumes staged by the
+// node plugin with the collector, which may be nil. It must be called before
+// SetupDriver.
+func (d *Driver) SetVolumeIOCollector(c *VolumeIOCollector) {
+	d.ioCollector = c
+}
+
+// SetAttachEventRecorder records the volumes attached and detached by the
+// controller plugin on their nodes with the recorder, which may be nil. It
+// must be called before SetupDriver.
+func (d *Driver) SetAttachEventRecorder(r *AttachEventRecorder) {
+	d.attachEvents = r
+}
+
 func (d *Driver) SetupDriver(cloud openstack.IOpenStack, mount mount.IMount, metadata metadata.IMetadata) {
 
 	d.ids = NewIdentityServer(d)
 	d.cs = NewControllerServer(d, cloud)
+	d.cs.deletions = newDeletionQueue(cloud, d.deleteConcurrency, d.deleteRetries)
+	d.cs.volumeCache = newVolumeCache(cloud, d.cluster, d.volumeCacheSize, d.volumeCacheMaxGB, d.volumeCacheMinUses)
+	d.cs.attachEvents = d.attachEvents
+	if d.cs.volumeCache != nil {
+		if err := d.cs.volumeCache.load(); err != nil {
+			klog.Warningf("Failed to load the volume cache: %v", err)
+		}
+	}
 	d.ns = NewNodeServer(d, mount, metadata, cloud)
 
+	journal, err := newNodeJournal(d.journalDir)
+	if err != nil {
+		klog.Warningf("Node journal disabled: %v", err)
+	}
+	d.ns.journal = journal
+	d.ns.usageMonitor = d.usageMonitor
+	d.ns.ioCollector = d.ioCollector
 }
 
 func (d *Driver) Run() {
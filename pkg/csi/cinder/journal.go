@@ -0,0 +1,218 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// journalStep is the last step recorded for a stage or publish operation
+type journalStep string
+
+const (
+	// journalStepStarted means the operation began but did not complete,
+	// e.g. the plugin or the node crashed half way through.
+	journalStepStarted journalStep = "started"
+	// journalStepDone means the operation completed successfully.
+	journalStepDone journalStep = "done"
+)
+
+// journalEntry records the node-side state of a single volume
+type journalEntry struct {
+	VolumeID          string                 `json:"volumeID"`
+	DevicePath        string                 `json:"devicePath,omitempty"`
+	StagingTargetPath string                 `json:"stagingTargetPath,omitempty"`
+	Stage             journalStep            `json:"stage,omitempty"`
+	Publish           map[string]journalStep `json:"publish,omitempty"`
+}
+
+// nodeJournal is a small on-disk journal of the stage and publish steps of
+// each volume on this node. It allows NodeStageVolume and NodePublishVolume
+// to tell an interrupted operation apart from a completed one after a crash,
+// so the stale mount can be cleaned up before the operation is retried.
+//
+// A nil *nodeJournal is valid and records nothing.
+type nodeJournal struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// newNodeJournal returns a journal persisting its entries in dir, or nil if
+// dir is empty.
+func newNodeJournal(dir string) (*nodeJournal, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create node journal directory %s: %v", dir, err)
+	}
+	return &nodeJournal{dir: dir}, nil
+}
+
+func (j *nodeJournal) path(volumeID string) string {
+	return filepath.Join(j.dir, filepath.Base(volumeID)+".json")
+}
+
+// get returns the entry of the volume, or nil if there is none.
+func (j *nodeJournal) get(volumeID string) (*journalEntry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.read(volumeID)
+}
+
+func (j *nodeJournal) read(volumeID string) (*journalEntry, error) {
+	data, err := ioutil.ReadFile(j.path(volumeID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entry := &journalEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		// A torn write leaves nothing to recover from, start over.
+		klog.Warningf("Ignoring corrupted journal entry for volume %s: %v", volumeID, err)
+		return nil, nil
+	}
+	return entry, nil
+}
+
+func (j *nodeJournal) write(entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so the entry is replaced atomically.
+	path := j.path(entry.VolumeID)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// update applies fn to the entry of the volume and persists the result.
+// The entry is removed once it no longer records any stage or publish step.
+func (j *nodeJournal) update(volumeID string, fn func(*journalEntry)) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, err := j.read(volumeID)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		entry = &journalEntry{VolumeID: volumeID}
+	}
+	fn(entry)
+
+	if entry.Stage == "" && len(entry.Publish) == 0 {
+		err := os.Remove(j.path(volumeID))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return j.write(entry)
+}
+
+// stageStarted records that the volume is about to be staged.
+func (j *nodeJournal) stageStarted(volumeID, devicePath, stagingTargetPath string) error {
+	return j.update(volumeID, func(e *journalEntry) {
+		e.DevicePath = devicePath
+		e.StagingTargetPath = stagingTargetPath
+		e.Stage = journalStepStarted
+	})
+}
+
+// stageDone records that the volume has been staged.
+func (j *nodeJournal) stageDone(volumeID string) error {
+	return j.update(volumeID, func(e *journalEntry) {
+		e.Stage = journalStepDone
+	})
+}
+
+// unstaged forgets the stage step of the volume.
+func (j *nodeJournal) unstaged(volumeID string) error {
+	return j.update(volumeID, func(e *journalEntry) {
+		e.DevicePath = ""
+		e.StagingTargetPath = ""
+		e.Stage = ""
+	})
+}
+
+// publishStarted records that the volume is about to be published to targetPath.
+func (j *nodeJournal) publishStarted(volumeID, targetPath string) error {
+	return j.update(volumeID, func(e *journalEntry) {
+		if e.Publish == nil {
+			e.Publish = map[string]journalStep{}
+		}
+		e.Publish[targetPath] = journalStepStarted
+	})
+}
+
+// publishDone records that the volume has been published to targetPath.
+func (j *nodeJournal) publishDone(volumeID, targetPath string) error {
+	return j.update(volumeID, func(e *journalEntry) {
+		if e.Publish == nil {
+			e.Publish = map[string]journalStep{}
+		}
+		e.Publish[targetPath] = journalStepDone
+	})
+}
+
+// unpublished forgets the publish step of the volume for targetPath.
+func (j *nodeJournal) unpublished(volumeID, targetPath string) error {
+	return j.update(volumeID, func(e *journalEntry) {
+		delete(e.Publish, targetPath)
+	})
+}
+
+// interruptedStage reports whether a previous stage of the volume to
+// stagingTargetPath was started but never completed.
+func (j *nodeJournal) interruptedStage(volumeID, stagingTargetPath string) (bool, error) {
+	entry, err := j.get(volumeID)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	return entry.Stage == journalStepStarted && entry.StagingTargetPath == stagingTargetPath, nil
+}
+
+// interruptedPublish reports whether a previous publish of the volume to
+// targetPath was started but never completed.
+func (j *nodeJournal) interruptedPublish(volumeID, targetPath string) (bool, error) {
+	entry, err := j.get(volumeID)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	return entry.Publish[targetPath] == journalStepStarted, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeJournal(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "cinder-journal")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	j, err := newNodeJournal(dir)
+	assert.NoError(err)
+
+	// Stage interrupted before completion
+	assert.NoError(j.stageStarted(FakeVolID, FakeDevicePath, FakeStagingTargetPath))
+	interrupted, err := j.interruptedStage(FakeVolID, FakeStagingTargetPath)
+	assert.NoError(err)
+	assert.True(interrupted)
+
+	// A different staging target is not considered interrupted
+	interrupted, err = j.interruptedStage(FakeVolID, "/mnt/other")
+	assert.NoError(err)
+	assert.False(interrupted)
+
+	assert.NoError(j.stageDone(FakeVolID))
+	interrupted, err = j.interruptedStage(FakeVolID, FakeStagingTargetPath)
+	assert.NoError(err)
+	assert.False(interrupted)
+
+	// Publish interrupted before completion
+	assert.NoError(j.publishStarted(FakeVolID, FakeTargetPath))
+	interrupted, err = j.interruptedPublish(FakeVolID, FakeTargetPath)
+	assert.NoError(err)
+	assert.True(interrupted)
+
+	assert.NoError(j.publishDone(FakeVolID, FakeTargetPath))
+	entry, err := j.get(FakeVolID)
+	assert.NoError(err)
+	assert.Equal(&journalEntry{
+		VolumeID:          FakeVolID,
+		DevicePath:        FakeDevicePath,
+		StagingTargetPath: FakeStagingTargetPath,
+		Stage:             journalStepDone,
+		Publish:           map[string]journalStep{FakeTargetPath: journalStepDone},
+	}, entry)
+
+	// The entry is removed once the volume is unpublished and unstaged
+	assert.NoError(j.unpublished(FakeVolID, FakeTargetPath))
+	assert.NoError(j.unstaged(FakeVolID))
+	entry, err = j.get(FakeVolID)
+	assert.NoError(err)
+	assert.Nil(entry)
+	_, err = os.Stat(filepath.Join(dir, FakeVolID+".json"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestNodeJournalDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	j, err := newNodeJournal("")
+	assert.NoError(err)
+	assert.Nil(j)
+
+	assert.NoError(j.stageStarted(FakeVolID, FakeDevicePath, FakeStagingTargetPath))
+	interrupted, err := j.interruptedStage(FakeVolID, FakeStagingTargetPath)
+	assert.NoError(err)
+	assert.False(interrupted)
+}
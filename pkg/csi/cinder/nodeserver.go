@@ -45,6 +45,13 @@ type nodeServer struct {
 	Mount    mount.IMount
 	Metadata metadata.IMetadata
 	Cloud    openstack.IOpenStack
+
+	// journal records the stage and publish steps of each volume, may be nil
+	journal *nodeJournal
+	// usageMonitor reports the volumes nearing full, may be nil
+	usageMonitor *VolumeUsageMonitor
+	// ioCollector exports the IO statistics of the volumes, may be nil
+	ioCollector *VolumeIOCollector
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
@@ -95,6 +102,18 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 
 	m := ns.Mount
+	// Clean up the leftovers of a publish interrupted by a crash
+	interrupted, err := ns.journal.interruptedPublish(volumeID, targetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to read node journal: %v", err)
+	}
+	if interrupted {
+		klog.V(4).Infof("NodePublishVolume: previous publish of volume %s to %s was interrupted, cleaning up", volumeID, targetPath)
+		if err := m.UnmountPath(targetPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "Unmount of targetpath %s failed with error %v", targetPath, err)
+		}
+	}
+
 	// Verify whether mounted
 	notMnt, err := m.IsLikelyNotMountPointAttach(targetPath)
 	if err != nil {
@@ -109,6 +128,9 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 				fsType = mnt.FsType
 			}
 		}
+		if err := ns.journal.publishStarted(volumeID, targetPath); err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to update node journal: %v", err)
+		}
 		// Mount
 		err = m.Mounter().Mount(source, targetPath, fsType, mountOptions)
 		if err != nil {
@@ -116,6 +138,10 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		}
 	}
 
+	if err := ns.journal.publishDone(volumeID, targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to update node journal: %v", err)
+	}
+
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -149,7 +175,7 @@ func nodePublishEphemeral(req *csi.NodePublishVolumeRequest, ns *nodeServer) (*c
 		volumeType = ""
 	}
 
-	evol, err := ns.Cloud.CreateVolume(volName, size, volumeType, volAvailability, "", "", &properties)
+	evol, err := ns.Cloud.CreateVolume(volName, size, volumeType, volAvailability, "", "", &properties, nil)
 
 	if err != nil {
 		klog.V(3).Infof("Failed to Create Ephemeral Volume: %v", err)
@@ -301,6 +327,10 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Errorf(codes.Internal, "Unmount of targetpath %s failed with error %v", targetPath, err)
 	}
 
+	if err := ns.journal.unpublished(volumeID, targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to update node journal: %v", err)
+	}
+
 	if ephemeralVolume {
 		return nodeUnpublishEphemeral(req, ns, vol)
 	}
@@ -372,18 +402,36 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unable to find Device path for volume: %v", err))
 	}
+	ns.ioCollector.track(volumeID, devicePath)
 
 	if blk := volumeCapability.GetBlock(); blk != nil {
 		// If block volume, do nothing
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	// Clean up the leftovers of a stage interrupted by a crash, the staging
+	// target may hold a half-done mount of a stale device
+	interrupted, err := ns.journal.interruptedStage(volumeID, stagingTarget)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to read node journal: %v", err)
+	}
+	if interrupted {
+		klog.V(4).Infof("NodeStageVolume: previous stage of volume %s to %s was interrupted, cleaning up", volumeID, stagingTarget)
+		if err := m.UnmountPath(stagingTarget); err != nil {
+			return nil, status.Errorf(codes.Internal, "Unmount of targetPath %s failed with error %v", stagingTarget, err)
+		}
+	}
+
 	// Verify whether mounted
 	notMnt, err := m.IsLikelyNotMountPointAttach(stagingTarget)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if err := ns.journal.stageStarted(volumeID, devicePath, stagingTarget); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to update node journal: %v", err)
+	}
+
 	// Volume Mount
 	if notMnt {
 		// set default fstype is ext4
@@ -422,6 +470,10 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		}
 	}
 
+	if err := ns.journal.stageDone(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to update node journal: %v", err)
+	}
+
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -452,6 +504,12 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Errorf(codes.Internal, "Unmount of targetPath %s failed with error %v", stagingTargetPath, err)
 	}
 
+	if err := ns.journal.unstaged(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to update node journal: %v", err)
+	}
+	ns.usageMonitor.forget(volumeID)
+	ns.ioCollector.untrack(volumeID)
+
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
@@ -510,6 +568,13 @@ func (ns *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolu
 		return nil, status.Errorf(codes.Internal, "failed to get stats by path: %s", err)
 	}
 
+	// The volumes staged before the plugin restarted are collected again
+	if ns.ioCollector != nil && !ns.ioCollector.tracked(volumeID) {
+		if devicePath, err := getDevicePath(volumeID, ns.Mount); err == nil {
+			ns.ioCollector.track(volumeID, devicePath)
+		}
+	}
+
 	if stats.Block {
 		return &csi.NodeGetVolumeStatsResponse{
 			Usage: []*csi.VolumeUsage{
@@ -521,6 +586,8 @@ func (ns *nodeServer) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolu
 		}, nil
 	}
 
+	ns.usageMonitor.observe(volumeID, stats)
+
 	return &csi.NodeGetVolumeStatsResponse{
 		Usage: []*csi.VolumeUsage{
 			{Total: stats.TotalBytes, Available: stats.AvailableBytes, Used: stats.UsedBytes, Unit: csi.VolumeUsage_BYTES},
@@ -559,12 +626,11 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Error(codes.Internal, "Unable to find Device path for volume")
 	}
 
-	if ns.Cloud.GetBlockStorageOpts().RescanOnResize {
-		// comparing current volume size with the expected one
-		newSize := req.GetCapacityRange().GetRequiredBytes()
-		if err := blockdevice.RescanBlockDeviceGeometry(devicePath, volumePath, newSize); err != nil {
-			return nil, status.Errorf(codes.Internal, "Could not verify %q volume size: %v", volumeID, err)
-		}
+	// The device mapper devices, e.g. LUKS or multipath, are resized after the
+	// volume, and the volume size is compared with the expected one if rescanned
+	newSize := req.GetCapacityRange().GetRequiredBytes()
+	if err := blockdevice.ResizeDeviceStack(ns.Mount.Mounter().Exec, devicePath, volumePath, newSize, ns.Cloud.GetBlockStorageOpts().RescanOnResize); err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not resize the device of %q volume: %v", volumeID, err)
 	}
 	r := mountutil.NewResizeFs(ns.Mount.Mounter().Exec)
 	if _, err := r.Resize(devicePath, volumePath); err != nil {
@@ -17,17 +17,25 @@ limitations under the License.
 package openstack
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/spf13/pflag"
 	gcfg "gopkg.in/gcfg.v1"
+	"k8s.io/apimachinery/pkg/util/cache"
 	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
 	"k8s.io/klog/v2"
 )
@@ -38,10 +46,11 @@ var userAgentData []string
 // AddExtraFlags is called by the main package to add component specific command line flags
 func AddExtraFlags(fs *pflag.FlagSet) {
 	fs.StringArrayVar(&userAgentData, "user-agent", nil, "Extra data to add to gophercloud user-agent. Use multiple times to add more than one component.")
+	client.AddTestModeFlags(fs)
 }
 
 type IOpenStack interface {
-	CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourcevolID string, tags *map[string]string) (*volumes.Volume, error)
+	CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourcevolID string, tags *map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error)
 	DeleteVolume(volumeID string) error
 	AttachVolume(instanceID, volumeID string) (string, error)
 	ListVolumes(limit int, startingToken string) ([]volumes.Volume, string, error)
@@ -62,6 +71,8 @@ type IOpenStack interface {
 	GetMaxVolLimit() int64
 	GetMetadataOpts() metadata.Opts
 	GetBlockStorageOpts() BlockStorageOpts
+	ForProject(projectID string) (IOpenStack, error)
+	ForSecrets(secrets map[string]string) (IOpenStack, error)
 }
 
 type OpenStack struct {
@@ -70,6 +81,17 @@ type OpenStack struct {
 	bsOpts       BlockStorageOpts
 	epOpts       gophercloud.EndpointOpts
 	metadataOpts metadata.Opts
+
+	// Credentials, used to create the clients of other projects
+	authOpts client.AuthOpts
+	// Clients scoped to other projects, by project ID
+	projects   map[string]*OpenStack
+	projectsMu sync.Mutex
+	// Clients authenticated with the credentials of secrets, by digest of the
+	// secrets. The least recently used are evicted, and the clients expire, so
+	// that the clients of rotated or deleted secrets are released.
+	secretClients   *cache.LRUExpireCache
+	secretClientsMu sync.Mutex
 }
 
 type BlockStorageOpts struct {
@@ -127,6 +149,15 @@ func GetConfigFromFiles(configFilePaths []string) (Config, error) {
 
 const defaultMaxVolAttachLimit int64 = 256
 
+const (
+	// secretClientsMaxSize bounds the number of the clients cached for the
+	// credentials of secrets
+	secretClientsMaxSize = 64
+	// secretClientsTTL is the time the clients of the credentials of secrets
+	// are cached for
+	secretClientsTTL = time.Hour
+)
+
 var OsInstance IOpenStack
 var configFiles = []string{"/etc/cloud.conf"}
 
@@ -171,19 +202,180 @@ func CreateOpenStackProvider() (IOpenStack, error) {
 	if len(cfg.Metadata.SearchOrder) == 0 {
 		cfg.Metadata.SearchOrder = fmt.Sprintf("%s,%s", metadata.ConfigDriveID, metadata.MetadataID)
 	}
+	metadata.SetOpts(cfg.Metadata)
 
 	// Init OpenStack
 	OsInstance = &OpenStack{
-		compute:      computeclient,
-		blockstorage: blockstorageclient,
-		bsOpts:       cfg.BlockStorage,
-		epOpts:       epOpts,
-		metadataOpts: cfg.Metadata,
+		compute:       computeclient,
+		blockstorage:  blockstorageclient,
+		bsOpts:        cfg.BlockStorage,
+		epOpts:        epOpts,
+		metadataOpts:  cfg.Metadata,
+		authOpts:      cfg.Global,
+		projects:      make(map[string]*OpenStack),
+		secretClients: cache.NewLRUExpireCache(secretClientsMaxSize),
 	}
 
 	return OsInstance, nil
 }
 
+// ForProject returns an OpenStack instance whose volumes are created in, and
+// accounted in the quota of, the project projectID. The token of the plugin
+// credentials is rescoped to the project, without authenticating again, and
+// only the block storage client uses it: the compute client is the one of
+// the plugin, so the volumes are still attached with the plugin credentials.
+// Keystone only rescopes the token if the user has a role in the project,
+// e.g. inherited from its domain, and never the tokens of trusts and
+// application credentials.
+func (os *OpenStack) ForProject(projectID string) (IOpenStack, error) {
+	if projectID == os.authOpts.TenantID {
+		return os, nil
+	}
+	if os.authOpts.TrustID != "" || os.authOpts.ApplicationCredentialID != "" || os.authOpts.ApplicationCredentialName != "" {
+		return nil, fmt.Errorf("cannot create volumes in project %s, the tokens of trusts and application credentials cannot be rescoped", projectID)
+	}
+
+	os.projectsMu.Lock()
+	defer os.projectsMu.Unlock()
+	if p, ok := os.projects[projectID]; ok {
+		return p, nil
+	}
+
+	provider, err := rescopedProviderClient(os.blockstorage.ProviderClient, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rescope the token to project %s: %v", projectID, err)
+	}
+	blockstorageclient, err := openstack.NewBlockStorageV3(provider, os.epOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	authOpts := os.authOpts
+	authOpts.TenantID = projectID
+	authOpts.TenantName = ""
+	authOpts.TenantDomainID = ""
+	authOpts.TenantDomainName = ""
+
+	p := &OpenStack{
+		compute:       os.compute,
+		blockstorage:  blockstorageclient,
+		bsOpts:        os.bsOpts,
+		epOpts:        os.epOpts,
+		metadataOpts:  os.metadataOpts,
+		authOpts:      authOpts,
+		projects:      make(map[string]*OpenStack),
+		secretClients: cache.NewLRUExpireCache(secretClientsMaxSize),
+	}
+	os.projects[projectID] = p
+	klog.V(3).Infof("Created the block storage client of project %s", projectID)
+
+	return p, nil
+}
+
+// rescopedProviderClient returns a provider client authenticated with the
+// token of parent rescoped to the project projectID. Once the rescoped token
+// expires, which is when the token of parent expires, parent authenticates
+// again and its new token is rescoped.
+func rescopedProviderClient(parent *gophercloud.ProviderClient, projectID string) (*gophercloud.ProviderClient, error) {
+	provider, err := openstack.NewClient(parent.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider.HTTPClient = parent.HTTPClient
+	provider.UserAgent = parent.UserAgent
+	provider.UseTokenLock()
+
+	rescope := func() error {
+		return openstack.AuthenticateV3(provider, &tokens.AuthOptions{
+			TokenID: parent.Token(),
+			Scope:   tokens.Scope{ProjectID: projectID},
+		}, gophercloud.EndpointOpts{})
+	}
+	if err := rescope(); err != nil {
+		return nil, err
+	}
+	provider.ReauthFunc = func() error {
+		if err := parent.Reauthenticate(parent.Token()); err != nil {
+			return err
+		}
+		return rescope()
+	}
+
+	return provider, nil
+}
+
+// ForSecrets returns an OpenStack instance authenticated with the OpenStack
+// credentials of the secrets of a CSI request, e.g. the provisioner secret
+// of a StorageClass, instead of the credentials of the config file. The
+// secrets use the keys of the manila-csi-plugin secrets, e.g. os-authURL,
+// os-userName, os-password, os-projectID and os-region. The instance itself
+// is returned if there are no secrets.
+func (os *OpenStack) ForSecrets(secrets map[string]string) (IOpenStack, error) {
+	if len(secrets) == 0 {
+		return os, nil
+	}
+
+	// The clients are created again when the secrets change
+	key := secretsDigest(secrets)
+	os.secretClientsMu.Lock()
+	defer os.secretClientsMu.Unlock()
+	if p, ok := os.secretClients.Get(key); ok {
+		return p.(*OpenStack), nil
+	}
+
+	authOpts, err := options.NewOpenstackOptions(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenStack credentials in secrets: %v", err)
+	}
+	provider, err := client.NewOpenStackClient(authOpts, "cinder-csi-plugin", userAgentData...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with the OpenStack credentials in secrets: %v", err)
+	}
+
+	epOpts := gophercloud.EndpointOpts{
+		Region:       authOpts.Region,
+		Availability: authOpts.EndpointType,
+	}
+	computeclient, err := openstack.NewComputeV2(provider, epOpts)
+	if err != nil {
+		return nil, err
+	}
+	blockstorageclient, err := openstack.NewBlockStorageV3(provider, epOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &OpenStack{
+		compute:       computeclient,
+		blockstorage:  blockstorageclient,
+		bsOpts:        os.bsOpts,
+		epOpts:        epOpts,
+		metadataOpts:  os.metadataOpts,
+		authOpts:      *authOpts,
+		projects:      make(map[string]*OpenStack),
+		secretClients: cache.NewLRUExpireCache(secretClientsMaxSize),
+	}
+	os.secretClients.Add(key, p, secretClientsTTL)
+	klog.V(3).Infof("Created the OpenStack clients of the credentials in secrets for region %s", authOpts.Region)
+
+	return p, nil
+}
+
+// secretsDigest returns the digest of the secrets the clients of their
+// credentials are cached by.
+func secretsDigest(secrets map[string]string) string {
+	keys := make([]string, 0, len(secrets))
+	for k := range secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	digest := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(digest, "%s=%s\n", k, secrets[k])
+	}
+	return fmt.Sprintf("%x", digest.Sum(nil))
+}
+
 // GetOpenStackProvider returns Openstack Instance
 func GetOpenStackProvider() (IOpenStack, error) {
 	if OsInstance != nil {
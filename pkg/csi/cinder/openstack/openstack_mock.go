@@ -17,6 +17,7 @@ limitations under the License.
 package openstack
 
 import (
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
@@ -71,20 +72,20 @@ func (_m *OpenStackMock) AttachVolume(instanceID string, volumeID string) (strin
 	return r0, r1
 }
 
-// CreateVolume provides a mock function with given fields: name, size, vtype, availability, tags
-func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, sourceVolID string, tags *map[string]string) (*volumes.Volume, error) {
-	ret := _m.Called(name, size, vtype, availability, snapshotID, sourceVolID, tags)
+// CreateVolume provides a mock function with given fields: name, size, vtype, availability, tags, schedulerHints
+func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, sourceVolID string, tags *map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
+	ret := _m.Called(name, size, vtype, availability, snapshotID, sourceVolID, tags, schedulerHints)
 
 	var r0 *volumes.Volume
-	if rf, ok := ret.Get(0).(func(string, int, string, string, string, string, *map[string]string) *volumes.Volume); ok {
-		r0 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags)
+	if rf, ok := ret.Get(0).(func(string, int, string, string, string, string, *map[string]string, *schedulerhints.SchedulerHints) *volumes.Volume); ok {
+		r0 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags, schedulerHints)
 	} else {
 		r0 = ret.Get(0).(*volumes.Volume)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, int, string, string, string, string, *map[string]string) error); ok {
-		r1 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags)
+	if rf, ok := ret.Get(1).(func(string, int, string, string, string, string, *map[string]string, *schedulerhints.SchedulerHints) error); ok {
+		r1 = rf(name, size, vtype, availability, snapshotID, sourceVolID, tags, schedulerHints)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -387,3 +388,45 @@ func (_m *OpenStackMock) GetMetadataOpts() metadata.Opts {
 func (_m *OpenStackMock) GetBlockStorageOpts() BlockStorageOpts {
 	return BlockStorageOpts{}
 }
+
+// ForProject provides a mock function with given fields: projectID
+func (_m *OpenStackMock) ForProject(projectID string) (IOpenStack, error) {
+	ret := _m.Called(projectID)
+
+	var r0 IOpenStack
+	if rf, ok := ret.Get(0).(func(string) IOpenStack); ok {
+		r0 = rf(projectID)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(IOpenStack)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(projectID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ForSecrets provides a mock function with given fields: secrets
+func (_m *OpenStackMock) ForSecrets(secrets map[string]string) (IOpenStack, error) {
+	ret := _m.Called(secrets)
+
+	var r0 IOpenStack
+	if rf, ok := ret.Get(0).(func(map[string]string) IOpenStack); ok {
+		r0 = rf(secrets)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).(IOpenStack)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(map[string]string) error); ok {
+		r1 = rf(secrets)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -17,13 +17,21 @@ limitations under the License.
 package openstack
 
 import (
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	th "github.com/gophercloud/gophercloud/testhelper"
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/cloud-provider-openstack/pkg/client"
 )
 
 var fakeFileName = "cloud.conf"
@@ -207,3 +215,93 @@ func TestUserAgentFlag(t *testing.T) {
 		})
 	}
 }
+
+func TestForSecrets(t *testing.T) {
+	os := &OpenStack{secretClients: cache.NewLRUExpireCache(secretClientsMaxSize)}
+
+	// Without secrets, the credentials of the plugin are used
+	cloud, err := os.ForSecrets(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, os, cloud)
+
+	// The secrets must hold valid credentials
+	_, err = os.ForSecrets(map[string]string{
+		"os-authURL":  fakeAuthURL,
+		"os-userName": fakeUserName,
+	})
+	assert.Error(t, err)
+	assert.Empty(t, os.secretClients.Keys())
+
+	// The clients of the same secrets are reused
+	secrets := map[string]string{"os-authURL": fakeAuthURL, "os-userName": fakeUserName, "os-password": fakePassword}
+	cached := &OpenStack{}
+	os.secretClients.Add(secretsDigest(secrets), cached, secretClientsTTL)
+	cloud, err = os.ForSecrets(map[string]string{"os-password": fakePassword, "os-userName": fakeUserName, "os-authURL": fakeAuthURL})
+	assert.NoError(t, err)
+	assert.Same(t, cached, cloud)
+
+	// The clients of rotated secrets are not reused
+	rotated := map[string]string{"os-authURL": fakeAuthURL, "os-userName": fakeUserName, "os-password": "rotated"}
+	assert.NotEqual(t, secretsDigest(secrets), secretsDigest(rotated))
+
+	// The cache is bounded, the least recently used clients are evicted
+	for i := 0; i < secretClientsMaxSize; i++ {
+		os.secretClients.Add(fmt.Sprintf("digest-%d", i), &OpenStack{}, secretClientsTTL)
+	}
+	assert.Len(t, os.secretClients.Keys(), secretClientsMaxSize)
+	_, ok := os.secretClients.Get(secretsDigest(secrets))
+	assert.False(t, ok)
+}
+
+func TestForProject(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodPost)
+		body, _ := io.ReadAll(r.Body)
+		// The token of the plugin is rescoped, the plugin credentials are not sent again
+		if !strings.Contains(string(body), `"token":{"id":"admin-token"}`) || !strings.Contains(string(body), `"project":{"id":"tenant-project"}`) {
+			t.Errorf("unexpected token request %s", body)
+		}
+		w.Header().Set("X-Subject-Token", "tenant-token")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"expires_at": "2099-01-01T00:00:00Z", "catalog": [{"type": "volumev3", "endpoints": [{"interface": "public", "region": "RegionOne", "url": "%sv3/tenant-project/"}]}]}}`, th.Endpoint())
+	})
+
+	parent, err := openstack.NewClient(th.Endpoint() + "v3/")
+	assert.NoError(t, err)
+	parent.SetToken("admin-token")
+
+	compute := &gophercloud.ServiceClient{ProviderClient: parent}
+	cloud := &OpenStack{
+		compute:      compute,
+		blockstorage: &gophercloud.ServiceClient{ProviderClient: parent},
+		authOpts:     client.AuthOpts{TenantID: "admin-project"},
+		projects:     make(map[string]*OpenStack),
+	}
+
+	// The project of the plugin is used as is
+	p, err := cloud.ForProject("admin-project")
+	assert.NoError(t, err)
+	assert.Same(t, cloud, p)
+
+	p, err = cloud.ForProject("tenant-project")
+	assert.NoError(t, err)
+	project := p.(*OpenStack)
+	assert.Equal(t, th.Endpoint()+"v3/tenant-project/", project.blockstorage.Endpoint)
+	assert.Equal(t, "tenant-token", project.blockstorage.Token())
+	// The volumes are attached with the plugin credentials
+	assert.Same(t, compute, project.compute)
+	assert.Equal(t, "admin-token", parent.Token())
+
+	// The clients of the project are reused
+	p, err = cloud.ForProject("tenant-project")
+	assert.NoError(t, err)
+	assert.Same(t, project, p)
+
+	// The tokens of trusts cannot be rescoped
+	trust := &OpenStack{authOpts: client.AuthOpts{TrustID: "trust"}, projects: make(map[string]*OpenStack)}
+	_, err = trust.ForProject("tenant-project")
+	assert.Error(t, err)
+}
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	volumeexpand "github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumeactions"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
@@ -49,8 +50,8 @@ const (
 
 var volumeErrorStates = [...]string{"error", "error_extending", "error_deleting"}
 
-// CreateVolume creates a volume of given size
-func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourcevolID string, tags *map[string]string) (*volumes.Volume, error) {
+// CreateVolume creates a volume of given size, with the scheduler hints if not nil
+func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourcevolID string, tags *map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
 
 	opts := &volumes.CreateOpts{
 		Name:             name,
@@ -65,7 +66,15 @@ func (os *OpenStack) CreateVolume(name string, size int, vtype, availability str
 		opts.Metadata = *tags
 	}
 
-	vol, err := volumes.Create(os.blockstorage, opts).Extract()
+	var createOpts volumes.CreateOptsBuilder = opts
+	if schedulerHints != nil {
+		createOpts = schedulerhints.CreateOptsExt{
+			VolumeCreateOptsBuilder: opts,
+			SchedulerHints:          schedulerHints,
+		}
+	}
+
+	vol, err := volumes.Create(os.blockstorage, createOpts).Extract()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// volumeHandleIndex indexes the PVs of the driver by the ID of their volume
+const volumeHandleIndex = "volumeHandle"
+
+// pvIndex finds the PVs of the volumes in an informer cache indexed by volume
+// ID, so that the node plugin doesn't list the PVs of the cluster each time
+// it looks for one.
+type pvIndex struct {
+	informer cache.SharedIndexInformer
+}
+
+// newPVIndex creates a pvIndex and starts its informer until stopCh is closed.
+func newPVIndex(kubeClient kubernetes.Interface, stopCh <-chan struct{}) *pvIndex {
+	informer := coreinformers.NewPersistentVolumeInformer(kubeClient, 0, cache.Indexers{volumeHandleIndex: pvVolumeHandle})
+	go informer.Run(stopCh)
+
+	return &pvIndex{informer: informer}
+}
+
+func pvVolumeHandle(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName {
+		return nil, nil
+	}
+	return []string{pv.Spec.CSI.VolumeHandle}, nil
+}
+
+// find returns the PV of the volume, nil if there is none. It fails until the
+// informer cache is synced.
+func (p *pvIndex) find(volumeID string) (*corev1.PersistentVolume, error) {
+	if !p.informer.HasSynced() {
+		return nil, fmt.Errorf("the PersistentVolume cache is not synced yet")
+	}
+
+	objs, err := p.informer.GetIndexer().ByIndex(volumeHandleIndex, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) == 0 {
+		return nil, nil
+	}
+	return objs[0].(*corev1.PersistentVolume), nil
+}
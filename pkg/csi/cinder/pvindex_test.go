@@ -0,0 +1,61 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newSyncedPVIndex returns a pvIndex of the given PVs whose cache is synced.
+func newSyncedPVIndex(t *testing.T, pvs ...runtime.Object) *pvIndex {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	p := newPVIndex(fake.NewSimpleClientset(pvs...), stopCh)
+	if !cache.WaitForCacheSync(stopCh, p.informer.HasSynced) {
+		t.Fatal("failed to sync the PersistentVolume cache")
+	}
+	return p
+}
+
+func TestPVIndex(t *testing.T) {
+	other := fakePV("other")
+	other.Name = "pv-other"
+	other.Spec.CSI.Driver = "other.csi.openstack.org"
+
+	p := newSyncedPVIndex(t, fakePV(FakeVolID), other)
+
+	pv, err := p.find(FakeVolID)
+	assert.NoError(t, err)
+	assert.Equal(t, fakePV(FakeVolID).Name, pv.Name)
+
+	// The PVs of other drivers are not indexed
+	pv, err = p.find("other")
+	assert.NoError(t, err)
+	assert.Nil(t, pv)
+
+	// The volumes aren't found until the cache is synced
+	stopCh := make(chan struct{})
+	close(stopCh)
+	_, err = newPVIndex(fake.NewSimpleClientset(), stopCh).find(FakeVolID)
+	assert.Error(t, err)
+}
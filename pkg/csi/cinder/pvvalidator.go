@@ -0,0 +1,256 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+const (
+	// PVValidationAnnotation records the result of the validation of a
+	// pre-provisioned PV, "valid" or the reason why the PV is invalid.
+	PVValidationAnnotation = driverName + "/validation"
+
+	pvValidationValid = "valid"
+)
+
+var volumeHandleRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// PVValidator validates statically created Cinder PVs when they are added to
+// the cluster, so that a missing volume or a volume in the wrong availability
+// zone is reported on the PV instead of failing later when mounting it.
+// The result is recorded as an event and in the PVValidationAnnotation.
+type PVValidator struct {
+	cloud      openstack.IOpenStack
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+	queue      workqueue.RateLimitingInterface
+	informer   informers.SharedInformerFactory
+	pvLister   corelisters.PersistentVolumeLister
+	pvSynced   cache.InformerSynced
+}
+
+// NewPVValidator creates a PVValidator using the given kubeconfig, or the
+// in-cluster config if kubeconfig is empty.
+func NewPVValidator(cloud openstack.IOpenStack, kubeconfig string) (*PVValidator, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes client config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{
+		Interface: kubeClient.CoreV1().Events(""),
+	})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: driverName + "-pv-validator"})
+
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	pvInformer := informerFactory.Core().V1().PersistentVolumes()
+
+	v := &PVValidator{
+		cloud:      cloud,
+		kubeClient: kubeClient,
+		recorder:   recorder,
+		queue:      workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		informer:   informerFactory,
+		pvLister:   pvInformer.Lister(),
+		pvSynced:   pvInformer.Informer().HasSynced,
+	}
+
+	pvInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			pv := obj.(*corev1.PersistentVolume)
+			if needsValidation(pv) {
+				v.queue.Add(pv.Name)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			pv := new.(*corev1.PersistentVolume)
+			if needsValidation(pv) {
+				v.queue.Add(pv.Name)
+			}
+		},
+	})
+
+	return v, nil
+}
+
+// Run starts the validator and blocks until stopCh is closed.
+func (v *PVValidator) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer v.queue.ShutDown()
+
+	klog.Info("Starting Cinder PV validator")
+	v.informer.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, v.pvSynced) {
+		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
+		return
+	}
+
+	go wait.Until(v.runWorker, time.Second, stopCh)
+	<-stopCh
+}
+
+func (v *PVValidator) runWorker() {
+	for v.processNextItem() {
+	}
+}
+
+func (v *PVValidator) processNextItem() bool {
+	key, quit := v.queue.Get()
+	if quit {
+		return false
+	}
+	defer v.queue.Done(key)
+
+	if err := v.sync(key.(string)); err != nil {
+		klog.Errorf("Failed to validate PV %s: %v", key, err)
+		v.queue.AddRateLimited(key)
+		return true
+	}
+	v.queue.Forget(key)
+	return true
+}
+
+func (v *PVValidator) sync(name string) error {
+	pv, err := v.pvLister.Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !needsValidation(pv) {
+		return nil
+	}
+
+	result := pvValidationValid
+	invalid, err := validatePV(v.cloud, pv)
+	if err != nil {
+		return err
+	}
+	if invalid != "" {
+		result = invalid
+		v.recorder.Event(pv, corev1.EventTypeWarning, "ValidationFailed", invalid)
+	} else {
+		v.recorder.Event(pv, corev1.EventTypeNormal, "Validated", fmt.Sprintf("Cinder volume %s is usable", pv.Spec.CSI.VolumeHandle))
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{PVValidationAnnotation: result},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = v.kubeClient.CoreV1().PersistentVolumes().Patch(context.TODO(), pv.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// needsValidation returns true for PVs of this driver that have not been
+// provisioned by it and have not been validated yet.
+func needsValidation(pv *corev1.PersistentVolume) bool {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != driverName {
+		return false
+	}
+	if pv.Annotations["pv.kubernetes.io/provisioned-by"] == driverName {
+		return false
+	}
+	_, validated := pv.Annotations[PVValidationAnnotation]
+	return !validated
+}
+
+// validatePV checks the Cinder volume referenced by a PV. It returns a
+// message describing why the PV is invalid, or an empty string if it is
+// valid. An error is only returned if the validation could not be done.
+func validatePV(cloud openstack.IOpenStack, pv *corev1.PersistentVolume) (string, error) {
+	volumeID := pv.Spec.CSI.VolumeHandle
+	if !volumeHandleRegexp.MatchString(volumeID) {
+		return fmt.Sprintf("volumeHandle %q is not a valid Cinder volume ID", volumeID), nil
+	}
+
+	vol, err := cloud.GetVolume(volumeID)
+	if err != nil {
+		if cpoerrors.IsNotFound(err) {
+			return fmt.Sprintf("Cinder volume %s does not exist", volumeID), nil
+		}
+		return "", err
+	}
+
+	if cloud.GetBlockStorageOpts().IgnoreVolumeAZ {
+		return "", nil
+	}
+
+	// The PV must be usable from the availability zone of the volume
+	zones := pvTopologyZones(pv)
+	if len(zones) == 0 {
+		return "", nil
+	}
+	for _, zone := range zones {
+		if zone == vol.AvailabilityZone {
+			return "", nil
+		}
+	}
+	return fmt.Sprintf("Cinder volume %s is in availability zone %s, but the PV requires nodes in %v", volumeID, vol.AvailabilityZone, zones), nil
+}
+
+// pvTopologyZones returns the zones the PV node affinity allows for this driver.
+func pvTopologyZones(pv *corev1.PersistentVolume) []string {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nil
+	}
+
+	var zones []string
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == topologyKey && expr.Operator == corev1.NodeSelectorOpIn {
+				zones = append(zones, expr.Values...)
+			}
+		}
+	}
+	return zones
+}
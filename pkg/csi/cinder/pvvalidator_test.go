@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func fakePV(volumeHandle string, zones ...string) *corev1.PersistentVolume {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: FakePVName},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       driverName,
+					VolumeHandle: volumeHandle,
+				},
+			},
+		},
+	}
+	if len(zones) > 0 {
+		pv.Spec.NodeAffinity = &corev1.VolumeNodeAffinity{
+			Required: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{Key: topologyKey, Operator: corev1.NodeSelectorOpIn, Values: zones},
+						},
+					},
+				},
+			},
+		}
+	}
+	return pv
+}
+
+func TestValidatePV(t *testing.T) {
+	volumeID := "261a8b81-3660-43e5-bab8-6470b65ee4e9"
+
+	tests := []struct {
+		name    string
+		pv      *corev1.PersistentVolume
+		invalid bool
+	}{
+		{
+			name: "valid without node affinity",
+			pv:   fakePV(volumeID),
+		},
+		{
+			name: "valid with matching zone",
+			pv:   fakePV(volumeID, "zone1", FakeAvailability),
+		},
+		{
+			name:    "invalid volume handle",
+			pv:      fakePV(FakeVolID),
+			invalid: true,
+		},
+		{
+			name:    "zone mismatch",
+			pv:      fakePV(volumeID, "zone1"),
+			invalid: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := validatePV(omock, test.pv)
+			assert.NoError(t, err)
+			assert.Equal(t, test.invalid, msg != "", msg)
+		})
+	}
+}
+
+func TestNeedsValidation(t *testing.T) {
+	pv := fakePV(FakeVolID)
+	assert.True(t, needsValidation(pv))
+
+	pv.Annotations = map[string]string{"pv.kubernetes.io/provisioned-by": driverName}
+	assert.False(t, needsValidation(pv))
+
+	pv.Annotations = map[string]string{PVValidationAnnotation: pvValidationValid}
+	assert.False(t, needsValidation(pv))
+
+	pv = fakePV(FakeVolID)
+	pv.Spec.CSI.Driver = "other.csi.k8s.io"
+	assert.False(t, needsValidation(pv))
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"container/list"
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+const (
+	volumeCacheNamePrefix      = "cinder-csi-cache-"
+	volumeCacheSnapshotKey     = "cinder.csi.openstack.org/cache-snapshot"
+	volumeCacheTypeKey         = "cinder.csi.openstack.org/cache-type"
+	volumeCacheAvailabilityKey = "cinder.csi.openstack.org/cache-availability"
+
+	// maxVolumeCacheCandidates bounds the number of snapshots whose uses are
+	// counted before they get a cached volume.
+	maxVolumeCacheCandidates = 1000
+	volumeCacheListLimit     = 1000
+)
+
+var (
+	volumeCacheVolumes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name: "cinder_csi_volume_cache_volumes",
+			Help: "Number of Cinder volumes cached to clone the volumes created from a snapshot",
+		})
+
+	registerVolumeCacheMetrics sync.Once
+)
+
+// volumeCacheKey identifies the volumes a cached volume can be cloned into.
+// Cinder clones a volume into the volume type and availability zone of the
+// source volume, so they are part of the key.
+type volumeCacheKey struct {
+	snapshotID   string
+	volType      string
+	availability string
+}
+
+// cachedVolume is a volume created from a snapshot and kept to be cloned
+type cachedVolume struct {
+	key      volumeCacheKey
+	volumeID string
+	size     int
+	// Position in the LRU list, nil while the volume is being created
+	elem *list.Element
+}
+
+// volumeCache keeps volumes created from the snapshots frequently used as
+// volume sources, e.g. the snapshots of golden images, and clones them
+// instead of creating the volumes from the snapshots, which is faster on
+// many Cinder backends. A snapshot gets a cached volume once it was used
+// minUses times, and the least recently used cached volumes are deleted
+// when there are more than maxVolumes, or more than maxGB GiB of them.
+//
+// The cached volumes are Cinder volumes of the plugin project with the
+// metadata of their source and of the cluster, so they are found again after
+// a restart. Only the cached volumes of the cluster are loaded and deleted,
+// the ones of the other clusters of the project are left alone.
+type volumeCache struct {
+	cloud      openstack.IOpenStack
+	cluster    string
+	maxVolumes int
+	maxGB      int
+	minUses    int
+
+	mu      sync.Mutex
+	volumes map[volumeCacheKey]*cachedVolume
+	uses    map[volumeCacheKey]int
+	// Cached volumes ready to be cloned, most recently used first
+	lru    *list.List
+	sizeGB int
+}
+
+// newVolumeCache returns a cache of at most maxVolumes volumes of the cluster,
+// or nil if maxVolumes is not positive. A maxGB which is not positive does not
+// limit the size of the cache.
+func newVolumeCache(cloud openstack.IOpenStack, cluster string, maxVolumes, maxGB, minUses int) *volumeCache {
+	if maxVolumes <= 0 {
+		return nil
+	}
+	registerVolumeCacheMetrics.Do(func() {
+		legacyregistry.MustRegister(volumeCacheVolumes)
+	})
+	if minUses < 1 {
+		minUses = 1
+	}
+
+	return &volumeCache{
+		cloud:      cloud,
+		cluster:    cluster,
+		maxVolumes: maxVolumes,
+		maxGB:      maxGB,
+		minUses:    minUses,
+		volumes:    make(map[volumeCacheKey]*cachedVolume),
+		uses:       make(map[volumeCacheKey]int),
+		lru:        list.New(),
+	}
+}
+
+// load adds the volumes cached for the cluster by a previous run of the
+// plugin to the cache.
+func (c *volumeCache) load() error {
+	var token string
+	for {
+		vols, next, err := c.cloud.ListVolumes(volumeCacheListLimit, token)
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		for _, vol := range vols {
+			snapshotID := vol.Metadata[volumeCacheSnapshotKey]
+			if snapshotID == "" || vol.Status != "available" || vol.Metadata[cinderCSIClusterIDKey] != c.cluster {
+				continue
+			}
+			key := volumeCacheKey{
+				snapshotID:   snapshotID,
+				volType:      vol.Metadata[volumeCacheTypeKey],
+				availability: vol.Metadata[volumeCacheAvailabilityKey],
+			}
+			if _, ok := c.volumes[key]; ok {
+				continue
+			}
+			c.addLocked(&cachedVolume{key: key, volumeID: vol.ID, size: vol.Size})
+		}
+		evicted := c.evictLocked()
+		c.mu.Unlock()
+		c.deleteVolumes(evicted)
+
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// lookup returns the ID of the cached volume to clone instead of creating a
+// volume of size GiB from the snapshot, or an empty string if there is none
+// yet. The cached volume is created in the background once the snapshot was
+// used minUses times.
+func (c *volumeCache) lookup(snapshotID, volType, availability string, size int) string {
+	key := volumeCacheKey{snapshotID: snapshotID, volType: volType, availability: availability}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if v, ok := c.volumes[key]; ok {
+		if v.elem == nil {
+			return ""
+		}
+		c.lru.MoveToFront(v.elem)
+		return v.volumeID
+	}
+
+	if len(c.uses) >= maxVolumeCacheCandidates {
+		c.uses = make(map[volumeCacheKey]int)
+	}
+	c.uses[key]++
+	if c.uses[key] < c.minUses {
+		return ""
+	}
+	delete(c.uses, key)
+
+	v := &cachedVolume{key: key, size: size}
+	c.volumes[key] = v
+	go c.fill(v)
+	return ""
+}
+
+// fill creates the cached volume from its snapshot.
+func (c *volumeCache) fill(v *cachedVolume) {
+	properties := map[string]string{
+		cinderCSIClusterIDKey:      c.cluster,
+		volumeCacheSnapshotKey:     v.key.snapshotID,
+		volumeCacheTypeKey:         v.key.volType,
+		volumeCacheAvailabilityKey: v.key.availability,
+	}
+
+	vol, err := c.cloud.CreateVolume(volumeCacheNamePrefix+v.key.snapshotID, v.size, v.key.volType, v.key.availability, v.key.snapshotID, "", &properties, nil)
+	if err == nil {
+		err = c.cloud.WaitVolumeTargetStatus(vol.ID, []string{"available"})
+	}
+
+	c.mu.Lock()
+	var evicted []*cachedVolume
+	orphan := vol != nil
+	switch {
+	case err != nil:
+		klog.Warningf("Failed to create the cached volume of snapshot %s: %v", v.key.snapshotID, err)
+		c.removeLocked(v)
+	case c.volumes[v.key] != v:
+		// The snapshot was evicted while the volume was created
+		klog.V(4).Infof("Snapshot %s was evicted from the volume cache", v.key.snapshotID)
+	default:
+		orphan = false
+		v.volumeID = vol.ID
+		c.addLocked(v)
+		evicted = c.evictLocked()
+		klog.V(4).Infof("Volume %s of snapshot %s added to the volume cache", vol.ID, v.key.snapshotID)
+	}
+	c.mu.Unlock()
+
+	if orphan {
+		evicted = append(evicted, &cachedVolume{key: v.key, volumeID: vol.ID})
+	}
+	c.deleteVolumes(evicted)
+}
+
+// forget removes a cached volume which cannot be cloned, e.g. because it
+// was deleted outside of the plugin.
+func (c *volumeCache) forget(volumeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, v := range c.volumes {
+		if v.volumeID == volumeID {
+			c.removeLocked(v)
+			return
+		}
+	}
+}
+
+// evictSnapshot deletes the cached volumes of the snapshot, which cannot be
+// deleted while volumes created from it exist on some Cinder backends.
+func (c *volumeCache) evictSnapshot(snapshotID string) error {
+	c.mu.Lock()
+	var evicted []*cachedVolume
+	for key, v := range c.volumes {
+		if key.snapshotID != snapshotID {
+			continue
+		}
+		c.removeLocked(v)
+		if v.volumeID != "" {
+			evicted = append(evicted, v)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, v := range evicted {
+		if err := c.cloud.DeleteVolume(v.volumeID); err != nil && !cpoerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *volumeCache) addLocked(v *cachedVolume) {
+	c.volumes[v.key] = v
+	v.elem = c.lru.PushFront(v)
+	c.sizeGB += v.size
+	volumeCacheVolumes.Set(float64(c.lru.Len()))
+}
+
+func (c *volumeCache) removeLocked(v *cachedVolume) {
+	if c.volumes[v.key] == v {
+		delete(c.volumes, v.key)
+	}
+	if v.elem != nil {
+		c.lru.Remove(v.elem)
+		v.elem = nil
+		c.sizeGB -= v.size
+		volumeCacheVolumes.Set(float64(c.lru.Len()))
+	}
+}
+
+// evictLocked removes the least recently used volumes over the limits of the
+// cache, and returns them to be deleted.
+func (c *volumeCache) evictLocked() []*cachedVolume {
+	var evicted []*cachedVolume
+	for c.lru.Len() > c.maxVolumes || (c.maxGB > 0 && c.sizeGB > c.maxGB && c.lru.Len() > 0) {
+		v := c.lru.Back().Value.(*cachedVolume)
+		c.removeLocked(v)
+		evicted = append(evicted, v)
+	}
+	return evicted
+}
+
+func (c *volumeCache) deleteVolumes(evicted []*cachedVolume) {
+	for _, v := range evicted {
+		klog.V(4).Infof("Deleting volume %s of snapshot %s from the volume cache", v.volumeID, v.key.snapshotID)
+		if err := c.cloud.DeleteVolume(v.volumeID); err != nil && !cpoerrors.IsNotFound(err) {
+			klog.Warningf("Failed to delete the cached volume %s: %v", v.volumeID, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"k8s.io/cloud-provider-openstack/pkg/csi/cinder/openstack"
+)
+
+func TestVolumeCache(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newVolumeCache(osmock, "cluster-a", 0, 0, 2))
+
+	cloud := new(openstack.OpenStackMock)
+	c := newVolumeCache(cloud, "cluster-a", 2, 25, 3)
+
+	// The volumes cached for the cluster by a previous run are loaded, the
+	// ones of another cluster are neither loaded nor evicted
+	cloud.On("ListVolumes", volumeCacheListLimit, "").Return([]volumes.Volume{
+		{ID: "cached-a", Size: 10, Status: "available", Metadata: map[string]string{cinderCSIClusterIDKey: "cluster-a", volumeCacheSnapshotKey: "snap-a", volumeCacheTypeKey: "ssd"}},
+		{ID: "cached-other", Size: 10, Status: "available", Metadata: map[string]string{cinderCSIClusterIDKey: "cluster-b", volumeCacheSnapshotKey: "snap-c", volumeCacheTypeKey: "ssd"}},
+		{ID: "other", Size: 10, Status: "available"},
+	}, "", nil).Once()
+	assert.NoError(c.load())
+	assert.Equal("cached-a", c.lookup("snap-a", "ssd", "", 10))
+	assert.Equal(1, c.lru.Len())
+
+	// Another volume type does not use the cached volume, and the snapshot is
+	// not cached before it's used minUses times
+	assert.Equal("", c.lookup("snap-a", "hdd", "", 10))
+	assert.Equal("", c.lookup("snap-a", "hdd", "", 10))
+	assert.Equal(2, c.uses[volumeCacheKey{snapshotID: "snap-a", volType: "hdd"}])
+
+	// A new cached volume evicts the least recently used one over the size limit
+	properties := map[string]string{cinderCSIClusterIDKey: "cluster-a", volumeCacheSnapshotKey: "snap-b", volumeCacheTypeKey: "ssd", volumeCacheAvailabilityKey: "nova"}
+	cloud.On("CreateVolume", volumeCacheNamePrefix+"snap-b", 20, "ssd", "nova", "snap-b", "", &properties, (*schedulerhints.SchedulerHints)(nil)).Return(&volumes.Volume{ID: "cached-b"}, nil).Once()
+	cloud.On("WaitVolumeTargetStatus", "cached-b", mock.Anything).Return(nil).Once()
+	cloud.On("DeleteVolume", "cached-a").Return(nil).Once()
+	key := volumeCacheKey{snapshotID: "snap-b", volType: "ssd", availability: "nova"}
+	v := &cachedVolume{key: key, size: 20}
+	c.volumes[key] = v
+	c.fill(v)
+	assert.Equal("cached-b", c.lookup("snap-b", "ssd", "nova", 20))
+	assert.Equal("", c.lookup("snap-a", "ssd", "", 10))
+	assert.Equal(20, c.sizeGB)
+
+	// The cached volumes of a deleted snapshot are deleted
+	cloud.On("DeleteVolume", "cached-b").Return(nil).Once()
+	assert.NoError(c.evictSnapshot("snap-b"))
+	assert.Equal(0, c.lru.Len())
+	assert.Equal(0, c.sizeGB)
+
+	cloud.AssertExpectations(t)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/metrics"
+	"k8s.io/klog/v2"
+)
+
+// sectorSize is the size of the sectors counted by the block layer
+// statistics, whatever the sector size of the device.
+const sectorSize = 512
+
+var (
+	volumeIOLabels = []string{"volume_id", "persistentvolume"}
+
+	volumeReadBytesDesc = metrics.NewDesc("cinder_csi_volume_read_bytes_total",
+		"Number of bytes read from the volume", volumeIOLabels, nil, metrics.ALPHA, "")
+	volumeWriteBytesDesc = metrics.NewDesc("cinder_csi_volume_write_bytes_total",
+		"Number of bytes written to the volume", volumeIOLabels, nil, metrics.ALPHA, "")
+	volumeReadOpsDesc = metrics.NewDesc("cinder_csi_volume_read_ops_total",
+		"Number of read operations completed on the volume", volumeIOLabels, nil, metrics.ALPHA, "")
+	volumeWriteOpsDesc = metrics.NewDesc("cinder_csi_volume_write_ops_total",
+		"Number of write operations completed on the volume", volumeIOLabels, nil, metrics.ALPHA, "")
+	volumeReadTimeDesc = metrics.NewDesc("cinder_csi_volume_read_time_seconds_total",
+		"Time spent on the read operations of the volume", volumeIOLabels, nil, metrics.ALPHA, "")
+	volumeWriteTimeDesc = metrics.NewDesc("cinder_csi_volume_write_time_seconds_total",
+		"Time spent on the write operations of the volume", volumeIOLabels, nil, metrics.ALPHA, "")
+)
+
+// blockStat are the statistics of a block device, as read from its stat
+// file in sysfs.
+type blockStat struct {
+	readOps      uint64
+	readSectors  uint64
+	readTicks    uint64 // in milliseconds
+	writeOps     uint64
+	writeSectors uint64
+	writeTicks   uint64 // in milliseconds
+}
+
+// VolumeIOCollector exports the IO statistics of the block devices of the
+// volumes staged on the node, labeled with the volume ID and the name of
+// the PV of the volume. The latency of the operations is the rate of their
+// time divided by the rate of their number.
+type VolumeIOCollector struct {
+	metrics.BaseStableCollector
+
+	// pvs finds the PVs of the volumes, may be nil
+	pvs         *pvIndex
+	sysBlockDir string
+
+	mu sync.Mutex
+	// devices are the names of the block devices of the volumes
+	devices map[string]string
+}
+
+// NewVolumeIOCollector creates a VolumeIOCollector finding the PVs of the
+// volumes with the given kubeconfig, or the in-cluster config if kubeconfig
+// is empty.
+func NewVolumeIOCollector(kubeconfig string) (*VolumeIOCollector, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes client config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	return newVolumeIOCollector(newPVIndex(kubeClient, wait.NeverStop), "/sys/class/block"), nil
+}
+
+func newVolumeIOCollector(pvs *pvIndex, sysBlockDir string) *VolumeIOCollector {
+	return &VolumeIOCollector{
+		pvs:         pvs,
+		sysBlockDir: sysBlockDir,
+		devices:     make(map[string]string),
+	}
+}
+
+// tracked reports whether the statistics of the volume are collected.
+func (c *VolumeIOCollector) tracked(volumeID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.devices[volumeID]
+	return ok
+}
+
+// track collects the statistics of the volume attached as devicePath. The PV
+// of the volume is only looked up when the statistics are collected, so
+// staging the volume doesn't wait for it.
+func (c *VolumeIOCollector) track(volumeID, devicePath string) {
+	if c == nil {
+		return
+	}
+
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
+	device := filepath.Base(devicePath)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices[volumeID] = device
+}
+
+// pvName returns the name of the PV of the volume, empty if it's not found.
+func (c *VolumeIOCollector) pvName(volumeID string) string {
+	if c.pvs == nil {
+		return ""
+	}
+
+	pv, err := c.pvs.find(volumeID)
+	if err != nil {
+		klog.V(4).Infof("Failed to find the PV of volume %s, its IO statistics are not labeled with the PV: %v", volumeID, err)
+		return ""
+	}
+	if pv == nil {
+		return ""
+	}
+	return pv.Name
+}
+
+// untrack stops collecting the statistics of the volume, e.g. when it's
+// unstaged from the node.
+func (c *VolumeIOCollector) untrack(volumeID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devices, volumeID)
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface.
+func (c *VolumeIOCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- volumeReadBytesDesc
+	ch <- volumeWriteBytesDesc
+	ch <- volumeReadOpsDesc
+	ch <- volumeWriteOpsDesc
+	ch <- volumeReadTimeDesc
+	ch <- volumeWriteTimeDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface.
+func (c *VolumeIOCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.mu.Lock()
+	devices := make(map[string]string, len(c.devices))
+	for volumeID, device := range c.devices {
+		devices[volumeID] = device
+	}
+	c.mu.Unlock()
+
+	for volumeID, device := range devices {
+		stat, err := readBlockStat(filepath.Join(c.sysBlockDir, device, "stat"))
+		if err != nil {
+			klog.V(4).Infof("Failed to read the IO statistics of volume %s: %v", volumeID, err)
+			continue
+		}
+
+		labels := []string{volumeID, c.pvName(volumeID)}
+		ch <- metrics.NewLazyConstMetric(volumeReadBytesDesc, metrics.CounterValue, float64(stat.readSectors*sectorSize), labels...)
+		ch <- metrics.NewLazyConstMetric(volumeWriteBytesDesc, metrics.CounterValue, float64(stat.writeSectors*sectorSize), labels...)
+		ch <- metrics.NewLazyConstMetric(volumeReadOpsDesc, metrics.CounterValue, float64(stat.readOps), labels...)
+		ch <- metrics.NewLazyConstMetric(volumeWriteOpsDesc, metrics.CounterValue, float64(stat.writeOps), labels...)
+		ch <- metrics.NewLazyConstMetric(volumeReadTimeDesc, metrics.CounterValue, float64(stat.readTicks)/1000, labels...)
+		ch <- metrics.NewLazyConstMetric(volumeWriteTimeDesc, metrics.CounterValue, float64(stat.writeTicks)/1000, labels...)
+	}
+}
+
+// readBlockStat reads the stat file of a block device, whose fields are
+// described in https://www.kernel.org/doc/Documentation/block/stat.txt
+func readBlockStat(path string) (*blockStat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("unexpected format of %s: %q", path, string(data))
+	}
+	values := make([]uint64, 8)
+	for i := range values {
+		values[i], err = strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected format of %s: %v", path, err)
+		}
+	}
+
+	return &blockStat{
+		readOps:      values[0],
+		readSectors:  values[2],
+		readTicks:    values[3],
+		writeOps:     values[4],
+		writeSectors: values[6],
+		writeTicks:   values[7],
+	}, nil
+}
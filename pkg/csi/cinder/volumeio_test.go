@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/component-base/metrics"
+)
+
+func TestVolumeIOCollector(t *testing.T) {
+	sysBlockDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(sysBlockDir, "vdb"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stat := "    1200      30    96000     450     800      10    64000     900        0     700    1350\n"
+	if err := os.WriteFile(filepath.Join(sysBlockDir, "vdb", "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := readBlockStat(filepath.Join(sysBlockDir, "vdb", "stat"))
+	assert.NoError(t, err)
+	assert.Equal(t, &blockStat{readOps: 1200, readSectors: 96000, readTicks: 450, writeOps: 800, writeSectors: 64000, writeTicks: 900}, s)
+
+	c := newVolumeIOCollector(newSyncedPVIndex(t, fakePV(FakeVolID)), sysBlockDir)
+	// The descriptors of the metrics are created by the registration
+	metrics.NewKubeRegistry().CustomMustRegister(c)
+	collect := func() int {
+		ch := make(chan metrics.Metric, 100)
+		c.CollectWithStability(ch)
+		close(ch)
+		return len(ch)
+	}
+
+	assert.False(t, c.tracked(FakeVolID))
+	c.track(FakeVolID, "/dev/vdb")
+	assert.True(t, c.tracked(FakeVolID))
+	assert.Equal(t, "vdb", c.devices[FakeVolID])
+	assert.Equal(t, fakePV(FakeVolID).Name, c.pvName(FakeVolID))
+	assert.Equal(t, 6, collect())
+
+	// The volumes whose device has no statistics are skipped
+	c.track("other", "/dev/vdc")
+	assert.Equal(t, "", c.pvName("other"))
+	assert.Equal(t, 6, collect())
+
+	c.untrack(FakeVolID)
+	c.untrack("other")
+	assert.Equal(t, 0, collect())
+
+	// A nil collector is disabled
+	var disabled *VolumeIOCollector
+	disabled.track(FakeVolID, "/dev/vdb")
+	disabled.untrack(FakeVolID)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+)
+
+// volumeUsageLevel is the highest threshold the usage of a volume crossed
+type volumeUsageLevel int
+
+const (
+	volumeUsageNormal volumeUsageLevel = iota
+	volumeUsageWarning
+	volumeUsageCritical
+)
+
+func (l volumeUsageLevel) String() string {
+	switch l {
+	case volumeUsageWarning:
+		return "warning"
+	case volumeUsageCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+var (
+	volumeUsageVolumes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "cinder_csi_volume_usage_threshold_volumes",
+			Help: "Number of volumes of the node whose filesystem usage is over the warning or critical threshold",
+		}, []string{"level"})
+
+	registerVolumeUsageMetrics sync.Once
+)
+
+// VolumeUsageMonitor records an event on the PVC of a filesystem volume when
+// its usage, in bytes or inodes, crosses the warning or critical threshold,
+// so the owners of the PVC are warned before the filesystem is full. The
+// usage is computed by NodeGetVolumeStats, which the kubelet calls
+// periodically for each published volume.
+type VolumeUsageMonitor struct {
+	// pvs finds the PVCs of the volumes
+	pvs      *pvIndex
+	recorder record.EventRecorder
+	// Thresholds in percent of the capacity, a threshold is disabled if not positive
+	warning  int
+	critical int
+
+	mu     sync.Mutex
+	levels map[string]volumeUsageLevel
+}
+
+// NewVolumeUsageMonitor creates a VolumeUsageMonitor using the given
+// kubeconfig, or the in-cluster config if kubeconfig is empty. It returns nil
+// if both thresholds are disabled.
+func NewVolumeUsageMonitor(kubeconfig string, warning, critical int) (*VolumeUsageMonitor, error) {
+	if warning <= 0 && critical <= 0 {
+		return nil, nil
+	}
+	if warning > 100 || critical > 100 {
+		return nil, fmt.Errorf("volume usage thresholds must be at most 100 percent")
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kubernetes client config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{
+		Interface: kubeClient.CoreV1().Events(""),
+	})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: driverName + "-volume-usage"})
+
+	return newVolumeUsageMonitor(newPVIndex(kubeClient, wait.NeverStop), recorder, warning, critical), nil
+}
+
+func newVolumeUsageMonitor(pvs *pvIndex, recorder record.EventRecorder, warning, critical int) *VolumeUsageMonitor {
+	registerVolumeUsageMetrics.Do(func() {
+		legacyregistry.MustRegister(volumeUsageVolumes)
+	})
+
+	return &VolumeUsageMonitor{
+		pvs:      pvs,
+		recorder: recorder,
+		warning:  warning,
+		critical: critical,
+		levels:   make(map[string]volumeUsageLevel),
+	}
+}
+
+// usagePercent returns the usage of the filesystem in percent, the highest of
+// the bytes and the inodes usage.
+func usagePercent(stats *mount.DeviceStats) float64 {
+	var percent float64
+	if stats.TotalBytes > 0 {
+		percent = float64(stats.UsedBytes) * 100 / float64(stats.TotalBytes)
+	}
+	if stats.TotalInodes > 0 {
+		if p := float64(stats.UsedInodes) * 100 / float64(stats.TotalInodes); p > percent {
+			percent = p
+		}
+	}
+	return percent
+}
+
+func (m *VolumeUsageMonitor) level(percent float64) volumeUsageLevel {
+	switch {
+	case m.critical > 0 && percent >= float64(m.critical):
+		return volumeUsageCritical
+	case m.warning > 0 && percent >= float64(m.warning):
+		return volumeUsageWarning
+	default:
+		return volumeUsageNormal
+	}
+}
+
+// observe records the usage of the filesystem of the volume, and an event on
+// its PVC if the usage crossed a threshold since the last observation.
+func (m *VolumeUsageMonitor) observe(volumeID string, stats *mount.DeviceStats) {
+	if m == nil {
+		return
+	}
+
+	percent := usagePercent(stats)
+	level := m.level(percent)
+
+	m.mu.Lock()
+	prev, ok := m.levels[volumeID]
+	if ok && prev == level {
+		m.mu.Unlock()
+		return
+	}
+	m.levels[volumeID] = level
+	m.updateMetricsLocked()
+	m.mu.Unlock()
+
+	// A volume below the thresholds when first observed is not reported
+	if !ok && level == volumeUsageNormal {
+		return
+	}
+
+	claim, err := m.getClaim(volumeID)
+	if err != nil {
+		klog.Warningf("Failed to find the PVC of volume %s, its usage of %.0f%% is not reported: %v", volumeID, percent, err)
+		return
+	}
+	if claim == nil {
+		klog.V(4).Infof("Volume %s has no PVC, its usage of %.0f%% is not reported", volumeID, percent)
+		return
+	}
+
+	switch level {
+	case volumeUsageCritical:
+		m.recorder.Eventf(claim, corev1.EventTypeWarning, "VolumeUsageCritical", "Volume %s is %.0f%% full, over the critical threshold of %d%%", volumeID, percent, m.critical)
+	case volumeUsageWarning:
+		m.recorder.Eventf(claim, corev1.EventTypeWarning, "VolumeUsageWarning", "Volume %s is %.0f%% full, over the warning threshold of %d%%", volumeID, percent, m.warning)
+	default:
+		m.recorder.Eventf(claim, corev1.EventTypeNormal, "VolumeUsageNormal", "Volume %s is %.0f%% full, below the usage thresholds", volumeID, percent)
+	}
+}
+
+// forget stops monitoring the volume, e.g. when it's unstaged from the node.
+func (m *VolumeUsageMonitor) forget(volumeID string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.levels, volumeID)
+	m.updateMetricsLocked()
+}
+
+func (m *VolumeUsageMonitor) updateMetricsLocked() {
+	counts := make(map[volumeUsageLevel]int)
+	for _, level := range m.levels {
+		counts[level]++
+	}
+	for _, level := range []volumeUsageLevel{volumeUsageWarning, volumeUsageCritical} {
+		volumeUsageVolumes.WithLabelValues(level.String()).Set(float64(counts[level]))
+	}
+}
+
+// getClaim returns the PVC bound to the PV of the volume, nil if there is
+// none.
+func (m *VolumeUsageMonitor) getClaim(volumeID string) (*corev1.ObjectReference, error) {
+	pv, err := m.pvs.find(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if pv == nil || pv.Spec.ClaimRef == nil {
+		return nil, nil
+	}
+
+	claim := pv.Spec.ClaimRef.DeepCopy()
+	claim.Kind = "PersistentVolumeClaim"
+	claim.APIVersion = "v1"
+	return claim, nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/mount"
+)
+
+func TestVolumeUsageMonitor(t *testing.T) {
+	pv := fakePV(FakeVolID)
+	pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: "default", Name: "data"}
+	recorder := record.NewFakeRecorder(10)
+	m := newVolumeUsageMonitor(newSyncedPVIndex(t, pv), recorder, 80, 95)
+
+	usage := func(usedBytes, usedInodes int64) *mount.DeviceStats {
+		return &mount.DeviceStats{TotalBytes: 100, UsedBytes: usedBytes, TotalInodes: 100, UsedInodes: usedInodes}
+	}
+
+	steps := []struct {
+		stats *mount.DeviceStats
+		event string
+	}{
+		{stats: usage(50, 10)},
+		{stats: usage(85, 10), event: "Warning VolumeUsageWarning Volume " + FakeVolID + " is 85% full, over the warning threshold of 80%"},
+		{stats: usage(90, 10)},
+		// Inodes are exhausted before bytes
+		{stats: usage(90, 97), event: "Warning VolumeUsageCritical Volume " + FakeVolID + " is 97% full, over the critical threshold of 95%"},
+		{stats: usage(20, 10), event: "Normal VolumeUsageNormal Volume " + FakeVolID + " is 20% full, below the usage thresholds"},
+	}
+	for i, step := range steps {
+		m.observe(FakeVolID, step.stats)
+		select {
+		case event := <-recorder.Events:
+			assert.Equal(t, step.event, event, "step %d", i)
+		default:
+			assert.Empty(t, step.event, "step %d", i)
+		}
+	}
+
+	// The volume of a PV without claim is monitored without events
+	m.observe("other", usage(99, 0))
+	assert.Empty(t, recorder.Events)
+	assert.Equal(t, volumeUsageCritical, m.levels["other"])
+
+	m.forget(FakeVolID)
+	m.forget("other")
+	assert.Empty(t, m.levels)
+
+	// A nil monitor is disabled
+	var disabled *VolumeUsageMonitor
+	disabled.observe(FakeVolID, usage(99, 0))
+	disabled.forget(FakeVolID)
+}
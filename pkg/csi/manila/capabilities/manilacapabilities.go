@@ -19,6 +19,7 @@ package capabilities
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 )
@@ -32,11 +33,29 @@ const (
 	ManilaCapabilityNone ManilaCapability = iota
 	ManilaCapabilitySnapshot
 	ManilaCapabilityShareFromSnapshot
+	ManilaCapabilityProtocolNFS
+	ManilaCapabilityProtocolCEPHFS
+	ManilaCapabilityShareServers
 
 	extraSpecSnapshotSupport                = "snapshot_support"
 	extraSpecCreateShareFromSnapshotSupport = "create_share_from_snapshot_support"
+	extraSpecStorageProtocol                = "storage_protocol"
+	extraSpecDriverHandlesShareServers      = "driver_handles_share_servers"
 )
 
+// ProtocolCapability returns the capability of the share type to create
+// shares of the protocol.
+func ProtocolCapability(proto string) ManilaCapability {
+	switch strings.ToUpper(proto) {
+	case "NFS":
+		return ManilaCapabilityProtocolNFS
+	case "CEPHFS":
+		return ManilaCapabilityProtocolCEPHFS
+	default:
+		return ManilaCapabilityNone
+	}
+}
+
 func GetManilaCapabilities(shareType string, manilaClient manilaclient.Interface) (ManilaCapabilities, error) {
 	shareTypes, err := manilaClient.GetShareTypes()
 	if err != nil {
@@ -63,8 +82,27 @@ func readManilaCaps(extraSpecs map[string]interface{}) ManilaCapabilities {
 		return b
 	}
 
+	// A share type without the storage_protocol extra spec is assumed to
+	// support every protocol, the backend decides
+	supportsProtocol := func(proto string) bool {
+		ss, ok := extraSpecs[extraSpecStorageProtocol].(string)
+		if !ok || ss == "" {
+			return true
+		}
+		// e.g. NFS_CIFS
+		for _, p := range strings.Split(strings.ToUpper(ss), "_") {
+			if p == proto {
+				return true
+			}
+		}
+		return false
+	}
+
 	return ManilaCapabilities{
 		ManilaCapabilitySnapshot:          strToBool(extraSpecs[extraSpecSnapshotSupport]),
 		ManilaCapabilityShareFromSnapshot: strToBool(extraSpecs[extraSpecCreateShareFromSnapshotSupport]),
+		ManilaCapabilityProtocolNFS:       supportsProtocol("NFS"),
+		ManilaCapabilityProtocolCEPHFS:    supportsProtocol("CEPHFS"),
+		ManilaCapabilityShareServers:      strToBool(extraSpecs[extraSpecDriverHandlesShareServers]),
 	}
 }
@@ -36,6 +36,10 @@ import (
 
 const clusterMetadataKey = "manila.csi.openstack.org/cluster"
 
+// pvcMetadataKeys are the parameters passed by the external-provisioner
+// started with --extra-create-metadata, added to the metadata of the shares
+var pvcMetadataKeys = []string{"csi.storage.k8s.io/pvc/name", "csi.storage.k8s.io/pvc/namespace", "csi.storage.k8s.io/pv/name"}
+
 type controllerServer struct {
 	d *Driver
 }
@@ -92,10 +96,25 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume parameters: %v", err)
 	}
 
+	if err := validateFsType(req.GetVolumeCapabilities(), shareOpts.Protocol); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume capabilities: %v", err)
+	}
+
 	shareMetadata, err := prepareShareMetadata(shareOpts.AppendShareMetadata, cs.d.clusterID)
 	if err != nil {
 		return nil, err
 	}
+	shareMetadata = appendRequestMetadata(shareMetadata, params, pvcMetadataKeys)
+
+	if shareOpts.SoftDeleteRetention != "" {
+		if _, err := parseSoftDeleteRetention(shareOpts.SoftDeleteRetention); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid softDeleteRetention parameter: %v", err)
+		}
+		if shareMetadata == nil {
+			shareMetadata = make(map[string]string)
+		}
+		shareMetadata[softDeleteRetentionMetadataKey] = shareOpts.SoftDeleteRetention
+	}
 
 	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
 	if err != nil {
@@ -113,11 +132,31 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
 	}
 
+	cs.d.shareGC.track(osOpts)
+
 	shareTypeCaps, err := capabilities.GetManilaCapabilities(shareOpts.Type, manilaClient)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get Manila capabilities for share type %s: %v", shareOpts.Type, err)
 	}
 
+	if !shareTypeCaps[capabilities.ProtocolCapability(shareOpts.Protocol)] {
+		return nil, status.Errorf(codes.InvalidArgument, "share type %s does not support %s shares", shareOpts.Type, shareOpts.Protocol)
+	}
+
+	// The shares created from snapshots are on the share network of their source
+	if shareOpts.ShareNetworkID == "" && cs.d.shareNetworks != nil &&
+		req.GetVolumeContentSource() == nil && shareTypeCaps[capabilities.ManilaCapabilityShareServers] {
+		shareOpts.ShareNetworkID, err = cs.d.shareNetworks.getOrCreate(manilaClient)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get the share network of share type %s: %v", shareOpts.Type, err)
+		}
+	}
+
+	mountOptions, err := getShareTypeMountOptions(shareOpts.Type)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
 	requestedSize := req.GetCapacityRange().GetRequiredBytes()
 	if requestedSize == 0 {
 		// At least 1GiB
@@ -165,6 +204,10 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	volCtx := filterParametersForVolumeContext(params, options.NodeVolumeContextFields())
 	volCtx["shareID"] = share.ID
 	volCtx["shareAccessID"] = accessRight.ID
+	delete(volCtx, "mountOptions")
+	if mountOptions != "" {
+		volCtx["mountOptions"] = mountOptions
+	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
@@ -192,6 +235,16 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 		return nil, status.Errorf(codes.Unauthenticated, "failed to create Manila v2 client: %v", err)
 	}
 
+	cs.d.shareGC.track(osOpts)
+
+	softDeleted, err := softDeleteShare(req.GetVolumeId(), manilaClient)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to soft-delete volume %s: %v", req.GetVolumeId(), err)
+	}
+	if softDeleted {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
 	if err := deleteShare(req.GetVolumeId(), manilaClient); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to delete volume %s: %v", req.GetVolumeId(), err)
 	}
@@ -206,6 +259,16 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	// Configuration
 
+	params := make(map[string]string)
+	for k, v := range req.GetParameters() {
+		params[k] = v
+	}
+
+	snapOpts, err := options.NewControllerSnapshotContext(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid snapshot parameters: %v", err)
+	}
+
 	osOpts, err := options.NewOpenstackOptions(req.GetSecrets())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid OpenStack secrets: %v", err)
@@ -251,7 +314,12 @@ func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateS
 
 	// Retrieve an existing snapshot or create a new one
 
-	snapshot, err := getOrCreateSnapshot(req.GetName(), sourceShare.ID, manilaClient)
+	snapMetadata, err := prepareSnapshotMetadata(snapOpts.AppendSnapshotMetadata, cs.d.clusterID, params, sourceShare)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := getOrCreateSnapshot(req.GetName(), sourceShare.ID, snapOpts, snapMetadata, manilaClient)
 	if err != nil {
 		if err == wait.ErrWaitTimeout {
 			return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while waiting for snapshot %s of volume %s to become available", snapshot.ID, req.GetSourceVolumeId())
@@ -491,16 +559,40 @@ func prepareShareMetadata(appendShareMetadata, clusterID string) (map[string]str
 		return nil, status.Errorf(codes.InvalidArgument, "failed to parse appendShareMetadata field: %v", err)
 	}
 
-	if clusterID != "" {
-		if shareMetadata == nil {
-			shareMetadata = make(map[string]string)
-		}
-		if val, ok := shareMetadata[clusterMetadataKey]; ok && val != clusterID {
-			klog.Warningf("skip adding cluster ID %v to share metadata because appended metadata already defines it as %v", clusterID, val)
-		} else {
-			shareMetadata[clusterMetadataKey] = clusterID
+	return appendClusterMetadata(shareMetadata, clusterID, "share"), nil
+}
+
+// appendClusterMetadata adds the cluster ID to the metadata of a share or a
+// snapshot, unless the metadata appended by the user already defines it.
+func appendClusterMetadata(metadata map[string]string, clusterID, resource string) map[string]string {
+	if clusterID == "" {
+		return metadata
+	}
+
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	if val, ok := metadata[clusterMetadataKey]; ok && val != clusterID {
+		klog.Warningf("skip adding cluster ID %v to %s metadata because appended metadata already defines it as %v", clusterID, resource, val)
+	} else {
+		metadata[clusterMetadataKey] = clusterID
+	}
+
+	return metadata
+}
+
+// appendRequestMetadata copies the keys of the request parameters to the
+// metadata, e.g. the names of the PVC or the VolumeSnapshot passed by the
+// sidecars started with --extra-create-metadata.
+func appendRequestMetadata(metadata, params map[string]string, keys []string) map[string]string {
+	for _, key := range keys {
+		if val, ok := params[key]; ok {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[key] = val
 		}
 	}
 
-	return shareMetadata, nil
+	return metadata
 }
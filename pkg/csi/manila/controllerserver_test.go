@@ -16,6 +16,8 @@ package manila
 import (
 	"fmt"
 	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 )
 
 func TestPrepareShareMetadata(t *testing.T) {
@@ -81,3 +83,42 @@ func TestPrepareShareMetadata(t *testing.T) {
 		}
 	}
 }
+
+func TestPrepareSnapshotMetadata(t *testing.T) {
+	params := map[string]string{
+		"csi.storage.k8s.io/volumesnapshot/name":        "snap",
+		"csi.storage.k8s.io/volumesnapshot/namespace":   "default",
+		"csi.storage.k8s.io/volumesnapshotcontent/name": "snapcontent-1",
+		"force": "true",
+	}
+	sourceShare := &shares.Share{Metadata: map[string]string{
+		"csi.storage.k8s.io/pvc/name":      "data",
+		"csi.storage.k8s.io/pvc/namespace": "default",
+		"keyB":                             "valueB",
+	}}
+
+	result, err := prepareSnapshotMetadata("{\"keyA\": \"valueA\"}", "MyCluster", params, sourceShare)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"keyA":                                   "valueA",
+		clusterMetadataKey:                       "MyCluster",
+		"csi.storage.k8s.io/volumesnapshot/name": "snap",
+		"csi.storage.k8s.io/volumesnapshot/namespace":   "default",
+		"csi.storage.k8s.io/volumesnapshotcontent/name": "snapcontent-1",
+		"csi.storage.k8s.io/pvc/name":                   "data",
+		"csi.storage.k8s.io/pvc/namespace":              "default",
+	}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("returned an incorrect result: got %#v, expected %#v", result, expected)
+	}
+
+	if result, err := prepareSnapshotMetadata("", "", nil, &shares.Share{}); err != nil || result != nil {
+		t.Errorf("expected no metadata, got %#v: %v", result, err)
+	}
+
+	if _, err := prepareSnapshotMetadata("INVALID", "", nil, &shares.Share{}); err == nil {
+		t.Errorf("expected an error for invalid metadata")
+	}
+}
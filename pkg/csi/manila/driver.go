@@ -29,6 +29,8 @@ import (
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/csiclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
@@ -44,6 +46,19 @@ type DriverOpts struct {
 	ShareProto   string
 	ClusterID    string
 
+	// ShareGCPeriod is how often the soft-deleted shares are checked for
+	// deletion, zero disables their deletion.
+	ShareGCPeriod time.Duration
+
+	// KubeClient is used to read the provisioner secrets of the StorageClasses
+	// when collecting the soft-deleted shares, it may be nil.
+	KubeClient kubernetes.Interface
+
+	// AutoShareNetwork enables the discovery of the share networks of the
+	// share types with driver_handles_share_servers=true, found or created
+	// from the network of the instance of the controller plugin.
+	AutoShareNetwork bool
+
 	ServerCSIEndpoint string
 	FwdCSIEndpoint    string
 
@@ -77,6 +92,12 @@ type Driver struct {
 
 	manilaClientBuilder manilaclient.Builder
 	csiClientBuilder    csiclient.Builder
+
+	shareGC       *shareGC
+	shareGCPeriod time.Duration
+
+	// shareNetworks is nil unless AutoShareNetwork is set
+	shareNetworks *shareNetworkResolver
 }
 
 type nonBlockingGRPCServer struct {
@@ -122,6 +143,12 @@ func NewDriver(o *DriverOpts) (*Driver, error) {
 		manilaClientBuilder: o.ManilaClientBuilder,
 		csiClientBuilder:    o.CSIClientBuilder,
 		clusterID:           o.ClusterID,
+		shareGC:             newShareGC(o.ManilaClientBuilder, o.KubeClient, o.DriverName),
+		shareGCPeriod:       o.ShareGCPeriod,
+	}
+
+	if o.AutoShareNetwork {
+		d.shareNetworks = newShareNetworkResolver(o.ClusterID)
 	}
 
 	klog.Info("Driver: ", d.name)
@@ -189,6 +216,10 @@ func NewDriver(o *DriverOpts) (*Driver, error) {
 }
 
 func (d *Driver) Run() {
+	if d.shareGCPeriod > 0 {
+		go d.shareGC.run(d.shareGCPeriod, wait.NeverStop)
+	}
+
 	s := nonBlockingGRPCServer{}
 	s.start(d.serverEndpoint, d.ids, d.cs, d.ns)
 	s.wait()
@@ -72,29 +72,31 @@ func compareManilaVersionsLessThan(a, b string) bool {
 	return aMaj < bMaj || (aMaj == bMaj && aMin < bMin)
 }
 
-func validateManilaClient(c *gophercloud.ServiceClient) error {
+// validateManilaClient checks the microversion of the client is supported by
+// the server, and returns the highest microversion supported by the server.
+func validateManilaClient(c *gophercloud.ServiceClient) (string, error) {
 	serverVersion, err := apiversions.Get(c, "v2").Extract()
 	if err != nil {
-		return fmt.Errorf("failed to get Manila v2 API microversions: %v", err)
+		return "", fmt.Errorf("failed to get Manila v2 API microversions: %v", err)
 	}
 
 	if err = validateManilaMicroversion(serverVersion.MinVersion); err != nil {
-		return fmt.Errorf("server's minimum microversion is invalid: %v", err)
+		return "", fmt.Errorf("server's minimum microversion is invalid: %v", err)
 	}
 
 	if err = validateManilaMicroversion(serverVersion.Version); err != nil {
-		return fmt.Errorf("server's maximum microversion is invalid: %v", err)
+		return "", fmt.Errorf("server's maximum microversion is invalid: %v", err)
 	}
 
 	if compareManilaVersionsLessThan(c.Microversion, serverVersion.MinVersion) {
-		return fmt.Errorf("client's microversion %s is lower than server's minimum microversion %s", c.Microversion, serverVersion.MinVersion)
+		return "", fmt.Errorf("client's microversion %s is lower than server's minimum microversion %s", c.Microversion, serverVersion.MinVersion)
 	}
 
 	if compareManilaVersionsLessThan(serverVersion.Version, c.Microversion) {
-		return fmt.Errorf("client's microversion %s is higher than server's highest supported microversion %s", c.Microversion, serverVersion.Version)
+		return "", fmt.Errorf("client's microversion %s is higher than server's highest supported microversion %s", c.Microversion, serverVersion.Version)
 	}
 
-	return nil
+	return serverVersion.Version, nil
 }
 
 func New(o *client.AuthOpts, userAgent string, extraUserAgentData []string) (*Client, error) {
@@ -104,10 +106,12 @@ func New(o *client.AuthOpts, userAgent string, extraUserAgentData []string) (*Cl
 		return nil, fmt.Errorf("failed to authenticate: %v", err)
 	}
 
-	client, err := openstack.NewSharedFileSystemV2(provider, gophercloud.EndpointOpts{
+	endpointOpts := gophercloud.EndpointOpts{
 		Region:       o.Region,
 		Availability: o.EndpointType,
-	})
+	}
+
+	client, err := openstack.NewSharedFileSystemV2(provider, endpointOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Manila v2 client: %v", err)
 	}
@@ -115,11 +119,12 @@ func New(o *client.AuthOpts, userAgent string, extraUserAgentData []string) (*Cl
 	// Check client's and server's versions for compatibility
 
 	client.Microversion = minimumManilaVersion
-	if err = validateManilaClient(client); err != nil {
+	maxMicroversion, err := validateManilaClient(client)
+	if err != nil {
 		return nil, fmt.Errorf("Manila v2 client validation failed: %v", err)
 	}
 
-	return &Client{c: client}, nil
+	return &Client{c: client, maxMicroversion: maxMicroversion, provider: provider, endpointOpts: endpointOpts}, nil
 }
 
 func NewFromServiceClient(c *gophercloud.ServiceClient) *Client {
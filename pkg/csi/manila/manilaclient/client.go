@@ -25,10 +25,18 @@ import (
 	shares_utils "github.com/gophercloud/utils/openstack/sharedfilesystems/v2/shares"
 	sharetypes_utils "github.com/gophercloud/utils/openstack/sharedfilesystems/v2/sharetypes"
 	snapshots_utils "github.com/gophercloud/utils/openstack/sharedfilesystems/v2/snapshots"
+	"k8s.io/klog/v2"
 )
 
 type Client struct {
 	c *gophercloud.ServiceClient
+	// maxMicroversion is the highest microversion supported by the server,
+	// empty if unknown
+	maxMicroversion string
+	// provider and endpointOpts create the clients of the other services,
+	// provider is nil if the client was created from a service client
+	provider     *gophercloud.ProviderClient
+	endpointOpts gophercloud.EndpointOpts
 }
 
 func (c Client) GetShareByID(shareID string) (*shares.Share, error) {
@@ -52,6 +60,19 @@ func (c Client) DeleteShare(shareID string) error {
 	return shares.Delete(c.c, shareID).ExtractErr()
 }
 
+func (c Client) UpdateShare(shareID string, opts shares.UpdateOptsBuilder) (*shares.Share, error) {
+	return shares.Update(c.c, shareID, opts).Extract()
+}
+
+func (c Client) ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error) {
+	page, err := shares.ListDetail(c.c, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	return shares.ExtractShares(page)
+}
+
 func (c Client) ExtendShare(shareID string, opts shares.ExtendOptsBuilder) error {
 	return shares.Extend(c.c, shareID, opts).ExtractErr()
 }
@@ -86,7 +107,21 @@ func (c Client) GetSnapshotByName(snapName string) (*snapshots.Snapshot, error)
 }
 
 func (c Client) CreateSnapshot(opts snapshots.CreateOptsBuilder) (*snapshots.Snapshot, error) {
-	return snapshots.Create(c.c, opts).Extract()
+	sc := c.c
+	if o, ok := opts.(SnapshotCreateOpts); ok && len(o.Metadata) > 0 {
+		if c.maxMicroversion != "" && compareManilaVersionsLessThan(c.maxMicroversion, snapshotMetadataMicroversion) {
+			klog.Warningf("Manila microversion %s is required to set the metadata of snapshot %s, the server supports up to %s: the metadata is not set", snapshotMetadataMicroversion, o.Name, c.maxMicroversion)
+			o.Metadata = nil
+			opts = o
+		} else {
+			// The metadata is rejected by the minimum microversion
+			mc := *c.c
+			mc.Microversion = snapshotMetadataMicroversion
+			sc = &mc
+		}
+	}
+
+	return snapshots.Create(sc, opts).Extract()
 }
 
 func (c Client) DeleteSnapshot(snapID string) error {
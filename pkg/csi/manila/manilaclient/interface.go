@@ -17,7 +17,9 @@ limitations under the License.
 package manilaclient
 
 import (
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/messages"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharetypes"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
@@ -29,9 +31,12 @@ type Interface interface {
 	GetShareByName(shareName string) (*shares.Share, error)
 	CreateShare(opts shares.CreateOptsBuilder) (*shares.Share, error)
 	DeleteShare(shareID string) error
+	UpdateShare(shareID string, opts shares.UpdateOptsBuilder) (*shares.Share, error)
+	ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error)
 	ExtendShare(shareID string, opts shares.ExtendOptsBuilder) error
 
 	GetExportLocations(shareID string) ([]shares.ExportLocation, error)
+	GetShareReplicas(shareID string) ([]ShareReplica, error)
 
 	SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error)
 
@@ -48,6 +53,11 @@ type Interface interface {
 	GetShareTypeIDFromName(shareTypeName string) (string, error)
 
 	GetUserMessages(opts messages.ListOptsBuilder) ([]messages.Message, error)
+
+	GetShareNetworks(opts sharenetworks.ListOptsBuilder) ([]sharenetworks.ShareNetwork, error)
+	CreateShareNetwork(opts sharenetworks.CreateOptsBuilder) (*sharenetworks.ShareNetwork, error)
+
+	GetInstancePorts(instanceID string) ([]ports.Port, error)
 }
 
 type Builder interface {
@@ -0,0 +1,49 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"net/url"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// ShareReplica is a replica of a share. Its ID is the ID of the share
+// instance the export locations of the replica belong to.
+type ShareReplica struct {
+	ID               string `json:"id"`
+	ShareID          string `json:"share_id"`
+	AvailabilityZone string `json:"availability_zone"`
+	ReplicaState     string `json:"replica_state"`
+	Status           string `json:"status"`
+}
+
+// GetShareReplicas lists the replicas of a share. Gophercloud doesn't
+// support share replicas yet, and the API is experimental before
+// microversion 2.56.
+func (c Client) GetShareReplicas(shareID string) ([]ShareReplica, error) {
+	var r struct {
+		ShareReplicas []ShareReplica `json:"share_replicas"`
+	}
+
+	u := c.c.ServiceURL("share-replicas", "detail") + "?" + url.Values{"share_id": {shareID}}.Encode()
+	_, err := c.c.Get(u, &r, &gophercloud.RequestOpts{
+		MoreHeaders: map[string]string{"X-OpenStack-Manila-API-Experimental": "True"},
+	})
+
+	return r.ShareReplicas, err
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
+)
+
+func (c Client) GetShareNetworks(opts sharenetworks.ListOptsBuilder) ([]sharenetworks.ShareNetwork, error) {
+	allPages, err := sharenetworks.ListDetail(c.c, opts).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	return sharenetworks.ExtractShareNetworks(allPages)
+}
+
+func (c Client) CreateShareNetwork(opts sharenetworks.CreateOptsBuilder) (*sharenetworks.ShareNetwork, error) {
+	return sharenetworks.Create(c.c, opts).Extract()
+}
+
+// GetInstancePorts lists the Neutron ports of a Nova instance. The Neutron
+// client is only created when needed, the plugin doesn't otherwise require a
+// Neutron endpoint.
+func (c Client) GetInstancePorts(instanceID string) ([]ports.Port, error) {
+	if c.provider == nil {
+		return nil, fmt.Errorf("no OpenStack provider to create a Neutron client")
+	}
+
+	network, err := openstack.NewNetworkV2(c.provider, c.endpointOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Neutron v2 client: %v", err)
+	}
+
+	allPages, err := ports.List(network, ports.ListOpts{DeviceID: instanceID}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+
+	return ports.ExtractPorts(allPages)
+}
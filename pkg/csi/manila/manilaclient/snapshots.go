@@ -0,0 +1,54 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manilaclient
+
+import (
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
+)
+
+// snapshotMetadataMicroversion is the first microversion accepting the
+// metadata of snapshots.
+const snapshotMetadataMicroversion = "2.73"
+
+// SnapshotCreateOpts are the options to create a snapshot, with the ones
+// gophercloud doesn't support yet.
+type SnapshotCreateOpts struct {
+	snapshots.CreateOpts
+
+	// Force creates the snapshot even if the share is busy
+	Force bool `json:"force,omitempty"`
+	// Metadata of the snapshot, requires microversion 2.73
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ToSnapshotCreateMap assembles the request body of the snapshot creation.
+func (opts SnapshotCreateOpts) ToSnapshotCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToSnapshotCreateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := b["snapshot"].(map[string]interface{})
+	if opts.Force {
+		snapshot["force"] = true
+	}
+	if len(opts.Metadata) > 0 {
+		snapshot["metadata"] = opts.Metadata
+	}
+
+	return b, nil
+}
@@ -129,7 +129,13 @@ func (ns *nodeServer) buildVolumeContext(volID volumeID, shareOpts *options.Node
 
 	sa := getShareAdapter(ns.d.shareProto)
 
-	volumeContext, err = sa.BuildVolumeContext(&shareadapters.VolumeContextArgs{Locations: availableExportLocations, Options: shareOpts})
+	volumeContext, err = sa.BuildVolumeContext(&shareadapters.VolumeContextArgs{
+		Locations:    availableExportLocations,
+		Share:        share,
+		ManilaClient: manilaClient,
+		NodeAZ:       ns.d.nodeAZ,
+		Options:      shareOpts,
+	})
 	if err != nil {
 		return nil, nil, status.Errorf(codes.InvalidArgument, "failed to build volume context for volume %s: %v", volID, err)
 	}
@@ -216,6 +222,9 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	req.Secrets = secret
 	req.VolumeContext = volumeCtx
+	if mnt := req.GetVolumeCapability().GetMount(); mnt != nil {
+		mnt.MountFlags = mergeMountOptions(mnt.MountFlags, shareOpts.MountOptions)
+	}
 
 	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).PublishVolume(ctx, req)
 }
@@ -294,6 +303,9 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	req.Secrets = stageSecret
 	req.VolumeContext = volumeCtx
+	if mnt := req.GetVolumeCapability().GetMount(); mnt != nil {
+		mnt.MountFlags = mergeMountOptions(mnt.MountFlags, shareOpts.MountOptions)
+	}
 
 	return ns.d.csiClientBuilder.NewNodeServiceClient(csiConn).StageVolume(ctx, req)
 }
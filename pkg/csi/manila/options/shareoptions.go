@@ -26,6 +26,7 @@ type ControllerVolumeContext struct {
 	ShareNetworkID      string `name:"shareNetworkID" value:"optional"`
 	AvailabilityZone    string `name:"availability" value:"optional"`
 	AppendShareMetadata string `name:"appendShareMetadata" value:"optional"`
+	SoftDeleteRetention string `name:"softDeleteRetention" value:"optional"`
 
 	// Adapter options
 
@@ -40,6 +41,7 @@ type NodeVolumeContext struct {
 	ShareID       string `name:"shareID" value:"optionalIf:shareName=." precludes:"shareName"`
 	ShareName     string `name:"shareName" value:"optionalIf:shareID=." precludes:"shareID"`
 	ShareAccessID string `name:"shareAccessID"`
+	MountOptions  string `name:"mountOptions" value:"optional"`
 
 	// Adapter options
 
@@ -0,0 +1,40 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/validator"
+)
+
+type ControllerSnapshotContext struct {
+	Description            string `name:"description" value:"optional"`
+	AppendSnapshotMetadata string `name:"appendSnapshotMetadata" value:"optional"`
+	Force                  string `name:"force" value:"default:false" matches:"^true|false$"`
+}
+
+var (
+	controllerSnapshotCtxValidator = validator.New(&ControllerSnapshotContext{})
+)
+
+func NewControllerSnapshotContext(data map[string]string) (*ControllerSnapshotContext, error) {
+	opts := &ControllerSnapshotContext{}
+	if err := controllerSnapshotCtxValidator.Populate(data, opts); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
@@ -23,4 +23,14 @@ type NfsConfig struct {
 	// Expects a CIDR-formatted address. If prefix is not provided,
 	// /32 or /128 prefix is assumed for IPv4 and IPv6 respectively.
 	MatchExportLocationAddress string `json:"matchExportLocationAddress,omitempty"`
+
+	// When mounting an NFS share, select an export location whose path matches this regular expression.
+	MatchExportLocationPath string `json:"matchExportLocationPath,omitempty"`
+
+	// When mounting an NFS share, select only export locations marked as preferred by Manila.
+	PreferredExportLocationOnly bool `json:"preferredExportLocationOnly,omitempty"`
+
+	// When mounting an NFS share, select an export location of a share replica
+	// in the availability zone of the node. Requires the node availability zone to be set.
+	MatchNodeAvailabilityZone bool `json:"matchNodeAvailabilityZone,omitempty"`
 }
@@ -29,6 +29,15 @@ var (
 
 type RuntimeConfig struct {
 	Nfs *NfsConfig `json:"nfs,omitempty"`
+
+	// Configuration of the volumes by share type name or ID.
+	ShareTypes map[string]*ShareTypeConfig `json:"shareTypes,omitempty"`
+}
+
+type ShareTypeConfig struct {
+	// Mount options added to the mount options of the volumes of the share type,
+	// unless the mount options of the volume set them already.
+	MountOptions []string `json:"mountOptions,omitempty"`
 }
 
 func Get() (*RuntimeConfig, error) {
@@ -19,6 +19,7 @@ package shareadapters
 import (
 	"fmt"
 	"net"
+	"regexp"
 	"strings"
 
 	"github.com/gophercloud/gophercloud"
@@ -61,7 +62,7 @@ func (NFS) GetOrGrantAccess(args *GrantAccessArgs) (*shares.AccessRight, error)
 }
 
 func (NFS) BuildVolumeContext(args *VolumeContextArgs) (volumeContext map[string]string, err error) {
-	chosenExportLocationIdx, err := nfsChooseExportLocation(args.Locations)
+	chosenExportLocationIdx, err := nfsChooseExportLocation(args)
 	if err != nil {
 		return nil, fmt.Errorf("failed to choose an export location: %v", err)
 	}
@@ -83,47 +84,76 @@ func (NFS) BuildNodePublishSecret(args *SecretArgs) (secret map[string]string, e
 }
 
 // Tries to choose a suitable export location from the given list.
-// Returns index into `locs`.
+// Returns index into `args.Locations`.
 // Runtime config for NFS is probed first to see if it contains any export location filters.
 // Those are then used for selecting the location. If none are defined, the function
 // falls back to using manilautil.AnyExportLocation filter.
-func nfsChooseExportLocation(locs []shares.ExportLocation) (chosenExportLocationIdx int, err error) {
+func nfsChooseExportLocation(args *VolumeContextArgs) (chosenExportLocationIdx int, err error) {
 	var conf *runtimeconfig.RuntimeConfig
 
 	if conf, err = runtimeconfig.Get(); err != nil {
 		return -1, fmt.Errorf("failed to read runtime config file %s: %v", runtimeconfig.RuntimeConfigFilename, err)
 	}
 
-	if conf != nil {
-		if chosenExportLocationIdx, err = nfsMatchExportLocationFromConfig(locs, conf); err != nil {
-			return -1, err
-		}
+	if conf != nil && conf.Nfs != nil {
+		return nfsMatchExportLocationFromConfig(args, conf.Nfs)
+	}
+
+	return manilautil.FindExportLocation(args.Locations, manilautil.AnyExportLocation)
+}
 
-		if chosenExportLocationIdx != -1 {
-			return chosenExportLocationIdx, err
+// Selects an export location satisfying all the filters of the NFS config.
+// Without filters, any suitable location is selected.
+func nfsMatchExportLocationFromConfig(args *VolumeContextArgs, conf *runtimeconfig.NfsConfig) (idx int, err error) {
+	locs := args.Locations
+	var preds []manilautil.ExportLocationPredicate
+
+	if conf.PreferredExportLocationOnly {
+		preds = append(preds, func(i int) (bool, error) { return locs[i].Preferred, nil })
+	}
+
+	if conf.MatchExportLocationPath != "" {
+		re, err := regexp.Compile(conf.MatchExportLocationPath)
+		if err != nil {
+			return -1, fmt.Errorf("matchExportLocationPath filter '%s' is not a valid regular expression: %v", conf.MatchExportLocationPath, err)
 		}
+		preds = append(preds, func(i int) (bool, error) { return re.MatchString(locs[i].Path), nil })
+	}
 
-		// If we got here, it either means there's no NFS config,
-		// or it doesn't contain any configuration for export locations.
-		// Fall through and choose any suitable location.
+	if conf.MatchExportLocationAddress != "" {
+		pred, err := nfsExportLocationAddressPredicate(locs, conf.MatchExportLocationAddress)
+		if err != nil {
+			return -1, err
+		}
+		preds = append(preds, pred)
 	}
 
-	return manilautil.FindExportLocation(locs, manilautil.AnyExportLocation)
-}
+	if conf.MatchNodeAvailabilityZone {
+		pred, err := nfsExportLocationAZPredicate(args)
+		if err != nil {
+			return -1, err
+		}
+		preds = append(preds, pred)
+	}
 
-func nfsMatchExportLocationFromConfig(locs []shares.ExportLocation, conf *runtimeconfig.RuntimeConfig) (idx int, err error) {
-	if conf.Nfs != nil {
-		if conf.Nfs.MatchExportLocationAddress != "" {
-			return nfsMatchExportLocationAddress(locs, conf.Nfs.MatchExportLocationAddress)
+	idx, err = manilautil.FindExportLocation(locs, func(i int) (bool, error) {
+		for _, pred := range preds {
+			if match, err := pred(i); err != nil || !match {
+				return false, err
+			}
 		}
+		return true, nil
+	})
+
+	if err != nil {
+		return -1, fmt.Errorf("NFS export location filters: %v", err)
 	}
 
-	// No NFS export location filters specified
-	return -1, nil
+	return idx, nil
 }
 
-// Selects an export location with a matching address
-func nfsMatchExportLocationAddress(locs []shares.ExportLocation, matchAddress string) (idx int, err error) {
+// Matches export locations whose address is in the `matchAddress` network
+func nfsExportLocationAddressPredicate(locs []shares.ExportLocation, matchAddress string) (manilautil.ExportLocationPredicate, error) {
 	if ip := net.ParseIP(matchAddress); ip != nil {
 		// `matchAddress` is a valid IP, but does not have a prefix.
 		// This means we're looking for an exact match in export location addresses.
@@ -140,10 +170,10 @@ func nfsMatchExportLocationAddress(locs []shares.ExportLocation, matchAddress st
 
 	_, netIP, err := net.ParseCIDR(matchAddress)
 	if err != nil {
-		return -1, fmt.Errorf("matchExportLocationAddress filter '%s' is not a CIDR-formatted IP address", matchAddress)
+		return nil, fmt.Errorf("matchExportLocationAddress filter '%s' is not a CIDR-formatted IP address", matchAddress)
 	}
 
-	idx, err = manilautil.FindExportLocation(locs, func(i int) (bool, error) {
+	return func(i int) (bool, error) {
 		addr, _, err := splitExportLocationPath(locs[i].Path)
 		if err != nil {
 			return false, err
@@ -155,11 +185,33 @@ func nfsMatchExportLocationAddress(locs []shares.ExportLocation, matchAddress st
 		}
 
 		return netIP.Contains(hostIP), nil
-	})
+	}, nil
+}
 
-	if err != nil {
-		return -1, fmt.Errorf("matchExportLocationAddress filter '%s': %v", matchAddress, err)
+// Matches export locations of share instances in the availability zone of the node.
+// The share instances are the replicas of the share, or the share itself if it's not replicated.
+func nfsExportLocationAZPredicate(args *VolumeContextArgs) (manilautil.ExportLocationPredicate, error) {
+	if args.NodeAZ == "" {
+		return nil, fmt.Errorf("matchNodeAvailabilityZone filter requires the availability zone of the node")
 	}
 
-	return idx, nil
+	instanceAZs := make(map[string]string)
+	if args.Share.ReplicationType != "" {
+		replicas, err := args.ManilaClient.GetShareReplicas(args.Share.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list replicas of share %s: %v", args.Share.ID, err)
+		}
+		for _, r := range replicas {
+			instanceAZs[r.ID] = r.AvailabilityZone
+		}
+	}
+
+	locs := args.Locations
+	return func(i int) (bool, error) {
+		az, ok := instanceAZs[locs[i].ShareInstanceID]
+		if !ok {
+			az = args.Share.AvailabilityZone
+		}
+		return az == args.NodeAZ, nil
+	}, nil
 }
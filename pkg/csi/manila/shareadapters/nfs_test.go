@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shareadapters
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/runtimeconfig"
+)
+
+type replicasManilaClient struct {
+	manilaclient.Interface
+	replicas []manilaclient.ShareReplica
+}
+
+func (c replicasManilaClient) GetShareReplicas(shareID string) ([]manilaclient.ShareReplica, error) {
+	return c.replicas, nil
+}
+
+// Tests the NFS export location filters of the runtime config
+func TestNfsMatchExportLocationFromConfig(t *testing.T) {
+	locs := []shares.ExportLocation{
+		{Path: "10.0.0.1:/admin", IsAdminOnly: true, Preferred: true, ShareInstanceID: "instance-a"},
+		{Path: "10.0.0.2:/share-a", ShareInstanceID: "instance-a"},
+		{Path: "10.0.1.2:/share-a", Preferred: true, ShareInstanceID: "instance-a"},
+		{Path: "10.0.2.2:/share-b", ShareInstanceID: "instance-b"},
+	}
+	args := &VolumeContextArgs{
+		Locations: locs,
+		Share:     &shares.Share{ID: "share", AvailabilityZone: "zone-a", ReplicationType: "readable"},
+		ManilaClient: replicasManilaClient{replicas: []manilaclient.ShareReplica{
+			{ID: "instance-a", AvailabilityZone: "zone-a"},
+			{ID: "instance-b", AvailabilityZone: "zone-b"},
+		}},
+		NodeAZ: "zone-b",
+	}
+
+	ts := []struct {
+		conf             runtimeconfig.NfsConfig
+		expectedMatchIdx int
+	}{
+		{conf: runtimeconfig.NfsConfig{}, expectedMatchIdx: 2},
+		{conf: runtimeconfig.NfsConfig{MatchExportLocationAddress: "10.0.0.0/24"}, expectedMatchIdx: 1},
+		{conf: runtimeconfig.NfsConfig{MatchExportLocationPath: "share-b$"}, expectedMatchIdx: 3},
+		{conf: runtimeconfig.NfsConfig{MatchNodeAvailabilityZone: true}, expectedMatchIdx: 3},
+		{conf: runtimeconfig.NfsConfig{PreferredExportLocationOnly: true, MatchExportLocationPath: "share-a"}, expectedMatchIdx: 2},
+		// Filters are combined
+		{conf: runtimeconfig.NfsConfig{PreferredExportLocationOnly: true, MatchNodeAvailabilityZone: true}, expectedMatchIdx: -1},
+		{conf: runtimeconfig.NfsConfig{MatchExportLocationPath: "["}, expectedMatchIdx: -1},
+	}
+
+	for i := range ts {
+		idx, err := nfsMatchExportLocationFromConfig(args, &ts[i].conf)
+		if idx != ts[i].expectedMatchIdx {
+			t.Errorf("test case %d: expected match index %d, got %d (%v)", i, ts[i].expectedMatchIdx, idx, err)
+		}
+		if (idx == -1) != (err != nil) {
+			t.Errorf("test case %d: unexpected error %v for match index %d", i, err, idx)
+		}
+	}
+
+	// Without replicas, the export locations are in the availability zone of the share
+	args.Share.ReplicationType = ""
+	args.NodeAZ = "zone-a"
+	if idx, err := nfsMatchExportLocationFromConfig(args, &runtimeconfig.NfsConfig{MatchNodeAvailabilityZone: true}); idx != 2 {
+		t.Errorf("expected match index 2 without replicas, got %d (%v)", idx, err)
+	}
+}
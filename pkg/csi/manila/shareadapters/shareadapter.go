@@ -33,6 +33,12 @@ type VolumeContextArgs struct {
 	// an export location when building a volume context.
 	Locations []shares.ExportLocation
 
+	// Share, ManilaClient and NodeAZ are used by export location
+	// filters which need more than the export locations themselves.
+	Share        *shares.Share
+	ManilaClient manilaclient.Interface
+	NodeAZ       string
+
 	Options *options.NodeVolumeContext
 }
 
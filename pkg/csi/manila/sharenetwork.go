@@ -0,0 +1,143 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/util/metadata"
+	"k8s.io/klog/v2"
+)
+
+const autoShareNetworkName = "manila-csi"
+
+// shareNetworkResolver finds or creates the share network of the Neutron
+// network of the cluster, for the share types with
+// driver_handles_share_servers=true when the StorageClass doesn't set
+// shareNetworkID. The network is discovered from the ports of the instance
+// the controller plugin runs on.
+type shareNetworkResolver struct {
+	// name is the name of the created share networks
+	name      string
+	clusterID string
+	// getInstanceID returns the ID of the instance the plugin runs on
+	getInstanceID func() (string, error)
+
+	// mu serializes the requests so that a single share network is created
+	mu sync.Mutex
+	// netID and subnetID are the discovered network and subnet, empty until
+	// they are discovered
+	netID    string
+	subnetID string
+}
+
+func newShareNetworkResolver(clusterID string) *shareNetworkResolver {
+	name := autoShareNetworkName
+	if clusterID != "" {
+		name += "-" + clusterID
+	}
+
+	return &shareNetworkResolver{
+		name:      name,
+		clusterID: clusterID,
+		getInstanceID: func() (string, error) {
+			md, err := metadata.Get(metadata.MetadataID + "," + metadata.ConfigDriveID)
+			if err != nil {
+				return "", err
+			}
+			return md.UUID, nil
+		},
+	}
+}
+
+// discoverNetwork returns the network and subnet of the first fixed IP of the
+// ports of the instance, discovered once.
+func (r *shareNetworkResolver) discoverNetwork(manilaClient manilaclient.Interface) (string, string, error) {
+	if r.netID != "" {
+		return r.netID, r.subnetID, nil
+	}
+
+	instanceID, err := r.getInstanceID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get the ID of the instance from the metadata: %v", err)
+	}
+
+	ports, err := manilaClient.GetInstancePorts(instanceID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list the ports of instance %s: %v", instanceID, err)
+	}
+
+	for _, p := range ports {
+		if len(p.FixedIPs) == 0 {
+			continue
+		}
+
+		r.netID, r.subnetID = p.NetworkID, p.FixedIPs[0].SubnetID
+		klog.Infof("Discovered network %s and subnet %s of instance %s for the share networks", r.netID, r.subnetID, instanceID)
+		return r.netID, r.subnetID, nil
+	}
+
+	return "", "", fmt.Errorf("instance %s has no port with a fixed IP visible to the project", instanceID)
+}
+
+// getOrCreate returns the ID of a share network of the discovered network and
+// subnet, created if the project has none.
+func (r *shareNetworkResolver) getOrCreate(manilaClient manilaclient.Interface) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	netID, subnetID, err := r.discoverNetwork(manilaClient)
+	if err != nil {
+		return "", err
+	}
+
+	shareNetworks, err := manilaClient.GetShareNetworks(sharenetworks.ListOpts{NeutronNetID: netID, NeutronSubnetID: subnetID})
+	if err != nil {
+		return "", fmt.Errorf("failed to list the share networks of subnet %s: %v", subnetID, err)
+	}
+
+	// Prefer the share network created by the plugin
+	for _, sn := range shareNetworks {
+		if sn.Name == r.name {
+			return sn.ID, nil
+		}
+	}
+	if len(shareNetworks) > 0 {
+		return shareNetworks[0].ID, nil
+	}
+
+	description := "Created by the Manila CSI driver"
+	if r.clusterID != "" {
+		description += " for cluster " + r.clusterID
+	}
+
+	sn, err := manilaClient.CreateShareNetwork(sharenetworks.CreateOpts{
+		Name:            r.name,
+		Description:     description,
+		NeutronNetID:    netID,
+		NeutronSubnetID: subnetID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create a share network for subnet %s: %v", subnetID, err)
+	}
+
+	klog.Infof("Created share network %s for network %s and subnet %s", sn.ID, netID, subnetID)
+	return sn.ID, nil
+}
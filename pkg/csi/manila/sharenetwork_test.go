@@ -0,0 +1,127 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+)
+
+type fakeShareNetworkClient struct {
+	manilaclient.Interface
+
+	ports         []ports.Port
+	shareNetworks []sharenetworks.ShareNetwork
+	listOpts      []sharenetworks.ListOpts
+	portLists     int
+}
+
+func (c *fakeShareNetworkClient) GetInstancePorts(instanceID string) ([]ports.Port, error) {
+	c.portLists++
+	return c.ports, nil
+}
+
+func (c *fakeShareNetworkClient) GetShareNetworks(opts sharenetworks.ListOptsBuilder) ([]sharenetworks.ShareNetwork, error) {
+	o := opts.(sharenetworks.ListOpts)
+	c.listOpts = append(c.listOpts, o)
+
+	var list []sharenetworks.ShareNetwork
+	for _, sn := range c.shareNetworks {
+		if sn.NeutronNetID == o.NeutronNetID && sn.NeutronSubnetID == o.NeutronSubnetID {
+			list = append(list, sn)
+		}
+	}
+	return list, nil
+}
+
+func (c *fakeShareNetworkClient) CreateShareNetwork(opts sharenetworks.CreateOptsBuilder) (*sharenetworks.ShareNetwork, error) {
+	o := opts.(sharenetworks.CreateOpts)
+	sn := sharenetworks.ShareNetwork{ID: "created", Name: o.Name, NeutronNetID: o.NeutronNetID, NeutronSubnetID: o.NeutronSubnetID}
+	c.shareNetworks = append(c.shareNetworks, sn)
+	return &sn, nil
+}
+
+func TestShareNetworkResolver(t *testing.T) {
+	r := newShareNetworkResolver("cluster1")
+	r.getInstanceID = func() (string, error) { return "instance1", nil }
+
+	c := &fakeShareNetworkClient{
+		ports: []ports.Port{
+			{ID: "port0", NetworkID: "net0"},
+			{ID: "port1", NetworkID: "net1", FixedIPs: []ports.IP{{SubnetID: "subnet1", IPAddress: "10.0.0.10"}}},
+		},
+		shareNetworks: []sharenetworks.ShareNetwork{
+			{ID: "other", Name: "other", NeutronNetID: "net2", NeutronSubnetID: "subnet2"},
+		},
+	}
+
+	// The share network is created for the network of the first fixed IP
+	id, err := r.getOrCreate(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != "created" {
+		t.Errorf("got share network %s, expected created", id)
+	}
+	if sn := c.shareNetworks[1]; sn.Name != "manila-csi-cluster1" || sn.NeutronNetID != "net1" || sn.NeutronSubnetID != "subnet1" {
+		t.Errorf("unexpected share network %+v", sn)
+	}
+
+	// The created share network is reused, the network is discovered once
+	id, err = r.getOrCreate(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != "created" || len(c.shareNetworks) != 2 {
+		t.Errorf("got share network %s and %d share networks, expected created and 2", id, len(c.shareNetworks))
+	}
+	if c.portLists != 1 {
+		t.Errorf("the ports were listed %d times, expected once", c.portLists)
+	}
+}
+
+func TestShareNetworkResolverExisting(t *testing.T) {
+	r := newShareNetworkResolver("")
+	r.getInstanceID = func() (string, error) { return "instance1", nil }
+
+	c := &fakeShareNetworkClient{
+		ports: []ports.Port{
+			{ID: "port1", NetworkID: "net1", FixedIPs: []ports.IP{{SubnetID: "subnet1", IPAddress: "10.0.0.10"}}},
+		},
+		shareNetworks: []sharenetworks.ShareNetwork{
+			{ID: "existing", Name: "existing", NeutronNetID: "net1", NeutronSubnetID: "subnet1"},
+		},
+	}
+
+	id, err := r.getOrCreate(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != "existing" || len(c.shareNetworks) != 1 {
+		t.Errorf("got share network %s and %d share networks, expected existing and 1", id, len(c.shareNetworks))
+	}
+
+	// Without any fixed IP, the network cannot be discovered
+	r = newShareNetworkResolver("")
+	r.getInstanceID = func() (string, error) { return "instance1", nil }
+	if _, err := r.getOrCreate(&fakeShareNetworkClient{ports: []ports.Port{{ID: "port0"}}}); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -20,9 +20,13 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
 	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
@@ -36,9 +40,30 @@ const (
 	snapshotDescription = "snapshotted-by=manila.csi.openstack.org"
 )
 
+// volumeSnapshotMetadataKeys are the parameters passed by the
+// external-snapshotter started with --extra-create-metadata, added to the
+// metadata of the snapshots
+var volumeSnapshotMetadataKeys = []string{"csi.storage.k8s.io/volumesnapshot/name", "csi.storage.k8s.io/volumesnapshot/namespace", "csi.storage.k8s.io/volumesnapshotcontent/name"}
+
+// prepareSnapshotMetadata returns the metadata of the snapshot: the metadata
+// appended by the snapshot class, the cluster ID, the VolumeSnapshot of the
+// request and the PVC of the source share.
+func prepareSnapshotMetadata(appendSnapshotMetadata, clusterID string, params map[string]string, sourceShare *shares.Share) (map[string]string, error) {
+	snapMetadata, err := parseStringMapFromJSON(appendSnapshotMetadata)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to parse appendSnapshotMetadata field: %v", err)
+	}
+
+	snapMetadata = appendClusterMetadata(snapMetadata, clusterID, "snapshot")
+	snapMetadata = appendRequestMetadata(snapMetadata, params, volumeSnapshotMetadataKeys)
+	snapMetadata = appendRequestMetadata(snapMetadata, sourceShare.Metadata, pvcMetadataKeys)
+
+	return snapMetadata, nil
+}
+
 // getOrCreateSnapshot retrieves an existing snapshot with name=snapName, or creates a new one if it doesn't exist yet.
 // Instead of waiting for the snapshot to become available (as getOrCreateShare does), CSI's ready_to_use flag is used to signal readiness
-func getOrCreateSnapshot(snapName, sourceShareID string, manilaClient manilaclient.Interface) (*snapshots.Snapshot, error) {
+func getOrCreateSnapshot(snapName, sourceShareID string, snapOpts *options.ControllerSnapshotContext, snapMetadata map[string]string, manilaClient manilaclient.Interface) (*snapshots.Snapshot, error) {
 	var (
 		snapshot *snapshots.Snapshot
 		err      error
@@ -50,10 +75,19 @@ func getOrCreateSnapshot(snapName, sourceShareID string, manilaClient manilaclie
 		if clouderrors.IsNotFound(err) {
 			// It doesn't exist, create it
 
-			opts := snapshots.CreateOpts{
-				ShareID:     sourceShareID,
-				Name:        snapName,
-				Description: snapshotDescription,
+			description := snapOpts.Description
+			if description == "" {
+				description = snapshotDescription
+			}
+
+			opts := manilaclient.SnapshotCreateOpts{
+				CreateOpts: snapshots.CreateOpts{
+					ShareID:     sourceShareID,
+					Name:        snapName,
+					Description: description,
+				},
+				Force:    snapOpts.Force == "true",
+				Metadata: snapMetadata,
 			}
 
 			var createErr error
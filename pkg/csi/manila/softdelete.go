@@ -0,0 +1,255 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
+	clouderrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// softDeleteRetentionMetadataKey holds how long a deleted share is kept
+	softDeleteRetentionMetadataKey = "manila.csi.openstack.org/soft-delete-retention"
+	// softDeletedAtMetadataKey holds when a share was soft-deleted, in RFC 3339 format
+	softDeletedAtMetadataKey = "manila.csi.openstack.org/soft-deleted-at"
+	// softDeletedMetadataKey tags the soft-deleted shares, so they can be listed
+	softDeletedMetadataKey = "manila.csi.openstack.org/soft-deleted"
+
+	softDeletedSharePrefix = "soft-deleted-"
+
+	provisionerSecretNameKey      = "csi.storage.k8s.io/provisioner-secret-name"
+	provisionerSecretNamespaceKey = "csi.storage.k8s.io/provisioner-secret-namespace"
+)
+
+func parseSoftDeleteRetention(retention string) (time.Duration, error) {
+	d, err := time.ParseDuration(retention)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("retention period must be positive, got %s", retention)
+	}
+
+	return d, nil
+}
+
+// softDeleteShare renames and tags the share instead of deleting it, when
+// the share was provisioned with a soft-delete retention period. It returns
+// false if the share has to be deleted right away.
+func softDeleteShare(shareID string, manilaClient manilaclient.Interface) (bool, error) {
+	share, err := manilaClient.GetShareByID(shareID)
+	if err != nil {
+		if clouderrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if share.Metadata[softDeleteRetentionMetadataKey] == "" {
+		return false, nil
+	}
+
+	if share.Metadata[softDeletedMetadataKey] != "true" {
+		md := shares.SetMetadataOpts{
+			Metadata: map[string]string{
+				softDeletedMetadataKey:   "true",
+				softDeletedAtMetadataKey: time.Now().UTC().Format(time.RFC3339),
+			},
+		}
+		if _, err := manilaClient.SetShareMetadata(shareID, md); err != nil {
+			return false, fmt.Errorf("failed to tag share %s as deleted: %v", shareID, err)
+		}
+	}
+
+	if !strings.HasPrefix(share.Name, softDeletedSharePrefix) {
+		name := softDeletedSharePrefix + share.Name
+		if _, err := manilaClient.UpdateShare(shareID, shares.UpdateOpts{DisplayName: &name}); err != nil {
+			return false, fmt.Errorf("failed to rename share %s: %v", shareID, err)
+		}
+	}
+
+	klog.Infof("volume %s soft-deleted, the share will be deleted after %s", shareID, share.Metadata[softDeleteRetentionMetadataKey])
+
+	return true, nil
+}
+
+// softDeleteExpired reports whether the retention period of a soft-deleted
+// share is over.
+func softDeleteExpired(share *shares.Share, now time.Time) bool {
+	if share.Metadata[softDeletedMetadataKey] != "true" {
+		return false
+	}
+
+	retention, err := parseSoftDeleteRetention(share.Metadata[softDeleteRetentionMetadataKey])
+	if err != nil {
+		klog.Warningf("soft-deleted share %s has an invalid retention period: %v", share.ID, err)
+		return false
+	}
+
+	deletedAt, err := time.Parse(time.RFC3339, share.Metadata[softDeletedAtMetadataKey])
+	if err != nil {
+		klog.Warningf("soft-deleted share %s has an invalid deletion time: %v", share.ID, err)
+		return false
+	}
+
+	return !now.Before(deletedAt.Add(retention))
+}
+
+// shareGC deletes the soft-deleted shares once their retention period is over.
+//
+// The plugin has no credentials of its own, they come with the CSI requests.
+// The garbage collector remembers the credentials of the latest request of
+// each project. So that the shares soft-deleted before a restart are deleted
+// too, it also reads the provisioner secrets of the StorageClasses of the
+// driver, if it has a Kubernetes client. It lists the soft-deleted shares of
+// these projects only.
+type shareGC struct {
+	manilaClientBuilder manilaclient.Builder
+	kubeClient          kubernetes.Interface
+	driverName          string
+
+	mu    sync.Mutex
+	creds map[string]*client.AuthOpts
+}
+
+func newShareGC(manilaClientBuilder manilaclient.Builder, kubeClient kubernetes.Interface, driverName string) *shareGC {
+	return &shareGC{
+		manilaClientBuilder: manilaClientBuilder,
+		kubeClient:          kubeClient,
+		driverName:          driverName,
+		creds:               make(map[string]*client.AuthOpts),
+	}
+}
+
+func authOptsKey(osOpts *client.AuthOpts) string {
+	return strings.Join([]string{
+		osOpts.AuthURL, osOpts.Region, osOpts.TenantID, osOpts.TenantName, osOpts.TenantDomainID, osOpts.TenantDomainName,
+		osOpts.TrustID, osOpts.ApplicationCredentialID, osOpts.ApplicationCredentialName,
+	}, "/")
+}
+
+// track remembers the credentials of a project.
+func (gc *shareGC) track(osOpts *client.AuthOpts) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.creds[authOptsKey(osOpts)] = osOpts
+}
+
+// storageClassCreds returns the credentials of the provisioner secrets of the
+// StorageClasses of the driver. The secrets whose reference is a template,
+// e.g. ${pvc.namespace}, cannot be resolved without a volume and are skipped.
+func (gc *shareGC) storageClassCreds() map[string]*client.AuthOpts {
+	creds := make(map[string]*client.AuthOpts)
+	if gc.kubeClient == nil {
+		return creds
+	}
+
+	scs, err := gc.kubeClient.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("failed to list StorageClasses to collect soft-deleted shares: %v", err)
+		return creds
+	}
+
+	for _, sc := range scs.Items {
+		if sc.Provisioner != gc.driverName {
+			continue
+		}
+
+		name, namespace := sc.Parameters[provisionerSecretNameKey], sc.Parameters[provisionerSecretNamespaceKey]
+		if name == "" || namespace == "" {
+			continue
+		}
+		if strings.Contains(name, "${") || strings.Contains(namespace, "${") {
+			klog.V(4).Infof("skipping the templated provisioner secret of StorageClass %s to collect soft-deleted shares", sc.Name)
+			continue
+		}
+
+		secret, err := gc.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("failed to get provisioner secret %s/%s of StorageClass %s: %v", namespace, name, sc.Name, err)
+			continue
+		}
+
+		data := make(map[string]string, len(secret.Data))
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+
+		osOpts, err := options.NewOpenstackOptions(data)
+		if err != nil {
+			klog.Errorf("invalid OpenStack credentials in provisioner secret %s/%s of StorageClass %s: %v", namespace, name, sc.Name, err)
+			continue
+		}
+
+		creds[authOptsKey(osOpts)] = osOpts
+	}
+
+	return creds
+}
+
+func (gc *shareGC) run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(gc.collect, period, stopCh)
+}
+
+func (gc *shareGC) collect() {
+	creds := gc.storageClassCreds()
+
+	gc.mu.Lock()
+	for key, osOpts := range gc.creds {
+		creds[key] = osOpts
+	}
+	gc.mu.Unlock()
+
+	now := time.Now()
+	for _, osOpts := range creds {
+		manilaClient, err := gc.manilaClientBuilder.New(osOpts)
+		if err != nil {
+			klog.Errorf("failed to create Manila v2 client to collect soft-deleted shares: %v", err)
+			continue
+		}
+
+		deleted, err := manilaClient.ListShares(shares.ListOpts{Metadata: map[string]string{softDeletedMetadataKey: "true"}})
+		if err != nil {
+			klog.Errorf("failed to list soft-deleted shares: %v", err)
+			continue
+		}
+
+		for i := range deleted {
+			if !softDeleteExpired(&deleted[i], now) {
+				continue
+			}
+
+			klog.Infof("retention period of soft-deleted share %s is over, deleting it", deleted[i].ID)
+			if err := deleteShare(deleted[i].ID, manilaClient); err != nil {
+				klog.Errorf("failed to delete soft-deleted share %s: %v", deleted[i].ID, err)
+			}
+		}
+	}
+}
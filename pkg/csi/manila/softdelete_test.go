@@ -0,0 +1,150 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseSoftDeleteRetention(t *testing.T) {
+	ts := []struct {
+		retention     string
+		expected      time.Duration
+		expectedError bool
+	}{
+		{retention: "72h", expected: 72 * time.Hour},
+		{retention: "30m", expected: 30 * time.Minute},
+		{retention: "0s", expectedError: true},
+		{retention: "-1h", expectedError: true},
+		{retention: "3 days", expectedError: true},
+	}
+
+	for i := range ts {
+		result, err := parseSoftDeleteRetention(ts[i].retention)
+		if (err != nil) != ts[i].expectedError {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+		if result != ts[i].expected {
+			t.Errorf("test %d: got %v, expected %v", i, result, ts[i].expected)
+		}
+	}
+}
+
+func TestSoftDeleteExpired(t *testing.T) {
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	ts := []struct {
+		metadata map[string]string
+		expected bool
+	}{
+		{
+			// Share not soft-deleted
+			metadata: map[string]string{softDeleteRetentionMetadataKey: "1h"},
+			expected: false,
+		},
+		{
+			// Retention period over
+			metadata: map[string]string{
+				softDeleteRetentionMetadataKey: "1h",
+				softDeletedMetadataKey:         "true",
+				softDeletedAtMetadataKey:       "2022-05-10T11:00:00Z",
+			},
+			expected: true,
+		},
+		{
+			// Retention period not over
+			metadata: map[string]string{
+				softDeleteRetentionMetadataKey: "2h",
+				softDeletedMetadataKey:         "true",
+				softDeletedAtMetadataKey:       "2022-05-10T11:00:00Z",
+			},
+			expected: false,
+		},
+		{
+			// Invalid deletion time
+			metadata: map[string]string{
+				softDeleteRetentionMetadataKey: "1h",
+				softDeletedMetadataKey:         "true",
+				softDeletedAtMetadataKey:       "yesterday",
+			},
+			expected: false,
+		},
+	}
+
+	for i := range ts {
+		share := &shares.Share{ID: "share", Metadata: ts[i].metadata}
+		if result := softDeleteExpired(share, now); result != ts[i].expected {
+			t.Errorf("test %d: got %v, expected %v", i, result, ts[i].expected)
+		}
+	}
+}
+
+func TestShareGCStorageClassCreds(t *testing.T) {
+	secret := func(name, project string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data: map[string][]byte{
+				"os-authURL":     []byte("https://keystone.example.com/v3"),
+				"os-region":      []byte("RegionOne"),
+				"os-userName":    []byte("admin"),
+				"os-password":    []byte("secret"),
+				"os-domainName":  []byte("Default"),
+				"os-projectName": []byte(project),
+			},
+		}
+	}
+	storageClass := func(name, provisioner, secretName, secretNamespace string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: name},
+			Provisioner: provisioner,
+			Parameters: map[string]string{
+				provisionerSecretNameKey:      secretName,
+				provisionerSecretNamespaceKey: secretNamespace,
+			},
+		}
+	}
+
+	kubeClient := fake.NewSimpleClientset(
+		secret("project-a", "a"),
+		secret("project-b", "b"),
+		storageClass("nfs-a", "nfs.manila.csi.openstack.org", "project-a", "default"),
+		storageClass("nfs-a-retain", "nfs.manila.csi.openstack.org", "project-a", "default"),
+		storageClass("nfs-templated", "nfs.manila.csi.openstack.org", "${pvc.name}", "${pvc.namespace}"),
+		storageClass("nfs-missing", "nfs.manila.csi.openstack.org", "missing", "default"),
+		storageClass("cephfs-b", "cephfs.manila.csi.openstack.org", "project-b", "default"),
+	)
+
+	gc := newShareGC(nil, kubeClient, "nfs.manila.csi.openstack.org")
+	creds := gc.storageClassCreds()
+
+	if len(creds) != 1 {
+		t.Fatalf("expected the credentials of a single project, got %d", len(creds))
+	}
+	for _, osOpts := range creds {
+		if osOpts.TenantName != "a" {
+			t.Errorf("expected the credentials of project a, got %s", osOpts.TenantName)
+		}
+	}
+
+	if creds := newShareGC(nil, nil, "nfs.manila.csi.openstack.org").storageClassCreds(); len(creds) != 0 {
+		t.Errorf("expected no credentials without a Kubernetes client, got %d", len(creds))
+	}
+}
@@ -29,7 +29,7 @@ import (
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/capabilities"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/manilaclient"
 	"k8s.io/cloud-provider-openstack/pkg/csi/manila/options"
-	"k8s.io/klog/v2"
+	"k8s.io/cloud-provider-openstack/pkg/csi/manila/runtimeconfig"
 )
 
 type (
@@ -153,6 +153,79 @@ func compareProtocol(protoA, protoB string) bool {
 	return strings.EqualFold(protoA, protoB)
 }
 
+// fsTypesByProtocol are the file system types of the volume capabilities
+// which can be mounted from the shares of the protocol
+var fsTypesByProtocol = map[string][]string{
+	"NFS":    {"nfs", "nfs4"},
+	"CEPHFS": {"ceph", "cephfs", "ceph-fuse", "fuse.ceph"},
+}
+
+func validateFsType(volCaps []*csi.VolumeCapability, proto string) error {
+	for _, volCap := range volCaps {
+		fsType := volCap.GetMount().GetFsType()
+		if fsType == "" {
+			continue
+		}
+
+		var supported bool
+		for _, t := range fsTypesByProtocol[strings.ToUpper(proto)] {
+			if strings.EqualFold(fsType, t) {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("fsType %s cannot be mounted from %s shares", fsType, proto)
+		}
+	}
+
+	return nil
+}
+
+// getShareTypeMountOptions returns the mount options of the volumes of the
+// share type from the runtime config, joined with commas
+func getShareTypeMountOptions(shareType string) (string, error) {
+	conf, err := runtimeconfig.Get()
+	if err != nil {
+		return "", fmt.Errorf("failed to read runtime config file %s: %v", runtimeconfig.RuntimeConfigFilename, err)
+	}
+
+	if conf == nil || conf.ShareTypes[shareType] == nil {
+		return "", nil
+	}
+
+	return strings.Join(conf.ShareTypes[shareType].MountOptions, ","), nil
+}
+
+// mergeMountOptions adds the mount options of the share type to the mount
+// flags of the volume, unless the volume sets them already
+func mergeMountOptions(mountFlags []string, shareTypeMountOptions string) []string {
+	if shareTypeMountOptions == "" {
+		return mountFlags
+	}
+
+	optionName := func(opt string) string {
+		return strings.TrimSpace(strings.SplitN(opt, "=", 2)[0])
+	}
+
+	set := make(map[string]bool)
+	for _, flag := range mountFlags {
+		for _, opt := range strings.Split(flag, ",") {
+			set[optionName(opt)] = true
+		}
+	}
+
+	merged := append([]string{}, mountFlags...)
+	for _, opt := range strings.Split(shareTypeMountOptions, ",") {
+		if name := optionName(opt); name != "" && !set[name] {
+			merged = append(merged, strings.TrimSpace(opt))
+			set[name] = true
+		}
+	}
+
+	return merged
+}
+
 //
 // Controller service request validation
 //
@@ -205,10 +278,6 @@ func validateCreateSnapshotRequest(req *csi.CreateSnapshotRequest) error {
 		return errors.New("secrets cannot be nil or empty")
 	}
 
-	if req.GetParameters() != nil {
-		klog.Info("parameters in CreateSnapshot requests are ignored")
-	}
-
 	return nil
 }
 
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manila
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestValidateFsType(t *testing.T) {
+	mountCap := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}}}
+	}
+
+	tests := []struct {
+		fsType  string
+		proto   string
+		wantErr bool
+	}{
+		{fsType: "", proto: "NFS"},
+		{fsType: "nfs", proto: "NFS"},
+		{fsType: "NFS4", proto: "NFS"},
+		{fsType: "cephfs", proto: "CEPHFS"},
+		{fsType: "ext4", proto: "NFS", wantErr: true},
+		{fsType: "nfs", proto: "CEPHFS", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateFsType([]*csi.VolumeCapability{mountCap(tt.fsType)}, tt.proto)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateFsType(%q, %s) error = %v, wantErr %v", tt.fsType, tt.proto, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	tests := []struct {
+		mountFlags   []string
+		shareTypeOpt string
+		want         []string
+	}{
+		{mountFlags: []string{"ro"}, shareTypeOpt: "", want: []string{"ro"}},
+		{mountFlags: nil, shareTypeOpt: "nfsvers=4.1,noatime", want: []string{"nfsvers=4.1", "noatime"}},
+		{mountFlags: []string{"nfsvers=3"}, shareTypeOpt: "nfsvers=4.1, noatime", want: []string{"nfsvers=3", "noatime"}},
+		{mountFlags: []string{"noatime,hard"}, shareTypeOpt: "hard,noatime", want: []string{"noatime,hard"}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeMountOptions(tt.mountFlags, tt.shareTypeOpt); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeMountOptions(%v, %q) = %v, want %v", tt.mountFlags, tt.shareTypeOpt, got, tt.want)
+		}
+	}
+}
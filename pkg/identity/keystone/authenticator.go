@@ -32,8 +32,12 @@ type tokenInfo struct {
 	roles       []string
 	projectName string
 	projectID   string
-	domainName  string
-	domainID    string
+	// projectDomainName and projectDomainID are the domain of the project,
+	// which may differ from the domain of the user
+	projectDomainName string
+	projectDomainID   string
+	domainName        string
+	domainID          string
 }
 
 type IKeystone interface {
@@ -84,6 +88,9 @@ func (k *Keystoner) GetTokenInfo(token string) (*tokenInfo, error) {
 		roles:       userRoles,
 		domainID:    tokenUser.Domain.ID,
 		domainName:  tokenUser.Domain.Name,
+
+		projectDomainID:   project.Domain.ID,
+		projectDomainName: project.Domain.Name,
 	}, nil
 }
 
@@ -113,6 +120,7 @@ func (k *Keystoner) GetGroups(token string, userID string) ([]string, error) {
 // Authenticator contacts openstack keystone to validate user's token passed in the request.
 type Authenticator struct {
 	keystoner IKeystone
+	filter    authFilter
 }
 
 // AuthenticateToken checks the token via Keystone call
@@ -122,19 +130,26 @@ func (a *Authenticator) AuthenticateToken(token string) (user.Info, bool, error)
 		return nil, false, fmt.Errorf("failed to authenticate: %v", err)
 	}
 
+	if err := a.filter.allows(tokenInfo); err != nil {
+		return nil, false, fmt.Errorf("failed to authenticate: %v", err)
+	}
+
 	userGroups, err := a.keystoner.GetGroups(token, tokenInfo.userID)
 	if err != nil {
 		return nil, false, fmt.Errorf("failed to authenticate: %v", err)
 	}
 
 	extra := map[string][]string{
-		Roles:       tokenInfo.roles,
-		ProjectID:   {tokenInfo.projectID},
-		ProjectName: {tokenInfo.projectName},
-		DomainID:    {tokenInfo.domainID},
-		DomainName:  {tokenInfo.domainName},
+		Roles:             tokenInfo.roles,
+		ProjectID:         {tokenInfo.projectID},
+		ProjectName:       {tokenInfo.projectName},
+		ProjectDomainID:   {tokenInfo.projectDomainID},
+		ProjectDomainName: {tokenInfo.projectDomainName},
+		DomainID:          {tokenInfo.domainID},
+		DomainName:        {tokenInfo.domainName},
 	}
 
+	userGroups = a.filter.filterGroups(userGroups)
 	userGroups = append(userGroups, tokenInfo.projectID)
 	authenticatedUser := &user.DefaultInfo{
 		Name:   tokenInfo.userName,
@@ -32,6 +32,9 @@ func TestAuthenticateToken(t *testing.T) {
 			domainName:  "domain-name",
 			domainID:    "domain-id",
 			roles:       []string{"role1", "role2"},
+
+			projectDomainName: "project-domain-name",
+			projectDomainID:   "project-domain-id",
 		}, nil).
 		Once()
 	keystone.
@@ -52,14 +55,151 @@ func TestAuthenticateToken(t *testing.T) {
 		UID:    "user-id",
 		Groups: []string{"group1", "group2"},
 		Extra: map[string][]string{
-			Roles:       {"role1", "role2"},
-			ProjectID:   {"project-id"},
-			ProjectName: {"project-name"},
-			DomainID:    {"domain-id"},
-			DomainName:  {"domain-name"},
+			Roles:             {"role1", "role2"},
+			ProjectID:         {"project-id"},
+			ProjectName:       {"project-name"},
+			ProjectDomainID:   {"project-domain-id"},
+			ProjectDomainName: {"project-domain-name"},
+			DomainID:          {"domain-id"},
+			DomainName:        {"domain-name"},
 		},
 	}
 	th.AssertDeepEquals(t, expectedUserInfo, userInfo)
 
 	keystone.AssertExpectations(t)
 }
+
+func TestAuthenticateTokenFilter(t *testing.T) {
+	info := &tokenInfo{
+		userName:    "user-name",
+		userID:      "user-id",
+		projectID:   "project-id",
+		projectName: "project-name",
+		domainName:  "domain-name",
+		domainID:    "domain-id",
+		roles:       []string{"member"},
+
+		projectDomainName: "project-domain-name",
+		projectDomainID:   "project-domain-id",
+	}
+
+	tests := []struct {
+		name           string
+		filter         authFilter
+		authenticated  bool
+		expectedGroups []string
+	}{
+		{
+			name:           "no filter",
+			authenticated:  true,
+			expectedGroups: []string{"k8s-admins", "system:masters", "project-id"},
+		},
+		{
+			name:           "allowed domain and project",
+			filter:         authFilter{allowedDomains: []string{"domain-name"}, allowedProjects: []string{"project-id"}},
+			authenticated:  true,
+			expectedGroups: []string{"k8s-admins", "system:masters", "project-id"},
+		},
+		{
+			name:   "domain not allowed",
+			filter: authFilter{allowedDomains: []string{"other-domain"}},
+		},
+		{
+			name:   "project not allowed",
+			filter: authFilter{allowedProjects: []string{"other-project"}},
+		},
+		{
+			name:           "allowed project of domain",
+			filter:         authFilter{allowedProjects: []string{"project-domain-name/project-name", "project-domain-id/other-project"}},
+			authenticated:  true,
+			expectedGroups: []string{"k8s-admins", "system:masters", "project-id"},
+		},
+		{
+			name:   "allowed project of the same name in another domain",
+			filter: authFilter{allowedProjects: []string{"domain-name/project-name", "other-domain/project-name"}},
+		},
+		{
+			name:   "project name without domain not allowed",
+			filter: authFilter{allowedProjects: []string{"project-name"}},
+		},
+		{
+			name:   "project denied",
+			filter: authFilter{deniedProjects: []string{"project-domain-id/project-name"}},
+		},
+		{
+			name:           "denied project of the same name in another domain",
+			filter:         authFilter{deniedProjects: []string{"other-domain/project-name", "project-name"}},
+			authenticated:  true,
+			expectedGroups: []string{"k8s-admins", "system:masters", "project-id"},
+		},
+		{
+			name:   "no allowed role",
+			filter: authFilter{allowedRoles: []string{"k8s-user"}},
+		},
+		{
+			name:           "allowed groups",
+			filter:         authFilter{allowedGroups: []string{"k8s-*"}},
+			authenticated:  true,
+			expectedGroups: []string{"k8s-admins", "project-id"},
+		},
+		{
+			name:           "denied groups",
+			filter:         authFilter{deniedGroups: []string{"system:*"}},
+			authenticated:  true,
+			expectedGroups: []string{"k8s-admins", "project-id"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keystone := &MockIKeystone{}
+			keystone.On("GetTokenInfo", "token").Return(info, nil)
+			keystone.On("GetGroups", "token", "user-id").Return([]string{"k8s-admins", "system:masters"}, nil)
+
+			a := &Authenticator{
+				keystoner: keystone,
+				filter:    test.filter,
+			}
+			userInfo, authenticated, err := a.AuthenticateToken("token")
+
+			th.AssertEquals(t, test.authenticated, authenticated)
+			if !test.authenticated {
+				th.AssertEquals(t, true, err != nil)
+				return
+			}
+			th.AssertNoErr(t, err)
+			th.AssertDeepEquals(t, test.expectedGroups, userInfo.GetGroups())
+		})
+	}
+}
+
+func TestAuthFilterValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    authFilter
+		expectErr bool
+	}{
+		{name: "empty"},
+		{name: "project ID", filter: authFilter{allowedProjects: []string{"c869168a828847f39f7f06edd7305637"}}},
+		{name: "project UUID", filter: authFilter{deniedProjects: []string{"c869168a-8288-47f3-9f7f-06edd7305637"}}},
+		{name: "project of domain", filter: authFilter{allowedProjects: []string{"Default/k8s"}}},
+		// A bare name never matches a token, it would deny nothing or lock
+		// everyone out
+		{name: "denied project name", filter: authFilter{deniedProjects: []string{"admin"}}, expectErr: true},
+		{name: "allowed project name", filter: authFilter{allowedProjects: []string{"Default/k8s", "k8s"}}, expectErr: true},
+		{name: "project without domain", filter: authFilter{allowedProjects: []string{"/k8s"}}, expectErr: true},
+		{name: "project without name", filter: authFilter{allowedProjects: []string{"Default/"}}, expectErr: true},
+		{name: "invalid group pattern", filter: authFilter{allowedGroups: []string{"k8s-["}}, expectErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.filter.validate()
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
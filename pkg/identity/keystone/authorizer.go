@@ -149,20 +149,50 @@ func resourcePermissionAllowed(permissionSpec map[string][]string, attr authoriz
 	return false
 }
 
+// nonResourcePathPrecedence returns the precedence of the non-resource path
+// definition matching the path, -1 if it does not match it. "*" matches all
+// paths, "/logs/*" matches all the paths starting with "/logs/", and an exact
+// path takes precedence over the wildcards, the longest prefix over the
+// shorter ones.
+func nonResourcePathPrecedence(definition string, path string) int {
+	switch {
+	case definition == path:
+		return len(path) + 2
+	case definition == "*":
+		return 0
+	case strings.HasSuffix(definition, "*"):
+		prefix := strings.TrimSuffix(definition, "*")
+		if strings.HasPrefix(path, prefix) {
+			return len(prefix) + 1
+		}
+	}
+	return -1
+}
+
+// nonResourcePermissionAllowed checks the verb of the request against the
+// verbs of the non-resource path definition with the highest precedence
+// matching the path, so that e.g. "/logs/*" allows reading all the logs but
+// "/logs/audit": [] none of the audit logs.
 func nonResourcePermissionAllowed(permissionSpec map[string][]string, attr authorizer.Attributes) bool {
 	path := attr.GetPath()
 	verb := attr.GetVerb()
 
+	precedence := -1
+	var verbs []string
 	for key, value := range permissionSpec {
-		allowedVerbs := sets.NewString()
-		for _, val := range value {
-			allowedVerbs.Insert(val)
-		}
-		if allowedVerbs.Has("*") {
-			allowedVerbs.Insert(verb)
+		if p := nonResourcePathPrecedence(strings.TrimSpace(key), path); p > precedence {
+			precedence = p
+			verbs = value
 		}
+	}
+	if precedence < 0 {
+		return false
+	}
+	klog.V(4).Infof("Request path: %s, verb: %s, allowed verbs: %s", path, verb, verbs)
 
-		if key == path && allowedVerbs.Has(verb) {
+	for _, val := range verbs {
+		val = strings.ToLower(val)
+		if val == "*" || val == verb {
 			return true
 		}
 	}
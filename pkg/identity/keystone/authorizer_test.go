@@ -321,6 +321,14 @@ func TestAuthorizerVersion2(t *testing.T) {
 			Roles:       {"testuser"},
 		},
 	}
+	monitoring := &user.DefaultInfo{
+		Name:   "monitoring",
+		Groups: []string{"group"},
+		Extra: map[string][]string{
+			ProjectName: {"demo"},
+			Roles:       {"monitoring"},
+		},
+	}
 
 	// Test developer
 	attrs := authorizer.AttributesRecord{User: developer, ResourceRequest: true, Verb: "get", Namespace: "default", Resource: "pods"}
@@ -411,4 +419,26 @@ func TestAuthorizerVersion2(t *testing.T) {
 	attrs = authorizer.AttributesRecord{User: testuser2, ResourceRequest: true, Verb: "get", Namespace: "default", Resource: "pods"}
 	decision, _, _ = a.Authorize(attrs)
 	th.AssertEquals(t, authorizer.DecisionAllow, decision)
+
+	// The non-resource path with the highest precedence decides
+	for _, c := range []struct {
+		verb     string
+		path     string
+		decision authorizer.Decision
+	}{
+		{"get", "/healthz", authorizer.DecisionAllow},
+		{"post", "/healthz", authorizer.DecisionDeny},
+		{"post", "/metrics", authorizer.DecisionAllow},
+		{"head", "/logs/kubelet.log", authorizer.DecisionAllow},
+		{"get", "/logs/kubelet.log", authorizer.DecisionAllow},
+		{"post", "/logs/kubelet.log", authorizer.DecisionDeny},
+		{"get", "/logs/audit/audit.log", authorizer.DecisionDeny},
+		{"get", "/logs/audit", authorizer.DecisionAllow},
+	} {
+		attrs = authorizer.AttributesRecord{User: monitoring, ResourceRequest: false, Verb: c.verb, Path: c.path}
+		decision, _, _ = a.Authorize(attrs)
+		if decision != c.decision {
+			t.Errorf("expected decision %v for %s %s, got %v", c.decision, c.verb, c.path, decision)
+		}
+	}
 }
@@ -36,6 +36,15 @@ type Config struct {
 	SyncConfigFile      string
 	SyncConfigMapName   string
 	Kubeconfig          string
+
+	ImpersonationConfigFile string
+
+	AllowedDomains  []string
+	AllowedProjects []string
+	DeniedProjects  []string
+	AllowedRoles    []string
+	AllowedGroups   []string
+	DeniedGroups    []string
 }
 
 // NewConfig returns a Config
@@ -51,6 +60,8 @@ func NewConfig() *Config {
 		SyncConfigFile:      os.Getenv("KEYSTONE_SYNC_CONFIG_FILE"),
 		SyncConfigMapName:   os.Getenv("KEYSTONE_SYNC_CONFIGMAP_NAME"),
 		Kubeconfig:          os.Getenv("KEYSTONE_KUBECONFIG_FILE"),
+
+		ImpersonationConfigFile: os.Getenv("KEYSTONE_IMPERSONATION_CONFIG_FILE"),
 	}
 }
 
@@ -73,6 +84,12 @@ func (c *Config) ValidateFlags() error {
 		klog.Warning("Argument --sync-config-file or --sync-configmap-name missing. Data synchronization between Keystone and Kubernetes is disabled.")
 	}
 
+	filter := c.authFilter()
+	if err := filter.validate(); err != nil {
+		errorsFound = true
+		klog.Errorf("%v", err)
+	}
+
 	if errorsFound {
 		return fmt.Errorf("failed to validate the input parameters")
 	}
@@ -90,5 +107,23 @@ func (c *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&c.PolicyConfigMapName, "policy-configmap-name", c.PolicyConfigMapName, "ConfigMap in kube-system namespace containing the policy configuration, the ConfigMap data must contain the key 'policies'")
 	fs.StringVar(&c.SyncConfigFile, "sync-config-file", c.SyncConfigFile, "File containing config values for data synchronization beetween Keystone and Kubernetes.")
 	fs.StringVar(&c.SyncConfigMapName, "sync-configmap-name", "", "ConfigMap in kube-system namespace containing config values for data synchronization beetween Keystone and Kubernetes.")
+	fs.StringSliceVar(&c.AllowedDomains, "allowed-domains", c.AllowedDomains, "IDs or names of the Keystone domains whose users are allowed to authenticate. All domains are allowed if empty.")
+	fs.StringSliceVar(&c.AllowedProjects, "allowed-projects", c.AllowedProjects, "IDs or <domain>/<name> of the Keystone projects whose scoped tokens are allowed to authenticate, the domain being its ID or name. A project name without domain is rejected. All projects are allowed if empty.")
+	fs.StringSliceVar(&c.DeniedProjects, "denied-projects", c.DeniedProjects, "IDs or <domain>/<name> of the Keystone projects whose scoped tokens are not allowed to authenticate, the domain being its ID or name.")
+	fs.StringSliceVar(&c.AllowedRoles, "allowed-roles", c.AllowedRoles, "Keystone roles of which the user needs at least one in the project to authenticate. All roles are allowed if empty.")
+	fs.StringSliceVar(&c.AllowedGroups, "allowed-groups", c.AllowedGroups, "Patterns of the Keystone groups passed to Kubernetes as groups of the user, e.g. 'k8s-*'. All groups are passed if empty.")
+	fs.StringSliceVar(&c.DeniedGroups, "denied-groups", c.DeniedGroups, "Patterns of the Keystone groups never passed to Kubernetes as groups of the user, e.g. 'system:*'.")
+	fs.StringVar(&c.ImpersonationConfigFile, "impersonation-config-file", c.ImpersonationConfigFile, "File containing the rules allowing Keystone roles to impersonate Kubernetes users, groups and service accounts. Impersonation is only allowed by the policy if empty.")
 	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Kubeconfig file used to connect to Kubernetes API to get policy configmap. If the service is running inside the pod, this option is not necessary, will use in-cluster config instead.")
 }
+
+func (c *Config) authFilter() authFilter {
+	return authFilter{
+		allowedDomains:  c.AllowedDomains,
+		allowedProjects: c.AllowedProjects,
+		deniedProjects:  c.DeniedProjects,
+		allowedRoles:    c.AllowedRoles,
+		allowedGroups:   c.AllowedGroups,
+		deniedGroups:    c.DeniedGroups,
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// authFilter restricts the Keystone users allowed to authenticate and the
+// Keystone groups passed to Kubernetes. An empty allow list allows everything.
+type authFilter struct {
+	// allowedDomains are the IDs or names of the domains of the users
+	allowedDomains []string
+	// allowedProjects are the IDs or <domain>/<name> of the projects the
+	// tokens are scoped to, project names being only unique in their domain
+	allowedProjects []string
+	// deniedProjects are the IDs or <domain>/<name> of the projects whose tokens are rejected
+	deniedProjects []string
+	// allowedRoles are the roles of which the user needs at least one in the project
+	allowedRoles []string
+	// allowedGroups are the patterns of the Keystone groups passed to Kubernetes
+	allowedGroups []string
+	// deniedGroups are the patterns of the Keystone groups never passed to Kubernetes
+	deniedGroups []string
+}
+
+// validate checks the projects and the group patterns.
+func (f *authFilter) validate() error {
+	for _, projects := range [][]string{f.allowedProjects, f.deniedProjects} {
+		if err := validateProjects(projects); err != nil {
+			return err
+		}
+	}
+	for _, patterns := range [][]string{f.allowedGroups, f.deniedGroups} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid group pattern %q: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// allows returns an error if the token is not allowed to authenticate.
+func (f *authFilter) allows(info *tokenInfo) error {
+	if len(f.allowedDomains) > 0 && !containsAny(f.allowedDomains, info.domainID, info.domainName) {
+		return fmt.Errorf("domain %s of user %s is not allowed", info.domainName, info.userName)
+	}
+	projects := projectRefs(info.projectID, info.projectName, info.projectDomainID, info.projectDomainName)
+	if len(f.allowedProjects) > 0 && !containsAny(f.allowedProjects, projects...) {
+		return fmt.Errorf("project %s is not allowed", info.projectName)
+	}
+	if containsAny(f.deniedProjects, projects...) {
+		return fmt.Errorf("project %s is denied", info.projectName)
+	}
+	if len(f.allowedRoles) > 0 && !containsAny(f.allowedRoles, info.roles...) {
+		return fmt.Errorf("user %s has none of the allowed roles in project %s", info.userName, info.projectName)
+	}
+	return nil
+}
+
+// filterGroups returns the groups passed to Kubernetes.
+func (f *authFilter) filterGroups(groups []string) []string {
+	if len(f.allowedGroups) == 0 && len(f.deniedGroups) == 0 {
+		return groups
+	}
+
+	var res []string
+	for _, g := range groups {
+		if (len(f.allowedGroups) > 0 && !matchesAny(f.allowedGroups, g)) || matchesAny(f.deniedGroups, g) {
+			klog.V(4).Infof("Keystone group %s is not passed to Kubernetes", g)
+			continue
+		}
+		res = append(res, g)
+	}
+	return res
+}
+
+// projectIDRegexp matches the Keystone project IDs, hexadecimal UUIDs with or
+// without dashes.
+var projectIDRegexp = regexp.MustCompile(`^[0-9a-fA-F]{32}$|^[0-9a-fA-F]{8}(-[0-9a-fA-F]{4}){3}-[0-9a-fA-F]{12}$`)
+
+// validateProjects checks that the projects are IDs or <domain>/<name>. A
+// project name alone is rejected, as it never matches a token, see
+// projectRefs.
+func validateProjects(projects []string) error {
+	for _, p := range projects {
+		if i := strings.Index(p, "/"); i > 0 && i < len(p)-1 {
+			continue
+		}
+		if !projectIDRegexp.MatchString(p) {
+			return fmt.Errorf("invalid project %q, it must be an ID or <domain>/<name>", p)
+		}
+	}
+	return nil
+}
+
+// projectRefs returns the ID of a project, and its name qualified with the ID
+// or the name of its domain. The name alone is not returned as projects of
+// different domains may have the same name.
+func projectRefs(projectID, projectName, domainID, domainName string) []string {
+	refs := []string{projectID}
+	if projectName == "" {
+		return refs
+	}
+	for _, domain := range []string{domainID, domainName} {
+		if domain != "" {
+			refs = append(refs, domain+"/"+projectName)
+		}
+	}
+	return refs
+}
+
+func containsAny(list []string, values ...string) bool {
+	for _, item := range list {
+		for _, v := range values {
+			if v != "" && item == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/klog/v2"
+)
+
+// impersonationRule allows the Keystone users with one of the roles, in one
+// of the projects, to impersonate the Kubernetes users, groups and service
+// accounts matching the patterns.
+type impersonationRule struct {
+	// Roles are the Keystone roles of which the user needs at least one
+	Roles []string `yaml:"roles"`
+	// Projects are the IDs or <domain>/<name> of the projects, all projects
+	// if empty
+	Projects []string `yaml:"projects"`
+	// Users are the patterns of the Kubernetes users, e.g. 'admin'
+	Users []string `yaml:"users"`
+	// Groups are the patterns of the Kubernetes groups, e.g. 'system:masters'
+	Groups []string `yaml:"groups"`
+	// ServiceAccounts are the patterns of the service accounts as
+	// namespace/name, e.g. 'ci/*'
+	ServiceAccounts []string `yaml:"service_accounts"`
+}
+
+type impersonationConfig struct {
+	Rules []impersonationRule `yaml:"rules"`
+}
+
+// newImpersonationConfigFromFile loads the impersonation rules from a file.
+func newImpersonationConfigFromFile(path string) (*impersonationConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ic impersonationConfig
+	if err := yaml.Unmarshal(data, &ic); err != nil {
+		return nil, err
+	}
+	return &ic, ic.validate()
+}
+
+// validate checks that each rule requires a role and that the projects and
+// the patterns are valid.
+func (ic *impersonationConfig) validate() error {
+	for i, rule := range ic.Rules {
+		if len(rule.Roles) == 0 {
+			return fmt.Errorf("impersonation rule %d has no roles", i)
+		}
+		if err := validateProjects(rule.Projects); err != nil {
+			return fmt.Errorf("impersonation rule %d: %v", i, err)
+		}
+		for _, patterns := range [][]string{rule.Users, rule.Groups, rule.ServiceAccounts} {
+			for _, p := range patterns {
+				if _, err := path.Match(p, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q in impersonation rule %d: %v", p, i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isImpersonation reports whether the request checks that the user may
+// impersonate a user, a group or a service account.
+func isImpersonation(attrs authorizer.Attributes) bool {
+	if !attrs.IsResourceRequest() || attrs.GetVerb() != "impersonate" || attrs.GetAPIGroup() != "" {
+		return false
+	}
+	switch attrs.GetResource() {
+	case "users", "groups", "serviceaccounts":
+		return true
+	}
+	return false
+}
+
+// impersonationTarget returns the impersonated user, group or service
+// account, as written in the logs and in the reason of the decision.
+func impersonationTarget(attrs authorizer.Attributes) string {
+	if attrs.GetResource() == "serviceaccounts" {
+		return fmt.Sprintf("serviceaccount %s/%s", attrs.GetNamespace(), attrs.GetName())
+	}
+	return fmt.Sprintf("%s %s", attrs.GetResource()[:len(attrs.GetResource())-1], attrs.GetName())
+}
+
+// authorize reports whether a rule allows the Keystone user to impersonate
+// the target of the request, and the reason of the decision. The reason is
+// returned to the kube-apiserver, which writes it in the audit log of the
+// requests denied for it. Every decision is logged too.
+func (ic *impersonationConfig) authorize(attrs authorizer.Attributes) (bool, string) {
+	user := attrs.GetUser()
+	extra := user.GetExtra()
+	target := impersonationTarget(attrs)
+
+	allowed := false
+	reason := fmt.Sprintf("no impersonation rule of k8s-keystone-auth allows the roles %v of project %s to impersonate %s",
+		extra[Roles], projectRef(extra), target)
+	for i, rule := range ic.Rules {
+		if rule.allows(extra, attrs) {
+			allowed = true
+			reason = fmt.Sprintf("impersonation rule %d of k8s-keystone-auth allows the roles %v of project %s to impersonate %s",
+				i, extra[Roles], projectRef(extra), target)
+			break
+		}
+	}
+
+	klog.InfoS("Impersonation decision", "allowed", allowed, "user", user.GetName(), "userID", user.GetUID(),
+		"projectID", extra[ProjectID], "projectName", extra[ProjectName], "projectDomainName", extra[ProjectDomainName], "roles", extra[Roles],
+		"target", target)
+	return allowed, reason
+}
+
+// projectRef returns the project of a user as <domain>/<name>, or its ID if
+// its name is unknown.
+func projectRef(extra map[string][]string) string {
+	if name := extraValue(extra, ProjectName); name != "" {
+		return extraValue(extra, ProjectDomainName) + "/" + name
+	}
+	return extraValue(extra, ProjectID)
+}
+
+func (rule *impersonationRule) allows(extra map[string][]string, attrs authorizer.Attributes) bool {
+	if !containsAny(rule.Roles, extra[Roles]...) {
+		return false
+	}
+	if len(rule.Projects) > 0 {
+		projects := projectRefs(extraValue(extra, ProjectID), extraValue(extra, ProjectName), extraValue(extra, ProjectDomainID), extraValue(extra, ProjectDomainName))
+		if !containsAny(rule.Projects, projects...) {
+			return false
+		}
+	}
+
+	switch attrs.GetResource() {
+	case "users":
+		return matchesAny(rule.Users, attrs.GetName())
+	case "groups":
+		return matchesAny(rule.Groups, attrs.GetName())
+	case "serviceaccounts":
+		return matchesAny(rule.ServiceAccounts, attrs.GetNamespace()+"/"+attrs.GetName())
+	}
+	return false
+}
+
+// extraValue returns the first value of a key of the extra information of a
+// user.
+func extraValue(extra map[string][]string, key string) string {
+	if len(extra[key]) == 0 {
+		return ""
+	}
+	return extra[key][0]
+}
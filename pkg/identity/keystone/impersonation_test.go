@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"strings"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+
+	auditinternal "k8s.io/apiserver/pkg/apis/audit"
+	auditpolicy "k8s.io/apiserver/pkg/audit/policy"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func TestImpersonation(t *testing.T) {
+	ic, err := newImpersonationConfigFromFile("impersonation_test.yaml")
+	th.AssertNoErr(t, err)
+	th.AssertEquals(t, 2, len(ic.Rules))
+
+	breakGlass := &user.DefaultInfo{Name: "alice", Extra: map[string][]string{ProjectName: {"ops"}, ProjectDomainName: {"Default"}, Roles: {"member", "k8s-break-glass"}}}
+	otherProject := &user.DefaultInfo{Name: "bob", Extra: map[string][]string{ProjectName: {"dev"}, ProjectDomainName: {"Default"}, Roles: {"k8s-break-glass"}}}
+	// A project with the same name in another domain
+	otherDomain := &user.DefaultInfo{Name: "eve", Extra: map[string][]string{ProjectName: {"ops"}, ProjectDomainName: {"customers"}, Roles: {"k8s-break-glass"}}}
+	ci := &user.DefaultInfo{Name: "ci", Extra: map[string][]string{ProjectName: {"dev"}, ProjectDomainName: {"Default"}, Roles: {"k8s-ci"}}}
+
+	tests := []struct {
+		user      user.Info
+		resource  string
+		namespace string
+		name      string
+		allowed   bool
+	}{
+		{user: breakGlass, resource: "users", name: "admin", allowed: true},
+		{user: breakGlass, resource: "groups", name: "system:masters", allowed: true},
+		{user: breakGlass, resource: "users", name: "root"},
+		{user: breakGlass, resource: "serviceaccounts", namespace: "ci", name: "deployer"},
+		{user: otherProject, resource: "users", name: "admin"},
+		{user: otherDomain, resource: "users", name: "admin"},
+		{user: otherDomain, resource: "groups", name: "system:masters"},
+		{user: ci, resource: "serviceaccounts", namespace: "ci", name: "deployer", allowed: true},
+		{user: ci, resource: "serviceaccounts", namespace: "kube-system", name: "deployer"},
+		{user: ci, resource: "users", name: "admin"},
+	}
+	for _, test := range tests {
+		attrs := authorizer.AttributesRecord{
+			User:            test.user,
+			Verb:            "impersonate",
+			Resource:        test.resource,
+			Namespace:       test.namespace,
+			Name:            test.name,
+			ResourceRequest: true,
+		}
+		th.AssertEquals(t, true, isImpersonation(attrs))
+		allowed, reason := ic.authorize(attrs)
+		if allowed != test.allowed {
+			t.Errorf("expected %s impersonating %s to be allowed: %v", test.user.GetName(), impersonationTarget(attrs), test.allowed)
+		}
+		if !strings.Contains(reason, impersonationTarget(attrs)) {
+			t.Errorf("expected the reason %q to name the impersonated %s", reason, impersonationTarget(attrs))
+		}
+	}
+
+	// Other requests are authorized by the policy
+	th.AssertEquals(t, false, isImpersonation(authorizer.AttributesRecord{Verb: "get", Resource: "users", ResourceRequest: true}))
+	th.AssertEquals(t, false, isImpersonation(authorizer.AttributesRecord{Verb: "impersonate", APIGroup: "authentication.k8s.io", Resource: "userextras", ResourceRequest: true}))
+
+	invalid := &impersonationConfig{Rules: []impersonationRule{{Users: []string{"admin"}}}}
+	if err := invalid.validate(); err == nil {
+		t.Errorf("expected an error for a rule without roles")
+	}
+	invalid = &impersonationConfig{Rules: []impersonationRule{{Roles: []string{"k8s-break-glass"}, Projects: []string{"/ops"}, Users: []string{"admin"}}}}
+	if err := invalid.validate(); err == nil {
+		t.Errorf("expected an error for a rule with an invalid project")
+	}
+}
+
+// TestImpersonationAuditPolicy checks that the example audit policy records
+// the requests of the Keystone users, which are the ones recording their
+// impersonations.
+func TestImpersonationAuditPolicy(t *testing.T) {
+	p, err := auditpolicy.LoadPolicyFromFile("../../../examples/webhook/keystone-audit-policy.yaml")
+	th.AssertNoErr(t, err)
+	evaluator := auditpolicy.NewPolicyRuleEvaluator(p)
+
+	keystoneUser := &user.DefaultInfo{
+		Name:   "alice",
+		Groups: []string{"c7c1ad8c0e6b4d3f9c3b2e1e5f0a7b6d", user.AllAuthenticated},
+		Extra:  map[string][]string{ProjectName: {"ops"}, ProjectDomainName: {"Default"}, Roles: {"k8s-break-glass"}},
+	}
+	node := &user.DefaultInfo{Name: "system:node:worker-0", Groups: []string{user.NodesGroup, user.AllAuthenticated}}
+
+	tests := []struct {
+		user     user.Info
+		verb     string
+		resource string
+		level    auditinternal.Level
+	}{
+		{user: keystoneUser, verb: "get", resource: "secrets", level: auditinternal.LevelMetadata},
+		{user: keystoneUser, verb: "delete", resource: "pods", level: auditinternal.LevelMetadata},
+		{user: node, verb: "get", resource: "pods", level: auditinternal.LevelNone},
+	}
+	for _, test := range tests {
+		attrs := authorizer.AttributesRecord{User: test.user, Verb: test.verb, Resource: test.resource, ResourceRequest: true}
+		if level := evaluator.EvaluatePolicyRule(attrs).Level; level != test.level {
+			t.Errorf("expected the audit level of %s %s by %s to be %s, got %s", test.verb, test.resource, test.user.GetName(), test.level, level)
+		}
+	}
+}
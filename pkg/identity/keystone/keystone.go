@@ -50,13 +50,15 @@ import (
 )
 
 const (
-	maxRetries  = 5
-	cmNamespace = "kube-system"
-	Roles       = "alpha.kubernetes.io/identity/roles"
-	ProjectID   = "alpha.kubernetes.io/identity/project/id"
-	ProjectName = "alpha.kubernetes.io/identity/project/name"
-	DomainID    = "alpha.kubernetes.io/identity/user/domain/id"
-	DomainName  = "alpha.kubernetes.io/identity/user/domain/name"
+	maxRetries        = 5
+	cmNamespace       = "kube-system"
+	Roles             = "alpha.kubernetes.io/identity/roles"
+	ProjectID         = "alpha.kubernetes.io/identity/project/id"
+	ProjectName       = "alpha.kubernetes.io/identity/project/name"
+	ProjectDomainID   = "alpha.kubernetes.io/identity/project/domain/id"
+	ProjectDomainName = "alpha.kubernetes.io/identity/project/domain/name"
+	DomainID          = "alpha.kubernetes.io/identity/user/domain/id"
+	DomainName        = "alpha.kubernetes.io/identity/user/domain/name"
 )
 
 var userAgentData []string
@@ -90,6 +92,7 @@ type Auth struct {
 	authz          *Authorizer
 	k8sClient      *kubernetes.Clientset
 	syncer         *Syncer
+	impersonation  *impersonationConfig
 	config         *Config
 	stopCh         chan struct{}
 	queue          workqueue.RateLimitingInterface
@@ -375,24 +378,39 @@ func (k *Auth) authorizeToken(w http.ResponseWriter, r *http.Request, data map[s
 	}
 
 	var allowed authorizer.Decision
-	if len(k.authz.pl) > 0 {
-		var reason string
+	var reason string
+	impersonationAllowed := false
+	if k.impersonation != nil && isImpersonation(attrs) {
+		impersonationAllowed, reason = k.impersonation.authorize(attrs)
+	}
+	if impersonationAllowed {
+		allowed = authorizer.DecisionAllow
+	} else if len(k.authz.pl) > 0 {
+		var policyReason string
 		var err error
-		allowed, reason, err = k.authz.Authorize(attrs)
-		klog.V(4).Infof("<<<< authorizeToken: %v, %v, %v\n", allowed, reason, err)
+		allowed, policyReason, err = k.authz.Authorize(attrs)
+		klog.V(4).Infof("<<<< authorizeToken: %v, %v, %v\n", allowed, policyReason, err)
 		if err != nil {
-			http.Error(w, reason, http.StatusInternalServerError)
+			http.Error(w, policyReason, http.StatusInternalServerError)
 			return
 		}
+		// The reason of a denied impersonation is kept unless the policy gives one
+		if allowed == authorizer.DecisionAllow || policyReason != "" {
+			reason = policyReason
+		}
 	} else {
 		// The operator didn't set authorization policy, deny by default.
 		allowed = authorizer.DecisionDeny
 	}
 
 	delete(data, "spec")
-	data["status"] = map[string]interface{}{
+	status := map[string]interface{}{
 		"allowed": allowed == authorizer.DecisionAllow,
 	}
+	if reason != "" {
+		status["reason"] = reason
+	}
+	data["status"] = status
 	output, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -480,13 +498,22 @@ func NewKeystoneAuth(c *Config) (*Auth, error) {
 		}
 	}
 
+	var impersonation *impersonationConfig
+	if c.ImpersonationConfigFile != "" {
+		impersonation, err = newImpersonationConfigFromFile(c.ImpersonationConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the impersonation rules from file %s: %v", c.ImpersonationConfigFile, err)
+		}
+	}
+
 	keystoneAuth := &Auth{
-		authn:     &Authenticator{keystoner: NewKeystoner(keystoneClient)},
-		authz:     &Authorizer{authURL: c.KeystoneURL, client: keystoneClient, pl: policy},
-		syncer:    &Syncer{k8sClient: k8sClient, syncConfig: sc},
-		k8sClient: k8sClient,
-		config:    c,
-		stopCh:    make(chan struct{}),
+		authn:         &Authenticator{keystoner: NewKeystoner(keystoneClient), filter: c.authFilter()},
+		authz:         &Authorizer{authURL: c.KeystoneURL, client: keystoneClient, pl: policy},
+		syncer:        &Syncer{k8sClient: k8sClient, syncConfig: sc},
+		impersonation: impersonation,
+		k8sClient:     k8sClient,
+		config:        c,
+		stopCh:        make(chan struct{}),
 	}
 
 	if k8sClient != nil {
@@ -29,14 +29,16 @@ import (
 
 	"k8s.io/cloud-provider-openstack/pkg/ingress/config"
 	"k8s.io/cloud-provider-openstack/pkg/ingress/controller"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
 	"k8s.io/component-base/cli"
 	"k8s.io/klog/v2"
 )
 
 var (
-	cfgFile string
-	isDebug bool
-	conf    config.Config
+	cfgFile   string
+	isDebug   bool
+	conf      config.Config
+	debugOpts debug.Options
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -46,6 +48,18 @@ var rootCmd = &cobra.Command{
 	Long:  `Ingress controller for OpenStack`,
 
 	Run: func(cmd *cobra.Command, args []string) {
+		// The controller logs with logrus, debug from verbosity 4 as --debug
+		debug.OnVerbosityChange(func(level int) {
+			if level >= 4 {
+				log.SetLevel(log.DebugLevel)
+			} else if !isDebug {
+				log.SetLevel(log.InfoLevel)
+			}
+		})
+		if err := debug.Start(debugOpts); err != nil {
+			log.WithFields(log.Fields{"error": err}).Fatal("Failed to start debug endpoint")
+		}
+
 		osIngress := controller.NewController(conf)
 		osIngress.Start()
 
@@ -76,6 +90,7 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.ingress_openstack.yaml)")
 	rootCmd.PersistentFlags().BoolVar(&isDebug, "debug", false, "Print more detailed information.")
+	debugOpts.AddFlags(rootCmd.PersistentFlags())
 
 	klog.InitFlags(nil)
 }
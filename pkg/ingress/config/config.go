@@ -17,15 +17,18 @@ limitations under the License.
 package config
 
 import (
+	"time"
+
 	"k8s.io/cloud-provider-openstack/pkg/client"
 )
 
 // Config struct contains ingress controller configuration
 type Config struct {
-	ClusterName string          `mapstructure:"cluster-name"`
-	Kubernetes  kubeConfig      `mapstructure:"kubernetes"`
-	OpenStack   client.AuthOpts `mapstructure:"openstack"`
-	Octavia     octaviaConfig   `mapstructure:"octavia"`
+	ClusterName string           `mapstructure:"cluster-name"`
+	Kubernetes  kubeConfig       `mapstructure:"kubernetes"`
+	OpenStack   client.AuthOpts  `mapstructure:"openstack"`
+	Octavia     octaviaConfig    `mapstructure:"octavia"`
+	Controller  controllerConfig `mapstructure:"controller"`
 }
 
 // Configuration for connecting to Kubernetes API server, either api_host or kubeconfig should be configured.
@@ -50,7 +53,32 @@ type octaviaConfig struct {
 	// If empty, no floating IP will be allocated to the load balancer vip.
 	FloatingIPNetwork string `mapstructure:"floating-network-id"`
 
+	// (Optional) Named public networks to create floating IP, selected by the
+	// octavia.ingress.kubernetes.io/floating-network annotation of the Ingress.
+	// The names are case insensitive.
+	FloatingIPNetworks map[string]string `mapstructure:"floating-networks"`
+
 	// (Optional) If the ingress controller should manage the security groups attached to the cluster nodes.
 	// Default is false.
 	ManageSecurityGroups bool `mapstructure:"manage-security-groups"`
 }
+
+// Reconciliation related configuration
+type controllerConfig struct {
+	// (Optional) Period of the resync of the Ingresses, Services, ConfigMaps and nodes from the API server.
+	// Default: 30s
+	ResyncPeriod time.Duration `mapstructure:"resync-period"`
+
+	// (Optional) Period of the check of the nodes, whose changes update the members of the load balancers.
+	// Default: 60s
+	NodeSyncPeriod time.Duration `mapstructure:"node-sync-period"`
+
+	// (Optional) Maximum rate of the reconciles of the Ingresses per second. Default: 10
+	RateLimitQPS float64 `mapstructure:"rate-limit-qps"`
+
+	// (Optional) Number of reconciles allowed in a burst above rate-limit-qps. Default: 100
+	RateLimitBurst int `mapstructure:"rate-limit-burst"`
+
+	// (Optional) Number of load balancers whose members are updated in parallel when the nodes change. Default: 5
+	NodeSyncWorkers int `mapstructure:"node-sync-workers"`
+}
@@ -18,11 +18,7 @@ package controller
 
 import (
 	"context"
-	"crypto"
-	"crypto/rand"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"reflect"
@@ -34,12 +30,14 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	apiv1 "k8s.io/api/core/v1"
 	nwv1 "k8s.io/api/networking/v1"
 	apimetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
@@ -52,7 +50,6 @@ import (
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	klog "k8s.io/klog/v2"
-	pkcs12 "software.sslmate.com/src/go-pkcs12"
 
 	"k8s.io/cloud-provider-openstack/pkg/ingress/config"
 	"k8s.io/cloud-provider-openstack/pkg/ingress/controller/openstack"
@@ -70,6 +67,16 @@ const (
 
 	maxRetries = 5
 
+	// Defaults of the controller options, the resync period of the informers
+	// and the rate limit of the queue of client-go.
+	defaultResyncPeriod   = 30 * time.Second
+	defaultNodeSyncPeriod = 60 * time.Second
+	defaultRateLimitQPS   = 10
+	defaultRateLimitBurst = 100
+	// The number of load balancers whose members are updated in parallel
+	// on a node change.
+	defaultNodeSyncWorkers = 5
+
 	// CreateEvent event associated with new objects in an informer
 	CreateEvent EventType = "CREATE"
 	// UpdateEvent event associated with an object update in an informer
@@ -104,6 +111,43 @@ const (
 	// It should be a comma-separated list of CIDRs.
 	IngressAnnotationSourceRangesKey = "octavia.ingress.kubernetes.io/whitelist-source-range"
 
+	// IngressAnnotationFloatingNetwork is the annotation used on the Ingress to choose the public network of the
+	// floating ip, by its name in the floating-networks configuration option.
+	// Default to the floating-network-id configuration option.
+	IngressAnnotationFloatingNetwork = "octavia.ingress.kubernetes.io/floating-network"
+
+	// IngressAnnotationTCPServices is the annotation used on the Ingress to expose TCP services on the load balancer,
+	// by the name of a ConfigMap in the namespace of the Ingress mapping the ports to the services.
+	IngressAnnotationTCPServices = "octavia.ingress.kubernetes.io/tcp-services-configmap"
+
+	// IngressAnnotationUDPServices is the annotation used on the Ingress to expose UDP services on the load balancer,
+	// by the name of a ConfigMap in the namespace of the Ingress mapping the ports to the services.
+	IngressAnnotationUDPServices = "octavia.ingress.kubernetes.io/udp-services-configmap"
+
+	// IngressAnnotationClientCASecret is the annotation used on the TLS Ingress to require client certificates, by the
+	// name of a Secret in the namespace of the Ingress holding the CA certificates under the ca.crt key.
+	IngressAnnotationClientCASecret = "octavia.ingress.kubernetes.io/client-ca-secret"
+
+	// IngressAnnotationClientAuthentication is the annotation used on the TLS Ingress to choose the client
+	// authentication mode, OPTIONAL or MANDATORY.
+	// Default to MANDATORY.
+	IngressAnnotationClientAuthentication = "octavia.ingress.kubernetes.io/client-authentication"
+
+	// IngressAnnotationTimeoutClientData is the annotation used on the Ingress to set the frontend client inactivity
+	// timeout of the listener in milliseconds, e.g. for long-polling clients.
+	// Default to the Octavia default, 50000.
+	IngressAnnotationTimeoutClientData = "octavia.ingress.kubernetes.io/timeout-client-data"
+
+	// IngressAnnotationTimeoutMemberConnect is the annotation used on the Ingress to set the backend member connection
+	// timeout of the listener in milliseconds.
+	// Default to the Octavia default, 5000.
+	IngressAnnotationTimeoutMemberConnect = "octavia.ingress.kubernetes.io/timeout-member-connect"
+
+	// IngressAnnotationTimeoutMemberData is the annotation used on the Ingress to set the backend member inactivity
+	// timeout of the listener in milliseconds, e.g. for long-polling backends.
+	// Default to the Octavia default, 50000.
+	IngressAnnotationTimeoutMemberData = "octavia.ingress.kubernetes.io/timeout-member-data"
+
 	// IngressControllerTag is added to the related resources.
 	IngressControllerTag = "octavia.ingress.kubernetes.io"
 
@@ -111,6 +155,8 @@ const (
 	IngressSecretCertName = "tls.crt"
 	// IngressSecretKeyName is private key name defined in the secret data.
 	IngressSecretKeyName = "tls.key"
+	// IngressSecretCAName is CA certificate key name defined in the secret data.
+	IngressSecretCAName = "ca.crt"
 
 	// BarbicanSecretNameTemplate is the name format string to create Barbican secret.
 	BarbicanSecretNameTemplate = "kube_ingress_%s_%s_%s_%s"
@@ -127,21 +173,23 @@ type Event struct {
 
 // Controller ...
 type Controller struct {
-	stopCh              chan struct{}
-	knownNodes          []*apiv1.Node
-	queue               workqueue.RateLimitingInterface
-	informer            informers.SharedInformerFactory
-	recorder            record.EventRecorder
-	ingressLister       nwlisters.IngressLister
-	ingressListerSynced cache.InformerSynced
-	serviceLister       corelisters.ServiceLister
-	serviceListerSynced cache.InformerSynced
-	nodeLister          corelisters.NodeLister
-	nodeListerSynced    cache.InformerSynced
-	osClient            *openstack.OpenStack
-	kubeClient          kubernetes.Interface
-	config              config.Config
-	subnetCIDR          string
+	stopCh                chan struct{}
+	knownNodes            []*apiv1.Node
+	queue                 workqueue.RateLimitingInterface
+	informer              informers.SharedInformerFactory
+	recorder              record.EventRecorder
+	ingressLister         nwlisters.IngressLister
+	ingressListerSynced   cache.InformerSynced
+	serviceLister         corelisters.ServiceLister
+	serviceListerSynced   cache.InformerSynced
+	configMapLister       corelisters.ConfigMapLister
+	configMapListerSynced cache.InformerSynced
+	nodeLister            corelisters.NodeLister
+	nodeListerSynced      cache.InformerSynced
+	osClient              *openstack.OpenStack
+	kubeClient            kubernetes.Interface
+	config                config.Config
+	subnetCIDR            string
 }
 
 // IsValid returns true if the given Ingress either doesn't specify
@@ -255,8 +303,40 @@ func getNodeAddressForLB(node *apiv1.Node) (string, error) {
 	return addrs[0].Address, nil
 }
 
+// setControllerDefaults sets the defaults of the controller options not configured.
+func setControllerDefaults(conf *config.Config) {
+	if conf.Controller.ResyncPeriod <= 0 {
+		conf.Controller.ResyncPeriod = defaultResyncPeriod
+	}
+	if conf.Controller.NodeSyncPeriod <= 0 {
+		conf.Controller.NodeSyncPeriod = defaultNodeSyncPeriod
+	}
+	if conf.Controller.RateLimitQPS <= 0 {
+		conf.Controller.RateLimitQPS = defaultRateLimitQPS
+	}
+	if conf.Controller.RateLimitBurst <= 0 {
+		conf.Controller.RateLimitBurst = defaultRateLimitBurst
+	}
+	if conf.Controller.NodeSyncWorkers <= 0 {
+		conf.Controller.NodeSyncWorkers = defaultNodeSyncWorkers
+	}
+}
+
+// newRateLimiter returns the rate limiter of the queue, which retries a
+// failed Ingress with an exponential backoff like the default rate limiter of
+// client-go, and limits the overall rate of the reconciles to the configured
+// rate.
+func newRateLimiter(conf config.Config) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(5*time.Millisecond, 1000*time.Second),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(conf.Controller.RateLimitQPS), conf.Controller.RateLimitBurst)},
+	)
+}
+
 // NewController creates a new OpenStack Ingress controller.
 func NewController(conf config.Config) *Controller {
+	setControllerDefaults(&conf)
+
 	// initialize k8s client
 	kubeClient, err := createApiserverClient(conf.Kubernetes.ApiserverHost, conf.Kubernetes.KubeConfig)
 	if err != nil {
@@ -276,10 +356,11 @@ func NewController(conf config.Config) *Controller {
 		}).Fatal("failed to initialize openstack client")
 	}
 
-	kubeInformerFactory := informers.NewSharedInformerFactory(kubeClient, time.Second*30)
+	kubeInformerFactory := informers.NewSharedInformerFactory(kubeClient, conf.Controller.ResyncPeriod)
 	serviceInformer := kubeInformerFactory.Core().V1().Services()
 	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	configMapInformer := kubeInformerFactory.Core().V1().ConfigMaps()
+	queue := workqueue.NewRateLimitingQueue(newRateLimiter(conf))
 
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(klog.Infof)
@@ -289,18 +370,20 @@ func NewController(conf config.Config) *Controller {
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: "openstack-ingress-controller"})
 
 	controller := &Controller{
-		config:              conf,
-		queue:               queue,
-		stopCh:              make(chan struct{}),
-		informer:            kubeInformerFactory,
-		recorder:            recorder,
-		serviceLister:       serviceInformer.Lister(),
-		serviceListerSynced: serviceInformer.Informer().HasSynced,
-		nodeLister:          nodeInformer.Lister(),
-		nodeListerSynced:    nodeInformer.Informer().HasSynced,
-		knownNodes:          []*apiv1.Node{},
-		osClient:            osClient,
-		kubeClient:          kubeClient,
+		config:                conf,
+		queue:                 queue,
+		stopCh:                make(chan struct{}),
+		informer:              kubeInformerFactory,
+		recorder:              recorder,
+		serviceLister:         serviceInformer.Lister(),
+		serviceListerSynced:   serviceInformer.Informer().HasSynced,
+		configMapLister:       configMapInformer.Lister(),
+		configMapListerSynced: configMapInformer.Informer().HasSynced,
+		nodeLister:            nodeInformer.Lister(),
+		nodeListerSynced:      nodeInformer.Informer().HasSynced,
+		knownNodes:            []*apiv1.Node{},
+		osClient:              osClient,
+		kubeClient:            kubeClient,
 	}
 
 	ingInformer := kubeInformerFactory.Networking().V1().Ingresses()
@@ -373,6 +456,36 @@ func NewController(conf config.Config) *Controller {
 		},
 	})
 
+	// The Ingresses are updated when the ConfigMaps of their stream rules change.
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			controller.enqueueStreamIngresses(obj.(*apiv1.ConfigMap))
+		},
+		UpdateFunc: func(old, new interface{}) {
+			newCM := new.(*apiv1.ConfigMap)
+			if newCM.ResourceVersion == old.(*apiv1.ConfigMap).ResourceVersion {
+				return
+			}
+			controller.enqueueStreamIngresses(newCM)
+		},
+		DeleteFunc: func(obj interface{}) {
+			cm, ok := obj.(*apiv1.ConfigMap)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					log.Errorf("couldn't get object from tombstone %#v", obj)
+					return
+				}
+				cm, ok = tombstone.Obj.(*apiv1.ConfigMap)
+				if !ok {
+					log.Errorf("Tombstone contained object that is not a ConfigMap: %#v", obj)
+					return
+				}
+			}
+			controller.enqueueStreamIngresses(cm)
+		},
+	})
+
 	controller.ingressLister = ingInformer.Lister()
 	controller.ingressListerSynced = ingInformer.Informer().HasSynced
 
@@ -389,7 +502,7 @@ func (c *Controller) Start() {
 	go c.informer.Start(c.stopCh)
 
 	// wait for the caches to synchronize before starting the worker
-	if !cache.WaitForCacheSync(c.stopCh, c.ingressListerSynced, c.serviceListerSynced, c.nodeListerSynced) {
+	if !cache.WaitForCacheSync(c.stopCh, c.ingressListerSynced, c.serviceListerSynced, c.configMapListerSynced, c.nodeListerSynced) {
 		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
@@ -411,7 +524,7 @@ func (c *Controller) Start() {
 	c.subnetCIDR = subnet.CIDR
 
 	go wait.Until(c.runWorker, time.Second, c.stopCh)
-	go wait.Until(c.nodeSyncLoop, 60*time.Second, c.stopCh)
+	go wait.Until(c.nodeSyncLoop, c.config.Controller.NodeSyncPeriod, c.stopCh)
 
 	<-c.stopCh
 }
@@ -445,10 +558,12 @@ func (c *Controller) nodeSyncLoop() {
 		return
 	}
 
-	// Update each valid ingress
-	for _, ing := range ings.Items {
-		if !IsValid(&ing) {
-			continue
+	// Update each valid ingress, the load balancers of several ingresses are
+	// updated in parallel as each update waits for its load balancer to be ACTIVE.
+	workqueue.ParallelizeUntil(context.TODO(), c.config.Controller.NodeSyncWorkers, len(ings.Items), func(i int) {
+		ing := &ings.Items[i]
+		if !IsValid(ing) {
+			return
 		}
 
 		log.WithFields(log.Fields{"ingress": ing.Name, "namespace": ing.Namespace}).Debug("Starting to handle ingress")
@@ -461,16 +576,16 @@ func (c *Controller) nodeSyncLoop() {
 			}
 
 			// If lb doesn't exist or error occurred, continue
-			continue
+			return
 		}
 
 		if err = c.osClient.UpdateLoadbalancerMembers(loadbalancer.ID, readyWorkerNodes); err != nil {
-			log.WithFields(log.Fields{"ingress": ing.Name}).Error("Failed to handle ingress")
-			continue
+			log.WithFields(log.Fields{"ingress": ing.Name}).Errorf("Failed to handle ingress: %v", err)
+			return
 		}
 
 		log.WithFields(log.Fields{"ingress": ing.Name, "namespace": ing.Namespace}).Info("Finished to handle ingress")
-	}
+	})
 
 	c.knownNodes = readyWorkerNodes
 
@@ -626,41 +741,89 @@ func (c *Controller) toBarbicanSecret(name string, namespace string, toSecretNam
 		return "", err
 	}
 
-	var pk crypto.PrivateKey
-	if keyBytes, isPresent := secret.Data[IngressSecretKeyName]; isPresent {
-		pk, err = privateKeyFromPEM(keyBytes)
-		if err != nil {
-			return "", err
-		}
-	} else {
+	keyBytes, isPresent := secret.Data[IngressSecretKeyName]
+	if !isPresent {
 		return "", fmt.Errorf("%s key doesn't exist in the secret %s", IngressSecretKeyName, name)
 	}
-
-	var cb []*x509.Certificate
-	if certBytes, isPresent := secret.Data[IngressSecretCertName]; isPresent {
-		cb, err = parsePEMBundle(certBytes)
-		if err != nil {
-			return "", err
-		}
-	} else {
+	certBytes, isPresent := secret.Data[IngressSecretCertName]
+	if !isPresent {
 		return "", fmt.Errorf("%s key doesn't exist in the secret %s", IngressSecretCertName, name)
 	}
 
-	var caCerts []*x509.Certificate
-	// We assume that the rest of the PEM bundle contains the CA certificate.
-	if len(cb) > 1 {
-		caCerts = append(caCerts, cb[1:]...)
+	encoded, err := openstackutil.EncodePKCS12(certBytes, keyBytes)
+	if err != nil {
+		return "", err
 	}
 
-	pfxData, err := pkcs12.Encode(rand.Reader, pk, cb[0], caCerts, "")
+	return openstackutil.EnsureSecret(c.osClient.Barbican, toSecretName, "application/octet-stream", encoded)
+}
+
+// toBarbicanCASecret uploads the CA certificates of the Secret to Barbican as a PEM bundle.
+func (c *Controller) toBarbicanCASecret(name string, namespace string, toSecretName string) (string, error) {
+	secret, err := c.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), name, apimetav1.GetOptions{})
 	if err != nil {
-		return "", fmt.Errorf("failed to create PKCS#12 bundle: %v", err)
+		return "", err
+	}
+
+	caBytes, isPresent := secret.Data[IngressSecretCAName]
+	if !isPresent {
+		return "", fmt.Errorf("%s key doesn't exist in the secret %s", IngressSecretCAName, name)
+	}
+	if _, err := openstackutil.ParsePEMBundle(caBytes); err != nil {
+		return "", err
 	}
-	encoded := base64.StdEncoding.EncodeToString(pfxData)
+	encoded := base64.StdEncoding.EncodeToString(caBytes)
 
 	return openstackutil.EnsureSecret(c.osClient.Barbican, toSecretName, "application/octet-stream", encoded)
 }
 
+// getListenerClientAuth returns the client authentication of the TLS Ingress listener chosen by the annotations, nil
+// if client certificates are not required.
+func (c *Controller) getListenerClientAuth(ing *nwv1.Ingress) (*openstack.ListenerClientAuth, error) {
+	caSecret := getStringFromIngressAnnotation(ing, IngressAnnotationClientCASecret, "")
+	if caSecret == "" {
+		return nil, nil
+	}
+	if len(ing.Spec.TLS) == 0 {
+		return nil, fmt.Errorf("annotation %s is only supported for TLS Ingress", IngressAnnotationClientCASecret)
+	}
+
+	authentication := strings.ToUpper(getStringFromIngressAnnotation(ing, IngressAnnotationClientAuthentication, "MANDATORY"))
+	if authentication != "MANDATORY" && authentication != "OPTIONAL" {
+		return nil, fmt.Errorf("invalid value %q of annotation %s, must be OPTIONAL or MANDATORY", authentication, IngressAnnotationClientAuthentication)
+	}
+
+	secretName := fmt.Sprintf(BarbicanSecretNameTemplate, c.config.ClusterName, ing.Namespace, ing.Name, "client_ca_"+caSecret)
+	secretRef, err := c.toBarbicanCASecret(caSecret, ing.Namespace, secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Barbican secret of the client CA: %v", err)
+	}
+	log.WithFields(log.Fields{"secretName": secretName, "secretRef": secretRef}).Info("client CA secret created in Barbican")
+
+	return &openstack.ListenerClientAuth{CATLSContainerRef: secretRef, Authentication: authentication}, nil
+}
+
+// getListenerTimeouts returns the timeouts of the Ingress listener set by the annotations.
+func getListenerTimeouts(ing *nwv1.Ingress) (openstack.ListenerTimeouts, error) {
+	var timeouts openstack.ListenerTimeouts
+	for annotation, timeout := range map[string]**int{
+		IngressAnnotationTimeoutClientData:    &timeouts.ClientData,
+		IngressAnnotationTimeoutMemberConnect: &timeouts.MemberConnect,
+		IngressAnnotationTimeoutMemberData:    &timeouts.MemberData,
+	} {
+		value := getStringFromIngressAnnotation(ing, annotation, "")
+		if value == "" {
+			continue
+		}
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return timeouts, fmt.Errorf("invalid value %q of annotation %s, must be a number of milliseconds", value, annotation)
+		}
+		*timeout = &ms
+	}
+	return timeouts, nil
+}
+
 func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	ingName := ing.ObjectMeta.Name
 	ingNamespace := ing.ObjectMeta.Namespace
@@ -673,6 +836,30 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 		return fmt.Errorf("TLS Ingress not supported because of Key Manager service unavailable")
 	}
 
+	internalSetting := getStringFromIngressAnnotation(ing, IngressAnnotationInternal, "true")
+	isInternal, err := strconv.ParseBool(internalSetting)
+	if err != nil {
+		return fmt.Errorf("unknown annotation %s: %v", IngressAnnotationInternal, err)
+	}
+
+	var floatingIPNetwork string
+	if !isInternal {
+		floatingIPNetwork, err = c.getFloatingIPNetwork(ing)
+		if err != nil {
+			return err
+		}
+	}
+
+	streamRules, streamVersion, err := c.getStreamRules(ing)
+	if err != nil {
+		return err
+	}
+	// The load balancer is updated when the ConfigMaps of the stream rules change too.
+	var streamDescription string
+	if streamVersion != "" {
+		streamDescription = fmt.Sprintf(", streams version: %s", streamVersion)
+	}
+
 	lb, err := c.osClient.EnsureLoadBalancer(resName, c.config.Octavia.SubnetID, ingNamespace, ingName, clusterName)
 	if err != nil {
 		return err
@@ -680,12 +867,13 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 
 	logger := log.WithFields(log.Fields{"ingress": ingfullName, "lbID": lb.ID})
 
-	if strings.Contains(lb.Description, ing.ResourceVersion) {
+	if strings.Contains(lb.Description, ing.ResourceVersion) && strings.Contains(lb.Description, streamDescription) {
 		logger.Info("ingress not changed")
 		return nil
 	}
 
 	var nodePorts []int
+	var udpNodePorts []int
 	var sgID string
 
 	if c.config.Octavia.ManageSecurityGroups {
@@ -718,11 +906,19 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	if len(secretRefs) > 0 {
 		port = 443
 	}
+	clientAuth, err := c.getListenerClientAuth(ing)
+	if err != nil {
+		return err
+	}
+	timeouts, err := getListenerTimeouts(ing)
+	if err != nil {
+		return err
+	}
 
 	// Create listener
 	sourceRanges := getStringFromIngressAnnotation(ing, IngressAnnotationSourceRangesKey, "0.0.0.0/0")
 	listenerAllowedCIDRs := strings.Split(sourceRanges, ",")
-	listener, err := c.osClient.EnsureListener(resName, lb.ID, secretRefs, listenerAllowedCIDRs)
+	listener, err := c.osClient.EnsureListener(resName, lb.ID, secretRefs, listenerAllowedCIDRs, clientAuth, timeouts)
 	if err != nil {
 		return err
 	}
@@ -782,7 +978,7 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 		poolName := utils.Hash(fmt.Sprintf("%s+%s", ing.Spec.DefaultBackend.Service.Name, ing.Spec.DefaultBackend.Service.Port.String()))
 
 		serviceName := fmt.Sprintf("%s/%s", ingNamespace, ing.Spec.DefaultBackend.Service.Name)
-		nodePort, err := c.getServiceNodePort(serviceName, ing.Spec.DefaultBackend.Service)
+		nodePort, err := c.getServiceNodePort(serviceName, ing.Spec.DefaultBackend.Service, apiv1.ProtocolTCP)
 		if err != nil {
 			return err
 		}
@@ -827,7 +1023,7 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 			poolName := utils.Hash(fmt.Sprintf("%s+%s", path.Backend.Service.Name, path.Backend.Service.Port.String()))
 
 			serviceName := fmt.Sprintf("%s/%s", ingNamespace, path.Backend.Service.Name)
-			nodePort, err := c.getServiceNodePort(serviceName, path.Backend.Service)
+			nodePort, err := c.getServiceNodePort(serviceName, path.Backend.Service, apiv1.ProtocolTCP)
 			if err != nil {
 				return err
 			}
@@ -870,6 +1066,52 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 		}
 	}
 
+	// Add a TCP or UDP listener for each stream rule, with a default pool forwarding to the service.
+	streamListeners := sets.NewString()
+	for i := range streamRules {
+		rule := &streamRules[i]
+		if rule.protocol == string(apiv1.ProtocolTCP) && rule.port == port {
+			return fmt.Errorf("TCP port %d of the stream rules is the port of the HTTP listener", rule.port)
+		}
+
+		nodePort, err := c.getServiceNodePort(rule.service, &rule.backend, apiv1.Protocol(rule.protocol))
+		if err != nil {
+			return err
+		}
+		if rule.protocol == string(apiv1.ProtocolUDP) {
+			udpNodePorts = append(udpNodePorts, nodePort)
+		} else {
+			nodePorts = append(nodePorts, nodePort)
+		}
+
+		listenerName := rule.listenerName(resName)
+		streamListener, err := c.osClient.EnsureStreamListener(listenerName, lb.ID, rule.protocol, rule.port, listenerAllowedCIDRs)
+		if err != nil {
+			return err
+		}
+		streamListeners.Insert(listenerName)
+
+		var members = make([]pools.BatchUpdateMemberOpts, len(updateMemberOpts))
+		copy(members, updateMemberOpts)
+		for index := range members {
+			members[index].ProtocolPort = nodePort
+		}
+
+		// The pool is named after the listener, so that the service of the port can be changed.
+		poolName := utils.Hash(fmt.Sprintf("%s+%d", rule.protocol, rule.port))
+		newPools = append(newPools, openstack.IngPool{
+			Name: poolName,
+			Opts: pools.CreateOpts{
+				Name:        poolName,
+				Protocol:    pools.Protocol(rule.protocol),
+				LBMethod:    pools.LBMethodRoundRobin,
+				ListenerID:  streamListener.ID,
+				Persistence: nil,
+			},
+			PoolMembers: members,
+		})
+	}
+
 	// Reconsile octavia resources.
 	rt := openstack.NewResourceTracker(ingfullName, c.osClient.Octavia, lb.ID, listener.ID, newPools, newPolicies, existingPools, oldPolicies)
 	if err := rt.CreateResources(); err != nil {
@@ -878,11 +1120,17 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	if err := rt.CleanupResources(); err != nil {
 		return err
 	}
+	if err := c.osClient.DeleteStreamListeners(resName+"_", lb.ID, streamListeners); err != nil {
+		return err
+	}
 
 	if c.config.Octavia.ManageSecurityGroups {
 		logger.WithFields(log.Fields{"sgID": sgID}).Info("ensuring security group rules")
 
-		if err := c.osClient.EnsureSecurityGroupRules(sgID, c.subnetCIDR, nodePorts); err != nil {
+		if err := c.osClient.EnsureSecurityGroupRules(sgID, c.subnetCIDR, "tcp", nodePorts); err != nil {
+			return fmt.Errorf("failed to ensure security group rules for Ingress %s: %v", ingName, err)
+		}
+		if err := c.osClient.EnsureSecurityGroupRules(sgID, c.subnetCIDR, "udp", udpNodePorts); err != nil {
 			return fmt.Errorf("failed to ensure security group rules for Ingress %s: %v", ingName, err)
 		}
 
@@ -893,19 +1141,13 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 		logger.WithFields(log.Fields{"sgID": sgID}).Info("ensured security group rules")
 	}
 
-	internalSetting := getStringFromIngressAnnotation(ing, IngressAnnotationInternal, "true")
-	isInternal, err := strconv.ParseBool(internalSetting)
-	if err != nil {
-		return fmt.Errorf("unknown annotation %s: %v", IngressAnnotationInternal, err)
-	}
-
 	address := lb.VipAddress
 	// Allocate floating ip for loadbalancer vip if the external network is configured and the Ingress is not internal.
-	if !isInternal && c.config.Octavia.FloatingIPNetwork != "" {
+	if !isInternal && floatingIPNetwork != "" {
 		logger.Info("creating floating IP")
 
 		description := fmt.Sprintf("Floating IP for Kubernetes ingress %s in namespace %s from cluster %s", ingName, ingNamespace, clusterName)
-		address, err = c.osClient.EnsureFloatingIP(false, lb.VipPortID, c.config.Octavia.FloatingIPNetwork, description)
+		address, err = c.osClient.EnsureFloatingIP(false, lb.VipPortID, floatingIPNetwork, description)
 		if err != nil {
 			return fmt.Errorf("failed to create floating IP: %v", err)
 		}
@@ -921,7 +1163,7 @@ func (c *Controller) ensureIngress(ing *nwv1.Ingress) error {
 	c.recorder.Event(ing, apiv1.EventTypeNormal, "Updated", fmt.Sprintf("Successfully associated IP address %s to ingress %s", address, ingfullName))
 
 	// Add ingress resource version to the load balancer description
-	newDes := fmt.Sprintf("Kubernetes Ingress %s in namespace %s from cluster %s, version: %s", ingName, ingNamespace, clusterName, newIng.ResourceVersion)
+	newDes := fmt.Sprintf("Kubernetes Ingress %s in namespace %s from cluster %s, version: %s%s", ingName, ingNamespace, clusterName, newIng.ResourceVersion, streamDescription)
 	if err = c.osClient.UpdateLoadBalancerDescription(lb.ID, newDes); err != nil {
 		return err
 	}
@@ -959,7 +1201,7 @@ func (c *Controller) getService(key string) (*apiv1.Service, error) {
 	return service, nil
 }
 
-func (c *Controller) getServiceNodePort(name string, serviceBackend *nwv1.IngressServiceBackend) (int, error) {
+func (c *Controller) getServiceNodePort(name string, serviceBackend *nwv1.IngressServiceBackend, protocol apiv1.Protocol) (int, error) {
 	var portInfo intstr.IntOrString
 	if serviceBackend.Port.Name != "" {
 		portInfo.Type = intstr.String
@@ -981,6 +1223,9 @@ func (c *Controller) getServiceNodePort(name string, serviceBackend *nwv1.Ingres
 	var nodePort int
 	ports := svc.Spec.Ports
 	for _, p := range ports {
+		if p.Protocol != protocol && (p.Protocol != "" || protocol != apiv1.ProtocolTCP) {
+			continue
+		}
 		if portInfo.Type == intstr.Int && int(p.Port) == portInfo.IntValue() {
 			nodePort = int(p.NodePort)
 			break
@@ -1000,6 +1245,25 @@ func (c *Controller) getServiceNodePort(name string, serviceBackend *nwv1.Ingres
 	return nodePort, nil
 }
 
+// getFloatingIPNetwork returns the ID of the public network to allocate the floating ip of the Ingress from. The
+// network chosen by the annotation must be configured in floating-networks and be an external network.
+func (c *Controller) getFloatingIPNetwork(ing *nwv1.Ingress) (string, error) {
+	name := getStringFromIngressAnnotation(ing, IngressAnnotationFloatingNetwork, "")
+	if name == "" {
+		return c.config.Octavia.FloatingIPNetwork, nil
+	}
+
+	networkID, ok := c.config.Octavia.FloatingIPNetworks[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("floating network %q of annotation %s is not configured", name, IngressAnnotationFloatingNetwork)
+	}
+	if err := c.osClient.ValidateFloatingIPNetwork(networkID); err != nil {
+		return "", fmt.Errorf("invalid floating network %q of annotation %s: %v", name, IngressAnnotationFloatingNetwork, err)
+	}
+
+	return networkID, nil
+}
+
 // getStringFromIngressAnnotation searches a given Ingress for a specific annotationKey and either returns the
 // annotation's value or a specified defaultSetting
 func getStringFromIngressAnnotation(ingress *nwv1.Ingress, annotationKey string, defaultValue string) string {
@@ -1009,53 +1273,3 @@ func getStringFromIngressAnnotation(ingress *nwv1.Ingress, annotationKey string,
 
 	return defaultValue
 }
-
-// privateKeyFromPEM converts a PEM block into a crypto.PrivateKey.
-func privateKeyFromPEM(pemData []byte) (crypto.PrivateKey, error) {
-	var result *pem.Block
-	rest := pemData
-	for {
-		result, rest = pem.Decode(rest)
-		if result == nil {
-			return nil, fmt.Errorf("cannot decode supplied PEM data")
-		}
-
-		switch result.Type {
-		case "RSA PRIVATE KEY":
-			return x509.ParsePKCS1PrivateKey(result.Bytes)
-		case "EC PRIVATE KEY":
-			return x509.ParseECPrivateKey(result.Bytes)
-		case "PRIVATE KEY":
-			return x509.ParsePKCS8PrivateKey(result.Bytes)
-		}
-	}
-}
-
-// parsePEMBundle parses a certificate bundle from top to bottom and returns
-// a slice of x509 certificates. This function will error if no certificates are found.
-//
-func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
-	var certificates []*x509.Certificate
-	var certDERBlock *pem.Block
-
-	for {
-		certDERBlock, bundle = pem.Decode(bundle)
-		if certDERBlock == nil {
-			break
-		}
-
-		if certDERBlock.Type == "CERTIFICATE" {
-			cert, err := x509.ParseCertificate(certDERBlock.Bytes)
-			if err != nil {
-				return nil, err
-			}
-			certificates = append(certificates, cert)
-		}
-	}
-
-	if len(certificates) == 0 {
-		return nil, fmt.Errorf("no certificates were found while parsing the bundle")
-	}
-
-	return certificates, nil
-}
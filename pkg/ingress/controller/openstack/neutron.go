@@ -22,9 +22,11 @@ import (
 	"strings"
 
 	neutrontags "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/external"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	log "github.com/sirupsen/logrus"
@@ -93,6 +95,15 @@ func (os *OpenStack) EnsureFloatingIP(needDelete bool, portID string, floatingIP
 		return "", fmt.Errorf("more than one floating IPs for port %s found", portID)
 	}
 
+	// Replace the floating IP if the Ingress moved to another public network.
+	if len(fips) == 1 && fips[0].FloatingNetworkID != floatingIPNetwork {
+		log.WithFields(log.Fields{"fip": fips[0].FloatingIP, "network": floatingIPNetwork}).Info("replacing floating IP from another network")
+		if err := floatingips.Delete(os.neutron, fips[0].ID).ExtractErr(); err != nil {
+			return "", err
+		}
+		fips = nil
+	}
+
 	var fip *floatingips.FloatingIP
 	if len(fips) == 0 {
 		floatIPOpts := floatingips.CreateOpts{
@@ -111,6 +122,22 @@ func (os *OpenStack) EnsureFloatingIP(needDelete bool, portID string, floatingIP
 	return fip.FloatingIP, nil
 }
 
+// ValidateFloatingIPNetwork checks that the network exists and is an external network.
+func (os *OpenStack) ValidateFloatingIPNetwork(networkID string) error {
+	var network struct {
+		networks.Network
+		external.NetworkExternalExt
+	}
+	if err := networks.Get(os.neutron, networkID).ExtractInto(&network); err != nil {
+		return err
+	}
+	if !network.External {
+		return fmt.Errorf("network %s is not an external network", networkID)
+	}
+
+	return nil
+}
+
 // GetSecurityGroups gets all the filtered security groups.
 func (os *OpenStack) GetSecurityGroups(listOpts groups.ListOpts) ([]groups.SecGroup, error) {
 	allPages, err := groups.List(os.neutron, listOpts).AllPages()
@@ -179,10 +206,11 @@ func (os *OpenStack) EnsureSecurityGroup(needDelete bool, name string, descripti
 	return group.ID, nil
 }
 
-// EnsureSecurityGroupRules ensures the only dstPorts are allowed in the given security group.
-func (os *OpenStack) EnsureSecurityGroupRules(sgID string, sourceIP string, dstPorts []int) error {
+// EnsureSecurityGroupRules ensures the only dstPorts are allowed for the protocol, tcp or udp, in the given security
+// group.
+func (os *OpenStack) EnsureSecurityGroupRules(sgID string, sourceIP string, protocol string, dstPorts []int) error {
 	listOpts := rules.ListOpts{
-		Protocol:       "tcp",
+		Protocol:       protocol,
 		SecGroupID:     sgID,
 		RemoteIPPrefix: sourceIP,
 	}
@@ -238,7 +266,7 @@ func (os *OpenStack) EnsureSecurityGroupRules(sgID string, sourceIP string, dstP
 			PortRangeMin:   newPort,
 			PortRangeMax:   newPort,
 			EtherType:      rules.EtherType4,
-			Protocol:       "tcp",
+			Protocol:       rules.RuleProtocol(protocol),
 			RemoteIPPrefix: sourceIP,
 			SecGroupID:     sgID,
 		}
@@ -79,6 +79,81 @@ type IngPool struct {
 	PoolMembers []pools.BatchUpdateMemberOpts
 }
 
+// ListenerClientAuth is the TLS client authentication of a TERMINATED_HTTPS listener, supported since Octavia API
+// v2.8.
+type ListenerClientAuth struct {
+	// CATLSContainerRef is the Barbican secret of the CA certificates the client certificates are verified with.
+	CATLSContainerRef string
+	// Authentication is the client authentication mode, OPTIONAL or MANDATORY.
+	Authentication string
+}
+
+// ListenerTimeouts are the timeouts of a listener in milliseconds, the ones which are nil are left to the Octavia
+// defaults, or to their current value.
+type ListenerTimeouts struct {
+	ClientData    *int
+	MemberConnect *int
+	MemberData    *int
+}
+
+// setUpdateOpts sets the timeouts which differ from the ones of the listener in the update options, and returns
+// whether there are any.
+func (timeouts ListenerTimeouts) setUpdateOpts(listener *listeners.Listener, opts *listeners.UpdateOpts) bool {
+	changed := false
+	if timeouts.ClientData != nil && *timeouts.ClientData != listener.TimeoutClientData {
+		opts.TimeoutClientData = timeouts.ClientData
+		changed = true
+	}
+	if timeouts.MemberConnect != nil && *timeouts.MemberConnect != listener.TimeoutMemberConnect {
+		opts.TimeoutMemberConnect = timeouts.MemberConnect
+		changed = true
+	}
+	if timeouts.MemberData != nil && *timeouts.MemberData != listener.TimeoutMemberData {
+		opts.TimeoutMemberData = timeouts.MemberData
+		changed = true
+	}
+	return changed
+}
+
+// listenerClientAuth holds the client authentication fields of a listener, which gophercloud does not support yet.
+type listenerClientAuth struct {
+	ClientCATLSContainerRef *string `json:"client_ca_tls_container_ref"`
+	ClientAuthentication    string  `json:"client_authentication,omitempty"`
+}
+
+// listenerCreateOpts adds the client authentication to the listener create options.
+type listenerCreateOpts struct {
+	listeners.CreateOpts
+	clientAuth listenerClientAuth
+}
+
+// ToListenerCreateMap builds a request body from listenerCreateOpts.
+func (opts listenerCreateOpts) ToListenerCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToListenerCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	addListenerClientAuth(b, opts.clientAuth)
+	return b, nil
+}
+
+func addListenerClientAuth(b map[string]interface{}, clientAuth listenerClientAuth) {
+	listener := b["listener"].(map[string]interface{})
+	listener["client_ca_tls_container_ref"] = clientAuth.ClientCATLSContainerRef
+	listener["client_authentication"] = clientAuth.ClientAuthentication
+}
+
+// toListenerClientAuth returns the client authentication fields of the listener, none if clientAuth is nil.
+func toListenerClientAuth(clientAuth *ListenerClientAuth) listenerClientAuth {
+	if clientAuth == nil {
+		return listenerClientAuth{ClientAuthentication: "NONE"}
+	}
+	return listenerClientAuth{
+		ClientCATLSContainerRef: &clientAuth.CATLSContainerRef,
+		ClientAuthentication:    clientAuth.Authentication,
+	}
+}
+
 // ResourceTracker tracks the resources created for Ingress.
 type ResourceTracker struct {
 	client *gophercloud.ServiceClient
@@ -174,6 +249,19 @@ func (rt *ResourceTracker) CreateResources() error {
 
 		poolMapping[pool.Name] = poolID
 
+		// The members of the existing pools are only updated when they
+		// change, as each update waits for the load balancer to be ACTIVE.
+		if isPresent {
+			members, err := openstackutil.GetMembersbyPool(rt.client, poolID)
+			if err != nil {
+				return fmt.Errorf("failed to get members of pool %s, error: %v", poolID, err)
+			}
+			if poolMembersEqual(members, pool.PoolMembers) {
+				rt.logger.WithFields(log.Fields{"poolName": pool.Name, "poolID": poolID}).Debug("pool members up to date")
+				continue
+			}
+		}
+
 		rt.logger.WithFields(log.Fields{"poolName": pool.Name, "poolID": poolID}).Info("updating pool members")
 		if err := openstackutil.BatchUpdatePoolMembers(rt.client, rt.lbID, poolID, pool.PoolMembers); err != nil {
 			return fmt.Errorf("failed to update pool members, error: %v", err)
@@ -224,6 +312,28 @@ func (rt *ResourceTracker) CreateResources() error {
 	return nil
 }
 
+// poolMembersEqual reports whether the members of a pool are the members of
+// the batch update.
+func poolMembersEqual(members []pools.Member, opts []pools.BatchUpdateMemberOpts) bool {
+	if len(members) != len(opts) {
+		return false
+	}
+	current := sets.NewString()
+	for _, m := range members {
+		current.Insert(fmt.Sprintf("%s|%s|%d", m.Name, m.Address, m.ProtocolPort))
+	}
+	for _, opt := range opts {
+		var name string
+		if opt.Name != nil {
+			name = *opt.Name
+		}
+		if !current.Has(fmt.Sprintf("%s|%s|%d", name, opt.Address, opt.ProtocolPort)) {
+			return false
+		}
+	}
+	return true
+}
+
 func (rt *ResourceTracker) CleanupResources() error {
 	for key, oldPolicy := range rt.oldPolicyMapping {
 		poolID, isPresent := rt.newPolicyRuleMapping[key]
@@ -336,7 +446,7 @@ func (os *OpenStack) UpdateLoadBalancerDescription(lbID string, newDescription s
 }
 
 // EnsureListener creates a loadbalancer listener in octavia if it does not exist, wait for the loadbalancer to be ACTIVE.
-func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []string, listenerAllowedCIDRs []string) (*listeners.Listener, error) {
+func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []string, listenerAllowedCIDRs []string, clientAuth *ListenerClientAuth, timeouts ListenerTimeouts) (*listeners.Listener, error) {
 	listener, err := openstackutil.GetListenerByName(os.Octavia, name, lbID)
 	if err != nil {
 		if err != openstackutil.ErrNotFound {
@@ -350,6 +460,10 @@ func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []strin
 			Protocol:       "HTTP",
 			ProtocolPort:   80, // Ingress Controller only supports http/https for now
 			LoadbalancerID: lbID,
+
+			TimeoutClientData:    timeouts.ClientData,
+			TimeoutMemberConnect: timeouts.MemberConnect,
+			TimeoutMemberData:    timeouts.MemberData,
 		}
 		if len(secretRefs) > 0 {
 			opts.DefaultTlsContainerRef = secretRefs[0]
@@ -360,22 +474,36 @@ func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []strin
 		if len(listenerAllowedCIDRs) > 0 {
 			opts.AllowedCIDRs = listenerAllowedCIDRs
 		}
-		listener, err = listeners.Create(os.Octavia, opts).Extract()
+		var createOpts listeners.CreateOptsBuilder = opts
+		if clientAuth != nil {
+			createOpts = listenerCreateOpts{CreateOpts: opts, clientAuth: toListenerClientAuth(clientAuth)}
+		}
+		listener, err = listeners.Create(os.Octavia, createOpts).Extract()
 		if err != nil {
 			return nil, fmt.Errorf("error creating listener: %v", err)
 		}
 
 		log.WithFields(log.Fields{"lbID": lbID, "listenerName": name}).Info("listener created")
 	} else {
+		var updateOpts listeners.UpdateOpts
+		updated := timeouts.setUpdateOpts(listener, &updateOpts)
 		if len(listenerAllowedCIDRs) > 0 && !reflect.DeepEqual(listener.AllowedCIDRs, listenerAllowedCIDRs) {
-			_, err := listeners.Update(os.Octavia, listener.ID, listeners.UpdateOpts{
-				AllowedCIDRs: &listenerAllowedCIDRs,
-			}).Extract()
+			updateOpts.AllowedCIDRs = &listenerAllowedCIDRs
+			updated = true
+		}
+		if updated {
+			_, err := listeners.Update(os.Octavia, listener.ID, updateOpts).Extract()
 			if err != nil {
-				return nil, fmt.Errorf("failed to update listener allowed CIDRs: %v", err)
+				return nil, fmt.Errorf("failed to update listener allowed CIDRs and timeouts: %v", err)
 			}
 
-			log.WithFields(log.Fields{"listenerID": listener.ID}).Debug("listener allowed CIDRs updated")
+			log.WithFields(log.Fields{"listenerID": listener.ID}).Debug("listener allowed CIDRs and timeouts updated")
+		}
+
+		if listener.Protocol == "TERMINATED_HTTPS" {
+			if err := os.ensureListenerClientAuth(lbID, listener.ID, clientAuth); err != nil {
+				return nil, err
+			}
 		}
 	}
 
@@ -387,6 +515,103 @@ func (os *OpenStack) EnsureListener(name string, lbID string, secretRefs []strin
 	return listener, nil
 }
 
+// ensureListenerClientAuth updates the client authentication of the listener if it changed.
+func (os *OpenStack) ensureListenerClientAuth(lbID, listenerID string, clientAuth *ListenerClientAuth) error {
+	var current listenerClientAuth
+	if err := listeners.Get(os.Octavia, listenerID).ExtractIntoStructPtr(&current, "listener"); err != nil {
+		return fmt.Errorf("failed to get listener %s: %v", listenerID, err)
+	}
+	if current.ClientAuthentication == "" {
+		current.ClientAuthentication = "NONE"
+	}
+
+	expected := toListenerClientAuth(clientAuth)
+	if current.ClientAuthentication == expected.ClientAuthentication && reflect.DeepEqual(current.ClientCATLSContainerRef, expected.ClientCATLSContainerRef) {
+		return nil
+	}
+
+	// The listener may still be updating its allowed CIDRs.
+	if _, err := os.waitLoadbalancerActiveProvisioningStatus(lbID); err != nil {
+		return fmt.Errorf("loadbalancer %s not in ACTIVE status before updating listener, error: %v", lbID, err)
+	}
+
+	// listeners.Update only accepts listeners.UpdateOpts, so the request is sent directly.
+	b := map[string]interface{}{"listener": map[string]interface{}{}}
+	addListenerClientAuth(b, expected)
+	var r listeners.UpdateResult
+	resp, err := os.Octavia.Put(os.Octavia.ServiceURL("lbaas", "listeners", listenerID), b, &r.Body, &gophercloud.RequestOpts{
+		OkCodes: []int{200, 202},
+	})
+	_, r.Header, r.Err = gophercloud.ParseResponse(resp, err)
+	if _, err := r.Extract(); err != nil {
+		return fmt.Errorf("failed to update listener client authentication: %v", err)
+	}
+	log.WithFields(log.Fields{"listenerID": listenerID, "clientAuthentication": expected.ClientAuthentication}).Debug("listener client authentication updated")
+
+	return nil
+}
+
+// EnsureStreamListener creates the TCP or UDP listener of a stream rule if it does not exist, and updates its allowed
+// CIDRs otherwise.
+func (os *OpenStack) EnsureStreamListener(name string, lbID string, protocol string, port int, allowedCIDRs []string) (*listeners.Listener, error) {
+	listener, err := openstackutil.GetListenerByName(os.Octavia, name, lbID)
+	if err != nil {
+		if err != openstackutil.ErrNotFound {
+			return nil, fmt.Errorf("error getting listener %s: %v", name, err)
+		}
+
+		log.WithFields(log.Fields{"lbID": lbID, "listenerName": name}).Info("creating stream listener")
+
+		opts := listeners.CreateOpts{
+			Name:           name,
+			Protocol:       listeners.Protocol(protocol),
+			ProtocolPort:   port,
+			LoadbalancerID: lbID,
+			AllowedCIDRs:   allowedCIDRs,
+		}
+		listener, err = openstackutil.CreateListener(os.Octavia, lbID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error creating stream listener %s: %v", name, err)
+		}
+
+		log.WithFields(log.Fields{"lbID": lbID, "listenerName": name}).Info("stream listener created")
+		return listener, nil
+	}
+
+	if len(allowedCIDRs) > 0 && !reflect.DeepEqual(listener.AllowedCIDRs, allowedCIDRs) {
+		if err := openstackutil.UpdateListener(os.Octavia, lbID, listener.ID, listeners.UpdateOpts{AllowedCIDRs: &allowedCIDRs}); err != nil {
+			return nil, fmt.Errorf("failed to update stream listener allowed CIDRs: %v", err)
+		}
+
+		log.WithFields(log.Fields{"listenerID": listener.ID}).Debug("stream listener allowed CIDRs updated")
+	}
+
+	return listener, nil
+}
+
+// DeleteStreamListeners deletes the listeners of the load balancer whose name starts with prefix, except the ones in
+// keep, i.e. the listeners of the stream rules removed from the Ingress.
+func (os *OpenStack) DeleteStreamListeners(prefix string, lbID string, keep sets.String) error {
+	lbListeners, err := openstackutil.GetListenersByLoadBalancerID(os.Octavia, lbID)
+	if err != nil {
+		return fmt.Errorf("failed to get listeners of load balancer %s: %v", lbID, err)
+	}
+
+	for _, listener := range lbListeners {
+		if !strings.HasPrefix(listener.Name, prefix) || keep.Has(listener.Name) {
+			continue
+		}
+
+		log.WithFields(log.Fields{"lbID": lbID, "listenerID": listener.ID}).Info("deleting stream listener")
+		if err := openstackutil.DeleteListener(os.Octavia, listener.ID, lbID); err != nil {
+			return err
+		}
+		log.WithFields(log.Fields{"lbID": lbID, "listenerID": listener.ID}).Info("stream listener deleted")
+	}
+
+	return nil
+}
+
 // EnsurePoolMembers ensure the pool and its members exist if deleted flag is not set, delete the pool and all its members otherwise.
 func (os *OpenStack) EnsurePoolMembers(deleted bool, poolName string, lbID string, listenerID string, nodePort *int, nodes []*apiv1.Node) (*string, error) {
 	logger := log.WithFields(log.Fields{"lbID": lbID, "listenerID": listenerID, "poolName": poolName})
@@ -456,23 +681,7 @@ func (os *OpenStack) EnsurePoolMembers(deleted bool, poolName string, lbID strin
 	}
 
 	// Batch update pool members
-	var members []pools.BatchUpdateMemberOpts
-	for _, node := range nodes {
-		addr, err := getNodeAddressForLB(node)
-		if err != nil {
-			// Node failure, do not create member
-			logger.WithFields(log.Fields{"nodeName": node.Name, "error": err}).Warn("failed to create LB pool member for node")
-			continue
-		}
-
-		nodeName := node.Name
-		member := pools.BatchUpdateMemberOpts{
-			Name:         &nodeName,
-			Address:      addr,
-			ProtocolPort: *nodePort,
-		}
-		members = append(members, member)
-	}
+	members := buildPoolMembers(logger, nodes, *nodePort)
 	// only allow >= 1 members or it will lead to openstack octavia issue
 	if len(members) == 0 {
 		return nil, fmt.Errorf("error because no members in pool: %s", pool.ID)
@@ -491,7 +700,31 @@ func (os *OpenStack) EnsurePoolMembers(deleted bool, poolName string, lbID strin
 	return &pool.ID, nil
 }
 
+// buildPoolMembers returns the members of a pool for the nodes.
+func buildPoolMembers(logger *log.Entry, nodes []*apiv1.Node, nodePort int) []pools.BatchUpdateMemberOpts {
+	var members []pools.BatchUpdateMemberOpts
+	for _, node := range nodes {
+		addr, err := getNodeAddressForLB(node)
+		if err != nil {
+			// Node failure, do not create member
+			logger.WithFields(log.Fields{"nodeName": node.Name, "error": err}).Warn("failed to create LB pool member for node")
+			continue
+		}
+
+		nodeName := node.Name
+		member := pools.BatchUpdateMemberOpts{
+			Name:         &nodeName,
+			Address:      addr,
+			ProtocolPort: nodePort,
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
 // UpdateLoadbalancerMembers update members for all the pools in the specified load balancer.
+// The members of each pool are replaced in a single batch update, and the
+// pools whose members are unchanged are not updated.
 func (os *OpenStack) UpdateLoadbalancerMembers(lbID string, nodes []*apiv1.Node) error {
 	lbPools, err := openstackutil.GetPools(os.Octavia, lbID)
 	if err != nil {
@@ -499,22 +732,35 @@ func (os *OpenStack) UpdateLoadbalancerMembers(lbID string, nodes []*apiv1.Node)
 	}
 
 	for _, pool := range lbPools {
-		log.WithFields(log.Fields{"poolID": pool.ID}).Debug("Starting to update pool members")
+		logger := log.WithFields(log.Fields{"poolID": pool.ID, "lbID": lbID})
+		logger.Debug("Starting to update pool members")
 
 		members, err := openstackutil.GetMembersbyPool(os.Octavia, pool.ID)
 		if err != nil {
-			log.WithFields(log.Fields{"poolID": pool.ID}).Errorf("Failed to get pool members: %v", err)
+			logger.Errorf("Failed to get pool members: %v", err)
+			continue
+		}
+		if len(members) == 0 {
+			logger.Warn("Skipping pool without members, its node port is unknown")
 			continue
 		}
 
 		// Members have the same ProtocolPort
-		nodePort := members[0].ProtocolPort
+		newMembers := buildPoolMembers(logger, nodes, members[0].ProtocolPort)
+		// only allow >= 1 members or it will lead to openstack octavia issue
+		if len(newMembers) == 0 {
+			return fmt.Errorf("error because no members in pool: %s", pool.ID)
+		}
+		if poolMembersEqual(members, newMembers) {
+			logger.Debug("pool members up to date")
+			continue
+		}
 
-		if _, err = os.EnsurePoolMembers(false, pool.Name, lbID, "", &nodePort, nodes); err != nil {
-			return err
+		if err := openstackutil.BatchUpdatePoolMembers(os.Octavia, lbID, pool.ID, newMembers); err != nil {
+			return fmt.Errorf("error batch updating members for pool %s: %v", pool.ID, err)
 		}
 
-		log.WithFields(log.Fields{"poolID": pool.ID, "lbID": lbID}).Info("Finished to update pool members")
+		logger.Info("Finished to update pool members")
 	}
 
 	return nil
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newNode(name string, addresses ...apiv1.NodeAddress) *apiv1.Node {
+	return &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     apiv1.NodeStatus{Addresses: addresses},
+	}
+}
+
+func TestBuildPoolMembers(t *testing.T) {
+	nodes := []*apiv1.Node{
+		newNode("node1",
+			apiv1.NodeAddress{Type: apiv1.NodeExternalIP, Address: "172.24.4.10"},
+			apiv1.NodeAddress{Type: apiv1.NodeInternalIP, Address: "10.0.0.1"},
+		),
+		newNode("node2", apiv1.NodeAddress{Type: apiv1.NodeExternalIP, Address: "172.24.4.11"}),
+		// The nodes without address are skipped
+		newNode("node3"),
+	}
+
+	members := buildPoolMembers(log.WithFields(log.Fields{}), nodes, 30080)
+
+	node1, node2 := "node1", "node2"
+	expected := []pools.BatchUpdateMemberOpts{
+		{Name: &node1, Address: "10.0.0.1", ProtocolPort: 30080},
+		{Name: &node2, Address: "172.24.4.11", ProtocolPort: 30080},
+	}
+	assert.Equal(t, expected, members)
+	assert.Empty(t, buildPoolMembers(log.WithFields(log.Fields{}), nil, 30080))
+}
+
+func TestPoolMembersEqual(t *testing.T) {
+	node1, node2 := "node1", "node2"
+	opts := []pools.BatchUpdateMemberOpts{
+		{Name: &node1, Address: "10.0.0.1", ProtocolPort: 30080},
+		{Name: &node2, Address: "10.0.0.2", ProtocolPort: 30080},
+	}
+
+	tests := []struct {
+		name     string
+		members  []pools.Member
+		opts     []pools.BatchUpdateMemberOpts
+		expected bool
+	}{
+		{
+			name: "same members in another order",
+			members: []pools.Member{
+				{ID: "m2", Name: "node2", Address: "10.0.0.2", ProtocolPort: 30080},
+				{ID: "m1", Name: "node1", Address: "10.0.0.1", ProtocolPort: 30080},
+			},
+			opts:     opts,
+			expected: true,
+		},
+		{name: "no members", expected: true},
+		{
+			name:    "node added",
+			members: []pools.Member{{Name: "node1", Address: "10.0.0.1", ProtocolPort: 30080}},
+			opts:    opts,
+		},
+		{
+			name: "node removed",
+			members: []pools.Member{
+				{Name: "node1", Address: "10.0.0.1", ProtocolPort: 30080},
+				{Name: "node2", Address: "10.0.0.2", ProtocolPort: 30080},
+			},
+			opts: opts[:1],
+		},
+		{
+			name: "address changed",
+			members: []pools.Member{
+				{Name: "node1", Address: "10.0.0.1", ProtocolPort: 30080},
+				{Name: "node2", Address: "10.0.0.3", ProtocolPort: 30080},
+			},
+			opts: opts,
+		},
+		{
+			name: "node port changed",
+			members: []pools.Member{
+				{Name: "node1", Address: "10.0.0.1", ProtocolPort: 30081},
+				{Name: "node2", Address: "10.0.0.2", ProtocolPort: 30081},
+			},
+			opts: opts,
+		},
+		{
+			name: "node replaced",
+			members: []pools.Member{
+				{Name: "node1", Address: "10.0.0.1", ProtocolPort: 30080},
+				{Name: "node3", Address: "10.0.0.2", ProtocolPort: 30080},
+			},
+			opts: opts,
+		},
+		{
+			name:     "member without name",
+			members:  []pools.Member{{Address: "10.0.0.1", ProtocolPort: 30080}},
+			opts:     []pools.BatchUpdateMemberOpts{{Address: "10.0.0.1", ProtocolPort: 30080}},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, poolMembersEqual(test.members, test.opts))
+		})
+	}
+}
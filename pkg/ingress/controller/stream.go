@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// streamRule exposes a port of a service on a TCP or UDP listener of the load balancer of the Ingress.
+type streamRule struct {
+	protocol string // TCP or UDP
+	port     int
+	service  string // namespace/name
+	backend  nwv1.IngressServiceBackend
+}
+
+// listenerName returns the name of the listener of the rule, prefixed by the name of the load balancer.
+func (r streamRule) listenerName(resName string) string {
+	return fmt.Sprintf("%s_%s_%d", resName, strings.ToLower(r.protocol), r.port)
+}
+
+// streamAnnotations are the annotations of the ConfigMaps of the stream rules, by protocol.
+var streamAnnotations = []struct {
+	annotation string
+	protocol   string
+}{
+	{IngressAnnotationTCPServices, string(apiv1.ProtocolTCP)},
+	{IngressAnnotationUDPServices, string(apiv1.ProtocolUDP)},
+}
+
+// getStreamRules returns the stream rules of the ConfigMaps of the Ingress, and their version, made of the resource
+// versions of the ConfigMaps. A ConfigMap that doesn't exist has no stream rules, so that the listeners of its rules
+// are deleted along with it.
+func (c *Controller) getStreamRules(ing *nwv1.Ingress) ([]streamRule, string, error) {
+	var rules []streamRule
+	var versions []string
+	for _, s := range streamAnnotations {
+		name := getStringFromIngressAnnotation(ing, s.annotation, "")
+		if name == "" {
+			continue
+		}
+
+		cm, err := c.configMapLister.ConfigMaps(ing.Namespace).Get(name)
+		if apierrors.IsNotFound(err) {
+			log.WithFields(log.Fields{"ingress": ing.Name, "namespace": ing.Namespace}).Warnf("ConfigMap %s of annotation %s not found, no %s stream rules", name, s.annotation, s.protocol)
+			versions = append(versions, "none")
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get ConfigMap %s/%s of annotation %s: %v", ing.Namespace, name, s.annotation, err)
+		}
+		protocolRules, err := parseStreamRules(s.protocol, ing.Namespace, cm.Data)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid ConfigMap %s/%s of annotation %s: %v", ing.Namespace, name, s.annotation, err)
+		}
+
+		rules = append(rules, protocolRules...)
+		versions = append(versions, cm.ResourceVersion)
+	}
+
+	return rules, strings.Join(versions, ","), nil
+}
+
+// parseStreamRules parses the data of a ConfigMap mapping the ports of the load balancer to the services, as
+// 'service:port' or 'namespace/service:port', the port being the number or the name of a port of the service. The
+// services must be in the namespace of the Ingress.
+func parseStreamRules(protocol string, namespace string, data map[string]string) ([]streamRule, error) {
+	var rules []streamRule
+	keys := make(map[int]string)
+	for key, value := range data {
+		port, err := strconv.Atoi(key)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", key)
+		}
+		// e.g. "53" and "053"
+		if other, ok := keys[port]; ok {
+			return nil, fmt.Errorf("port %d is defined twice, by %q and %q", port, other, key)
+		}
+		keys[port] = key
+
+		i := strings.LastIndex(value, ":")
+		if i <= 0 || i == len(value)-1 {
+			return nil, fmt.Errorf("invalid service %q of port %d, expected service:port", value, port)
+		}
+		name, servicePort := value[:i], value[i+1:]
+		if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+			if parts[0] != namespace {
+				return nil, fmt.Errorf("service %q of port %d is not in namespace %s of the Ingress", value, port, namespace)
+			}
+			name = parts[1]
+		}
+
+		backend := nwv1.IngressServiceBackend{Name: name}
+		if number, err := strconv.Atoi(servicePort); err == nil {
+			backend.Port.Number = int32(number)
+		} else {
+			backend.Port.Name = servicePort
+		}
+
+		rules = append(rules, streamRule{
+			protocol: protocol,
+			port:     port,
+			service:  fmt.Sprintf("%s/%s", namespace, name),
+			backend:  backend,
+		})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].port < rules[j].port
+	})
+	return rules, nil
+}
+
+// enqueueStreamIngresses updates the Ingresses whose stream rules are defined by the ConfigMap.
+func (c *Controller) enqueueStreamIngresses(cm *apiv1.ConfigMap) {
+	ings, err := c.ingressLister.Ingresses(cm.Namespace).List(labels.Everything())
+	if err != nil {
+		log.Errorf("Failed to list the ingresses of namespace %s: %v", cm.Namespace, err)
+		return
+	}
+
+	for _, ing := range ings {
+		if !IsValid(ing) {
+			continue
+		}
+		for _, s := range streamAnnotations {
+			if ing.Annotations[s.annotation] == cm.Name {
+				key := fmt.Sprintf("%s/%s", ing.Namespace, ing.Name)
+				c.recorder.Event(ing, apiv1.EventTypeNormal, "Updating", fmt.Sprintf("Ingress %s", key))
+				c.queue.AddRateLimited(Event{Obj: ing, Type: UpdateEvent})
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apiv1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestParseStreamRules(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          map[string]string
+		expected      []streamRule
+		expectedError bool
+	}{
+		{
+			name: "service ports by number and name",
+			data: map[string]string{"5432": "postgres:5432", "53": "default/dns:dns"},
+			expected: []streamRule{
+				{protocol: "TCP", port: 53, service: "default/dns", backend: nwv1.IngressServiceBackend{Name: "dns", Port: nwv1.ServiceBackendPort{Name: "dns"}}},
+				{protocol: "TCP", port: 5432, service: "default/postgres", backend: nwv1.IngressServiceBackend{Name: "postgres", Port: nwv1.ServiceBackendPort{Number: 5432}}},
+			},
+		},
+		{
+			name:          "port not a number",
+			data:          map[string]string{"dns": "dns:53"},
+			expectedError: true,
+		},
+		{
+			name:          "port out of range",
+			data:          map[string]string{"65536": "dns:53"},
+			expectedError: true,
+		},
+		{
+			name:          "service without port",
+			data:          map[string]string{"53": "dns"},
+			expectedError: true,
+		},
+		{
+			name:          "service with an empty port",
+			data:          map[string]string{"53": "dns:"},
+			expectedError: true,
+		},
+		{
+			name:          "service of another namespace",
+			data:          map[string]string{"53": "kube-system/dns:53"},
+			expectedError: true,
+		},
+		{
+			name:          "duplicate ports",
+			data:          map[string]string{"53": "dns:53", "053": "other:53"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := parseStreamRules("TCP", "default", tc.data)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, rules)
+		})
+	}
+}
+
+func TestGetStreamRulesConfigMapNotFound(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	cm := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tcp-services", Namespace: "default", ResourceVersion: "42"},
+		Data:       map[string]string{"53": "dns:53"},
+	}
+	if err := indexer.Add(cm); err != nil {
+		t.Fatal(err)
+	}
+	c := &Controller{configMapLister: corelisters.NewConfigMapLister(indexer)}
+
+	ing := &nwv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ingress",
+			Namespace: "default",
+			Annotations: map[string]string{
+				IngressAnnotationTCPServices: "tcp-services",
+				IngressAnnotationUDPServices: "udp-services",
+			},
+		},
+	}
+
+	rules, version, err := c.getStreamRules(ing)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "42,none", version)
+
+	// The version changes once the ConfigMap is deleted, so that its listeners are deleted too
+	if err := indexer.Delete(cm); err != nil {
+		t.Fatal(err)
+	}
+	rules, version, err = c.getStreamRules(ing)
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+	assert.Equal(t, "none,none", version)
+}
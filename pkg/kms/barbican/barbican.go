@@ -5,10 +5,21 @@ import (
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
 	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	"k8s.io/cloud-provider-openstack/pkg/util"
 )
 
 type KMSOpts struct {
 	KeyID string `gcfg:"key-id"`
+
+	// CacheFile is where the key is kept sealed, to be used when Barbican
+	// is unreachable. The cache is disabled if empty.
+	CacheFile string `gcfg:"cache-file"`
+	// CacheKeyFile holds the node key sealing the cache
+	CacheKeyFile string `gcfg:"cache-key-file"`
+	// CacheTTL is how long the cached key can be used after it was last
+	// fetched from Barbican
+	CacheTTL util.MyDuration `gcfg:"cache-ttl"`
 }
 
 //Config to read config options
@@ -41,8 +52,9 @@ func (barbican *Barbican) GetSecret(keyID string) ([]byte, error) {
 		PayloadContentType: "application/octet-stream",
 	}
 
+	mc := metrics.NewMetricContext("secret_payload", "get")
 	key, err := secrets.GetPayload(barbican.Client, keyID, opts).Extract()
-	if err != nil {
+	if mc.ObserveRequest(err) != nil {
 		return nil, err
 	}
 
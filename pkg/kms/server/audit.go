@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+)
+
+// unknownCaller is the caller of the requests whose peer credentials are
+// unknown
+const unknownCaller = "unknown"
+
+// callerAddr is the remote address of the connections to the unix socket,
+// identifying the process which opened them by its peer credentials.
+type callerAddr struct {
+	net.Addr
+	uid uint32
+	pid int32
+}
+
+func (a *callerAddr) String() string {
+	return fmt.Sprintf("uid=%d,pid=%d", a.uid, a.pid)
+}
+
+// callerListener accepts the connections with the peer credentials of the
+// process connecting as remote address, which gRPC passes to the requests.
+type callerListener struct {
+	net.Listener
+}
+
+func (l *callerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+	uid, pid, err := peerCredentials(unixConn)
+	if err != nil {
+		klog.V(4).Infof("Failed to get the peer credentials of a connection: %v", err)
+		return conn, nil
+	}
+	return &callerConn{Conn: conn, addr: &callerAddr{Addr: conn.RemoteAddr(), uid: uid, pid: pid}}, nil
+}
+
+type callerConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *callerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// caller returns the process which sent the request, and its user as metric
+// label, as the process IDs change.
+func caller(ctx context.Context) (string, string) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return unknownCaller, unknownCaller
+	}
+	addr, ok := p.Addr.(*callerAddr)
+	if !ok {
+		return unknownCaller, unknownCaller
+	}
+	return addr.String(), fmt.Sprintf("uid=%d", addr.uid)
+}
+
+// audit logs and counts an encrypt or decrypt request which started at start,
+// failed if err is set.
+func (s *KMSserver) audit(ctx context.Context, operation string, start time.Time, err error) {
+	from, label := caller(ctx)
+	metrics.ObserveKMSRequest(operation, label, start, err)
+	if err != nil {
+		klog.Warningf("audit: %s request from %s with key %s failed after %v: %v", operation, from, s.cfg.KeyManager.KeyID, time.Since(start), err)
+		return
+	}
+	klog.Infof("audit: %s request from %s with key %s served in %v", operation, from, s.cfg.KeyManager.KeyID, time.Since(start))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+)
+
+func TestCallerListener(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the peer credentials are only implemented on Linux")
+	}
+
+	socket := filepath.Join(t.TempDir(), "kms.sock")
+	listener, err := net.Listen(netProtocol, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial(netProtocol, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := (&callerListener{Listener: listener}).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{Addr: conn.RemoteAddr()})
+	from, label := caller(ctx)
+	if expected := fmt.Sprintf("uid=%d,pid=%d", os.Getuid(), os.Getpid()); from != expected {
+		t.Errorf("expected caller %s, got %s", expected, from)
+	}
+	if expected := fmt.Sprintf("uid=%d", os.Getuid()); label != expected {
+		t.Errorf("expected caller label %s, got %s", expected, label)
+	}
+}
+
+func TestUnknownCaller(t *testing.T) {
+	from, label := caller(context.TODO())
+	if from != unknownCaller || label != unknownCaller {
+		t.Errorf("expected an unknown caller without peer, got %s and %s", from, label)
+	}
+
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{Addr: &net.UnixAddr{Name: "@", Net: netProtocol}})
+	from, label = caller(ctx)
+	if from != unknownCaller || label != unknownCaller {
+		t.Errorf("expected an unknown caller without peer credentials, got %s and %s", from, label)
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultCacheTTL = 24 * time.Hour
+	// cacheRefreshPeriod limits how often the cache file is rewritten with an
+	// unchanged key, to record that the key is still valid.
+	cacheRefreshPeriod = time.Minute
+	sealKeySize        = 32
+)
+
+// cacheEntry is the content of the cache file. The key is encrypted with the
+// seal key, with the key ID and the fetch time as additional data so they
+// cannot be altered.
+type cacheEntry struct {
+	KeyID     string    `json:"keyID"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Sealed    []byte    `json:"sealed"`
+}
+
+// keyCache keeps the last key fetched from Barbican sealed in a local file,
+// and falls back to it when Barbican is unreachable, so that kube-apiserver
+// can still start and decrypt the secrets. The cached key is only used until
+// it is older than the TTL, and every use is logged for auditing.
+type keyCache struct {
+	barbican BarbicanService
+	path     string
+	aead     cipher.AEAD
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entry   *cacheEntry
+	current []byte
+}
+
+func newKeyCache(barbicanService BarbicanService, opts barbican.KMSOpts) (*keyCache, error) {
+	sealKey, err := readSealKey(opts.CacheKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := opts.CacheTTL.Duration
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+
+	return &keyCache{
+		barbican: barbicanService,
+		path:     opts.CacheFile,
+		aead:     aead,
+		ttl:      ttl,
+		now:      time.Now,
+	}, nil
+}
+
+// readSealKey reads the key sealing the cache, which must be 32 bytes long,
+// raw or base64 encoded.
+func readSealKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("cache-key-file is required to use the key cache")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cache key file: %v", err)
+	}
+	if len(data) == sealKeySize {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != sealKeySize {
+		return nil, fmt.Errorf("the cache key file %s must contain %d bytes, raw or base64 encoded", path, sealKeySize)
+	}
+	return key, nil
+}
+
+// GetSecret gets the key from Barbican, or from the cache if Barbican is
+// unreachable.
+func (c *keyCache) GetSecret(keyID string) ([]byte, error) {
+	key, err := c.barbican.GetSecret(keyID)
+	if err == nil {
+		c.store(keyID, key)
+		return key, nil
+	}
+	if !isUnreachable(err) {
+		return nil, err
+	}
+
+	cached, cacheErr := c.load(keyID)
+	if cacheErr != nil {
+		klog.Errorf("Barbican is unreachable and the cached key cannot be used: %v", cacheErr)
+		return nil, err
+	}
+	klog.Warningf("audit: Barbican is unreachable (%v), using key %s from the local cache", err, keyID)
+	return cached, nil
+}
+
+// isUnreachable reports whether Barbican failed to answer. A key which
+// Barbican refuses to return, e.g. because it was deleted or access to it
+// was revoked, must not be served from the cache.
+func isUnreachable(err error) bool {
+	if e, ok := err.(gophercloud.StatusCodeError); ok {
+		return e.GetStatusCode() >= 500
+	}
+	return true
+}
+
+func (c *keyCache) store(keyID string, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.entry != nil && c.entry.KeyID == keyID && string(c.current) == string(key) && now.Sub(c.entry.FetchedAt) < cacheRefreshPeriod {
+		return
+	}
+
+	entry := &cacheEntry{KeyID: keyID, FetchedAt: now.UTC()}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		klog.Errorf("Failed to update the key cache: %v", err)
+		return
+	}
+	entry.Sealed = c.aead.Seal(nonce, nonce, key, entry.additionalData())
+
+	if err := writeCacheFile(c.path, entry); err != nil {
+		klog.Errorf("Failed to update the key cache: %v", err)
+		return
+	}
+	if c.entry == nil || c.entry.KeyID != keyID || string(c.current) != string(key) {
+		klog.Infof("audit: key %s stored in the local cache %s", keyID, c.path)
+	}
+	c.entry = entry
+	c.current = key
+}
+
+func (c *keyCache) load(keyID string) ([]byte, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, fmt.Errorf("invalid cache file %s: %v", c.path, err)
+	}
+	if entry.KeyID != keyID {
+		return nil, fmt.Errorf("the cache holds key %s instead of %s", entry.KeyID, keyID)
+	}
+	if age := c.now().Sub(entry.FetchedAt); age > c.ttl {
+		return nil, fmt.Errorf("the cached key expired %v ago", age-c.ttl)
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(entry.Sealed) < nonceSize {
+		return nil, fmt.Errorf("invalid cache file %s", c.path)
+	}
+	key, err := c.aead.Open(nil, entry.Sealed[:nonceSize], entry.Sealed[nonceSize:], entry.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal the cached key: %v", err)
+	}
+	return key, nil
+}
+
+func (e *cacheEntry) additionalData() []byte {
+	return []byte(e.KeyID + "/" + e.FetchedAt.Format(time.RFC3339Nano))
+}
+
+// writeCacheFile replaces the cache file atomically, so a crash never leaves
+// a truncated cache behind.
+func writeCacheFile(path string, entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
+	"k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+type flakyBarbican struct {
+	key []byte
+	err error
+}
+
+func (b *flakyBarbican) GetSecret(keyID string) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.key, nil
+}
+
+func TestKeyCache(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "seal.key")
+	if err := ioutil.WriteFile(keyFile, bytes.Repeat([]byte{1}, sealKeySize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &flakyBarbican{key: []byte("0123456789abcdef")}
+	opts := barbican.KMSOpts{
+		CacheFile:    filepath.Join(dir, "cache"),
+		CacheKeyFile: keyFile,
+		CacheTTL:     util.MyDuration{Duration: time.Hour},
+	}
+	cache, err := newKeyCache(backend, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	if _, err := cache.GetSecret("key-id"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is read back from the cache file when Barbican is unreachable
+	restarted, err := newKeyCache(backend, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.now = cache.now
+	backend.err = errors.New("connection refused")
+	key, err := restarted.GetSecret("key-id")
+	if err != nil || !bytes.Equal(key, backend.key) {
+		t.Fatalf("expected the cached key, got %q, %v", key, err)
+	}
+
+	// A different key is not served
+	if _, err := restarted.GetSecret("other-key-id"); err == nil {
+		t.Fatal("expected an error for a key which is not cached")
+	}
+
+	// A key refused by Barbican is not served
+	backend.err = gophercloud.ErrDefault403{}
+	if _, err := restarted.GetSecret("key-id"); err == nil {
+		t.Fatal("expected an error for a key refused by Barbican")
+	}
+
+	// The cached key expires
+	backend.err = errors.New("connection refused")
+	now = now.Add(2 * time.Hour)
+	if _, err := restarted.GetSecret("key-id"); err == nil {
+		t.Fatal("expected an error for an expired key")
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 	gcfg "gopkg.in/gcfg.v1"
 	pb "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/v1beta1"
+	osClient "k8s.io/cloud-provider-openstack/pkg/client"
 	"k8s.io/cloud-provider-openstack/pkg/kms/barbican"
 	"k8s.io/cloud-provider-openstack/pkg/kms/encryption/aescbc"
 	"k8s.io/klog/v2"
@@ -62,6 +64,21 @@ func Run(configFilePath string, socketpath string, sigchan <-chan os.Signal) (er
 	}
 	s.barbican = &barbican.Barbican{Client: client}
 
+	if s.cfg.Global.ApplicationCredentialFile != "" {
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go osClient.WatchApplicationCredentialFile(client.ProviderClient, s.cfg.Global.ApplicationCredentialFile, stopCh)
+	}
+
+	if s.cfg.KeyManager.CacheFile != "" {
+		cache, err := newKeyCache(s.barbican, s.cfg.KeyManager)
+		if err != nil {
+			klog.V(4).Infof("Failed to set up the key cache: %v", err)
+			return err
+		}
+		s.barbican = cache
+	}
+
 	// unlink the unix socket
 	if err = unix.Unlink(socketpath); err != nil {
 		klog.V(4).Infof("Error to unlink unix socket: %v", err)
@@ -78,7 +95,7 @@ func Run(configFilePath string, socketpath string, sigchan <-chan os.Signal) (er
 
 	serverCh := make(chan error, 1)
 	go func() {
-		err := gServer.Serve(listener)
+		err := gServer.Serve(&callerListener{Listener: listener})
 		serverCh <- err
 		close(serverCh)
 	}()
@@ -113,8 +130,11 @@ func (s *KMSserver) Version(ctx context.Context, req *pb.VersionRequest) (*pb.Ve
 }
 
 // Decrypt decrypts the cipher
-func (s *KMSserver) Decrypt(ctx context.Context, req *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+func (s *KMSserver) Decrypt(ctx context.Context, req *pb.DecryptRequest) (res *pb.DecryptResponse, err error) {
 	klog.V(4).Infof("Decrypt Request by Kubernetes api server")
+	defer func(start time.Time) {
+		s.audit(ctx, "decrypt", start, err)
+	}(time.Now())
 
 	key, err := s.barbican.GetSecret(s.cfg.KeyManager.KeyID)
 	if err != nil {
@@ -132,8 +152,11 @@ func (s *KMSserver) Decrypt(ctx context.Context, req *pb.DecryptRequest) (*pb.De
 }
 
 // Encrypt encrypts DEK
-func (s *KMSserver) Encrypt(ctx context.Context, req *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+func (s *KMSserver) Encrypt(ctx context.Context, req *pb.EncryptRequest) (res *pb.EncryptResponse, err error) {
 	klog.V(4).Infof("Encrypt Request by Kubernetes api server")
+	defer func(start time.Time) {
+		s.audit(ctx, "encrypt", start, err)
+	}(time.Now())
 
 	key, err := s.barbican.GetSecret(s.cfg.KeyManager.KeyID)
 
@@ -62,4 +62,7 @@ func (mc *MetricContext) Observe(om *OpenstackMetrics, err error) error {
 func RegisterMetrics() {
 	doRegisterAPIMetrics()
 	doRegisterOccmMetrics()
+	doRegisterLoadBalancerMetrics()
+	doRegisterRouteMetrics()
+	doRegisterQuotaMetrics()
 }
@@ -0,0 +1,62 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// KMSRequests is the number of encrypt and decrypt requests of the KMS plugin, by caller and result
+var KMSRequests = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name: "openstack_kms_requests_total",
+		Help: "Number of encrypt and decrypt requests of the KMS plugin, by caller and result",
+	}, []string{"operation", "caller", "result"})
+
+// KMSRequestDuration is the latency of the encrypt and decrypt requests of the KMS plugin
+var KMSRequestDuration = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Name: "openstack_kms_request_duration_seconds",
+		Help: "Latency of the encrypt and decrypt requests of the KMS plugin",
+	}, []string{"operation"})
+
+// ObserveKMSRequest records an encrypt or decrypt request which started at
+// start, failed if err is set.
+func ObserveKMSRequest(operation, caller string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	KMSRequests.WithLabelValues(operation, caller, result).Inc()
+	KMSRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+var registerKMSMetrics sync.Once
+
+// RegisterKMSMetrics registers the metrics of the KMS plugin, and of its
+// OpenStack API calls.
+func RegisterKMSMetrics() {
+	doRegisterAPIMetrics()
+	registerKMSMetrics.Do(func() {
+		legacyregistry.MustRegister(KMSRequests)
+		legacyregistry.MustRegister(KMSRequestDuration)
+	})
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	loadBalancerLabels = []string{"loadbalancer"}
+	listenerLabels     = []string{"namespace", "service", "loadbalancer", "listener"}
+
+	loadBalancerBytesInDesc = metrics.NewDesc("openstack_loadbalancer_bytes_in_total",
+		"Bytes received by an Octavia load balancer of Services", loadBalancerLabels, nil, metrics.ALPHA, "")
+	loadBalancerBytesOutDesc = metrics.NewDesc("openstack_loadbalancer_bytes_out_total",
+		"Bytes sent by an Octavia load balancer of Services", loadBalancerLabels, nil, metrics.ALPHA, "")
+	loadBalancerActiveConnectionsDesc = metrics.NewDesc("openstack_loadbalancer_active_connections",
+		"Active connections of an Octavia load balancer of Services", loadBalancerLabels, nil, metrics.ALPHA, "")
+	loadBalancerConnectionsDesc = metrics.NewDesc("openstack_loadbalancer_connections_total",
+		"Connections handled by an Octavia load balancer of Services", loadBalancerLabels, nil, metrics.ALPHA, "")
+	loadBalancerRequestErrorsDesc = metrics.NewDesc("openstack_loadbalancer_request_errors_total",
+		"Requests an Octavia load balancer of Services was unable to fulfill", loadBalancerLabels, nil, metrics.ALPHA, "")
+
+	listenerBytesInDesc = metrics.NewDesc("openstack_loadbalancer_listener_bytes_in_total",
+		"Bytes received by an Octavia listener of a Service", listenerLabels, nil, metrics.ALPHA, "")
+	listenerBytesOutDesc = metrics.NewDesc("openstack_loadbalancer_listener_bytes_out_total",
+		"Bytes sent by an Octavia listener of a Service", listenerLabels, nil, metrics.ALPHA, "")
+	listenerActiveConnectionsDesc = metrics.NewDesc("openstack_loadbalancer_listener_active_connections",
+		"Active connections of an Octavia listener of a Service", listenerLabels, nil, metrics.ALPHA, "")
+	listenerConnectionsDesc = metrics.NewDesc("openstack_loadbalancer_listener_connections_total",
+		"Connections handled by an Octavia listener of a Service", listenerLabels, nil, metrics.ALPHA, "")
+	listenerRequestErrorsDesc = metrics.NewDesc("openstack_loadbalancer_listener_request_errors_total",
+		"Requests an Octavia listener of a Service was unable to fulfill", listenerLabels, nil, metrics.ALPHA, "")
+
+	poolMembersDesc = metrics.NewDesc("openstack_loadbalancer_pool_members",
+		"Members of an Octavia pool of a Service by operating status",
+		[]string{"namespace", "service", "loadbalancer", "pool", "operating_status"}, nil, metrics.ALPHA, "")
+)
+
+// LoadBalancerTraffic is the traffic of a load balancer or of a listener,
+// counted by Octavia since its creation.
+type LoadBalancerTraffic struct {
+	BytesIn           int
+	BytesOut          int
+	ActiveConnections int
+	TotalConnections  int
+	RequestErrors     int
+}
+
+// LoadBalancerStats is the statistics of a whole load balancer, reported
+// once even if Services share it.
+type LoadBalancerStats struct {
+	LoadBalancer string
+	Traffic      LoadBalancerTraffic
+}
+
+// ListenerStats is the statistics of a listener of a Service, and of its
+// pool.
+type ListenerStats struct {
+	Namespace    string
+	Service      string
+	LoadBalancer string
+	Listener     string
+	Traffic      LoadBalancerTraffic
+	// Pool is the ID of the pool of the listener, empty without pool
+	Pool string
+	// Members counts the members of the pool by operating status
+	Members map[string]int
+}
+
+// loadBalancerStatsCollector exports the last statistics fetched from
+// Octavia. The traffic is exported as counters, as Octavia counts it since
+// the creation of the load balancers.
+type loadBalancerStatsCollector struct {
+	metrics.BaseStableCollector
+
+	mu            sync.Mutex
+	loadBalancers []LoadBalancerStats
+	listeners     []ListenerStats
+}
+
+var loadBalancerStats = &loadBalancerStatsCollector{}
+
+// SetLoadBalancerStats replaces the exported statistics of the load
+// balancers, so the ones of deleted Services are not reported anymore.
+func SetLoadBalancerStats(loadBalancers []LoadBalancerStats, listeners []ListenerStats) {
+	loadBalancerStats.mu.Lock()
+	defer loadBalancerStats.mu.Unlock()
+	loadBalancerStats.loadBalancers = loadBalancers
+	loadBalancerStats.listeners = listeners
+}
+
+// DescribeWithStability implements the metrics.StableCollector interface.
+func (c *loadBalancerStatsCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- loadBalancerBytesInDesc
+	ch <- loadBalancerBytesOutDesc
+	ch <- loadBalancerActiveConnectionsDesc
+	ch <- loadBalancerConnectionsDesc
+	ch <- loadBalancerRequestErrorsDesc
+	ch <- listenerBytesInDesc
+	ch <- listenerBytesOutDesc
+	ch <- listenerActiveConnectionsDesc
+	ch <- listenerConnectionsDesc
+	ch <- listenerRequestErrorsDesc
+	ch <- poolMembersDesc
+}
+
+// CollectWithStability implements the metrics.StableCollector interface.
+func (c *loadBalancerStatsCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range c.loadBalancers {
+		labels := []string{s.LoadBalancer}
+		ch <- metrics.NewLazyConstMetric(loadBalancerBytesInDesc, metrics.CounterValue, float64(s.Traffic.BytesIn), labels...)
+		ch <- metrics.NewLazyConstMetric(loadBalancerBytesOutDesc, metrics.CounterValue, float64(s.Traffic.BytesOut), labels...)
+		ch <- metrics.NewLazyConstMetric(loadBalancerActiveConnectionsDesc, metrics.GaugeValue, float64(s.Traffic.ActiveConnections), labels...)
+		ch <- metrics.NewLazyConstMetric(loadBalancerConnectionsDesc, metrics.CounterValue, float64(s.Traffic.TotalConnections), labels...)
+		ch <- metrics.NewLazyConstMetric(loadBalancerRequestErrorsDesc, metrics.CounterValue, float64(s.Traffic.RequestErrors), labels...)
+	}
+	for _, s := range c.listeners {
+		labels := []string{s.Namespace, s.Service, s.LoadBalancer, s.Listener}
+		ch <- metrics.NewLazyConstMetric(listenerBytesInDesc, metrics.CounterValue, float64(s.Traffic.BytesIn), labels...)
+		ch <- metrics.NewLazyConstMetric(listenerBytesOutDesc, metrics.CounterValue, float64(s.Traffic.BytesOut), labels...)
+		ch <- metrics.NewLazyConstMetric(listenerActiveConnectionsDesc, metrics.GaugeValue, float64(s.Traffic.ActiveConnections), labels...)
+		ch <- metrics.NewLazyConstMetric(listenerConnectionsDesc, metrics.CounterValue, float64(s.Traffic.TotalConnections), labels...)
+		ch <- metrics.NewLazyConstMetric(listenerRequestErrorsDesc, metrics.CounterValue, float64(s.Traffic.RequestErrors), labels...)
+		for status, count := range s.Members {
+			ch <- metrics.NewLazyConstMetric(poolMembersDesc, metrics.GaugeValue, float64(count), s.Namespace, s.Service, s.LoadBalancer, s.Pool, status)
+		}
+	}
+}
+
+var registerLoadBalancerMetrics sync.Once
+
+// doRegisterLoadBalancerMetrics registers the load balancer statistics metrics.
+func doRegisterLoadBalancerMetrics() {
+	registerLoadBalancerMetrics.Do(func() {
+		legacyregistry.CustomMustRegister(loadBalancerStats)
+	})
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	quotaLabels = []string{"service", "resource"}
+
+	// QuotaUsed is the number of resources of the project in use
+	QuotaUsed = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "openstack_quota_used",
+			Help: "Resources of the OpenStack project in use, by service and resource",
+		}, quotaLabels)
+	// QuotaLimit is the quota of the project, -1 if unlimited
+	QuotaLimit = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name: "openstack_quota_limit",
+			Help: "Quota of the OpenStack project by service and resource, -1 if unlimited",
+		}, quotaLabels)
+)
+
+// ResetQuotaUsage drops the quota usage of all resources, so the ones which
+// could not be fetched are not reported with stale values.
+func ResetQuotaUsage() {
+	QuotaUsed.Reset()
+	QuotaLimit.Reset()
+}
+
+var registerQuotaMetrics sync.Once
+
+// doRegisterQuotaMetrics registers the quota usage metrics.
+func doRegisterQuotaMetrics() {
+	registerQuotaMetrics.Do(func() {
+		legacyregistry.MustRegister(
+			QuotaUsed,
+			QuotaLimit,
+		)
+	})
+}
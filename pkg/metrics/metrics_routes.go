@@ -0,0 +1,101 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	// RouteDivergenceRoute is the kind of divergence of a pod CIDR without route to the node
+	RouteDivergenceRoute = "route"
+	// RouteDivergenceAddressPair is the kind of divergence of a pod CIDR missing from the allowed address pairs of the node port
+	RouteDivergenceAddressPair = "allowed_address_pair"
+
+	// RouteOperationCreate is the operation of the route changes creating a route
+	RouteOperationCreate = "create"
+	// RouteOperationDelete is the operation of the route changes deleting a route
+	RouteOperationDelete = "delete"
+)
+
+// RouteDivergence is the number of pod CIDRs of a node missing a route or an allowed address pair
+var RouteDivergence = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Name: "openstack_route_divergence",
+		Help: "Number of pod CIDRs of a node without route through the node or allowed address pair on its port",
+	}, []string{"node", "kind"})
+
+// RouteBackend is set to 1 for the backend programming the routes
+var RouteBackend = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Name: "openstack_route_backend",
+		Help: "Backend programming the routes to the pod CIDRs of the nodes, set to 1",
+	}, []string{"backend"})
+
+// RouteGarbageCollected is the number of orphaned routes and allowed address pairs removed
+var RouteGarbageCollected = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name: "openstack_route_garbage_collected_total",
+		Help: "Number of orphaned routes and allowed address pairs removed",
+	}, []string{"kind"})
+
+// RouteQuotaExceeded is the number of route changes rejected because the router has the maximum number of routes
+var RouteQuotaExceeded = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name: "openstack_route_quota_exceeded_total",
+		Help: "Number of route changes rejected because the router has the maximum number of routes",
+	}, []string{"router"})
+
+// RouteChanges is the number of routes created and deleted for the route controller, by result
+var RouteChanges = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name: "openstack_route_changes_total",
+		Help: "Number of routes created and deleted for the route controller, by result",
+	}, []string{"operation", "result"})
+
+// RouteBlackholes is the number of blackhole routes found by the last listing of the routes
+var RouteBlackholes = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Name: "openstack_route_blackholes",
+		Help: "Number of routes whose next hop is not the address of a node, found by the last listing of the routes",
+	})
+
+// ObserveRouteChange counts a route created or deleted, failed if err is set.
+func ObserveRouteChange(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	RouteChanges.WithLabelValues(operation, result).Inc()
+}
+
+var registerRouteMetrics sync.Once
+
+// doRegisterRouteMetrics registers the route metrics.
+func doRegisterRouteMetrics() {
+	registerRouteMetrics.Do(func() {
+		legacyregistry.MustRegister(RouteDivergence)
+		legacyregistry.MustRegister(RouteBackend)
+		legacyregistry.MustRegister(RouteGarbageCollected)
+		legacyregistry.MustRegister(RouteQuotaExceeded)
+		legacyregistry.MustRegister(RouteChanges)
+		legacyregistry.MustRegister(RouteBlackholes)
+	})
+}
@@ -18,12 +18,14 @@ package openstack
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/attachinterfaces"
@@ -34,8 +36,10 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/cloud-provider-openstack/pkg/client"
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
@@ -49,10 +53,22 @@ type Instances struct {
 	compute        *gophercloud.ServiceClient
 	opts           metadata.Opts
 	networkingOpts NetworkingOpts
+	deletionGuard  *nodeDeletionGuard
+	// floatingIPNodes is nil unless the floating IPs are only reported for some nodes
+	floatingIPNodes *floatingIPNodeFilter
+	nodeNames       *nodeNameMapper
 }
 
 const (
 	instanceShutoff = "SHUTOFF"
+
+	// LabelHostID is the node label holding the Nova hostId of the instance.
+	// The hostId is an opaque hash of the hypervisor host, stable within a
+	// project, which allows spreading workloads over physical hosts without
+	// admin access to the hypervisor names.
+	LabelHostID = "node.openstack.org/host-id"
+
+	hostIDLabelSyncPeriod = 5 * time.Minute
 )
 
 var _ cloudprovider.Instances = &Instances{}
@@ -78,9 +94,12 @@ func (os *OpenStack) instances() (*Instances, bool) {
 	}
 
 	return &Instances{
-		compute:        compute,
-		opts:           os.metadataOpts,
-		networkingOpts: os.networkingOpts,
+		compute:         compute,
+		opts:            os.metadataOpts,
+		networkingOpts:  os.networkingOpts,
+		deletionGuard:   os.nodeDeletionGuard,
+		floatingIPNodes: os.floatingIPNodes,
+		nodeNames:       os.nodeNames,
 	}, true
 }
 
@@ -115,10 +134,21 @@ func (i *Instances) AddSSHKeyToAllInstances(ctx context.Context, user string, ke
 func (i *Instances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.NodeAddress, error) {
 	klog.V(4).Infof("NodeAddresses(%v) called", name)
 
-	addrs, err := getAddressesByName(i.compute, name, i.networkingOpts)
+	srv, err := i.nodeNames.getServer(i.compute, name)
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces, err := getAttachedInterfacesByID(i.compute, srv.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := nodeAddresses(&srv.Server, interfaces, i.networkingOpts)
 	if err != nil {
 		return nil, err
 	}
+	addrs = i.floatingIPNodes.filter(&srv.Server, i.floatingIPNodes.lookup("", string(name)), addrs)
 
 	klog.V(4).Infof("NodeAddresses(%v) => %v", name, addrs)
 	return addrs, nil
@@ -152,6 +182,7 @@ func (i *Instances) NodeAddressesByProviderID(ctx context.Context, providerID st
 	if err != nil {
 		return []v1.NodeAddress{}, err
 	}
+	addresses = i.floatingIPNodes.filter(server, i.floatingIPNodes.lookup(providerID, ""), addresses)
 
 	klog.V(4).Infof("NodeAddressesByProviderID(%v) => %v", providerID, addresses)
 	return addresses, nil
@@ -159,7 +190,10 @@ func (i *Instances) NodeAddressesByProviderID(ctx context.Context, providerID st
 
 // InstanceExists returns true if the instance for the given node exists.
 func (i *Instances) InstanceExists(ctx context.Context, node *v1.Node) (bool, error) {
-	return i.InstanceExistsByProviderID(ctx, node.Spec.ProviderID)
+	if !isNodeMigrated(node) {
+		return true, nil
+	}
+	return i.instanceExists(ctx, node.Spec.ProviderID, node)
 }
 
 func instanceExistsByProviderID(ctx context.Context, compute *gophercloud.ServiceClient, providerID string) (bool, error) {
@@ -183,12 +217,29 @@ func instanceExistsByProviderID(ctx context.Context, compute *gophercloud.Servic
 // InstanceExistsByProviderID returns true if the instance with the given provider id still exists.
 // If false is returned with no error, the instance will be immediately deleted by the cloud controller manager.
 func (i *Instances) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
-	return instanceExistsByProviderID(ctx, i.compute, providerID)
+	return i.instanceExists(ctx, providerID, nil)
+}
+
+// instanceExists reports a missing instance as existing while the node
+// deletion guard holds back the deletion of its node.
+func (i *Instances) instanceExists(ctx context.Context, providerID string, node *v1.Node) (bool, error) {
+	exists, err := instanceExistsByProviderID(ctx, i.compute, providerID)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		i.deletionGuard.found(providerID)
+		return true, nil
+	}
+	return !i.deletionGuard.allowDeletion(providerID, node), nil
 }
 
 // InstanceShutdown returns true if the instances is in safe state to detach volumes.
 // It is the only state, where volumes can be detached immediately.
 func (i *Instances) InstanceShutdown(ctx context.Context, node *v1.Node) (bool, error) {
+	if !isNodeMigrated(node) {
+		return false, nil
+	}
 	return i.InstanceShutdownByProviderID(ctx, node.Spec.ProviderID)
 }
 
@@ -219,6 +270,10 @@ func (i *Instances) InstanceShutdownByProviderID(ctx context.Context, providerID
 
 // InstanceMetadata returns metadata of the specified instance.
 func (i *Instances) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloudprovider.InstanceMetadata, error) {
+	if !isNodeMigrated(node) {
+		return legacyInstanceMetadata(node), nil
+	}
+
 	instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID)
 	if err != nil {
 		return nil, err
@@ -243,6 +298,7 @@ func (i *Instances) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloud
 	if err != nil {
 		return nil, err
 	}
+	addresses = i.floatingIPNodes.filter(srv, node, addresses)
 
 	return &cloudprovider.InstanceMetadata{
 		ProviderID:    node.Spec.ProviderID,
@@ -253,7 +309,7 @@ func (i *Instances) InstanceMetadata(ctx context.Context, node *v1.Node) (*cloud
 
 // InstanceID returns the cloud provider ID of the specified instance.
 func (i *Instances) InstanceID(ctx context.Context, name types.NodeName) (string, error) {
-	srv, err := getServerByName(i.compute, name)
+	srv, err := i.nodeNames.getServer(i.compute, name)
 	if err != nil {
 		if err == errors.ErrNotFound {
 			return "", cloudprovider.InstanceNotFound
@@ -287,7 +343,7 @@ func (i *Instances) InstanceTypeByProviderID(ctx context.Context, providerID str
 
 // InstanceType returns the type of the specified instance.
 func (i *Instances) InstanceType(ctx context.Context, name types.NodeName) (string, error) {
-	srv, err := getServerByName(i.compute, name)
+	srv, err := i.nodeNames.getServer(i.compute, name)
 
 	if err != nil {
 		return "", err
@@ -327,6 +383,53 @@ func srvInstanceType(client *gophercloud.ServiceClient, srv *servers.Server) (st
 	return "", fmt.Errorf("flavor original_name/id not found")
 }
 
+// syncHostIDLabels sets LabelHostID on the nodes according to the hostId of
+// their instance. It is run periodically as instances may be migrated.
+func (i *Instances) syncHostIDLabels(ctx context.Context, kclient kubernetes.Interface) {
+	nodes, err := kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list nodes: %v", err)
+		return
+	}
+
+	for _, node := range nodes.Items {
+		if !isNodeMigrated(&node) {
+			continue
+		}
+		// Nodes not initialized yet have no provider ID
+		instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID)
+		if err != nil {
+			continue
+		}
+
+		mc := metrics.NewMetricContext("server", "get")
+		srv, err := servers.Get(i.compute, instanceID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			klog.Errorf("Failed to get instance %s of node %s: %v", instanceID, node.Name, err)
+			continue
+		}
+
+		if srv.HostID == "" || node.Labels[LabelHostID] == srv.HostID {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]string{LabelHostID: srv.HostID},
+			},
+		})
+		if err != nil {
+			klog.Errorf("Failed to build label patch for node %s: %v", node.Name, err)
+			continue
+		}
+		if _, err := kclient.CoreV1().Nodes().Patch(ctx, node.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			klog.Errorf("Failed to set label %s on node %s: %v", LabelHostID, node.Name, err)
+			continue
+		}
+		klog.V(4).Infof("Set label %s=%s on node %s", LabelHostID, srv.HostID, node.Name)
+	}
+}
+
 func isValidLabelValue(v string) bool {
 	if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
 		return false
@@ -396,14 +499,6 @@ func mapNodeNameToServerName(nodeName types.NodeName) string {
 	return string(nodeName)
 }
 
-// mapServerToNodeName maps an OpenStack Server to a k8s NodeName
-func mapServerToNodeName(server *servers.Server) types.NodeName {
-	// Node names are always lowercase, and (at least)
-	// routecontroller does case-sensitive string comparisons
-	// assuming this
-	return types.NodeName(strings.ToLower(server.Name))
-}
-
 func readInstanceID(searchOrder string) (string, error) {
 	// First, try to get data from metadata service because local
 	// data might be changed by accident
@@ -427,38 +522,6 @@ func readInstanceID(searchOrder string) (string, error) {
 	return "", err
 }
 
-func getServerByName(client *gophercloud.ServiceClient, name types.NodeName) (*ServerAttributesExt, error) {
-	opts := servers.ListOpts{
-		Name: fmt.Sprintf("^%s$", regexp.QuoteMeta(mapNodeNameToServerName(name))),
-	}
-
-	var s []ServerAttributesExt
-	serverList := make([]ServerAttributesExt, 0, 1)
-
-	mc := metrics.NewMetricContext("server", "list")
-	pager := servers.List(client, opts)
-
-	err := pager.EachPage(func(page pagination.Page) (bool, error) {
-		if err := servers.ExtractServersInto(page, &s); err != nil {
-			return false, err
-		}
-		serverList = append(serverList, s...)
-		if len(serverList) > 1 {
-			return false, errors.ErrMultipleResults
-		}
-		return true, nil
-	})
-	if mc.ObserveRequest(err) != nil {
-		return nil, err
-	}
-
-	if len(serverList) == 0 {
-		return nil, errors.ErrNotFound
-	}
-
-	return &serverList[0], nil
-}
-
 // IP addresses order:
 // * interfaces private IPs
 // * access IPs
@@ -573,8 +636,8 @@ func nodeAddresses(srv *servers.Server, interfaces []attachinterfaces.Interface,
 	return addrs, nil
 }
 
-func getAddressesByName(client *gophercloud.ServiceClient, name types.NodeName, networkingOpts NetworkingOpts) ([]v1.NodeAddress, error) {
-	srv, err := getServerByName(client, name)
+func getAddressesByName(client *gophercloud.ServiceClient, nodeNames *nodeNameMapper, name types.NodeName, networkingOpts NetworkingOpts) ([]v1.NodeAddress, error) {
+	srv, err := nodeNames.getServer(client, name)
 	if err != nil {
 		return nil, err
 	}
@@ -587,15 +650,10 @@ func getAddressesByName(client *gophercloud.ServiceClient, name types.NodeName,
 	return nodeAddresses(&srv.Server, interfaces, networkingOpts)
 }
 
-func getAddressByName(client *gophercloud.ServiceClient, name types.NodeName, needIPv6 bool, networkingOpts NetworkingOpts) (string, error) {
-	if needIPv6 && networkingOpts.IPv6SupportDisabled {
-		return "", errors.ErrIPv6SupportDisabled
-	}
-
-	addrs, err := getAddressesByName(client, name, networkingOpts)
-	if err != nil {
-		return "", err
-	} else if len(addrs) == 0 {
+// selectNodeAddress returns the first internal address of the IP family, or
+// the first external address if there is none.
+func selectNodeAddress(addrs []v1.NodeAddress, needIPv6 bool) (string, error) {
+	if len(addrs) == 0 {
 		return "", errors.ErrNoAddressFound
 	}
 
@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// nodeProviderIDIndex indexes the nodes by provider ID, so the node of an
+// instance not found is known to the node deletion guard.
+const nodeProviderIDIndex = "providerID"
+
+func nodeProviderIDIndexFunc(obj interface{}) ([]string, error) {
+	node, ok := obj.(*v1.Node)
+	if !ok || node.Spec.ProviderID == "" {
+		return nil, nil
+	}
+	return []string{node.Spec.ProviderID}, nil
+}
+
+// nodeDeletionGuard protects the nodes from being deleted by the node
+// lifecycle controller when their instance is momentarily not found, e.g.
+// when the Nova cells list the instances inconsistently. The instance of a
+// node younger than minAge is reported to exist, and an instance is only
+// reported missing once it has not been found for cooldown.
+type nodeDeletionGuard struct {
+	minAge   time.Duration
+	cooldown time.Duration
+	// nodes is indexed by provider ID, nil if the age of the nodes is not checked
+	nodes cache.Indexer
+
+	mu sync.Mutex
+	// notFound records when each instance was first and last not found
+	notFound map[string][2]time.Time
+	now      func() time.Time
+}
+
+func newNodeDeletionGuard(opts InstancesOpts) *nodeDeletionGuard {
+	return &nodeDeletionGuard{
+		minAge:   opts.NodeDeletionMinAge.Duration,
+		cooldown: opts.NodeDeletionCooldown.Duration,
+		notFound: make(map[string][2]time.Time),
+		now:      time.Now,
+	}
+}
+
+// enabled reports whether the guard delays the deletion of any node.
+func (g *nodeDeletionGuard) enabled() bool {
+	return g != nil && (g.minAge > 0 || g.cooldown > 0)
+}
+
+// found forgets the instance, which exists again.
+func (g *nodeDeletionGuard) found(providerID string) {
+	if !g.enabled() {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.notFound, providerID)
+}
+
+// allowDeletion reports whether the instance of providerID, which was not
+// found, may be reported missing, so its node is deleted. node is the node
+// of the instance, looked up by provider ID if nil.
+func (g *nodeDeletionGuard) allowDeletion(providerID string, node *v1.Node) bool {
+	if !g.enabled() {
+		return true
+	}
+	now := g.now()
+
+	if node == nil && g.nodes != nil {
+		objs, err := g.nodes.ByIndex(nodeProviderIDIndex, providerID)
+		if err == nil && len(objs) > 0 {
+			node = objs[0].(*v1.Node)
+		}
+	}
+	if node != nil && g.minAge > 0 {
+		if age := now.Sub(node.CreationTimestamp.Time); age < g.minAge {
+			klog.InfoS("Instance not found, keeping the node younger than the minimum age", "node", klog.KObj(node), "providerID", providerID, "age", age)
+			return false
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Forget the instances not checked anymore, e.g. of the nodes deleted
+	// by the operators
+	for id, seen := range g.notFound {
+		if now.Sub(seen[1]) > g.cooldown+time.Hour {
+			delete(g.notFound, id)
+		}
+	}
+
+	seen, ok := g.notFound[providerID]
+	if !ok {
+		seen[0] = now
+	}
+	seen[1] = now
+	g.notFound[providerID] = seen
+	if missing := now.Sub(seen[0]); missing < g.cooldown {
+		klog.InfoS("Instance not found, waiting for the deletion cool-down before deleting the node", "providerID", providerID, "missingFor", missing, "cooldown", g.cooldown)
+		return false
+	}
+	delete(g.notFound, providerID)
+	return true
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+func TestNodeDeletionGuardDisabled(t *testing.T) {
+	var nilGuard *nodeDeletionGuard
+	assert.True(t, nilGuard.allowDeletion("openstack:///a", nil))
+	assert.True(t, newNodeDeletionGuard(InstancesOpts{}).allowDeletion("openstack:///a", nil))
+}
+
+func TestNodeDeletionGuardMinAge(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	newNode := func(name, providerID string, age time.Duration) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Spec:       corev1.NodeSpec{ProviderID: providerID},
+		}
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{nodeProviderIDIndex: nodeProviderIDIndexFunc})
+	assert.NoError(t, indexer.Add(newNode("young", "openstack:///young", time.Minute)))
+	assert.NoError(t, indexer.Add(newNode("old", "openstack:///old", time.Hour)))
+
+	g := newNodeDeletionGuard(InstancesOpts{NodeDeletionMinAge: util.MyDuration{Duration: 10 * time.Minute}})
+	g.now = func() time.Time { return now }
+	g.nodes = indexer
+
+	assert.False(t, g.allowDeletion("openstack:///young", nil))
+	assert.True(t, g.allowDeletion("openstack:///old", nil))
+	assert.True(t, g.allowDeletion("openstack:///unknown", nil))
+	assert.False(t, g.allowDeletion("openstack:///other", newNode("other", "openstack:///other", 5*time.Minute)))
+}
+
+func TestNodeDeletionGuardCooldown(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	g := newNodeDeletionGuard(InstancesOpts{NodeDeletionCooldown: util.MyDuration{Duration: 2 * time.Minute}})
+	g.now = func() time.Time { return now }
+
+	assert.False(t, g.allowDeletion("openstack:///a", nil))
+	now = now.Add(time.Minute)
+	assert.False(t, g.allowDeletion("openstack:///a", nil))
+
+	// The instance is found again, the cool-down restarts.
+	g.found("openstack:///a")
+	now = now.Add(90 * time.Second)
+	assert.False(t, g.allowDeletion("openstack:///a", nil))
+	now = now.Add(2 * time.Minute)
+	assert.True(t, g.allowDeletion("openstack:///a", nil))
+	assert.Empty(t, g.notFound)
+}
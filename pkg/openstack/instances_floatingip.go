@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/mitchellh/mapstructure"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// floatingIPNodeFilter restricts the nodes whose floating IPs are reported as
+// ExternalIP addresses to the nodes matching the floating-ip-node-selector,
+// e.g. the edge nodes. A node which is not known yet doesn't report them.
+type floatingIPNodeFilter struct {
+	selector labels.Selector
+	// nodes are the nodes indexed by provider ID, nil until the informers
+	// are started
+	nodes cache.Indexer
+}
+
+// newFloatingIPNodeFilter returns nil if no selector is configured, the
+// floating IPs of all the nodes are reported.
+func newFloatingIPNodeFilter(opts NetworkingOpts) *floatingIPNodeFilter {
+	if opts.FloatingIPNodeSelector == "" {
+		return nil
+	}
+	// The selector is validated by checkOpenStackOpts
+	selector, _ := labels.Parse(opts.FloatingIPNodeSelector)
+	return &floatingIPNodeFilter{selector: selector}
+}
+
+// lookup returns the node of the provider ID or, if empty, of the name, nil
+// if not known.
+func (f *floatingIPNodeFilter) lookup(providerID, name string) *v1.Node {
+	if f == nil || f.nodes == nil {
+		return nil
+	}
+
+	var obj interface{}
+	if providerID != "" {
+		if objs, err := f.nodes.ByIndex(nodeProviderIDIndex, providerID); err == nil && len(objs) > 0 {
+			obj = objs[0]
+		}
+	} else {
+		obj, _, _ = f.nodes.GetByKey(name)
+	}
+	node, _ := obj.(*v1.Node)
+	return node
+}
+
+// filter removes the floating IPs of the server from the addresses of its
+// node, unless the node matches the selector.
+func (f *floatingIPNodeFilter) filter(srv *servers.Server, node *v1.Node, addrs []v1.NodeAddress) []v1.NodeAddress {
+	if f == nil || (node != nil && f.selector.Matches(labels.Set(node.Labels))) {
+		return addrs
+	}
+
+	var addresses map[string][]struct {
+		IPType string `mapstructure:"OS-EXT-IPS:type"`
+		Addr   string
+	}
+	if err := mapstructure.Decode(srv.Addresses, &addresses); err != nil {
+		return addrs
+	}
+	for _, network := range addresses {
+		for _, props := range network {
+			if props.IPType == "floating" {
+				klog.V(5).Infof("Node '%s' floating IP '%s' ignored due to 'floating-ip-node-selector' option", srv.Name, props.Addr)
+				RemoveFromNodeAddresses(&addrs, v1.NodeAddress{Type: v1.NodeExternalIP, Address: props.Addr})
+			}
+		}
+	}
+	return addrs
+}
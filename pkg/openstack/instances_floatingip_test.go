@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestFloatingIPNodeFilter(t *testing.T) {
+	srv := &servers.Server{
+		Name: "node",
+		Addresses: map[string]interface{}{
+			"private": []interface{}{
+				map[string]interface{}{"addr": "10.0.0.10", "OS-EXT-IPS:type": "fixed"},
+				map[string]interface{}{"addr": "172.24.4.10", "OS-EXT-IPS:type": "floating"},
+			},
+		},
+	}
+	addrs := func() []corev1.NodeAddress {
+		return []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.10"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.10"},
+			{Type: corev1.NodeExternalIP, Address: "172.24.4.10"},
+		}
+	}
+	withoutFloatingIP := addrs()[:2]
+
+	// Without selector, the floating IPs of all the nodes are reported
+	assert.Nil(t, newFloatingIPNodeFilter(NetworkingOpts{}))
+	var nilFilter *floatingIPNodeFilter
+	assert.Equal(t, addrs(), nilFilter.filter(srv, nilFilter.lookup("", "node"), addrs()))
+
+	newNode := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       corev1.NodeSpec{ProviderID: "openstack:///" + name},
+		}
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{nodeProviderIDIndex: nodeProviderIDIndexFunc})
+	assert.NoError(t, indexer.Add(newNode("edge", map[string]string{"node-role.kubernetes.io/edge": ""})))
+	assert.NoError(t, indexer.Add(newNode("worker", nil)))
+
+	f := newFloatingIPNodeFilter(NetworkingOpts{FloatingIPNodeSelector: "node-role.kubernetes.io/edge"})
+	// The nodes are not known until the informers are started
+	assert.Nil(t, f.lookup("", "edge"))
+	f.nodes = indexer
+
+	assert.Equal(t, addrs(), f.filter(srv, f.lookup("", "edge"), addrs()))
+	assert.Equal(t, addrs(), f.filter(srv, f.lookup("openstack:///edge", ""), addrs()))
+	assert.Equal(t, withoutFloatingIP, f.filter(srv, f.lookup("", "worker"), addrs()))
+	assert.Equal(t, withoutFloatingIP, f.filter(srv, f.lookup("openstack:///worker", ""), addrs()))
+	assert.Equal(t, withoutFloatingIP, f.filter(srv, f.lookup("", "unknown"), addrs()))
+	assert.Equal(t, addrs(), f.filter(srv, newNode("other", map[string]string{"node-role.kubernetes.io/edge": "true"}), addrs()))
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/pagination"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	"k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// nodeNameMapper maps the names of the servers to the names of their nodes
+// with the node-name-* rules, e.g. for the clouds whose server names have a
+// domain or uppercase letters while the node names don't. A nil
+// nodeNameMapper only lowercases the server names.
+type nodeNameMapper struct {
+	// regex extracts the node name from its first group, the names which
+	// don't match are kept
+	regex       *regexp.Regexp
+	stripDomain bool
+	keepCase    bool
+	// nodes are the nodes by name, nil until the informers are started
+	nodes cache.Indexer
+}
+
+// newNodeNameMapper returns the node name mapper of the options, whose regex
+// is validated by checkOpenStackOpts.
+func newNodeNameMapper(opts InstancesOpts) *nodeNameMapper {
+	m := &nodeNameMapper{stripDomain: opts.NodeNameStripDomain, keepCase: opts.NodeNameKeepCase}
+	if opts.NodeNameRegex != "" {
+		m.regex, _ = compileNodeNameRegex(opts.NodeNameRegex)
+	}
+	return m
+}
+
+// compileNodeNameRegex compiles the node-name-regex option, which must have
+// a group extracting the node name.
+func compileNodeNameRegex(expr string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if regex.NumSubexp() == 0 {
+		return nil, fmt.Errorf("no group extracting the node name")
+	}
+	return regex, nil
+}
+
+// nodeName returns the node name of the server name: the group of the regex
+// is extracted, then the domain is stripped, then the name is lowercased.
+func (m *nodeNameMapper) nodeName(serverName string) types.NodeName {
+	if m == nil {
+		m = &nodeNameMapper{}
+	}
+	name := serverName
+	if m.regex != nil {
+		if match := m.regex.FindStringSubmatch(name); len(match) > 1 && match[1] != "" {
+			name = match[1]
+		}
+	}
+	if m.stripDomain {
+		if i := strings.Index(name, "."); i > 0 {
+			name = name[:i]
+		}
+	}
+	// Node names are lowercase unless told otherwise, and (at least)
+	// routecontroller does case-sensitive string comparisons assuming this
+	if !m.keepCase {
+		name = strings.ToLower(name)
+	}
+	return types.NodeName(name)
+}
+
+// serverNameFilter returns the Nova name filter of the servers of the node,
+// which are then matched with nodeName. The filter is empty when the server
+// name cannot be derived from the node name.
+func (m *nodeNameMapper) serverNameFilter(name types.NodeName) string {
+	switch {
+	case m.regex != nil:
+		return ""
+	case m.stripDomain:
+		return fmt.Sprintf("^%s(\\..*)?$", regexp.QuoteMeta(string(name)))
+	default:
+		return fmt.Sprintf("^%s$", regexp.QuoteMeta(mapNodeNameToServerName(name)))
+	}
+}
+
+// providerID returns the provider ID of the node, empty if the node or its
+// provider ID is not known.
+func (m *nodeNameMapper) providerID(name types.NodeName) string {
+	if m.nodes == nil {
+		return ""
+	}
+	obj, exists, err := m.nodes.GetByKey(string(name))
+	if err != nil || !exists {
+		return ""
+	}
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return ""
+	}
+	return node.Spec.ProviderID
+}
+
+// getServer returns the server of the node, found by the provider ID of the
+// node if known, so that a server whose name doesn't map to the node name is
+// still found, or else by its name.
+func (m *nodeNameMapper) getServer(client *gophercloud.ServiceClient, name types.NodeName) (*ServerAttributesExt, error) {
+	if m == nil {
+		m = &nodeNameMapper{}
+	}
+	if providerID := m.providerID(name); providerID != "" {
+		instanceID, err := instanceIDFromProviderID(providerID)
+		if err == nil {
+			var srv ServerAttributesExt
+			mc := metrics.NewMetricContext("server", "get")
+			err := servers.Get(client, instanceID).ExtractInto(&srv)
+			if mc.ObserveRequest(err) != nil {
+				if errors.IsNotFound(err) {
+					return nil, errors.ErrNotFound
+				}
+				return nil, err
+			}
+			return &srv, nil
+		}
+		klog.V(4).Infof("Looking up the server of node %s by name, its provider ID %q is invalid: %v", name, providerID, err)
+	}
+
+	opts := servers.ListOpts{
+		Name: m.serverNameFilter(name),
+	}
+	// Without the regex and strip-domain rules the servers matched by the
+	// name filter are the ones of the node
+	filtered := m.regex == nil && !m.stripDomain
+
+	var s []ServerAttributesExt
+	serverList := make([]ServerAttributesExt, 0, 1)
+
+	mc := metrics.NewMetricContext("server", "list")
+	pager := servers.List(client, opts)
+
+	err := pager.EachPage(func(page pagination.Page) (bool, error) {
+		if err := servers.ExtractServersInto(page, &s); err != nil {
+			return false, err
+		}
+		for _, srv := range s {
+			if filtered || m.nodeName(srv.Name) == name {
+				serverList = append(serverList, srv)
+			}
+		}
+		if len(serverList) > 1 {
+			return false, errors.ErrMultipleResults
+		}
+		return true, nil
+	})
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	if len(serverList) == 0 {
+		return nil, errors.ErrNotFound
+	}
+
+	return &serverList[0], nil
+}
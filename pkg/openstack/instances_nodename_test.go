@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+func TestNodeNameMapper(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       InstancesOpts
+		serverName string
+		nodeName   types.NodeName
+	}{
+		{name: "default", opts: InstancesOpts{}, serverName: "Worker-1.example.com", nodeName: "worker-1.example.com"},
+		{name: "case kept", opts: InstancesOpts{NodeNameKeepCase: true}, serverName: "Worker-1", nodeName: "Worker-1"},
+		{name: "strip domain", opts: InstancesOpts{NodeNameStripDomain: true}, serverName: "Worker-1.example.com", nodeName: "worker-1"},
+		{name: "regex", opts: InstancesOpts{NodeNameRegex: `^prod-(.+)$`, NodeNameStripDomain: true, NodeNameKeepCase: true}, serverName: "prod-Worker-1.example.com", nodeName: "Worker-1"},
+		{name: "regex not matching", opts: InstancesOpts{NodeNameRegex: `^prod-(.+)$`}, serverName: "worker-1", nodeName: "worker-1"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.nodeName, newNodeNameMapper(test.opts).nodeName(test.serverName), test.name)
+	}
+	// A nil mapper only lowercases the server names
+	var m *nodeNameMapper
+	assert.Equal(t, types.NodeName("worker-1.example.com"), m.nodeName("Worker-1.example.com"))
+
+	_, err := compileNodeNameRegex(`^prod-.+$`)
+	assert.Error(t, err)
+	_, err = compileNodeNameRegex(`^prod-(.+$`)
+	assert.Error(t, err)
+}
+
+func TestNodeNameMapperGetServer(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var nameFilter string
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		nameFilter = r.URL.Query().Get("name")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "server1", "name": "Worker-1.example.com"}, {"id": "server2", "name": "Worker-10.example.com"}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"server": {"id": "server3", "name": "renamed"}}`)
+	})
+	th.Mux.HandleFunc("/servers/server4", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	m := newNodeNameMapper(InstancesOpts{NodeNameStripDomain: true})
+
+	// The server is found by name without the informers
+	srv, err := m.getServer(fakeclient.ServiceClient(), "worker-1")
+	require.NoError(t, err)
+	assert.Equal(t, "server1", srv.ID)
+	assert.Equal(t, `^worker-1(\..*)?$`, nameFilter)
+
+	_, err = m.getServer(fakeclient.ServiceClient(), "worker-2")
+	assert.Equal(t, errors.ErrNotFound, err)
+
+	// The servers are then found by the provider IDs of the nodes, whatever
+	// their names
+	m.nodes = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, providerID := range map[string]string{"worker-3": "openstack:///server3", "worker-4": "openstack:///server4"} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.NodeSpec{ProviderID: providerID}}
+		require.NoError(t, m.nodes.Add(node))
+	}
+	srv, err = m.getServer(fakeclient.ServiceClient(), "worker-3")
+	require.NoError(t, err)
+	assert.Equal(t, "server3", srv.ID)
+
+	_, err = m.getServer(fakeclient.ServiceClient(), "worker-4")
+	assert.Equal(t, errors.ErrNotFound, err)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+)
+
+func TestSyncHostIDLabels(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var requested []string
+	for _, id := range []string{"moved", "labeled", "nohost", "legacy"} {
+		id := id
+		hostID := "host2"
+		if id == "nohost" {
+			hostID = ""
+		}
+		th.Mux.HandleFunc("/servers/"+id, func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, id)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"server": {"id": %q, "hostId": %q}}`, id, hostID)
+		})
+	}
+
+	newNode := func(name, providerID, hostID string, annotations map[string]string, taints ...corev1.Taint) *corev1.Node {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}, Annotations: annotations},
+			Spec:       corev1.NodeSpec{ProviderID: providerID, Taints: taints},
+		}
+		if hostID != "" {
+			node.Labels[LabelHostID] = hostID
+		}
+		return node
+	}
+	kclient := fake.NewSimpleClientset(
+		newNode("moved", "openstack:///moved", "host1", map[string]string{AnnotationExternalCCM: "true"}),
+		newNode("labeled", "openstack:///labeled", "host2", map[string]string{AnnotationExternalCCM: "true"}),
+		newNode("nohost", "openstack:///nohost", "", map[string]string{AnnotationExternalCCM: "true"}),
+		newNode("uninitialized", "", "", nil, corev1.Taint{Key: cloudproviderapi.TaintExternalCloudProvider}),
+		newNode("legacy", "openstack:///legacy", "host1", nil),
+	)
+
+	migrationMode = true
+	defer func() { migrationMode = false }()
+	instances := &Instances{compute: fakeclient.ServiceClient()}
+	instances.syncHostIDLabels(context.TODO(), kclient)
+
+	// The nodes not managed by openstack-cloud-controller-manager and the
+	// uninitialized ones are skipped
+	assert.ElementsMatch(t, []string{"moved", "labeled", "nohost"}, requested)
+
+	expected := map[string]string{"moved": "host2", "labeled": "host2", "nohost": "", "uninitialized": "", "legacy": "host1"}
+	for name, hostID := range expected {
+		node, err := kclient.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, hostID, node.Labels[LabelHostID], name)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	secgroups "github.com/gophercloud/utils/openstack/networking/v2/extensions/security/groups"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/util/debug"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	// InventoryPath is the path the inventory is served on
+	InventoryPath = "/inventory"
+
+	inventoryKindLoadBalancer  = "LoadBalancer"
+	inventoryKindFloatingIP    = "FloatingIP"
+	inventoryKindSecurityGroup = "SecurityGroup"
+	inventoryKindRoute         = "Route"
+)
+
+var (
+	// inventoryBindAddress is the address the inventory is served on, disabled if empty
+	inventoryBindAddress string
+	// inventoryTokenFile is the file of the bearer token of the requests to the inventory
+	inventoryTokenFile string
+)
+
+// InventoryItem is an OpenStack resource owned by the cloud provider
+type InventoryItem struct {
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Owners are the Kubernetes objects the resource has been created for.
+	// A route without owner is a blackhole route whose node is gone.
+	Owners []corev1.ObjectReference `json:"owners,omitempty"`
+}
+
+// Inventory lists the OpenStack resources owned by the cloud provider
+type Inventory struct {
+	Items []InventoryItem `json:"items"`
+	// Errors are the lookups which failed, the inventory may be incomplete if not empty.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// add records item, merging its owners with the ones of an already recorded
+// item of the same kind and ID, e.g. a load balancer shared by several Services.
+func (inv *Inventory) add(item InventoryItem) {
+	for i := range inv.Items {
+		existing := &inv.Items[i]
+		if existing.Kind == item.Kind && existing.ID == item.ID {
+			existing.Owners = append(existing.Owners, item.Owners...)
+			return
+		}
+	}
+	inv.Items = append(inv.Items, item)
+}
+
+func (inv *Inventory) addError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	klog.Warningf("Inventory: %s", msg)
+	inv.Errors = append(inv.Errors, msg)
+}
+
+func objectReference(kind string, obj metav1.Object) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		UID:       obj.GetUID(),
+	}
+}
+
+// buildInventory lists the load balancers, floating IPs and security groups
+// of the Services of LoadBalancer type, and the routes of the cluster router.
+// Lookup failures are reported in the inventory instead of failing it.
+func (os *OpenStack) buildInventory(ctx context.Context) (*Inventory, error) {
+	inv := &Inventory{Items: []InventoryItem{}}
+
+	if os.lbOpts.Enabled {
+		if err := os.addLoadBalancerInventory(ctx, inv); err != nil {
+			return nil, err
+		}
+	}
+	if os.routeOpts.RouterID != "" || os.routeOpts.DiscoverRouter {
+		os.addRouteInventory(ctx, inv)
+	}
+
+	sort.SliceStable(inv.Items, func(i, j int) bool {
+		if inv.Items[i].Kind != inv.Items[j].Kind {
+			return inv.Items[i].Kind < inv.Items[j].Kind
+		}
+		return inv.Items[i].ID < inv.Items[j].ID
+	})
+	return inv, nil
+}
+
+func (os *OpenStack) addLoadBalancerInventory(ctx context.Context, inv *Inventory) error {
+	network, err := client.NewNetworkV2(os.provider, os.epOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create an OpenStack Network client: %v", err)
+	}
+	lbClient, err := client.NewLoadBalancerV2(os.provider, os.epOpts, os.lbOpts.UseOctavia)
+	if err != nil {
+		return fmt.Errorf("failed to create an OpenStack LoadBalancer client: %v", err)
+	}
+
+	services, err := os.kclient.CoreV1().Services(corev1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list Services: %v", err)
+	}
+
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		owner := []corev1.ObjectReference{objectReference("Service", svc)}
+
+		if lbID := getStringFromServiceAnnotation(svc, ServiceAnnotationLoadBalancerID, ""); lbID != "" {
+			lb, err := openstackutil.GetLoadbalancerByID(lbClient, lbID)
+			if err != nil {
+				if cpoerrors.IsNotFound(err) {
+					inv.addError("load balancer %s of Service %s/%s does not exist", lbID, svc.Namespace, svc.Name)
+				} else {
+					inv.addError("failed to get load balancer %s of Service %s/%s: %v", lbID, svc.Namespace, svc.Name, err)
+				}
+			} else {
+				inv.add(InventoryItem{Kind: inventoryKindLoadBalancer, ID: lb.ID, Name: lb.Name, Address: lb.VipAddress, Owners: owner})
+
+				fip, err := openstackutil.GetFloatingIPByPortID(network, lb.VipPortID)
+				if err != nil {
+					inv.addError("failed to get floating IP of load balancer %s: %v", lb.ID, err)
+				} else if fip != nil {
+					inv.add(InventoryItem{Kind: inventoryKindFloatingIP, ID: fip.ID, Address: fip.FloatingIP, Owners: owner})
+				}
+			}
+		}
+
+		if os.lbOpts.ManageSecurityGroups {
+			sgName := getSecurityGroupName(svc)
+			sgID, err := secgroups.IDFromName(network, sgName)
+			if err != nil {
+				if !isSecurityGroupNotFound(err) {
+					inv.addError("failed to get security group %s: %v", sgName, err)
+				}
+			} else {
+				inv.add(InventoryItem{Kind: inventoryKindSecurityGroup, ID: sgID, Name: sgName, Owners: owner})
+			}
+		}
+	}
+
+	return nil
+}
+
+func (os *OpenStack) addRouteInventory(ctx context.Context, inv *Inventory) {
+	r, ok := os.Routes()
+	if !ok {
+		inv.addError("routes are not supported")
+		return
+	}
+
+	routes, err := r.ListRoutes(ctx, "")
+	if err != nil {
+		inv.addError("failed to list routes: %v", err)
+		return
+	}
+
+	for _, route := range routes {
+		item := InventoryItem{Kind: inventoryKindRoute, ID: route.DestinationCIDR, Address: string(route.TargetNode)}
+		if !route.Blackhole {
+			item.Owners = []corev1.ObjectReference{{Kind: "Node", Name: string(route.TargetNode)}}
+		}
+		inv.add(item)
+	}
+}
+
+// ServeHTTP serves the inventory as JSON
+func (os *OpenStack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	inv, err := os.buildInventory(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(inv); err != nil {
+		klog.Errorf("Failed to write inventory: %v", err)
+	}
+}
+
+// inventoryHandler returns the handler of the inventory and of the route
+// plan, which requires the bearer token.
+func (os *OpenStack) inventoryHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(InventoryPath, os)
+	mux.HandleFunc(RoutePlanPath, os.serveRoutePlan)
+	return debug.WithBearerToken(mux, token)
+}
+
+// serveInventory serves the inventory on addr until stop is closed, to the
+// requests authenticated by the token of tokenFile.
+func (os *OpenStack) serveInventory(addr, tokenFile string, stop <-chan struct{}) {
+	if tokenFile == "" {
+		klog.Errorf("Not serving the inventory: --inventory-token-file is required by --inventory-bind-address")
+		return
+	}
+	token, err := debug.ReadTokenFile(tokenFile)
+	if err != nil {
+		klog.Errorf("Not serving the inventory: %v", err)
+		return
+	}
+
+	server := &http.Server{Addr: addr, Handler: os.inventoryHandler(token)}
+
+	go func() {
+		<-stop
+		server.Close()
+	}()
+
+	klog.Infof("Serving the inventory of the OpenStack resources on %s%s", addr, InventoryPath)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("Failed to serve the inventory: %v", err)
+	}
+}
@@ -19,12 +19,14 @@ package openstack
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/containers"
@@ -42,6 +44,7 @@ import (
 	secgroups "github.com/gophercloud/utils/openstack/networking/v2/extensions/security/groups"
 	"gopkg.in/godo.v2/glob"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -62,7 +65,11 @@ const (
 	servicePrefix                   = "kube_service_"
 	defaultLoadBalancerSourceRanges = "0.0.0.0/0"
 	activeStatus                    = "ACTIVE"
+	errorStatus                     = "ERROR"
+	lbLockBuckets                   = 64
 	annotationXForwardedFor         = "X-Forwarded-For"
+	annotationXForwardedPort        = "X-Forwarded-Port"
+	annotationXForwardedProto       = "X-Forwarded-Proto"
 
 	ServiceAnnotationLoadBalancerInternal             = "service.beta.kubernetes.io/openstack-internal-load-balancer"
 	ServiceAnnotationLoadBalancerConnLimit            = "loadbalancer.openstack.org/connection-limit"
@@ -70,6 +77,7 @@ const (
 	ServiceAnnotationLoadBalancerFloatingSubnet       = "loadbalancer.openstack.org/floating-subnet"
 	ServiceAnnotationLoadBalancerFloatingSubnetID     = "loadbalancer.openstack.org/floating-subnet-id"
 	ServiceAnnotationLoadBalancerFloatingSubnetTags   = "loadbalancer.openstack.org/floating-subnet-tags"
+	ServiceAnnotationLoadBalancerFloatingIP           = "loadbalancer.openstack.org/floating-ip"
 	ServiceAnnotationLoadBalancerClass                = "loadbalancer.openstack.org/class"
 	ServiceAnnotationLoadBalancerKeepFloatingIP       = "loadbalancer.openstack.org/keep-floatingip"
 	ServiceAnnotationLoadBalancerPortID               = "loadbalancer.openstack.org/port-id"
@@ -81,20 +89,73 @@ const (
 	ServiceAnnotationLoadBalancerTimeoutMemberData    = "loadbalancer.openstack.org/timeout-member-data"
 	ServiceAnnotationLoadBalancerTimeoutTCPInspect    = "loadbalancer.openstack.org/timeout-tcp-inspect"
 	ServiceAnnotationLoadBalancerXForwardedFor        = "loadbalancer.openstack.org/x-forwarded-for"
+	ServiceAnnotationLoadBalancerXForwardedPort       = "loadbalancer.openstack.org/x-forwarded-port"
+	ServiceAnnotationLoadBalancerXForwardedProto      = "loadbalancer.openstack.org/x-forwarded-proto"
 	ServiceAnnotationLoadBalancerFlavorID             = "loadbalancer.openstack.org/flavor-id"
+	ServiceAnnotationLoadBalancerFlavorName           = "loadbalancer.openstack.org/flavor-name"
 	ServiceAnnotationLoadBalancerAvailabilityZone     = "loadbalancer.openstack.org/availability-zone"
+	ServiceAnnotationLoadBalancerVIPIPv6SubnetID      = "loadbalancer.openstack.org/vip-ipv6-subnet-id"
+	// ServiceAnnotationLoadBalancerManageMembers defines whether the nodes are the members of the pools. If false,
+	// the pools are created without members, and their members are managed outside of Kubernetes.
+	ServiceAnnotationLoadBalancerManageMembers = "loadbalancer.openstack.org/manage-members"
 	// ServiceAnnotationLoadBalancerEnableHealthMonitor defines whether to create health monitor for the load balancer
 	// pool, if not specified, use 'create-monitor' config. The health monitor can be created or deleted dynamically.
 	ServiceAnnotationLoadBalancerEnableHealthMonitor     = "loadbalancer.openstack.org/enable-health-monitor"
 	ServiceAnnotationLoadBalancerHealthMonitorDelay      = "loadbalancer.openstack.org/health-monitor-delay"
 	ServiceAnnotationLoadBalancerHealthMonitorTimeout    = "loadbalancer.openstack.org/health-monitor-timeout"
 	ServiceAnnotationLoadBalancerHealthMonitorMaxRetries = "loadbalancer.openstack.org/health-monitor-max-retries"
+	// ServiceAnnotationLoadBalancerHealthMonitorType is the type of the health monitors, TCP, HTTP, HTTPS, UDP-CONNECT
+	// or SCTP. Default to the protocol of the port.
+	ServiceAnnotationLoadBalancerHealthMonitorType          = "loadbalancer.openstack.org/health-monitor-type"
+	ServiceAnnotationLoadBalancerHealthMonitorURLPath       = "loadbalancer.openstack.org/health-monitor-url-path"
+	ServiceAnnotationLoadBalancerHealthMonitorExpectedCodes = "loadbalancer.openstack.org/health-monitor-expected-codes"
 	// revive:disable:var-naming
 	ServiceAnnotationTlsContainerRef = "loadbalancer.openstack.org/default-tls-container-ref"
 	// revive:enable:var-naming
+	// ServiceAnnotationLoadBalancerTLSSecret is the name of a kubernetes.io/tls Secret in the namespace of the Service,
+	// uploaded to Barbican as the default TLS container of the listeners.
+	ServiceAnnotationLoadBalancerTLSSecret = "loadbalancer.openstack.org/tls-secret"
+	// ServiceAnnotationLoadBalancerTLSSecretHash is set by the controller to the hash of the TLS Secret used by the
+	// listeners. It is removed when the Secret changes, to rotate the certificate.
+	ServiceAnnotationLoadBalancerTLSSecretHash = "loadbalancer.openstack.org/tls-secret-hash"
+	// ServiceAnnotationLoadBalancerAllowedCIDRSets is a comma-separated list of CIDRSet objects whose CIDRs are
+	// allowed to reach the listeners, in addition to the source ranges of the Service.
+	ServiceAnnotationLoadBalancerAllowedCIDRSets = "loadbalancer.openstack.org/allowed-cidr-sets"
+	// ServiceAnnotationLoadBalancerAllowedCIDRSetsHash is set by the controller to the hash of the CIDRs of the
+	// CIDRSet objects used by the listeners. It is removed when one of them changes, to update the listeners.
+	ServiceAnnotationLoadBalancerAllowedCIDRSetsHash = "loadbalancer.openstack.org/allowed-cidr-sets-hash"
+	// ServiceAnnotationLoadBalancerSessionPersistence is the session persistence of the pools, SOURCE_IP, HTTP_COOKIE
+	// or APP_COOKIE, overriding the session affinity of the Service. NONE disables it.
+	ServiceAnnotationLoadBalancerSessionPersistence           = "loadbalancer.openstack.org/session-persistence"
+	ServiceAnnotationLoadBalancerSessionPersistenceCookieName = "loadbalancer.openstack.org/session-persistence-cookie-name"
+	// ServiceAnnotationLoadBalancerDNSHostname is the fully qualified host name whose A and AAAA records are
+	// published in Designate with the addresses of the load balancer, requires the dns-records option.
+	ServiceAnnotationLoadBalancerDNSHostname = "loadbalancer.openstack.org/dns-hostname"
+	// ServiceAnnotationLoadBalancerDNSPublishedHostname is set by the controller to the host name whose records
+	// it published, so they are removed when the host name changes or the Service is deleted.
+	ServiceAnnotationLoadBalancerDNSPublishedHostname = "loadbalancer.openstack.org/dns-published-hostname"
+	// ServiceAnnotationLoadBalancerMemberNodeSelector is the label selector of the nodes added as members of the
+	// load balancer, overriding the member-node-selector option.
+	ServiceAnnotationLoadBalancerMemberNodeSelector = "loadbalancer.openstack.org/member-node-selector"
+	// ServiceAnnotationLoadBalancerL7Policies is the JSON list of the L7 policies of the HTTP listeners of the
+	// Service, which redirect the requests matching their rules to another port, redirect them to a URL or reject them.
+	ServiceAnnotationLoadBalancerL7Policies = "loadbalancer.openstack.org/l7-policies"
 	// See https://nip.io
 	defaultProxyHostnameSuffix      = "nip.io"
 	ServiceAnnotationLoadBalancerID = "loadbalancer.openstack.org/load-balancer-id"
+	// ServiceAnnotationLoadBalancerExistingID binds the Service to a load balancer created outside of Kubernetes,
+	// of which only the listeners, pools and members of the Service are managed. It is never deleted.
+	ServiceAnnotationLoadBalancerExistingID = "loadbalancer.openstack.org/existing-load-balancer-id"
+	// ServiceAnnotationLoadBalancerExistingTag binds the Service to the existing load balancer with this Octavia tag,
+	// e.g. pre-created by infrastructure as code, like ServiceAnnotationLoadBalancerExistingID.
+	ServiceAnnotationLoadBalancerExistingTag = "loadbalancer.openstack.org/existing-load-balancer-tag"
+
+	ServiceAnnotationLoadBalancerMemberSubnetID = "loadbalancer.openstack.org/member-subnet-id"
+	// ServiceAnnotationLoadBalancerProvider overrides the lb-provider config for the load balancer of the Service.
+	ServiceAnnotationLoadBalancerProvider = "loadbalancer.openstack.org/provider"
+
+	// lbMethodSourceIPPort is the only load balancing algorithm of the ovn provider.
+	lbMethodSourceIPPort = "SOURCE_IP_PORT"
 )
 
 // LbaasV2 is a LoadBalancer implementation based on Octavia
@@ -127,6 +188,11 @@ type servicePatcher struct {
 
 var _ cloudprovider.LoadBalancer = &LbaasV2{}
 
+// errLoadBalancerProvisioning is returned by EnsureLoadBalancer when the load
+// balancer is still being provisioned with async-provisioning, so the
+// Service is requeued instead of blocking a worker until it is ACTIVE.
+var errLoadBalancerProvisioning = errors.New("load balancer is being provisioned")
+
 // negate returns a negated matches for a given one
 func negate(f matcher) matcher { return func(s *subnets.Subnet) bool { return !f(s) } }
 
@@ -326,19 +392,26 @@ type serviceConfig struct {
 	lbNetworkID             string
 	lbSubnetID              string
 	lbMemberSubnetID        string
+	memberSubnet            *net.IPNet
 	lbPublicNetworkID       string
 	lbPublicSubnetSpec      *floatingSubnetSpec
+	floatingIP              string
 	keepClientIP            bool
 	enableProxyProtocol     bool
+	proxyProtocol           v2pools.Protocol
+	insertHeaders           map[string]string
+	persistence             *v2pools.SessionPersistence
 	timeoutClientData       int
 	timeoutMemberConnect    int
 	timeoutMemberData       int
 	timeoutTCPInspect       int
 	allowedCIDR             []string
+	cidrSetsHash            string
 	enableMonitor           bool
 	flavorID                string
 	availabilityZone        string
 	tlsContainerRef         string
+	tlsSecretHash           string
 	lbID                    string
 	lbName                  string
 	supportLBTags           bool
@@ -346,6 +419,22 @@ type serviceConfig struct {
 	healthMonitorDelay      int
 	healthMonitorTimeout    int
 	healthMonitorMaxRetries int
+	healthMonitorType       string
+	healthMonitorURLPath    string
+	healthMonitorExpected   string
+	vipIPv6SubnetID         string
+	additionalVIPSubnetID   string
+	memberIPFamily          corev1.IPFamily
+	manageMembers           bool
+	sharingGroup            string
+	lbProvider              string
+	lbMethod                string
+	l7Policies              []l7Policy
+	// membersDraining is set when members of removed nodes are kept until the end of their drain period
+	membersDraining bool
+
+	// existingLB is set when the load balancer was created outside of Kubernetes
+	existingLB bool
 }
 
 type listenerKey struct {
@@ -472,9 +561,64 @@ func toRuleProtocol(protocol corev1.Protocol) rules.RuleProtocol {
 	}
 }
 
+// listenerTimeouts holds the timeouts of a listener, in milliseconds
+type listenerTimeouts struct {
+	clientData    int
+	memberConnect int
+	memberData    int
+	tcpInspect    int
+}
+
+// defaultListenerTimeouts are the Octavia default timeouts.
+var defaultListenerTimeouts = listenerTimeouts{clientData: 50000, memberConnect: 5000, memberData: 50000, tcpInspect: 0}
+
+// listenerTimeoutPresets are the timeouts used per listener protocol when the
+// timeout-presets option is enabled: long idle timeouts for plain TCP, which
+// typically carries long-lived connections such as database sessions, and
+// short ones for HTTP.
+var listenerTimeoutPresets = map[listeners.Protocol]listenerTimeouts{
+	listeners.ProtocolTCP:             {clientData: 3600000, memberConnect: 5000, memberData: 3600000, tcpInspect: 0},
+	listeners.ProtocolHTTP:            {clientData: 30000, memberConnect: 5000, memberData: 30000, tcpInspect: 0},
+	listeners.ProtocolTerminatedHTTPS: {clientData: 30000, memberConnect: 5000, memberData: 30000, tcpInspect: 0},
+}
+
+// getListenerTimeouts returns the timeouts for a listener of the given protocol. The
+// timeouts set by Service annotations or cloud.conf take precedence, the others fall
+// back to the protocol presets if enabled, or to the Octavia defaults.
+func (lbaas *LbaasV2) getListenerTimeouts(protocol listeners.Protocol, svcConf *serviceConfig) listenerTimeouts {
+	timeouts := defaultListenerTimeouts
+	if lbaas.opts.TimeoutPresets {
+		if preset, ok := listenerTimeoutPresets[protocol]; ok {
+			timeouts = preset
+		}
+	}
+
+	if svcConf.timeoutClientData >= 0 {
+		timeouts.clientData = svcConf.timeoutClientData
+	}
+	if svcConf.timeoutMemberConnect >= 0 {
+		timeouts.memberConnect = svcConf.timeoutMemberConnect
+	}
+	if svcConf.timeoutMemberData >= 0 {
+		timeouts.memberData = svcConf.timeoutMemberData
+	}
+	if svcConf.timeoutTCPInspect >= 0 {
+		timeouts.tcpInspect = svcConf.timeoutTCPInspect
+	}
+	return timeouts
+}
+
+// isLayer4Protocol reports whether the listeners and pools of the protocol
+// can't use the layer 7 features of the Service, TLS termination, the
+// inserted headers, the PROXY protocol and the cookie session persistence.
+// These only apply to the TCP ports of a Service mixing TCP and UDP ports.
+func isLayer4Protocol(protocol string) bool {
+	return protocol == string(listeners.ProtocolUDP) || protocol == string(listeners.ProtocolSCTP)
+}
+
 func getListenerProtocol(protocol corev1.Protocol, svcConf *serviceConfig) listeners.Protocol {
 	// Make neutron-lbaas code work
-	if svcConf != nil {
+	if svcConf != nil && !isLayer4Protocol(string(protocol)) {
 		if svcConf.tlsContainerRef != "" {
 			return listeners.ProtocolTerminatedHTTPS
 		} else if svcConf.keepClientIP {
@@ -576,11 +720,14 @@ func (lbaas *LbaasV2) createFullyPopulatedOctaviaLoadBalancer(name, clusterName
 	createOpts := loadbalancers.CreateOpts{
 		Name:        name,
 		Description: fmt.Sprintf("Kubernetes external service %s/%s from cluster %s", service.Namespace, service.Name, clusterName),
-		Provider:    lbaas.opts.LBProvider,
+		Provider:    svcConf.lbProvider,
 	}
 
 	if svcConf.supportLBTags {
 		createOpts.Tags = []string{svcConf.lbName}
+		if svcConf.sharingGroup != "" {
+			createOpts.Tags = append(createOpts.Tags, sharingGroupTag(clusterName, svcConf.sharingGroup))
+		}
 	}
 
 	if svcConf.flavorID != "" {
@@ -596,6 +743,8 @@ func (lbaas *LbaasV2) createFullyPopulatedOctaviaLoadBalancer(name, clusterName
 
 	if vipPort != "" {
 		createOpts.VipPortID = vipPort
+	} else if svcConf.vipIPv6SubnetID != "" {
+		createOpts.VipSubnetID = svcConf.vipIPv6SubnetID
 	} else {
 		if lbClass != nil && lbClass.SubnetID != "" {
 			createOpts.VipSubnetID = lbClass.SubnetID
@@ -614,18 +763,23 @@ func (lbaas *LbaasV2) createFullyPopulatedOctaviaLoadBalancer(name, clusterName
 
 	// For external load balancer, the LoadBalancerIP is a public IP address.
 	loadBalancerIP := service.Spec.LoadBalancerIP
-	if loadBalancerIP != "" && svcConf.internal {
+	if loadBalancerIP != "" && (svcConf.internal || svcConf.vipIPv6SubnetID != "") {
 		createOpts.VipAddress = loadBalancerIP
 	}
 
 	for _, port := range service.Spec.Ports {
 		listenerCreateOpt := lbaas.buildListenerCreateOpt(port, svcConf)
-		members, newMembers, err := lbaas.buildBatchUpdateMemberOpts(port, nodes, svcConf)
-		if err != nil {
-			return nil, err
-		}
 		poolCreateOpt := lbaas.buildPoolCreateOpt(string(listenerCreateOpt.Protocol), service, svcConf)
-		poolCreateOpt.Members = members
+		newMembers := sets.NewString()
+		if svcConf.manageMembers {
+			var members []v2pools.BatchUpdateMemberOpts
+			var err error
+			members, newMembers, err = lbaas.buildBatchUpdateMemberOpts(service, port, nodes, svcConf)
+			if err != nil {
+				return nil, err
+			}
+			poolCreateOpt.Members = members
+		}
 		// Pool name must be provided to create fully populated loadbalancer
 		poolCreateOpt.Name = fmt.Sprintf("%s_%d_pool", listenerCreateOpt.Protocol, int(port.Port))
 		var withHealthMonitor string
@@ -640,24 +794,38 @@ func (lbaas *LbaasV2) createFullyPopulatedOctaviaLoadBalancer(name, clusterName
 		klog.V(2).Infof("Loadbalancer %s: adding pool%s using protocol %s with %d members", name, withHealthMonitor, poolCreateOpt.Protocol, len(newMembers))
 	}
 
+	var createOptsBuilder loadbalancers.CreateOptsBuilder = createOpts
+	if svcConf.additionalVIPSubnetID != "" {
+		createOptsBuilder = openstackutil.LoadBalancerCreateOpts{
+			CreateOpts:     createOpts,
+			AdditionalVIPs: []openstackutil.AdditionalVIP{{SubnetID: svcConf.additionalVIPSubnetID}},
+		}
+	}
+
 	mc := metrics.NewMetricContext("loadbalancer", "create")
-	loadbalancer, err := loadbalancers.Create(lbaas.lb, createOpts).Extract()
+	loadbalancer, err := loadbalancers.Create(lbaas.lb, createOptsBuilder).Extract()
 	if mc.ObserveRequest(err) != nil {
-		var printObj interface{} = createOpts
-		if opts, err := json.Marshal(createOpts); err == nil {
+		var printObj interface{} = createOptsBuilder
+		if opts, err := json.Marshal(createOptsBuilder); err == nil {
 			printObj = string(opts)
 		}
 		return nil, fmt.Errorf("error creating loadbalancer %v: %v", printObj, err)
 	}
 
-	// In case subnet ID is not configured
-	if lbaas.opts.SubnetID == "" {
-		lbaas.opts.SubnetID = loadbalancer.VipSubnetID
-		svcConf.lbMemberSubnetID = loadbalancer.VipSubnetID
+	// In case subnet ID is not configured, unless the VIP is not on the
+	// subnet of the nodes
+	if lbaas.defaultSubnetID() == "" && svcConf.vipIPv6SubnetID == "" && !isVIPPlacedByAnnotation(service) {
+		lbaas.setDefaultSubnetID(loadbalancer.VipSubnetID)
+		if svcConf.memberSubnet == nil {
+			svcConf.lbMemberSubnetID = loadbalancer.VipSubnetID
+		}
 	}
 
-	if err := openstackutil.WaitLoadbalancerActive(lbaas.lb, loadbalancer.ID); err != nil {
-		return nil, err
+	// With async-provisioning, the reconcile is finished on a later pass
+	if !lbaas.opts.AsyncProvisioning {
+		if err := openstackutil.WaitLoadbalancerActive(lbaas.lb, loadbalancer.ID); err != nil {
+			return nil, err
+		}
 	}
 
 	return loadbalancer, nil
@@ -726,7 +894,8 @@ func cutString(original string) string {
 // In case no InternalIP can be found, ExternalIP is tried.
 // If neither InternalIP nor ExternalIP can be found an error is
 // returned.
-func nodeAddressForLB(node *corev1.Node) (string, error) {
+// If ipFamily is not empty, only the addresses of that IP family are considered.
+func nodeAddressForLB(node *corev1.Node, ipFamily corev1.IPFamily) (string, error) {
 	addrs := node.Status.Addresses
 	if len(addrs) == 0 {
 		return "", cpoerrors.ErrNoAddressFound
@@ -736,15 +905,170 @@ func nodeAddressForLB(node *corev1.Node) (string, error) {
 
 	for _, allowedAddrType := range allowedAddrTypes {
 		for _, addr := range addrs {
-			if addr.Type == allowedAddrType {
-				return addr.Address, nil
+			if addr.Type != allowedAddrType {
+				continue
 			}
+			if ipFamily != "" && getIPFamily(addr.Address) != ipFamily {
+				continue
+			}
+			return addr.Address, nil
 		}
 	}
 
 	return "", cpoerrors.ErrNoAddressFound
 }
 
+// nodeAddressInSubnet returns the first internal address of the node in the
+// subnet, or its first external address in the subnet if there is none.
+func nodeAddressInSubnet(node *corev1.Node, subnet *net.IPNet) (string, error) {
+	for _, addrType := range []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeExternalIP} {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == addrType && subnet.Contains(net.ParseIP(addr.Address)) {
+				return addr.Address, nil
+			}
+		}
+	}
+	return "", cpoerrors.ErrNoAddressFound
+}
+
+// isVIPPlacedByAnnotation returns whether the VIP of the Service is placed on
+// the network, subnet or port of its annotations, e.g. for an internal load
+// balancer on another tenant network than the one of the nodes.
+func isVIPPlacedByAnnotation(service *corev1.Service) bool {
+	for _, annotation := range []string{ServiceAnnotationLoadBalancerNetworkID, ServiceAnnotationLoadBalancerSubnetID, ServiceAnnotationLoadBalancerPortID} {
+		if getStringFromServiceAnnotation(service, annotation, "") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setVIPSubnet sets the network and subnet of the VIP of the Service, and the
+// subnet of its members. The VIP defaults to the subnet of the nodes, which
+// is found from the first node if not configured. The members are always on
+// the subnet of the nodes, even if the VIP is placed elsewhere by the
+// annotations of the Service.
+func (lbaas *LbaasV2) setVIPSubnet(service *corev1.Service, nodes []*corev1.Node, svcConf *serviceConfig) error {
+	placed := isVIPPlacedByAnnotation(service)
+	svcConf.lbNetworkID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerNetworkID, lbaas.opts.NetworkID)
+	svcConf.lbSubnetID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSubnetID, "")
+	// The subnet of the network of the network-id annotation is chosen by Octavia
+	if svcConf.lbSubnetID == "" && getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerNetworkID, "") == "" {
+		svcConf.lbSubnetID = lbaas.defaultSubnetID()
+	}
+	if lbaas.defaultSubnetID() != "" {
+		svcConf.lbMemberSubnetID = lbaas.defaultSubnetID()
+	} else if !placed {
+		svcConf.lbMemberSubnetID = svcConf.lbSubnetID
+	}
+
+	if (len(svcConf.lbNetworkID) == 0 && len(svcConf.lbSubnetID) == 0) || ((svcConf.vipIPv6SubnetID != "" || placed) && len(svcConf.lbMemberSubnetID) == 0) {
+		serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+		if len(nodes) == 0 {
+			return fmt.Errorf("no subnet-id or network-id for service %s, and there are no nodes to find it from", serviceName)
+		}
+		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0], svcConf.memberIPFamily)
+		if err != nil {
+			return fmt.Errorf("failed to get subnet to create load balancer for service %s: %v", serviceName, err)
+		}
+		if !placed {
+			svcConf.lbSubnetID = subnetID
+		}
+		svcConf.lbMemberSubnetID = subnetID
+		lbaas.setDefaultSubnetID(subnetID)
+	}
+	return nil
+}
+
+// setMemberSubnet sets the subnet of the members of the Service from its
+// member-subnet-id annotation, if any, for the nodes attached to several
+// subnets. The members are then created with the node addresses on this
+// subnet, instead of their first addresses.
+func (lbaas *LbaasV2) setMemberSubnet(service *corev1.Service, svcConf *serviceConfig) error {
+	subnetID := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerMemberSubnetID, "")
+	if subnetID == "" {
+		return nil
+	}
+
+	mc := metrics.NewMetricContext("subnet", "get")
+	subnet, err := subnets.Get(lbaas.network, subnetID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("failed to get member subnet %s of Service %s/%s: %v", subnetID, service.Namespace, service.Name, err)
+	}
+	_, cidr, err := net.ParseCIDR(subnet.CIDR)
+	if err != nil {
+		return fmt.Errorf("failed to parse the CIDR of member subnet %s: %v", subnetID, err)
+	}
+	if svcConf.memberIPFamily != "" && getIPFamily(cidr.IP.String()) != svcConf.memberIPFamily {
+		return fmt.Errorf("member subnet %s of Service %s/%s is not an %s subnet", subnetID, service.Namespace, service.Name, svcConf.memberIPFamily)
+	}
+
+	svcConf.lbMemberSubnetID = subnetID
+	svcConf.memberSubnet = cidr
+	return nil
+}
+
+// setFlavor sets the flavor of the load balancer of the Service from its
+// flavor-id or flavor-name annotation, defaulting to the flavor-id config.
+func (lbaas *LbaasV2) setFlavor(service *corev1.Service, svcConf *serviceConfig) error {
+	flavorName := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerFlavorName, "")
+	if flavorName == "" {
+		svcConf.flavorID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerFlavorID, lbaas.opts.FlavorID)
+		return nil
+	}
+	if _, ok := service.Annotations[ServiceAnnotationLoadBalancerFlavorID]; ok {
+		return fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerFlavorID, ServiceAnnotationLoadBalancerFlavorName)
+	}
+
+	flavorID, err := openstackutil.GetFlavorIDByName(lbaas.lb, flavorName)
+	if err != nil {
+		return fmt.Errorf("failed to find load balancer flavor %s of Service %s/%s: %v", flavorName, service.Namespace, service.Name, err)
+	}
+	svcConf.flavorID = flavorID
+	return nil
+}
+
+// setLBProvider sets the Octavia provider of the load balancer of the Service
+// from its provider annotation, defaulting to the lb-provider config.
+func (lbaas *LbaasV2) setLBProvider(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.lbProvider = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProvider, lbaas.opts.LBProvider)
+	if svcConf.lbProvider != lbaas.opts.LBProvider && !cpoutil.Contains(supportedLBProvider, svcConf.lbProvider) {
+		return fmt.Errorf("unsupported load balancer provider %q of Service %s/%s, supported providers: %v", svcConf.lbProvider, service.Namespace, service.Name, supportedLBProvider)
+	}
+	svcConf.lbMethod = lbaas.getLBMethod(svcConf.lbProvider)
+	return nil
+}
+
+// getLBMethod returns the load balancing algorithm of the pools of a load
+// balancer of the given provider. The ovn provider only supports
+// SOURCE_IP_PORT, which is used when another provider is configured.
+func (lbaas *LbaasV2) getLBMethod(provider string) string {
+	if provider == "ovn" && lbaas.opts.LBProvider != "ovn" {
+		return lbMethodSourceIPPort
+	}
+	return lbaas.opts.LBMethod
+}
+
+// isSameLBProvider returns true if the given Octavia providers are the same,
+// octavia being an alias of the amphora provider.
+func isSameLBProvider(a, b string) bool {
+	alias := func(p string) string {
+		if p == "octavia" {
+			return "amphora"
+		}
+		return p
+	}
+	return alias(a) == alias(b)
+}
+
+// getIPFamily returns the IP family of the given address.
+func getIPFamily(address string) corev1.IPFamily {
+	if net.ParseIP(address).To4() == nil {
+		return corev1.IPv6Protocol
+	}
+	return corev1.IPv4Protocol
+}
+
 //getStringFromServiceAnnotation searches a given v1.Service for a specific annotationKey and either returns the annotation's value or a specified defaultSetting
 func getStringFromServiceAnnotation(service *corev1.Service, annotationKey string, defaultSetting string) string {
 	klog.V(4).Infof("getStringFromServiceAnnotation(%s/%s, %v, %v)", service.Namespace, service.Name, annotationKey, defaultSetting)
@@ -801,8 +1125,8 @@ func getBoolFromServiceAnnotation(service *corev1.Service, annotationKey string,
 }
 
 // getSubnetIDForLB returns subnet-id for a specific node
-func getSubnetIDForLB(compute *gophercloud.ServiceClient, node corev1.Node) (string, error) {
-	ipAddress, err := nodeAddressForLB(&node)
+func getSubnetIDForLB(compute *gophercloud.ServiceClient, node corev1.Node, ipFamily corev1.IPFamily) (string, error) {
+	ipAddress, err := nodeAddressForLB(&node, ipFamily)
 	if err != nil {
 		return "", err
 	}
@@ -829,10 +1153,10 @@ func getSubnetIDForLB(compute *gophercloud.ServiceClient, node corev1.Node) (str
 }
 
 // applyNodeSecurityGroupIDForLB associates the security group with all the ports on the nodes.
-func applyNodeSecurityGroupIDForLB(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, nodes []*corev1.Node, sg string) error {
+func applyNodeSecurityGroupIDForLB(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, nodeNames *nodeNameMapper, nodes []*corev1.Node, sg string) error {
 	for _, node := range nodes {
 		nodeName := types.NodeName(node.Name)
-		srv, err := getServerByName(compute, nodeName)
+		srv, err := nodeNames.getServer(compute, nodeName)
 		if err != nil {
 			return err
 		}
@@ -900,12 +1224,12 @@ func disassociateSecurityGroupForLB(network *gophercloud.ServiceClient, sg strin
 }
 
 // getNodeSecurityGroupIDForLB lists node-security-groups for specific nodes
-func getNodeSecurityGroupIDForLB(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, nodes []*corev1.Node) ([]string, error) {
+func getNodeSecurityGroupIDForLB(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, nodeNames *nodeNameMapper, nodes []*corev1.Node) ([]string, error) {
 	secGroupIDs := sets.NewString()
 
 	for _, node := range nodes {
 		nodeName := types.NodeName(node.Name)
-		srv, err := getServerByName(compute, nodeName)
+		srv, err := nodeNames.getServer(compute, nodeName)
 		if err != nil {
 			return []string{}, err
 		}
@@ -1022,10 +1346,23 @@ func (lbaas *LbaasV2) createFloatingIP(msg string, floatIPOpts floatingips.Creat
 
 // Priority of choosing VIP port floating IP:
 // 1. The floating IP that is already attached to the VIP port.
-// 2. Floating IP specified in Spec.LoadBalancerIP
-// 3. Create a new one
+// 2. Floating IP specified in the floating-ip annotation or Spec.LoadBalancerIP
+// 3. Floating IP kept on the deletion of a previous Service of the same name
+// 4. Create a new one
 func (lbaas *LbaasV2) getServiceAddress(clusterName string, service *corev1.Service, lb *loadbalancers.LoadBalancer, svcConf *serviceConfig) (string, error) {
-	if svcConf.internal {
+	if svcConf.internal || svcConf.vipIPv6SubnetID != "" {
+		return lb.VipAddress, nil
+	}
+
+	// The floating IP of an existing load balancer is managed with it
+	if svcConf.existingLB {
+		floatIP, err := openstackutil.GetFloatingIPByPortID(lbaas.network, lb.VipPortID)
+		if err != nil {
+			return "", fmt.Errorf("failed when getting floating IP for port %s: %v", lb.VipPortID, err)
+		}
+		if floatIP != nil {
+			return floatIP.FloatingIP, nil
+		}
 		return lb.VipAddress, nil
 	}
 
@@ -1039,10 +1376,19 @@ func (lbaas *LbaasV2) getServiceAddress(clusterName string, service *corev1.Serv
 		return "", fmt.Errorf("failed when getting floating IP for port %s: %v", portID, err)
 	}
 	klog.V(4).Infof("Found floating ip %v by loadbalancer port id %q", floatIP, portID)
+	if floatIP != nil && svcConf.floatingIP != "" && floatIP.FloatingIP != svcConf.floatingIP {
+		msg := fmt.Sprintf("Load balancer %s keeps its floating IP %s instead of %s, it must be recreated to change it", lb.ID, floatIP.FloatingIP, svcConf.floatingIP)
+		lbaas.recordEvent(service, corev1.EventTypeWarning, "FloatingIPMismatch", msg)
+		klog.InfoS(msg, "service", klog.KObj(service))
+	}
 
-	// second attempt: fetch floating IP specified in service Spec.LoadBalancerIP
-	// if found, associate floating IP with loadbalancer's VIP port
-	loadBalancerIP := service.Spec.LoadBalancerIP
+	// second attempt: fetch floating IP specified in the floating-ip annotation
+	// or service Spec.LoadBalancerIP, if found, associate floating IP with
+	// loadbalancer's VIP port
+	loadBalancerIP := svcConf.floatingIP
+	if loadBalancerIP == "" {
+		loadBalancerIP = service.Spec.LoadBalancerIP
+	}
 	if floatIP == nil && loadBalancerIP != "" {
 		opts := floatingips.ListOpts{
 			FloatingIP: loadBalancerIP,
@@ -1068,10 +1414,22 @@ func (lbaas *LbaasV2) getServiceAddress(clusterName string, service *corev1.Serv
 			} else {
 				return "", fmt.Errorf("floating IP %s is not available", loadBalancerIP)
 			}
+		} else if svcConf.floatingIP != "" {
+			// A floating IP of the annotation must be allocated beforehand.
+			return "", fmt.Errorf("floating IP %s of annotation %s not found", svcConf.floatingIP, ServiceAnnotationLoadBalancerFloatingIP)
+		}
+	}
+
+	// third attempt: reuse the floating IP kept on the deletion of a previous
+	// Service of the same namespace and name
+	if floatIP == nil && loadBalancerIP == "" && svcConf.lbPublicNetworkID != "" {
+		floatIP, err = lbaas.reuseKeptFloatingIP(clusterName, service, portID)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	// third attempt: create a new floating IP
+	// fourth attempt: create a new floating IP
 	if floatIP == nil {
 		if svcConf.lbPublicNetworkID != "" {
 			klog.V(2).Infof("Creating floating IP %s for loadbalancer %s", loadBalancerIP, lb.ID)
@@ -1079,7 +1437,7 @@ func (lbaas *LbaasV2) getServiceAddress(clusterName string, service *corev1.Serv
 			floatIPOpts := floatingips.CreateOpts{
 				FloatingNetworkID: svcConf.lbPublicNetworkID,
 				PortID:            portID,
-				Description:       fmt.Sprintf("Floating IP for Kubernetes external service %s from cluster %s", serviceName, clusterName),
+				Description:       floatingIPDescription(serviceName, clusterName),
 			}
 
 			if loadBalancerIP == "" && svcConf.lbPublicSubnetSpec.MatcherConfigured() {
@@ -1135,28 +1493,32 @@ func (lbaas *LbaasV2) getServiceAddress(clusterName string, service *corev1.Serv
 	return lb.VipAddress, nil
 }
 
-func (lbaas *LbaasV2) ensureOctaviaHealthMonitor(lbID string, name string, pool *v2pools.Pool, port corev1.ServicePort, svcConf *serviceConfig) error {
+// ensureOctaviaHealthMonitor makes sure the pool has the health monitor of the
+// Service. The current health monitor is taken from state if not nil.
+func (lbaas *LbaasV2) ensureOctaviaHealthMonitor(lbID string, name string, pool *v2pools.Pool, state *lbPoolState, port corev1.ServicePort, svcConf *serviceConfig) error {
 	monitorID := pool.MonitorID
 
 	if monitorID != "" {
-		monitor, err := openstackutil.GetHealthMonitor(lbaas.lb, monitorID)
+		monitor, err := state.getMonitor(lbaas, monitorID)
 		if err != nil {
 			return err
 		}
-		//Recreate health monitor with correct protocol if externalTrafficPolicy was changed
-		if (svcConf.healthCheckNodePort > 0 && monitor.Type != "HTTP") ||
-			(svcConf.healthCheckNodePort == 0 && monitor.Type == "HTTP") {
+		// Recreate health monitor with correct type if externalTrafficPolicy or the type annotation was changed
+		createOpts := lbaas.buildMonitorCreateOpts(svcConf, port)
+		if monitor.Type != createOpts.Type {
 			klog.InfoS("Recreating health monitor for the pool", "pool", pool.ID, "oldMonitor", monitorID)
 			if err := openstackutil.DeleteHealthMonitor(lbaas.lb, monitorID, lbID); err != nil {
 				return err
 			}
 			monitorID = ""
-		}
-		if svcConf.healthMonitorDelay != monitor.Delay || svcConf.healthMonitorTimeout != monitor.Timeout || svcConf.healthMonitorMaxRetries != monitor.MaxRetries {
+		} else if svcConf.healthMonitorDelay != monitor.Delay || svcConf.healthMonitorTimeout != monitor.Timeout || svcConf.healthMonitorMaxRetries != monitor.MaxRetries ||
+			(createOpts.URLPath != "" && createOpts.URLPath != monitor.URLPath) || (createOpts.ExpectedCodes != "" && createOpts.ExpectedCodes != monitor.ExpectedCodes) {
 			updateOpts := v2monitors.UpdateOpts{
-				Delay:      svcConf.healthMonitorDelay,
-				Timeout:    svcConf.healthMonitorTimeout,
-				MaxRetries: svcConf.healthMonitorMaxRetries,
+				Delay:         svcConf.healthMonitorDelay,
+				Timeout:       svcConf.healthMonitorTimeout,
+				MaxRetries:    svcConf.healthMonitorMaxRetries,
+				URLPath:       createOpts.URLPath,
+				ExpectedCodes: createOpts.ExpectedCodes,
 			}
 			klog.Infof("Updating health monitor %s updateOpts %+v", monitorID, updateOpts)
 			if err := openstackutil.UpdateHealthMonitor(lbaas.lb, monitorID, updateOpts); err != nil {
@@ -1190,35 +1552,92 @@ func (lbaas *LbaasV2) ensureOctaviaHealthMonitor(lbID string, name string, pool
 
 //buildMonitorCreateOpts returns a v2monitors.CreateOpts without PoolID for consumption of both, fully popuplated Loadbalancers and Monitors.
 func (lbaas *LbaasV2) buildMonitorCreateOpts(svcConf *serviceConfig, port corev1.ServicePort) v2monitors.CreateOpts {
-	monitorProtocol := string(port.Protocol)
-	if port.Protocol == corev1.ProtocolUDP {
-		monitorProtocol = "UDP-CONNECT"
-	}
-	if svcConf.healthCheckNodePort > 0 {
-		monitorProtocol = "HTTP"
-	}
-	return v2monitors.CreateOpts{
-		Type:       monitorProtocol,
+	monitorType := getHealthMonitorType(svcConf, port)
+	opts := v2monitors.CreateOpts{
+		Type:       monitorType,
 		Delay:      svcConf.healthMonitorDelay,
 		Timeout:    svcConf.healthMonitorTimeout,
 		MaxRetries: svcConf.healthMonitorMaxRetries,
 	}
+	if isHTTPHealthMonitor(monitorType) && svcConf.healthCheckNodePort == 0 {
+		opts.URLPath = svcConf.healthMonitorURLPath
+		opts.ExpectedCodes = svcConf.healthMonitorExpected
+	}
+	return opts
+}
+
+// getHealthMonitorType returns the type of the health monitor of the port.
+// The health check node port of a Service with the Local external traffic
+// policy is always checked over HTTP.
+func getHealthMonitorType(svcConf *serviceConfig, port corev1.ServicePort) string {
+	if svcConf.healthCheckNodePort > 0 {
+		return "HTTP"
+	}
+	if svcConf.healthMonitorType != "" && isHealthMonitorTypeOf(svcConf.healthMonitorType, port.Protocol) {
+		return svcConf.healthMonitorType
+	}
+	if port.Protocol == corev1.ProtocolUDP {
+		return "UDP-CONNECT"
+	}
+	return string(port.Protocol)
+}
+
+// isHealthMonitorTypeOf reports whether the health monitors of the type can
+// check the ports of the protocol.
+func isHealthMonitorTypeOf(monitorType string, protocol corev1.Protocol) bool {
+	switch protocol {
+	case corev1.ProtocolUDP:
+		return monitorType == "UDP-CONNECT"
+	case corev1.ProtocolSCTP:
+		return monitorType == "SCTP" || monitorType == "UDP-CONNECT"
+	default:
+		return monitorType == "TCP" || isHTTPHealthMonitor(monitorType)
+	}
+}
+
+func isHTTPHealthMonitor(monitorType string) bool {
+	return monitorType == "HTTP" || monitorType == "HTTPS"
+}
+
+// setHealthMonitor sets the health monitors of the Service from the
+// annotations, defaulting to the configuration.
+func (lbaas *LbaasV2) setHealthMonitor(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.enableMonitor = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerEnableHealthMonitor, lbaas.opts.CreateMonitor)
+	if svcConf.enableMonitor && lbaas.opts.UseOctavia && service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && service.Spec.HealthCheckNodePort > 0 {
+		svcConf.healthCheckNodePort = int(service.Spec.HealthCheckNodePort)
+	}
+	svcConf.healthMonitorDelay = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorDelay, int(lbaas.opts.MonitorDelay.Duration.Seconds()))
+	svcConf.healthMonitorTimeout = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorTimeout, int(lbaas.opts.MonitorTimeout.Duration.Seconds()))
+	svcConf.healthMonitorMaxRetries = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetries, int(lbaas.opts.MonitorMaxRetries))
+
+	svcConf.healthMonitorType = strings.ToUpper(getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorType, ""))
+	svcConf.healthMonitorURLPath = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorURLPath, "/")
+	svcConf.healthMonitorExpected = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorExpectedCodes, "200")
+	if svcConf.healthMonitorType == "" {
+		return nil
+	}
+
+	// The type must suit the protocol of a port, the ports of the other
+	// protocols of a mixed-protocol Service keep the default type
+	for _, port := range service.Spec.Ports {
+		if isHealthMonitorTypeOf(svcConf.healthMonitorType, port.Protocol) {
+			return nil
+		}
+	}
+	return fmt.Errorf("health monitor type %s of annotation %s cannot check any port of the Service", svcConf.healthMonitorType, ServiceAnnotationLoadBalancerHealthMonitorType)
 }
 
 // Make sure the pool is created for the Service, nodes are added as pool members.
-func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *listeners.Listener, service *corev1.Service, port corev1.ServicePort, nodes []*corev1.Node, svcConf *serviceConfig) (*v2pools.Pool, error) {
-	pool, err := openstackutil.GetPoolByListener(lbaas.lb, lbID, listener.ID)
+// ensureOctaviaPool makes sure the listener has the pool of the Service port
+// with the nodes as members. The current pool and members are taken from state
+// if not nil.
+func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *listeners.Listener, state *lbPoolState, service *corev1.Service, port corev1.ServicePort, nodes []*corev1.Node, svcConf *serviceConfig) (*v2pools.Pool, error) {
+	pool, err := state.getPool(lbaas, lbID, listener.ID)
 	if err != nil && err != openstackutil.ErrNotFound {
 		return nil, fmt.Errorf("error getting pool for listener %s: %v", listener.ID, err)
 	}
 
-	// By default, use the protocol of the listener
-	poolProto := v2pools.Protocol(listener.Protocol)
-	if svcConf.enableProxyProtocol {
-		poolProto = v2pools.ProtocolPROXY
-	} else if (svcConf.keepClientIP || svcConf.tlsContainerRef != "") && poolProto != v2pools.ProtocolHTTP {
-		poolProto = v2pools.ProtocolHTTP
-	}
+	poolProto := getPoolProtocol(listener.Protocol, svcConf)
 
 	// Delete the pool and its members if it already exists and has the wrong protocol
 	if pool != nil && v2pools.Protocol(pool.Protocol) != poolProto {
@@ -1242,22 +1661,40 @@ func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *list
 			return nil, err
 		}
 		klog.V(2).Infof("Pool %s created for listener %s", pool.ID, listener.ID)
+	} else if persistence := getPoolPersistence(poolProto, svcConf); isSessionPersistenceChanged(pool.Persistence, persistence) {
+		klog.InfoS("Updating session persistence of the pool", "poolID", pool.ID, "lbID", lbID)
+		if err := openstackutil.UpdatePool(lbaas.lb, lbID, pool.ID, poolSessionPersistenceUpdateOpts{persistence: persistence}); err != nil {
+			return nil, fmt.Errorf("failed to update session persistence of pool %s: %v", pool.ID, err)
+		}
+	}
+
+	if !svcConf.manageMembers {
+		klog.V(4).Infof("Skipping the members of pool %s, they are managed outside of Kubernetes", pool.ID)
+		return pool, nil
 	}
 
 	curMembers := sets.NewString()
-	poolMembers, err := openstackutil.GetMembersbyPool(lbaas.lb, pool.ID)
+	poolMembers, err := state.getMembers(lbaas, pool.ID)
 	if err != nil {
 		klog.Errorf("failed to get members in the pool %s: %v", pool.ID, err)
 	}
-	for _, m := range poolMembers {
-		curMembers.Insert(fmt.Sprintf("%s-%d-%d", m.Address, m.ProtocolPort, m.MonitorPort))
+	for i, m := range poolMembers {
+		curMembers.Insert(lbaas.memberKey(m.Address, m.ProtocolPort, m.MonitorPort, &poolMembers[i].Weight, svcConf))
 	}
 
-	members, newMembers, err := lbaas.buildBatchUpdateMemberOpts(port, nodes, svcConf)
+	members, newMembers, err := lbaas.buildBatchUpdateMemberOpts(service, port, nodes, svcConf)
 	if err != nil {
 		return nil, err
 	}
 
+	// The members of the removed nodes stop receiving new connections, and
+	// are deleted at the end of the drain period.
+	if lbaas.isMemberDrainEnabled(svcConf) {
+		var draining bool
+		members, draining = lbaas.keepDrainingMembers(service, pool.ID, poolMembers, members, newMembers, svcConf)
+		svcConf.membersDraining = svcConf.membersDraining || draining
+	}
+
 	if !curMembers.Equal(newMembers) {
 		klog.V(2).Infof("Updating %d members for pool %s", len(members), pool.ID)
 		if err := openstackutil.BatchUpdatePoolMembers(lbaas.lb, lbID, pool.ID, members); err != nil {
@@ -1270,10 +1707,26 @@ func (lbaas *LbaasV2) ensureOctaviaPool(lbID string, name string, listener *list
 }
 
 func (lbaas *LbaasV2) buildPoolCreateOpt(listenerProtocol string, service *corev1.Service, svcConf *serviceConfig) v2pools.CreateOpts {
+	poolProto := getPoolProtocol(listenerProtocol, svcConf)
+	lbmethod := v2pools.LBMethod(svcConf.lbMethod)
+	return v2pools.CreateOpts{
+		Protocol:    poolProto,
+		LBMethod:    lbmethod,
+		Persistence: getPoolPersistence(poolProto, svcConf),
+	}
+}
+
+// getPoolProtocol returns the protocol of the pool of the listener, the
+// protocol of the listener unless the Service uses the PROXY protocol or
+// HTTP pools.
+func getPoolProtocol(listenerProtocol string, svcConf *serviceConfig) v2pools.Protocol {
 	// By default, use the protocol of the listener
 	poolProto := v2pools.Protocol(listenerProtocol)
+	if isLayer4Protocol(listenerProtocol) {
+		return poolProto
+	}
 	if svcConf.enableProxyProtocol {
-		poolProto = v2pools.ProtocolPROXY
+		poolProto = svcConf.proxyProtocol
 	} else if (svcConf.keepClientIP || svcConf.tlsContainerRef != "") && poolProto != v2pools.ProtocolHTTP {
 		if svcConf.keepClientIP && svcConf.tlsContainerRef != "" {
 			klog.V(4).Infof("Forcing to use %q protocol for pool because annotations %q %q are set", v2pools.ProtocolHTTP, ServiceAnnotationLoadBalancerXForwardedFor, ServiceAnnotationTlsContainerRef)
@@ -1284,31 +1737,38 @@ func (lbaas *LbaasV2) buildPoolCreateOpt(listenerProtocol string, service *corev
 		}
 		poolProto = v2pools.ProtocolHTTP
 	}
+	return poolProto
+}
 
-	affinity := service.Spec.SessionAffinity
-	var persistence *v2pools.SessionPersistence
-	switch affinity {
-	case corev1.ServiceAffinityNone:
-		persistence = nil
-	case corev1.ServiceAffinityClientIP:
-		persistence = &v2pools.SessionPersistence{Type: "SOURCE_IP"}
-	}
-
-	lbmethod := v2pools.LBMethod(lbaas.opts.LBMethod)
-	return v2pools.CreateOpts{
-		Protocol:    poolProto,
-		LBMethod:    lbmethod,
-		Persistence: persistence,
+// getPoolPersistence returns the session persistence of the pool of the
+// protocol. The cookie based session persistence is left out of the UDP and
+// SCTP pools of a mixed-protocol Service.
+func getPoolPersistence(poolProto v2pools.Protocol, svcConf *serviceConfig) *v2pools.SessionPersistence {
+	if svcConf.persistence != nil && svcConf.persistence.Type != "SOURCE_IP" && isLayer4Protocol(string(poolProto)) {
+		return nil
 	}
+	return svcConf.persistence
 }
 
 //buildBatchUpdateMemberOpts returns v2pools.BatchUpdateMemberOpts array for Services and Nodes alongside a list of member names
-func (lbaas *LbaasV2) buildBatchUpdateMemberOpts(port corev1.ServicePort, nodes []*corev1.Node, svcConf *serviceConfig) ([]v2pools.BatchUpdateMemberOpts, sets.String, error) {
+func (lbaas *LbaasV2) buildBatchUpdateMemberOpts(service *corev1.Service, port corev1.ServicePort, nodes []*corev1.Node, svcConf *serviceConfig) ([]v2pools.BatchUpdateMemberOpts, sets.String, error) {
 	var members []v2pools.BatchUpdateMemberOpts
 	newMembers := sets.NewString()
 
 	for _, node := range nodes {
-		addr, err := nodeAddressForLB(node)
+		var addr string
+		var err error
+		if svcConf.memberSubnet != nil {
+			addr, err = nodeAddressInSubnet(node, svcConf.memberSubnet)
+			if err == cpoerrors.ErrNoAddressFound {
+				msg := fmt.Sprintf("Node %s has no address on member subnet %s (%s), not adding it to the load balancer", node.Name, svcConf.lbMemberSubnetID, svcConf.memberSubnet)
+				klog.Warning(msg)
+				lbaas.recordEvent(service, corev1.EventTypeWarning, "MemberAddressNotInSubnet", msg)
+				continue
+			}
+		} else {
+			addr, err = nodeAddressForLB(node, svcConf.memberIPFamily)
+		}
 		if err != nil {
 			if err == cpoerrors.ErrNoAddressFound {
 				// Node failure, do not create member
@@ -1328,8 +1788,17 @@ func (lbaas *LbaasV2) buildBatchUpdateMemberOpts(port corev1.ServicePort, nodes
 		if svcConf.healthCheckNodePort > 0 {
 			member.MonitorPort = &svcConf.healthCheckNodePort
 		}
+		if lbaas.isMemberDrainEnabled(svcConf) {
+			// The cordoned nodes do not receive new connections, the weight
+			// of the uncordoned ones is restored
+			weight := 1
+			if isNodeDraining(node) {
+				weight = 0
+			}
+			member.Weight = &weight
+		}
 		members = append(members, member)
-		newMembers.Insert(fmt.Sprintf("%s-%d-%d", addr, member.ProtocolPort, svcConf.healthCheckNodePort))
+		newMembers.Insert(lbaas.memberKey(addr, member.ProtocolPort, svcConf.healthCheckNodePort, member.Weight, svcConf))
 	}
 	return members, newMembers, nil
 }
@@ -1373,42 +1842,36 @@ func (lbaas *LbaasV2) ensureOctaviaListener(lbID string, name string, curListene
 			listenerChanged = true
 		}
 
-		listenerKeepClientIP := listener.InsertHeaders[annotationXForwardedFor] == "true"
-		if svcConf.keepClientIP != listenerKeepClientIP {
-			updateOpts.InsertHeaders = &listener.InsertHeaders
-			if svcConf.keepClientIP {
-				if *updateOpts.InsertHeaders == nil {
-					*updateOpts.InsertHeaders = make(map[string]string)
-				}
-				(*updateOpts.InsertHeaders)[annotationXForwardedFor] = "true"
-			} else {
-				delete(*updateOpts.InsertHeaders, annotationXForwardedFor)
+		if !isLayer4Protocol(listener.Protocol) {
+			if insertHeaders, changed := getListenerInsertHeaders(listener.InsertHeaders, svcConf.insertHeaders); changed {
+				updateOpts.InsertHeaders = &insertHeaders
+				listenerChanged = true
+			}
+			if svcConf.tlsContainerRef != listener.DefaultTlsContainerRef {
+				updateOpts.DefaultTlsContainerRef = &svcConf.tlsContainerRef
+				listenerChanged = true
 			}
-			listenerChanged = true
-		}
-		if svcConf.tlsContainerRef != listener.DefaultTlsContainerRef {
-			updateOpts.DefaultTlsContainerRef = &svcConf.tlsContainerRef
-			listenerChanged = true
 		}
-		if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, lbaas.opts.LBProvider) {
-			if svcConf.timeoutClientData != listener.TimeoutClientData {
-				updateOpts.TimeoutClientData = &svcConf.timeoutClientData
+		if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, svcConf.lbProvider) {
+			timeouts := lbaas.getListenerTimeouts(listeners.Protocol(listener.Protocol), svcConf)
+			if timeouts.clientData != listener.TimeoutClientData {
+				updateOpts.TimeoutClientData = &timeouts.clientData
 				listenerChanged = true
 			}
-			if svcConf.timeoutMemberConnect != listener.TimeoutMemberConnect {
-				updateOpts.TimeoutMemberConnect = &svcConf.timeoutMemberConnect
+			if timeouts.memberConnect != listener.TimeoutMemberConnect {
+				updateOpts.TimeoutMemberConnect = &timeouts.memberConnect
 				listenerChanged = true
 			}
-			if svcConf.timeoutMemberData != listener.TimeoutMemberData {
-				updateOpts.TimeoutMemberData = &svcConf.timeoutMemberData
+			if timeouts.memberData != listener.TimeoutMemberData {
+				updateOpts.TimeoutMemberData = &timeouts.memberData
 				listenerChanged = true
 			}
-			if svcConf.timeoutTCPInspect != listener.TimeoutTCPInspect {
-				updateOpts.TimeoutTCPInspect = &svcConf.timeoutTCPInspect
+			if timeouts.tcpInspect != listener.TimeoutTCPInspect {
+				updateOpts.TimeoutTCPInspect = &timeouts.tcpInspect
 				listenerChanged = true
 			}
 		}
-		if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureVIPACL, lbaas.opts.LBProvider) {
+		if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureVIPACL, svcConf.lbProvider) {
 			if !cpoutil.StringListEqual(svcConf.allowedCIDR, listener.AllowedCIDRs) {
 				updateOpts.AllowedCIDRs = &svcConf.allowedCIDR
 				listenerChanged = true
@@ -1441,36 +1904,57 @@ func (lbaas *LbaasV2) buildListenerCreateOpt(port corev1.ServicePort, svcConf *s
 		listenerCreateOpt.Tags = []string{svcConf.lbName}
 	}
 
-	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, lbaas.opts.LBProvider) {
-		listenerCreateOpt.TimeoutClientData = &svcConf.timeoutClientData
-		listenerCreateOpt.TimeoutMemberConnect = &svcConf.timeoutMemberConnect
-		listenerCreateOpt.TimeoutMemberData = &svcConf.timeoutMemberData
-		listenerCreateOpt.TimeoutTCPInspect = &svcConf.timeoutTCPInspect
-	}
+	// The UDP and SCTP ports of a mixed-protocol Service keep their protocol,
+	// without the layer 7 features of its TCP ports
+	layer7 := !isLayer4Protocol(string(listenerProtocol))
 
-	if svcConf.keepClientIP {
-		listenerCreateOpt.InsertHeaders = map[string]string{annotationXForwardedFor: "true"}
+	if layer7 && len(svcConf.insertHeaders) > 0 {
+		listenerCreateOpt.InsertHeaders = svcConf.insertHeaders
 	}
 
-	if svcConf.tlsContainerRef != "" {
+	if layer7 && svcConf.tlsContainerRef != "" {
 		listenerCreateOpt.DefaultTlsContainerRef = svcConf.tlsContainerRef
 	}
 
 	// protocol selection
-	if svcConf.tlsContainerRef != "" && listenerCreateOpt.Protocol != listeners.ProtocolTerminatedHTTPS {
+	if layer7 && svcConf.tlsContainerRef != "" && listenerCreateOpt.Protocol != listeners.ProtocolTerminatedHTTPS {
 		klog.V(4).Infof("Forcing to use %q protocol for listener because %q annotation is set", listeners.ProtocolTerminatedHTTPS, ServiceAnnotationTlsContainerRef)
 		listenerCreateOpt.Protocol = listeners.ProtocolTerminatedHTTPS
-	} else if svcConf.keepClientIP && listenerCreateOpt.Protocol != listeners.ProtocolHTTP {
+	} else if layer7 && svcConf.keepClientIP && listenerCreateOpt.Protocol != listeners.ProtocolHTTP {
 		klog.V(4).Infof("Forcing to use %q protocol for listener because %q annotation is set", listeners.ProtocolHTTP, ServiceAnnotationLoadBalancerXForwardedFor)
 		listenerCreateOpt.Protocol = listeners.ProtocolHTTP
 	}
 
+	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, svcConf.lbProvider) {
+		timeouts := lbaas.getListenerTimeouts(listenerCreateOpt.Protocol, svcConf)
+		listenerCreateOpt.TimeoutClientData = &timeouts.clientData
+		listenerCreateOpt.TimeoutMemberConnect = &timeouts.memberConnect
+		listenerCreateOpt.TimeoutMemberData = &timeouts.memberData
+		listenerCreateOpt.TimeoutTCPInspect = &timeouts.tcpInspect
+	}
+
 	if len(svcConf.allowedCIDR) > 0 {
 		listenerCreateOpt.AllowedCIDRs = svcConf.allowedCIDR
 	}
 	return listenerCreateOpt
 }
 
+// setFloatingIP sets the pre-allocated floating IP of the floating-ip
+// annotation, only valid for an external load balancer with an IPv4 VIP.
+func setFloatingIP(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.floatingIP = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerFloatingIP, "")
+	if svcConf.floatingIP == "" {
+		return nil
+	}
+	if svcConf.internal || svcConf.vipIPv6SubnetID != "" {
+		return fmt.Errorf("annotation %s requires an external load balancer with an IPv4 VIP", ServiceAnnotationLoadBalancerFloatingIP)
+	}
+	if ip := net.ParseIP(svcConf.floatingIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid floating IP %q in annotation %s", svcConf.floatingIP, ServiceAnnotationLoadBalancerFloatingIP)
+	}
+	return nil
+}
+
 func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev1.Node, svcConf *serviceConfig) error {
 	if len(service.Spec.Ports) == 0 {
 		return fmt.Errorf("no ports provided to openstack load balancer")
@@ -1478,11 +1962,27 @@ func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev
 	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
 
 	svcConf.lbID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
-	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, lbaas.opts.LBProvider)
+	if err := setExistingLoadBalancer(service, svcConf); err != nil {
+		return err
+	}
+	if err := lbaas.setLBProvider(service, svcConf); err != nil {
+		return err
+	}
+	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, svcConf.lbProvider)
+	if err := lbaas.findExistingLoadBalancerByTag(service, svcConf); err != nil {
+		return err
+	}
+	svcConf.manageMembers = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerManageMembers, true)
+
+	// Members always keep using IPv4 addresses when the VIP is allocated from an IPv6 subnet.
+	svcConf.vipIPv6SubnetID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerVIPIPv6SubnetID, lbaas.opts.VIPIPv6SubnetID)
+	if svcConf.vipIPv6SubnetID != "" {
+		svcConf.memberIPFamily = corev1.IPv4Protocol
+	}
 
 	// Find subnet ID for creating members
-	if lbaas.opts.SubnetID != "" {
-		svcConf.lbMemberSubnetID = lbaas.opts.SubnetID
+	if lbaas.defaultSubnetID() != "" {
+		svcConf.lbMemberSubnetID = lbaas.defaultSubnetID()
 	} else {
 		svcConf.configClassName = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerClass, "")
 		if svcConf.configClassName != "" {
@@ -1495,9 +1995,11 @@ func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev
 				svcConf.lbMemberSubnetID = lbClass.SubnetID
 			}
 		} else {
-			svcConf.lbMemberSubnetID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSubnetID, lbaas.opts.SubnetID)
+			// The members are on the subnet of the nodes, even if the VIP is
+			// on the subnet of the subnet-id annotation
+			svcConf.lbMemberSubnetID = lbaas.defaultSubnetID()
 			if len(svcConf.lbMemberSubnetID) == 0 && len(nodes) > 0 {
-				subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0])
+				subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0], svcConf.memberIPFamily)
 				if err != nil {
 					return fmt.Errorf("no subnet-id found for service %s: %v", serviceName, err)
 				}
@@ -1505,35 +2007,95 @@ func (lbaas *LbaasV2) checkServiceUpdate(service *corev1.Service, nodes []*corev
 			}
 		}
 	}
+	if err := lbaas.setMemberSubnet(service, svcConf); err != nil {
+		return err
+	}
 
 	// This affects the protocol of listener and pool
-	keepClientIP := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerXForwardedFor, false)
-	useProxyProtocol := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProxyEnabled, false)
-	if useProxyProtocol && keepClientIP {
-		return fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerProxyEnabled, ServiceAnnotationLoadBalancerXForwardedFor)
+	svcConf.tlsContainerRef = getTLSContainerRefForProtocol(service, lbaas.opts.TlsContainerRef)
+	if err := lbaas.setClientIPPreservation(service, svcConf); err != nil {
+		return err
+	}
+	if err := lbaas.setSessionPersistence(service, svcConf); err != nil {
+		return err
 	}
-	svcConf.keepClientIP = keepClientIP
-	svcConf.enableProxyProtocol = useProxyProtocol
 
-	svcConf.tlsContainerRef = getStringFromServiceAnnotation(service, ServiceAnnotationTlsContainerRef, lbaas.opts.TlsContainerRef)
-	svcConf.enableMonitor = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerEnableHealthMonitor, lbaas.opts.CreateMonitor)
-	if svcConf.enableMonitor && lbaas.opts.UseOctavia && service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && service.Spec.HealthCheckNodePort > 0 {
-		svcConf.healthCheckNodePort = int(service.Spec.HealthCheckNodePort)
+	if err := lbaas.setHealthMonitor(service, svcConf); err != nil {
+		return err
 	}
-	svcConf.healthMonitorDelay = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorDelay, int(lbaas.opts.MonitorDelay.Duration.Seconds()))
-	svcConf.healthMonitorTimeout = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorTimeout, int(lbaas.opts.MonitorTimeout.Duration.Seconds()))
-	svcConf.healthMonitorMaxRetries = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetries, int(lbaas.opts.MonitorMaxRetries))
 	return nil
 }
 
+// setExistingLoadBalancer sets the load balancer of the Service to the one of
+// the existing-load-balancer-id annotation, if any. The load balancer of the
+// existing-load-balancer-tag annotation is found by
+// findExistingLoadBalancerByTag.
+func setExistingLoadBalancer(service *corev1.Service, svcConf *serviceConfig) error {
+	existingID := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerExistingID, "")
+	if getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerExistingTag, "") != "" {
+		if existingID != "" {
+			return fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerExistingID, ServiceAnnotationLoadBalancerExistingTag)
+		}
+		svcConf.existingLB = true
+		return nil
+	}
+	if existingID == "" {
+		return nil
+	}
+	if svcConf.lbID != "" && svcConf.lbID != existingID {
+		return fmt.Errorf("annotation %s of Service %s/%s is %s, it cannot be changed to %s", ServiceAnnotationLoadBalancerID, service.Namespace, service.Name, svcConf.lbID, existingID)
+	}
+	svcConf.lbID = existingID
+	svcConf.existingLB = true
+	return nil
+}
+
+// findExistingLoadBalancerByTag sets the load balancer of the Service to the
+// one with the tag of the existing-load-balancer-tag annotation, until the
+// Service records its ID in the load-balancer-id annotation, so the tag can
+// be removed from the load balancer once adopted. The load balancer is left
+// unset if none has the tag.
+func (lbaas *LbaasV2) findExistingLoadBalancerByTag(service *corev1.Service, svcConf *serviceConfig) error {
+	tag := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerExistingTag, "")
+	if tag == "" || svcConf.lbID != "" {
+		return nil
+	}
+	if !svcConf.supportLBTags {
+		return fmt.Errorf("annotation %s of Service %s/%s is only supported with the tag feature in the cloud load balancer service", ServiceAnnotationLoadBalancerExistingTag, service.Namespace, service.Name)
+	}
+
+	lbs, err := openstackutil.GetLoadBalancers(lbaas.lb, loadbalancers.ListOpts{Tags: []string{tag}})
+	if err != nil {
+		return fmt.Errorf("failed to list the load balancers with tag %s: %v", tag, err)
+	}
+	switch len(lbs) {
+	case 0:
+		return nil
+	case 1:
+		klog.InfoS("Adopting the existing load balancer with tag", "lbID", lbs[0].ID, "tag", tag, "service", klog.KObj(service))
+		svcConf.lbID = lbs[0].ID
+		return nil
+	default:
+		return fmt.Errorf("%d load balancers have tag %s of annotation %s, it must identify a single one", len(lbs), tag, ServiceAnnotationLoadBalancerExistingTag)
+	}
+}
+
 func (lbaas *LbaasV2) checkServiceDelete(service *corev1.Service, svcConf *serviceConfig) error {
 	svcConf.lbID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
-	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, lbaas.opts.LBProvider)
+	if err := setExistingLoadBalancer(service, svcConf); err != nil {
+		return err
+	}
+	svcConf.lbProvider = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProvider, lbaas.opts.LBProvider)
+	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, svcConf.lbProvider)
+	if err := lbaas.findExistingLoadBalancerByTag(service, svcConf); err != nil {
+		return err
+	}
 
 	// This affects the protocol of listener and pool
 	svcConf.keepClientIP = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerXForwardedFor, false)
-	svcConf.enableProxyProtocol = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProxyEnabled, false)
-	svcConf.tlsContainerRef = getStringFromServiceAnnotation(service, ServiceAnnotationTlsContainerRef, lbaas.opts.TlsContainerRef)
+	svcConf.proxyProtocol, _ = getProxyProtocol(service)
+	svcConf.enableProxyProtocol = svcConf.proxyProtocol != ""
+	svcConf.tlsContainerRef = getTLSContainerRefForProtocol(service, lbaas.opts.TlsContainerRef)
 
 	return nil
 }
@@ -1541,7 +2103,9 @@ func (lbaas *LbaasV2) checkServiceDelete(service *corev1.Service, svcConf *servi
 func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node, svcConf *serviceConfig) error {
 	serviceName := fmt.Sprintf("%s/%s", service.Namespace, service.Name)
 
-	if len(nodes) == 0 {
+	// The nodes are not needed when the members are managed outside of Kubernetes
+	svcConf.manageMembers = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerManageMembers, true)
+	if len(nodes) == 0 && svcConf.manageMembers {
 		return fmt.Errorf("there are no available nodes for LoadBalancer service %s", serviceName)
 	}
 	ports := service.Spec.Ports
@@ -1550,7 +2114,24 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 	}
 
 	svcConf.lbID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
-	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, lbaas.opts.LBProvider)
+	if err := setExistingLoadBalancer(service, svcConf); err != nil {
+		return err
+	}
+	if err := lbaas.setLBProvider(service, svcConf); err != nil {
+		return err
+	}
+	svcConf.supportLBTags = openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTags, svcConf.lbProvider)
+	if err := lbaas.findExistingLoadBalancerByTag(service, svcConf); err != nil {
+		return err
+	}
+	if svcConf.existingLB && svcConf.lbID == "" {
+		// The load balancer may not be created yet, the Service is retried
+		return fmt.Errorf("no load balancer has tag %s of annotation %s of Service %s", service.Annotations[ServiceAnnotationLoadBalancerExistingTag], ServiceAnnotationLoadBalancerExistingTag, serviceName)
+	}
+	svcConf.sharingGroup = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSharingGroup, "")
+	if svcConf.sharingGroup != "" && !svcConf.supportLBTags {
+		return fmt.Errorf("load balancer sharing group %s of Service %s is only supported with the tag feature in the cloud load balancer service", svcConf.sharingGroup, serviceName)
+	}
 
 	// If in the config file internal-lb=true, user is not allowed to create external service.
 	if lbaas.opts.InternalLB {
@@ -1563,7 +2144,21 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 	}
 
 	svcConf.tlsContainerRef = getStringFromServiceAnnotation(service, ServiceAnnotationTlsContainerRef, lbaas.opts.TlsContainerRef)
-	if svcConf.tlsContainerRef != "" {
+	if tlsSecret := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTLSSecret, ""); tlsSecret != "" {
+		if _, ok := service.Annotations[ServiceAnnotationTlsContainerRef]; ok {
+			return fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerTLSSecret, ServiceAnnotationTlsContainerRef)
+		}
+		if lbaas.secret == nil {
+			return fmt.Errorf("failed to create a TLS Terminated loadbalancer because openstack keymanager client is not "+
+				"initialized and tls-secret %q is set", tlsSecret)
+		}
+		ref, hash, err := lbaas.ensureTLSSecret(context.TODO(), service, tlsSecret)
+		if err != nil {
+			return err
+		}
+		svcConf.tlsContainerRef = ref
+		svcConf.tlsSecretHash = hash
+	} else if svcConf.tlsContainerRef != "" {
 		if lbaas.secret == nil {
 			return fmt.Errorf("failed to create a TLS Terminated loadbalancer because openstack keymanager client is not "+
 				"initialized and default-tls-container-ref %q is set", svcConf.tlsContainerRef)
@@ -1580,26 +2175,38 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 		klog.V(4).Infof("Default TLS container %q found", container.ContainerRef)
 	}
 
-	svcConf.connLimit = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerConnLimit, -1)
+	svcConf.vipIPv6SubnetID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerVIPIPv6SubnetID, lbaas.opts.VIPIPv6SubnetID)
+	if svcConf.vipIPv6SubnetID != "" {
+		mc := metrics.NewMetricContext("subnet", "get")
+		subnet, err := subnets.Get(lbaas.network, svcConf.vipIPv6SubnetID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			return fmt.Errorf("failed to find VIP subnet %q: %v", svcConf.vipIPv6SubnetID, err)
+		}
+		if subnet.IPVersion != 6 {
+			return fmt.Errorf("VIP subnet %q is not an IPv6 subnet", svcConf.vipIPv6SubnetID)
+		}
+		// Octavia mixed pools: the VIP is IPv6 while the members keep using the IPv4 node addresses.
+		svcConf.memberIPFamily = corev1.IPv4Protocol
+	}
+	if err := lbaas.setDualStack(service, svcConf); err != nil {
+		return err
+	}
 
-	svcConf.lbNetworkID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerNetworkID, lbaas.opts.NetworkID)
-	svcConf.lbSubnetID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSubnetID, lbaas.opts.SubnetID)
-	if lbaas.opts.SubnetID != "" {
-		svcConf.lbMemberSubnetID = lbaas.opts.SubnetID
-	} else {
-		svcConf.lbMemberSubnetID = svcConf.lbSubnetID
+	if err := lbaas.setVIPSubnet(service, nodes, svcConf); err != nil {
+		return err
 	}
-	if len(svcConf.lbNetworkID) == 0 && len(svcConf.lbSubnetID) == 0 {
-		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0])
-		if err != nil {
-			return fmt.Errorf("failed to get subnet to create load balancer for service %s: %v", serviceName, err)
-		}
-		svcConf.lbSubnetID = subnetID
-		svcConf.lbMemberSubnetID = subnetID
-		lbaas.opts.SubnetID = subnetID
+	if err := lbaas.setMemberSubnet(service, svcConf); err != nil {
+		return err
 	}
 
-	if !svcConf.internal {
+	if err := setFloatingIP(service, svcConf); err != nil {
+		return err
+	}
+
+	if svcConf.vipIPv6SubnetID != "" {
+		// There are no floating IPs for IPv6, the VIP address itself is reported in the Service status.
+		klog.V(4).Infof("Ensure a load balancer service with IPv6 VIP from subnet %s", svcConf.vipIPv6SubnetID)
+	} else if !svcConf.internal {
 		var lbClass *LBClass
 		var floatingNetworkID string
 		var floatingSubnet floatingSubnetSpec
@@ -1658,76 +2265,387 @@ func (lbaas *LbaasV2) checkService(service *corev1.Service, nodes []*corev1.Node
 			}
 		}
 
-		// check subnets belongs to network
-		if floatingNetworkID != "" && floatingSubnet.subnetID != "" {
-			mc := metrics.NewMetricContext("subnet", "get")
-			subnet, err := subnets.Get(lbaas.network, floatingSubnet.subnetID).Extract()
-			if mc.ObserveRequest(err) != nil {
-				return fmt.Errorf("failed to find subnet %q: %v", floatingSubnet.subnetID, err)
-			}
+		// check subnets belongs to network
+		if floatingNetworkID != "" && floatingSubnet.subnetID != "" {
+			mc := metrics.NewMetricContext("subnet", "get")
+			subnet, err := subnets.Get(lbaas.network, floatingSubnet.subnetID).Extract()
+			if mc.ObserveRequest(err) != nil {
+				return fmt.Errorf("failed to find subnet %q: %v", floatingSubnet.subnetID, err)
+			}
+
+			if subnet.NetworkID != floatingNetworkID {
+				return fmt.Errorf("floating IP subnet %q doesn't belong to the network %q", floatingSubnet.subnetID, subnet.NetworkID)
+			}
+		}
+
+		svcConf.lbPublicNetworkID = floatingNetworkID
+		if floatingSubnet.Configured() {
+			klog.V(4).Infof("Using subnet spec %+v for %s", floatingSubnet, serviceName)
+			svcConf.lbPublicSubnetSpec = &floatingSubnet
+		} else {
+			klog.V(4).Infof("no subnet spec found for %s", serviceName)
+		}
+	} else {
+		klog.V(4).Infof("Ensure an internal loadbalancer service.")
+	}
+
+	if err := lbaas.setClientIPPreservation(service, svcConf); err != nil {
+		return err
+	}
+	if err := lbaas.setSessionPersistence(service, svcConf); err != nil {
+		return err
+	}
+
+	if err := lbaas.setListenerLimits(service, svcConf); err != nil {
+		return err
+	}
+
+	var listenerAllowedCIDRs []string
+	sourceRanges, err := GetLoadBalancerSourceRanges(service)
+	if err != nil {
+		return fmt.Errorf("failed to get source ranges for loadbalancer service %s: %v", serviceName, err)
+	}
+	sourceRanges, svcConf.cidrSetsHash, err = lbaas.addAllowedCIDRSets(service, sourceRanges)
+	if err != nil {
+		return err
+	}
+	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureVIPACL, svcConf.lbProvider) {
+		klog.V(4).Info("LoadBalancerSourceRanges is suppported")
+		listenerAllowedCIDRs, err = getListenerAllowedCIDRs(sourceRanges, svcConf)
+		if err != nil {
+			return fmt.Errorf("invalid source ranges for loadbalancer service %s: %v", serviceName, err)
+		}
+	} else if !IsAllowAll(sourceRanges) {
+		msg := fmt.Sprintf("The source ranges %v are ignored, the allowed CIDRs of the listeners are not supported by the Octavia API or provider", sourceRanges.StringSlice())
+		klog.Warning(msg)
+		lbaas.recordEvent(service, corev1.EventTypeWarning, "LoadBalancerSourceRangesIgnored", msg)
+	}
+	svcConf.allowedCIDR = listenerAllowedCIDRs
+
+	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureFlavors, svcConf.lbProvider) {
+		if err := lbaas.setFlavor(service, svcConf); err != nil {
+			return err
+		}
+	}
+
+	availabilityZone := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerAvailabilityZone, lbaas.opts.AvailabilityZone)
+	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureAvailabilityZones, svcConf.lbProvider) {
+		svcConf.availabilityZone = availabilityZone
+	} else if availabilityZone != "" {
+		klog.Warning("LoadBalancer Availability Zones aren't supported. Please, upgrade Octavia API to version 2.14 or later (Ussuri release) to use them")
+	}
+
+	if err := lbaas.setHealthMonitor(service, svcConf); err != nil {
+		return err
+	}
+
+	if err := lbaas.setL7Policies(service, svcConf); err != nil {
+		return err
+	}
 
-			if subnet.NetworkID != floatingNetworkID {
-				return fmt.Errorf("floating IP subnet %q doesn't belong to the network %q", floatingSubnet.subnetID, subnet.NetworkID)
-			}
+	if hasProtocolPort(service, corev1.ProtocolSCTP) {
+		sctpSupported := openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureSCTP, svcConf.lbProvider)
+		if err := lbaas.checkSCTPPorts(service, svcConf, sctpSupported); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		svcConf.lbPublicNetworkID = floatingNetworkID
-		if floatingSubnet.Configured() {
-			klog.V(4).Infof("Using subnet spec %+v for %s", floatingSubnet, serviceName)
-			svcConf.lbPublicSubnetSpec = &floatingSubnet
-		} else {
-			klog.V(4).Infof("no subnet spec found for %s", serviceName)
+// maxListenerTimeout is the maximum listener timeout of Octavia in
+// milliseconds, one year.
+const maxListenerTimeout int64 = 31536000000
+
+// getListenerTimeoutAnnotation returns the listener timeout of the annotation
+// of the Service in milliseconds, and whether it is set.
+func getListenerTimeoutAnnotation(service *corev1.Service, annotation string) (int, bool, error) {
+	value, ok := service.Annotations[annotation]
+	if !ok {
+		return 0, false, nil
+	}
+	timeout, err := strconv.Atoi(value)
+	if err != nil || timeout < 0 || int64(timeout) > maxListenerTimeout {
+		return 0, false, fmt.Errorf("invalid value %q of annotation %s, must be a timeout in milliseconds between 0 and %d", value, annotation, maxListenerTimeout)
+	}
+	return timeout, true, nil
+}
+
+// setListenerLimits sets the connection limit and the timeouts of the
+// listeners from the annotations, the timeouts defaulting to the
+// configuration. The listeners are updated when they change.
+func (lbaas *LbaasV2) setListenerLimits(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.connLimit = -1
+	if value, ok := service.Annotations[ServiceAnnotationLoadBalancerConnLimit]; ok {
+		connLimit, err := strconv.Atoi(value)
+		if err != nil || connLimit < -1 {
+			return fmt.Errorf("invalid value %q of annotation %s, must be a positive integer or -1 for unlimited", value, ServiceAnnotationLoadBalancerConnLimit)
+		}
+		svcConf.connLimit = connLimit
+	}
+
+	timeouts := []struct {
+		annotation   string
+		defaultValue int
+		value        *int
+	}{
+		{ServiceAnnotationLoadBalancerTimeoutClientData, lbaas.opts.TimeoutClientData, &svcConf.timeoutClientData},
+		{ServiceAnnotationLoadBalancerTimeoutMemberConnect, lbaas.opts.TimeoutMemberConnect, &svcConf.timeoutMemberConnect},
+		{ServiceAnnotationLoadBalancerTimeoutMemberData, lbaas.opts.TimeoutMemberData, &svcConf.timeoutMemberData},
+		{ServiceAnnotationLoadBalancerTimeoutTCPInspect, lbaas.opts.TimeoutTCPInspect, &svcConf.timeoutTCPInspect},
+	}
+	var annotated []string
+	for _, t := range timeouts {
+		// Negative values are resolved per listener protocol by getListenerTimeouts.
+		*t.value = t.defaultValue
+		timeout, ok, err := getListenerTimeoutAnnotation(service, t.annotation)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*t.value = timeout
+			annotated = append(annotated, t.annotation)
 		}
-	} else {
-		klog.V(4).Infof("Ensure an internal loadbalancer service.")
 	}
 
+	if len(annotated) > 0 && !openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, svcConf.lbProvider) {
+		msg := fmt.Sprintf("Listener timeouts of annotations %s are not supported with provider %s, Octavia API v2.1 or later is required", strings.Join(annotated, ", "), svcConf.lbProvider)
+		klog.Warning(msg)
+		lbaas.recordEvent(service, corev1.EventTypeWarning, "LoadBalancerTimeoutsUnsupported", msg)
+	}
+	return nil
+}
+
+// getProxyProtocol returns the pool protocol of the PROXY protocol version of
+// the Service annotation, PROXY for "true" or "v1" and PROXYV2 for "v2", empty
+// if the PROXY protocol is disabled.
+func getProxyProtocol(service *corev1.Service) (v2pools.Protocol, error) {
+	value := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProxyEnabled, "false")
+	switch strings.ToLower(value) {
+	case "false", "":
+		return "", nil
+	case "true", "v1":
+		return v2pools.ProtocolPROXY, nil
+	case "v2":
+		return v2pools.ProtocolPROXYV2, nil
+	}
+	return "", fmt.Errorf("invalid value %q of annotation %s, must be true, false, v1 or v2", value, ServiceAnnotationLoadBalancerProxyEnabled)
+}
+
+// setClientIPPreservation sets how the load balancer passes the client address
+// to the members, either by the PROXY protocol of the pools or by the headers
+// the HTTP listeners insert in the requests. The tlsContainerRef of svcConf
+// must be set before.
+func (lbaas *LbaasV2) setClientIPPreservation(service *corev1.Service, svcConf *serviceConfig) error {
+	proxyProtocol, err := getProxyProtocol(service)
+	if err != nil {
+		return err
+	}
 	keepClientIP := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerXForwardedFor, false)
-	useProxyProtocol := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerProxyEnabled, false)
-	if useProxyProtocol && keepClientIP {
+	if proxyProtocol != "" && keepClientIP {
 		return fmt.Errorf("annotation %s and %s cannot be used together", ServiceAnnotationLoadBalancerProxyEnabled, ServiceAnnotationLoadBalancerXForwardedFor)
 	}
+	if proxyProtocol == v2pools.ProtocolPROXYV2 && !openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeaturePROXYV2, svcConf.lbProvider) {
+		return fmt.Errorf("PROXY protocol v2 of annotation %s is not supported with provider %s, Octavia API v2.22 or later is required", ServiceAnnotationLoadBalancerProxyEnabled, svcConf.lbProvider)
+	}
 	svcConf.keepClientIP = keepClientIP
-	svcConf.enableProxyProtocol = useProxyProtocol
+	svcConf.enableProxyProtocol = proxyProtocol != ""
+	svcConf.proxyProtocol = proxyProtocol
+
+	svcConf.insertHeaders = nil
+	for _, h := range []struct {
+		annotation string
+		header     string
+	}{
+		{ServiceAnnotationLoadBalancerXForwardedFor, annotationXForwardedFor},
+		{ServiceAnnotationLoadBalancerXForwardedPort, annotationXForwardedPort},
+		{ServiceAnnotationLoadBalancerXForwardedProto, annotationXForwardedProto},
+	} {
+		if getBoolFromServiceAnnotation(service, h.annotation, false) {
+			if svcConf.insertHeaders == nil {
+				svcConf.insertHeaders = make(map[string]string)
+			}
+			svcConf.insertHeaders[h.header] = "true"
+		}
+	}
+	// Only the HTTP and TERMINATED_HTTPS listeners insert headers.
+	if len(svcConf.insertHeaders) > 0 && !keepClientIP && svcConf.tlsContainerRef == "" {
+		return fmt.Errorf("annotations %s and %s require an HTTP listener, enabled by annotation %s or %s", ServiceAnnotationLoadBalancerXForwardedPort, ServiceAnnotationLoadBalancerXForwardedProto, ServiceAnnotationLoadBalancerXForwardedFor, ServiceAnnotationTlsContainerRef)
+	}
+	return nil
+}
 
-	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureTimeout, lbaas.opts.LBProvider) {
-		svcConf.timeoutClientData = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTimeoutClientData, 50000)
-		svcConf.timeoutMemberConnect = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTimeoutMemberConnect, 5000)
-		svcConf.timeoutMemberData = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTimeoutMemberData, 50000)
-		svcConf.timeoutTCPInspect = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTimeoutTCPInspect, 0)
+// isDualStackService returns true if the Service asks for both an IPv4 and an
+// IPv6 address.
+func isDualStackService(service *corev1.Service) bool {
+	policy := service.Spec.IPFamilyPolicy
+	return policy != nil && *policy != corev1.IPFamilyPolicySingleStack && len(service.Spec.IPFamilies) == 2
+}
+
+// setDualStack gives a dual-stack Service an IPv4 VIP with an additional IPv6
+// VIP from the IPv6 VIP subnet. A Service requiring dual-stack fails if that is
+// not possible, one preferring it falls back to a single VIP. setDualStack must
+// be called after the IPv6 VIP subnet is validated.
+func (lbaas *LbaasV2) setDualStack(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.additionalVIPSubnetID = ""
+	if !isDualStackService(service) {
+		return nil
 	}
 
-	var listenerAllowedCIDRs []string
-	sourceRanges, err := GetLoadBalancerSourceRanges(service)
+	var reason string
+	if svcConf.vipIPv6SubnetID == "" {
+		reason = fmt.Sprintf("no IPv6 VIP subnet is configured, set annotation %s or the vip-ipv6-subnet-id option", ServiceAnnotationLoadBalancerVIPIPv6SubnetID)
+	} else if getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerPortID, "") != "" {
+		reason = fmt.Sprintf("annotation %s is set", ServiceAnnotationLoadBalancerPortID)
+	} else if !openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureAdditionalVIPs, svcConf.lbProvider) {
+		reason = "additional VIPs are not supported by the Octavia API or provider"
+	}
+	if reason != "" {
+		if *service.Spec.IPFamilyPolicy == corev1.IPFamilyPolicyRequireDualStack {
+			return fmt.Errorf("cannot create a dual-stack load balancer for Service %s/%s: %s", service.Namespace, service.Name, reason)
+		}
+		msg := fmt.Sprintf("Creating a single-stack load balancer, %s", reason)
+		lbaas.recordEvent(service, corev1.EventTypeWarning, "LoadBalancerDualStackUnsupported", msg)
+		klog.InfoS(msg, "service", klog.KObj(service))
+		return nil
+	}
+
+	// The IPv4 VIP keeps its floating IP, the IPv6 VIP is reported as it is.
+	svcConf.additionalVIPSubnetID = svcConf.vipIPv6SubnetID
+	svcConf.vipIPv6SubnetID = ""
+	return nil
+}
+
+// getAdditionalVIPAddress returns the address of the additional VIP of the load
+// balancer in the given subnet, or "" if there is none.
+func (lbaas *LbaasV2) getAdditionalVIPAddress(lbID string, subnetID string) (string, error) {
+	vips, err := openstackutil.GetAdditionalVIPs(lbaas.lb, lbID)
 	if err != nil {
-		return fmt.Errorf("failed to get source ranges for loadbalancer service %s: %v", serviceName, err)
+		return "", fmt.Errorf("failed to get additional VIPs of load balancer %s: %v", lbID, err)
 	}
-	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureVIPACL, lbaas.opts.LBProvider) {
-		klog.V(4).Info("LoadBalancerSourceRanges is suppported")
-		listenerAllowedCIDRs = sourceRanges.StringSlice()
-	} else {
-		klog.Warning("LoadBalancerSourceRanges is ignored")
+	for _, vip := range vips {
+		if vip.SubnetID == subnetID {
+			return vip.IPAddress, nil
+		}
 	}
-	svcConf.allowedCIDR = listenerAllowedCIDRs
+	return "", nil
+}
 
-	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureFlavors, lbaas.opts.LBProvider) {
-		svcConf.flavorID = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerFlavorID, lbaas.opts.FlavorID)
+// setSessionPersistence sets the session persistence of the pools from the
+// annotations, defaulting to SOURCE_IP for the ClientIP session affinity. The
+// cookie based session persistence requires HTTP pools, so setClientIPPreservation
+// must be called before.
+func (lbaas *LbaasV2) setSessionPersistence(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.persistence = nil
+	if service.Spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		svcConf.persistence = &v2pools.SessionPersistence{Type: "SOURCE_IP"}
 	}
 
-	availabilityZone := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerAvailabilityZone, lbaas.opts.AvailabilityZone)
-	if openstackutil.IsOctaviaFeatureSupported(lbaas.lb, openstackutil.OctaviaFeatureAvailabilityZones, lbaas.opts.LBProvider) {
-		svcConf.availabilityZone = availabilityZone
-	} else if availabilityZone != "" {
-		klog.Warning("LoadBalancer Availability Zones aren't supported. Please, upgrade Octavia API to version 2.14 or later (Ussuri release) to use them")
+	persistenceType := strings.ToUpper(getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSessionPersistence, ""))
+	cookieName := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSessionPersistenceCookieName, "")
+	if cookieName != "" && persistenceType != "APP_COOKIE" {
+		return fmt.Errorf("annotation %s requires the APP_COOKIE session persistence", ServiceAnnotationLoadBalancerSessionPersistenceCookieName)
 	}
 
-	svcConf.enableMonitor = getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerEnableHealthMonitor, lbaas.opts.CreateMonitor)
-	if svcConf.enableMonitor && lbaas.opts.UseOctavia && service.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && service.Spec.HealthCheckNodePort > 0 {
-		svcConf.healthCheckNodePort = int(service.Spec.HealthCheckNodePort)
+	switch persistenceType {
+	case "":
+		return nil
+	case "NONE":
+		svcConf.persistence = nil
+		return nil
+	case "SOURCE_IP":
+		svcConf.persistence = &v2pools.SessionPersistence{Type: persistenceType}
+		return nil
+	case "HTTP_COOKIE", "APP_COOKIE":
+		if persistenceType == "APP_COOKIE" && cookieName == "" {
+			return fmt.Errorf("the APP_COOKIE session persistence requires annotation %s", ServiceAnnotationLoadBalancerSessionPersistenceCookieName)
+		}
+		if svcConf.enableProxyProtocol || (!svcConf.keepClientIP && svcConf.tlsContainerRef == "") {
+			return fmt.Errorf("the %s session persistence requires an HTTP pool, enabled by annotation %s or %s", persistenceType, ServiceAnnotationLoadBalancerXForwardedFor, ServiceAnnotationTlsContainerRef)
+		}
+		svcConf.persistence = &v2pools.SessionPersistence{Type: persistenceType, CookieName: cookieName}
+		return nil
+	}
+	return fmt.Errorf("invalid value %q of annotation %s, must be NONE, SOURCE_IP, HTTP_COOKIE or APP_COOKIE", persistenceType, ServiceAnnotationLoadBalancerSessionPersistence)
+}
+
+// poolSessionPersistenceUpdateOpts updates the session persistence of a pool,
+// removing it when nil, which v2pools.UpdateOpts can't express.
+type poolSessionPersistenceUpdateOpts struct {
+	persistence *v2pools.SessionPersistence
+}
+
+func (opts poolSessionPersistenceUpdateOpts) ToPoolUpdateMap() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"pool": map[string]interface{}{"session_persistence": opts.persistence},
+	}, nil
+}
+
+// isSessionPersistenceChanged returns whether the session persistence of the pool differs from expected.
+func isSessionPersistenceChanged(current v2pools.SessionPersistence, expected *v2pools.SessionPersistence) bool {
+	if expected == nil {
+		return current.Type != ""
+	}
+	return current.Type != expected.Type || current.CookieName != expected.CookieName
+}
+
+// getListenerInsertHeaders returns the insert headers of the listener with the
+// X-Forwarded-* headers managed by the Service annotations replaced by
+// expected, and whether they changed. Other headers are kept.
+func getListenerInsertHeaders(current map[string]string, expected map[string]string) (map[string]string, bool) {
+	insertHeaders := make(map[string]string)
+	changed := false
+	for k, v := range current {
+		switch k {
+		case annotationXForwardedFor, annotationXForwardedPort, annotationXForwardedProto:
+			if expected[k] != v {
+				changed = true
+			}
+		default:
+			insertHeaders[k] = v
+		}
+	}
+	for k, v := range expected {
+		if current[k] != v {
+			changed = true
+		}
+		insertHeaders[k] = v
+	}
+	return insertHeaders, changed
+}
+
+// hasProtocolPort reports whether the Service has a port of the protocol.
+func hasProtocolPort(service *corev1.Service, protocol corev1.Protocol) bool {
+	for _, port := range service.Spec.Ports {
+		if port.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSCTPPorts checks that the SCTP ports of the Service can be load
+// balanced. SCTP listeners are supported since Octavia API v2.23, without the
+// layer 7 features. A Warning event is recorded on the Service if the load
+// balancer service cannot do SCTP.
+func (lbaas *LbaasV2) checkSCTPPorts(service *corev1.Service, svcConf *serviceConfig, sctpSupported bool) error {
+	if !sctpSupported {
+		msg := fmt.Sprintf("The cloud load balancer service does not support SCTP with provider %s, Octavia API v2.23 or later is required", svcConf.lbProvider)
+		lbaas.recordEvent(service, corev1.EventTypeWarning, "SCTPNotSupported", msg)
+		return fmt.Errorf("SCTP ports of Service %s/%s are not supported: %s", service.Namespace, service.Name, msg)
+	}
+
+	for _, annotation := range []struct {
+		name string
+		set  bool
+	}{
+		{ServiceAnnotationLoadBalancerXForwardedFor, svcConf.keepClientIP},
+		{ServiceAnnotationLoadBalancerProxyEnabled, svcConf.enableProxyProtocol},
+		{ServiceAnnotationTlsContainerRef, svcConf.tlsContainerRef != ""},
+	} {
+		if annotation.set {
+			return fmt.Errorf("annotation %s cannot be used with the SCTP ports of Service %s/%s", annotation.name, service.Namespace, service.Name)
+		}
 	}
-	svcConf.healthMonitorDelay = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorDelay, int(lbaas.opts.MonitorDelay.Duration.Seconds()))
-	svcConf.healthMonitorTimeout = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorTimeout, int(lbaas.opts.MonitorTimeout.Duration.Seconds()))
-	svcConf.healthMonitorMaxRetries = getIntFromServiceAnnotation(service, ServiceAnnotationLoadBalancerHealthMonitorMaxRetries, int(lbaas.opts.MonitorMaxRetries))
 	return nil
 }
 
@@ -1769,6 +2687,155 @@ func (sp *servicePatcher) Patch(ctx context.Context, err error) error {
 	return utilerrors.NewAggregate([]error{err, perr})
 }
 
+// lbErrorTracker counts the consecutive reconciles that found a load balancer
+// in ERROR provisioning status.
+type lbErrorTracker struct {
+	mu          sync.Mutex
+	errorCounts map[string]int // keyed by load balancer ID
+}
+
+func newLBErrorTracker() *lbErrorTracker {
+	return &lbErrorTracker{
+		errorCounts: map[string]int{},
+	}
+}
+
+// observeError records a reconcile that found the load balancer in ERROR and
+// returns the number of consecutive such reconciles.
+func (t *lbErrorTracker) observeError(lbID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errorCounts[lbID]++
+	return t.errorCounts[lbID]
+}
+
+func (t *lbErrorTracker) reset(lbID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.errorCounts, lbID)
+}
+
+// recreateErroredLoadBalancer deletes a load balancer in ERROR provisioning
+// status and creates it again, once RecreateOnErrorThreshold consecutive
+// reconciles found it in that state. The floating IP of the load balancer is
+// tagged as kept before the deletion, so the new load balancer reuses it even
+// if the controller restarts in between.
+func (lbaas *LbaasV2) recreateErroredLoadBalancer(clusterName string, service *corev1.Service, nodes []*corev1.Node, loadbalancer *loadbalancers.LoadBalancer, isLBOwner bool, svcConf *serviceConfig) (*loadbalancers.LoadBalancer, error) {
+	count := lbaas.errorTracker.observeError(loadbalancer.ID)
+	if count < lbaas.opts.RecreateOnErrorThreshold {
+		return nil, fmt.Errorf("load balancer %s is in ERROR provisioning status, it will be recreated after %d more attempts", loadbalancer.ID, lbaas.opts.RecreateOnErrorThreshold-count)
+	}
+
+	// Never recreate load balancers that other Services or users rely on.
+	isShared := false
+	for _, tag := range loadbalancer.Tags {
+		if tag != svcConf.lbName && strings.HasPrefix(tag, servicePrefix) {
+			isShared = true
+		}
+	}
+	if !isLBOwner || isShared {
+		return nil, fmt.Errorf("load balancer %s is in ERROR provisioning status, not recreating it as it is not owned by Service %s/%s only", loadbalancer.ID, service.Namespace, service.Name)
+	}
+
+	// A floating IP of the annotation or Spec.LoadBalancerIP is found again by
+	// its address, any other one is found by the tag of the kept floating IPs.
+	if !svcConf.internal && loadbalancer.VipPortID != "" && svcConf.floatingIP == "" && service.Spec.LoadBalancerIP == "" {
+		fip, err := openstackutil.GetFloatingIPByPortID(lbaas.network, loadbalancer.VipPortID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get floating IP for loadbalancer VIP port %s: %v", loadbalancer.VipPortID, err)
+		}
+		if fip != nil {
+			if err := lbaas.tagKeptFloatingIP(clusterName, service, fip); err != nil {
+				return nil, fmt.Errorf("load balancer %s is in ERROR provisioning status, not recreating it as its floating IP %s cannot be tagged as kept: %v", loadbalancer.ID, fip.FloatingIP, err)
+			}
+		}
+	}
+
+	msg := fmt.Sprintf("Load balancer %s is in ERROR provisioning status after %d attempts, recreating it", loadbalancer.ID, count)
+	klog.InfoS("Recreating load balancer in ERROR", "lbID", loadbalancer.ID, "service", klog.KObj(service), "attempts", count)
+	lbaas.recordEvent(service, corev1.EventTypeWarning, "RecreatingLoadBalancer", msg)
+
+	if err := openstackutil.DeleteLoadbalancer(lbaas.lb, loadbalancer.ID, true); err != nil {
+		return nil, err
+	}
+	lbaas.errorTracker.reset(loadbalancer.ID)
+
+	// The old load balancer is gone, look the new one up by name if the creation fails.
+	delete(service.ObjectMeta.Annotations, ServiceAnnotationLoadBalancerID)
+	svcConf.lbID = ""
+
+	newLB, err := lbaas.createFullyPopulatedOctaviaLoadBalancer(svcConf.lbName, clusterName, service, nodes, svcConf)
+	if err != nil {
+		return nil, fmt.Errorf("error recreating loadbalancer %s: %v", svcConf.lbName, err)
+	}
+	lbaas.recordEvent(service, corev1.EventTypeNormal, "RecreatedLoadBalancer", fmt.Sprintf("Load balancer %s replaced with %s", loadbalancer.ID, newLB.ID))
+
+	return newLB, nil
+}
+
+// lockLoadBalancer serializes the operations on the load balancer of the Service, so that
+// Services sharing a load balancer are reconciled one at a time while Services using
+// different load balancers are reconciled in parallel. It returns the unlock function.
+func (lbaas *LbaasV2) lockLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service) func() {
+	if lbaas.lbLocks == nil {
+		return func() {}
+	}
+
+	// The neutron-lbaas and security group code keep per-Service state in the shared options,
+	// never run them in parallel.
+	key := "global"
+	if lbaas.opts.UseOctavia && !lbaas.opts.ManageSecurityGroups {
+		// Services sharing a load balancer all have its ID in the annotation, but the
+		// Services of a sharing group pick a load balancer of the group before having its ID,
+		// they are all locked on the group.
+		if group := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerSharingGroup, ""); group != "" {
+			key = sharingGroupTag(clusterName, group)
+		} else {
+			key = getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerID, "")
+		}
+		if key == "" {
+			key = lbaas.GetLoadBalancerName(ctx, clusterName, service)
+		}
+	}
+
+	lbaas.lbLocks.LockKey(key)
+	return func() {
+		_ = lbaas.lbLocks.UnlockKey(key)
+	}
+}
+
+// defaultSubnetID returns the subnet-id option, autodetected when the first load balancer is
+// created if not configured. It is guarded by subnetMu as the load balancers of different
+// Services are reconciled in parallel.
+func (lbaas *LbaasV2) defaultSubnetID() string {
+	if lbaas.subnetMu == nil {
+		return lbaas.opts.SubnetID
+	}
+	lbaas.subnetMu.RLock()
+	defer lbaas.subnetMu.RUnlock()
+	return lbaas.opts.SubnetID
+}
+
+func (lbaas *LbaasV2) setDefaultSubnetID(subnetID string) {
+	if lbaas.subnetMu == nil {
+		lbaas.opts.SubnetID = subnetID
+		return
+	}
+	lbaas.subnetMu.Lock()
+	defer lbaas.subnetMu.Unlock()
+	lbaas.opts.SubnetID = subnetID
+}
+
+// recordEvent records an event on the Service if an event recorder is available.
+func (lbaas *LbaasV2) recordEvent(service *corev1.Service, eventType, reason, message string) {
+	if lbaas.eventRecorder != nil {
+		lbaas.eventRecorder.Event(service, eventType, reason, message)
+	}
+}
+
 func (lbaas *LbaasV2) updateServiceAnnotation(service *corev1.Service, annotName, annotValue string) {
 	if service.ObjectMeta.Annotations == nil {
 		service.ObjectMeta.Annotations = map[string]string{}
@@ -1795,6 +2862,14 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 	isLBOwner := false
 	createNewLB := false
 
+	// Add the Service to a load balancer of its sharing group, if any
+	if svcConf.lbID == "" && svcConf.sharingGroup != "" {
+		svcConf.lbID, err = lbaas.findSharingGroupLoadBalancer(ctx, clusterName, service, svcConf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a load balancer of sharing group %s: %v", svcConf.sharingGroup, err)
+		}
+	}
+
 	// Check the load balancer in the Service annotation.
 	if svcConf.lbID != "" {
 		loadbalancer, err = openstackutil.GetLoadbalancerByID(lbaas.lb, svcConf.lbID)
@@ -1805,7 +2880,8 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		// If this LB name matches the default generated name, the Service 'owns' the LB, but it's also possible for this
 		// LB to be shared by other Services.
 		// If the names don't match, this is a LB this Service wants to attach.
-		if loadbalancer.Name == lbName {
+		// An existing load balancer is never owned, even if it has the generated name.
+		if loadbalancer.Name == lbName && !svcConf.existingLB {
 			isLBOwner = true
 		}
 
@@ -1815,7 +2891,7 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 
 		// The load balancer can only be shared with the configured number of Services.
-		if svcConf.supportLBTags {
+		if svcConf.supportLBTags && !svcConf.existingLB {
 			sharedCount := 0
 			for _, tag := range loadbalancer.Tags {
 				if strings.HasPrefix(tag, servicePrefix) {
@@ -1846,9 +2922,34 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	}
 
+	if loadbalancer.ProvisioningStatus == errorStatus && lbaas.opts.RecreateOnErrorThreshold > 0 {
+		loadbalancer, err = lbaas.recreateErroredLoadBalancer(clusterName, service, nodes, loadbalancer, isLBOwner, svcConf)
+		if err != nil {
+			return nil, err
+		}
+		createNewLB = true
+	}
+
+	if lbaas.opts.AsyncProvisioning && strings.HasPrefix(loadbalancer.ProvisioningStatus, "PENDING_") {
+		// The ID is recorded so the next pass finds the load balancer, and
+		// the Service is requeued with backoff by the service controller.
+		lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerID, loadbalancer.ID)
+		if createNewLB {
+			lbaas.recordEvent(service, corev1.EventTypeNormal, "ProvisioningLoadBalancer", fmt.Sprintf("Provisioning load balancer %s", loadbalancer.ID))
+		}
+		return nil, fmt.Errorf("%w: load balancer %s, current provisioning status: %s", errLoadBalancerProvisioning, loadbalancer.ID, loadbalancer.ProvisioningStatus)
+	}
 	if loadbalancer.ProvisioningStatus != activeStatus {
 		return nil, fmt.Errorf("load balancer %s is not ACTIVE, current provisioning status: %s", loadbalancer.ID, loadbalancer.ProvisioningStatus)
 	}
+	lbaas.errorTracker.reset(loadbalancer.ID)
+
+	// The provider of an existing load balancer cannot be changed, its pools are created for its actual provider.
+	if loadbalancer.Provider != "" && !isSameLBProvider(loadbalancer.Provider, svcConf.lbProvider) {
+		lbaas.recordEvent(service, corev1.EventTypeWarning, "LoadBalancerProviderMismatch", fmt.Sprintf("Load balancer %s uses provider %s instead of %s, it must be recreated to change its provider", loadbalancer.ID, loadbalancer.Provider, svcConf.lbProvider))
+		svcConf.lbProvider = loadbalancer.Provider
+		svcConf.lbMethod = lbaas.getLBMethod(svcConf.lbProvider)
+	}
 
 	loadbalancer.Listeners, err = openstackutil.GetListenersByLoadBalancerID(lbaas.lb, loadbalancer.ID)
 	if err != nil {
@@ -1872,20 +2973,49 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 			return nil, err
 		}
 
+		// The pools, members and health monitors of all the ports are fetched
+		// at once, the load balancer is only changed one port at a time as it
+		// is immutable until ACTIVE again after each change.
+		poolState, err := lbaas.getLBPoolState(ctx, loadbalancer.ID, svcConf.manageMembers)
+		if err != nil {
+			return nil, err
+		}
+		newPorts, createdPorts := 0, 0
+		for _, port := range service.Spec.Ports {
+			if _, ok := curListenerMapping[listenerKey{Protocol: getListenerProtocol(port.Protocol, svcConf), Port: int(port.Port)}]; !ok {
+				newPorts++
+			}
+		}
+
+		svcListeners := make(map[int32]*listeners.Listener)
+		poolIDs := make(map[int32]string)
 		for portIndex, port := range service.Spec.Ports {
+			_, exists := curListenerMapping[listenerKey{Protocol: getListenerProtocol(port.Protocol, svcConf), Port: int(port.Port)}]
 			listener, err := lbaas.ensureOctaviaListener(loadbalancer.ID, cutString(fmt.Sprintf("listener_%d_%s", portIndex, lbName)), curListenerMapping, port, svcConf, service)
 			if err != nil {
 				return nil, err
 			}
 
-			pool, err := lbaas.ensureOctaviaPool(loadbalancer.ID, cutString(fmt.Sprintf("pool_%d_%s", portIndex, lbName)), listener, service, port, nodes, svcConf)
+			pool, err := lbaas.ensureOctaviaPool(loadbalancer.ID, cutString(fmt.Sprintf("pool_%d_%s", portIndex, lbName)), listener, poolState, service, port, nodes, svcConf)
 			if err != nil {
 				return nil, err
 			}
 
-			if err := lbaas.ensureOctaviaHealthMonitor(loadbalancer.ID, cutString(fmt.Sprintf("monitor_%d_%s", portIndex, lbName)), pool, port, svcConf); err != nil {
+			if err := lbaas.ensureOctaviaHealthMonitor(loadbalancer.ID, cutString(fmt.Sprintf("monitor_%d_%s", portIndex, lbName)), pool, poolState, port, svcConf); err != nil {
 				return nil, err
 			}
+			if !exists {
+				createdPorts++
+				// Adding many ports takes minutes, the progress is reported
+				if newPorts > 1 {
+					lbaas.recordEvent(service, corev1.EventTypeNormal, "ListenerCreated", fmt.Sprintf("Created the listener and pool of port %d on load balancer %s (%d/%d new ports)", port.Port, loadbalancer.ID, createdPorts, newPorts))
+				}
+			}
+			// The L7 policies are on the TCP listener of a port shared with UDP
+			if _, ok := svcListeners[port.Port]; !ok || !isLayer4Protocol(listener.Protocol) {
+				svcListeners[port.Port] = listener
+				poolIDs[port.Port] = pool.ID
+			}
 
 			// After all ports have been processed, remaining listeners are removed if they were created by this Service.
 			// The remove of the listener must always happen at the end of the loop to avoid wrong assignment.
@@ -1893,19 +3023,42 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 			curListeners = popListener(curListeners, listener.ID)
 		}
 
+		// The policies are updated before the remaining listeners are deleted, as they may redirect to their pools.
+		if err := lbaas.ensureL7Policies(loadbalancer.ID, service, svcListeners, poolIDs, svcConf); err != nil {
+			return nil, err
+		}
+
 		// Deal with the remaining listeners, delete the listener if it was created by this Service previously.
 		if err := lbaas.deleteOctaviaListeners(loadbalancer.ID, curListeners, isLBOwner, lbName); err != nil {
 			return nil, err
 		}
+	} else if len(svcConf.l7Policies) > 0 {
+		// The fully populated load balancer is created without the L7 policies.
+		svcListeners := make(map[int32]*listeners.Listener)
+		poolIDs := make(map[int32]string)
+		for _, port := range service.Spec.Ports {
+			for i, l := range loadbalancer.Listeners {
+				if l.ProtocolPort == int(port.Port) && listeners.Protocol(l.Protocol) == getListenerProtocol(port.Protocol, svcConf) && !isLayer4Protocol(l.Protocol) {
+					svcListeners[port.Port] = &loadbalancer.Listeners[i]
+					poolIDs[port.Port] = l.DefaultPoolID
+				}
+			}
+		}
+		if err := lbaas.ensureL7Policies(loadbalancer.ID, service, svcListeners, poolIDs, svcConf); err != nil {
+			return nil, err
+		}
 	}
 
 	addr, err := lbaas.getServiceAddress(clusterName, service, loadbalancer, svcConf)
 	if err != nil {
 		return nil, err
 	}
+	lbaas.checkExternalIPs(service, addr, loadbalancer)
 
 	// Add annotation to Service and add LB name to load balancer tags.
 	lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerID, loadbalancer.ID)
+	lbaas.updateTLSSecretHash(service, svcConf)
+	lbaas.updateCIDRSetsHash(service, svcConf)
 	if svcConf.supportLBTags {
 		lbTags := loadbalancer.Tags
 		if !cpoutil.Contains(lbTags, lbName) {
@@ -1920,6 +3073,26 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 	status := &corev1.LoadBalancerStatus{
 		Ingress: []corev1.LoadBalancerIngress{{IP: addr}},
 	}
+	if svcConf.additionalVIPSubnetID != "" {
+		ipv6Addr, err := lbaas.getAdditionalVIPAddress(loadbalancer.ID, svcConf.additionalVIPSubnetID)
+		if err != nil {
+			return nil, err
+		}
+		if ipv6Addr == "" {
+			// Octavia cannot add VIPs to an existing load balancer.
+			msg := fmt.Sprintf("Load balancer %s has no IPv6 VIP, it must be recreated to be dual-stack", loadbalancer.ID)
+			lbaas.recordEvent(service, corev1.EventTypeWarning, "LoadBalancerDualStackUnsupported", msg)
+			klog.InfoS(msg, "service", klog.KObj(service))
+		} else if service.Spec.IPFamilies[0] == corev1.IPv6Protocol {
+			status.Ingress = append([]corev1.LoadBalancerIngress{{IP: ipv6Addr}}, status.Ingress...)
+		} else {
+			status.Ingress = append(status.Ingress, corev1.LoadBalancerIngress{IP: ipv6Addr})
+		}
+	}
+
+	if err := lbaas.ensureDNSRecords(clusterName, service, status.Ingress); err != nil {
+		return nil, err
+	}
 
 	// If the load balancer is using the PROXY protocol, expose its IP address via
 	// the Hostname field to prevent kube-proxy from injecting an iptables bypass.
@@ -1927,7 +3100,7 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 	// https://github.com/kubernetes/enhancements/tree/master/keps/sig-network/1860-kube-proxy-IP-node-binding
 	// is implemented (maybe in v1.22).
 	if svcConf.enableProxyProtocol && lbaas.opts.EnableIngressHostname {
-		fakeHostname := fmt.Sprintf("%s.%s", status.Ingress[0].IP, lbaas.opts.IngressHostnameSuffix)
+		fakeHostname := fmt.Sprintf("%s.%s", addr, lbaas.opts.IngressHostnameSuffix)
 		status.Ingress = []corev1.LoadBalancerIngress{{Hostname: fakeHostname}}
 	}
 
@@ -1938,24 +3111,65 @@ func (lbaas *LbaasV2) ensureOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	}
 
+	if svcConf.membersDraining {
+		return status, fmt.Errorf("%w: load balancer %s", errMembersDraining, loadbalancer.ID)
+	}
+
 	return status, nil
 }
 
 // EnsureLoadBalancer creates a new load balancer or updates the existing one.
 func (lbaas *LbaasV2) EnsureLoadBalancer(ctx context.Context, clusterName string, apiService *corev1.Service, nodes []*corev1.Node) (*corev1.LoadBalancerStatus, error) {
-	if !lbaas.opts.Enabled {
+	if !lbaas.opts.Enabled || !isMigrated(apiService) {
 		return nil, cloudprovider.ImplementedElsewhere
 	}
 
+	lbaas.gc.setClusterName(clusterName)
+	unlock := lbaas.lockLoadBalancer(ctx, clusterName, apiService)
+	defer unlock()
+
 	mc := metrics.NewMetricContext("loadbalancer", "ensure")
 	status, err := lbaas.ensureLoadBalancer(ctx, clusterName, apiService, nodes)
+	if errors.Is(err, errLoadBalancerProvisioning) || errors.Is(err, errMembersDraining) {
+		// Not a failed reconcile, the load balancer is not ACTIVE yet or
+		// members are deleted on a later pass
+		_ = mc.ObserveReconcile(nil)
+		return status, err
+	}
 	return status, mc.ObserveReconcile(err)
 }
 
+// getMemberNodes returns the nodes matching the member node selector of the
+// Service, the nodes added as members of its load balancer.
+func (lbaas *LbaasV2) getMemberNodes(service *corev1.Service, nodes []*corev1.Node) ([]*corev1.Node, error) {
+	selector := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerMemberNodeSelector, lbaas.opts.MemberNodeSelector)
+	if strings.TrimSpace(selector) == "" {
+		return nodes, nil
+	}
+	s, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid member node selector %q: %v", selector, err)
+	}
+
+	var memberNodes []*corev1.Node
+	for _, node := range nodes {
+		if s.Matches(labels.Set(node.Labels)) {
+			memberNodes = append(memberNodes, node)
+		}
+	}
+	klog.V(4).InfoS("Selected member nodes", "service", klog.KObj(service), "selector", selector, "nodes", len(nodes), "memberNodes", len(memberNodes))
+	return memberNodes, nil
+}
+
 func (lbaas *LbaasV2) ensureLoadBalancer(ctx context.Context, clusterName string, apiService *corev1.Service, nodes []*corev1.Node) (*corev1.LoadBalancerStatus, error) {
 	serviceName := fmt.Sprintf("%s/%s", apiService.Namespace, apiService.Name)
 	klog.InfoS("EnsureLoadBalancer", "cluster", clusterName, "service", klog.KObj(apiService))
 
+	nodes, err := lbaas.getMemberNodes(apiService, nodes)
+	if err != nil {
+		return nil, err
+	}
+
 	if lbaas.opts.UseOctavia {
 		return lbaas.ensureOctaviaLoadBalancer(ctx, clusterName, apiService, nodes)
 	}
@@ -1972,7 +3186,7 @@ func (lbaas *LbaasV2) ensureLoadBalancer(ctx context.Context, clusterName string
 	if len(lbaas.opts.SubnetID) == 0 && len(lbaas.opts.NetworkID) == 0 {
 		// Get SubnetID automatically.
 		// The LB needs to be configured with instance addresses on the same subnet, so get SubnetID by one node.
-		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0])
+		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0], "")
 		if err != nil {
 			klog.Warningf("Failed to find subnet-id for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 			return nil, fmt.Errorf("no subnet-id for service %s/%s : subnet-id not set in cloud provider config, "+
@@ -2200,8 +3414,10 @@ func (lbaas *LbaasV2) ensureLoadBalancer(ctx context.Context, clusterName string
 		if err != nil && !cpoerrors.IsNotFound(err) {
 			return nil, fmt.Errorf("error getting pool members %s: %v", pool.ID, err)
 		}
+		// Neutron-LBaaS has no batch update of the pool members, unlike Octavia,
+		// so the members are created and deleted one by one.
 		for _, node := range nodes {
-			addr, err := nodeAddressForLB(node)
+			addr, err := nodeAddressForLB(node, "")
 			if err != nil {
 				if err == cpoerrors.ErrNotFound {
 					// Node failure, do not create member
@@ -2385,7 +3601,7 @@ func (lbaas *LbaasV2) ensureLoadBalancer(ctx context.Context, clusterName string
 				floatIPOpts := floatingips.CreateOpts{
 					FloatingNetworkID: floatingNetworkID,
 					PortID:            portID,
-					Description:       fmt.Sprintf("Floating IP for Kubernetes external service %s from cluster %s", serviceName, clusterName),
+					Description:       floatingIPDescription(serviceName, clusterName),
 				}
 
 				if floatingSubnetID != "" {
@@ -2480,7 +3696,7 @@ func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *corev1
 	// find node-security-group for service
 	var err error
 	if len(lbaas.opts.NodeSecurityGroupIDs) == 0 && !lbaas.opts.UseOctavia {
-		lbaas.opts.NodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes)
+		lbaas.opts.NodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, lbaas.nodeNames, nodes)
 		if err != nil {
 			return fmt.Errorf("failed to find node-security-group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 		}
@@ -2628,9 +3844,9 @@ func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *corev1
 		// traffic from Octavia amphorae to the node port on the worker nodes.
 		if lbaas.opts.UseOctavia {
 			mc := metrics.NewMetricContext("subnet", "get")
-			subnet, err := subnets.Get(lbaas.network, lbaas.opts.SubnetID).Extract()
+			subnet, err := subnets.Get(lbaas.network, lbaas.defaultSubnetID()).Extract()
 			if mc.ObserveRequest(err) != nil {
-				return fmt.Errorf("failed to find subnet %s from openstack: %v", lbaas.opts.SubnetID, err)
+				return fmt.Errorf("failed to find subnet %s from openstack: %v", lbaas.defaultSubnetID(), err)
 			}
 
 			sgListopts := rules.ListOpts{
@@ -2666,7 +3882,7 @@ func (lbaas *LbaasV2) ensureSecurityGroup(clusterName string, apiService *corev1
 				return fmt.Errorf("failed to create rule for security group %s: %v", lbSecGroupID, err)
 			}
 
-			if err := applyNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes, lbSecGroupID); err != nil {
+			if err := applyNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, lbaas.nodeNames, nodes, lbSecGroupID); err != nil {
 				return err
 			}
 		} else {
@@ -2745,6 +3961,13 @@ func (lbaas *LbaasV2) updateOctaviaLoadBalancer(ctx context.Context, clusterName
 		lbListeners[key] = l
 	}
 
+	// The pools and members of all the ports are fetched at once, so the
+	// Services with many ports are updated with fewer requests.
+	poolState, err := lbaas.getLBPoolState(ctx, loadbalancer.ID, svcConf.manageMembers)
+	if err != nil {
+		return err
+	}
+
 	// Update pool members for each listener.
 	for portIndex, port := range service.Spec.Ports {
 		proto := getListenerProtocol(port.Protocol, svcConf)
@@ -2756,7 +3979,7 @@ func (lbaas *LbaasV2) updateOctaviaLoadBalancer(ctx context.Context, clusterName
 			return fmt.Errorf("loadbalancer %s does not contain required listener for port %d and protocol %s", loadbalancer.ID, port.Port, port.Protocol)
 		}
 
-		_, err := lbaas.ensureOctaviaPool(loadbalancer.ID, cutString(fmt.Sprintf("pool_%d_%s", portIndex, loadbalancer.Name)), &listener, service, port, nodes, svcConf)
+		_, err := lbaas.ensureOctaviaPool(loadbalancer.ID, cutString(fmt.Sprintf("pool_%d_%s", portIndex, loadbalancer.Name)), &listener, poolState, service, port, nodes, svcConf)
 		if err != nil {
 			return err
 		}
@@ -2769,20 +3992,38 @@ func (lbaas *LbaasV2) updateOctaviaLoadBalancer(ctx context.Context, clusterName
 		}
 	}
 
+	if svcConf.membersDraining {
+		return fmt.Errorf("%w: load balancer %s", errMembersDraining, loadbalancer.ID)
+	}
+
 	return nil
 }
 
 // UpdateLoadBalancer updates hosts under the specified load balancer.
 func (lbaas *LbaasV2) UpdateLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) error {
-	if !lbaas.opts.Enabled {
+	if !lbaas.opts.Enabled || !isMigrated(service) {
 		return cloudprovider.ImplementedElsewhere
 	}
+	lbaas.gc.setClusterName(clusterName)
+	unlock := lbaas.lockLoadBalancer(ctx, clusterName, service)
+	defer unlock()
+
 	mc := metrics.NewMetricContext("loadbalancer", "update")
 	err := lbaas.updateLoadBalancer(ctx, clusterName, service, nodes)
+	if errors.Is(err, errMembersDraining) {
+		// Not a failed reconcile, the drained members are deleted on a later pass
+		_ = mc.ObserveReconcile(nil)
+		return err
+	}
 	return mc.ObserveReconcile(err)
 }
 
 func (lbaas *LbaasV2) updateLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) error {
+	nodes, err := lbaas.getMemberNodes(service, nodes)
+	if err != nil {
+		return err
+	}
+
 	if lbaas.opts.UseOctavia {
 		return lbaas.updateOctaviaLoadBalancer(ctx, clusterName, service, nodes)
 	}
@@ -2797,7 +4038,7 @@ func (lbaas *LbaasV2) updateLoadBalancer(ctx context.Context, clusterName string
 	if len(lbaas.opts.SubnetID) == 0 && len(nodes) > 0 {
 		// Get SubnetID automatically.
 		// The LB needs to be configured with instance addresses on the same subnet, so get SubnetID by one node.
-		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0])
+		subnetID, err := getSubnetIDForLB(lbaas.compute, *nodes[0], "")
 		if err != nil {
 			klog.Warningf("Failed to find subnet-id for loadbalancer service %s/%s: %v", service.Namespace, service.Name, err)
 			return fmt.Errorf("no subnet-id for service %s/%s : subnet-id not set in cloud provider config, "+
@@ -2851,7 +4092,7 @@ func (lbaas *LbaasV2) updateLoadBalancer(ctx context.Context, clusterName string
 	// Compose Set of member (addresses) that _should_ exist
 	addrs := make(map[string]*corev1.Node)
 	for _, node := range nodes {
-		addr, err := nodeAddressForLB(node)
+		addr, err := nodeAddressForLB(node, "")
 		if err != nil {
 			return err
 		}
@@ -2885,7 +4126,8 @@ func (lbaas *LbaasV2) updateLoadBalancer(ctx context.Context, clusterName string
 			members[member.Address] = member
 		}
 
-		// Add any new members for this port
+		// Add any new members for this port, one by one as Neutron-LBaaS has no
+		// batch update of the pool members
 		for addr, node := range addrs {
 			if _, ok := members[addr]; ok && members[addr].ProtocolPort == int(port.NodePort) {
 				// Already exists, do not create member
@@ -2941,7 +4183,7 @@ func (lbaas *LbaasV2) updateSecurityGroup(_ string, apiService *corev1.Service,
 	originalNodeSecurityGroupIDs := lbaas.opts.NodeSecurityGroupIDs
 
 	var err error
-	lbaas.opts.NodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, nodes)
+	lbaas.opts.NodeSecurityGroupIDs, err = getNodeSecurityGroupIDForLB(lbaas.compute, lbaas.network, lbaas.nodeNames, nodes)
 	if err != nil {
 		return fmt.Errorf("failed to find node-security-group for loadbalancer service %s/%s: %v", apiService.Namespace, apiService.Name, err)
 	}
@@ -3021,6 +4263,15 @@ func (lbaas *LbaasV2) updateSecurityGroup(_ string, apiService *corev1.Service,
 
 // EnsureLoadBalancerDeleted deletes the specified load balancer
 func (lbaas *LbaasV2) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *corev1.Service) error {
+	if !isMigrated(service) {
+		// The load balancer is deleted by the in-tree cloud provider
+		klog.V(4).Infof("Service %s/%s is not migrated, skipping the deletion of its load balancer", service.Namespace, service.Name)
+		return nil
+	}
+
+	unlock := lbaas.lockLoadBalancer(ctx, clusterName, service)
+	defer unlock()
+
 	mc := metrics.NewMetricContext("loadbalancer", "delete")
 	err := lbaas.ensureLoadBalancerDeleted(ctx, clusterName, service)
 	return mc.ObserveReconcile(err)
@@ -3082,6 +4333,12 @@ func (lbaas *LbaasV2) ensureLoadBalancerDeleted(ctx context.Context, clusterName
 		return err
 	}
 
+	if published := service.Annotations[ServiceAnnotationLoadBalancerDNSPublishedHostname]; published != "" {
+		if err := lbaas.deleteDNSRecords(clusterName, service, published); err != nil {
+			return err
+		}
+	}
+
 	if svcConf.lbID != "" {
 		loadbalancer, err = openstackutil.GetLoadbalancerByID(lbaas.lb, svcConf.lbID)
 	} else {
@@ -3115,14 +4372,14 @@ func (lbaas *LbaasV2) ensureLoadBalancerDeleted(ctx context.Context, clusterName
 
 	// If the LB is shared by other Service or the LB was not created by occm, the LB should not be deleted.
 	needDeleteLB := true
-	if isSharedLB || !isCreatedByOCCM {
+	if isSharedLB || !isCreatedByOCCM || svcConf.existingLB {
 		needDeleteLB = false
 	}
 
 	klog.V(4).InfoS("Deleting service", "service", klog.KObj(service), "needDeleteLB", needDeleteLB, "isSharedLB", isSharedLB, "updateLBTag", updateLBTag, "isCreatedByOCCM", isCreatedByOCCM)
 
 	keepFloatingAnnotation := getBoolFromServiceAnnotation(service, ServiceAnnotationLoadBalancerKeepFloatingIP, false)
-	if needDeleteLB && !keepFloatingAnnotation {
+	if needDeleteLB {
 		if loadbalancer.VipPortID != "" {
 			portID := loadbalancer.VipPortID
 			fip, err := openstackutil.GetFloatingIPByPortID(lbaas.network, portID)
@@ -3130,8 +4387,16 @@ func (lbaas *LbaasV2) ensureLoadBalancerDeleted(ctx context.Context, clusterName
 				return fmt.Errorf("failed to get floating IP for loadbalancer VIP port %s: %v", portID, err)
 			}
 
+			// The floating IP is kept even if it cannot be tagged.
+			if fip != nil && keepFloatingAnnotation {
+				if err := lbaas.tagKeptFloatingIP(clusterName, service, fip); err != nil {
+					msg := fmt.Sprintf("Floating IP %s is kept but cannot be tagged for the recreation of the Service: %v", fip.FloatingIP, err)
+					lbaas.recordEvent(service, corev1.EventTypeWarning, "KeptFloatingIPNotTagged", msg)
+					klog.InfoS(msg, "service", klog.KObj(service))
+				}
+			}
 			// Delete the floating IP only if it was created dynamically by the controller manager.
-			if fip != nil {
+			if fip != nil && !keepFloatingAnnotation {
 				klog.InfoS("Matching floating IP", "floatingIP", fip.FloatingIP, "description", fip.Description)
 				matched, err := regexp.Match("Floating IP for Kubernetes external service", []byte(fip.Description))
 				if err != nil {
@@ -3254,6 +4519,13 @@ func (lbaas *LbaasV2) ensureLoadBalancerDeleted(ctx context.Context, clusterName
 		}
 	}
 
+	// Delete the Barbican secrets of the TLS Secret
+	if _, ok := service.Annotations[ServiceAnnotationLoadBalancerTLSSecretHash]; ok && lbaas.secret != nil {
+		if err := lbaas.deleteTLSSecrets(service, ""); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -3329,6 +4601,47 @@ func IsAllowAll(ipnets netsets.IPNet) bool {
 	return false
 }
 
+// getListenerAllowedCIDRs returns the allowed CIDRs of the listeners from the
+// source ranges, only those of the IP families of the VIPs, which Octavia
+// requires. The default source range, allowing all the sources, allows those
+// of the IPv6 VIP too. The IP family of the VIP is only known with an IPv6 VIP
+// subnet, otherwise all the source ranges are allowed.
+func getListenerAllowedCIDRs(sourceRanges netsets.IPNet, svcConf *serviceConfig) ([]string, error) {
+	var ipv4, ipv6 bool
+	switch {
+	case svcConf.additionalVIPSubnetID != "":
+		ipv4, ipv6 = true, true
+	case svcConf.vipIPv6SubnetID != "":
+		ipv6 = true
+	default:
+		return sourceRanges.StringSlice(), nil
+	}
+
+	if len(sourceRanges) == 1 && IsAllowAll(sourceRanges) {
+		var cidrs []string
+		if ipv4 {
+			cidrs = append(cidrs, "0.0.0.0/0")
+		}
+		if ipv6 {
+			cidrs = append(cidrs, "::/0")
+		}
+		return cidrs, nil
+	}
+
+	var cidrs []string
+	for _, cidr := range sourceRanges.StringSlice() {
+		isIPv6 := sourceRanges[cidr].IP.To4() == nil
+		if (isIPv6 && ipv6) || (!isIPv6 && ipv4) {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	if len(cidrs) == 0 {
+		// No allowed CIDR would allow all the sources
+		return nil, fmt.Errorf("none of the source ranges %v is of the IP family of the VIP", sourceRanges.StringSlice())
+	}
+	return cidrs, nil
+}
+
 // GetLoadBalancerSourceRanges first try to parse and verify LoadBalancerSourceRanges field from a service.
 // If the field is not specified, turn to parse and verify the AnnotationLoadBalancerSourceRangesKey annotation from a service,
 // extracting the source ranges to allow, and if not present returns a default (allow-all) value.
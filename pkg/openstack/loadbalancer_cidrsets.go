@@ -0,0 +1,220 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+	netsets "k8s.io/cloud-provider-openstack/pkg/util/net/sets"
+)
+
+// cidrSetResource is the cluster-scoped CIDRSet custom resource, a named list
+// of CIDRs in spec.cidrs which Services allow with annotation
+// ServiceAnnotationLoadBalancerAllowedCIDRSets. Its definition is in
+// manifests/controller-manager/cidrset-crd.yaml.
+var cidrSetResource = schema.GroupVersionResource{Group: "loadbalancer.openstack.org", Version: "v1alpha1", Resource: "cidrsets"}
+
+// getCIDRSetCIDRs returns the CIDRs of a CIDRSet object.
+func getCIDRSetCIDRs(obj interface{}) ([]string, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected CIDRSet object type %T", obj)
+	}
+	cidrs, _, err := unstructured.NestedStringSlice(u.Object, "spec", "cidrs")
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDRSet %s: %v", u.GetName(), err)
+	}
+	return cidrs, nil
+}
+
+// getAllowedCIDRSetNames returns the names of the CIDRSet objects allowed by
+// the Service.
+func getAllowedCIDRSetNames(service *corev1.Service) []string {
+	var names []string
+	for _, name := range strings.Split(getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerAllowedCIDRSets, ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// addAllowedCIDRSets returns the source ranges of the Service with the CIDRs of
+// the CIDRSet objects it allows, and the hash of these CIDRs. The default
+// source range, which allows everything, is replaced when the Service sets no
+// source range.
+func (lbaas *LbaasV2) addAllowedCIDRSets(service *corev1.Service, sourceRanges netsets.IPNet) (netsets.IPNet, string, error) {
+	names := getAllowedCIDRSetNames(service)
+	if len(names) == 0 {
+		return sourceRanges, "", nil
+	}
+	if lbaas.cidrSetLister == nil {
+		return nil, "", fmt.Errorf("annotation %s requires the cidr-sets option", ServiceAnnotationLoadBalancerAllowedCIDRSets)
+	}
+
+	var cidrs []string
+	for _, name := range names {
+		obj, err := lbaas.cidrSetLister.Get(name)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get CIDRSet %s: %v", name, err)
+		}
+		setCIDRs, err := getCIDRSetCIDRs(obj)
+		if err != nil {
+			return nil, "", err
+		}
+		cidrs = append(cidrs, setCIDRs...)
+	}
+	setRanges, err := netsets.ParseIPNets(cidrs...)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid CIDRs in CIDRSets %s: %v", strings.Join(names, ","), err)
+	}
+
+	if len(service.Spec.LoadBalancerSourceRanges) == 0 && service.Annotations[corev1.AnnotationLoadBalancerSourceRangesKey] == "" {
+		sourceRanges = netsets.IPNet{}
+	}
+	for _, ipNet := range setRanges {
+		sourceRanges.Insert(ipNet)
+	}
+
+	allowed := sourceRanges.StringSlice()
+	sort.Strings(allowed)
+	h := sha256.New()
+	h.Write([]byte(strings.Join(allowed, ",")))
+	return sourceRanges, hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// updateCIDRSetsHash records the hash of the CIDRs of the CIDRSet objects used
+// by the listeners of the Service.
+func (lbaas *LbaasV2) updateCIDRSetsHash(service *corev1.Service, svcConf *serviceConfig) {
+	if svcConf.cidrSetsHash != "" {
+		lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerAllowedCIDRSetsHash, svcConf.cidrSetsHash)
+	} else {
+		delete(service.Annotations, ServiceAnnotationLoadBalancerAllowedCIDRSetsHash)
+	}
+}
+
+// cidrSetWatcher requeues the Services allowing a CIDRSet when it changes, so
+// the allowed CIDRs of their listeners are updated. The Services are requeued
+// by removing their CIDR sets hash annotation, as the service controller
+// ensures the load balancer of a Service when its annotations change. A Service
+// allowing a missing CIDRSet fails and is retried by the service controller, so
+// the creation of a CIDRSet is not watched.
+type cidrSetWatcher struct {
+	kclient       kubernetes.Interface
+	serviceLister corelisters.ServiceLister
+}
+
+func newCIDRSetWatcher(kclient kubernetes.Interface, serviceLister corelisters.ServiceLister) *cidrSetWatcher {
+	return &cidrSetWatcher{kclient: kclient, serviceLister: serviceLister}
+}
+
+func (w *cidrSetWatcher) onCIDRSetUpdate(oldObj, newObj interface{}) {
+	oldCIDRs, oldErr := getCIDRSetCIDRs(oldObj)
+	newCIDRs, newErr := getCIDRSetCIDRs(newObj)
+	if oldErr == nil && newErr == nil && cpoutil.StringListEqual(oldCIDRs, newCIDRs) {
+		return
+	}
+	w.requeueServices(newObj)
+}
+
+func (w *cidrSetWatcher) onCIDRSetDelete(obj interface{}) {
+	w.requeueServices(obj)
+}
+
+func (w *cidrSetWatcher) requeueServices(obj interface{}) {
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		klog.Errorf("Failed to get the name of CIDRSet %v: %v", obj, err)
+		return
+	}
+
+	services, err := w.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list the Services: %v", err)
+		return
+	}
+	for _, service := range services {
+		if _, ok := service.Annotations[ServiceAnnotationLoadBalancerAllowedCIDRSetsHash]; !ok {
+			continue
+		}
+		if !cpoutil.Contains(getAllowedCIDRSetNames(service), name) {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{ServiceAnnotationLoadBalancerAllowedCIDRSetsHash: nil},
+			},
+		})
+		if err != nil {
+			klog.Errorf("Failed to build annotation patch for Service %s/%s: %v", service.Namespace, service.Name, err)
+			continue
+		}
+		if _, err := w.kclient.CoreV1().Services(service.Namespace).Patch(context.TODO(), service.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			klog.Errorf("Failed to requeue Service %s/%s for the update of CIDRSet %s: %v", service.Namespace, service.Name, name, err)
+			continue
+		}
+		klog.InfoS("Updating the allowed CIDRs of the Service", "service", klog.KObj(service), "cidrSet", name)
+	}
+}
+
+// watchCIDRSets starts the informer of the CIDRSet objects, whose lister is
+// given to the load balancers, and requeues the Services allowing a CIDRSet
+// when it changes.
+func (os *OpenStack) watchCIDRSets(clientBuilder cloudprovider.ControllerClientBuilder, informerFactory informers.SharedInformerFactory, stop <-chan struct{}) error {
+	config, err := clientBuilder.Config("cloud-controller-manager")
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	informer := dynamicInformerFactory.ForResource(cidrSetResource)
+	watcher := newCIDRSetWatcher(os.kclient, informerFactory.Core().V1().Services().Lister())
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: watcher.onCIDRSetUpdate,
+		DeleteFunc: watcher.onCIDRSetDelete,
+	})
+	os.cidrSetLister = informer.Lister()
+	dynamicInformerFactory.Start(stop)
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newCIDRSet(name string, cidrs ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "loadbalancer.openstack.org/v1alpha1",
+		"kind":       "CIDRSet",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"cidrs": cidrs},
+	}}
+}
+
+func TestAddAllowedCIDRSets(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, indexer.Add(newCIDRSet("corp", "10.0.0.0/8", "192.168.32.0/24")))
+	assert.NoError(t, indexer.Add(newCIDRSet("vpn", "172.16.0.0/12")))
+	assert.NoError(t, indexer.Add(newCIDRSet("invalid", "10.0.0.0")))
+	lister := cache.NewGenericLister(indexer, cidrSetResource.GroupResource())
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		sourceRanges []string
+		expected     []string
+		expectedErr  bool
+	}{
+		{
+			name:     "no CIDR sets",
+			expected: []string{"0.0.0.0/0"},
+		},
+		{
+			name:        "CIDR sets replace the default source range",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerAllowedCIDRSets: "corp, vpn"},
+			expected:    []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.32.0/24"},
+		},
+		{
+			name:         "CIDR sets are added to the source ranges",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerAllowedCIDRSets: "vpn"},
+			sourceRanges: []string{"203.0.113.0/24"},
+			expected:     []string{"172.16.0.0/12", "203.0.113.0/24"},
+		},
+		{
+			name:        "missing CIDR set",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerAllowedCIDRSets: "corp,missing"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid CIDR",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerAllowedCIDRSets: "invalid"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{cidrSetLister: lister}}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations},
+				Spec:       corev1.ServiceSpec{LoadBalancerSourceRanges: test.sourceRanges},
+			}
+			sourceRanges, err := GetLoadBalancerSourceRanges(service)
+			assert.NoError(t, err)
+
+			sourceRanges, hash, err := lbaas.addAllowedCIDRSets(service, sourceRanges)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			allowed := sourceRanges.StringSlice()
+			sort.Strings(allowed)
+			assert.Equal(t, test.expected, allowed)
+			assert.Equal(t, len(test.annotations) > 0, hash != "")
+		})
+	}
+}
+
+func TestAddAllowedCIDRSetsDisabled(t *testing.T) {
+	lbaas := &LbaasV2{LoadBalancer{}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ServiceAnnotationLoadBalancerAllowedCIDRSets: "corp"}}}
+	_, _, err := lbaas.addAllowedCIDRSets(service, nil)
+	assert.Error(t, err)
+}
+
+func TestCIDRSetWatcher(t *testing.T) {
+	newService := func(name, cidrSets, hash string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerAllowedCIDRSets:     cidrSets,
+			ServiceAnnotationLoadBalancerAllowedCIDRSetsHash: hash,
+		}}}
+	}
+	services := []*corev1.Service{
+		newService("updated", "vpn,corp", "previous"),
+		newService("other", "vpn", "previous"),
+	}
+
+	kclient := fake.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, s := range services {
+		_, err := kclient.CoreV1().Services(s.Namespace).Create(context.TODO(), s, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, indexer.Add(s))
+	}
+
+	watcher := newCIDRSetWatcher(kclient, corelisters.NewServiceLister(indexer))
+	// A resync without changes does not requeue the Services.
+	watcher.onCIDRSetUpdate(newCIDRSet("vpn", "172.16.0.0/12"), newCIDRSet("vpn", "172.16.0.0/12"))
+	watcher.onCIDRSetUpdate(newCIDRSet("corp", "10.0.0.0/8"), newCIDRSet("corp", "10.0.0.0/8", "192.168.32.0/24"))
+
+	for _, test := range []struct {
+		name      string
+		wantFound bool
+	}{
+		{name: "updated"},
+		{name: "other", wantFound: true},
+	} {
+		s, err := kclient.CoreV1().Services("default").Get(context.TODO(), test.name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		_, found := s.Annotations[ServiceAnnotationLoadBalancerAllowedCIDRSetsHash]
+		assert.Equal(t, test.wantFound, found, test.name)
+	}
+}
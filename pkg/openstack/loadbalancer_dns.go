@@ -0,0 +1,233 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+const (
+	dnsRecordTypeA    = "A"
+	dnsRecordTypeAAAA = "AAAA"
+)
+
+// dnsRecordDescription is the description of the recordsets published for
+// the Service, which marks the recordsets the controller owns.
+func dnsRecordDescription(clusterName string, service *corev1.Service) string {
+	return fmt.Sprintf("DNS record for Kubernetes external service %s/%s from cluster %s", service.Namespace, service.Name, clusterName)
+}
+
+// normalizeDNSHostname returns the host name in the form of the Designate
+// recordset names, lower case and fully qualified.
+func normalizeDNSHostname(hostname string) string {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if hostname != "" && !strings.HasSuffix(hostname, ".") {
+		hostname += "."
+	}
+	return hostname
+}
+
+// getDNSRecords returns the addresses of the ingress of the load balancer by
+// record type.
+func getDNSRecords(ingress []corev1.LoadBalancerIngress) map[string][]string {
+	records := map[string][]string{dnsRecordTypeA: nil, dnsRecordTypeAAAA: nil}
+	for _, i := range ingress {
+		ip := net.ParseIP(i.IP)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			records[dnsRecordTypeA] = append(records[dnsRecordTypeA], i.IP)
+		} else {
+			records[dnsRecordTypeAAAA] = append(records[dnsRecordTypeAAAA], i.IP)
+		}
+	}
+	return records
+}
+
+// getDNSZone returns the zone of the project holding the host name, the zone
+// with the longest name if several zones hold it.
+func (lbaas *LbaasV2) getDNSZone(hostname string) (*zones.Zone, error) {
+	mc := metrics.NewMetricContext("dns_zone", "list")
+	allPages, err := zones.List(lbaas.dns, zones.ListOpts{}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to list DNS zones: %v", err)
+	}
+	allZones, err := zones.ExtractZones(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS zones: %v", err)
+	}
+
+	var zone *zones.Zone
+	for i, z := range allZones {
+		if (hostname == z.Name || strings.HasSuffix(hostname, "."+z.Name)) && (zone == nil || len(z.Name) > len(zone.Name)) {
+			zone = &allZones[i]
+		}
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("no DNS zone of the project holds host name %s", hostname)
+	}
+	return zone, nil
+}
+
+// getDNSRecordSets returns the recordsets of the host name in the zone by type.
+func (lbaas *LbaasV2) getDNSRecordSets(zoneID, hostname string) (map[string]recordsets.RecordSet, error) {
+	mc := metrics.NewMetricContext("dns_recordset", "list")
+	allPages, err := recordsets.ListByZone(lbaas.dns, zoneID, recordsets.ListOpts{Name: hostname}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to list the DNS records of %s: %v", hostname, err)
+	}
+	allRecordSets, err := recordsets.ExtractRecordSets(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the DNS records of %s: %v", hostname, err)
+	}
+
+	rrs := make(map[string]recordsets.RecordSet)
+	for _, rr := range allRecordSets {
+		if rr.Name == hostname {
+			rrs[rr.Type] = rr
+		}
+	}
+	return rrs, nil
+}
+
+// ensureDNSRecords publishes the addresses of the load balancer as the A and
+// AAAA records of the host name of annotation
+// ServiceAnnotationLoadBalancerDNSHostname, and removes the records of the
+// host name previously published. The recordsets not created for the Service
+// are never changed.
+func (lbaas *LbaasV2) ensureDNSRecords(clusterName string, service *corev1.Service, ingress []corev1.LoadBalancerIngress) error {
+	hostname := normalizeDNSHostname(getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSHostname, ""))
+	published := service.Annotations[ServiceAnnotationLoadBalancerDNSPublishedHostname]
+	if hostname == "" && published == "" {
+		return nil
+	}
+
+	if published != "" && published != hostname {
+		if err := lbaas.deleteDNSRecords(clusterName, service, published); err != nil {
+			return err
+		}
+		delete(service.Annotations, ServiceAnnotationLoadBalancerDNSPublishedHostname)
+	}
+	if hostname == "" {
+		return nil
+	}
+	if lbaas.dns == nil {
+		return fmt.Errorf("annotation %s requires the dns-records option", ServiceAnnotationLoadBalancerDNSHostname)
+	}
+
+	zone, err := lbaas.getDNSZone(hostname)
+	if err != nil {
+		return err
+	}
+	rrs, err := lbaas.getDNSRecordSets(zone.ID, hostname)
+	if err != nil {
+		return err
+	}
+
+	description := dnsRecordDescription(clusterName, service)
+	for _, rrType := range []string{dnsRecordTypeA, dnsRecordTypeAAAA} {
+		records := getDNSRecords(ingress)[rrType]
+		rr, found := rrs[rrType]
+		if found && rr.Description != description {
+			msg := fmt.Sprintf("DNS record %s %s exists and was not created for the Service, it is not changed", rrType, hostname)
+			lbaas.recordEvent(service, corev1.EventTypeWarning, "DNSRecordConflict", msg)
+			return errors.New(msg)
+		}
+
+		switch {
+		case !found && len(records) > 0:
+			klog.InfoS("Creating DNS record", "service", klog.KObj(service), "name", hostname, "type", rrType, "records", records)
+			opts := recordsets.CreateOpts{Name: hostname, Type: rrType, TTL: lbaas.opts.DNSRecordTTL, Description: description, Records: records}
+			mc := metrics.NewMetricContext("dns_recordset", "create")
+			_, err := recordsets.Create(lbaas.dns, zone.ID, opts).Extract()
+			if mc.ObserveRequest(err) != nil {
+				return fmt.Errorf("failed to create DNS record %s %s: %v", rrType, hostname, err)
+			}
+		case found && len(records) == 0:
+			if err := lbaas.deleteDNSRecordSet(zone.ID, rr); err != nil {
+				return err
+			}
+		case found && (!cpoutil.StringListEqual(rr.Records, records) || (lbaas.opts.DNSRecordTTL > 0 && rr.TTL != lbaas.opts.DNSRecordTTL)):
+			klog.InfoS("Updating DNS record", "service", klog.KObj(service), "name", hostname, "type", rrType, "records", records)
+			opts := recordsets.UpdateOpts{Records: records}
+			if lbaas.opts.DNSRecordTTL > 0 {
+				opts.TTL = &lbaas.opts.DNSRecordTTL
+			}
+			mc := metrics.NewMetricContext("dns_recordset", "update")
+			_, err := recordsets.Update(lbaas.dns, zone.ID, rr.ID, opts).Extract()
+			if mc.ObserveRequest(err) != nil {
+				return fmt.Errorf("failed to update DNS record %s %s: %v", rrType, hostname, err)
+			}
+		}
+	}
+
+	lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerDNSPublishedHostname, hostname)
+	return nil
+}
+
+// deleteDNSRecords removes the A and AAAA records of the host name created
+// for the Service.
+func (lbaas *LbaasV2) deleteDNSRecords(clusterName string, service *corev1.Service, hostname string) error {
+	if lbaas.dns == nil {
+		klog.Warningf("The DNS records of %s published for Service %s/%s are not removed without the dns-records option", hostname, service.Namespace, service.Name)
+		return nil
+	}
+
+	zone, err := lbaas.getDNSZone(hostname)
+	if err != nil {
+		// The zone was deleted with its records
+		klog.Warningf("Failed to get the DNS zone of the records of %s published for Service %s/%s: %v", hostname, service.Namespace, service.Name, err)
+		return nil
+	}
+	rrs, err := lbaas.getDNSRecordSets(zone.ID, hostname)
+	if err != nil {
+		return err
+	}
+
+	description := dnsRecordDescription(clusterName, service)
+	for _, rr := range rrs {
+		if (rr.Type != dnsRecordTypeA && rr.Type != dnsRecordTypeAAAA) || rr.Description != description {
+			continue
+		}
+		if err := lbaas.deleteDNSRecordSet(zone.ID, rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lbaas *LbaasV2) deleteDNSRecordSet(zoneID string, rr recordsets.RecordSet) error {
+	klog.InfoS("Deleting DNS record", "name", rr.Name, "type", rr.Type, "records", rr.Records)
+	mc := metrics.NewMetricContext("dns_recordset", "delete")
+	err := recordsets.Delete(lbaas.dns, zoneID, rr.ID).ExtractErr()
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("failed to delete DNS record %s %s: %v", rr.Type, rr.Name, err)
+	}
+	return nil
+}
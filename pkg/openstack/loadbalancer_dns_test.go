@@ -0,0 +1,104 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNormalizeDNSHostname(t *testing.T) {
+	assert.Equal(t, "web.example.com.", normalizeDNSHostname(" Web.Example.com"))
+	assert.Equal(t, "web.example.com.", normalizeDNSHostname("web.example.com."))
+	assert.Equal(t, "", normalizeDNSHostname(""))
+}
+
+func TestGetDNSRecords(t *testing.T) {
+	records := getDNSRecords([]corev1.LoadBalancerIngress{{IP: "172.24.4.10"}, {IP: "2001:db8::10"}, {Hostname: "lb.example.com"}})
+	assert.Equal(t, []string{"172.24.4.10"}, records[dnsRecordTypeA])
+	assert.Equal(t, []string{"2001:db8::10"}, records[dnsRecordTypeAAAA])
+}
+
+func TestEnsureDNSRecords(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{
+		ServiceAnnotationLoadBalancerDNSHostname: "web.apps.example.com",
+	}}}
+	description := dnsRecordDescription("kubernetes", service)
+
+	th.Mux.HandleFunc("/zones", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"zones": [{"id": "zone1", "name": "example.com."}, {"id": "zone2", "name": "apps.example.com."}, {"id": "zone3", "name": "other.com."}]}`)
+	})
+	var created, deleted []string
+	th.Mux.HandleFunc("/zones/zone2/recordsets", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "web.apps.example.com.", r.URL.Query().Get("name"))
+			fmt.Fprintf(w, `{"recordsets": [{"id": "rr-aaaa", "name": "web.apps.example.com.", "type": "AAAA", "records": ["2001:db8::10"], "description": "%s"}]}`, description)
+		case http.MethodPost:
+			th.TestJSONRequest(t, r, fmt.Sprintf(`{"name": "web.apps.example.com.", "type": "A", "records": ["172.24.4.10"], "ttl": 300, "description": "%s"}`, description))
+			created = append(created, "A")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id": "rr-a", "name": "web.apps.example.com.", "type": "A", "records": ["172.24.4.10"]}`)
+		}
+	})
+	th.Mux.HandleFunc("/zones/zone2/recordsets/rr-aaaa", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodDelete)
+		deleted = append(deleted, "AAAA")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{dns: fakeclient.ServiceClient(), opts: LoadBalancerOpts{DNSRecordTTL: 300}}}
+	err := lbaas.ensureDNSRecords("kubernetes", service, []corev1.LoadBalancerIngress{{IP: "172.24.4.10"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, created)
+	// The IPv6 address is not published anymore
+	assert.Equal(t, []string{"AAAA"}, deleted)
+	assert.Equal(t, "web.apps.example.com.", service.Annotations[ServiceAnnotationLoadBalancerDNSPublishedHostname])
+
+	// The records of another Service are not changed
+	other := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", Annotations: map[string]string{
+		ServiceAnnotationLoadBalancerDNSHostname: "web.apps.example.com.",
+	}}}
+	err = lbaas.ensureDNSRecords("kubernetes", other, []corev1.LoadBalancerIngress{{IP: "2001:db8::20"}})
+	assert.Error(t, err)
+	assert.Empty(t, other.Annotations[ServiceAnnotationLoadBalancerDNSPublishedHostname])
+}
+
+func TestEnsureDNSRecordsDisabled(t *testing.T) {
+	lbaas := &LbaasV2{LoadBalancer{}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		ServiceAnnotationLoadBalancerDNSHostname: "web.example.com",
+	}}}
+	assert.Error(t, lbaas.ensureDNSRecords("kubernetes", service, nil))
+
+	// The published host name is forgotten without the option
+	service.Annotations = map[string]string{ServiceAnnotationLoadBalancerDNSPublishedHostname: "web.example.com."}
+	assert.NoError(t, lbaas.ensureDNSRecords("kubernetes", service, nil))
+	assert.NotContains(t, service.Annotations, ServiceAnnotationLoadBalancerDNSPublishedHostname)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// toBeDeletedTaint is the taint of the nodes being removed by the cluster autoscaler.
+const toBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+
+// errMembersDraining is returned by EnsureLoadBalancer and UpdateLoadBalancer
+// when members of removed nodes are kept until the end of their drain period,
+// so the Service is reconciled again to delete them.
+var errMembersDraining = errors.New("load balancer members are draining")
+
+// memberDrainTracker remembers since when the members of the nodes removed
+// from the load balancers are draining.
+type memberDrainTracker struct {
+	mu     sync.Mutex
+	starts map[string]time.Time // keyed by pool ID, address and port of the member
+}
+
+func newMemberDrainTracker() *memberDrainTracker {
+	return &memberDrainTracker{
+		starts: map[string]time.Time{},
+	}
+}
+
+// observe returns since when the member is draining, now if it just started.
+func (t *memberDrainTracker) observe(key string, now time.Time) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if start, ok := t.starts[key]; ok {
+		return start, false
+	}
+	t.starts[key] = now
+	return now, true
+}
+
+func (t *memberDrainTracker) forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.starts, key)
+}
+
+// isNodeDraining returns true if the node is cordoned or being removed by the
+// cluster autoscaler.
+func isNodeDraining(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == toBeDeletedTaint {
+			return true
+		}
+	}
+	return false
+}
+
+// isMemberDrainEnabled returns true if the members of the load balancer of
+// the Service are drained before they are deleted. The ovn provider does not
+// support the weight of the members.
+func (lbaas *LbaasV2) isMemberDrainEnabled(svcConf *serviceConfig) bool {
+	return lbaas.opts.MemberDrainPeriod.Duration > 0 && svcConf.lbProvider != "ovn"
+}
+
+// memberKey returns the key comparing the current and the wanted members of a
+// pool. The weight is only compared when the members are drained, the members
+// with a weight of 0 being the draining ones.
+func (lbaas *LbaasV2) memberKey(address string, port, monitorPort int, weight *int, svcConf *serviceConfig) string {
+	key := fmt.Sprintf("%s-%d-%d", address, port, monitorPort)
+	if lbaas.isMemberDrainEnabled(svcConf) && weight != nil && *weight == 0 {
+		key += "-draining"
+	}
+	return key
+}
+
+// keepDrainingMembers adds to the wanted members of the pool the current
+// members of the removed nodes, with a weight of 0 until the end of their
+// drain period. It returns the members and whether some are still draining.
+func (lbaas *LbaasV2) keepDrainingMembers(service *corev1.Service, poolID string, poolMembers []v2pools.Member, members []v2pools.BatchUpdateMemberOpts, newMembers sets.String, svcConf *serviceConfig) ([]v2pools.BatchUpdateMemberOpts, bool) {
+	wanted := sets.NewString()
+	for _, m := range members {
+		wanted.Insert(fmt.Sprintf("%s/%s/%d", poolID, m.Address, m.ProtocolPort))
+	}
+	for key := range wanted {
+		lbaas.drainTracker.forget(key)
+	}
+
+	draining := false
+	now := time.Now()
+	for _, m := range poolMembers {
+		key := fmt.Sprintf("%s/%s/%d", poolID, m.Address, m.ProtocolPort)
+		if wanted.Has(key) {
+			continue
+		}
+		start, started := lbaas.drainTracker.observe(key, now)
+		if now.Sub(start) >= lbaas.opts.MemberDrainPeriod.Duration {
+			klog.InfoS("Deleting drained member", "poolID", poolID, "address", m.Address, "port", m.ProtocolPort)
+			lbaas.drainTracker.forget(key)
+			continue
+		}
+		if started {
+			msg := fmt.Sprintf("Draining member %s (%s:%d) of pool %s for %v before deleting it", m.Name, m.Address, m.ProtocolPort, poolID, lbaas.opts.MemberDrainPeriod.Duration)
+			klog.InfoS(msg, "service", klog.KObj(service))
+			lbaas.recordEvent(service, corev1.EventTypeNormal, "DrainingMember", msg)
+		}
+
+		name, subnetID, weight := m.Name, m.SubnetID, 0
+		member := v2pools.BatchUpdateMemberOpts{
+			Address:      m.Address,
+			ProtocolPort: m.ProtocolPort,
+			Name:         &name,
+			SubnetID:     &subnetID,
+			Weight:       &weight,
+		}
+		if m.MonitorPort != 0 {
+			monitorPort := m.MonitorPort
+			member.MonitorPort = &monitorPort
+		}
+		members = append(members, member)
+		newMembers.Insert(lbaas.memberKey(m.Address, m.ProtocolPort, m.MonitorPort, &weight, svcConf))
+		draining = true
+	}
+	return members, draining
+}
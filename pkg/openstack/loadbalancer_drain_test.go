@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+func TestIsNodeDraining(t *testing.T) {
+	assert.False(t, isNodeDraining(&corev1.Node{}))
+	assert.True(t, isNodeDraining(&corev1.Node{Spec: corev1.NodeSpec{Unschedulable: true}}))
+	assert.True(t, isNodeDraining(&corev1.Node{Spec: corev1.NodeSpec{Taints: []corev1.Taint{{Key: toBeDeletedTaint, Effect: corev1.TaintEffectNoSchedule}}}}))
+}
+
+func TestEnsureOctaviaPoolDrainsMembers(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var updates []map[string]interface{}
+	th.Mux.HandleFunc("/lbaas/pools/pool1/members", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodPut)
+		var body struct {
+			Members []map[string]interface{} `json:"members"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		updates = body.Members
+		w.WriteHeader(http.StatusAccepted)
+	})
+	th.Mux.HandleFunc("/lbaas/loadbalancers/lb1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"loadbalancer": {"id": "lb1", "provisioning_status": "ACTIVE"}}`))
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{
+		lb:           fakeclient.ServiceClient(),
+		opts:         LoadBalancerOpts{MemberDrainPeriod: util.MyDuration{Duration: time.Hour}},
+		drainTracker: newMemberDrainTracker(),
+	}}
+	listener := &listeners.Listener{ID: "listener1", Protocol: "TCP"}
+	nodes := []*corev1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       corev1.NodeSpec{Unschedulable: true},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.10"}}},
+	}}
+	// node2 was removed from the cluster
+	state := &lbPoolState{
+		pools: map[string]*v2pools.Pool{"listener1": {ID: "pool1", Protocol: "TCP"}},
+		members: map[string][]v2pools.Member{"pool1": {
+			{ID: "member1", Name: "node1", Address: "10.0.0.10", ProtocolPort: 30080, Weight: 1},
+			{ID: "member2", Name: "node2", Address: "10.0.0.11", ProtocolPort: 30080, Weight: 1},
+		}},
+	}
+	svcConf := &serviceConfig{manageMembers: true}
+
+	_, err := lbaas.ensureOctaviaPool("lb1", "pool", listener, state, &corev1.Service{}, corev1.ServicePort{NodePort: 30080}, nodes, svcConf)
+	require.NoError(t, err)
+	assert.True(t, svcConf.membersDraining)
+	require.Len(t, updates, 2)
+	for _, m := range updates {
+		assert.EqualValues(t, 0, m["weight"])
+	}
+
+	// The drain period of node2 has elapsed and node1 is uncordoned
+	lbaas.opts.MemberDrainPeriod.Duration = time.Nanosecond
+	nodes[0].Spec.Unschedulable = false
+	svcConf = &serviceConfig{manageMembers: true}
+
+	_, err = lbaas.ensureOctaviaPool("lb1", "pool", listener, state, &corev1.Service{}, corev1.ServicePort{NodePort: 30080}, nodes, svcConf)
+	require.NoError(t, err)
+	assert.False(t, svcConf.membersDraining)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "10.0.0.10", updates[0]["address"])
+	assert.EqualValues(t, 1, updates[0]["weight"])
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	eventExternalIPNotFound     = "ExternalIPNotFound"
+	eventExternalIPUnassociated = "ExternalIPUnassociated"
+)
+
+// checkExternalIPs warns about the external IPs of the Service which cannot
+// receive traffic. kube-proxy only handles the traffic to an external IP
+// which reaches a node, so an external IP must be the address of the load
+// balancer, or an address of a Neutron port or an associated floating IP of
+// the project. Otherwise the traffic to it is silently dropped.
+func (lbaas *LbaasV2) checkExternalIPs(service *corev1.Service, addr string, loadbalancer *loadbalancers.LoadBalancer) {
+	for _, ip := range service.Spec.ExternalIPs {
+		if ip == addr || ip == loadbalancer.VipAddress {
+			continue
+		}
+
+		reason, msg, err := lbaas.checkExternalIP(ip)
+		if err != nil {
+			klog.Warningf("Failed to check external IP %s of Service %s/%s: %v", ip, service.Namespace, service.Name, err)
+			continue
+		}
+		if msg != "" {
+			klog.Warningf("Service %s/%s: %s", service.Namespace, service.Name, msg)
+			lbaas.recordEvent(service, corev1.EventTypeWarning, reason, msg)
+		}
+	}
+}
+
+// checkExternalIP returns the reason and message of the warning about the
+// external IP, or an empty message if the IP can receive traffic.
+func (lbaas *LbaasV2) checkExternalIP(ip string) (string, string, error) {
+	fips, err := openstackutil.GetFloatingIPs(lbaas.network, floatingips.ListOpts{FloatingIP: ip})
+	if err != nil {
+		return "", "", err
+	}
+	if len(fips) > 0 {
+		if fips[0].PortID == "" {
+			return eventExternalIPUnassociated, fmt.Sprintf("External IP %s is a floating IP which is not associated to any port, traffic to it is dropped", ip), nil
+		}
+		return "", "", nil
+	}
+
+	ports, err := openstackutil.GetPorts(lbaas.network, neutronports.ListOpts{FixedIPs: []neutronports.FixedIPOpts{{IPAddress: ip}}})
+	if err != nil {
+		return "", "", err
+	}
+	if len(ports) > 0 {
+		return "", "", nil
+	}
+
+	return eventExternalIPNotFound, fmt.Sprintf("External IP %s is neither the load balancer address nor an address of a Neutron port or floating IP of the project, traffic to it may be dropped", ip), nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	neutrontags "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// keptFloatingIPTagPrefix is the prefix of the tag of the floating IPs kept
+// on the deletion of their Service with annotation
+// ServiceAnnotationLoadBalancerKeepFloatingIP.
+const keptFloatingIPTagPrefix = "kube_service_fip_"
+
+// keptFloatingIPTag returns the tag of the floating IP kept for the Service,
+// which identifies the Service of the cluster by its namespace and name. The
+// identity is hashed, as the Neutron tags are limited to 60 characters.
+func keptFloatingIPTag(clusterName string, service *corev1.Service) string {
+	sum := sha256.Sum256([]byte(clusterName + "," + service.Namespace + "," + service.Name))
+	return keptFloatingIPTagPrefix + hex.EncodeToString(sum[:])[:40]
+}
+
+// tagKeptFloatingIP tags the floating IP kept on the deletion of the load
+// balancer of the Service, so it is associated again with the load balancer
+// of a Service of the same namespace and name.
+func (lbaas *LbaasV2) tagKeptFloatingIP(clusterName string, service *corev1.Service, fip *floatingips.FloatingIP) error {
+	tag := keptFloatingIPTag(clusterName, service)
+	if cpoutil.Contains(fip.Tags, tag) {
+		return nil
+	}
+	mc := metrics.NewMetricContext("tag", "add")
+	if err := mc.ObserveRequest(neutrontags.Add(lbaas.network, "floatingips", fip.ID, tag).ExtractErr()); err != nil {
+		return err
+	}
+	klog.InfoS("Kept floating IP for the recreation of the service", "floatingIP", fip.FloatingIP, "service", klog.KObj(service), "tag", tag)
+	return nil
+}
+
+// reuseKeptFloatingIP associates the floating IP kept on the deletion of a
+// previous Service of the same namespace and name with the VIP port, and
+// removes its tag. It returns nil if no such floating IP is available.
+func (lbaas *LbaasV2) reuseKeptFloatingIP(clusterName string, service *corev1.Service, portID string) (*floatingips.FloatingIP, error) {
+	tag := keptFloatingIPTag(clusterName, service)
+	fips, err := openstackutil.GetFloatingIPs(lbaas.network, floatingips.ListOpts{Tags: tag})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the floating IPs kept for the service: %v", err)
+	}
+
+	for _, fip := range fips {
+		// The tags filter is ignored without the standard-attr-tag extension
+		if fip.PortID != "" || !cpoutil.Contains(fip.Tags, tag) {
+			continue
+		}
+
+		klog.InfoS("Reusing the floating IP kept for the service", "floatingIP", fip.FloatingIP, "service", klog.KObj(service), "portID", portID)
+		mc := metrics.NewMetricContext("floating_ip", "update")
+		floatIP, err := floatingips.Update(lbaas.network, fip.ID, floatingips.UpdateOpts{PortID: &portID}).Extract()
+		if mc.ObserveRequest(err) != nil {
+			return nil, fmt.Errorf("failed to associate the kept floating IP %s with port %s: %v", fip.FloatingIP, portID, err)
+		}
+
+		mc = metrics.NewMetricContext("tag", "delete")
+		if err := mc.ObserveRequest(neutrontags.Delete(lbaas.network, "floatingips", fip.ID, tag).ExtractErr()); err != nil {
+			klog.Warningf("Failed to remove tag %s of the reused floating IP %s: %v", tag, fip.FloatingIP, err)
+		}
+		return floatIP, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestKeptFloatingIPTag(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	tag := keptFloatingIPTag("kubernetes", service)
+	assert.LessOrEqual(t, len(tag), 60)
+	assert.Equal(t, tag, keptFloatingIPTag("kubernetes", service.DeepCopy()))
+	assert.NotEqual(t, tag, keptFloatingIPTag("other", service))
+
+	other := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod"}}
+	assert.NotEqual(t, tag, keptFloatingIPTag("kubernetes", other))
+}
+
+func TestReuseKeptFloatingIP(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	tag := keptFloatingIPTag("kubernetes", service)
+
+	untagged := false
+	th.Mux.HandleFunc("/floatingips", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("tags") != tag {
+			fmt.Fprint(w, `{"floatingips": []}`)
+			return
+		}
+		// fip1 is in use, fip3 is returned as if the tags filter was ignored
+		fmt.Fprintf(w, `{"floatingips": [
+			{"id": "fip1", "floating_ip_address": "172.24.4.10", "port_id": "port1", "tags": ["%[1]s"]},
+			{"id": "fip3", "floating_ip_address": "172.24.4.30", "port_id": "", "tags": []},
+			{"id": "fip2", "floating_ip_address": "172.24.4.20", "port_id": "", "tags": ["%[1]s"]}
+		]}`, tag)
+	})
+	th.Mux.HandleFunc("/floatingips/fip2", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodPut)
+		th.TestJSONRequest(t, r, `{"floatingip": {"port_id": "vip-port"}}`)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"floatingip": {"id": "fip2", "floating_ip_address": "172.24.4.20", "port_id": "vip-port"}}`)
+	})
+	th.Mux.HandleFunc("/floatingips/fip2/tags/"+tag, func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodDelete)
+		untagged = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{network: fakeclient.ServiceClient()}}
+	fip, err := lbaas.reuseKeptFloatingIP("kubernetes", service, "vip-port")
+	assert.NoError(t, err)
+	if assert.NotNil(t, fip) {
+		assert.Equal(t, "172.24.4.20", fip.FloatingIP)
+		assert.Equal(t, "vip-port", fip.PortID)
+	}
+	assert.True(t, untagged)
+
+	// No floating IP was kept for another Service
+	other := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}}
+	fip, err = lbaas.reuseKeptFloatingIP("kubernetes", other, "vip-port")
+	assert.NoError(t, err)
+	assert.Nil(t, fip)
+}
+
+func TestRecreateErroredLoadBalancerTagsFloatingIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		tagStatus int
+		expectErr string
+		deleted   bool
+	}{
+		{
+			name:      "floating IP tagged before the deletion",
+			tagStatus: http.StatusCreated,
+			expectErr: "error deleting loadbalancer lb1",
+			deleted:   true,
+		},
+		{
+			name:      "not recreated if the floating IP cannot be tagged",
+			tagStatus: http.StatusInternalServerError,
+			expectErr: "cannot be tagged as kept",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+			tag := keptFloatingIPTag("kubernetes", service)
+
+			tagged, deleted := false, false
+			th.Mux.HandleFunc("/floatingips", func(w http.ResponseWriter, r *http.Request) {
+				th.TestFormValues(t, r, map[string]string{"port_id": "vip-port"})
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"floatingips": [{"id": "fip1", "floating_ip_address": "172.24.4.10", "port_id": "vip-port"}]}`)
+			})
+			th.Mux.HandleFunc("/floatingips/fip1/tags/"+tag, func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, http.MethodPut)
+				tagged = true
+				w.WriteHeader(test.tagStatus)
+			})
+			th.Mux.HandleFunc("/lbaas/loadbalancers/lb1", func(w http.ResponseWriter, r *http.Request) {
+				th.TestMethod(t, r, http.MethodDelete)
+				assert.True(t, tagged, "floating IP must be tagged before the deletion")
+				deleted = true
+				w.WriteHeader(http.StatusConflict)
+			})
+
+			lbaas := &LbaasV2{LoadBalancer{
+				network:      fakeclient.ServiceClient(),
+				lb:           fakeclient.ServiceClient(),
+				opts:         LoadBalancerOpts{RecreateOnErrorThreshold: 1},
+				errorTracker: newLBErrorTracker(),
+			}}
+			lb := &loadbalancers.LoadBalancer{ID: "lb1", VipPortID: "vip-port", Tags: []string{"kube_service_kubernetes_default_web"}}
+			svcConf := &serviceConfig{lbName: "kube_service_kubernetes_default_web"}
+
+			_, err := lbaas.recreateErroredLoadBalancer("kubernetes", service, nil, lb, true, svcConf)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.expectErr)
+			}
+			assert.True(t, tagged)
+			assert.Equal(t, test.deleted, deleted)
+		})
+	}
+}
+
+func TestSetFloatingIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		floatingIP string
+		svcConf    serviceConfig
+		expected   string
+		expectErr  bool
+	}{
+		{name: "no annotation"},
+		{name: "floating IP", floatingIP: "172.24.4.10", expected: "172.24.4.10"},
+		{name: "invalid address", floatingIP: "172.24.4", expectErr: true},
+		{name: "IPv6 address", floatingIP: "2001:db8::10", expectErr: true},
+		{name: "internal load balancer", floatingIP: "172.24.4.10", svcConf: serviceConfig{internal: true}, expectErr: true},
+		{name: "IPv6 VIP", floatingIP: "172.24.4.10", svcConf: serviceConfig{vipIPv6SubnetID: "subnet-v6"}, expectErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+			if test.floatingIP != "" {
+				service.Annotations = map[string]string{ServiceAnnotationLoadBalancerFloatingIP: test.floatingIP}
+			}
+			svcConf := test.svcConf
+			err := setFloatingIP(service, &svcConf)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, svcConf.floatingIP)
+		})
+	}
+}
+
+func TestGetServiceAddressFloatingIPAnnotation(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/floatingips", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("port_id") == "attached-port" {
+			fmt.Fprint(w, `{"floatingips": [{"id": "fip1", "floating_ip_address": "172.24.4.10", "port_id": "attached-port"}]}`)
+			return
+		}
+		// The floating IP of the annotation was not allocated beforehand
+		fmt.Fprint(w, `{"floatingips": []}`)
+	})
+	th.Mux.HandleFunc("/floatingips/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected floating IP request %s %s", r.Method, r.URL)
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{network: fakeclient.ServiceClient(), eventRecorder: recorder}}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{ServiceAnnotationLoadBalancerFloatingIP: "172.24.4.20"},
+		},
+	}
+	svcConf := &serviceConfig{lbPublicNetworkID: "public"}
+	assert.NoError(t, setFloatingIP(service, svcConf))
+
+	// A missing floating IP fails instead of allocating another one
+	lb := &loadbalancers.LoadBalancer{ID: "lb1", VipPortID: "vip-port", VipAddress: "10.0.0.10"}
+	_, err := lbaas.getServiceAddress("kubernetes", service, lb, svcConf)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ServiceAnnotationLoadBalancerFloatingIP)
+	}
+	assert.Empty(t, recorder.Events)
+
+	// The load balancer keeps the floating IP already associated with its VIP
+	lb = &loadbalancers.LoadBalancer{ID: "lb1", VipPortID: "attached-port", VipAddress: "10.0.0.10"}
+	address, err := lbaas.getServiceAddress("kubernetes", service, lb, svcConf)
+	assert.NoError(t, err)
+	assert.Equal(t, "172.24.4.10", address)
+
+	close(recorder.Events)
+	var events []string
+	for e := range recorder.Events {
+		events = append(events, strings.Fields(e)[1])
+	}
+	assert.Equal(t, []string{"FloatingIPMismatch"}, events)
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	floatingIPDescriptionPrefix = "Floating IP for Kubernetes external service "
+	// defaultClusterName is the default --cluster-name, which the clusters
+	// sharing a project may all have
+	defaultClusterName = "kubernetes"
+)
+
+// floatingIPDescription is the description of the floating IPs created for
+// the load balancers of the Services.
+func floatingIPDescription(serviceName, clusterName string) string {
+	return fmt.Sprintf("%s%s from cluster %s", floatingIPDescriptionPrefix, serviceName, clusterName)
+}
+
+// lbGarbageCollector records the name of the cluster, learnt from the service
+// controller as the cloud provider is not told about it otherwise, to find
+// the load balancer resources of the cluster.
+type lbGarbageCollector struct {
+	mu          sync.Mutex
+	clusterName string
+}
+
+func newLBGarbageCollector() *lbGarbageCollector {
+	return &lbGarbageCollector{}
+}
+
+// setClusterName records the name of the cluster of the load balancers. It
+// is safe to call on a nil collector.
+func (gc *lbGarbageCollector) setClusterName(clusterName string) {
+	if gc == nil {
+		return
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.clusterName = clusterName
+}
+
+func (gc *lbGarbageCollector) getClusterName() string {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.clusterName
+}
+
+// lbReferences are the load balancer resources of the cluster used by the
+// Services
+type lbReferences struct {
+	// prefix is the prefix of the names and tags of the load balancers of
+	// the cluster
+	prefix string
+	// names are the load balancer names of the Services, the tags of their
+	// load balancers and listeners
+	names sets.String
+	// ids are the IDs of the load balancers in the annotations of the Services
+	ids sets.String
+	// descriptions are the descriptions of the floating IPs of the Services
+	descriptions sets.String
+}
+
+// isOrphaned returns whether the tags, or the name, of a resource identify a
+// Service of the cluster, and none of them a Service which still exists.
+func (refs *lbReferences) isOrphaned(tags ...string) bool {
+	found := false
+	for _, tag := range tags {
+		if refs.names.Has(tag) {
+			return false
+		}
+		if strings.HasPrefix(tag, refs.prefix) {
+			found = true
+		}
+	}
+	return found
+}
+
+// hasPrefix returns whether one of the tags identifies a Service of the
+// cluster.
+func (refs *lbReferences) hasPrefix(tags ...string) bool {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, refs.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectGarbage deletes the load balancer resources of the cluster which are
+// not used by any Service anymore, e.g. left behind by failed reconciles or
+// by Services deleted while openstack-cloud-controller-manager was down:
+//   - the load balancers created for Services of the cluster, by their name
+//     and description, unless shared with a Service which still exists.
+//     They are deleted in cascade.
+//   - the listeners tagged for Services on the other load balancers, with
+//     their pools, and the pools of the cluster without listener.
+//   - the detached floating IPs created for Services, unless kept with the
+//     keep-floatingip annotation.
+//
+// The resources are found by the name of the cluster, nothing is collected
+// with the default name, which would match the resources of the other
+// clusters of the project. With the gc-dry-run option, the orphaned
+// resources are only logged.
+func (lbaas *LbaasV2) collectGarbage(ctx context.Context) {
+	clusterName := lbaas.gc.getClusterName()
+	if clusterName == "" {
+		klog.V(4).Info("Not collecting the orphaned load balancer resources yet, no Service was reconciled")
+		return
+	}
+	if clusterName == defaultClusterName {
+		klog.Warningf("Not collecting the orphaned load balancer resources, the cluster name is the default %q, which other clusters of the project may have too, set a unique --cluster-name", clusterName)
+		return
+	}
+
+	services, err := lbaas.kclient.CoreV1().Services(corev1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list Services to collect the orphaned load balancer resources: %v", err)
+		return
+	}
+	refs := lbaas.getLBReferences(ctx, clusterName, services.Items)
+
+	lbs, err := openstackutil.GetLoadBalancers(lbaas.lb, loadbalancers.ListOpts{})
+	if err != nil {
+		klog.Errorf("Failed to list the load balancers to collect the orphaned ones: %v", err)
+		return
+	}
+	for i := range lbs {
+		lb := &lbs[i]
+		if strings.HasPrefix(lb.ProvisioningStatus, "PENDING_") {
+			continue
+		}
+		// The name of a cluster may be the prefix of another one's, e.g.
+		// prod and prod_eu, the description has the full cluster name
+		if strings.HasPrefix(lb.Name, refs.prefix) && strings.HasSuffix(lb.Description, " from cluster "+clusterName) &&
+			!refs.ids.Has(lb.ID) && refs.isOrphaned(append([]string{lb.Name}, lb.Tags...)...) {
+			lbaas.collectLoadBalancer(lb)
+			continue
+		}
+		if !refs.ids.Has(lb.ID) && !refs.hasPrefix(lb.Tags...) {
+			// Not a load balancer shared by the Services of the cluster
+			continue
+		}
+		if err := lbaas.collectListeners(lb, refs); err != nil {
+			klog.Warningf("Failed to collect the orphaned listeners and pools of load balancer %s: %v", lb.ID, err)
+		}
+	}
+
+	fips, err := openstackutil.GetFloatingIPs(lbaas.network, floatingips.ListOpts{})
+	if err != nil {
+		klog.Errorf("Failed to list the floating IPs to collect the orphaned ones: %v", err)
+		return
+	}
+	for i := range fips {
+		fip := &fips[i]
+		if fip.PortID != "" || !strings.HasPrefix(fip.Description, floatingIPDescriptionPrefix) ||
+			!strings.HasSuffix(fip.Description, " from cluster "+clusterName) || refs.descriptions.Has(fip.Description) {
+			continue
+		}
+		kept := false
+		for _, tag := range fip.Tags {
+			if strings.HasPrefix(tag, keptFloatingIPTagPrefix) {
+				kept = true
+			}
+		}
+		if !kept {
+			lbaas.collectFloatingIP(fip)
+		}
+	}
+}
+
+func (lbaas *LbaasV2) getLBReferences(ctx context.Context, clusterName string, services []corev1.Service) *lbReferences {
+	refs := &lbReferences{
+		prefix:       servicePrefix + clusterName + "_",
+		names:        sets.NewString(),
+		ids:          sets.NewString(),
+		descriptions: sets.NewString(),
+	}
+	for i := range services {
+		svc := &services[i]
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		refs.names.Insert(lbaas.GetLoadBalancerName(ctx, clusterName, svc))
+		refs.descriptions.Insert(floatingIPDescription(fmt.Sprintf("%s/%s", svc.Namespace, svc.Name), clusterName))
+		for _, annotation := range []string{ServiceAnnotationLoadBalancerID, ServiceAnnotationLoadBalancerExistingID} {
+			if id := getStringFromServiceAnnotation(svc, annotation, ""); id != "" {
+				refs.ids.Insert(id)
+			}
+		}
+	}
+	return refs
+}
+
+func (lbaas *LbaasV2) collectLoadBalancer(lb *loadbalancers.LoadBalancer) {
+	if lbaas.opts.GCDryRun {
+		klog.InfoS("Found orphaned load balancer, not deleted in dry-run mode", "lbID", lb.ID, "lbName", lb.Name)
+		return
+	}
+	klog.InfoS("Deleting orphaned load balancer", "lbID", lb.ID, "lbName", lb.Name)
+	if err := openstackutil.DeleteLoadbalancer(lbaas.lb, lb.ID, true); err != nil {
+		klog.Warningf("Failed to delete orphaned load balancer %s: %v", lb.ID, err)
+		return
+	}
+	klog.InfoS("Deleted orphaned load balancer", "lbID", lb.ID, "lbName", lb.Name)
+}
+
+// collectListeners deletes the orphaned listeners and pools of a load
+// balancer in use.
+func (lbaas *LbaasV2) collectListeners(lb *loadbalancers.LoadBalancer, refs *lbReferences) error {
+	if lb.ProvisioningStatus != activeStatus {
+		return nil
+	}
+
+	// The listeners are not deleted while a Service is reconciled, see
+	// lockLoadBalancer
+	key := lb.ID
+	for _, tag := range lb.Tags {
+		if strings.HasPrefix(tag, sharingGroupPrefix) {
+			key = tag
+		}
+	}
+	if lbaas.opts.ManageSecurityGroups {
+		key = "global"
+	}
+	if lbaas.lbLocks != nil {
+		lbaas.lbLocks.LockKey(key)
+		defer func() {
+			_ = lbaas.lbLocks.UnlockKey(key)
+		}()
+	}
+
+	listenerList, err := openstackutil.GetListenersByLoadBalancerID(lbaas.lb, lb.ID)
+	if err != nil {
+		return err
+	}
+	for _, listener := range listenerList {
+		if !refs.isOrphaned(listener.Tags...) {
+			continue
+		}
+		if lbaas.opts.GCDryRun {
+			klog.InfoS("Found orphaned listener, not deleted in dry-run mode", "listenerID", listener.ID, "lbID", lb.ID, "tags", listener.Tags)
+			continue
+		}
+		// The orphaned listener has a tag of the cluster, which
+		// deleteOctaviaListeners matches
+		for _, tag := range listener.Tags {
+			if strings.HasPrefix(tag, refs.prefix) {
+				if err := lbaas.deleteOctaviaListeners(lb.ID, []listeners.Listener{listener}, false, tag); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	// The pools are created with their listener, a pool of the cluster
+	// without listener was left behind by a failed deletion
+	lbPools, err := openstackutil.GetPools(lbaas.lb, lb.ID)
+	if err != nil {
+		return err
+	}
+	for _, pool := range lbPools {
+		if len(pool.Listeners) > 0 || !strings.HasPrefix(pool.Name, "pool_") || !strings.Contains(pool.Name, "_"+refs.prefix) {
+			continue
+		}
+		if lbaas.opts.GCDryRun {
+			klog.InfoS("Found orphaned pool, not deleted in dry-run mode", "poolID", pool.ID, "lbID", lb.ID, "poolName", pool.Name)
+			continue
+		}
+		klog.InfoS("Deleting orphaned pool", "poolID", pool.ID, "lbID", lb.ID, "poolName", pool.Name)
+		// Delete pool automatically deletes all its members and its health monitor.
+		if err := openstackutil.DeletePool(lbaas.lb, pool.ID, lb.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lbaas *LbaasV2) collectFloatingIP(fip *floatingips.FloatingIP) {
+	if lbaas.opts.GCDryRun {
+		klog.InfoS("Found orphaned floating IP, not deleted in dry-run mode", "floatingIP", fip.FloatingIP, "description", fip.Description)
+		return
+	}
+	klog.InfoS("Deleting orphaned floating IP", "floatingIP", fip.FloatingIP, "description", fip.Description)
+	mc := metrics.NewMetricContext("floating_ip", "delete")
+	if err := mc.ObserveRequest(floatingips.Delete(lbaas.network, fip.ID).ExtractErr()); err != nil {
+		klog.Warningf("Failed to delete orphaned floating IP %s: %v", fip.FloatingIP, err)
+	}
+}
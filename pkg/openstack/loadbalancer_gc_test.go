@@ -0,0 +1,192 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLBReferencesIsOrphaned(t *testing.T) {
+	lbaas := &LbaasV2{}
+	services := []corev1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}, Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"}, Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "user", Annotations: map[string]string{ServiceAnnotationLoadBalancerExistingID: "lb-user"}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+	}
+	refs := lbaas.getLBReferences(context.TODO(), "cluster1", services)
+
+	assert.True(t, refs.ids.Has("lb-user"))
+	assert.True(t, refs.descriptions.Has(floatingIPDescription("default/web", "cluster1")))
+	assert.False(t, refs.descriptions.Has(floatingIPDescription("default/api", "cluster1")))
+
+	assert.False(t, refs.isOrphaned("kube_service_cluster1_default_web"))
+	assert.False(t, refs.isOrphaned("kube_service_cluster1_default_api", "kube_service_cluster1_default_web"))
+	assert.True(t, refs.isOrphaned("kube_service_cluster1_default_api"))
+	assert.False(t, refs.isOrphaned("kube_service_other_default_api"))
+	assert.False(t, refs.isOrphaned())
+}
+
+func TestLbaasCollectGarbage(t *testing.T) {
+	tests := []struct {
+		name    string
+		dryRun  bool
+		deleted []string
+	}{
+		{
+			name:   "dry run",
+			dryRun: true,
+		},
+		{
+			name: "orphans deleted",
+			deleted: []string{
+				"/lbaas/loadbalancers/lb-api",
+				"/lbaas/pools/pool-gone",
+				"/lbaas/listeners/listener-gone",
+				"/lbaas/pools/pool-leftover",
+				"/floatingips/fip-gone",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			th.SetupHTTP()
+			defer th.TeardownHTTP()
+
+			var mu sync.Mutex
+			var deleted []string
+			record := func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method != http.MethodDelete {
+					return false
+				}
+				mu.Lock()
+				deleted = append(deleted, r.URL.Path)
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+				return true
+			}
+
+			th.Mux.HandleFunc("/lbaas/loadbalancers", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"loadbalancers": [
+					{"id": "lb-web", "name": "kube_service_cluster1_default_web", "provisioning_status": "ACTIVE"},
+					{"id": "lb-api", "name": "kube_service_cluster1_default_api", "description": "Kubernetes external service default/api from cluster cluster1", "provisioning_status": "ACTIVE"},
+					{"id": "lb-prefixed", "name": "kube_service_cluster1_eu_default_api", "description": "Kubernetes external service default/api from cluster cluster1_eu", "provisioning_status": "ACTIVE"},
+					{"id": "lb-gone", "name": "kube_service_cluster1_default_gone", "provisioning_status": "PENDING_UPDATE"},
+					{"id": "lb-other", "name": "kube_service_other_default_api", "provisioning_status": "ACTIVE"},
+					{"id": "lb-shared", "name": "shared", "provisioning_status": "ACTIVE",
+					 "tags": ["kube_service_cluster1_default_web", "kube_service_cluster1_default_gone"]}
+				]}`)
+			})
+			th.Mux.HandleFunc("/lbaas/loadbalancers/lb-api", func(w http.ResponseWriter, r *http.Request) {
+				if record(w, r) {
+					assert.Equal(t, "true", r.URL.Query().Get("cascade"))
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+			})
+			th.Mux.HandleFunc("/lbaas/loadbalancers/lb-shared", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"loadbalancer": {"id": "lb-shared", "provisioning_status": "ACTIVE"}}`)
+			})
+			th.Mux.HandleFunc("/lbaas/listeners", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "lb-shared", r.URL.Query().Get("loadbalancer_id"))
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"listeners": [
+					{"id": "listener-web", "tags": ["kube_service_cluster1_default_web"]},
+					{"id": "listener-gone", "tags": ["kube_service_cluster1_default_gone"]},
+					{"id": "listener-user"}
+				]}`)
+			})
+			th.Mux.HandleFunc("/lbaas/listeners/listener-gone", func(w http.ResponseWriter, r *http.Request) {
+				record(w, r)
+			})
+			th.Mux.HandleFunc("/lbaas/pools", func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "lb-shared", r.URL.Query().Get("loadbalancer_id"))
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"pools": [
+					{"id": "pool-web", "name": "pool_0_kube_service_cluster1_default_web", "listeners": [{"id": "listener-web"}]},
+					{"id": "pool-gone", "name": "pool_0_kube_service_cluster1_default_gone", "listeners": [{"id": "listener-gone"}]},
+					{"id": "pool-leftover", "name": "pool_1_kube_service_cluster1_default_gone", "listeners": []},
+					{"id": "pool-user", "name": "user", "listeners": []}
+				]}`)
+			})
+			for _, pool := range []string{"pool-gone", "pool-leftover"} {
+				th.Mux.HandleFunc("/lbaas/pools/"+pool, func(w http.ResponseWriter, r *http.Request) {
+					record(w, r)
+				})
+			}
+			th.Mux.HandleFunc("/floatingips", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"floatingips": [
+					{"id": "fip-web", "description": "%s"},
+					{"id": "fip-gone", "description": "%s"},
+					{"id": "fip-kept", "description": "%s", "tags": ["%sdefault_gone"]},
+					{"id": "fip-attached", "description": "%s", "port_id": "port1"},
+					{"id": "fip-other", "description": "%s"}
+				]}`,
+					floatingIPDescription("default/web", "cluster1"),
+					floatingIPDescription("default/gone", "cluster1"),
+					floatingIPDescription("default/gone", "cluster1"), keptFloatingIPTagPrefix,
+					floatingIPDescription("default/gone", "cluster1"),
+					floatingIPDescription("default/gone", "other"))
+			})
+			th.Mux.HandleFunc("/floatingips/fip-gone", func(w http.ResponseWriter, r *http.Request) {
+				record(w, r)
+			})
+
+			kclient := fake.NewSimpleClientset(&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			})
+			gc := newLBGarbageCollector()
+			lbaas := &LbaasV2{LoadBalancer{
+				lb:      fakeclient.ServiceClient(),
+				network: fakeclient.ServiceClient(),
+				kclient: kclient,
+				gc:      gc,
+				opts:    LoadBalancerOpts{GCDryRun: test.dryRun},
+			}}
+
+			// Nothing is collected until the cluster name is known, nor
+			// with the default cluster name
+			lbaas.collectGarbage(context.TODO())
+			assert.Empty(t, deleted)
+			gc.setClusterName(defaultClusterName)
+			lbaas.collectGarbage(context.TODO())
+			assert.Empty(t, deleted)
+
+			gc.setClusterName("cluster1")
+			lbaas.collectGarbage(context.TODO())
+			assert.Equal(t, test.deleted, deleted)
+		})
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// l7PolicyRule is a rule of an L7 policy of annotation
+// ServiceAnnotationLoadBalancerL7Policies.
+type l7PolicyRule struct {
+	Type        l7policies.RuleType    `json:"type"`
+	CompareType l7policies.CompareType `json:"compareType"`
+	Key         string                 `json:"key,omitempty"`
+	Value       string                 `json:"value"`
+	Invert      bool                   `json:"invert,omitempty"`
+}
+
+// l7Policy is an L7 policy of annotation ServiceAnnotationLoadBalancerL7Policies,
+// applied to the listener of a port of the Service. A request matching all its
+// rules is redirected to the pool of another port of the Service, redirected
+// to a URL or rejected.
+type l7Policy struct {
+	Port         int32             `json:"port"`
+	Action       l7policies.Action `json:"action"`
+	RedirectPort int32             `json:"redirectPort,omitempty"`
+	RedirectURL  string            `json:"redirectURL,omitempty"`
+	Rules        []l7PolicyRule    `json:"rules"`
+}
+
+// l7PolicyDescription is the description of the L7 policies created for the
+// Service, which marks the policies the controller owns.
+func l7PolicyDescription(service *corev1.Service) string {
+	return fmt.Sprintf("L7 policy of Kubernetes external service %s/%s", service.Namespace, service.Name)
+}
+
+// setL7Policies sets the L7 policies of annotation
+// ServiceAnnotationLoadBalancerL7Policies. The policies require HTTP
+// listeners, so setClientIPPreservation must be called before.
+func (lbaas *LbaasV2) setL7Policies(service *corev1.Service, svcConf *serviceConfig) error {
+	svcConf.l7Policies = nil
+	value := strings.TrimSpace(getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerL7Policies, ""))
+	if value == "" {
+		return nil
+	}
+	if svcConf.lbProvider == "ovn" {
+		return fmt.Errorf("annotation %s is not supported by the ovn provider", ServiceAnnotationLoadBalancerL7Policies)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	var policies []l7Policy
+	if err := decoder.Decode(&policies); err != nil {
+		return fmt.Errorf("failed to parse annotation %s: %v", ServiceAnnotationLoadBalancerL7Policies, err)
+	}
+
+	ports := make(map[int32]corev1.ServicePort)
+	for _, port := range service.Spec.Ports {
+		// The TCP port is kept when a UDP port has the same number
+		if _, ok := ports[port.Port]; !ok || !isLayer4Protocol(string(port.Protocol)) {
+			ports[port.Port] = port
+		}
+	}
+	for i, policy := range policies {
+		if err := checkL7Policy(policy, ports, svcConf); err != nil {
+			return fmt.Errorf("invalid policy %d of annotation %s: %v", i, ServiceAnnotationLoadBalancerL7Policies, err)
+		}
+	}
+	svcConf.l7Policies = policies
+	return nil
+}
+
+func checkL7Policy(policy l7Policy, ports map[int32]corev1.ServicePort, svcConf *serviceConfig) error {
+	port, ok := ports[policy.Port]
+	if !ok {
+		return fmt.Errorf("port %d is not a port of the Service", policy.Port)
+	}
+	if proto := getListenerProtocol(port.Protocol, svcConf); proto != listeners.ProtocolHTTP && proto != listeners.ProtocolTerminatedHTTPS {
+		return fmt.Errorf("the listener of port %d uses protocol %s, L7 policies require HTTP or TERMINATED_HTTPS, enabled by annotation %s or %s", policy.Port, proto, ServiceAnnotationLoadBalancerXForwardedFor, ServiceAnnotationTlsContainerRef)
+	}
+
+	switch policy.Action {
+	case l7policies.ActionRedirectToPool:
+		if _, ok := ports[policy.RedirectPort]; !ok {
+			return fmt.Errorf("redirectPort %d is not a port of the Service", policy.RedirectPort)
+		}
+	case l7policies.ActionRedirectToURL:
+		if policy.RedirectURL == "" {
+			return fmt.Errorf("action %s requires redirectURL", policy.Action)
+		}
+	case l7policies.ActionReject:
+	default:
+		return fmt.Errorf("unsupported action %q", policy.Action)
+	}
+	if policy.Action != l7policies.ActionRedirectToPool && policy.RedirectPort != 0 {
+		return fmt.Errorf("redirectPort requires action %s", l7policies.ActionRedirectToPool)
+	}
+	if policy.Action != l7policies.ActionRedirectToURL && policy.RedirectURL != "" {
+		return fmt.Errorf("redirectURL requires action %s", l7policies.ActionRedirectToURL)
+	}
+
+	if len(policy.Rules) == 0 {
+		return errors.New("no rules")
+	}
+	for _, rule := range policy.Rules {
+		switch rule.Type {
+		case l7policies.TypeCookie, l7policies.TypeHeader:
+			if rule.Key == "" {
+				return fmt.Errorf("rule type %s requires a key", rule.Type)
+			}
+		case l7policies.TypeFileType, l7policies.TypeHostName, l7policies.TypePath:
+		default:
+			return fmt.Errorf("unsupported rule type %q", rule.Type)
+		}
+		switch rule.CompareType {
+		case l7policies.CompareTypeContains, l7policies.CompareTypeEndWith, l7policies.CompareTypeEqual, l7policies.CompareTypeRegex, l7policies.CompareTypeStartWith:
+		default:
+			return fmt.Errorf("unsupported rule compareType %q", rule.CompareType)
+		}
+		if rule.Value == "" {
+			return fmt.Errorf("rule of type %s without a value", rule.Type)
+		}
+	}
+	return nil
+}
+
+// l7PolicyKey identifies the behavior of an L7 policy, regardless of its
+// position and of the order of its rules.
+func l7PolicyKey(action, redirectPoolID, redirectURL string, rules []l7policies.Rule) string {
+	var ruleKeys []string
+	for _, r := range rules {
+		ruleKeys = append(ruleKeys, fmt.Sprintf("%s|%s|%s|%s|%t", r.RuleType, r.CompareType, r.Key, r.Value, r.Invert))
+	}
+	sort.Strings(ruleKeys)
+	return fmt.Sprintf("%s|%s|%s|%s", action, redirectPoolID, redirectURL, strings.Join(ruleKeys, ","))
+}
+
+// ensureL7Policies makes sure the listeners of the Service have the L7
+// policies of annotation ServiceAnnotationLoadBalancerL7Policies, in the order
+// of the annotation and ahead of the policies not created for the Service.
+// The policies of a listener are recreated when they change. svcListeners and
+// poolIDs are the listeners and pools of the Service by port.
+func (lbaas *LbaasV2) ensureL7Policies(lbID string, service *corev1.Service, svcListeners map[int32]*listeners.Listener, poolIDs map[int32]string, svcConf *serviceConfig) error {
+	description := l7PolicyDescription(service)
+
+	for portIndex, port := range service.Spec.Ports {
+		listener, ok := svcListeners[port.Port]
+		if !ok {
+			continue
+		}
+
+		var desired []l7policies.CreateOpts
+		var desiredKeys []string
+		for _, policy := range svcConf.l7Policies {
+			if policy.Port != port.Port {
+				continue
+			}
+			opts := l7policies.CreateOpts{
+				Name:        cutString(fmt.Sprintf("l7policy_%d_%d_%s", portIndex, len(desired), svcConf.lbName)),
+				ListenerID:  listener.ID,
+				Action:      policy.Action,
+				Position:    int32(len(desired) + 1),
+				Description: description,
+				RedirectURL: policy.RedirectURL,
+			}
+			if policy.Action == l7policies.ActionRedirectToPool {
+				opts.RedirectPoolID = poolIDs[policy.RedirectPort]
+				if opts.RedirectPoolID == "" {
+					return fmt.Errorf("no pool found for port %d to redirect to", policy.RedirectPort)
+				}
+			}
+			var rules []l7policies.Rule
+			for _, r := range policy.Rules {
+				opts.Rules = append(opts.Rules, l7policies.CreateRuleOpts{RuleType: r.Type, CompareType: r.CompareType, Key: r.Key, Value: r.Value, Invert: r.Invert})
+				rules = append(rules, l7policies.Rule{RuleType: string(r.Type), CompareType: string(r.CompareType), Key: r.Key, Value: r.Value, Invert: r.Invert})
+			}
+			desired = append(desired, opts)
+			desiredKeys = append(desiredKeys, l7PolicyKey(string(opts.Action), opts.RedirectPoolID, opts.RedirectURL, rules))
+		}
+		// Avoid listing the policies of the listeners without any
+		if len(desired) == 0 && len(listener.L7Policies) == 0 {
+			continue
+		}
+
+		policies, err := openstackutil.GetL7policies(lbaas.lb, listener.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get L7 policies of listener %s: %v", listener.ID, err)
+		}
+		var current []l7policies.L7Policy
+		for _, policy := range policies {
+			if policy.Description == description {
+				current = append(current, policy)
+			}
+		}
+		sort.Slice(current, func(i, j int) bool { return current[i].Position < current[j].Position })
+
+		var currentKeys []string
+		for _, policy := range current {
+			rules, err := openstackutil.GetL7Rules(lbaas.lb, policy.ID)
+			if err != nil {
+				return fmt.Errorf("failed to get rules of L7 policy %s: %v", policy.ID, err)
+			}
+			currentKeys = append(currentKeys, l7PolicyKey(policy.Action, policy.RedirectPoolID, policy.RedirectURL, rules))
+		}
+		if strings.Join(currentKeys, "\n") == strings.Join(desiredKeys, "\n") {
+			continue
+		}
+
+		for _, policy := range current {
+			klog.InfoS("Deleting L7 policy", "policyID", policy.ID, "listenerID", listener.ID, "lbID", lbID)
+			if err := openstackutil.DeleteL7policy(lbaas.lb, policy.ID, lbID); err != nil {
+				return fmt.Errorf("failed to delete L7 policy %s: %v", policy.ID, err)
+			}
+		}
+		for _, opts := range desired {
+			rules := opts.Rules
+			opts.Rules = nil
+			klog.InfoS("Creating L7 policy", "name", opts.Name, "action", opts.Action, "listenerID", listener.ID, "lbID", lbID)
+			policy, err := openstackutil.CreateL7Policy(lbaas.lb, opts, lbID)
+			if err != nil {
+				return fmt.Errorf("failed to create L7 policy %s: %v", opts.Name, err)
+			}
+			for _, rule := range rules {
+				if err := openstackutil.CreateL7Rule(lbaas.lb, policy.ID, rule, lbID); err != nil {
+					return fmt.Errorf("failed to create rule of L7 policy %s: %v", policy.ID, err)
+				}
+			}
+		}
+	}
+	return nil
+}
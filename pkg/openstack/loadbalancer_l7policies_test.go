@@ -0,0 +1,207 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/l7policies"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newL7PoliciesService(policies string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerL7Policies: policies,
+		}},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "web", Port: 80, Protocol: corev1.ProtocolTCP},
+			{Name: "api", Port: 8080, Protocol: corev1.ProtocolTCP},
+		}},
+	}
+}
+
+func TestSetL7Policies(t *testing.T) {
+	tests := []struct {
+		name        string
+		policies    string
+		svcConf     serviceConfig
+		expected    []l7Policy
+		expectedErr bool
+	}{
+		{
+			name:     "redirect to pool",
+			policies: `[{"port": 80, "action": "REDIRECT_TO_POOL", "redirectPort": 8080, "rules": [{"type": "PATH", "compareType": "STARTS_WITH", "value": "/api"}]}]`,
+			svcConf:  serviceConfig{keepClientIP: true},
+			expected: []l7Policy{{Port: 80, Action: l7policies.ActionRedirectToPool, RedirectPort: 8080, Rules: []l7PolicyRule{{Type: l7policies.TypePath, CompareType: l7policies.CompareTypeStartWith, Value: "/api"}}}},
+		},
+		{
+			name:        "TCP listener",
+			policies:    `[{"port": 80, "action": "REJECT", "rules": [{"type": "PATH", "compareType": "STARTS_WITH", "value": "/admin"}]}]`,
+			expectedErr: true,
+		},
+		{
+			name:        "unknown redirect port",
+			policies:    `[{"port": 80, "action": "REDIRECT_TO_POOL", "redirectPort": 9090, "rules": [{"type": "PATH", "compareType": "STARTS_WITH", "value": "/api"}]}]`,
+			svcConf:     serviceConfig{keepClientIP: true},
+			expectedErr: true,
+		},
+		{
+			name:        "header rule without key",
+			policies:    `[{"port": 80, "action": "REJECT", "rules": [{"type": "HEADER", "compareType": "EQUAL_TO", "value": "x"}]}]`,
+			svcConf:     serviceConfig{keepClientIP: true},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown field",
+			policies:    `[{"port": 80, "action": "REJECT", "url": "https://example.com", "rules": [{"type": "PATH", "compareType": "STARTS_WITH", "value": "/admin"}]}]`,
+			svcConf:     serviceConfig{keepClientIP: true},
+			expectedErr: true,
+		},
+		{
+			name:        "ovn provider",
+			policies:    `[{"port": 80, "action": "REJECT", "rules": [{"type": "PATH", "compareType": "STARTS_WITH", "value": "/admin"}]}]`,
+			svcConf:     serviceConfig{keepClientIP: true, lbProvider: "ovn"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{}}
+			svcConf := test.svcConf
+			err := lbaas.setL7Policies(newL7PoliciesService(test.policies), &svcConf)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, svcConf.l7Policies)
+		})
+	}
+}
+
+func TestEnsureL7Policies(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	service := newL7PoliciesService("")
+	description := l7PolicyDescription(service)
+
+	th.Mux.HandleFunc("/lbaas/l7policies", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "listener80", r.URL.Query().Get("listener_id"))
+			// policy1 is up to date, policy2 redirects to a URL removed from the annotation,
+			// policy3 was not created for the Service
+			fmt.Fprintf(w, `{"l7policies": [
+				{"id": "policy2", "action": "REDIRECT_TO_URL", "redirect_url": "https://example.com", "position": 2, "description": "%[1]s"},
+				{"id": "policy1", "action": "REDIRECT_TO_POOL", "redirect_pool_id": "pool8080", "position": 1, "description": "%[1]s"},
+				{"id": "policy3", "action": "REJECT", "position": 3}
+			]}`, description)
+		case http.MethodPost:
+			th.TestJSONRequest(t, r, fmt.Sprintf(`{"l7policy": {"name": "l7policy_0_0_lb", "listener_id": "listener80", "action": "REDIRECT_TO_POOL", "position": 1, "description": "%s", "redirect_pool_id": "pool8080"}}`, description))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"l7policy": {"id": "policy4"}}`)
+		}
+	})
+	th.Mux.HandleFunc("/lbaas/l7policies/policy1/rules", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"rules": [{"id": "rule1", "type": "PATH", "compare_type": "STARTS_WITH", "value": "/api"}]}`)
+	})
+	th.Mux.HandleFunc("/lbaas/l7policies/policy2/rules", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"rules": [{"id": "rule2", "type": "PATH", "compare_type": "STARTS_WITH", "value": "/old"}]}`)
+	})
+	var deleted, createdRules []string
+	for _, id := range []string{"policy1", "policy2", "policy3"} {
+		id := id
+		th.Mux.HandleFunc("/lbaas/l7policies/"+id, func(w http.ResponseWriter, r *http.Request) {
+			th.TestMethod(t, r, http.MethodDelete)
+			deleted = append(deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	th.Mux.HandleFunc("/lbaas/l7policies/policy4/rules", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodPost)
+		th.TestJSONRequest(t, r, `{"rule": {"type": "PATH", "compare_type": "STARTS_WITH", "value": "/api"}}`)
+		createdRules = append(createdRules, "policy4")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"rule": {"id": "rule4"}}`)
+	})
+	th.Mux.HandleFunc("/lbaas/loadbalancers/lb1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"loadbalancer": {"id": "lb1", "provisioning_status": "ACTIVE"}}`)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	svcListeners := map[int32]*listeners.Listener{
+		80:   {ID: "listener80", L7Policies: []l7policies.L7Policy{{ID: "policy1"}, {ID: "policy2"}, {ID: "policy3"}}},
+		8080: {ID: "listener8080"},
+	}
+	poolIDs := map[int32]string{80: "pool80", 8080: "pool8080"}
+	redirect := l7Policy{Port: 80, Action: l7policies.ActionRedirectToPool, RedirectPort: 8080, Rules: []l7PolicyRule{{Type: l7policies.TypePath, CompareType: l7policies.CompareTypeStartWith, Value: "/api"}}}
+	svcConf := &serviceConfig{lbName: "lb", l7Policies: []l7Policy{redirect}}
+
+	// The policies of the Service are recreated, the other policy is kept
+	err := lbaas.ensureL7Policies("lb1", service, svcListeners, poolIDs, svcConf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"policy1", "policy2"}, deleted)
+	assert.Equal(t, []string{"policy4"}, createdRules)
+}
+
+func TestEnsureL7PoliciesUpToDate(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	service := newL7PoliciesService("")
+	description := l7PolicyDescription(service)
+
+	th.Mux.HandleFunc("/lbaas/l7policies", func(w http.ResponseWriter, r *http.Request) {
+		th.TestMethod(t, r, http.MethodGet)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"l7policies": [{"id": "policy1", "action": "REJECT", "position": 1, "description": "%s"}]}`, description)
+	})
+	th.Mux.HandleFunc("/lbaas/l7policies/policy1/rules", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"rules": [{"id": "rule2", "type": "HOST_NAME", "compare_type": "EQUAL_TO", "value": "admin.example.com"}, {"id": "rule1", "type": "PATH", "compare_type": "STARTS_WITH", "value": "/admin"}]}`)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	svcListeners := map[int32]*listeners.Listener{
+		80: {ID: "listener80", L7Policies: []l7policies.L7Policy{{ID: "policy1"}}},
+		// Without any policy, the policies of the listener are not listed
+		8080: {ID: "listener8080"},
+	}
+	reject := l7Policy{Port: 80, Action: l7policies.ActionReject, Rules: []l7PolicyRule{
+		{Type: l7policies.TypePath, CompareType: l7policies.CompareTypeStartWith, Value: "/admin"},
+		{Type: l7policies.TypeHostName, CompareType: l7policies.CompareTypeEqual, Value: "admin.example.com"},
+	}}
+	svcConf := &serviceConfig{lbName: "lb", l7Policies: []l7Policy{reject}}
+
+	err := lbaas.ensureL7Policies("lb1", service, svcListeners, nil, svcConf)
+	assert.NoError(t, err)
+}
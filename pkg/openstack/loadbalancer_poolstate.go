@@ -0,0 +1,132 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v2monitors "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/monitors"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// poolStateWorkers is the number of concurrent requests fetching the members
+// and health monitors of the pools of a load balancer
+const poolStateWorkers = 8
+
+// lbPoolState is the pools of a load balancer with their members and health
+// monitors, fetched once before the ports of a Service are reconciled instead
+// of once per port. Reading them does not require the load balancer to be
+// ACTIVE, so the members and health monitors are fetched in parallel.
+type lbPoolState struct {
+	// pools are the pools by listener ID
+	pools map[string]*v2pools.Pool
+	// members are the members by pool ID, missing if they could not be fetched
+	members map[string][]v2pools.Member
+	// monitors are the health monitors by ID
+	monitors map[string]*v2monitors.Monitor
+}
+
+// getLBPoolState fetches the pools of the load balancer, and the members of
+// the pools if withMembers is set.
+func (lbaas *LbaasV2) getLBPoolState(ctx context.Context, lbID string, withMembers bool) (*lbPoolState, error) {
+	lbPools, err := openstackutil.GetPools(lbaas.lb, lbID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the pools of load balancer %s: %v", lbID, err)
+	}
+
+	state := &lbPoolState{
+		pools:    make(map[string]*v2pools.Pool),
+		members:  make(map[string][]v2pools.Member),
+		monitors: make(map[string]*v2monitors.Monitor),
+	}
+	for i, p := range lbPools {
+		for _, l := range p.Listeners {
+			if _, ok := state.pools[l.ID]; ok {
+				return nil, fmt.Errorf("error getting pool for listener %s: %v", l.ID, openstackutil.ErrMultipleResults)
+			}
+			state.pools[l.ID] = &lbPools[i]
+		}
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	workqueue.ParallelizeUntil(ctx, poolStateWorkers, len(lbPools), func(i int) {
+		pool := lbPools[i]
+		if withMembers {
+			members, err := openstackutil.GetMembersbyPool(lbaas.lb, pool.ID)
+			mu.Lock()
+			if err != nil {
+				// The members are fetched again by ensureOctaviaPool
+				klog.Errorf("failed to get members in the pool %s: %v", pool.ID, err)
+			} else {
+				state.members[pool.ID] = members
+			}
+			mu.Unlock()
+		}
+		if pool.MonitorID != "" {
+			monitor, err := openstackutil.GetHealthMonitor(lbaas.lb, pool.MonitorID)
+			mu.Lock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				state.monitors[monitor.ID] = monitor
+			}
+			mu.Unlock()
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return state, nil
+}
+
+// getPool returns the pool of the listener, fetched if state is nil.
+func (state *lbPoolState) getPool(lbaas *LbaasV2, lbID, listenerID string) (*v2pools.Pool, error) {
+	if state == nil {
+		return openstackutil.GetPoolByListener(lbaas.lb, lbID, listenerID)
+	}
+	if pool, ok := state.pools[listenerID]; ok {
+		return pool, nil
+	}
+	return nil, openstackutil.ErrNotFound
+}
+
+// getMembers returns the members of the pool, fetched if unknown.
+func (state *lbPoolState) getMembers(lbaas *LbaasV2, poolID string) ([]v2pools.Member, error) {
+	if state != nil {
+		if members, ok := state.members[poolID]; ok {
+			return members, nil
+		}
+	}
+	return openstackutil.GetMembersbyPool(lbaas.lb, poolID)
+}
+
+// getMonitor returns the health monitor, fetched if unknown.
+func (state *lbPoolState) getMonitor(lbaas *LbaasV2, monitorID string) (*v2monitors.Monitor, error) {
+	if state != nil {
+		if monitor, ok := state.monitors[monitorID]; ok {
+			return monitor, nil
+		}
+	}
+	return openstackutil.GetHealthMonitor(lbaas.lb, monitorID)
+}
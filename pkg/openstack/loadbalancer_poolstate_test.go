@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetLBPoolState(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/lbaas/pools", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "lb1", r.URL.Query().Get("loadbalancer_id"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"pools": [
+			{"id": "pool1", "protocol": "TCP", "listeners": [{"id": "listener1"}], "healthmonitor_id": "monitor1"},
+			{"id": "pool2", "protocol": "TCP", "listeners": [{"id": "listener2"}]}
+		]}`)
+	})
+	for _, pool := range []string{"pool1", "pool2"} {
+		pool := pool
+		th.Mux.HandleFunc("/lbaas/pools/"+pool+"/members", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"members": [{"id": "%s-member", "address": "10.0.0.10", "protocol_port": 30080}]}`, pool)
+		})
+	}
+	th.Mux.HandleFunc("/lbaas/healthmonitors/monitor1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"healthmonitor": {"id": "monitor1", "type": "TCP"}}`)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	state, err := lbaas.getLBPoolState(context.TODO(), "lb1", true)
+	require.NoError(t, err)
+
+	pool, err := state.getPool(lbaas, "lb1", "listener2")
+	assert.NoError(t, err)
+	assert.Equal(t, "pool2", pool.ID)
+	_, err = state.getPool(lbaas, "lb1", "listener3")
+	assert.Error(t, err)
+
+	members, err := state.getMembers(lbaas, "pool1")
+	assert.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "pool1-member", members[0].ID)
+
+	monitor, err := state.getMonitor(lbaas, "monitor1")
+	assert.NoError(t, err)
+	assert.Equal(t, "TCP", monitor.Type)
+}
+
+func TestEnsureOctaviaPoolFromState(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	// The pool and its members are up to date, nothing is requested
+	th.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s request to %s", r.Method, r.URL.Path)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	listener := &listeners.Listener{ID: "listener1", Protocol: "TCP"}
+	nodes := []*corev1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.10"}}},
+	}}
+	state := &lbPoolState{
+		pools:   map[string]*v2pools.Pool{"listener1": {ID: "pool1", Protocol: "TCP"}},
+		members: map[string][]v2pools.Member{"pool1": {{ID: "member1", Address: "10.0.0.10", ProtocolPort: 30080}}},
+	}
+	svcConf := &serviceConfig{manageMembers: true}
+
+	pool, err := lbaas.ensureOctaviaPool("lb1", "pool", listener, state, &corev1.Service{}, corev1.ServicePort{NodePort: 30080}, nodes, svcConf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pool1", pool.ID)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	// ServiceAnnotationLoadBalancerSharingGroup shares the load balancers
+	// between the Services of the same group, without setting the ID of the
+	// load balancer to share.
+	ServiceAnnotationLoadBalancerSharingGroup = "loadbalancer.openstack.org/load-balancer-sharing-group"
+
+	// sharingGroupPrefix is the prefix of the tag of the load balancers of a
+	// sharing group. It must not start with servicePrefix, which is the
+	// prefix of the tags of the Services sharing a load balancer.
+	sharingGroupPrefix = "kube_lb_group_"
+)
+
+// sharingGroupTag returns the tag of the load balancers of the sharing group.
+func sharingGroupTag(clusterName, group string) string {
+	return cutString(sharingGroupPrefix + clusterName + "_" + group)
+}
+
+// findSharingGroupLoadBalancer returns the ID of a load balancer of the
+// sharing group of the Service the Service can be added to: one which is
+// ACTIVE, on the VIP network and subnet of the Service if set, shared by less
+// than max-shared-lb Services and without listener on the ports of the
+// Service. It returns an empty ID if the Service already has a load balancer
+// or none of the group can take it, so that a load balancer is created.
+func (lbaas *LbaasV2) findSharingGroupLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, svcConf *serviceConfig) (string, error) {
+	lbName := lbaas.GetLoadBalancerName(ctx, clusterName, service)
+	legacyName := lbaas.getLoadBalancerLegacyName(ctx, clusterName, service)
+	if _, err := getLoadbalancerByName(lbaas.lb, lbName, legacyName); err != cpoerrors.ErrNotFound {
+		// The load balancer of the Service, e.g. created before the
+		// sharing group was set, or an error
+		return "", err
+	}
+
+	lbs, err := openstackutil.GetLoadBalancers(lbaas.lb, loadbalancers.ListOpts{Tags: []string{sharingGroupTag(clusterName, svcConf.sharingGroup)}})
+	if err != nil {
+		return "", err
+	}
+
+	for _, lb := range lbs {
+		if lb.ProvisioningStatus != activeStatus {
+			continue
+		}
+		if (svcConf.lbSubnetID != "" && lb.VipSubnetID != svcConf.lbSubnetID) || (svcConf.lbNetworkID != "" && lb.VipNetworkID != svcConf.lbNetworkID) {
+			continue
+		}
+
+		sharedCount := 0
+		for _, tag := range lb.Tags {
+			if strings.HasPrefix(tag, servicePrefix) {
+				sharedCount++
+			}
+		}
+		if sharedCount+1 > lbaas.opts.MaxSharedLB {
+			continue
+		}
+
+		lbListeners, err := openstackutil.GetListenersByLoadBalancerID(lbaas.lb, lb.ID)
+		if err != nil {
+			return "", err
+		}
+		if !listenersHavePorts(lbListeners, service.Spec.Ports) {
+			klog.V(3).InfoS("Sharing load balancer of the sharing group", "lbID", lb.ID, "group", svcConf.sharingGroup, "service", klog.KObj(service))
+			return lb.ID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// listenersHavePorts reports whether a listener uses one of the ports, the
+// listeners over TCP, e.g. HTTP or HTTPS, conflicting with each other.
+func listenersHavePorts(lbListeners []listeners.Listener, ports []corev1.ServicePort) bool {
+	for _, port := range ports {
+		transport := listenerTransport(string(port.Protocol))
+		for _, listener := range lbListeners {
+			if listener.ProtocolPort == int(port.Port) && listenerTransport(listener.Protocol) == transport {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listenerTransport returns the transport protocol of the listener protocol,
+// UDP, SCTP or TCP for the others.
+func listenerTransport(protocol string) string {
+	switch protocol {
+	case string(listeners.ProtocolUDP), string(corev1.ProtocolSCTP):
+		return protocol
+	default:
+		return string(listeners.ProtocolTCP)
+	}
+}
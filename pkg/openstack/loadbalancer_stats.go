@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// syncStats fetches the statistics of the load balancers of the Services, of
+// their listeners and pools, and exposes them as metrics.
+func (lbaas *LbaasV2) syncStats(ctx context.Context) {
+	services, err := lbaas.kclient.CoreV1().Services(corev1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list Services to collect the load balancer statistics: %v", err)
+		return
+	}
+
+	// The metrics are replaced as a whole so the ones of deleted Services
+	// and load balancers disappear.
+	var lbMetrics []metrics.LoadBalancerStats
+	var listenerMetrics []metrics.ListenerStats
+	// The statistics of a shared load balancer are only fetched and
+	// reported once
+	reported := make(map[string]bool)
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		lbID := getStringFromServiceAnnotation(svc, ServiceAnnotationLoadBalancerID, "")
+		if lbID == "" {
+			continue
+		}
+
+		stats, err := lbaas.getServiceListenerStats(svc, lbID)
+		if err != nil {
+			klog.Warningf("Failed to get the statistics of load balancer %s of Service %s/%s: %v", lbID, svc.Namespace, svc.Name, err)
+			continue
+		}
+		if !reported[lbID] {
+			lb, err := openstackutil.GetLoadBalancerStats(lbaas.lb, lbID)
+			if err != nil {
+				klog.Warningf("Failed to get the statistics of load balancer %s of Service %s/%s: %v", lbID, svc.Namespace, svc.Name, err)
+				continue
+			}
+			reported[lbID] = true
+			lbMetrics = append(lbMetrics, metrics.LoadBalancerStats{
+				LoadBalancer: lbID,
+				Traffic: metrics.LoadBalancerTraffic{
+					BytesIn:           lb.BytesIn,
+					BytesOut:          lb.BytesOut,
+					ActiveConnections: lb.ActiveConnections,
+					TotalConnections:  lb.TotalConnections,
+					RequestErrors:     lb.RequestErrors,
+				},
+			})
+		}
+		listenerMetrics = append(listenerMetrics, stats...)
+	}
+
+	metrics.SetLoadBalancerStats(lbMetrics, listenerMetrics)
+}
+
+// getServiceListenerStats returns the statistics of the listeners of the
+// Service on the load balancer. The listeners of a shared load balancer are
+// told apart by their port, which is unique on the load balancer.
+func (lbaas *LbaasV2) getServiceListenerStats(service *corev1.Service, lbID string) ([]metrics.ListenerStats, error) {
+	lbListeners, err := openstackutil.GetListenersByLoadBalancerID(lbaas.lb, lbID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []metrics.ListenerStats
+	for _, listener := range lbListeners {
+		if !serviceHasPort(service, listener.ProtocolPort) {
+			continue
+		}
+
+		stats, err := openstackutil.GetListenerStats(lbaas.lb, listener.ID)
+		if err != nil {
+			return nil, err
+		}
+		s := metrics.ListenerStats{
+			Namespace:    service.Namespace,
+			Service:      service.Name,
+			LoadBalancer: lbID,
+			Listener:     listener.ID,
+			Traffic: metrics.LoadBalancerTraffic{
+				BytesIn:           stats.BytesIn,
+				BytesOut:          stats.BytesOut,
+				ActiveConnections: stats.ActiveConnections,
+				TotalConnections:  stats.TotalConnections,
+				RequestErrors:     stats.RequestErrors,
+			},
+		}
+
+		pool, err := openstackutil.GetPoolByListener(lbaas.lb, lbID, listener.ID)
+		if err != nil && err != openstackutil.ErrNotFound {
+			return nil, err
+		}
+		if pool != nil {
+			members, err := openstackutil.GetMembersbyPool(lbaas.lb, pool.ID)
+			if err != nil {
+				return nil, err
+			}
+			s.Pool = pool.ID
+			s.Members = make(map[string]int)
+			for _, m := range members {
+				s.Members[m.OperatingStatus]++
+			}
+		}
+
+		result = append(result, s)
+	}
+
+	return result, nil
+}
+
+func serviceHasPort(service *corev1.Service, port int) bool {
+	for _, p := range service.Spec.Ports {
+		if int(p.Port) == port {
+			return true
+		}
+	}
+	return false
+}
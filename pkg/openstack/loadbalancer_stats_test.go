@@ -0,0 +1,192 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+)
+
+// setupStatsHandlers serves the load balancer lb1, shared by two Services,
+// with a listener on port 80 and a pool of two members, a listener on port
+// 443 without pool, and a listener on port 8080. It returns the number of
+// requests for the statistics of the load balancer.
+func setupStatsHandlers(t *testing.T) *int {
+	th.Mux.HandleFunc("/lbaas/listeners", func(w http.ResponseWriter, r *http.Request) {
+		th.TestFormValues(t, r, map[string]string{"loadbalancer_id": "lb1"})
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"listeners": [
+			{"id": "listener-http", "protocol_port": 80},
+			{"id": "listener-https", "protocol_port": 443},
+			{"id": "listener-other", "protocol_port": 8080}
+		]}`)
+	})
+	for i, id := range []string{"listener-http", "listener-https", "listener-other"} {
+		i := i
+		th.Mux.HandleFunc("/lbaas/listeners/"+id+"/stats", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"stats": {"bytes_in": %d, "bytes_out": %d, "active_connections": %d, "total_connections": %d, "request_errors": %d}}`,
+				100*(i+1), 200*(i+1), i+1, 10*(i+1), i)
+		})
+	}
+	th.Mux.HandleFunc("/lbaas/pools", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"pools": [
+			{"id": "pool-http", "listeners": [{"id": "listener-http"}]},
+			{"id": "pool-other", "listeners": [{"id": "listener-other"}]}
+		]}`)
+	})
+	th.Mux.HandleFunc("/lbaas/pools/pool-http/members", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"members": [
+			{"id": "member1", "operating_status": "ONLINE"},
+			{"id": "member2", "operating_status": "ERROR"},
+			{"id": "member3", "operating_status": "ONLINE"}
+		]}`)
+	})
+	th.Mux.HandleFunc("/lbaas/pools/pool-other/members", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"members": [{"id": "member4", "operating_status": "NO_MONITOR"}]}`)
+	})
+	lbRequests := 0
+	th.Mux.HandleFunc("/lbaas/loadbalancers/lb1/stats", func(w http.ResponseWriter, r *http.Request) {
+		lbRequests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"stats": {"bytes_in": 600, "bytes_out": 1200, "active_connections": 6, "total_connections": 60, "request_errors": 3}}`)
+	})
+	return &lbRequests
+}
+
+func newStatsService(name string, annotations map[string]string, serviceType corev1.ServiceType, ports ...int32) *corev1.Service {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations},
+		Spec:       corev1.ServiceSpec{Type: serviceType},
+	}
+	for _, port := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Port: port})
+	}
+	return svc
+}
+
+func TestGetServiceListenerStats(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	setupStatsHandlers(t)
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	svc := newStatsService("web", nil, corev1.ServiceTypeLoadBalancer, 80, 443)
+
+	stats, err := lbaas.getServiceListenerStats(svc, "lb1")
+	assert.NoError(t, err)
+	// The listener of the port of the other Service is skipped
+	assert.Equal(t, []metrics.ListenerStats{
+		{
+			Namespace:    "default",
+			Service:      "web",
+			LoadBalancer: "lb1",
+			Listener:     "listener-http",
+			Traffic:      metrics.LoadBalancerTraffic{BytesIn: 100, BytesOut: 200, ActiveConnections: 1, TotalConnections: 10},
+			Pool:         "pool-http",
+			Members:      map[string]int{"ONLINE": 2, "ERROR": 1},
+		},
+		{
+			Namespace:    "default",
+			Service:      "web",
+			LoadBalancer: "lb1",
+			Listener:     "listener-https",
+			Traffic:      metrics.LoadBalancerTraffic{BytesIn: 200, BytesOut: 400, ActiveConnections: 2, TotalConnections: 20, RequestErrors: 1},
+		},
+	}, stats)
+}
+
+func TestSyncStats(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+	lbRequests := setupStatsHandlers(t)
+
+	lbAnnotations := map[string]string{ServiceAnnotationLoadBalancerID: "lb1"}
+	kclient := fake.NewSimpleClientset(
+		newStatsService("web", lbAnnotations, corev1.ServiceTypeLoadBalancer, 80, 443),
+		newStatsService("other", lbAnnotations, corev1.ServiceTypeLoadBalancer, 8080),
+		// Not provisioned yet
+		newStatsService("pending", nil, corev1.ServiceTypeLoadBalancer, 80),
+		newStatsService("internal", nil, corev1.ServiceTypeClusterIP, 80),
+	)
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient(), kclient: kclient}}
+
+	metrics.RegisterMetrics()
+	lbaas.syncStats(context.TODO())
+	// The statistics of the shared load balancer are fetched and reported
+	// once
+	assert.Equal(t, 1, *lbRequests)
+
+	expected := `
+# HELP openstack_loadbalancer_bytes_in_total [ALPHA] Bytes received by an Octavia load balancer of Services
+# TYPE openstack_loadbalancer_bytes_in_total counter
+openstack_loadbalancer_bytes_in_total{loadbalancer="lb1"} 600
+# HELP openstack_loadbalancer_active_connections [ALPHA] Active connections of an Octavia load balancer of Services
+# TYPE openstack_loadbalancer_active_connections gauge
+openstack_loadbalancer_active_connections{loadbalancer="lb1"} 6
+# HELP openstack_loadbalancer_connections_total [ALPHA] Connections handled by an Octavia load balancer of Services
+# TYPE openstack_loadbalancer_connections_total counter
+openstack_loadbalancer_connections_total{loadbalancer="lb1"} 60
+# HELP openstack_loadbalancer_listener_bytes_in_total [ALPHA] Bytes received by an Octavia listener of a Service
+# TYPE openstack_loadbalancer_listener_bytes_in_total counter
+openstack_loadbalancer_listener_bytes_in_total{listener="listener-http",loadbalancer="lb1",namespace="default",service="web"} 100
+openstack_loadbalancer_listener_bytes_in_total{listener="listener-https",loadbalancer="lb1",namespace="default",service="web"} 200
+openstack_loadbalancer_listener_bytes_in_total{listener="listener-other",loadbalancer="lb1",namespace="default",service="other"} 300
+# HELP openstack_loadbalancer_listener_active_connections [ALPHA] Active connections of an Octavia listener of a Service
+# TYPE openstack_loadbalancer_listener_active_connections gauge
+openstack_loadbalancer_listener_active_connections{listener="listener-http",loadbalancer="lb1",namespace="default",service="web"} 1
+openstack_loadbalancer_listener_active_connections{listener="listener-https",loadbalancer="lb1",namespace="default",service="web"} 2
+openstack_loadbalancer_listener_active_connections{listener="listener-other",loadbalancer="lb1",namespace="default",service="other"} 3
+# HELP openstack_loadbalancer_pool_members [ALPHA] Members of an Octavia pool of a Service by operating status
+# TYPE openstack_loadbalancer_pool_members gauge
+openstack_loadbalancer_pool_members{loadbalancer="lb1",namespace="default",operating_status="ERROR",pool="pool-http",service="web"} 1
+openstack_loadbalancer_pool_members{loadbalancer="lb1",namespace="default",operating_status="NO_MONITOR",pool="pool-other",service="other"} 1
+openstack_loadbalancer_pool_members{loadbalancer="lb1",namespace="default",operating_status="ONLINE",pool="pool-http",service="web"} 2
+`
+	names := []string{
+		"openstack_loadbalancer_bytes_in_total",
+		"openstack_loadbalancer_active_connections",
+		"openstack_loadbalancer_connections_total",
+		"openstack_loadbalancer_listener_bytes_in_total",
+		"openstack_loadbalancer_listener_active_connections",
+		"openstack_loadbalancer_pool_members",
+	}
+	assert.NoError(t, testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(expected), names...))
+
+	// The metrics of the deleted Services disappear
+	assert.NoError(t, kclient.CoreV1().Services("default").Delete(context.TODO(), "other", metav1.DeleteOptions{}))
+	assert.NoError(t, kclient.CoreV1().Services("default").Delete(context.TODO(), "web", metav1.DeleteOptions{}))
+	lbaas.syncStats(context.TODO())
+	assert.NoError(t, testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(""), names...))
+}
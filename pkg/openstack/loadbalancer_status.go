@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	// ServiceConditionLoadBalancerDegraded is true when the load balancer of
+	// the Service is in the ERROR provisioning status, not ONLINE, or missing.
+	ServiceConditionLoadBalancerDegraded = "LoadBalancerDegraded"
+
+	// eventLBRecovered is the reason of the event recorded when the load
+	// balancer of a Service is not degraded anymore
+	eventLBRecovered = "LoadBalancerRecovered"
+
+	operatingStatusOnline    = "ONLINE"
+	operatingStatusNoMonitor = "NO_MONITOR"
+	operatingStatusDegraded  = "DEGRADED"
+	operatingStatusOffline   = "OFFLINE"
+)
+
+// syncStatus publishes the status of the load balancers of the Services as
+// the LoadBalancerDegraded condition of the Services, and records an event
+// when it changes.
+func (lbaas *LbaasV2) syncStatus(ctx context.Context) {
+	services, err := lbaas.kclient.CoreV1().Services(corev1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list Services to sync the status of their load balancers: %v", err)
+		return
+	}
+
+	// A shared load balancer is only fetched once
+	lbs := make(map[string]*loadbalancers.LoadBalancer)
+	now := metav1.Now()
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		lbID := getStringFromServiceAnnotation(svc, ServiceAnnotationLoadBalancerID, "")
+		if lbID == "" {
+			continue
+		}
+
+		lb, ok := lbs[lbID]
+		if !ok {
+			lb, err = openstackutil.GetLoadbalancerByID(lbaas.lb, lbID)
+			if err != nil && !cpoerrors.IsNotFound(err) {
+				klog.Warningf("Failed to get load balancer %s of Service %s/%s: %v", lbID, svc.Namespace, svc.Name, err)
+				continue
+			}
+			lbs[lbID] = lb
+		}
+
+		expected := getLBCondition(lbID, lb)
+		if expected == nil {
+			continue
+		}
+		expected.ObservedGeneration = svc.Generation
+		expected.LastTransitionTime = now
+		current := apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionLoadBalancerDegraded)
+		if current != nil {
+			if current.Status == expected.Status && current.Reason == expected.Reason &&
+				current.Message == expected.Message && current.ObservedGeneration == expected.ObservedGeneration {
+				continue
+			}
+			if current.Status == expected.Status {
+				expected.LastTransitionTime = current.LastTransitionTime
+			}
+		}
+
+		if err := lbaas.patchServiceCondition(ctx, svc, *expected); err != nil {
+			klog.Errorf("Failed to set the %s condition of Service %s/%s: %v", ServiceConditionLoadBalancerDegraded, svc.Namespace, svc.Name, err)
+			continue
+		}
+
+		if current == nil || current.Status != expected.Status {
+			klog.V(2).InfoS("Load balancer condition changed", "service", klog.KObj(svc), "lbID", lbID, "status", expected.Status, "reason", expected.Reason)
+			switch {
+			case expected.Status == metav1.ConditionTrue:
+				lbaas.recordEvent(svc, corev1.EventTypeWarning, ServiceConditionLoadBalancerDegraded, expected.Message)
+			case current != nil:
+				lbaas.recordEvent(svc, corev1.EventTypeNormal, eventLBRecovered, expected.Message)
+			}
+		}
+	}
+}
+
+// getLBCondition returns the LoadBalancerDegraded condition of the Services
+// of the load balancer, nil if lb is nil, or if it is being changed and its
+// status is not known yet.
+func getLBCondition(lbID string, lb *loadbalancers.LoadBalancer) *metav1.Condition {
+	degraded := func(reason, format string, args ...interface{}) *metav1.Condition {
+		return &metav1.Condition{Type: ServiceConditionLoadBalancerDegraded, Status: metav1.ConditionTrue, Reason: reason, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch {
+	case lb == nil:
+		return degraded("LoadBalancerNotFound", "Load balancer %s does not exist", lbID)
+	case lb.ProvisioningStatus == errorStatus:
+		return degraded("ProvisioningError", "Load balancer %s is in the ERROR provisioning status", lbID)
+	case strings.HasPrefix(lb.ProvisioningStatus, "PENDING_"):
+		return nil
+	case lb.OperatingStatus == errorStatus:
+		return degraded("OperatingError", "Load balancer %s is in the ERROR operating status", lbID)
+	case lb.OperatingStatus == operatingStatusDegraded:
+		return degraded("MembersDegraded", "Load balancer %s is DEGRADED, some of its members are in ERROR", lbID)
+	case lb.OperatingStatus == operatingStatusOffline:
+		return degraded("LoadBalancerOffline", "Load balancer %s is OFFLINE, it is administratively disabled", lbID)
+	case lb.OperatingStatus == operatingStatusOnline, lb.OperatingStatus == operatingStatusNoMonitor:
+		return &metav1.Condition{
+			Type:    ServiceConditionLoadBalancerDegraded,
+			Status:  metav1.ConditionFalse,
+			Reason:  "LoadBalancerHealthy",
+			Message: fmt.Sprintf("Load balancer %s is %s and %s", lbID, lb.ProvisioningStatus, lb.OperatingStatus),
+		}
+	}
+	return nil
+}
+
+// patchServiceCondition sets the condition of the Service. The conditions are
+// merged by type, so the conditions set by others are kept.
+func (lbaas *LbaasV2) patchServiceCondition(ctx context.Context, svc *corev1.Service, condition metav1.Condition) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []metav1.Condition{condition},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = lbaas.kclient.CoreV1().Services(svc.Namespace).Patch(ctx, svc.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	return err
+}
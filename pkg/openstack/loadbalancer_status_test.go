@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestGetLBCondition(t *testing.T) {
+	tests := []struct {
+		name   string
+		lb     *loadbalancers.LoadBalancer
+		status metav1.ConditionStatus
+		reason string
+	}{
+		{name: "missing", status: metav1.ConditionTrue, reason: "LoadBalancerNotFound"},
+		{name: "provisioning error", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "ERROR", OperatingStatus: "ONLINE"}, status: metav1.ConditionTrue, reason: "ProvisioningError"},
+		{name: "pending", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "PENDING_UPDATE", OperatingStatus: "ERROR"}},
+		{name: "operating error", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "ACTIVE", OperatingStatus: "ERROR"}, status: metav1.ConditionTrue, reason: "OperatingError"},
+		{name: "degraded", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "ACTIVE", OperatingStatus: "DEGRADED"}, status: metav1.ConditionTrue, reason: "MembersDegraded"},
+		{name: "offline", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "ACTIVE", OperatingStatus: "OFFLINE"}, status: metav1.ConditionTrue, reason: "LoadBalancerOffline"},
+		{name: "online", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "ACTIVE", OperatingStatus: "ONLINE"}, status: metav1.ConditionFalse, reason: "LoadBalancerHealthy"},
+		{name: "no monitor", lb: &loadbalancers.LoadBalancer{ProvisioningStatus: "ACTIVE", OperatingStatus: "NO_MONITOR"}, status: metav1.ConditionFalse, reason: "LoadBalancerHealthy"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			condition := getLBCondition("lb1", test.lb)
+			if test.reason == "" {
+				assert.Nil(t, condition)
+				return
+			}
+			require.NotNil(t, condition)
+			assert.Equal(t, ServiceConditionLoadBalancerDegraded, condition.Type)
+			assert.Equal(t, test.status, condition.Status)
+			assert.Equal(t, test.reason, condition.Reason)
+		})
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	operatingStatus := "ERROR"
+	th.Mux.HandleFunc("/lbaas/loadbalancers/lb1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"loadbalancer": {"id": "lb1", "provisioning_status": "ACTIVE", "operating_status": "%s"}}`, operatingStatus)
+	})
+	th.Mux.HandleFunc("/lbaas/loadbalancers/lb2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	newService := func(name, lbID string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: map[string]string{ServiceAnnotationLoadBalancerID: lbID}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			Status: corev1.ServiceStatus{Conditions: []metav1.Condition{
+				{Type: "Other", Status: metav1.ConditionTrue, Reason: "Other"},
+			}},
+		}
+	}
+	kclient := fake.NewSimpleClientset(newService("web", "lb1"), newService("shared", "lb1"), newService("gone", "lb2"))
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient(), kclient: kclient, eventRecorder: recorder}}
+
+	getCondition := func(name string) *metav1.Condition {
+		svc, err := kclient.CoreV1().Services("default").Get(context.TODO(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		// The conditions of the others are kept
+		assert.NotNil(t, apimeta.FindStatusCondition(svc.Status.Conditions, "Other"))
+		return apimeta.FindStatusCondition(svc.Status.Conditions, ServiceConditionLoadBalancerDegraded)
+	}
+
+	lbaas.syncStatus(context.TODO())
+	for _, name := range []string{"web", "shared"} {
+		condition := getCondition(name)
+		require.NotNil(t, condition)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, "OperatingError", condition.Reason)
+	}
+	condition := getCondition("gone")
+	require.NotNil(t, condition)
+	assert.Equal(t, "LoadBalancerNotFound", condition.Reason)
+	assert.Len(t, recorder.Events, 3)
+	for len(recorder.Events) > 0 {
+		assert.Contains(t, <-recorder.Events, "Warning LoadBalancerDegraded")
+	}
+
+	// Unchanged conditions are not patched again
+	kclient.ClearActions()
+	lbaas.syncStatus(context.TODO())
+	for _, action := range kclient.Actions() {
+		assert.NotEqual(t, "patch", action.GetVerb())
+	}
+	assert.Empty(t, recorder.Events)
+
+	// The recovery is recorded
+	operatingStatus = "ONLINE"
+	lbaas.syncStatus(context.TODO())
+	condition = getCondition("web")
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "Normal LoadBalancerRecovered")
+}
@@ -1,12 +1,27 @@
 package openstack
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/keymutex"
 
 	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	v2pools "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+
+	netsets "k8s.io/cloud-provider-openstack/pkg/util/net/sets"
 )
 
 type testPopListener struct {
@@ -133,3 +148,1171 @@ func TestPopListener(t *testing.T) {
 		assert.Equal(t, ids, item.result, item.name)
 	}
 }
+
+func TestNodeAddressForLB(t *testing.T) {
+	node := &corev1.Node{
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node-1"},
+				{Type: corev1.NodeInternalIP, Address: "fd00::10"},
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.10"},
+				{Type: corev1.NodeExternalIP, Address: "2001:db8::10"},
+			},
+		},
+	}
+
+	addr, err := nodeAddressForLB(node, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::10", addr)
+
+	addr, err = nodeAddressForLB(node, corev1.IPv4Protocol)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.10", addr)
+
+	addr, err = nodeAddressForLB(node, corev1.IPv6Protocol)
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::10", addr)
+
+	ipv6Only := &corev1.Node{
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "fd00::10"},
+			},
+		},
+	}
+	_, err = nodeAddressForLB(ipv6Only, corev1.IPv4Protocol)
+	assert.Error(t, err)
+}
+
+func TestLBErrorTracker(t *testing.T) {
+	tracker := newLBErrorTracker()
+
+	assert.Equal(t, 1, tracker.observeError("lb-1"))
+	assert.Equal(t, 2, tracker.observeError("lb-1"))
+	assert.Equal(t, 1, tracker.observeError("lb-2"))
+
+	tracker.reset("lb-1")
+	assert.Equal(t, 1, tracker.observeError("lb-1"))
+
+	// A nil tracker is a no-op
+	var nilTracker *lbErrorTracker
+	nilTracker.reset("lb-1")
+}
+
+func TestGetListenerTimeouts(t *testing.T) {
+	unset := &serviceConfig{timeoutClientData: -1, timeoutMemberConnect: -1, timeoutMemberData: -1, timeoutTCPInspect: -1}
+	overridden := &serviceConfig{timeoutClientData: 10000, timeoutMemberConnect: -1, timeoutMemberData: -1, timeoutTCPInspect: 0}
+
+	tests := []struct {
+		name     string
+		presets  bool
+		protocol listeners.Protocol
+		svcConf  *serviceConfig
+		expected listenerTimeouts
+	}{
+		{
+			name:     "octavia defaults",
+			protocol: listeners.ProtocolTCP,
+			svcConf:  unset,
+			expected: defaultListenerTimeouts,
+		},
+		{
+			name:     "tcp preset",
+			presets:  true,
+			protocol: listeners.ProtocolTCP,
+			svcConf:  unset,
+			expected: listenerTimeouts{clientData: 3600000, memberConnect: 5000, memberData: 3600000},
+		},
+		{
+			name:     "http preset",
+			presets:  true,
+			protocol: listeners.ProtocolHTTP,
+			svcConf:  unset,
+			expected: listenerTimeouts{clientData: 30000, memberConnect: 5000, memberData: 30000},
+		},
+		{
+			name:     "no preset for udp",
+			presets:  true,
+			protocol: listeners.ProtocolUDP,
+			svcConf:  unset,
+			expected: defaultListenerTimeouts,
+		},
+		{
+			name:     "overridden",
+			presets:  true,
+			protocol: listeners.ProtocolTCP,
+			svcConf:  overridden,
+			expected: listenerTimeouts{clientData: 10000, memberConnect: 5000, memberData: 3600000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{TimeoutPresets: test.presets}}}
+			assert.Equal(t, test.expected, lbaas.getListenerTimeouts(test.protocol, test.svcConf))
+		})
+	}
+}
+
+func TestLockLoadBalancer(t *testing.T) {
+	newService := func(name string, annotations map[string]string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations}}
+	}
+	tests := []struct {
+		name   string
+		first  *corev1.Service
+		second *corev1.Service
+	}{
+		{
+			name:   "shared load balancer",
+			first:  newService("svc-1", map[string]string{ServiceAnnotationLoadBalancerID: "lb-id"}),
+			second: newService("svc-2", map[string]string{ServiceAnnotationLoadBalancerID: "lb-id"}),
+		},
+		{
+			// The Service joining the group picks and modifies a load
+			// balancer of the group before having its ID
+			name:   "sharing group",
+			first:  newService("svc-1", map[string]string{ServiceAnnotationLoadBalancerSharingGroup: "web"}),
+			second: newService("svc-2", map[string]string{ServiceAnnotationLoadBalancerSharingGroup: "web", ServiceAnnotationLoadBalancerID: "lb-id"}),
+		},
+		{
+			name:   "Services joining a sharing group",
+			first:  newService("svc-1", map[string]string{ServiceAnnotationLoadBalancerSharingGroup: "web"}),
+			second: newService("svc-2", map[string]string{ServiceAnnotationLoadBalancerSharingGroup: "web"}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{UseOctavia: true}, lbLocks: keymutex.NewHashed(lbLockBuckets)}}
+			unlock := lbaas.lockLoadBalancer(context.TODO(), "kubernetes", test.first)
+
+			locked := make(chan struct{})
+			go func() {
+				unlockOther := lbaas.lockLoadBalancer(context.TODO(), "kubernetes", test.second)
+				close(locked)
+				unlockOther()
+			}()
+
+			select {
+			case <-locked:
+				t.Fatal("Services sharing a load balancer must not be reconciled in parallel")
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			unlock()
+			select {
+			case <-locked:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the load balancer lock was not released")
+			}
+		})
+	}
+}
+
+func TestCheckExternalIPs(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/floatingips", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("floating_ip_address") {
+		case "172.24.4.10":
+			fmt.Fprint(w, `{"floatingips": [{"id": "fip1", "floating_ip_address": "172.24.4.10", "port_id": "port1"}]}`)
+		case "172.24.4.11":
+			fmt.Fprint(w, `{"floatingips": [{"id": "fip2", "floating_ip_address": "172.24.4.11", "port_id": null}]}`)
+		default:
+			fmt.Fprint(w, `{"floatingips": []}`)
+		}
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("fixed_ips") == "ip_address=10.0.0.5" {
+			fmt.Fprint(w, `{"ports": [{"id": "port2", "fixed_ips": [{"ip_address": "10.0.0.5"}]}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"ports": []}`)
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{network: fakeclient.ServiceClient(), eventRecorder: recorder}}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			// The load balancer address, an associated floating IP, an
+			// unassociated floating IP, a port address and an unknown address
+			ExternalIPs: []string{"172.24.4.100", "172.24.4.10", "172.24.4.11", "10.0.0.5", "192.168.1.1"},
+		},
+	}
+	lbaas.checkExternalIPs(service, "172.24.4.100", &loadbalancers.LoadBalancer{VipAddress: "10.0.0.100"})
+
+	close(recorder.Events)
+	var events []string
+	for e := range recorder.Events {
+		events = append(events, strings.Fields(e)[1])
+	}
+	assert.Equal(t, []string{eventExternalIPUnassociated, eventExternalIPNotFound}, events)
+}
+
+func TestEnsureOctaviaPoolUnmanagedMembers(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/lbaas/pools", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"pools": [{"id": "pool1", "protocol": "TCP", "listeners": [{"id": "listener1"}]}]}`)
+	})
+	th.Mux.HandleFunc("/lbaas/pools/pool1/members", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s request to the members of the pool", r.Method)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	listener := &listeners.Listener{ID: "listener1", Protocol: "TCP"}
+	nodes := []*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}}
+	svcConf := &serviceConfig{manageMembers: false}
+
+	pool, err := lbaas.ensureOctaviaPool("lb1", "pool", listener, nil, &corev1.Service{}, corev1.ServicePort{NodePort: 30000}, nodes, svcConf)
+	assert.NoError(t, err)
+	assert.Equal(t, "pool1", pool.ID)
+}
+
+func TestCreateLoadBalancerAsyncProvisioning(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/lbaas/loadbalancers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"loadbalancer": {"id": "lb1", "vip_subnet_id": "subnet1", "provisioning_status": "PENDING_CREATE"}}`)
+	})
+	th.Mux.HandleFunc("/lbaas/loadbalancers/lb1", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s request waiting for the load balancer", r.Method)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient(), opts: LoadBalancerOpts{AsyncProvisioning: true, SubnetID: "subnet1"}}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}}
+
+	lb, err := lbaas.createFullyPopulatedOctaviaLoadBalancer("kube_service_kubernetes_default_svc", "kubernetes", service, nil, &serviceConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "PENDING_CREATE", lb.ProvisioningStatus)
+}
+
+func TestFindSharingGroupLoadBalancer(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/lbaas/loadbalancers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("tags") != "kube_lb_group_kubernetes_web" {
+			fmt.Fprint(w, `{"loadbalancers": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"loadbalancers": [
+			{"id": "lb-full", "provisioning_status": "ACTIVE", "tags": ["kube_lb_group_kubernetes_web", "kube_service_kubernetes_default_a", "kube_service_kubernetes_default_b"]},
+			{"id": "lb-pending", "provisioning_status": "PENDING_UPDATE", "tags": ["kube_lb_group_kubernetes_web", "kube_service_kubernetes_default_c"]},
+			{"id": "lb-port", "provisioning_status": "ACTIVE", "tags": ["kube_lb_group_kubernetes_web", "kube_service_kubernetes_default_d"]},
+			{"id": "lb-free", "provisioning_status": "ACTIVE", "tags": ["kube_lb_group_kubernetes_web", "kube_service_kubernetes_default_e"]}
+		]}`)
+	})
+	th.Mux.HandleFunc("/lbaas/listeners", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("loadbalancer_id") {
+		case "lb-port":
+			fmt.Fprint(w, `{"listeners": [{"id": "l1", "protocol": "HTTP", "protocol_port": 80}]}`)
+		case "lb-free":
+			fmt.Fprint(w, `{"listeners": [{"id": "l2", "protocol": "TCP", "protocol_port": 443}, {"id": "l3", "protocol": "UDP", "protocol_port": 80}]}`)
+		default:
+			t.Errorf("unexpected listing of the listeners of %s", r.URL.Query().Get("loadbalancer_id"))
+		}
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient(), opts: LoadBalancerOpts{MaxSharedLB: 2}}}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Protocol: corev1.ProtocolTCP, Port: 80}}},
+	}
+
+	lbID, err := lbaas.findSharingGroupLoadBalancer(context.TODO(), "kubernetes", service, &serviceConfig{sharingGroup: "web"})
+	assert.NoError(t, err)
+	assert.Equal(t, "lb-free", lbID)
+
+	// A load balancer is created when none of the group can take the Service
+	lbID, err = lbaas.findSharingGroupLoadBalancer(context.TODO(), "kubernetes", service, &serviceConfig{sharingGroup: "db"})
+	assert.NoError(t, err)
+	assert.Equal(t, "", lbID)
+}
+
+func TestBuildBatchUpdateMemberOptsMemberSubnet(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/subnets/subnet-storage", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"subnet": {"id": "subnet-storage", "cidr": "192.168.0.0/24", "ip_version": 4}}`)
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{network: fakeclient.ServiceClient(), eventRecorder: recorder}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name:        "svc",
+		Namespace:   "default",
+		Annotations: map[string]string{ServiceAnnotationLoadBalancerMemberSubnetID: "subnet-storage"},
+	}}
+	svcConf := &serviceConfig{lbMemberSubnetID: "subnet-private"}
+	assert.NoError(t, lbaas.setMemberSubnet(service, svcConf))
+	assert.Equal(t, "subnet-storage", svcConf.lbMemberSubnetID)
+
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.10"},
+			{Type: corev1.NodeInternalIP, Address: "192.168.0.10"},
+		}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}, Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.11"},
+		}}},
+	}
+	members, _, err := lbaas.buildBatchUpdateMemberOpts(service, corev1.ServicePort{NodePort: 30080}, nodes, svcConf)
+	assert.NoError(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, "192.168.0.10", members[0].Address)
+		assert.Equal(t, "subnet-storage", *members[0].SubnetID)
+	}
+
+	// The node without address on the member subnet is reported
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "MemberAddressNotInSubnet")
+		assert.Contains(t, event, "node-2")
+	default:
+		t.Error("expected an event for node-2")
+	}
+
+	// The member subnet must be of the IP family of the members
+	svcConf = &serviceConfig{memberIPFamily: corev1.IPv6Protocol}
+	assert.Error(t, lbaas.setMemberSubnet(service, svcConf))
+}
+
+func TestSetLBProvider(t *testing.T) {
+	tests := []struct {
+		name             string
+		configProvider   string
+		configMethod     string
+		annotation       string
+		expectedProvider string
+		expectedMethod   string
+		expectedErr      bool
+	}{
+		{
+			name:             "config provider",
+			configProvider:   "amphora",
+			configMethod:     "ROUND_ROBIN",
+			expectedProvider: "amphora",
+			expectedMethod:   "ROUND_ROBIN",
+		},
+		{
+			name:             "ovn over amphora",
+			configProvider:   "amphora",
+			configMethod:     "ROUND_ROBIN",
+			annotation:       "ovn",
+			expectedProvider: "ovn",
+			expectedMethod:   "SOURCE_IP_PORT",
+		},
+		{
+			name:             "amphora over ovn",
+			configProvider:   "ovn",
+			configMethod:     "SOURCE_IP_PORT",
+			annotation:       "amphora",
+			expectedProvider: "amphora",
+			expectedMethod:   "SOURCE_IP_PORT",
+		},
+		{
+			name:           "unsupported provider",
+			configProvider: "amphora",
+			configMethod:   "ROUND_ROBIN",
+			annotation:     "f5",
+			expectedErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{LBProvider: test.configProvider, LBMethod: test.configMethod}}}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"}}
+			if test.annotation != "" {
+				service.Annotations = map[string]string{ServiceAnnotationLoadBalancerProvider: test.annotation}
+			}
+			svcConf := &serviceConfig{}
+			err := lbaas.setLBProvider(service, svcConf)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedProvider, svcConf.lbProvider)
+			assert.Equal(t, test.expectedMethod, svcConf.lbMethod)
+		})
+	}
+
+	assert.True(t, isSameLBProvider("octavia", "amphora"))
+	assert.False(t, isSameLBProvider("ovn", "amphora"))
+}
+
+func TestSetFlavor(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/lbaas/flavors", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("name") {
+		case "dedicated":
+			fmt.Fprint(w, `{"flavors": [{"id": "flavor-dedicated", "name": "dedicated", "enabled": true}]}`)
+		case "disabled":
+			fmt.Fprint(w, `{"flavors": [{"id": "flavor-disabled", "name": "disabled", "enabled": false}]}`)
+		default:
+			fmt.Fprint(w, `{"flavors": []}`)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expectedID  string
+		expectedErr bool
+	}{
+		{
+			name:       "config flavor",
+			expectedID: "flavor-default",
+		},
+		{
+			name:        "flavor id",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerFlavorID: "flavor-small"},
+			expectedID:  "flavor-small",
+		},
+		{
+			name:        "flavor name",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerFlavorName: "dedicated"},
+			expectedID:  "flavor-dedicated",
+		},
+		{
+			name:        "disabled flavor",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerFlavorName: "disabled"},
+			expectedErr: true,
+		},
+		{
+			name: "flavor id and name",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerFlavorID:   "flavor-small",
+				ServiceAnnotationLoadBalancerFlavorName: "dedicated",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient(), opts: LoadBalancerOpts{FlavorID: "flavor-default"}}}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations}}
+			svcConf := &serviceConfig{}
+			err := lbaas.setFlavor(service, svcConf)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedID, svcConf.flavorID)
+		})
+	}
+}
+
+func TestCheckSCTPPorts(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	lbaas := &LbaasV2{LoadBalancer{eventRecorder: recorder}}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Protocol: corev1.ProtocolSCTP, Port: 3868}}},
+	}
+	assert.True(t, hasProtocolPort(service, corev1.ProtocolSCTP))
+	assert.False(t, hasProtocolPort(service, corev1.ProtocolUDP))
+
+	assert.NoError(t, lbaas.checkSCTPPorts(service, &serviceConfig{lbProvider: "amphora"}, true))
+
+	// The layer 7 features cannot be used
+	assert.Error(t, lbaas.checkSCTPPorts(service, &serviceConfig{lbProvider: "amphora", keepClientIP: true}, true))
+	assert.Error(t, lbaas.checkSCTPPorts(service, &serviceConfig{lbProvider: "amphora", tlsContainerRef: "ref"}, true))
+
+	// An event is recorded when the provider cannot do SCTP
+	assert.Error(t, lbaas.checkSCTPPorts(service, &serviceConfig{lbProvider: "amphora"}, false))
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "SCTPNotSupported")
+	default:
+		t.Error("expected a SCTPNotSupported event")
+	}
+
+	// SCTP listeners do not conflict with the TCP listeners of the same port
+	lbListeners := []listeners.Listener{{Protocol: "HTTP", ProtocolPort: 3868}}
+	assert.False(t, listenersHavePorts(lbListeners, service.Spec.Ports))
+	lbListeners = append(lbListeners, listeners.Listener{Protocol: "SCTP", ProtocolPort: 3868})
+	assert.True(t, listenersHavePorts(lbListeners, service.Spec.Ports))
+}
+
+func TestSetClientIPPreservation(t *testing.T) {
+	tests := []struct {
+		name                  string
+		annotations           map[string]string
+		tlsContainerRef       string
+		expectedProxyProtocol v2pools.Protocol
+		expectedHeaders       map[string]string
+		expectedErr           bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:                  "proxy protocol",
+			annotations:           map[string]string{ServiceAnnotationLoadBalancerProxyEnabled: "true"},
+			expectedProxyProtocol: v2pools.ProtocolPROXY,
+		},
+		{
+			name:                  "proxy protocol v1",
+			annotations:           map[string]string{ServiceAnnotationLoadBalancerProxyEnabled: "v1"},
+			expectedProxyProtocol: v2pools.ProtocolPROXY,
+		},
+		{
+			name:        "invalid proxy protocol",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerProxyEnabled: "v3"},
+			expectedErr: true,
+		},
+		{
+			name: "proxy protocol and x-forwarded-for",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerProxyEnabled:  "true",
+				ServiceAnnotationLoadBalancerXForwardedFor: "true",
+			},
+			expectedErr: true,
+		},
+		{
+			name: "x-forwarded headers",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerXForwardedFor:   "true",
+				ServiceAnnotationLoadBalancerXForwardedPort:  "true",
+				ServiceAnnotationLoadBalancerXForwardedProto: "true",
+			},
+			expectedHeaders: map[string]string{"X-Forwarded-For": "true", "X-Forwarded-Port": "true", "X-Forwarded-Proto": "true"},
+		},
+		{
+			name:            "x-forwarded-proto on TLS listener",
+			annotations:     map[string]string{ServiceAnnotationLoadBalancerXForwardedProto: "true"},
+			tlsContainerRef: "ref",
+			expectedHeaders: map[string]string{"X-Forwarded-Proto": "true"},
+		},
+		{
+			name:        "x-forwarded-port without HTTP listener",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerXForwardedPort: "true"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{}}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations}}
+			svcConf := &serviceConfig{lbProvider: "amphora", tlsContainerRef: test.tlsContainerRef}
+			err := lbaas.setClientIPPreservation(service, svcConf)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedProxyProtocol, svcConf.proxyProtocol)
+			assert.Equal(t, test.expectedProxyProtocol != "", svcConf.enableProxyProtocol)
+			assert.Equal(t, test.expectedHeaders, svcConf.insertHeaders)
+		})
+	}
+}
+
+func TestGetListenerInsertHeaders(t *testing.T) {
+	current := map[string]string{"X-Forwarded-For": "true", "X-SSL-Client-Verify": "true"}
+
+	insertHeaders, changed := getListenerInsertHeaders(current, map[string]string{"X-Forwarded-For": "true"})
+	assert.False(t, changed)
+	assert.Equal(t, current, insertHeaders)
+
+	insertHeaders, changed = getListenerInsertHeaders(current, map[string]string{"X-Forwarded-Proto": "true"})
+	assert.True(t, changed)
+	assert.Equal(t, map[string]string{"X-Forwarded-Proto": "true", "X-SSL-Client-Verify": "true"}, insertHeaders)
+
+	insertHeaders, changed = getListenerInsertHeaders(current, nil)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]string{"X-SSL-Client-Verify": "true"}, insertHeaders)
+}
+
+func TestSetHealthMonitor(t *testing.T) {
+	tcpPort := corev1.ServicePort{Protocol: corev1.ProtocolTCP, Port: 80}
+	udpPort := corev1.ServicePort{Protocol: corev1.ProtocolUDP, Port: 53}
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		port            corev1.ServicePort
+		expectedType    string
+		expectedURLPath string
+		expectedCodes   string
+		expectedErr     bool
+	}{
+		{
+			name:         "default TCP",
+			port:         tcpPort,
+			expectedType: "TCP",
+		},
+		{
+			name:         "default UDP",
+			port:         udpPort,
+			expectedType: "UDP-CONNECT",
+		},
+		{
+			name:            "HTTP with defaults",
+			annotations:     map[string]string{ServiceAnnotationLoadBalancerHealthMonitorType: "http"},
+			port:            tcpPort,
+			expectedType:    "HTTP",
+			expectedURLPath: "/",
+			expectedCodes:   "200",
+		},
+		{
+			name: "HTTPS with path and codes",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerHealthMonitorType:          "HTTPS",
+				ServiceAnnotationLoadBalancerHealthMonitorURLPath:       "/healthz",
+				ServiceAnnotationLoadBalancerHealthMonitorExpectedCodes: "200-204",
+			},
+			port:            tcpPort,
+			expectedType:    "HTTPS",
+			expectedURLPath: "/healthz",
+			expectedCodes:   "200-204",
+		},
+		{
+			name:        "HTTP on UDP port",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorType: "HTTP"},
+			port:        udpPort,
+			expectedErr: true,
+		},
+		{
+			name:        "UDP-CONNECT on TCP port",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorType: "UDP-CONNECT"},
+			port:        tcpPort,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{}}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{test.port}},
+			}
+			svcConf := &serviceConfig{}
+			err := lbaas.setHealthMonitor(service, svcConf)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			opts := lbaas.buildMonitorCreateOpts(svcConf, test.port)
+			assert.Equal(t, test.expectedType, opts.Type)
+			assert.Equal(t, test.expectedURLPath, opts.URLPath)
+			assert.Equal(t, test.expectedCodes, opts.ExpectedCodes)
+		})
+	}
+
+	// The health check node port is always checked over HTTP with the defaults of Octavia
+	svcConf := &serviceConfig{healthCheckNodePort: 32000, healthMonitorType: "TCP", healthMonitorURLPath: "/", healthMonitorExpected: "200"}
+	opts := (&LbaasV2{LoadBalancer{}}).buildMonitorCreateOpts(svcConf, tcpPort)
+	assert.Equal(t, "HTTP", opts.Type)
+	assert.Empty(t, opts.URLPath)
+}
+
+func TestMixedProtocolService(t *testing.T) {
+	tcpPort := corev1.ServicePort{Protocol: corev1.ProtocolTCP, Port: 53}
+	udpPort := corev1.ServicePort{Protocol: corev1.ProtocolUDP, Port: 53}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns", Namespace: "default", Annotations: map[string]string{ServiceAnnotationLoadBalancerHealthMonitorType: "HTTP"}},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{tcpPort, udpPort}},
+	}
+
+	// The health monitor type of the annotation only applies to the ports it can check
+	lbaas := &LbaasV2{LoadBalancer{}}
+	svcConf := &serviceConfig{}
+	assert.NoError(t, lbaas.setHealthMonitor(service, svcConf))
+	assert.Equal(t, "HTTP", lbaas.buildMonitorCreateOpts(svcConf, tcpPort).Type)
+	opts := lbaas.buildMonitorCreateOpts(svcConf, udpPort)
+	assert.Equal(t, "UDP-CONNECT", opts.Type)
+	assert.Empty(t, opts.URLPath)
+
+	service.Annotations[ServiceAnnotationLoadBalancerHealthMonitorType] = "SCTP"
+	assert.Error(t, lbaas.setHealthMonitor(service, &serviceConfig{}))
+
+	// The layer 7 features only apply to the TCP ports
+	svcConf = &serviceConfig{
+		keepClientIP:    true,
+		tlsContainerRef: "https://key-manager/v1/containers/tls",
+		persistence:     &v2pools.SessionPersistence{Type: "HTTP_COOKIE"},
+	}
+	assert.Equal(t, listeners.ProtocolTerminatedHTTPS, getListenerProtocol(tcpPort.Protocol, svcConf))
+	assert.Equal(t, listeners.ProtocolUDP, getListenerProtocol(udpPort.Protocol, svcConf))
+	assert.Equal(t, v2pools.ProtocolHTTP, getPoolProtocol(string(listeners.ProtocolTerminatedHTTPS), svcConf))
+	assert.Equal(t, v2pools.ProtocolUDP, getPoolProtocol(string(listeners.ProtocolUDP), svcConf))
+	assert.Equal(t, svcConf.persistence, getPoolPersistence(v2pools.ProtocolHTTP, svcConf))
+	assert.Nil(t, getPoolPersistence(v2pools.ProtocolUDP, svcConf))
+
+	svcConf = &serviceConfig{enableProxyProtocol: true, proxyProtocol: v2pools.ProtocolPROXY, persistence: &v2pools.SessionPersistence{Type: "SOURCE_IP"}}
+	assert.Equal(t, v2pools.ProtocolPROXY, getPoolProtocol(string(listeners.ProtocolTCP), svcConf))
+	assert.Equal(t, v2pools.ProtocolUDP, getPoolProtocol(string(listeners.ProtocolUDP), svcConf))
+	assert.Equal(t, svcConf.persistence, getPoolPersistence(v2pools.ProtocolUDP, svcConf))
+}
+
+func TestSetSessionPersistence(t *testing.T) {
+	tests := []struct {
+		name         string
+		affinity     corev1.ServiceAffinity
+		annotations  map[string]string
+		keepClientIP bool
+		expected     *v2pools.SessionPersistence
+		expectedErr  bool
+	}{
+		{
+			name: "no session affinity",
+		},
+		{
+			name:     "client IP session affinity",
+			affinity: corev1.ServiceAffinityClientIP,
+			expected: &v2pools.SessionPersistence{Type: "SOURCE_IP"},
+		},
+		{
+			name:        "disabled by annotation",
+			affinity:    corev1.ServiceAffinityClientIP,
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistence: "none"},
+		},
+		{
+			name:         "HTTP cookie",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerSessionPersistence: "HTTP_COOKIE"},
+			keepClientIP: true,
+			expected:     &v2pools.SessionPersistence{Type: "HTTP_COOKIE"},
+		},
+		{
+			name:        "HTTP cookie without HTTP pool",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistence: "HTTP_COOKIE"},
+			expectedErr: true,
+		},
+		{
+			name: "app cookie",
+			annotations: map[string]string{
+				ServiceAnnotationLoadBalancerSessionPersistence:           "APP_COOKIE",
+				ServiceAnnotationLoadBalancerSessionPersistenceCookieName: "JSESSIONID",
+			},
+			keepClientIP: true,
+			expected:     &v2pools.SessionPersistence{Type: "APP_COOKIE", CookieName: "JSESSIONID"},
+		},
+		{
+			name:         "app cookie without cookie name",
+			annotations:  map[string]string{ServiceAnnotationLoadBalancerSessionPersistence: "APP_COOKIE"},
+			keepClientIP: true,
+			expectedErr:  true,
+		},
+		{
+			name:        "cookie name without app cookie",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistenceCookieName: "JSESSIONID"},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid type",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerSessionPersistence: "STICKY"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{}}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations},
+				Spec:       corev1.ServiceSpec{SessionAffinity: test.affinity},
+			}
+			svcConf := &serviceConfig{keepClientIP: test.keepClientIP}
+			err := lbaas.setSessionPersistence(service, svcConf)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, svcConf.persistence)
+		})
+	}
+}
+
+func TestIsSessionPersistenceChanged(t *testing.T) {
+	assert.False(t, isSessionPersistenceChanged(v2pools.SessionPersistence{}, nil))
+	assert.True(t, isSessionPersistenceChanged(v2pools.SessionPersistence{Type: "SOURCE_IP"}, nil))
+	assert.False(t, isSessionPersistenceChanged(v2pools.SessionPersistence{Type: "SOURCE_IP"}, &v2pools.SessionPersistence{Type: "SOURCE_IP"}))
+	assert.True(t, isSessionPersistenceChanged(v2pools.SessionPersistence{Type: "APP_COOKIE", CookieName: "a"}, &v2pools.SessionPersistence{Type: "APP_COOKIE", CookieName: "b"}))
+}
+
+func TestGetListenerTimeoutAnnotation(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		ServiceAnnotationLoadBalancerTimeoutClientData:    "3600000",
+		ServiceAnnotationLoadBalancerTimeoutMemberData:    "-1",
+		ServiceAnnotationLoadBalancerTimeoutMemberConnect: "5s",
+	}}}
+
+	timeout, ok, err := getListenerTimeoutAnnotation(service, ServiceAnnotationLoadBalancerTimeoutClientData)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3600000, timeout)
+
+	_, ok, err = getListenerTimeoutAnnotation(service, ServiceAnnotationLoadBalancerTimeoutTCPInspect)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = getListenerTimeoutAnnotation(service, ServiceAnnotationLoadBalancerTimeoutMemberData)
+	assert.Error(t, err)
+	_, _, err = getListenerTimeoutAnnotation(service, ServiceAnnotationLoadBalancerTimeoutMemberConnect)
+	assert.Error(t, err)
+}
+
+func TestSetListenerLimitsInvalid(t *testing.T) {
+	for _, annotations := range []map[string]string{
+		{ServiceAnnotationLoadBalancerConnLimit: "-2"},
+		{ServiceAnnotationLoadBalancerConnLimit: "unlimited"},
+		{ServiceAnnotationLoadBalancerTimeoutTCPInspect: "-1"},
+	} {
+		lbaas := &LbaasV2{LoadBalancer{}}
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: annotations}}
+		assert.Error(t, lbaas.setListenerLimits(service, &serviceConfig{}), annotations)
+	}
+}
+
+func TestIsDualStackService(t *testing.T) {
+	singleStack := corev1.IPFamilyPolicySingleStack
+	preferDualStack := corev1.IPFamilyPolicyPreferDualStack
+	dualStackFamilies := []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+
+	tests := []struct {
+		name     string
+		policy   *corev1.IPFamilyPolicyType
+		families []corev1.IPFamily
+		expected bool
+	}{
+		{name: "no policy", families: dualStackFamilies},
+		{name: "single-stack", policy: &singleStack, families: []corev1.IPFamily{corev1.IPv4Protocol}},
+		{name: "prefer dual-stack on a single-stack cluster", policy: &preferDualStack, families: []corev1.IPFamily{corev1.IPv4Protocol}},
+		{name: "prefer dual-stack", policy: &preferDualStack, families: dualStackFamilies, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &corev1.Service{Spec: corev1.ServiceSpec{IPFamilyPolicy: test.policy, IPFamilies: test.families}}
+			assert.Equal(t, test.expected, isDualStackService(service))
+		})
+	}
+}
+
+func TestSetDualStackWithoutIPv6Subnet(t *testing.T) {
+	families := []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+	for _, policy := range []corev1.IPFamilyPolicyType{corev1.IPFamilyPolicyPreferDualStack, corev1.IPFamilyPolicyRequireDualStack} {
+		policy := policy
+		t.Run(string(policy), func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{}}
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default"},
+				Spec:       corev1.ServiceSpec{IPFamilyPolicy: &policy, IPFamilies: families},
+			}
+			svcConf := &serviceConfig{additionalVIPSubnetID: "stale"}
+			err := lbaas.setDualStack(service, svcConf)
+			if policy == corev1.IPFamilyPolicyRequireDualStack {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Empty(t, svcConf.additionalVIPSubnetID)
+		})
+	}
+}
+
+func TestSetExistingLoadBalancer(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expectedID  string
+		existingLB  bool
+		expectError bool
+	}{
+		{
+			name:        "no existing load balancer",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerID: "lb1"},
+			expectedID:  "lb1",
+		},
+		{
+			name:        "existing load balancer",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerExistingID: "lb2"},
+			expectedID:  "lb2",
+			existingLB:  true,
+		},
+		{
+			name:        "existing load balancer recorded",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerID: "lb2", ServiceAnnotationLoadBalancerExistingID: "lb2"},
+			expectedID:  "lb2",
+			existingLB:  true,
+		},
+		{
+			name:        "existing load balancer changed",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerID: "lb1", ServiceAnnotationLoadBalancerExistingID: "lb2"},
+			expectError: true,
+		},
+		{
+			name:        "existing load balancer by tag",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerExistingTag: "tag1"},
+			existingLB:  true,
+		},
+		{
+			name:        "existing load balancer by tag recorded",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerID: "lb2", ServiceAnnotationLoadBalancerExistingTag: "tag1"},
+			expectedID:  "lb2",
+			existingLB:  true,
+		},
+		{
+			name:        "existing load balancer by ID and tag",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerExistingID: "lb2", ServiceAnnotationLoadBalancerExistingTag: "tag1"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations}}
+			svcConf := &serviceConfig{lbID: test.annotations[ServiceAnnotationLoadBalancerID]}
+			err := setExistingLoadBalancer(service, svcConf)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedID, svcConf.lbID)
+			assert.Equal(t, test.existingLB, svcConf.existingLB)
+		})
+	}
+}
+
+func TestFindExistingLoadBalancerByTag(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/lbaas/loadbalancers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("tags") {
+		case "single":
+			fmt.Fprint(w, `{"loadbalancers": [{"id": "lb1"}]}`)
+		case "multiple":
+			fmt.Fprint(w, `{"loadbalancers": [{"id": "lb1"}, {"id": "lb2"}]}`)
+		default:
+			fmt.Fprint(w, `{"loadbalancers": []}`)
+		}
+	})
+
+	tests := []struct {
+		name          string
+		tag           string
+		lbID          string
+		supportLBTags bool
+		expectedID    string
+		expectError   bool
+	}{
+		{name: "no tag", supportLBTags: true},
+		{name: "single load balancer", tag: "single", supportLBTags: true, expectedID: "lb1"},
+		{name: "no load balancer", tag: "none", supportLBTags: true},
+		{name: "multiple load balancers", tag: "multiple", supportLBTags: true, expectError: true},
+		{name: "recorded load balancer", tag: "multiple", lbID: "lb2", supportLBTags: true, expectedID: "lb2"},
+		{name: "tags not supported", tag: "single", expectError: true},
+	}
+	lbaas := &LbaasV2{LoadBalancer{lb: fakeclient.ServiceClient()}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: map[string]string{ServiceAnnotationLoadBalancerExistingTag: test.tag}}}
+			svcConf := &serviceConfig{lbID: test.lbID, supportLBTags: test.supportLBTags}
+			err := lbaas.findExistingLoadBalancerByTag(service, svcConf)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedID, svcConf.lbID)
+		})
+	}
+}
+
+func TestGetListenerAllowedCIDRs(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceRanges []string
+		svcConf      *serviceConfig
+		expected     []string
+		expectErr    bool
+	}{
+		{name: "unknown VIP family", sourceRanges: []string{"10.0.0.0/8", "2001:db8::/32"}, svcConf: &serviceConfig{}, expected: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{name: "default on an IPv6 VIP", sourceRanges: []string{"0.0.0.0/0"}, svcConf: &serviceConfig{vipIPv6SubnetID: "subnet"}, expected: []string{"::/0"}},
+		{name: "default on a dual-stack VIP", sourceRanges: []string{"0.0.0.0/0"}, svcConf: &serviceConfig{additionalVIPSubnetID: "subnet"}, expected: []string{"0.0.0.0/0", "::/0"}},
+		{name: "IPv6 VIP", sourceRanges: []string{"10.0.0.0/8", "2001:db8::/32"}, svcConf: &serviceConfig{vipIPv6SubnetID: "subnet"}, expected: []string{"2001:db8::/32"}},
+		{name: "no range of the IPv6 VIP family", sourceRanges: []string{"10.0.0.0/8"}, svcConf: &serviceConfig{vipIPv6SubnetID: "subnet"}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sourceRanges, err := netsets.ParseIPNets(test.sourceRanges...)
+			assert.NoError(t, err)
+			cidrs, err := getListenerAllowedCIDRs(sourceRanges, test.svcConf)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, cidrs)
+		})
+	}
+}
+
+func TestGetMemberNodes(t *testing.T) {
+	newNode := func(name string, nodeLabels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels}}
+	}
+	nodes := []*corev1.Node{
+		newNode("ingress", map[string]string{"node-role.kubernetes.io/ingress": ""}),
+		newNode("worker", map[string]string{"node-role.kubernetes.io/worker": ""}),
+		newNode("unlabeled", nil),
+	}
+	names := func(nodes []*corev1.Node) []string {
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name        string
+		selector    string
+		annotations map[string]string
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name:     "no selector",
+			expected: []string{"ingress", "worker", "unlabeled"},
+		},
+		{
+			name:     "option",
+			selector: "node-role.kubernetes.io/ingress",
+			expected: []string{"ingress"},
+		},
+		{
+			name:        "annotation overrides the option",
+			selector:    "node-role.kubernetes.io/ingress",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerMemberNodeSelector: "!node-role.kubernetes.io/ingress"},
+			expected:    []string{"worker", "unlabeled"},
+		},
+		{
+			name:        "invalid annotation",
+			annotations: map[string]string{ServiceAnnotationLoadBalancerMemberNodeSelector: "a in (b"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{MemberNodeSelector: test.selector}}}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", Annotations: test.annotations}}
+			memberNodes, err := lbaas.getMemberNodes(service, nodes)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, names(memberNodes))
+		})
+	}
+}
+
+func TestSetVIPSubnet(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		networkID       string
+		subnetID        string
+		memberSubnetID  string
+		vipPlacedByUser bool
+	}{
+		{
+			name:           "node subnet",
+			subnetID:       "node-subnet",
+			memberSubnetID: "node-subnet",
+		},
+		{
+			name:            "subnet annotation",
+			annotations:     map[string]string{ServiceAnnotationLoadBalancerSubnetID: "tenant-subnet"},
+			subnetID:        "tenant-subnet",
+			memberSubnetID:  "node-subnet",
+			vipPlacedByUser: true,
+		},
+		{
+			name:            "network annotation",
+			annotations:     map[string]string{ServiceAnnotationLoadBalancerNetworkID: "tenant-network"},
+			networkID:       "tenant-network",
+			memberSubnetID:  "node-subnet",
+			vipPlacedByUser: true,
+		},
+		{
+			name:            "port annotation",
+			annotations:     map[string]string{ServiceAnnotationLoadBalancerPortID: "vip-port"},
+			subnetID:        "node-subnet",
+			memberSubnetID:  "node-subnet",
+			vipPlacedByUser: true,
+		},
+	}
+
+	for _, test := range tests {
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc", Annotations: test.annotations}}
+		assert.Equal(t, test.vipPlacedByUser, isVIPPlacedByAnnotation(service), test.name)
+
+		lbaas := &LbaasV2{LoadBalancer{opts: LoadBalancerOpts{SubnetID: "node-subnet"}}}
+		svcConf := &serviceConfig{}
+		assert.NoError(t, lbaas.setVIPSubnet(service, nil, svcConf), test.name)
+		assert.Equal(t, test.networkID, svcConf.lbNetworkID, test.name)
+		assert.Equal(t, test.subnetID, svcConf.lbSubnetID, test.name)
+		assert.Equal(t, test.memberSubnetID, svcConf.lbMemberSubnetID, test.name)
+	}
+}
+
+func TestSetVIPSubnetFromNodes(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/servers/server1/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": [{"port_id": "port1", "fixed_ips": [{"subnet_id": "node-subnet", "ip_address": "10.0.0.10"}]}]}`)
+	})
+
+	lbaas := &LbaasV2{LoadBalancer{compute: fakeclient.ServiceClient()}}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec:       corev1.NodeSpec{ProviderID: "openstack:///server1"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.10"}}},
+	}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "svc",
+		Annotations: map[string]string{ServiceAnnotationLoadBalancerSubnetID: "tenant-subnet"},
+	}}
+
+	// The members are on the subnet of the nodes, which does not become the
+	// subnet of the VIPs
+	svcConf := &serviceConfig{}
+	assert.NoError(t, lbaas.setVIPSubnet(service, []*corev1.Node{node}, svcConf))
+	assert.Equal(t, "tenant-subnet", svcConf.lbSubnetID)
+	assert.Equal(t, "node-subnet", svcConf.lbMemberSubnetID)
+	assert.Equal(t, "node-subnet", lbaas.defaultSubnetID())
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// tlsSecretPrefix prefixes the names of the Barbican secrets of the TLS
+// Secrets of the Services, followed by the UID of the Service.
+const tlsSecretPrefix = servicePrefix + "tls_"
+
+// getTLSSecretHash returns the hash of the certificate and the private key of
+// the TLS Secret, which changes when the certificate is rotated.
+func getTLSSecretHash(secret *corev1.Secret) (string, error) {
+	cert, ok := secret.Data[corev1.TLSCertKey]
+	if !ok {
+		return "", fmt.Errorf("%s key doesn't exist in the secret %s/%s", corev1.TLSCertKey, secret.Namespace, secret.Name)
+	}
+	key, ok := secret.Data[corev1.TLSPrivateKeyKey]
+	if !ok {
+		return "", fmt.Errorf("%s key doesn't exist in the secret %s/%s", corev1.TLSPrivateKeyKey, secret.Namespace, secret.Name)
+	}
+
+	h := sha256.New()
+	h.Write(cert)
+	h.Write(key)
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// getTLSSecretName returns the name of the Barbican secret of the certificate
+// of the Service with the hash.
+func getTLSSecretName(service *corev1.Service, hash string) string {
+	return fmt.Sprintf("%s%s_%s", tlsSecretPrefix, service.UID, hash)
+}
+
+// ensureTLSSecret uploads the certificate of the TLS Secret of the Service to
+// Barbican as a PKCS#12 bundle, and returns its reference and the hash of the
+// Secret. A new Barbican secret is created when the certificate changes.
+func (lbaas *LbaasV2) ensureTLSSecret(ctx context.Context, service *corev1.Service, name string) (string, string, error) {
+	secret, err := lbaas.kclient.CoreV1().Secrets(service.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get TLS secret %s/%s: %v", service.Namespace, name, err)
+	}
+	hash, err := getTLSSecretHash(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	encoded, err := openstackutil.EncodePKCS12(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid TLS secret %s/%s: %v", service.Namespace, name, err)
+	}
+	ref, err := openstackutil.EnsureSecret(lbaas.secret, getTLSSecretName(service, hash), "application/octet-stream", encoded)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create Barbican secret of TLS secret %s/%s: %v", service.Namespace, name, err)
+	}
+	return ref, hash, nil
+}
+
+// deleteTLSSecrets deletes the Barbican secrets of the certificates of the
+// Service but the one in use.
+func (lbaas *LbaasV2) deleteTLSSecrets(service *corev1.Service, inUseRef string) error {
+	prefix := fmt.Sprintf("%s%s_", tlsSecretPrefix, service.UID)
+	mc := metrics.NewMetricContext("secret", "list")
+	allPages, err := secrets.List(lbaas.secret, secrets.ListOpts{SecretType: secrets.OpaqueSecret}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return fmt.Errorf("failed to list Barbican secrets: %v", err)
+	}
+	allSecrets, err := secrets.ExtractSecrets(allPages)
+	if err != nil {
+		return fmt.Errorf("failed to extract Barbican secrets: %v", err)
+	}
+
+	for _, s := range allSecrets {
+		if !strings.HasPrefix(s.Name, prefix) || s.SecretRef == inUseRef {
+			continue
+		}
+		secretID, err := openstackutil.ParseSecretID(s.SecretRef)
+		if err != nil {
+			return err
+		}
+		mc := metrics.NewMetricContext("secret", "delete")
+		err = secrets.Delete(lbaas.secret, secretID).ExtractErr()
+		if mc.ObserveRequest(err) != nil {
+			return fmt.Errorf("failed to delete Barbican secret %s: %v", s.Name, err)
+		}
+		klog.InfoS("Deleted Barbican secret", "secretName", s.Name, "service", klog.KObj(service))
+	}
+	return nil
+}
+
+// getTLSContainerRefForProtocol returns a non-empty value when the listeners
+// of the Service terminate TLS. Only the protocols of the listeners and pools
+// depend on the TLS container when the load balancer is updated or deleted,
+// the Barbican secret of the TLS Secret is not needed.
+func getTLSContainerRefForProtocol(service *corev1.Service, defaultRef string) string {
+	if tlsSecret := getStringFromServiceAnnotation(service, ServiceAnnotationLoadBalancerTLSSecret, ""); tlsSecret != "" {
+		return tlsSecret
+	}
+	return getStringFromServiceAnnotation(service, ServiceAnnotationTlsContainerRef, defaultRef)
+}
+
+// updateTLSSecretHash records the hash of the TLS Secret used by the listeners
+// of the Service, and deletes the Barbican secrets of its previous
+// certificates, which are not used by the listeners anymore.
+func (lbaas *LbaasV2) updateTLSSecretHash(service *corev1.Service, svcConf *serviceConfig) {
+	_, hadHash := service.Annotations[ServiceAnnotationLoadBalancerTLSSecretHash]
+	if svcConf.tlsSecretHash != "" {
+		lbaas.updateServiceAnnotation(service, ServiceAnnotationLoadBalancerTLSSecretHash, svcConf.tlsSecretHash)
+	} else if hadHash {
+		delete(service.Annotations, ServiceAnnotationLoadBalancerTLSSecretHash)
+	} else {
+		return
+	}
+
+	// The Barbican secrets are deleted once the listeners do not use them, a
+	// failure is retried at the next rotation.
+	if err := lbaas.deleteTLSSecrets(service, svcConf.tlsContainerRef); err != nil {
+		klog.Warningf("Failed to delete the previous Barbican secrets of Service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+}
+
+// tlsSecretRotator requeues the Services using a TLS Secret when the Secret
+// changes, so the certificate of their listeners is rotated. The Services are
+// requeued by removing their TLS Secret hash annotation, as the service
+// controller ensures the load balancer of a Service when its annotations
+// change.
+type tlsSecretRotator struct {
+	kclient       kubernetes.Interface
+	serviceLister corelisters.ServiceLister
+}
+
+func newTLSSecretRotator(kclient kubernetes.Interface, serviceLister corelisters.ServiceLister) *tlsSecretRotator {
+	return &tlsSecretRotator{kclient: kclient, serviceLister: serviceLister}
+}
+
+func (r *tlsSecretRotator) onSecretUpdate(oldObj, newObj interface{}) {
+	secret, ok := newObj.(*corev1.Secret)
+	if !ok || secret.Type != corev1.SecretTypeTLS {
+		return
+	}
+	hash, err := getTLSSecretHash(secret)
+	if err != nil {
+		return
+	}
+
+	services, err := r.serviceLister.Services(secret.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list the Services of namespace %s: %v", secret.Namespace, err)
+		return
+	}
+	for _, service := range services {
+		if service.Annotations[ServiceAnnotationLoadBalancerTLSSecret] != secret.Name {
+			continue
+		}
+		current, ok := service.Annotations[ServiceAnnotationLoadBalancerTLSSecretHash]
+		if !ok || current == hash {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{ServiceAnnotationLoadBalancerTLSSecretHash: nil},
+			},
+		})
+		if err != nil {
+			klog.Errorf("Failed to build annotation patch for Service %s/%s: %v", service.Namespace, service.Name, err)
+			continue
+		}
+		if _, err := r.kclient.CoreV1().Services(service.Namespace).Patch(context.TODO(), service.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			klog.Errorf("Failed to requeue Service %s/%s for the rotation of TLS secret %s: %v", service.Namespace, service.Name, secret.Name, err)
+			continue
+		}
+		klog.InfoS("Rotating the certificate of the Service", "service", klog.KObj(service), "secret", secret.Name)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetTLSContainerRefForProtocol(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	assert.Equal(t, "", getTLSContainerRefForProtocol(service, ""))
+	assert.Equal(t, "default-ref", getTLSContainerRefForProtocol(service, "default-ref"))
+
+	service.Annotations[ServiceAnnotationLoadBalancerTLSSecret] = "tls"
+	assert.NotEmpty(t, getTLSContainerRefForProtocol(service, ""))
+}
+
+func TestTLSSecretRotator(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")},
+	}
+	hash, err := getTLSSecretHash(secret)
+	assert.NoError(t, err)
+
+	newService := func(name, tlsSecret, hash string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: map[string]string{
+			ServiceAnnotationLoadBalancerTLSSecret:     tlsSecret,
+			ServiceAnnotationLoadBalancerTLSSecretHash: hash,
+		}}}
+	}
+	services := []*corev1.Service{
+		newService("rotated", "tls", "previous"),
+		newService("up-to-date", "tls", hash),
+		newService("other", "other-tls", "previous"),
+	}
+
+	kclient := fake.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, s := range services {
+		_, err := kclient.CoreV1().Services(s.Namespace).Create(context.TODO(), s, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		assert.NoError(t, indexer.Add(s))
+	}
+
+	rotator := newTLSSecretRotator(kclient, corelisters.NewServiceLister(indexer))
+	rotator.onSecretUpdate(secret, secret)
+
+	for _, test := range []struct {
+		name      string
+		wantHash  string
+		wantFound bool
+	}{
+		{name: "rotated"},
+		{name: "up-to-date", wantHash: hash, wantFound: true},
+		{name: "other", wantHash: "previous", wantFound: true},
+	} {
+		s, err := kclient.CoreV1().Services("default").Get(context.TODO(), test.name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		got, found := s.Annotations[ServiceAnnotationLoadBalancerTLSSecretHash]
+		assert.Equal(t, test.wantFound, found, test.name)
+		assert.Equal(t, test.wantHash, got, test.name)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+)
+
+// AnnotationExternalCCM opts a node or a Service in to the reconciliation by
+// openstack-cloud-controller-manager when it runs in migration mode.
+const AnnotationExternalCCM = "openstack.org/external-ccm"
+
+// migrationMode is set when openstack-cloud-controller-manager runs next to
+// the in-tree OpenStack cloud provider, and only reconciles the nodes and
+// Services which were migrated to it.
+var migrationMode bool
+
+// isMigrated reports whether the object is reconciled by
+// openstack-cloud-controller-manager.
+func isMigrated(obj metav1.Object) bool {
+	return !migrationMode || obj.GetAnnotations()[AnnotationExternalCCM] == "true"
+}
+
+// isNodeMigrated reports whether the node is reconciled by
+// openstack-cloud-controller-manager. A node whose kubelet runs with
+// --cloud-provider=external can only be initialized by an external cloud
+// provider, so it is always migrated.
+func isNodeMigrated(node *corev1.Node) bool {
+	if isMigrated(node) {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == cloudproviderapi.TaintExternalCloudProvider {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyInstanceMetadata returns the metadata the node already has, so the
+// node controller leaves a node managed by the in-tree cloud provider as is.
+func legacyInstanceMetadata(node *corev1.Node) *cloudprovider.InstanceMetadata {
+	return &cloudprovider.InstanceMetadata{
+		ProviderID:    node.Spec.ProviderID,
+		InstanceType:  node.Labels[corev1.LabelInstanceTypeStable],
+		NodeAddresses: node.Status.Addresses,
+		Zone:          node.Labels[corev1.LabelTopologyZone],
+		Region:        node.Labels[corev1.LabelTopologyRegion],
+	}
+}
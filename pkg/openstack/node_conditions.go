@@ -0,0 +1,254 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/extendedserverattributes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/services"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	// NodeConditionNeutronPortDown is true when a Neutron port of the instance
+	// of the node is administratively down or its status is DOWN.
+	NodeConditionNeutronPortDown corev1.NodeConditionType = "NeutronPortDown"
+	// NodeConditionHypervisorDown is true when the nova-compute service of the
+	// hypervisor of the instance is disabled or down. The hypervisor of the
+	// instance is only known with admin access.
+	NodeConditionHypervisorDown corev1.NodeConditionType = "HypervisorDown"
+	// NodeConditionServerError is true when the instance of the node is in the
+	// ERROR state.
+	NodeConditionServerError corev1.NodeConditionType = "ServerError"
+
+	instanceError = "ERROR"
+)
+
+// serverWithHost is an instance with the hypervisor host it runs on, empty
+// without admin access.
+type serverWithHost struct {
+	servers.Server
+	extendedserverattributes.ServerAttributesExt
+}
+
+// syncNodeConditions reports the OpenStack problems of the instances of the
+// nodes as node conditions, so the cluster autoscaler and the operators can
+// react to them before the kubelet stops posting its status.
+func (os *OpenStack) syncNodeConditions() {
+	ctx := context.TODO()
+
+	compute, err := client.NewComputeV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Errorf("Failed to create an OpenStack Compute client to report the node conditions: %v", err)
+		return
+	}
+	network, err := client.NewNetworkV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Errorf("Failed to create an OpenStack Network client to report the node conditions: %v", err)
+		return
+	}
+
+	nodes, err := os.kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list nodes: %v", err)
+		return
+	}
+
+	// The compute services are only listed with admin access, the hypervisor
+	// condition is not reported otherwise.
+	computeServices, err := getComputeServices(compute)
+	if err != nil {
+		klog.V(4).Infof("Not reporting the %s node condition: %v", NodeConditionHypervisorDown, err)
+	}
+
+	now := metav1.Now()
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		// Nodes not initialized yet have no provider ID
+		instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID)
+		if err != nil {
+			continue
+		}
+
+		var srv serverWithHost
+		mc := metrics.NewMetricContext("server", "get")
+		err = servers.Get(compute, instanceID).ExtractInto(&srv)
+		if mc.ObserveRequest(err) != nil {
+			// The node lifecycle controller deletes the nodes of the deleted instances
+			if !cpoerrors.IsNotFound(err) {
+				klog.Errorf("Failed to get instance %s of node %s: %v", instanceID, node.Name, err)
+			}
+			continue
+		}
+
+		ports, err := openstackutil.GetPorts(network, neutronports.ListOpts{DeviceID: instanceID})
+		if err != nil {
+			klog.Errorf("Failed to list the ports of instance %s of node %s: %v", instanceID, node.Name, err)
+			ports = nil
+		}
+
+		expected := getNodeConditions(&srv, ports, computeServices)
+		conditions, changed := mergeNodeConditions(node.Status.Conditions, expected, now)
+		if len(changed) == 0 {
+			continue
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"status": map[string]interface{}{
+				"conditions": conditions,
+			},
+		})
+		if err != nil {
+			klog.Errorf("Failed to build condition patch for node %s: %v", node.Name, err)
+			continue
+		}
+		if _, err := os.kclient.CoreV1().Nodes().PatchStatus(ctx, node.Name, patch); err != nil {
+			klog.Errorf("Failed to set the conditions of node %s: %v", node.Name, err)
+			continue
+		}
+
+		for _, c := range changed {
+			klog.V(2).InfoS("Node condition changed", "node", node.Name, "condition", c.Type, "status", c.Status, "reason", c.Reason)
+			if c.Status == corev1.ConditionTrue && os.eventRecorder != nil {
+				os.eventRecorder.Event(node, corev1.EventTypeWarning, string(c.Type), c.Message)
+			}
+		}
+	}
+}
+
+// getComputeServices returns the nova-compute services by host.
+func getComputeServices(compute *gophercloud.ServiceClient) (map[string]services.Service, error) {
+	mc := metrics.NewMetricContext("compute_service", "list")
+	allPages, err := services.List(compute, services.ListOpts{Binary: "nova-compute"}).AllPages()
+	if mc.ObserveRequest(err) != nil {
+		return nil, fmt.Errorf("failed to list compute services: %v", err)
+	}
+	all, err := services.ExtractServices(allPages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract compute services: %v", err)
+	}
+
+	computeServices := make(map[string]services.Service, len(all))
+	for _, s := range all {
+		computeServices[s.Host] = s
+	}
+	return computeServices, nil
+}
+
+// getNodeConditions returns the conditions of the node of the instance. The
+// hypervisor condition is omitted when the host of the instance or the compute
+// services are unknown.
+func getNodeConditions(srv *serverWithHost, ports []neutronports.Port, computeServices map[string]services.Service) []corev1.NodeCondition {
+	var conditions []corev1.NodeCondition
+
+	if srv.Status == instanceError {
+		msg := fmt.Sprintf("Instance %s is in the ERROR state", srv.ID)
+		if srv.Fault.Message != "" {
+			msg = fmt.Sprintf("%s: %s", msg, srv.Fault.Message)
+		}
+		conditions = append(conditions, newNodeCondition(NodeConditionServerError, true, "ServerError", msg))
+	} else {
+		conditions = append(conditions, newNodeCondition(NodeConditionServerError, false, "ServerNotInError", fmt.Sprintf("Instance %s is %s", srv.ID, srv.Status)))
+	}
+
+	var adminDown, down []string
+	for _, p := range ports {
+		if !p.AdminStateUp {
+			adminDown = append(adminDown, p.ID)
+		} else if p.Status == "DOWN" {
+			down = append(down, p.ID)
+		}
+	}
+	switch {
+	case len(adminDown) > 0:
+		sort.Strings(adminDown)
+		conditions = append(conditions, newNodeCondition(NodeConditionNeutronPortDown, true, "PortAdminDown", fmt.Sprintf("Ports %s are administratively down", strings.Join(adminDown, ", "))))
+	case len(down) > 0:
+		sort.Strings(down)
+		conditions = append(conditions, newNodeCondition(NodeConditionNeutronPortDown, true, "PortDown", fmt.Sprintf("Ports %s are down", strings.Join(down, ", "))))
+	case len(ports) > 0:
+		conditions = append(conditions, newNodeCondition(NodeConditionNeutronPortDown, false, "PortsUp", "All ports are up"))
+	}
+
+	if s, ok := computeServices[srv.Host]; ok && srv.Host != "" {
+		switch {
+		case s.Status == "disabled":
+			msg := fmt.Sprintf("Compute service of hypervisor %s is disabled", s.Host)
+			if s.DisabledReason != "" {
+				msg = fmt.Sprintf("%s: %s", msg, s.DisabledReason)
+			}
+			conditions = append(conditions, newNodeCondition(NodeConditionHypervisorDown, true, "ComputeServiceDisabled", msg))
+		case s.State == "down" || s.ForcedDown:
+			conditions = append(conditions, newNodeCondition(NodeConditionHypervisorDown, true, "ComputeServiceDown", fmt.Sprintf("Compute service of hypervisor %s is down", s.Host)))
+		default:
+			conditions = append(conditions, newNodeCondition(NodeConditionHypervisorDown, false, "ComputeServiceUp", fmt.Sprintf("Compute service of hypervisor %s is up", s.Host)))
+		}
+	}
+
+	return conditions
+}
+
+func newNodeCondition(conditionType corev1.NodeConditionType, status bool, reason, message string) corev1.NodeCondition {
+	c := corev1.NodeCondition{Type: conditionType, Status: corev1.ConditionFalse, Reason: reason, Message: message}
+	if status {
+		c.Status = corev1.ConditionTrue
+	}
+	return c
+}
+
+// mergeNodeConditions returns the conditions of the node to patch with the
+// expected conditions, and the expected conditions which changed. The
+// conditions are only patched when one of them changed, to spare the API
+// server an update of every node at every sync.
+func mergeNodeConditions(current []corev1.NodeCondition, expected []corev1.NodeCondition, now metav1.Time) ([]corev1.NodeCondition, []corev1.NodeCondition) {
+	var conditions, changed []corev1.NodeCondition
+	for _, e := range expected {
+		e.LastHeartbeatTime = now
+		e.LastTransitionTime = now
+
+		var cur *corev1.NodeCondition
+		for i := range current {
+			if current[i].Type == e.Type {
+				cur = &current[i]
+				break
+			}
+		}
+		if cur != nil && cur.Status == e.Status {
+			e.LastTransitionTime = cur.LastTransitionTime
+		}
+		if cur == nil || cur.Status != e.Status || cur.Reason != e.Reason || cur.Message != e.Message {
+			changed = append(changed, e)
+		}
+		conditions = append(conditions, e)
+	}
+	return conditions, changed
+}
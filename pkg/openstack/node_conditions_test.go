@@ -0,0 +1,101 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/services"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func conditionStatuses(conditions []corev1.NodeCondition) map[corev1.NodeConditionType]string {
+	statuses := make(map[corev1.NodeConditionType]string)
+	for _, c := range conditions {
+		statuses[c.Type] = string(c.Status) + "/" + c.Reason
+	}
+	return statuses
+}
+
+func TestGetNodeConditions(t *testing.T) {
+	computeServices := map[string]services.Service{
+		"host1": {Host: "host1", Status: "enabled", State: "up"},
+		"host2": {Host: "host2", Status: "disabled", State: "up", DisabledReason: "maintenance"},
+		"host3": {Host: "host3", Status: "enabled", State: "down"},
+	}
+
+	srv := &serverWithHost{Server: servers.Server{ID: "srv", Status: "ACTIVE"}}
+	srv.Host = "host1"
+	ports := []neutronports.Port{{ID: "p1", AdminStateUp: true, Status: "ACTIVE"}}
+	assert.Equal(t, map[corev1.NodeConditionType]string{
+		NodeConditionServerError:     "False/ServerNotInError",
+		NodeConditionNeutronPortDown: "False/PortsUp",
+		NodeConditionHypervisorDown:  "False/ComputeServiceUp",
+	}, conditionStatuses(getNodeConditions(srv, ports, computeServices)))
+
+	srv = &serverWithHost{Server: servers.Server{ID: "srv", Status: "ERROR"}}
+	srv.Host = "host2"
+	ports = []neutronports.Port{{ID: "p1", AdminStateUp: true, Status: "DOWN"}, {ID: "p2", AdminStateUp: false}}
+	assert.Equal(t, map[corev1.NodeConditionType]string{
+		NodeConditionServerError:     "True/ServerError",
+		NodeConditionNeutronPortDown: "True/PortAdminDown",
+		NodeConditionHypervisorDown:  "True/ComputeServiceDisabled",
+	}, conditionStatuses(getNodeConditions(srv, ports, computeServices)))
+
+	srv.Host = "host3"
+	ports = []neutronports.Port{{ID: "p1", AdminStateUp: true, Status: "DOWN"}}
+	assert.Equal(t, map[corev1.NodeConditionType]string{
+		NodeConditionServerError:     "True/ServerError",
+		NodeConditionNeutronPortDown: "True/PortDown",
+		NodeConditionHypervisorDown:  "True/ComputeServiceDown",
+	}, conditionStatuses(getNodeConditions(srv, ports, computeServices)))
+
+	// The hypervisor is unknown without admin access
+	srv.Host = ""
+	assert.NotContains(t, conditionStatuses(getNodeConditions(srv, ports, nil)), NodeConditionHypervisorDown)
+}
+
+func TestMergeNodeConditions(t *testing.T) {
+	before := metav1.NewTime(time.Now().Add(-time.Hour))
+	now := metav1.Now()
+	current := []corev1.NodeCondition{
+		{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+		{Type: NodeConditionServerError, Status: corev1.ConditionFalse, Reason: "ServerNotInError", Message: "Instance srv is ACTIVE", LastTransitionTime: before},
+	}
+
+	// Unchanged conditions are not patched
+	expected := []corev1.NodeCondition{newNodeCondition(NodeConditionServerError, false, "ServerNotInError", "Instance srv is ACTIVE")}
+	conditions, changed := mergeNodeConditions(current, expected, now)
+	assert.Empty(t, changed)
+	assert.Equal(t, before, conditions[0].LastTransitionTime)
+
+	// The transition time is set when the status changes
+	expected = []corev1.NodeCondition{
+		newNodeCondition(NodeConditionServerError, true, "ServerError", "Instance srv is in the ERROR state"),
+		newNodeCondition(NodeConditionNeutronPortDown, false, "PortsUp", "All ports are up"),
+	}
+	conditions, changed = mergeNodeConditions(current, expected, now)
+	assert.Len(t, changed, 2)
+	assert.Len(t, conditions, 2)
+	assert.Equal(t, now, conditions[0].LastTransitionTime)
+	assert.Equal(t, now, conditions[0].LastHeartbeatTime)
+}
@@ -20,7 +20,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
@@ -28,10 +30,19 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/spf13/pflag"
 	gcfg "gopkg.in/gcfg.v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/keymutex"
 
 	"k8s.io/cloud-provider-openstack/pkg/client"
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
@@ -59,45 +70,84 @@ var supportedLBProvider = []string{"amphora", "octavia", "ovn"}
 // AddExtraFlags is called by the main package to add component specific command line flags
 func AddExtraFlags(fs *pflag.FlagSet) {
 	fs.StringArrayVar(&userAgentData, "user-agent", nil, "Extra data to add to gophercloud user-agent. Use multiple times to add more than one component.")
+	fs.BoolVar(&migrationMode, "migration-mode", false, "Run next to the in-tree OpenStack cloud provider, and only reconcile the nodes and Services annotated with "+AnnotationExternalCCM+": \"true\".")
+	fs.StringVar(&inventoryBindAddress, "inventory-bind-address", "", "The address to serve the inventory of the OpenStack resources owned by the cloud provider and the plan of the route changes on, e.g. 127.0.0.1:10259. They are not served if empty.")
+	fs.StringVar(&inventoryTokenFile, "inventory-token-file", "", "Path to the file of the bearer token the requests to --inventory-bind-address must be authenticated with. Required by --inventory-bind-address.")
+	client.AddTestModeFlags(fs)
 }
 
 // LoadBalancer is used for creating and maintaining load balancers
 type LoadBalancer struct {
 	secret  *gophercloud.ServiceClient
 	network *gophercloud.ServiceClient
+	// dns is the Designate client, nil unless the dns-records option is set
+	dns     *gophercloud.ServiceClient
 	compute *gophercloud.ServiceClient
 	lb      *gophercloud.ServiceClient
 	opts    LoadBalancerOpts
 	kclient kubernetes.Interface
+
+	eventRecorder record.EventRecorder
+	errorTracker  *lbErrorTracker
+	drainTracker  *memberDrainTracker
+	lbLocks       keymutex.KeyMutex
+	gc            *lbGarbageCollector
+	subnetMu      *sync.RWMutex
+	// cidrSetLister lists the CIDRSet objects, nil unless the cidr-sets option is set
+	cidrSetLister cache.GenericLister
+	// nodeNames finds the servers of the nodes
+	nodeNames *nodeNameMapper
 }
 
 // LoadBalancerOpts have the options to talk to Neutron LBaaSV2 or Octavia
 type LoadBalancerOpts struct {
-	Enabled               bool                `gcfg:"enabled"`              // if false, disables the controller
-	LBVersion             string              `gcfg:"lb-version"`           // overrides autodetection. Only support v2.
-	UseOctavia            bool                `gcfg:"use-octavia"`          // uses Octavia V2 service catalog endpoint
-	SubnetID              string              `gcfg:"subnet-id"`            // overrides autodetection.
-	NetworkID             string              `gcfg:"network-id"`           // If specified, will create virtual ip from a subnet in network which has available IP addresses
-	FloatingNetworkID     string              `gcfg:"floating-network-id"`  // If specified, will create floating ip for loadbalancer, or do not create floating ip.
-	FloatingSubnetID      string              `gcfg:"floating-subnet-id"`   // If specified, will create floating ip for loadbalancer in this particular floating pool subnetwork.
-	FloatingSubnet        string              `gcfg:"floating-subnet"`      // If specified, will create floating ip for loadbalancer in one of the matching floating pool subnetworks.
-	FloatingSubnetTags    string              `gcfg:"floating-subnet-tags"` // If specified, will create floating ip for loadbalancer in one of the matching floating pool subnetworks.
-	LBClasses             map[string]*LBClass // Predefined named Floating networks and subnets
-	LBMethod              string              `gcfg:"lb-method"` // default to ROUND_ROBIN.
-	LBProvider            string              `gcfg:"lb-provider"`
-	CreateMonitor         bool                `gcfg:"create-monitor"`
-	MonitorDelay          util.MyDuration     `gcfg:"monitor-delay"`
-	MonitorTimeout        util.MyDuration     `gcfg:"monitor-timeout"`
-	MonitorMaxRetries     uint                `gcfg:"monitor-max-retries"`
-	ManageSecurityGroups  bool                `gcfg:"manage-security-groups"`
-	NodeSecurityGroupIDs  []string            // Do not specify, get it automatically when enable manage-security-groups. TODO(FengyunPan): move it into cache
-	InternalLB            bool                `gcfg:"internal-lb"`    // default false
-	CascadeDelete         bool                `gcfg:"cascade-delete"` // applicable only if use-octavia is set to True
-	FlavorID              string              `gcfg:"flavor-id"`
-	AvailabilityZone      string              `gcfg:"availability-zone"`
-	EnableIngressHostname bool                `gcfg:"enable-ingress-hostname"` // Used with proxy protocol by adding a dns suffix to the load balancer IP address. Default false.
-	IngressHostnameSuffix string              `gcfg:"ingress-hostname-suffix"` // Used with proxy protocol by adding a dns suffix to the load balancer IP address. Default nip.io.
-	MaxSharedLB           int                 `gcfg:"max-shared-lb"`           //  Number of Services in maximum can share a single load balancer. Default 2
+	Enabled                  bool                `gcfg:"enabled"`              // if false, disables the controller
+	LBVersion                string              `gcfg:"lb-version"`           // overrides autodetection. Only support v2.
+	UseOctavia               bool                `gcfg:"use-octavia"`          // uses Octavia V2 service catalog endpoint
+	SubnetID                 string              `gcfg:"subnet-id"`            // overrides autodetection.
+	NetworkID                string              `gcfg:"network-id"`           // If specified, will create virtual ip from a subnet in network which has available IP addresses
+	FloatingNetworkID        string              `gcfg:"floating-network-id"`  // If specified, will create floating ip for loadbalancer, or do not create floating ip.
+	FloatingSubnetID         string              `gcfg:"floating-subnet-id"`   // If specified, will create floating ip for loadbalancer in this particular floating pool subnetwork.
+	FloatingSubnet           string              `gcfg:"floating-subnet"`      // If specified, will create floating ip for loadbalancer in one of the matching floating pool subnetworks.
+	FloatingSubnetTags       string              `gcfg:"floating-subnet-tags"` // If specified, will create floating ip for loadbalancer in one of the matching floating pool subnetworks.
+	LBClasses                map[string]*LBClass // Predefined named Floating networks and subnets
+	LBMethod                 string              `gcfg:"lb-method"` // default to ROUND_ROBIN.
+	LBProvider               string              `gcfg:"lb-provider"`
+	CreateMonitor            bool                `gcfg:"create-monitor"`
+	MonitorDelay             util.MyDuration     `gcfg:"monitor-delay"`
+	MonitorTimeout           util.MyDuration     `gcfg:"monitor-timeout"`
+	MonitorMaxRetries        uint                `gcfg:"monitor-max-retries"`
+	ManageSecurityGroups     bool                `gcfg:"manage-security-groups"`
+	NodeSecurityGroupIDs     []string            // Do not specify, get it automatically when enable manage-security-groups. TODO(FengyunPan): move it into cache
+	InternalLB               bool                `gcfg:"internal-lb"`    // default false
+	CascadeDelete            bool                `gcfg:"cascade-delete"` // applicable only if use-octavia is set to True
+	FlavorID                 string              `gcfg:"flavor-id"`
+	AvailabilityZone         string              `gcfg:"availability-zone"`
+	EnableIngressHostname    bool                `gcfg:"enable-ingress-hostname"`     // Used with proxy protocol by adding a dns suffix to the load balancer IP address. Default false.
+	IngressHostnameSuffix    string              `gcfg:"ingress-hostname-suffix"`     // Used with proxy protocol by adding a dns suffix to the load balancer IP address. Default nip.io.
+	MaxSharedLB              int                 `gcfg:"max-shared-lb"`               //  Number of Services in maximum can share a single load balancer. Default 2
+	VIPIPv6SubnetID          string              `gcfg:"vip-ipv6-subnet-id"`          // If specified, the VIP is allocated from this IPv6 subnet while members keep using IPv4 addresses.
+	RecreateOnErrorThreshold int                 `gcfg:"recreate-on-error-threshold"` // If positive, recreate a load balancer found in ERROR status by this number of consecutive reconciles. Default 0 (disabled)
+	TimeoutPresets           bool                `gcfg:"timeout-presets"`             // If true, listener timeouts default to presets depending on the listener protocol. Default false
+	TimeoutClientData        int                 `gcfg:"timeout-client-data"`         // Cluster-wide default listener timeouts in milliseconds, negative if not set
+	TimeoutMemberConnect     int                 `gcfg:"timeout-member-connect"`
+	TimeoutMemberData        int                 `gcfg:"timeout-member-data"`
+	TimeoutTCPInspect        int                 `gcfg:"timeout-tcp-inspect"`
+	StatsSyncPeriod          util.MyDuration     `gcfg:"stats-sync-period"`  // If positive, period of the collection of the Octavia listener and pool statistics. Default 0 (disabled)
+	AsyncProvisioning        bool                `gcfg:"async-provisioning"` // If true, do not wait for a new load balancer to be ACTIVE, finish the reconcile on a later pass. Default false
+	CIDRSets                 bool                `gcfg:"cidr-sets"`          // If true, Services can allow the CIDRs of CIDRSet objects. Default false
+	DNSRecords               bool                `gcfg:"dns-records"`        // If true, publish the addresses of the Services in Designate. Default false
+	DNSRecordTTL             int                 `gcfg:"dns-record-ttl"`     // TTL of the Designate recordsets in seconds, 0 for the default TTL of the zone
+	// Label selector of the nodes added as members of the load balancers. Default all the nodes
+	MemberNodeSelector string `gcfg:"member-node-selector"`
+	// If positive, the members of the cordoned nodes get a weight of 0, and the members of the removed nodes are deleted after this period. Default 0 (deleted at once)
+	MemberDrainPeriod util.MyDuration `gcfg:"member-drain-period"`
+	// If positive, period of the deletion of the load balancers, listeners, pools and floating IPs of the cluster not used by any Service. Default 0 (disabled)
+	GCPeriod util.MyDuration `gcfg:"gc-period"`
+	// If true, the orphaned load balancer resources are only logged. Default true
+	GCDryRun bool `gcfg:"gc-dry-run"`
+	// If positive, period of the publication of the status of the load balancers as conditions of the Services. Default 0 (disabled)
+	StatusSyncPeriod util.MyDuration `gcfg:"status-sync-period"`
 	// revive:disable:var-naming
 	TlsContainerRef string `gcfg:"default-tls-container-ref"` //  reference to a tls container
 	// revive:enable:var-naming
@@ -118,11 +168,70 @@ type NetworkingOpts struct {
 	IPv6SupportDisabled bool     `gcfg:"ipv6-support-disabled"`
 	PublicNetworkName   []string `gcfg:"public-network-name"`
 	InternalNetworkName []string `gcfg:"internal-network-name"`
+	// If set, label selector of the nodes whose floating IPs are reported as ExternalIP addresses. Default "" (all nodes)
+	FloatingIPNodeSelector string `gcfg:"floating-ip-node-selector"`
+}
+
+// InstancesOpts is used for Nova instances settings
+type InstancesOpts struct {
+	HostIDLabel bool `gcfg:"host-id-label"` // if true, label nodes with the Nova hostId of their instance
+	// If positive, period of the report of the OpenStack problems of the instances as node conditions. Default 0 (disabled)
+	NodeConditionsSyncPeriod util.MyDuration `gcfg:"node-conditions-sync-period"`
+	// If positive, the nodes younger than this are not deleted when their instance is not found. Default 0 (disabled)
+	NodeDeletionMinAge util.MyDuration `gcfg:"node-deletion-min-age"`
+	// If positive, the nodes are only deleted once their instance has not been found for this duration. Default 0 (disabled)
+	NodeDeletionCooldown util.MyDuration `gcfg:"node-deletion-cooldown"`
+	// If set, regular expression whose first group extracts the node name from the server name. Default "" (disabled)
+	NodeNameRegex string `gcfg:"node-name-regex"`
+	// If true, the domain of the server names is stripped from the node names. Default false
+	NodeNameStripDomain bool `gcfg:"node-name-strip-domain"`
+	// If true, the node names keep the case of the server names instead of being lowercased. Default false
+	NodeNameKeepCase bool `gcfg:"node-name-keep-case"`
+}
+
+// QuotaOpts is used for the quota usage metrics
+type QuotaOpts struct {
+	SyncPeriod util.MyDuration `gcfg:"sync-period"` // If positive, period of the collection of the quota usage of the project. Default 0 (disabled)
 }
 
 // RouterOpts is used for Neutron routes
 type RouterOpts struct {
-	RouterID string `gcfg:"router-id"` // required
+	Backend             string                   `gcfg:"backend"`   // how the routes are programmed, neutron-extraroute by default
+	RouterID            string                   `gcfg:"router-id"` // required unless discovered
+	DiscoverRouter      bool                     `gcfg:"discover-router"`
+	AdditionalRouterIDs []string                 `gcfg:"additional-router-id"`
+	SegmentRouters      map[string]*RouteSegment // Routers of the segments of routed provider networks, by segment ID
+	AuditPeriod         util.MyDuration          `gcfg:"audit-period"`
+	GCPeriod            util.MyDuration          `gcfg:"gc-period"`    // period of the removal of the orphaned routes and address pairs
+	ClusterCIDRs        []string                 `gcfg:"cluster-cidr"` // pod CIDR ranges of the cluster, the only routes and address pairs removed by the GC without tags
+	BatchWindow         util.MyDuration          `gcfg:"batch-window"`
+	CacheTTL            util.MyDuration          `gcfg:"cache-ttl"` // how long the server addresses of the routes are cached
+	// ManageAllowedAddressPairs adds the pod CIDRs to the allowed address
+	// pairs of the ports of the nodes, true by default
+	ManageAllowedAddressPairs bool `gcfg:"manage-allowed-address-pairs"`
+	// NeutronPortLookup finds the port of a next hop with a single Neutron
+	// request instead of listing the interfaces of the server with Nova
+	NeutronPortLookup bool `gcfg:"neutron-port-lookup"`
+	// RequestTimeout is the timeout of each OpenStack API call of the routes
+	RequestTimeout util.MyDuration `gcfg:"request-timeout"`
+	// PruneBlackholeRoutes removes the routes through addresses without port
+	// when the route quota of a router is exceeded
+	PruneBlackholeRoutes bool `gcfg:"prune-blackhole-routes"`
+	// TagRoutes marks the routes created for the cluster with tags of the
+	// routers or subnets holding them, and only lists the marked routes
+	TagRoutes bool `gcfg:"tag-routes"`
+	// WatchPodCIDRs creates the routes of a node as soon as its pod CIDRs
+	// are assigned, without waiting for the route controller
+	WatchPodCIDRs bool `gcfg:"watch-pod-cidrs"`
+	// RouterProjectID is the project of the routers, when it is not the
+	// project of the credentials, e.g. a router of the cloud administrators
+	// managed with admin credentials
+	RouterProjectID string `gcfg:"router-project-id"`
+}
+
+// RouteSegment defines the router of a segment of a routed provider network
+type RouteSegment struct {
+	RouterID string `gcfg:"router-id"`
 }
 
 type ServerAttributesExt struct {
@@ -138,9 +247,30 @@ type OpenStack struct {
 	routeOpts      RouterOpts
 	metadataOpts   metadata.Opts
 	networkingOpts NetworkingOpts
+	instancesOpts  InstancesOpts
+	quotaOpts      QuotaOpts
 	// InstanceID of the server where this OpenStack object is instantiated.
 	localInstanceID string
 	kclient         kubernetes.Interface
+	eventRecorder   record.EventRecorder
+	lbErrorTracker  *lbErrorTracker
+	drainTracker    *memberDrainTracker
+	lbLocks         keymutex.KeyMutex
+	lbGC            *lbGarbageCollector
+	// Neutron extensions found by the preflight checks, nil if not checked
+	netExtensions map[string]bool
+	routeCache    *routeNodeCache
+	routeTrigger  *routePodCIDRTrigger
+	routeGC       *routeGarbageCollector
+	cidrSetLister cache.GenericLister
+	// nodeDeletionGuard holds back the deletion of the nodes whose instance is momentarily not found
+	nodeDeletionGuard *nodeDeletionGuard
+	// floatingIPNodes restricts the nodes reporting their floating IPs, nil if not configured
+	floatingIPNodes *floatingIPNodeFilter
+	// nodeNames maps the server names to the node names
+	nodeNames *nodeNameMapper
+	// routerDiscovery holds the router of the nodes found by discover-router
+	routerDiscovery *routerDiscovery
 }
 
 // Config is used to read and store information from the cloud configuration file
@@ -149,8 +279,11 @@ type Config struct {
 	LoadBalancer      LoadBalancerOpts
 	LoadBalancerClass map[string]*LBClass
 	Route             RouterOpts
+	RouteSegment      map[string]*RouteSegment
 	Metadata          metadata.Opts
 	Networking        NetworkingOpts
+	Instances         InstancesOpts
+	Quota             QuotaOpts
 }
 
 func init() {
@@ -174,6 +307,148 @@ func init() {
 func (os *OpenStack) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
 	clientset := clientBuilder.ClientOrDie("cloud-controller-manager")
 	os.kclient = clientset
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: os.kclient.CoreV1().Events("")})
+	os.eventRecorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "openstack-cloud-controller-manager"})
+
+	os.preflight()
+
+	// Set once, as Routes creates a new backend on every call
+	if name, ok := routeBackendName(os.routeOpts); ok {
+		metrics.RouteBackend.WithLabelValues(name).Set(1)
+	}
+
+	if os.instancesOpts.HostIDLabel {
+		instances, ok := os.instances()
+		if !ok {
+			klog.Errorf("Unable to label nodes with %s, failed to create an OpenStack Compute client", LabelHostID)
+		} else {
+			go wait.Until(func() {
+				instances.syncHostIDLabels(context.TODO(), os.kclient)
+			}, hostIDLabelSyncPeriod, stop)
+		}
+	}
+
+	if os.instancesOpts.NodeConditionsSyncPeriod.Duration > 0 {
+		go wait.Until(os.syncNodeConditions, os.instancesOpts.NodeConditionsSyncPeriod.Duration, stop)
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.UseOctavia && os.lbOpts.StatsSyncPeriod.Duration > 0 {
+		lb, ok := os.LoadBalancer()
+		if !ok {
+			klog.Errorf("Unable to collect the load balancer statistics, failed to create the OpenStack clients")
+		} else {
+			go wait.Until(func() {
+				lb.(*LbaasV2).syncStats(context.TODO())
+			}, os.lbOpts.StatsSyncPeriod.Duration, stop)
+		}
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.UseOctavia && os.lbOpts.StatusSyncPeriod.Duration > 0 {
+		lb, ok := os.LoadBalancer()
+		if !ok {
+			klog.Errorf("Unable to sync the status of the load balancers, failed to create the OpenStack clients")
+		} else {
+			go wait.Until(func() {
+				lb.(*LbaasV2).syncStatus(context.TODO())
+			}, os.lbOpts.StatusSyncPeriod.Duration, stop)
+		}
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.UseOctavia && os.lbOpts.GCPeriod.Duration > 0 {
+		lb, ok := os.LoadBalancer()
+		if !ok {
+			klog.Errorf("Unable to collect the orphaned load balancer resources, failed to create the OpenStack clients")
+		} else {
+			go wait.Until(func() {
+				lb.(*LbaasV2).collectGarbage(context.TODO())
+			}, os.lbOpts.GCPeriod.Duration, stop)
+		}
+	}
+
+	if os.quotaOpts.SyncPeriod.Duration > 0 {
+		go wait.Until(os.syncQuotaUsage, os.quotaOpts.SyncPeriod.Duration, stop)
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(os.kclient, 0)
+	// The servers of the nodes are looked up by the provider IDs of the nodes
+	// before their names
+	os.nodeNames.nodes = informerFactory.Core().V1().Nodes().Informer().GetIndexer()
+	if os.routeOpts.CacheTTL.Duration > 0 {
+		os.routeCache = newRouteNodeCache(os.routeOpts.CacheTTL.Duration)
+		informerFactory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { os.routeCache.invalidate() },
+			DeleteFunc: func(obj interface{}) { os.routeCache.invalidate() },
+		})
+	}
+
+	if os.routeOpts.WatchPodCIDRs {
+		r, ok := os.Routes()
+		if !ok {
+			klog.Errorf("Unable to watch the pod CIDRs of the nodes, routes are not supported")
+		} else {
+			os.routeTrigger = newRoutePodCIDRTrigger(os.kclient, r.(*Routes))
+			informerFactory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				UpdateFunc: os.routeTrigger.onNodeUpdate,
+			})
+		}
+	}
+	if os.lbOpts.Enabled && os.lbOpts.UseOctavia {
+		lb, ok := os.LoadBalancer()
+		if ok && lb.(*LbaasV2).secret != nil {
+			rotator := newTLSSecretRotator(os.kclient, informerFactory.Core().V1().Services().Lister())
+			informerFactory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				UpdateFunc: rotator.onSecretUpdate,
+			})
+		}
+	}
+	if os.instancesOpts.NodeDeletionMinAge.Duration > 0 || os.floatingIPNodes != nil {
+		nodeInformer := informerFactory.Core().V1().Nodes().Informer()
+		if err := nodeInformer.AddIndexers(cache.Indexers{nodeProviderIDIndex: nodeProviderIDIndexFunc}); err != nil {
+			klog.Errorf("Unable to index the nodes by provider ID, the node deletion minimum age and the floating IP node selector are not applied: %v", err)
+		} else {
+			if os.instancesOpts.NodeDeletionMinAge.Duration > 0 {
+				os.nodeDeletionGuard.nodes = nodeInformer.GetIndexer()
+			}
+			if os.floatingIPNodes != nil {
+				os.floatingIPNodes.nodes = nodeInformer.GetIndexer()
+			}
+		}
+	}
+	if os.lbOpts.Enabled && os.lbOpts.UseOctavia && os.lbOpts.CIDRSets {
+		if err := os.watchCIDRSets(clientBuilder, informerFactory, stop); err != nil {
+			klog.Errorf("Unable to watch the CIDRSet objects: %v", err)
+		}
+	}
+	informerFactory.Start(stop)
+
+	if os.routeOpts.AuditPeriod.Duration > 0 {
+		r, ok := os.Routes()
+		if !ok {
+			klog.Errorf("Unable to audit the routes, routes are not supported")
+		} else {
+			go wait.Until(func() {
+				r.(*Routes).audit(context.TODO(), os.kclient)
+			}, os.routeOpts.AuditPeriod.Duration, stop)
+		}
+	}
+
+	if os.routeOpts.GCPeriod.Duration > 0 {
+		os.routeGC = &routeGarbageCollector{}
+		r, ok := os.Routes()
+		if !ok {
+			klog.Errorf("Unable to collect the orphaned routes, routes are not supported")
+		} else {
+			go wait.Until(func() {
+				r.(*Routes).collectGarbage(context.TODO(), os.kclient)
+			}, os.routeOpts.GCPeriod.Duration, stop)
+		}
+	}
+
+	if inventoryBindAddress != "" {
+		go os.serveInventory(inventoryBindAddress, inventoryTokenFile, stop)
+	}
 }
 
 // ReadConfig reads values from the cloud.conf
@@ -199,6 +474,12 @@ func ReadConfig(config io.Reader) (Config, error) {
 	cfg.LoadBalancer.IngressHostnameSuffix = defaultProxyHostnameSuffix
 	cfg.LoadBalancer.TlsContainerRef = ""
 	cfg.LoadBalancer.MaxSharedLB = 2
+	cfg.LoadBalancer.GCDryRun = true
+	cfg.LoadBalancer.TimeoutClientData = -1
+	cfg.LoadBalancer.TimeoutMemberConnect = -1
+	cfg.LoadBalancer.TimeoutMemberData = -1
+	cfg.LoadBalancer.TimeoutTCPInspect = -1
+	cfg.Route.ManageAllowedAddressPairs = true
 
 	err := gcfg.FatalOnly(gcfg.ReadInto(&cfg, config))
 	if err != nil {
@@ -245,6 +526,28 @@ func (c *caller) call(f func()) {
 
 // check opts for OpenStack
 func checkOpenStackOpts(openstackOpts *OpenStack) error {
+	if _, err := labels.Parse(openstackOpts.lbOpts.MemberNodeSelector); err != nil {
+		return fmt.Errorf("invalid member-node-selector %q: %v", openstackOpts.lbOpts.MemberNodeSelector, err)
+	}
+	if _, err := labels.Parse(openstackOpts.networkingOpts.FloatingIPNodeSelector); err != nil {
+		return fmt.Errorf("invalid floating-ip-node-selector %q: %v", openstackOpts.networkingOpts.FloatingIPNodeSelector, err)
+	}
+	if openstackOpts.routeOpts.GCPeriod.Duration > 0 && !openstackOpts.routeOpts.TagRoutes && len(openstackOpts.routeOpts.ClusterCIDRs) == 0 {
+		return fmt.Errorf("gc-period of the routes requires tag-routes or cluster-cidr, to only remove the routes of the cluster")
+	}
+	if openstackOpts.routeOpts.PruneBlackholeRoutes && !openstackOpts.routeOpts.TagRoutes && len(openstackOpts.routeOpts.ClusterCIDRs) == 0 {
+		return fmt.Errorf("prune-blackhole-routes requires tag-routes or cluster-cidr, to only remove the routes of the cluster")
+	}
+	for _, cidr := range openstackOpts.routeOpts.ClusterCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid cluster-cidr %q: %v", cidr, err)
+		}
+	}
+	if openstackOpts.instancesOpts.NodeNameRegex != "" {
+		if _, err := compileNodeNameRegex(openstackOpts.instancesOpts.NodeNameRegex); err != nil {
+			return fmt.Errorf("invalid node-name-regex %q: %v", openstackOpts.instancesOpts.NodeNameRegex, err)
+		}
+	}
 	return metadata.CheckMetadataSearchOrder(openstackOpts.metadataOpts.SearchOrder)
 }
 
@@ -259,6 +562,7 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 		cfg.Metadata.RequestTimeout.Duration = time.Duration(defaultTimeOut)
 	}
 	provider.HTTPClient.Timeout = cfg.Metadata.RequestTimeout.Duration
+	metadata.SetOpts(cfg.Metadata)
 
 	os := OpenStack{
 		provider: provider,
@@ -270,16 +574,27 @@ func NewOpenStack(cfg Config) (*OpenStack, error) {
 		routeOpts:      cfg.Route,
 		metadataOpts:   cfg.Metadata,
 		networkingOpts: cfg.Networking,
+		instancesOpts:  cfg.Instances,
+		quotaOpts:      cfg.Quota,
+		lbErrorTracker: newLBErrorTracker(),
+		drainTracker:   newMemberDrainTracker(),
+		lbLocks:        keymutex.NewHashed(lbLockBuckets),
+		lbGC:           newLBGarbageCollector(),
 	}
+	os.nodeDeletionGuard = newNodeDeletionGuard(os.instancesOpts)
+	os.floatingIPNodes = newFloatingIPNodeFilter(os.networkingOpts)
 
 	// ini file doesn't support maps so we are reusing top level sub sections
 	// and copy the resulting map to corresponding loadbalancer section
 	os.lbOpts.LBClasses = cfg.LoadBalancerClass
+	os.routeOpts.SegmentRouters = cfg.RouteSegment
 
 	err = checkOpenStackOpts(&os)
 	if err != nil {
 		return nil, err
 	}
+	os.nodeNames = newNodeNameMapper(os.instancesOpts)
+	os.routerDiscovery = &routerDiscovery{}
 
 	return &os, nil
 }
@@ -327,6 +642,14 @@ func (os *OpenStack) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 		klog.Warningf("Failed to create an OpenStack Secret client: %v", err)
 	}
 
+	var dns *gophercloud.ServiceClient
+	if os.lbOpts.DNSRecords {
+		dns, err = client.NewDNSV2(os.provider, os.epOpts)
+		if err != nil {
+			klog.Warningf("Failed to create an OpenStack DNS client, the DNS records of the Services are not managed: %v", err)
+		}
+	}
+
 	// LBaaS v1 is deprecated in the OpenStack Liberty release.
 	// Currently kubernetes OpenStack cloud provider just support LBaaS v2.
 	lbVersion := os.lbOpts.LBVersion
@@ -337,7 +660,23 @@ func (os *OpenStack) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 
 	klog.V(1).Info("Claiming to support LoadBalancer")
 
-	return &LbaasV2{LoadBalancer{secret, network, compute, lb, os.lbOpts, os.kclient}}, true
+	return &LbaasV2{LoadBalancer{
+		secret:        secret,
+		network:       network,
+		dns:           dns,
+		compute:       compute,
+		lb:            lb,
+		opts:          os.lbOpts,
+		kclient:       os.kclient,
+		eventRecorder: os.eventRecorder,
+		errorTracker:  os.lbErrorTracker,
+		drainTracker:  os.drainTracker,
+		lbLocks:       os.lbLocks,
+		gc:            os.lbGC,
+		subnetMu:      &sync.RWMutex{},
+		cidrSetLister: os.cidrSetLister,
+		nodeNames:     os.nodeNames,
+	}}, true
 }
 
 // Zones indicates that we support zones
@@ -399,7 +738,7 @@ func (os *OpenStack) GetZoneByNodeName(ctx context.Context, nodeName types.NodeN
 		return cloudprovider.Zone{}, err
 	}
 
-	srv, err := getServerByName(compute, nodeName)
+	srv, err := os.nodeNames.getServer(compute, nodeName)
 	if err != nil {
 		if err == errors.ErrNotFound {
 			return cloudprovider.Zone{}, cloudprovider.InstanceNotFound
@@ -425,15 +764,20 @@ func (os *OpenStack) Routes() (cloudprovider.Routes, bool) {
 		return nil, false
 	}
 
-	netExts, err := openstackutil.GetNetworkExtensions(network)
-	if err != nil {
-		klog.Warningf("Failed to list neutron extensions: %v", err)
-		return nil, false
+	netExts := os.netExtensions
+	if netExts == nil {
+		netExts, err = openstackutil.GetNetworkExtensions(network)
+		if err != nil {
+			klog.Warningf("Failed to list neutron extensions: %v", err)
+			return nil, false
+		}
 	}
 
-	if !netExts["extraroute"] {
-		klog.V(3).Info("Neutron extraroute extension not found, required for Routes support")
-		return nil, false
+	for _, ext := range routeBackendNetworkExtensions(os.routeOpts) {
+		if !netExts[ext] {
+			klog.V(3).Infof("Neutron %s extension not found, required for Routes support", ext)
+			return nil, false
+		}
 	}
 
 	compute, err := client.NewComputeV2(os.provider, os.epOpts)
@@ -442,12 +786,36 @@ func (os *OpenStack) Routes() (cloudprovider.Routes, bool) {
 		return nil, false
 	}
 
-	r, err := NewRoutes(compute, network, os.routeOpts, os.networkingOpts)
+	opts := os.routeOpts
+	if opts.DiscoverRouter && (opts.Backend == "" || opts.Backend == routeBackendExtraRoute) {
+		opts.RouterID, err = os.routerDiscovery.resolve(compute, network, os.kclient, os.nodeNames, opts, os.networkingOpts)
+		if err != nil {
+			klog.Warningf("Error initialising Routes support, failed to discover the router: %v", err)
+			return nil, false
+		}
+	}
+
+	r, err := NewRoutes(compute, network, opts, os.networkingOpts)
 	if err != nil {
 		klog.Warningf("Error initialising Routes support: %v", err)
 		return nil, false
 	}
 
+	// Without these extensions, concurrent updates of a router by other
+	// clients are detected from its revision or by reading it again
+	r.(*Routes).batcher.atomic = netExts["extraroute-atomic"]
+	r.(*Routes).batcher.verify = !netExts["revision-if-match"]
+	r.(*Routes).cache = os.routeCache
+	r.(*Routes).trigger = os.routeTrigger
+	r.(*Routes).gc = os.routeGC
+	r.(*Routes).kclient = os.kclient
+	r.(*Routes).nodeNames = os.nodeNames
+	if projectID := getProjectID(os.provider); os.routeOpts.RouterProjectID != "" && projectID != os.routeOpts.RouterProjectID {
+		// Admin credentials list the ports of all the projects
+		klog.V(3).Infof("Managing the routers of project %s from project %s", os.routeOpts.RouterProjectID, projectID)
+		r.(*Routes).projectID = projectID
+	}
+
 	klog.V(1).Info("Claiming to support Routes")
 	return r, true
 }
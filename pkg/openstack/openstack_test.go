@@ -20,6 +20,8 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -30,6 +32,9 @@ import (
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/attachinterfaces"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
 	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -1080,3 +1085,178 @@ func testConfigFromEnv(t *testing.T, cfg *Config) {
 		t.Skip("No config found in environment")
 	}
 }
+
+func TestInventoryAdd(t *testing.T) {
+	svc1 := v1.ObjectReference{Kind: "Service", Namespace: "default", Name: "svc1"}
+	svc2 := v1.ObjectReference{Kind: "Service", Namespace: "default", Name: "svc2"}
+
+	inv := &Inventory{}
+	inv.add(InventoryItem{Kind: inventoryKindLoadBalancer, ID: "lb1", Owners: []v1.ObjectReference{svc1}})
+	inv.add(InventoryItem{Kind: inventoryKindFloatingIP, ID: "lb1", Owners: []v1.ObjectReference{svc1}})
+	// A shared load balancer is listed once with all its Services
+	inv.add(InventoryItem{Kind: inventoryKindLoadBalancer, ID: "lb1", Owners: []v1.ObjectReference{svc2}})
+
+	expected := []InventoryItem{
+		{Kind: inventoryKindLoadBalancer, ID: "lb1", Owners: []v1.ObjectReference{svc1, svc2}},
+		{Kind: inventoryKindFloatingIP, ID: "lb1", Owners: []v1.ObjectReference{svc1}},
+	}
+	if !reflect.DeepEqual(inv.Items, expected) {
+		t.Errorf("unexpected inventory items, got %+v, expected %+v", inv.Items, expected)
+	}
+}
+
+func TestInventoryHandler(t *testing.T) {
+	handler := (&OpenStack{}).inventoryHandler("secret")
+	do := func(method, path, auth string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, path := range []string{InventoryPath, RoutePlanPath} {
+		if code := do(http.MethodGet, path, ""); code != http.StatusUnauthorized {
+			t.Errorf("expected %s without token to be unauthorized, got %d", path, code)
+		}
+		if code := do(http.MethodGet, path, "Bearer wrong"); code != http.StatusUnauthorized {
+			t.Errorf("expected %s with a wrong token to be unauthorized, got %d", path, code)
+		}
+		// Authenticated requests reach the handlers, which only serve GET
+		if code := do(http.MethodPost, path, "Bearer secret"); code != http.StatusMethodNotAllowed {
+			t.Errorf("expected POST on %s to be not allowed, got %d", path, code)
+		}
+	}
+}
+
+func TestIsNodeMigrated(t *testing.T) {
+	defer func() { migrationMode = false }()
+
+	legacy := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}}
+	annotated := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "annotated", Annotations: map[string]string{AnnotationExternalCCM: "true"}}}
+	uninitialized := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "uninitialized"},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{Key: "node.cloudprovider.kubernetes.io/uninitialized", Value: "true", Effect: v1.TaintEffectNoSchedule}},
+		},
+	}
+
+	tests := []struct {
+		migrationMode bool
+		node          *v1.Node
+		expected      bool
+	}{
+		{migrationMode: false, node: legacy, expected: true},
+		{migrationMode: true, node: legacy, expected: false},
+		{migrationMode: true, node: annotated, expected: true},
+		{migrationMode: true, node: uninitialized, expected: true},
+	}
+
+	for _, test := range tests {
+		migrationMode = test.migrationMode
+		if got := isNodeMigrated(test.node); got != test.expected {
+			t.Errorf("isNodeMigrated(%s) with migration mode %v: got %v, expected %v", test.node.Name, test.migrationMode, got, test.expected)
+		}
+	}
+}
+
+func TestPreflightCapabilities(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/extensions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"extensions": [{"alias": "extraroute"}, {"alias": "allowed-address-pairs"}, {"alias": "qos"}]}`)
+	})
+	th.Mux.HandleFunc("/lbaas/providers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"providers": [{"name": "amphora"}, {"name": "octavia"}]}`)
+	})
+
+	caps, err := getCapabilities(fakeclient.ServiceClient(), fakeclient.ServiceClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caps.networkExtensions["extraroute"] || caps.networkExtensions["router"] {
+		t.Errorf("unexpected Neutron extensions %v", caps.networkExtensions)
+	}
+	if !reflect.DeepEqual(caps.lbProviders, []string{"amphora", "octavia"}) {
+		t.Errorf("unexpected load balancer providers %v", caps.lbProviders)
+	}
+
+	// Without the router extension, there are no floating IPs
+	os := &OpenStack{lbOpts: LoadBalancerOpts{Enabled: true, LBProvider: "amphora"}}
+	os.applyCapabilities(caps)
+	if !os.lbOpts.InternalLB {
+		t.Errorf("expected the load balancers to be internal")
+	}
+	if os.netExtensions["router"] {
+		t.Errorf("unexpected Neutron extensions %v", os.netExtensions)
+	}
+}
+
+func TestGetQuotaUsage(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/quotas/project-a/details.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"quota": {"floatingip": {"used": 4, "reserved": 0, "limit": 10}, "port": {"used": 30, "reserved": 0, "limit": -1}}}`)
+	})
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"router": {"id": "router-a", "routes": [{"destination": "10.0.0.0/24", "nexthop": "192.168.0.1"}]}}`)
+	})
+	th.Mux.HandleFunc("/routers/router-b", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"router": {"id": "router-b", "routes": [{"destination": "10.0.1.0/24", "nexthop": "192.168.0.2"}]}}`)
+	})
+	th.Mux.HandleFunc("/quotas/project-a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"quota": {"load_balancer": 5}}`)
+	})
+	th.Mux.HandleFunc("/lbaas/loadbalancers", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("project_id") != "project-a" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"loadbalancers": [{"id": "lb-a"}, {"id": "lb-b"}]}`)
+	})
+
+	network, err := getNetworkQuotaUsage(fakeclient.ServiceClient(), "project-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network[0] != (quotaUsage{service: "neutron", resource: "floatingips", used: 4, limit: 10}) ||
+		network[1] != (quotaUsage{service: "neutron", resource: "ports", used: 30, limit: -1}) {
+		t.Errorf("unexpected Neutron quota usage %v", network)
+	}
+
+	routes, err := getRouteQuotaUsage(fakeclient.ServiceClient(), []string{"router-a", "router-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(routes, []quotaUsage{{service: "neutron", resource: "routes", used: 2, limit: quotaLimitUnknown}}) {
+		t.Errorf("unexpected route usage %v", routes)
+	}
+
+	lb, err := getLoadBalancerQuotaUsage(fakeclient.ServiceClient(), "project-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lb, []quotaUsage{{service: "octavia", resource: "loadbalancers", used: 2, limit: 5}}) {
+		t.Errorf("unexpected Octavia quota usage %v", lb)
+	}
+
+	provider := &gophercloud.ProviderClient{}
+	var auth tokens.CreateResult
+	auth.Body = map[string]interface{}{"token": map[string]interface{}{"project": map[string]interface{}{"id": "project-a"}}}
+	if err := provider.SetTokenAndAuthResult(auth); err != nil {
+		t.Fatal(err)
+	}
+	if id := getProjectID(provider); id != "project-a" {
+		t.Errorf("unexpected project %q", id)
+	}
+}
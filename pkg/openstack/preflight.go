@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/providers"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// preflightNetworkExtensions are the Neutron extensions checked at startup,
+// with the features depending on them.
+var preflightNetworkExtensions = []struct {
+	alias    string
+	features string
+}{
+	{"router", "routes, load balancer floating IPs"},
+	{"extraroute", "routes"},
+	{"extraroute-atomic", "concurrent route updates"},
+	{"revision-if-match", "concurrent route updates"},
+	{"allowed-address-pairs", "routes"},
+	{"standard-attr-tag", "routes with tag-routes, reuse of kept floating IPs"},
+	{"qos", "none"},
+	{"trunk", "none"},
+}
+
+// routesNetworkExtensions are the Neutron extensions required by the routes
+var routesNetworkExtensions = []string{"router", "extraroute", "allowed-address-pairs"}
+
+// capabilities are the features of the OpenStack cloud found at startup
+type capabilities struct {
+	networkExtensions map[string]bool
+	// nil if the providers of Octavia could not be listed
+	lbProviders []string
+}
+
+// preflight checks the Neutron extensions and the Octavia providers at
+// startup, and disables the features the cloud does not support, so they
+// are reported once instead of failing at their first use.
+func (os *OpenStack) preflight() {
+	network, err := client.NewNetworkV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Warningf("Skipping the preflight checks, failed to create an OpenStack Network client: %v", err)
+		return
+	}
+	var lb *gophercloud.ServiceClient
+	if os.lbOpts.Enabled {
+		lb, err = client.NewLoadBalancerV2(os.provider, os.epOpts, os.lbOpts.UseOctavia)
+		if err != nil {
+			klog.Warningf("Failed to create an OpenStack LoadBalancer client, skipping the load balancer preflight checks: %v", err)
+		}
+	}
+
+	caps, err := getCapabilities(network, lb)
+	if err != nil {
+		klog.Warningf("Skipping the preflight checks, failed to list the Neutron extensions: %v", err)
+		return
+	}
+	os.applyCapabilities(caps)
+}
+
+// getCapabilities lists the Neutron extensions, and the Octavia providers if
+// lb is not nil. Failing to list the providers is not an error, as they are
+// only listed by Octavia.
+func getCapabilities(network, lb *gophercloud.ServiceClient) (*capabilities, error) {
+	netExts, err := openstackutil.GetNetworkExtensions(network)
+	if err != nil {
+		return nil, err
+	}
+	caps := &capabilities{networkExtensions: netExts}
+
+	if lb != nil {
+		mc := metrics.NewMetricContext("loadbalancer_provider", "list")
+		allPages, err := providers.List(lb, providers.ListOpts{}).AllPages()
+		if mc.ObserveRequest(err) != nil {
+			klog.Warningf("Failed to list the load balancer providers: %v", err)
+			return caps, nil
+		}
+		lbProviders, err := providers.ExtractProviders(allPages)
+		if err != nil {
+			klog.Warningf("Failed to list the load balancer providers: %v", err)
+			return caps, nil
+		}
+		caps.lbProviders = []string{}
+		for _, p := range lbProviders {
+			caps.lbProviders = append(caps.lbProviders, p.Name)
+		}
+	}
+
+	return caps, nil
+}
+
+// applyCapabilities logs the capabilities, and disables the features
+// depending on a missing one.
+func (os *OpenStack) applyCapabilities(caps *capabilities) {
+	for _, ext := range preflightNetworkExtensions {
+		status := "available"
+		if !caps.networkExtensions[ext.alias] {
+			status = "missing"
+		}
+		klog.Infof("Neutron extension %q: %s, required by: %s", ext.alias, status, ext.features)
+	}
+	os.netExtensions = caps.networkExtensions
+
+	for _, ext := range routeBackendNetworkExtensions(os.routeOpts) {
+		if !caps.networkExtensions[ext] {
+			klog.Warningf("Neutron extension %q not found, routes are disabled", ext)
+			break
+		}
+	}
+
+	if os.lbOpts.Enabled && !caps.networkExtensions["router"] && !os.lbOpts.InternalLB {
+		klog.Warningf("Neutron extension \"router\" not found, floating IPs are not supported and load balancers are internal")
+		os.lbOpts.InternalLB = true
+	}
+
+	if os.lbOpts.Enabled && caps.lbProviders != nil {
+		klog.Infof("Load balancer providers: %v", caps.lbProviders)
+		if os.lbOpts.LBProvider != "" && !cpoutil.Contains(caps.lbProviders, os.lbOpts.LBProvider) {
+			klog.Warningf("Load balancer provider %q not found, load balancers cannot be created", os.lbOpts.LBProvider)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/quotasets"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	lbquotas "github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/quotas"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// quotaUsage is the usage of the quota of a resource of the project
+type quotaUsage struct {
+	service  string
+	resource string
+	used     int
+	// limit is -1 if unlimited, quotaLimitUnknown if not exposed by the API
+	limit int
+}
+
+const quotaLimitUnknown = -2
+
+// syncQuotaUsage fetches the quota usage of the project from Neutron, Nova
+// and Octavia and exposes it as metrics, so the exhaustion of a quota can be
+// alerted on before the reconciles start failing.
+func (os *OpenStack) syncQuotaUsage() {
+	projectID := getProjectID(os.provider)
+	if projectID == "" {
+		klog.Errorf("Failed to collect the quota usage, the project of the token is unknown")
+		return
+	}
+
+	var usages []quotaUsage
+	network, err := client.NewNetworkV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Warningf("Failed to create an OpenStack Network client to collect the quota usage: %v", err)
+	} else {
+		u, err := getNetworkQuotaUsage(network, projectID)
+		if err != nil {
+			klog.Warningf("Failed to get the Neutron quota usage of project %s: %v", projectID, err)
+		}
+		usages = append(usages, u...)
+
+		routerIDs := os.routeOpts.AdditionalRouterIDs
+		if os.routeOpts.RouterID != "" {
+			routerIDs = append([]string{os.routeOpts.RouterID}, routerIDs...)
+		}
+		u, err = getRouteQuotaUsage(network, routerIDs)
+		if err != nil {
+			klog.Warningf("Failed to count the routes of the routers: %v", err)
+		}
+		usages = append(usages, u...)
+	}
+
+	compute, err := client.NewComputeV2(os.provider, os.epOpts)
+	if err != nil {
+		klog.Warningf("Failed to create an OpenStack Compute client to collect the quota usage: %v", err)
+	} else {
+		u, err := getComputeQuotaUsage(compute, projectID)
+		if err != nil {
+			klog.Warningf("Failed to get the Nova quota usage of project %s: %v", projectID, err)
+		}
+		usages = append(usages, u...)
+	}
+
+	if os.lbOpts.Enabled && os.lbOpts.UseOctavia {
+		lb, err := client.NewLoadBalancerV2(os.provider, os.epOpts, os.lbOpts.UseOctavia)
+		if err != nil {
+			klog.Warningf("Failed to create an OpenStack LoadBalancer client to collect the quota usage: %v", err)
+		} else {
+			u, err := getLoadBalancerQuotaUsage(lb, projectID)
+			if err != nil {
+				klog.Warningf("Failed to get the Octavia quota usage of project %s: %v", projectID, err)
+			}
+			usages = append(usages, u...)
+		}
+	}
+
+	metrics.ResetQuotaUsage()
+	for _, u := range usages {
+		metrics.QuotaUsed.WithLabelValues(u.service, u.resource).Set(float64(u.used))
+		if u.limit != quotaLimitUnknown {
+			metrics.QuotaLimit.WithLabelValues(u.service, u.resource).Set(float64(u.limit))
+		}
+	}
+}
+
+// getProjectID returns the ID of the project the token of the provider is
+// scoped to, empty if unknown.
+func getProjectID(provider *gophercloud.ProviderClient) string {
+	var project *tokens.Project
+	var err error
+	switch r := provider.GetAuthResult().(type) {
+	case tokens.CreateResult:
+		project, err = r.ExtractProject()
+	case tokens.GetResult:
+		project, err = r.ExtractProject()
+	}
+	if err != nil || project == nil {
+		return ""
+	}
+	return project.ID
+}
+
+// getNetworkQuotaUsage returns the Neutron quota usage of the project.
+func getNetworkQuotaUsage(network *gophercloud.ServiceClient, projectID string) ([]quotaUsage, error) {
+	mc := metrics.NewMetricContext("network_quota", "get")
+	q, err := quotas.GetDetail(network, projectID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	usage := func(resource string, d quotas.QuotaDetail) quotaUsage {
+		return quotaUsage{service: "neutron", resource: resource, used: d.Used, limit: d.Limit}
+	}
+	return []quotaUsage{
+		usage("floatingips", q.FloatingIP),
+		usage("ports", q.Port),
+		usage("routers", q.Router),
+		usage("security_groups", q.SecurityGroup),
+		usage("security_group_rules", q.SecurityGroupRule),
+	}, nil
+}
+
+// getRouteQuotaUsage returns the number of routes of the routers. Neutron
+// limits the routes of each router with its max_routes option, which is not
+// exposed by the API, so the limit is unknown.
+func getRouteQuotaUsage(network *gophercloud.ServiceClient, routerIDs []string) ([]quotaUsage, error) {
+	if len(routerIDs) == 0 {
+		return nil, nil
+	}
+
+	count := 0
+	for _, routerID := range routerIDs {
+		mc := metrics.NewMetricContext("router", "get")
+		router, err := routers.Get(network, routerID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			return nil, err
+		}
+		count += len(router.Routes)
+	}
+	return []quotaUsage{{service: "neutron", resource: "routes", used: count, limit: quotaLimitUnknown}}, nil
+}
+
+// getComputeQuotaUsage returns the Nova quota usage of the project.
+func getComputeQuotaUsage(compute *gophercloud.ServiceClient, projectID string) ([]quotaUsage, error) {
+	mc := metrics.NewMetricContext("compute_quota", "get")
+	q, err := quotasets.GetDetail(compute, projectID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	usage := func(resource string, d quotasets.QuotaDetail) quotaUsage {
+		return quotaUsage{service: "nova", resource: resource, used: d.InUse, limit: d.Limit}
+	}
+	return []quotaUsage{
+		usage("instances", q.Instances),
+		usage("cores", q.Cores),
+		usage("ram", q.RAM),
+	}, nil
+}
+
+// getLoadBalancerQuotaUsage returns the Octavia quota usage of the project.
+// Octavia only reports the limits, the load balancers of the project are
+// counted.
+func getLoadBalancerQuotaUsage(lb *gophercloud.ServiceClient, projectID string) ([]quotaUsage, error) {
+	mc := metrics.NewMetricContext("loadbalancer_quota", "get")
+	q, err := lbquotas.Get(lb, projectID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	lbs, err := openstackutil.GetLoadBalancers(lb, loadbalancers.ListOpts{ProjectID: projectID})
+	if err != nil {
+		return nil, err
+	}
+	return []quotaUsage{{service: "octavia", resource: "loadbalancers", used: len(lbs), limit: q.Loadbalancer}}, nil
+}
@@ -18,18 +18,28 @@ package openstack
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
 	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	"github.com/gophercloud/gophercloud/pagination"
 
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
 	"k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
 	"k8s.io/klog/v2"
 )
 
@@ -39,60 +49,112 @@ type Routes struct {
 	network        *gophercloud.ServiceClient
 	opts           RouterOpts
 	networkingOpts NetworkingOpts
+	batcher        *routeBatcher
+	backend        routeBackend
+	// Cache of the server addresses, nil if disabled
+	cache *routeNodeCache
+	// Trigger of the routes of the nodes assigned pod CIDRs, nil if disabled
+	trigger *routePodCIDRTrigger
+	// Collector of the orphaned routes, nil if disabled
+	gc *routeGarbageCollector
+	// kclient gets the next hop annotation of the nodes, nil if unknown
+	kclient kubernetes.Interface
+	// nodeNames finds the servers of the nodes
+	nodeNames *nodeNameMapper
+	// ctx cancels the route changes, see withContext
+	ctx context.Context
+	// projectID scopes the lookups of the ports of the nodes, set when the
+	// routers belong to another project
+	projectID string
 }
 
 var _ cloudprovider.Routes = &Routes{}
 
 // NewRoutes creates a new instance of Routes
 func NewRoutes(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, opts RouterOpts, networkingOpts NetworkingOpts) (cloudprovider.Routes, error) {
-	if opts.RouterID == "" {
+	usesRouters := opts.Backend == "" || opts.Backend == routeBackendExtraRoute
+	if usesRouters && opts.RouterID == "" {
 		return nil, errors.ErrNoRouterID
 	}
 
-	return &Routes{
+	r := &Routes{
 		compute:        compute,
 		network:        network,
 		opts:           opts,
 		networkingOpts: networkingOpts,
-	}, nil
+		// The batched updates of a router are not cancelled with the
+		// context of one of the changes
+		batcher: newRouteBatcher(openstackutil.WithContext(context.Background(), network, opts.RequestTimeout.Duration), opts.BatchWindow.Duration),
+		ctx:     context.Background(),
+	}
+	if usesRouters && opts.RouterProjectID != "" {
+		if err := r.checkRouterProjects(); err != nil {
+			return nil, err
+		}
+	}
+	backend, err := newRouteBackend(r)
+	if err != nil {
+		return nil, err
+	}
+	r.backend = backend
+	klog.V(3).Infof("Using route backend %s", backend.name())
+
+	return r, nil
+}
+
+// withContext returns a copy of the Routes whose OpenStack calls are
+// cancelled with ctx, e.g. when the route controller gives up on a
+// reconciliation, and each time out after the request-timeout option.
+func (r *Routes) withContext(ctx context.Context) *Routes {
+	rc := *r
+	rc.compute = openstackutil.WithContext(ctx, r.compute, r.opts.RequestTimeout.Duration)
+	rc.network = openstackutil.WithContext(ctx, r.network, r.opts.RequestTimeout.Duration)
+	rc.backend = r.backend.bind(&rc)
+	rc.ctx = ctx
+	return &rc
 }
 
 // ListRoutes lists all managed routes that belong to the specified clusterName
 func (r *Routes) ListRoutes(ctx context.Context, clusterName string) ([]*cloudprovider.Route, error) {
-	klog.V(4).Infof("ListRoutes(%v)", clusterName)
+	mc := metrics.NewMetricContext("route", "list")
+	routes, err := r.listRoutes(ctx, clusterName)
+	if err != nil {
+		return nil, mc.ObserveReconcile(err)
+	}
 
-	nodeNamesByAddr := make(map[string]types.NodeName)
-	err := foreachServer(r.compute, servers.ListOpts{}, func(srv *servers.Server) (bool, error) {
-		interfaces, err := getAttachedInterfacesByID(r.compute, srv.ID)
-		if err != nil {
-			return false, err
-		}
-
-		addrs, err := nodeAddresses(srv, interfaces, r.networkingOpts)
-		if err != nil {
-			return false, err
+	blackholes := 0
+	for _, route := range routes {
+		if route.Blackhole {
+			blackholes++
 		}
+	}
+	metrics.RouteBlackholes.Set(float64(blackholes))
+	return routes, mc.ObserveReconcile(nil)
+}
 
-		name := mapServerToNodeName(srv)
-		for _, addr := range addrs {
-			nodeNamesByAddr[addr.Address] = name
-		}
+func (r *Routes) listRoutes(ctx context.Context, clusterName string) ([]*cloudprovider.Route, error) {
+	klog.V(4).Infof("ListRoutes(%v)", clusterName)
+	r = r.withContext(ctx)
+	r.trigger.setClusterName(clusterName)
+	r.gc.setClusterName(clusterName)
 
-		return true, nil
-	})
+	sa, err := r.getServerAddresses()
 	if err != nil {
 		return nil, err
 	}
 
-	mc := metrics.NewMetricContext("router", "get")
-	router, err := routers.Get(r.network, r.opts.RouterID).Extract()
-	if mc.ObserveRequest(err) != nil {
+	items, tags, err := r.listRoutesAndTags()
+	if err != nil {
 		return nil, err
 	}
 
 	var routes []*cloudprovider.Route
-	for _, item := range router.Routes {
-		nodeName, foundNode := nodeNamesByAddr[item.NextHop]
+	for _, item := range items {
+		if tags != nil && !tags.Has(routeTag(clusterName, item)) {
+			// Not created for the cluster, e.g. a static route of the operators
+			continue
+		}
+		nodeName, foundNode := sa.nodeNames[item.NextHop]
 		if !foundNode {
 			nodeName = types.NodeName(item.NextHop)
 		}
@@ -108,6 +170,189 @@ func (r *Routes) ListRoutes(ctx context.Context, clusterName string) ([]*cloudpr
 	return routes, nil
 }
 
+// routerIDs returns the IDs of the router, of the additional routers and of
+// the segment routers, without duplicates.
+func (r *Routes) routerIDs() []string {
+	ids := []string{r.opts.RouterID}
+	for _, id := range r.opts.AdditionalRouterIDs {
+		if id != "" && !cpoutil.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	segments := make([]string, 0, len(r.opts.SegmentRouters))
+	for segmentID := range r.opts.SegmentRouters {
+		segments = append(segments, segmentID)
+	}
+	sort.Strings(segments)
+
+	for _, segmentID := range segments {
+		seg := r.opts.SegmentRouters[segmentID]
+		if seg != nil && seg.RouterID != "" && !cpoutil.Contains(ids, seg.RouterID) {
+			ids = append(ids, seg.RouterID)
+		}
+	}
+	return ids
+}
+
+// routerDiscovery is the router discovered by the discover-router option,
+// resolved once for all the Routes of the provider.
+type routerDiscovery struct {
+	mu       sync.Mutex
+	routerID string
+}
+
+// resolve returns the router of the subnets of the nodes, discovered on the
+// first call. The router of router-id is returned if the nodes are attached
+// to several routers, and is not kept if no router is discovered, e.g.
+// before the nodes are registered.
+func (d *routerDiscovery) resolve(compute, network *gophercloud.ServiceClient, kclient kubernetes.Interface, nodeNames *nodeNameMapper, opts RouterOpts, networkingOpts NetworkingOpts) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.routerID != "" {
+		return d.routerID, nil
+	}
+
+	if kclient == nil {
+		return "", fmt.Errorf("the nodes are not known yet")
+	}
+	nodes, err := kclient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list the nodes: %v", err)
+	}
+	routerIDs, err := discoverRouterIDs(compute, network, nodeNames, nodes.Items, networkingOpts, opts.RouterProjectID)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case len(routerIDs) == 1:
+		if opts.RouterID != "" && opts.RouterID != routerIDs[0] {
+			klog.Warningf("Using discovered router %s instead of router-id %s", routerIDs[0], opts.RouterID)
+		}
+		d.routerID = routerIDs[0]
+	case len(routerIDs) > 1 && opts.RouterID != "":
+		klog.V(3).Infof("Using router-id %s, the nodes are attached to routers %v", opts.RouterID, routerIDs)
+		d.routerID = opts.RouterID
+	case len(routerIDs) > 1:
+		return "", fmt.Errorf("the nodes are attached to several routers %v, set router-id", routerIDs)
+	default:
+		return opts.RouterID, nil
+	}
+	klog.V(3).Infof("Discovered router %s of the nodes", d.routerID)
+	return d.routerID, nil
+}
+
+// discoverRouterIDs returns the routers with an interface on the subnets of
+// the internal addresses of the servers of the nodes, only those of the
+// project if set.
+func discoverRouterIDs(compute *gophercloud.ServiceClient, network *gophercloud.ServiceClient, nodeNames *nodeNameMapper, nodes []v1.Node, networkingOpts NetworkingOpts, projectID string) ([]string, error) {
+	subnetIDs := make(map[string]bool)
+	for i := range nodes {
+		srv, err := getNodeServer(compute, nodeNames, &nodes[i])
+		if err != nil {
+			if errors.IsNotFound(err) {
+				klog.V(4).Infof("Ignoring node %s without server to discover the router", nodes[i].Name)
+				continue
+			}
+			return nil, err
+		}
+
+		interfaces, err := getAttachedInterfacesByID(compute, srv.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := nodeAddresses(srv, interfaces, networkingOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if addr.Type != v1.NodeInternalIP {
+				continue
+			}
+			for _, intf := range interfaces {
+				for _, fixedIP := range intf.FixedIPs {
+					if fixedIP.IPAddress == addr.Address {
+						subnetIDs[fixedIP.SubnetID] = true
+					}
+				}
+			}
+		}
+	}
+
+	var routerIDs []string
+	for subnetID := range subnetIDs {
+		ports, err := openstackutil.GetPorts(network, neutronports.ListOpts{FixedIPs: []neutronports.FixedIPOpts{{SubnetID: subnetID}}, ProjectID: projectID})
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range ports {
+			if isRouterInterface(port.DeviceOwner) && !cpoutil.Contains(routerIDs, port.DeviceID) {
+				routerIDs = append(routerIDs, port.DeviceID)
+			}
+		}
+	}
+	sort.Strings(routerIDs)
+
+	return routerIDs, nil
+}
+
+// getNodeServer returns the server of the node, found by its provider ID if
+// set, else by its name.
+func getNodeServer(compute *gophercloud.ServiceClient, nodeNames *nodeNameMapper, node *v1.Node) (*servers.Server, error) {
+	if instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID); err == nil {
+		mc := metrics.NewMetricContext("server", "get")
+		srv, err := servers.Get(compute, instanceID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			if errors.IsNotFound(err) {
+				return nil, errors.ErrNotFound
+			}
+			return nil, err
+		}
+		return srv, nil
+	}
+	srv, err := nodeNames.getServer(compute, types.NodeName(node.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &srv.Server, nil
+}
+
+// checkRouterProjects checks that the routers belong to the project of the
+// router-project-id option. The routers of another project than the one of
+// the credentials are only found with admin credentials.
+func (r *Routes) checkRouterProjects() error {
+	for _, routerID := range r.routerIDs() {
+		mc := metrics.NewMetricContext("router", "get")
+		router, err := routers.Get(r.network, routerID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			if errors.IsNotFound(err) {
+				return fmt.Errorf("router %s not found, managing the routers of project %s requires admin credentials", routerID, r.opts.RouterProjectID)
+			}
+			return fmt.Errorf("failed to get router %s: %v", routerID, err)
+		}
+		if router.ProjectID != r.opts.RouterProjectID {
+			return fmt.Errorf("router %s belongs to project %s, not to router-project-id %s", routerID, router.ProjectID, r.opts.RouterProjectID)
+		}
+	}
+	return nil
+}
+
+// addressPortsOpts returns the options listing the ports of the nodes with
+// the address. Admin credentials list the ports of all the projects, which
+// may have the same address on other networks.
+func (r *Routes) addressPortsOpts(addr string) neutronports.ListOpts {
+	return neutronports.ListOpts{FixedIPs: []neutronports.FixedIPOpts{{IPAddress: addr}}, ProjectID: r.projectID}
+}
+
+// isRouterInterface reports whether a port with the device owner is the
+// interface of a router on a subnet, rather than its external gateway.
+func isRouterInterface(deviceOwner string) bool {
+	return strings.HasPrefix(deviceOwner, "network:router_interface") || deviceOwner == "network:ha_router_replicated_interface"
+}
+
 func foreachServer(client *gophercloud.ServiceClient, opts servers.ListOptsBuilder, handler func(*servers.Server) (bool, error)) error {
 	mc := metrics.NewMetricContext("server", "list")
 	pager := servers.List(client, opts)
@@ -128,24 +373,17 @@ func foreachServer(client *gophercloud.ServiceClient, opts servers.ListOptsBuild
 	return mc.ObserveRequest(err)
 }
 
-func updateRoutes(network *gophercloud.ServiceClient, router *routers.Router, newRoutes []routers.Route) (func(), error) {
-	origRoutes := router.Routes // shallow copy
-
-	mc := metrics.NewMetricContext("router", "update")
-	_, err := routers.Update(network, router.ID, routers.UpdateOpts{
-		Routes: &newRoutes,
-	}).Extract()
-	if mc.ObserveRequest(err) != nil {
+// updateRoutes adds the route to or removes it from the router. It returns
+// a function reverting the change, nil if the router was left unchanged.
+func (r *Routes) updateRoutes(routerID string, route routers.Route, remove bool) (func(), error) {
+	unchanged, err := r.batcher.apply(r.ctx, routerID, route, remove)
+	if err != nil || unchanged {
 		return nil, err
 	}
 
 	unwinder := func() {
-		klog.V(4).Infof("Reverting routes change to router %v", router.ID)
-		mc := metrics.NewMetricContext("router", "update")
-		_, err := routers.Update(network, router.ID, routers.UpdateOpts{
-			Routes: &origRoutes,
-		}).Extract()
-		if mc.ObserveRequest(err) != nil {
+		klog.V(4).Infof("Reverting routes change to router %v", routerID)
+		if _, err := r.batcher.apply(r.ctx, routerID, route, !remove); err != nil {
 			klog.Warningf("Unable to reset routes during error unwind: %v", err)
 		}
 	}
@@ -153,24 +391,28 @@ func updateRoutes(network *gophercloud.ServiceClient, router *routers.Router, ne
 	return unwinder, nil
 }
 
-func updateAllowedAddressPairs(network *gophercloud.ServiceClient, port *neutronports.Port, newPairs []neutronports.AddressPair) (func(), error) {
-	origPairs := port.AllowedAddressPairs // shallow copy
+// managesAddressPairs reports whether the pod CIDRs are added to the allowed
+// address pairs of the ports of their next hops. They are not when disabled
+// by the manage-allowed-address-pairs option, e.g. on ports without port
+// security, or when the backend does not need them.
+func (r *Routes) managesAddressPairs() bool {
+	return r.opts.ManageAllowedAddressPairs && r.backend.managesAddressPairs()
+}
 
-	mc := metrics.NewMetricContext("port", "update")
-	_, err := neutronports.Update(network, port.ID, neutronports.UpdateOpts{
-		AllowedAddressPairs: &newPairs,
-	}).Extract()
-	if mc.ObserveRequest(err) != nil {
+// updateAllowedAddressPair adds the CIDR to or removes it from the allowed
+// address pairs of the port. The port is updated conditionally on its
+// revision, so the address pairs of the IPv4 and IPv6 pod CIDRs of a
+// dual-stack node can be updated concurrently. It returns a function
+// reverting the change, nil if the port was left unchanged.
+func (r *Routes) updateAllowedAddressPair(portID string, cidr string, remove bool) (func(), error) {
+	changed, err := updateAllowedAddressPair(r.network, portID, cidr, remove)
+	if err != nil || !changed {
 		return nil, err
 	}
 
 	unwinder := func() {
-		klog.V(4).Infof("Reverting allowed-address-pairs change to port %v", port.ID)
-		mc := metrics.NewMetricContext("port", "update")
-		_, err := neutronports.Update(network, port.ID, neutronports.UpdateOpts{
-			AllowedAddressPairs: &origPairs,
-		}).Extract()
-		if mc.ObserveRequest(err) != nil {
+		klog.V(4).Infof("Reverting allowed-address-pairs change to port %v", portID)
+		if _, err := updateAllowedAddressPair(r.network, portID, cidr, !remove); err != nil {
 			klog.Warningf("Unable to reset allowed-address-pairs during error unwind: %v", err)
 		}
 	}
@@ -178,15 +420,82 @@ func updateAllowedAddressPairs(network *gophercloud.ServiceClient, port *neutron
 	return unwinder, nil
 }
 
+func updateAllowedAddressPair(network *gophercloud.ServiceClient, portID string, cidr string, remove bool) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		port, revision, err := getPortRevision(network, portID)
+		if err != nil {
+			return false, err
+		}
+
+		index := -1
+		for i, item := range port.AllowedAddressPairs {
+			if item.IPAddress == cidr {
+				index = i
+				break
+			}
+		}
+		if remove == (index == -1) {
+			return false, nil
+		}
+
+		pairs := make([]neutronports.AddressPair, 0, len(port.AllowedAddressPairs)+1)
+		pairs = append(pairs, port.AllowedAddressPairs...)
+		if remove {
+			pairs = append(pairs[:index], pairs[index+1:]...)
+		} else {
+			pairs = append(pairs, neutronports.AddressPair{IPAddress: cidr})
+		}
+
+		opts := neutronports.UpdateOpts{AllowedAddressPairs: &pairs}
+		body, err := opts.ToPortUpdateMap()
+		if err != nil {
+			return false, err
+		}
+		mc := metrics.NewMetricContext("port", "update")
+		err = mc.ObserveRequest(putIfMatch(network, network.ServiceURL("ports", portID), body, revision))
+		if err == nil {
+			return true, nil
+		}
+		if !isPreconditionFailed(err) || attempt >= maxRouteUpdateConflicts {
+			return false, err
+		}
+		klog.V(4).Infof("Port %s was updated concurrently, updating its allowed address pairs again", portID)
+	}
+}
+
+// getPortRevision gets the port and its revision number.
+func getPortRevision(network *gophercloud.ServiceClient, portID string) (*neutronports.Port, int, error) {
+	var p struct {
+		neutronports.Port
+		RevisionNumber int `json:"revision_number"`
+	}
+
+	mc := metrics.NewMetricContext("port", "get")
+	err := neutronports.Get(network, portID).ExtractIntoStructPtr(&p, "port")
+	if mc.ObserveRequest(err) != nil {
+		return nil, 0, err
+	}
+
+	return &p.Port, p.RevisionNumber, nil
+}
+
 // CreateRoute creates the described managed route
 func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
+	mc := metrics.NewMetricContext("route", "create")
+	err := r.createRoute(ctx, clusterName, nameHint, route)
+	metrics.ObserveRouteChange(metrics.RouteOperationCreate, err)
+	return mc.ObserveReconcile(err)
+}
+
+func (r *Routes) createRoute(ctx context.Context, clusterName string, nameHint string, route *cloudprovider.Route) error {
 	klog.V(4).Infof("CreateRoute(%v, %v, %v)", clusterName, nameHint, route)
+	r = r.withContext(ctx)
 
 	onFailure := newCaller()
 
 	ip, _, _ := net.ParseCIDR(route.DestinationCIDR)
 	isCIDRv6 := ip.To4() == nil
-	addr, err := getAddressByName(r.compute, route.TargetNode, isCIDRv6, r.networkingOpts)
+	addr, err := r.getNextHop(route.TargetNode, isCIDRv6)
 
 	if err != nil {
 		return err
@@ -194,60 +503,56 @@ func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint s
 
 	klog.V(4).Infof("Using nexthop %v for node %v", addr, route.TargetNode)
 
-	mc := metrics.NewMetricContext("router", "get")
-	router, err := routers.Get(r.network, r.opts.RouterID).Extract()
-	if mc.ObserveRequest(err) != nil {
+	// get the port of addr on target node.
+	port, err := r.getPortByIP(route.TargetNode, addr)
+	if err != nil {
 		return err
 	}
 
-	routes := router.Routes
-
-	for _, item := range routes {
-		if item.DestinationCIDR == route.DestinationCIDR && item.NextHop == addr {
-			klog.V(4).Infof("Skipping existing route: %v", route)
-			return nil
-		}
-	}
-
-	routes = append(routes, routers.Route{
+	newRoute := routers.Route{
 		DestinationCIDR: route.DestinationCIDR,
 		NextHop:         addr,
-	})
-
-	unwind, err := updateRoutes(r.network, router, routes)
-	if err != nil {
-		return err
 	}
-	defer onFailure.call(unwind)
-
-	// get the port of addr on target node.
-	portID, err := getPortIDByIP(r.compute, route.TargetNode, addr)
-	if err != nil {
-		return err
+	unwind, err := r.backend.addRoute(newRoute, port)
+	if isRouteQuotaExceeded(err) && r.opts.PruneBlackholeRoutes {
+		pruned, perr := r.pruneBlackholeRoutes(clusterName)
+		if perr != nil {
+			klog.Warningf("Failed to remove the blackhole routes to free the route quota: %v", perr)
+		}
+		if pruned > 0 {
+			unwind, err = r.backend.addRoute(newRoute, port)
+		}
 	}
-	port, err := getPortByID(r.network, portID)
 	if err != nil {
 		return err
 	}
-
-	found := false
-	for _, item := range port.AllowedAddressPairs {
-		if item.IPAddress == route.DestinationCIDR {
-			klog.V(4).Infof("Found existing allowed-address-pair: %v", item)
-			found = true
-			break
-		}
+	if unwind != nil {
+		defer onFailure.call(unwind)
+	} else {
+		// The address pair may still be missing, e.g. after a failed update
+		klog.V(4).Infof("Skipping existing route: %v", route)
 	}
-
-	if !found {
-		newPairs := append(port.AllowedAddressPairs, neutronports.AddressPair{
-			IPAddress: route.DestinationCIDR,
-		})
-		unwind, err := updateAllowedAddressPairs(r.network, port, newPairs)
-		if err != nil {
+	if r.tagsRoutes() {
+		// An existing route is adopted, e.g. when tag-routes is enabled
+		if err := r.tagRoute(clusterName, newRoute, false); err != nil {
 			return err
 		}
+	}
+
+	if !r.managesAddressPairs() {
+		klog.V(4).Infof("Route created: %v", route)
+		onFailure.disarm()
+		return nil
+	}
+
+	unwind, err = r.updateAllowedAddressPair(port.ID, route.DestinationCIDR, false)
+	if err != nil {
+		return err
+	}
+	if unwind != nil {
 		defer onFailure.call(unwind)
+	} else {
+		klog.V(4).Infof("Found existing allowed-address-pair: %v", route.DestinationCIDR)
 	}
 
 	klog.V(4).Infof("Route created: %v", route)
@@ -257,7 +562,15 @@ func (r *Routes) CreateRoute(ctx context.Context, clusterName string, nameHint s
 
 // DeleteRoute deletes the specified managed route
 func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) error {
+	mc := metrics.NewMetricContext("route", "delete")
+	err := r.deleteRoute(ctx, clusterName, route)
+	metrics.ObserveRouteChange(metrics.RouteOperationDelete, err)
+	return mc.ObserveReconcile(err)
+}
+
+func (r *Routes) deleteRoute(ctx context.Context, clusterName string, route *cloudprovider.Route) error {
 	klog.V(4).Infof("DeleteRoute(%v, %v)", clusterName, route)
+	r = r.withContext(ctx)
 
 	onFailure := newCaller()
 
@@ -268,71 +581,50 @@ func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *clo
 	// Blackhole routes are orphaned and have no counterpart in OpenStack
 	if !route.Blackhole {
 		var err error
-		addr, err = getAddressByName(r.compute, route.TargetNode, isCIDRv6, r.networkingOpts)
+		addr, err = r.getNextHop(route.TargetNode, isCIDRv6)
 		if err != nil {
 			return err
 		}
 	}
 
-	mc := metrics.NewMetricContext("router", "get")
-	router, err := routers.Get(r.network, r.opts.RouterID).Extract()
-	if mc.ObserveRequest(err) != nil {
-		return err
+	nextHop := addr
+	if route.Blackhole {
+		nextHop = string(route.TargetNode)
 	}
-
-	routes := router.Routes
-	index := -1
-	for i, item := range routes {
-		if item.DestinationCIDR == route.DestinationCIDR && (item.NextHop == addr || route.Blackhole && item.NextHop == string(route.TargetNode)) {
-			index = i
-			break
-		}
+	unwind, err := r.backend.removeRoute(routers.Route{DestinationCIDR: route.DestinationCIDR, NextHop: nextHop})
+	if err != nil {
+		return err
 	}
-
-	if index == -1 {
+	if unwind == nil {
 		klog.V(4).Infof("Skipping non-existent route: %v", route)
 		return nil
 	}
+	defer onFailure.call(unwind)
 
-	// Delete element `index`
-	routes[index] = routes[len(routes)-1]
-	routes = routes[:len(routes)-1]
+	if r.tagsRoutes() {
+		if err := r.tagRoute(clusterName, routers.Route{DestinationCIDR: route.DestinationCIDR, NextHop: nextHop}, true); err != nil {
+			return err
+		}
+	}
 
-	unwind, err := updateRoutes(r.network, router, routes)
 	// If this was a blackhole route we are done, there are no ports to update
-	if err != nil || route.Blackhole {
-		return err
+	if route.Blackhole || !r.managesAddressPairs() {
+		klog.V(4).Infof("Route deleted: %v", route)
+		onFailure.disarm()
+		return nil
 	}
-	defer onFailure.call(unwind)
 
 	// get the port of addr on target node.
-	portID, err := getPortIDByIP(r.compute, route.TargetNode, addr)
+	port, err := r.getPortByIP(route.TargetNode, addr)
 	if err != nil {
 		return err
 	}
-	port, err := getPortByID(r.network, portID)
+
+	unwind, err = r.updateAllowedAddressPair(port.ID, route.DestinationCIDR, true)
 	if err != nil {
 		return err
 	}
-
-	addrPairs := port.AllowedAddressPairs
-	index = -1
-	for i, item := range addrPairs {
-		if item.IPAddress == route.DestinationCIDR {
-			index = i
-			break
-		}
-	}
-
-	if index != -1 {
-		// Delete element `index`
-		addrPairs[index] = addrPairs[len(addrPairs)-1]
-		addrPairs = addrPairs[:len(addrPairs)-1]
-
-		unwind, err := updateAllowedAddressPairs(r.network, port, addrPairs)
-		if err != nil {
-			return err
-		}
+	if unwind != nil {
 		defer onFailure.call(unwind)
 	}
 
@@ -341,8 +633,8 @@ func (r *Routes) DeleteRoute(ctx context.Context, clusterName string, route *clo
 	return nil
 }
 
-func getPortIDByIP(compute *gophercloud.ServiceClient, targetNode types.NodeName, ipAddress string) (string, error) {
-	srv, err := getServerByName(compute, targetNode)
+func getPortIDByIP(compute *gophercloud.ServiceClient, nodeNames *nodeNameMapper, targetNode types.NodeName, ipAddress string) (string, error) {
+	srv, err := nodeNames.getServer(compute, targetNode)
 	if err != nil {
 		return "", err
 	}
@@ -363,6 +655,29 @@ func getPortIDByIP(compute *gophercloud.ServiceClient, targetNode types.NodeName
 	return "", errors.ErrNotFound
 }
 
+// getPortByIPFromNeutron returns the port of the address on the node,
+// listing the ports of the server with this fixed IP from Neutron rather
+// than every interface of the server from Nova.
+func getPortByIPFromNeutron(compute, network *gophercloud.ServiceClient, nodeNames *nodeNameMapper, targetNode types.NodeName, ipAddress string) (*neutronports.Port, error) {
+	srv, err := nodeNames.getServer(compute, targetNode)
+	if err != nil {
+		return nil, err
+	}
+
+	ports, err := openstackutil.GetPorts(network, neutronports.ListOpts{
+		DeviceID: srv.ID,
+		FixedIPs: []neutronports.FixedIPOpts{{IPAddress: ipAddress}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		return nil, errors.ErrNotFound
+	}
+
+	return &ports[0], nil
+}
+
 func getPortByID(client *gophercloud.ServiceClient, portID string) (*neutronports.Port, error) {
 	mc := metrics.NewMetricContext("port", "get")
 	targetPort, err := neutronports.Get(client, portID).Extract()
@@ -376,3 +691,133 @@ func getPortByID(client *gophercloud.ServiceClient, portID string) (*neutronport
 
 	return targetPort, nil
 }
+
+// getRouterIDForPort returns the router to route the traffic to the next hop
+// addr of port through. On a routed provider network, the next hop is only
+// reachable from a router attached to its segment: the router of the
+// segment is used if one is configured, and the router is checked to have an
+// interface on the segment.
+func (r *Routes) getRouterIDForPort(port *neutronports.Port, addr string) (string, error) {
+	var subnetID string
+	for _, fixedIP := range port.FixedIPs {
+		if fixedIP.IPAddress == addr {
+			subnetID = fixedIP.SubnetID
+			break
+		}
+	}
+	if subnetID == "" {
+		return r.opts.RouterID, nil
+	}
+
+	segments := make(map[string]string)
+	segmentID, err := getSubnetSegmentID(r.network, subnetID, segments)
+	if err != nil {
+		return "", err
+	}
+	if segmentID == "" {
+		return r.getRouterIDForSubnet(subnetID, addr)
+	}
+
+	routerID := r.opts.RouterID
+	if seg := r.opts.SegmentRouters[segmentID]; seg != nil && seg.RouterID != "" {
+		routerID = seg.RouterID
+	}
+
+	attached, err := isRouterOnSegment(r.network, routerID, subnetID, segmentID, segments)
+	if err != nil {
+		return "", err
+	}
+	if !attached {
+		return "", fmt.Errorf("next hop %s is on segment %s, router %s has no interface on it, configure the router of the segment in a RouteSegment section", addr, segmentID, routerID)
+	}
+
+	klog.V(4).Infof("Using router %s for next hop %s on segment %s", routerID, addr, segmentID)
+	return routerID, nil
+}
+
+// getRouterIDForSubnet returns the first of the router and the additional
+// routers which has an interface on the subnet of the next hop addr, or the
+// router if none has.
+func (r *Routes) getRouterIDForSubnet(subnetID, addr string) (string, error) {
+	if len(r.opts.AdditionalRouterIDs) == 0 {
+		return r.opts.RouterID, nil
+	}
+
+	for _, routerID := range append([]string{r.opts.RouterID}, r.opts.AdditionalRouterIDs...) {
+		attached, err := isRouterOnSubnet(r.network, routerID, subnetID)
+		if err != nil {
+			return "", err
+		}
+		if attached {
+			klog.V(4).Infof("Using router %s for next hop %s on subnet %s", routerID, addr, subnetID)
+			return routerID, nil
+		}
+	}
+
+	klog.V(4).Infof("No router has an interface on subnet %s of next hop %s, using router %s", subnetID, addr, r.opts.RouterID)
+	return r.opts.RouterID, nil
+}
+
+// isRouterOnSubnet reports whether the router has an interface on the subnet.
+func isRouterOnSubnet(network *gophercloud.ServiceClient, routerID, subnetID string) (bool, error) {
+	ports, err := openstackutil.GetPorts(network, neutronports.ListOpts{DeviceID: routerID})
+	if err != nil {
+		return false, err
+	}
+
+	for _, port := range ports {
+		for _, fixedIP := range port.FixedIPs {
+			if fixedIP.SubnetID == subnetID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// isRouterOnSegment reports whether the router has an interface on the subnet
+// or on another subnet of the segment.
+func isRouterOnSegment(network *gophercloud.ServiceClient, routerID, subnetID, segmentID string, segments map[string]string) (bool, error) {
+	ports, err := openstackutil.GetPorts(network, neutronports.ListOpts{DeviceID: routerID})
+	if err != nil {
+		return false, err
+	}
+
+	for _, port := range ports {
+		for _, fixedIP := range port.FixedIPs {
+			if fixedIP.SubnetID == subnetID {
+				return true, nil
+			}
+			id, err := getSubnetSegmentID(network, fixedIP.SubnetID, segments)
+			if err != nil {
+				return false, err
+			}
+			if id == segmentID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// getSubnetSegmentID returns the segment of the subnet, empty if the subnet is
+// not on a routed provider network. The segments already known are looked up
+// in and added to the segments cache.
+func getSubnetSegmentID(network *gophercloud.ServiceClient, subnetID string, segments map[string]string) (string, error) {
+	if id, ok := segments[subnetID]; ok {
+		return id, nil
+	}
+
+	// The segment_id attribute is not part of subnets.Subnet
+	var s struct {
+		SegmentID string `json:"segment_id"`
+	}
+	mc := metrics.NewMetricContext("subnet", "get")
+	err := subnets.Get(network, subnetID).ExtractIntoStructPtr(&s, "subnet")
+	if mc.ObserveRequest(err) != nil {
+		return "", err
+	}
+
+	segments[subnetID] = s.SegmentID
+	return s.SegmentID, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"net"
+
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// routeDivergence counts the pod CIDRs of a node missing a route or an
+// allowed address pair
+type routeDivergence struct {
+	routes       int
+	addressPairs int
+}
+
+// audit compares the pod CIDRs of the nodes with the routes of the routers
+// and the allowed address pairs of the node ports, and exposes the
+// differences as metrics, so a route or an address pair removed outside of
+// Kubernetes does not silently break the pod network.
+func (r *Routes) audit(ctx context.Context, kclient kubernetes.Interface) {
+	r = r.withContext(ctx)
+	nodes, err := kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list nodes to audit the routes: %v", err)
+		return
+	}
+
+	routes, err := r.backend.listRoutes()
+	if err != nil {
+		klog.Errorf("Failed to list the routes to audit them: %v", err)
+		return
+	}
+	nextHops := make(map[string][]string)
+	for _, route := range routes {
+		nextHops[route.DestinationCIDR] = append(nextHops[route.DestinationCIDR], route.NextHop)
+	}
+
+	divergences := make(map[string]routeDivergence)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !isNodeMigrated(node) || len(nodePodCIDRs(node)) == 0 {
+			continue
+		}
+		d, err := r.auditNode(node, nextHops)
+		if err != nil {
+			klog.Warningf("Failed to audit the routes of node %s: %v", node.Name, err)
+			continue
+		}
+		if d.routes > 0 || d.addressPairs > 0 {
+			klog.Warningf("Node %s has %d pod CIDRs without route and %d without allowed address pair", node.Name, d.routes, d.addressPairs)
+		}
+		divergences[node.Name] = d
+	}
+
+	metrics.RouteDivergence.Reset()
+	for name, d := range divergences {
+		metrics.RouteDivergence.WithLabelValues(name, metrics.RouteDivergenceRoute).Set(float64(d.routes))
+		metrics.RouteDivergence.WithLabelValues(name, metrics.RouteDivergenceAddressPair).Set(float64(d.addressPairs))
+	}
+}
+
+// auditNode checks that each pod CIDR of the node is routed through a next
+// hop of the node of the same IP family, and is an allowed
+// address pair of the port of this address.
+func (r *Routes) auditNode(node *corev1.Node, nextHops map[string][]string) (routeDivergence, error) {
+	var d routeDivergence
+	for _, cidr := range nodePodCIDRs(node) {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		candidates := nodeNextHops(node, ip.To4() == nil)
+		if len(candidates) == 0 {
+			d.routes++
+			if r.managesAddressPairs() {
+				d.addressPairs++
+			}
+			continue
+		}
+
+		// The route may go through any internal address of a node attached
+		// to several networks
+		addr := ""
+		for _, candidate := range candidates {
+			if cpoutil.Contains(nextHops[cidr], candidate) {
+				addr = candidate
+				break
+			}
+		}
+		if addr == "" {
+			d.routes++
+			addr = candidates[0]
+		}
+		if !r.managesAddressPairs() {
+			continue
+		}
+
+		ports, err := openstackutil.GetPorts(r.network, r.addressPortsOpts(addr))
+		if err != nil {
+			return d, err
+		}
+		if !portsHaveAddressPair(ports, cidr) {
+			d.addressPairs++
+		}
+	}
+	return d, nil
+}
+
+func nodePodCIDRs(node *corev1.Node) []string {
+	if len(node.Spec.PodCIDRs) > 0 {
+		return node.Spec.PodCIDRs
+	}
+	if node.Spec.PodCIDR != "" {
+		return []string{node.Spec.PodCIDR}
+	}
+	return nil
+}
+
+func portsHaveAddressPair(ports []neutronports.Port, cidr string) bool {
+	for _, port := range ports {
+		for _, pair := range port.AllowedAddressPairs {
+			if pair.IPAddress == cidr {
+				return true
+			}
+		}
+	}
+	return false
+}
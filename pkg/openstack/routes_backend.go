@@ -0,0 +1,441 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+)
+
+const (
+	// routeBackendExtraRoute routes the pod CIDRs with static routes on the routers
+	routeBackendExtraRoute = "neutron-extraroute"
+	// routeBackendSubnetHostRoutes routes the pod CIDRs with host routes on the subnets of the nodes
+	routeBackendSubnetHostRoutes = "subnet-host-routes"
+	// routeBackendNoopAudit only logs the route changes, without changing the cloud
+	routeBackendNoopAudit = "noop-audit"
+)
+
+// routeBackend programs the routes to the pod CIDRs of the nodes. Routes
+// looks up the next hop of a route, the address of its node, and the port
+// of this address, and manages the allowed address pairs of the port, so a
+// backend only programs the routes themselves.
+type routeBackend interface {
+	// name returns the name of the backend, as set in the backend option
+	name() string
+	// listRoutes returns the programmed routes
+	listRoutes() ([]routers.Route, error)
+	// listResources returns the Neutron resources holding the routes, whose
+	// tags mark the routes created for a cluster, nil if there are none.
+	listResources() ([]routeResource, error)
+	// addRoute programs the route through the port of its next hop. It
+	// returns a function reverting the change, nil if the route existed.
+	addRoute(route routers.Route, port *neutronports.Port) (func(), error)
+	// removeRoute removes the route. It returns a function reverting the
+	// change, nil if the route did not exist.
+	removeRoute(route routers.Route) (func(), error)
+	// managesAddressPairs reports whether the pod CIDRs must be allowed
+	// address pairs of the ports of their next hops.
+	managesAddressPairs() bool
+	// bind returns the backend programming the routes with the OpenStack
+	// clients of r, a copy of the Routes bound to a context.
+	bind(r *Routes) routeBackend
+}
+
+// routeBackendNetworkExtensions returns the Neutron extensions the backend
+// of the routes requires.
+func routeBackendNetworkExtensions(opts RouterOpts) []string {
+	var exts []string
+	switch opts.Backend {
+	case routeBackendSubnetHostRoutes:
+		exts = []string{"allowed-address-pairs"}
+	case routeBackendNoopAudit:
+		return nil
+	default:
+		exts = routesNetworkExtensions
+	}
+	if opts.TagRoutes {
+		exts = append(append([]string{}, exts...), "standard-attr-tag")
+	}
+
+	if opts.ManageAllowedAddressPairs {
+		return exts
+	}
+	var required []string
+	for _, ext := range exts {
+		if ext != "allowed-address-pairs" {
+			required = append(required, ext)
+		}
+	}
+	return required
+}
+
+// routeResource is a router or a subnet holding routes
+type routeResource struct {
+	// resourceType is the type of the resource in the Neutron tags API
+	resourceType string
+	id           string
+	tags         []string
+	routes       []routers.Route
+}
+
+// resourceRoutes returns the routes of the resources.
+func resourceRoutes(resources []routeResource) []routers.Route {
+	var routes []routers.Route
+	for _, res := range resources {
+		routes = append(routes, res.routes...)
+	}
+	return routes
+}
+
+func newRouteBackend(r *Routes) (routeBackend, error) {
+	var backend routeBackend
+	switch r.opts.Backend {
+	case "", routeBackendExtraRoute:
+		backend = &extraRouteBackend{r: r}
+	case routeBackendSubnetHostRoutes:
+		backend = &subnetHostRoutesBackend{r: r}
+	case routeBackendNoopAudit:
+		backend = &noopAuditBackend{routes: make(map[routers.Route]bool)}
+	default:
+		return nil, fmt.Errorf("unknown route backend %q", r.opts.Backend)
+	}
+	return backend, nil
+}
+
+// routeBackendName returns the name of the backend of the routes, false if
+// the backend is unknown.
+func routeBackendName(opts RouterOpts) (string, bool) {
+	switch opts.Backend {
+	case "", routeBackendExtraRoute:
+		return routeBackendExtraRoute, true
+	case routeBackendSubnetHostRoutes, routeBackendNoopAudit:
+		return opts.Backend, true
+	}
+	return "", false
+}
+
+// extraRouteBackend programs the routes as static routes on the router, the
+// additional routers and the routers of the segments.
+type extraRouteBackend struct {
+	r *Routes
+}
+
+func (b *extraRouteBackend) name() string {
+	return routeBackendExtraRoute
+}
+
+func (b *extraRouteBackend) bind(r *Routes) routeBackend {
+	return &extraRouteBackend{r: r}
+}
+
+func (b *extraRouteBackend) listRoutes() ([]routers.Route, error) {
+	resources, err := b.listResources()
+	if err != nil {
+		return nil, err
+	}
+	return resourceRoutes(resources), nil
+}
+
+func (b *extraRouteBackend) listResources() ([]routeResource, error) {
+	var resources []routeResource
+	for _, routerID := range b.r.routerIDs() {
+		mc := metrics.NewMetricContext("router", "get")
+		router, err := routers.Get(b.r.network, routerID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			return nil, err
+		}
+		resources = append(resources, routeResource{resourceType: "routers", id: routerID, tags: router.Tags, routes: router.Routes})
+	}
+	return resources, nil
+}
+
+func (b *extraRouteBackend) addRoute(route routers.Route, port *neutronports.Port) (func(), error) {
+	routerID, err := b.r.getRouterIDForPort(port, route.NextHop)
+	if err != nil {
+		return nil, err
+	}
+	return b.r.updateRoutes(routerID, route, false)
+}
+
+func (b *extraRouteBackend) removeRoute(route routers.Route) (func(), error) {
+	// The route may be on the router of the segment of the node
+	for _, routerID := range b.r.routerIDs() {
+		mc := metrics.NewMetricContext("router", "get")
+		router, err := routers.Get(b.r.network, routerID).Extract()
+		if mc.ObserveRequest(err) != nil {
+			return nil, err
+		}
+
+		for _, item := range router.Routes {
+			if item == route {
+				return b.r.updateRoutes(routerID, route, true)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (b *extraRouteBackend) managesAddressPairs() bool {
+	return true
+}
+
+// subnetHostRoutesBackend programs the routes as host routes of the subnet
+// of their next hop, so the nodes of the subnet route the pod CIDRs to each
+// other without router. The host routes are only applied by the servers
+// when they renew their DHCP lease.
+type subnetHostRoutesBackend struct {
+	r *Routes
+}
+
+func (b *subnetHostRoutesBackend) name() string {
+	return routeBackendSubnetHostRoutes
+}
+
+func (b *subnetHostRoutesBackend) bind(r *Routes) routeBackend {
+	return &subnetHostRoutesBackend{r: r}
+}
+
+// subnetIDs returns the subnets of the addresses of the servers.
+func (b *subnetHostRoutesBackend) subnetIDs() ([]string, error) {
+	sa, err := b.r.getServerAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range sa.subnetIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (b *subnetHostRoutesBackend) listRoutes() ([]routers.Route, error) {
+	resources, err := b.listResources()
+	if err != nil {
+		return nil, err
+	}
+	return resourceRoutes(resources), nil
+}
+
+func (b *subnetHostRoutesBackend) listResources() ([]routeResource, error) {
+	subnetIDs, err := b.subnetIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []routeResource
+	for _, subnetID := range subnetIDs {
+		subnet, _, err := getSubnetRevision(b.r.network, subnetID)
+		if err != nil {
+			return nil, err
+		}
+		res := routeResource{resourceType: "subnets", id: subnetID, tags: subnet.Tags}
+		for _, hr := range subnet.HostRoutes {
+			res.routes = append(res.routes, routers.Route{DestinationCIDR: hr.DestinationCIDR, NextHop: hr.NextHop})
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+func (b *subnetHostRoutesBackend) addRoute(route routers.Route, port *neutronports.Port) (func(), error) {
+	var subnetID string
+	for _, fixedIP := range port.FixedIPs {
+		if fixedIP.IPAddress == route.NextHop {
+			subnetID = fixedIP.SubnetID
+			break
+		}
+	}
+	if subnetID == "" {
+		return nil, fmt.Errorf("next hop %s is not an address of port %s", route.NextHop, port.ID)
+	}
+	return b.updateHostRoutes(subnetID, route, false)
+}
+
+func (b *subnetHostRoutesBackend) removeRoute(route routers.Route) (func(), error) {
+	subnetIDs, err := b.subnetIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subnetID := range subnetIDs {
+		unwind, err := b.updateHostRoutes(subnetID, route, true)
+		if err != nil || unwind != nil {
+			return unwind, err
+		}
+	}
+	return nil, nil
+}
+
+func (b *subnetHostRoutesBackend) managesAddressPairs() bool {
+	return true
+}
+
+func (b *subnetHostRoutesBackend) updateHostRoutes(subnetID string, route routers.Route, remove bool) (func(), error) {
+	changed, err := updateHostRoute(b.r.network, subnetID, route, remove)
+	if err != nil || !changed {
+		return nil, err
+	}
+
+	unwinder := func() {
+		klog.V(4).Infof("Reverting host routes change to subnet %v", subnetID)
+		if _, err := updateHostRoute(b.r.network, subnetID, route, !remove); err != nil {
+			klog.Warningf("Unable to reset host routes during error unwind: %v", err)
+		}
+	}
+	return unwinder, nil
+}
+
+// updateHostRoute adds the route to or removes it from the host routes of
+// the subnet, conditionally on the revision of the subnet. It reports
+// whether the subnet was changed.
+func updateHostRoute(network *gophercloud.ServiceClient, subnetID string, route routers.Route, remove bool) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		subnet, revision, err := getSubnetRevision(network, subnetID)
+		if err != nil {
+			return false, err
+		}
+
+		index := -1
+		for i, hr := range subnet.HostRoutes {
+			if hr.DestinationCIDR == route.DestinationCIDR && hr.NextHop == route.NextHop {
+				index = i
+				break
+			}
+		}
+		if remove == (index == -1) {
+			return false, nil
+		}
+
+		hostRoutes := make([]subnets.HostRoute, 0, len(subnet.HostRoutes)+1)
+		hostRoutes = append(hostRoutes, subnet.HostRoutes...)
+		if remove {
+			hostRoutes = append(hostRoutes[:index], hostRoutes[index+1:]...)
+		} else {
+			hostRoutes = append(hostRoutes, subnets.HostRoute{DestinationCIDR: route.DestinationCIDR, NextHop: route.NextHop})
+		}
+
+		opts := subnets.UpdateOpts{HostRoutes: &hostRoutes}
+		body, err := opts.ToSubnetUpdateMap()
+		if err != nil {
+			return false, err
+		}
+		mc := metrics.NewMetricContext("subnet", "update")
+		err = mc.ObserveRequest(putIfMatch(network, network.ServiceURL("subnets", subnetID), body, revision))
+		if err == nil {
+			return true, nil
+		}
+		if !isPreconditionFailed(err) || attempt >= maxRouteUpdateConflicts {
+			return false, err
+		}
+		klog.V(4).Infof("Subnet %s was updated concurrently, updating its host routes again", subnetID)
+	}
+}
+
+// getSubnetRevision gets the subnet and its revision number.
+func getSubnetRevision(network *gophercloud.ServiceClient, subnetID string) (*subnets.Subnet, int, error) {
+	var s struct {
+		subnets.Subnet
+		RevisionNumber int `json:"revision_number"`
+	}
+
+	mc := metrics.NewMetricContext("subnet", "get")
+	err := subnets.Get(network, subnetID).ExtractIntoStructPtr(&s, "subnet")
+	if mc.ObserveRequest(err) != nil {
+		return nil, 0, err
+	}
+
+	return &s.Subnet, s.RevisionNumber, nil
+}
+
+// noopAuditBackend logs the route changes without changing the cloud, and
+// remembers the routes it would have programmed, so the route controller
+// considers them programmed.
+type noopAuditBackend struct {
+	mu     sync.Mutex
+	routes map[routers.Route]bool
+}
+
+func (b *noopAuditBackend) name() string {
+	return routeBackendNoopAudit
+}
+
+// bind returns the backend itself, which records the routes without
+// OpenStack calls.
+func (b *noopAuditBackend) bind(r *Routes) routeBackend {
+	return b
+}
+
+func (b *noopAuditBackend) listRoutes() ([]routers.Route, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	routes := make([]routers.Route, 0, len(b.routes))
+	for route := range b.routes {
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// listResources returns nil, the routes are only held by the backend.
+func (b *noopAuditBackend) listResources() ([]routeResource, error) {
+	return nil, nil
+}
+
+func (b *noopAuditBackend) addRoute(route routers.Route, port *neutronports.Port) (func(), error) {
+	return b.update(route, false), nil
+}
+
+func (b *noopAuditBackend) removeRoute(route routers.Route) (func(), error) {
+	return b.update(route, true), nil
+}
+
+func (b *noopAuditBackend) managesAddressPairs() bool {
+	return false
+}
+
+func (b *noopAuditBackend) update(route routers.Route, remove bool) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.routes[route] != remove {
+		return nil
+	}
+	if remove {
+		klog.Infof("Would remove the route to %s through %s", route.DestinationCIDR, route.NextHop)
+		delete(b.routes, route)
+	} else {
+		klog.Infof("Would add a route to %s through %s", route.DestinationCIDR, route.NextHop)
+		b.routes[route] = true
+	}
+	return func() { b.update(route, !remove) }
+}
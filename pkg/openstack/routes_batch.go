@@ -0,0 +1,373 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// maxRouteUpdateConflicts is the number of times the routes of a router are
+// updated again when another client updated the router in the meantime.
+const maxRouteUpdateConflicts = 5
+
+// routeChange adds a route to or removes a route from a router
+type routeChange struct {
+	route  routers.Route
+	remove bool
+
+	// unchanged is set when the route already was, or was not, on the router
+	unchanged bool
+	done      chan error
+}
+
+// routerBatch holds the route changes waiting for the update of a router
+type routerBatch struct {
+	changes []*routeChange
+	running bool
+}
+
+// routeBatcher coalesces the route changes of a router into a single update.
+// The changes submitted while the router is being updated, and during the
+// batch window, are applied together by the next update. Each update is
+// conditional on the revision of the router it was computed from, and is
+// computed again if another client updated the router in the meantime.
+type routeBatcher struct {
+	network *gophercloud.ServiceClient
+	window  time.Duration
+	// atomic adds and removes the routes with the extraroute-atomic Neutron
+	// extension instead of replacing all the routes of the router, so the
+	// concurrent changes of other clients are never overwritten. It is
+	// disabled if Neutron rejects these updates although it lists the
+	// extension.
+	atomic bool
+	// verify reads the routes again after each update, as Neutron ignores
+	// the revision of the updates without the revision-if-match extension,
+	// and updates them again if another client overwrote the changes
+	verify bool
+
+	mu      sync.Mutex
+	batches map[string]*routerBatch
+}
+
+func newRouteBatcher(network *gophercloud.ServiceClient, window time.Duration) *routeBatcher {
+	return &routeBatcher{
+		network: network,
+		window:  window,
+		batches: make(map[string]*routerBatch),
+	}
+}
+
+// apply applies the change to the router and waits for the update of the
+// router. It reports whether the router was left unchanged because the route
+// already was, or was not, on it. It returns the error of ctx if ctx is
+// done before the update, which may still apply the change.
+func (b *routeBatcher) apply(ctx context.Context, routerID string, route routers.Route, remove bool) (bool, error) {
+	change := &routeChange{route: route, remove: remove, done: make(chan error, 1)}
+
+	b.mu.Lock()
+	batch, ok := b.batches[routerID]
+	if !ok {
+		batch = &routerBatch{}
+		b.batches[routerID] = batch
+	}
+	batch.changes = append(batch.changes, change)
+	if !batch.running {
+		batch.running = true
+		go b.run(routerID, batch)
+	}
+	b.mu.Unlock()
+
+	select {
+	case err := <-change.done:
+		return change.unchanged, err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// run updates the router until no change is waiting.
+func (b *routeBatcher) run(routerID string, batch *routerBatch) {
+	for {
+		if b.window > 0 {
+			time.Sleep(b.window)
+		}
+
+		b.mu.Lock()
+		changes := batch.changes
+		batch.changes = nil
+		if len(changes) == 0 {
+			batch.running = false
+			delete(b.batches, routerID)
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		klog.V(4).Infof("Applying %d route changes to router %s", len(changes), routerID)
+		err := b.update(routerID, changes)
+		if err != nil && len(changes) > 1 {
+			// A single invalid route makes Neutron reject the whole update,
+			// so the changes are applied one by one to isolate it.
+			klog.V(4).Infof("Failed to apply %d route changes to router %s at once, applying them one by one: %v", len(changes), routerID, err)
+			for _, change := range changes {
+				change.done <- b.update(routerID, []*routeChange{change})
+			}
+			continue
+		}
+		for _, change := range changes {
+			change.done <- err
+		}
+	}
+}
+
+// update applies the changes to the routes of the router.
+func (b *routeBatcher) update(routerID string, changes []*routeChange) error {
+	for attempt := 0; ; attempt++ {
+		router, revision, err := getRouterRevision(b.network, routerID)
+		if err != nil {
+			return err
+		}
+
+		routes, changed := applyRouteChanges(router.Routes, changes)
+		if !changed {
+			return nil
+		}
+
+		atomic := b.isAtomic()
+		if atomic {
+			err = updateRoutesAtomic(b.network, routerID, router.Routes, routes)
+			if isExtraRoutesUnsupported(err) {
+				klog.Warningf("Neutron does not support the extraroute-atomic updates of router %s, replacing the routes of the routers instead: %v", routerID, err)
+				b.disableAtomic()
+				atomic = false
+				err = updateRoutesIfMatch(b.network, routerID, routes, revision)
+			}
+		} else {
+			err = updateRoutesIfMatch(b.network, routerID, routes, revision)
+		}
+		if isRoutesExhausted(err) {
+			metrics.RouteQuotaExceeded.WithLabelValues(routerID).Inc()
+			return fmt.Errorf("%w: router %s has %d routes: %v", cpoerrors.ErrRouteQuotaExceeded, routerID, len(router.Routes), err)
+		}
+		overwritten := false
+		if err == nil && b.verify && !atomic {
+			overwritten, err = b.isOverwritten(routerID, changes)
+		}
+		if err == nil && !overwritten {
+			return nil
+		}
+		if attempt >= maxRouteUpdateConflicts {
+			if overwritten {
+				return fmt.Errorf("the routes of router %s were overwritten by another client %d times", routerID, attempt+1)
+			}
+			return err
+		}
+		if err != nil && !isPreconditionFailed(err) {
+			return err
+		}
+		klog.V(4).Infof("Router %s was updated concurrently, updating its routes again", routerID)
+	}
+}
+
+// isAtomic reports whether the routes are added and removed with the
+// extraroute-atomic extension.
+func (b *routeBatcher) isAtomic() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.atomic
+}
+
+// disableAtomic falls back to replacing all the routes of the routers.
+func (b *routeBatcher) disableAtomic() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.atomic = false
+}
+
+// isOverwritten reports whether another client overwrote the changes after
+// the update of the router, which Neutron does not prevent without
+// conditional updates.
+func (b *routeBatcher) isOverwritten(routerID string, changes []*routeChange) (bool, error) {
+	router, _, err := getRouterRevision(b.network, routerID)
+	if err != nil {
+		return false, err
+	}
+	for _, change := range changes {
+		if !change.unchanged && routesContain(router.Routes, change.route) == change.remove {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applyRouteChanges returns the routes with the changes applied, and whether
+// they differ from the original routes. The unchanged flag of each change is
+// set accordingly.
+func applyRouteChanges(orig []routers.Route, changes []*routeChange) ([]routers.Route, bool) {
+	routes := make([]routers.Route, len(orig))
+	copy(routes, orig)
+
+	changed := false
+	for _, change := range changes {
+		index := -1
+		for i, item := range routes {
+			if item == change.route {
+				index = i
+				break
+			}
+		}
+
+		change.unchanged = change.remove == (index == -1)
+		if change.unchanged {
+			continue
+		}
+		changed = true
+
+		if change.remove {
+			routes = append(routes[:index], routes[index+1:]...)
+		} else {
+			routes = append(routes, change.route)
+		}
+	}
+
+	return routes, changed
+}
+
+// getRouterRevision gets the router and its revision number.
+func getRouterRevision(network *gophercloud.ServiceClient, routerID string) (*routers.Router, int, error) {
+	var r struct {
+		routers.Router
+		RevisionNumber int `json:"revision_number"`
+	}
+
+	mc := metrics.NewMetricContext("router", "get")
+	err := routers.Get(network, routerID).ExtractIntoStructPtr(&r, "router")
+	if mc.ObserveRequest(err) != nil {
+		return nil, 0, err
+	}
+
+	return &r.Router, r.RevisionNumber, nil
+}
+
+// updateRoutesIfMatch updates the routes of the router if it's still at the
+// revision.
+func updateRoutesIfMatch(network *gophercloud.ServiceClient, routerID string, routes []routers.Route, revision int) error {
+	opts := routers.UpdateOpts{Routes: &routes}
+	body, err := opts.ToRouterUpdateMap()
+	if err != nil {
+		return err
+	}
+
+	mc := metrics.NewMetricContext("router", "update")
+	return mc.ObserveRequest(putIfMatch(network, network.ServiceURL("routers", routerID), body, revision))
+}
+
+// updateRoutesAtomic adds the routes of routes missing from orig to the
+// router, and removes the routes of orig missing from routes, leaving the
+// other routes of the router as they are.
+func updateRoutesAtomic(network *gophercloud.ServiceClient, routerID string, orig, routes []routers.Route) error {
+	var added, removed []routers.Route
+	for _, route := range routes {
+		if !routesContain(orig, route) {
+			added = append(added, route)
+		}
+	}
+	for _, route := range orig {
+		if !routesContain(routes, route) {
+			removed = append(removed, route)
+		}
+	}
+
+	for _, u := range []struct {
+		action string
+		routes []routers.Route
+	}{{"add_extraroutes", added}, {"remove_extraroutes", removed}} {
+		if len(u.routes) == 0 {
+			continue
+		}
+		body := map[string]interface{}{"router": map[string]interface{}{"routes": u.routes}}
+		mc := metrics.NewMetricContext("router", "update")
+		_, err := network.Put(network.ServiceURL("routers", routerID, u.action), body, nil, &gophercloud.RequestOpts{OkCodes: []int{http.StatusOK}})
+		if mc.ObserveRequest(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putIfMatch updates the Neutron resource if it's still at the revision.
+// Neutron ignores the condition if it does not support it, and the update
+// is then unconditional.
+func putIfMatch(network *gophercloud.ServiceClient, url string, body map[string]interface{}, revision int) error {
+	reqOpts := &gophercloud.RequestOpts{OkCodes: []int{http.StatusOK}}
+	if revision > 0 {
+		reqOpts.MoreHeaders = map[string]string{"If-Match": fmt.Sprintf("revision_number=%d", revision)}
+	}
+
+	_, err := network.Put(url, body, nil, reqOpts)
+	return err
+}
+
+func isPreconditionFailed(err error) bool {
+	if e, ok := err.(gophercloud.StatusCodeError); ok {
+		return e.GetStatusCode() == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// isRoutesExhausted reports whether Neutron rejected the routes because the
+// router would have more routes than its max_routes option allows. The
+// option is not exposed by the API, the error is the only way to detect it.
+func isRoutesExhausted(err error) bool {
+	var body []byte
+	switch e := err.(type) {
+	case gophercloud.ErrDefault400:
+		body = e.Body
+	case gophercloud.ErrDefault409:
+		body = e.Body
+	default:
+		return false
+	}
+	return bytes.Contains(body, []byte("RoutesExhausted"))
+}
+
+// isExtraRoutesUnsupported reports whether Neutron rejected the
+// add_extraroutes or remove_extraroutes action of a router as unknown, as
+// some Neutron backends do although they list the extraroute-atomic
+// extension. A missing router is not reported.
+func isExtraRoutesUnsupported(err error) bool {
+	switch e := err.(type) {
+	case gophercloud.ErrDefault404:
+		return !bytes.Contains(e.Body, []byte("RouterNotFound"))
+	case gophercloud.StatusCodeError:
+		code := e.GetStatusCode()
+		return code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+)
+
+// serverAddresses are the addresses of the servers and the ports of these
+// addresses, as needed by the routes.
+type serverAddresses struct {
+	addrs     map[types.NodeName][]v1.NodeAddress
+	nodeNames map[string]types.NodeName // by address
+	portIDs   map[string]string         // by address
+	subnetIDs map[string]string         // by address
+}
+
+// listServerAddresses lists every server and its interfaces.
+func listServerAddresses(compute *gophercloud.ServiceClient, nodeNames *nodeNameMapper, networkingOpts NetworkingOpts) (*serverAddresses, error) {
+	sa := &serverAddresses{
+		addrs:     make(map[types.NodeName][]v1.NodeAddress),
+		nodeNames: make(map[string]types.NodeName),
+		portIDs:   make(map[string]string),
+		subnetIDs: make(map[string]string),
+	}
+
+	err := foreachServer(compute, servers.ListOpts{}, func(srv *servers.Server) (bool, error) {
+		interfaces, err := getAttachedInterfacesByID(compute, srv.ID)
+		if err != nil {
+			return false, err
+		}
+
+		addrs, err := nodeAddresses(srv, interfaces, networkingOpts)
+		if err != nil {
+			return false, err
+		}
+
+		name := nodeNames.nodeName(srv.Name)
+		sa.addrs[name] = addrs
+		for _, addr := range addrs {
+			sa.nodeNames[addr.Address] = name
+		}
+		for _, intf := range interfaces {
+			for _, fixedIP := range intf.FixedIPs {
+				sa.portIDs[fixedIP.IPAddress] = intf.PortID
+				sa.subnetIDs[fixedIP.IPAddress] = fixedIP.SubnetID
+			}
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sa, nil
+}
+
+// routeNodeCache caches the addresses of the servers and the ports of these
+// addresses for ttl, so the routes do not list every server and its
+// interfaces on each reconciliation. It is invalidated when a node is added
+// or deleted.
+type routeNodeCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	expiry  time.Time
+	servers *serverAddresses
+}
+
+func newRouteNodeCache(ttl time.Duration) *routeNodeCache {
+	return &routeNodeCache{ttl: ttl}
+}
+
+// get returns the cached server addresses, listing them again if the cache
+// expired.
+func (c *routeNodeCache) get(compute *gophercloud.ServiceClient, nodeNames *nodeNameMapper, networkingOpts NetworkingOpts) (*serverAddresses, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.servers != nil && time.Now().Before(c.expiry) {
+		return c.servers, nil
+	}
+
+	sa, err := listServerAddresses(compute, nodeNames, networkingOpts)
+	if err != nil {
+		return nil, err
+	}
+	c.servers = sa
+	c.expiry = time.Now().Add(c.ttl)
+	return sa, nil
+}
+
+func (c *routeNodeCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	klog.V(4).Info("Invalidating the cached server addresses of the routes")
+	c.servers = nil
+}
+
+// getServerAddresses returns the addresses of the servers, from the cache
+// if enabled.
+func (r *Routes) getServerAddresses() (*serverAddresses, error) {
+	if r.cache != nil {
+		return r.cache.get(r.compute, r.nodeNames, r.networkingOpts)
+	}
+	return listServerAddresses(r.compute, r.nodeNames, r.networkingOpts)
+}
+
+// getAddressesByName returns the addresses of the node, from the cache if
+// enabled.
+func (r *Routes) getAddressesByName(name types.NodeName) ([]v1.NodeAddress, error) {
+	if r.cache == nil {
+		return getAddressesByName(r.compute, r.nodeNames, name, r.networkingOpts)
+	}
+
+	sa, err := r.cache.get(r.compute, r.nodeNames, r.networkingOpts)
+	if err != nil {
+		return nil, err
+	}
+	addrs, ok := sa.addrs[name]
+	if !ok {
+		// The server was created after the cache was filled
+		return getAddressesByName(r.compute, r.nodeNames, name, r.networkingOpts)
+	}
+	return addrs, nil
+}
+
+// getPortByIP returns the port of the address on the node.
+func (r *Routes) getPortByIP(name types.NodeName, addr string) (*neutronports.Port, error) {
+	if r.cache != nil {
+		sa, err := r.cache.get(r.compute, r.nodeNames, r.networkingOpts)
+		if err != nil {
+			return nil, err
+		}
+		if portID, ok := sa.portIDs[addr]; ok {
+			port, err := getPortByID(r.network, portID)
+			if err == nil || !cpoerrors.IsNotFound(err) {
+				return port, err
+			}
+			// The server was recreated with the same address
+			r.cache.invalidate()
+		}
+	}
+
+	if r.opts.NeutronPortLookup {
+		return getPortByIPFromNeutron(r.compute, r.network, r.nodeNames, name, addr)
+	}
+
+	portID, err := getPortIDByIP(r.compute, r.nodeNames, name, addr)
+	if err != nil {
+		return nil, err
+	}
+	return getPortByID(r.network, portID)
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+	cpoerrors "k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// routeGarbageCollector is the state of the removal of the orphaned routes
+// shared by the Routes of the cloud provider.
+type routeGarbageCollector struct {
+	mu sync.Mutex
+	// clusterName is learnt from the route controller, empty until it
+	// lists the routes
+	clusterName string
+}
+
+// setClusterName records the name of the cluster of the routes. It is safe
+// to call on a nil collector.
+func (gc *routeGarbageCollector) setClusterName(clusterName string) {
+	if gc == nil {
+		return
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.clusterName = clusterName
+}
+
+func (gc *routeGarbageCollector) getClusterName() string {
+	if gc == nil {
+		return ""
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.clusterName
+}
+
+// ownsRoute reports whether the route was created for the cluster: with the
+// tag of the cluster if the routes are tagged, else to a pod CIDR within the
+// cluster-cidr option. The routes of the other clusters sharing the routers
+// are never removed.
+func (r *Routes) ownsRoute(clusterName string, tags sets.String, route routers.Route) bool {
+	if r.tagsRoutes() {
+		return tags.Has(routeTag(clusterName, route))
+	}
+	return withinCIDRs(r.opts.ClusterCIDRs, route.DestinationCIDR)
+}
+
+// collectGarbage removes the routes and the allowed address pairs left
+// behind by the nodes deleted outside of Kubernetes:
+//   - a route created for the cluster, see ownsRoute, whose next hop is not
+//     an address of a node is removed if no port has this address anymore,
+//     or if the port of this address has the destination of the route as
+//     allowed address pair, which is then removed too. The other routes,
+//     e.g. to appliances, are kept.
+//   - an allowed address pair of the port of a node, which is a network
+//     within the cluster-cidr option and neither a pod CIDR of the node nor
+//     routed through the node, is removed. The other pairs, e.g. the virtual
+//     IPs of keepalived or the ranges of MetalLB, are kept.
+func (r *Routes) collectGarbage(ctx context.Context, kclient kubernetes.Interface) {
+	r = r.withContext(ctx)
+	clusterName := r.gc.getClusterName()
+	if r.tagsRoutes() && clusterName == "" {
+		klog.V(4).Infof("Not collecting the orphaned routes yet, the routes were not listed by the route controller")
+		return
+	}
+	nodes, err := kclient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.Errorf("Failed to list nodes to collect the orphaned routes: %v", err)
+		return
+	}
+	if len(nodes.Items) == 0 {
+		// The nodes may not be listed yet, the routes are all kept
+		return
+	}
+
+	sa, err := r.getServerAddresses()
+	if err != nil {
+		klog.Errorf("Failed to list the server addresses to collect the orphaned routes: %v", err)
+		return
+	}
+
+	// The pod CIDRs routed through each internal address of the nodes
+	nodeCIDRs := make(map[string][]string)
+	nodeNames := make(map[types.NodeName]bool)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		nodeNames[types.NodeName(node.Name)] = true
+		for _, addr := range node.Status.Addresses {
+			if _, ok := nodeCIDRs[addr.Address]; !ok {
+				nodeCIDRs[addr.Address] = nil
+			}
+		}
+		for _, cidr := range nodePodCIDRs(node) {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			for _, addr := range nodeNextHops(node, ip.To4() == nil) {
+				nodeCIDRs[addr] = append(nodeCIDRs[addr], cidr)
+			}
+		}
+	}
+	isNodeAddress := func(addr string) bool {
+		if _, ok := nodeCIDRs[addr]; ok {
+			return true
+		}
+		// The node may not have its addresses yet
+		name, ok := sa.nodeNames[addr]
+		return ok && nodeNames[name]
+	}
+
+	routes, tags, err := r.listRoutesAndTags()
+	if err != nil {
+		klog.Errorf("Failed to list the routes to collect the orphaned ones: %v", err)
+		return
+	}
+
+	routed := make(map[string][]string)
+	for _, route := range routes {
+		if isNodeAddress(route.NextHop) {
+			routed[route.NextHop] = append(routed[route.NextHop], route.DestinationCIDR)
+			continue
+		}
+		if !r.ownsRoute(clusterName, tags, route) {
+			klog.V(4).Infof("Keeping the route to %s through %s, which was not created for the cluster", route.DestinationCIDR, route.NextHop)
+			continue
+		}
+		if err := r.collectRoute(clusterName, route); err != nil {
+			klog.Warningf("Failed to remove the orphaned route to %s through %s: %v", route.DestinationCIDR, route.NextHop, err)
+		}
+	}
+
+	// The address pairs are not tagged, only the ones within the pod CIDRs
+	// of the cluster are known to be added for it
+	if !r.managesAddressPairs() || len(r.opts.ClusterCIDRs) == 0 {
+		return
+	}
+	for addr, cidrs := range nodeCIDRs {
+		if net.ParseIP(addr) == nil {
+			// A hostname of the node
+			continue
+		}
+		if err := r.collectAddressPairs(addr, append(cidrs, routed[addr]...)); err != nil {
+			klog.Warningf("Failed to remove the orphaned allowed address pairs of %s: %v", addr, err)
+		}
+	}
+}
+
+// collectRoute removes the route of the cluster through an address which is
+// not an address of a node, with its tag and its allowed address pair, if it
+// was programmed for a node.
+func (r *Routes) collectRoute(clusterName string, route routers.Route) error {
+	ports, err := openstackutil.GetPorts(r.network, r.addressPortsOpts(route.NextHop))
+	if err != nil {
+		return err
+	}
+
+	var portID string
+	if len(ports) > 0 {
+		for _, port := range ports {
+			if portsHaveAddressPair([]neutronports.Port{port}, route.DestinationCIDR) {
+				portID = port.ID
+				break
+			}
+		}
+		if portID == "" {
+			klog.V(4).Infof("Keeping the route to %s through %s, which is not an address of a node", route.DestinationCIDR, route.NextHop)
+			return nil
+		}
+	}
+
+	klog.Infof("Removing the orphaned route to %s through %s", route.DestinationCIDR, route.NextHop)
+	unwind, err := r.backend.removeRoute(route)
+	if err != nil {
+		return err
+	}
+	if unwind != nil {
+		metrics.RouteGarbageCollected.WithLabelValues(metrics.RouteDivergenceRoute).Inc()
+	}
+	if r.tagsRoutes() {
+		if err := r.tagRoute(clusterName, route, true); err != nil {
+			return err
+		}
+	}
+
+	if portID == "" || !r.managesAddressPairs() {
+		return nil
+	}
+	unwind, err = r.updateAllowedAddressPair(portID, route.DestinationCIDR, true)
+	if err != nil {
+		return err
+	}
+	if unwind != nil {
+		metrics.RouteGarbageCollected.WithLabelValues(metrics.RouteDivergenceAddressPair).Inc()
+	}
+	return nil
+}
+
+// collectAddressPairs removes the allowed address pairs of the port of the
+// node address which are networks within the cluster-cidr option other than
+// the CIDRs.
+func (r *Routes) collectAddressPairs(addr string, cidrs []string) error {
+	ports, err := openstackutil.GetPorts(r.network, r.addressPortsOpts(addr))
+	if err != nil {
+		return err
+	}
+
+	for _, port := range ports {
+		for _, pair := range port.AllowedAddressPairs {
+			if !isNetworkCIDR(pair.IPAddress) || !withinCIDRs(r.opts.ClusterCIDRs, pair.IPAddress) || cpoutil.Contains(cidrs, pair.IPAddress) {
+				continue
+			}
+			klog.Infof("Removing the orphaned allowed address pair %s of port %s", pair.IPAddress, port.ID)
+			unwind, err := r.updateAllowedAddressPair(port.ID, pair.IPAddress, true)
+			if err != nil {
+				return err
+			}
+			if unwind != nil {
+				metrics.RouteGarbageCollected.WithLabelValues(metrics.RouteDivergenceAddressPair).Inc()
+			}
+		}
+	}
+	return nil
+}
+
+// pruneBlackholeRoutes removes the routes created for the cluster, see
+// ownsRoute, whose next hop is not the address of any port anymore, which
+// drop the traffic, to free the route quota of the routers. The other routes,
+// e.g. to appliances outside of Neutron, are kept. It returns the number of
+// routes removed.
+func (r *Routes) pruneBlackholeRoutes(clusterName string) (int, error) {
+	routes, tags, err := r.listRoutesAndTags()
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, route := range routes {
+		if !r.ownsRoute(clusterName, tags, route) {
+			continue
+		}
+		ports, err := openstackutil.GetPorts(r.network, r.addressPortsOpts(route.NextHop))
+		if err != nil {
+			return pruned, err
+		}
+		if len(ports) > 0 {
+			continue
+		}
+
+		klog.Infof("Removing the blackhole route to %s through %s to free the route quota", route.DestinationCIDR, route.NextHop)
+		unwind, err := r.backend.removeRoute(route)
+		if err != nil {
+			return pruned, err
+		}
+		if unwind != nil {
+			metrics.RouteGarbageCollected.WithLabelValues(metrics.RouteDivergenceRoute).Inc()
+			pruned++
+		}
+		if r.tagsRoutes() {
+			if err := r.tagRoute(clusterName, route, true); err != nil {
+				return pruned, err
+			}
+		}
+	}
+	return pruned, nil
+}
+
+func isRouteQuotaExceeded(err error) bool {
+	return errors.Is(err, cpoerrors.ErrRouteQuotaExceeded)
+}
+
+// withinCIDRs reports whether the CIDR is a subnet of one of the networks.
+func withinCIDRs(networks []string, cidr string) bool {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, bits := ipNet.Mask.Size()
+	for _, network := range networks {
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			continue
+		}
+		nOnes, nBits := n.Mask.Size()
+		if nBits == bits && nOnes <= ones && n.Contains(ipNet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNetworkCIDR reports whether the CIDR is a network, rather than a single
+// address.
+func isNetworkCIDR(cidr string) bool {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, bits := ipNet.Mask.Size()
+	return ones < bits
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	"k8s.io/cloud-provider-openstack/pkg/util/errors"
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+// AnnotationRouteNextHop sets the addresses of a node the routes to its pod
+// CIDRs go through, one per IP family separated by commas, e.g. when the
+// node has interfaces on several networks.
+const AnnotationRouteNextHop = "node.openstack.org/route-next-hop"
+
+// getNextHop returns the address of the node of the IP family the routes to
+// the node go through: the address of the route-next-hop annotation of the
+// node if set, else the first internal address on a subnet of the routers
+// when the node has several, else the first internal address.
+func (r *Routes) getNextHop(name types.NodeName, needIPv6 bool) (string, error) {
+	if needIPv6 && r.networkingOpts.IPv6SupportDisabled {
+		return "", errors.ErrIPv6SupportDisabled
+	}
+
+	addrs, err := r.getAddressesByName(name)
+	if err != nil {
+		return "", err
+	}
+
+	override, err := r.getNextHopOverride(name, needIPv6)
+	if err != nil {
+		return "", err
+	}
+	if override != "" {
+		for _, addr := range addrs {
+			if addr.Address == override {
+				return override, nil
+			}
+		}
+		return "", fmt.Errorf("address %s of the %s annotation of node %s is not an address of the node", override, AnnotationRouteNextHop, name)
+	}
+
+	candidates := nodeAddressesOfFamily(addrs, corev1.NodeInternalIP, needIPv6)
+	if len(candidates) > 1 && r.backend.name() == routeBackendExtraRoute {
+		addr, err := r.selectRoutedAddress(candidates)
+		if err != nil {
+			return "", err
+		}
+		if addr != "" {
+			return addr, nil
+		}
+		klog.V(4).Infof("No address of node %s is on a subnet of the routers, using the first one", name)
+	}
+
+	return selectNodeAddress(addrs, needIPv6)
+}
+
+// getNextHopOverride returns the address of the IP family set by the
+// route-next-hop annotation of the node, empty if none.
+func (r *Routes) getNextHopOverride(name types.NodeName, needIPv6 bool) (string, error) {
+	if r.kclient == nil {
+		return "", nil
+	}
+
+	node, err := r.kclient.CoreV1().Nodes().Get(context.TODO(), string(name), metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get node %s: %v", name, err)
+	}
+	return nodeNextHopOverride(node, needIPv6), nil
+}
+
+// nodeNextHopOverride returns the address of the IP family set by the
+// route-next-hop annotation of the node, empty if none.
+func nodeNextHopOverride(node *corev1.Node, ipv6 bool) string {
+	value := node.Annotations[AnnotationRouteNextHop]
+	if value == "" {
+		return ""
+	}
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.TrimSpace(addr)
+		ip := net.ParseIP(addr)
+		if ip != nil && (ip.To4() == nil) == ipv6 {
+			return addr
+		}
+	}
+	return ""
+}
+
+// nodeNextHops returns the addresses of the node of the IP family the routes
+// to the node may go through: the address of the route-next-hop annotation
+// if set, else the internal addresses.
+func nodeNextHops(node *corev1.Node, ipv6 bool) []string {
+	if addr := nodeNextHopOverride(node, ipv6); addr != "" {
+		return []string{addr}
+	}
+	return nodeAddressesOfFamily(node.Status.Addresses, corev1.NodeInternalIP, ipv6)
+}
+
+// nodeAddressesOfFamily returns the addresses of the type and of the IP
+// family.
+func nodeAddressesOfFamily(addrs []corev1.NodeAddress, addrType corev1.NodeAddressType, ipv6 bool) []string {
+	var found []string
+	for _, addr := range addrs {
+		if addr.Type != addrType {
+			continue
+		}
+		ip := net.ParseIP(addr.Address)
+		if ip != nil && (ip.To4() == nil) == ipv6 {
+			found = append(found, addr.Address)
+		}
+	}
+	return found
+}
+
+// selectRoutedAddress returns the first of the addresses on a subnet a
+// router has an interface on, empty if none is.
+func (r *Routes) selectRoutedAddress(addrs []string) (string, error) {
+	var cidrs []*net.IPNet
+	for _, routerID := range r.routerIDs() {
+		ports, err := openstackutil.GetPorts(r.network, neutronports.ListOpts{DeviceID: routerID})
+		if err != nil {
+			return "", err
+		}
+		for _, port := range ports {
+			for _, fixedIP := range port.FixedIPs {
+				mc := metrics.NewMetricContext("subnet", "get")
+				subnet, err := subnets.Get(r.network, fixedIP.SubnetID).Extract()
+				if mc.ObserveRequest(err) != nil {
+					return "", err
+				}
+				if _, cidr, err := net.ParseCIDR(subnet.CIDR); err == nil {
+					cidrs = append(cidrs, cidr)
+				}
+			}
+		}
+	}
+
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		for _, cidr := range cidrs {
+			if cidr.Contains(ip) {
+				return addr, nil
+			}
+		}
+	}
+	return "", nil
+}
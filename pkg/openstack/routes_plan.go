@@ -0,0 +1,243 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	openstackutil "k8s.io/cloud-provider-openstack/pkg/util/openstack"
+)
+
+const (
+	// RoutePlanPath is the path the plan of the route changes is served on,
+	// next to the inventory
+	RoutePlanPath = "/routes/plan"
+
+	// defaultRoutePlanClusterName is the default --cluster-name of the
+	// controller manager, whose routes are planned unless the cluster-name
+	// query parameter is set.
+	defaultRoutePlanClusterName = "kubernetes"
+
+	routePlanActionAdd    = "add"
+	routePlanActionRemove = "remove"
+
+	routePlanKindRoute       = "Route"
+	routePlanKindAddressPair = "AllowedAddressPair"
+)
+
+// RoutePlanChange is a route or an allowed address pair the route controller
+// would add or remove.
+type RoutePlanChange struct {
+	Action          string `json:"action"`
+	Kind            string `json:"kind"`
+	DestinationCIDR string `json:"destinationCIDR"`
+	NextHop         string `json:"nextHop,omitempty"`
+	// Node is the node the route goes to, empty for a blackhole route.
+	Node string `json:"node,omitempty"`
+	// PortID is the port of the next hop holding the allowed address pair.
+	PortID string `json:"portID,omitempty"`
+}
+
+// RoutePlan lists the changes which would bring the routes and the allowed
+// address pairs to the pod CIDRs of the nodes.
+type RoutePlan struct {
+	ClusterName string            `json:"clusterName"`
+	Backend     string            `json:"backend"`
+	Changes     []RoutePlanChange `json:"changes"`
+	// Errors are the nodes which could not be planned, the plan may be incomplete if not empty.
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (p *RoutePlan) addError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	klog.Warningf("Route plan: %s", msg)
+	p.Errors = append(p.Errors, msg)
+}
+
+// plannedRoute is a route to a pod CIDR through an address of a node
+type plannedRoute struct {
+	cidr string
+	node types.NodeName
+}
+
+// plan computes the changes the route controller would make for the nodes,
+// without applying them: the routes of the cluster to a pod CIDR of a node
+// through any address of this node are kept, the missing routes are added
+// through the next hop of the node, and the other routes, e.g. the blackhole
+// routes of the deleted nodes, are removed, with their allowed address pairs.
+func (r *Routes) plan(ctx context.Context, clusterName string, nodes []corev1.Node) (*RoutePlan, error) {
+	r = r.withContext(ctx)
+	p := &RoutePlan{ClusterName: clusterName, Backend: r.backend.name(), Changes: []RoutePlanChange{}}
+
+	sa, err := r.getServerAddresses()
+	if err != nil {
+		return nil, err
+	}
+	items, tags, err := r.listRoutesAndTags()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[plannedRoute][]routers.Route)
+	for _, item := range items {
+		if tags != nil && !tags.Has(routeTag(clusterName, item)) {
+			// Not created for the cluster, e.g. a static route of the operators
+			continue
+		}
+		key := plannedRoute{cidr: item.DestinationCIDR, node: sa.nodeNames[item.NextHop]}
+		existing[key] = append(existing[key], item)
+	}
+
+	desired := make(map[plannedRoute]bool)
+	for i := range nodes {
+		node := &nodes[i]
+		if !isNodeMigrated(node) {
+			continue
+		}
+		for _, cidr := range nodePodCIDRs(node) {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				p.addError("invalid pod CIDR %s of node %s: %v", cidr, node.Name, err)
+				continue
+			}
+			key := plannedRoute{cidr: cidr, node: types.NodeName(node.Name)}
+			desired[key] = true
+			if routes, ok := existing[key]; ok {
+				for _, route := range routes {
+					r.planAddressPair(p, routePlanActionAdd, route, node.Name)
+				}
+				continue
+			}
+
+			nextHop, err := r.getNextHop(key.node, ip.To4() == nil)
+			if err != nil {
+				p.addError("failed to get the next hop of node %s: %v", node.Name, err)
+				continue
+			}
+			route := routers.Route{DestinationCIDR: cidr, NextHop: nextHop}
+			p.Changes = append(p.Changes, RoutePlanChange{Action: routePlanActionAdd, Kind: routePlanKindRoute, DestinationCIDR: cidr, NextHop: nextHop, Node: node.Name})
+			r.planAddressPair(p, routePlanActionAdd, route, node.Name)
+		}
+	}
+
+	for key, routes := range existing {
+		if desired[key] {
+			continue
+		}
+		for _, route := range routes {
+			p.Changes = append(p.Changes, RoutePlanChange{Action: routePlanActionRemove, Kind: routePlanKindRoute, DestinationCIDR: route.DestinationCIDR, NextHop: route.NextHop, Node: string(key.node)})
+			r.planAddressPair(p, routePlanActionRemove, route, string(key.node))
+		}
+	}
+
+	sort.SliceStable(p.Changes, func(i, j int) bool {
+		a, b := p.Changes[i], p.Changes[j]
+		if a.DestinationCIDR != b.DestinationCIDR {
+			return a.DestinationCIDR < b.DestinationCIDR
+		}
+		if a.Kind != b.Kind {
+			return a.Kind > b.Kind
+		}
+		if a.Action != b.Action {
+			return a.Action > b.Action
+		}
+		return a.NextHop < b.NextHop
+	})
+	return p, nil
+}
+
+// planAddressPair plans the addition of the destination of the route to the
+// allowed address pairs of the port of its next hop, or its removal, if the
+// allowed address pairs are managed and the port does not have it already,
+// or has it.
+func (r *Routes) planAddressPair(p *RoutePlan, action string, route routers.Route, node string) {
+	if !r.managesAddressPairs() {
+		return
+	}
+
+	ports, err := openstackutil.GetPorts(r.network, r.addressPortsOpts(route.NextHop))
+	if err != nil {
+		p.addError("failed to get the port of address %s: %v", route.NextHop, err)
+		return
+	}
+	change := RoutePlanChange{Action: action, Kind: routePlanKindAddressPair, DestinationCIDR: route.DestinationCIDR, NextHop: route.NextHop, Node: node}
+	if action == routePlanActionAdd {
+		if len(ports) == 0 {
+			p.addError("no port has the address %s of node %s", route.NextHop, node)
+			return
+		}
+		if !portsHaveAddressPair(ports, route.DestinationCIDR) {
+			change.PortID = ports[0].ID
+			p.Changes = append(p.Changes, change)
+		}
+		return
+	}
+	for _, port := range ports {
+		if portsHaveAddressPair([]neutronports.Port{port}, route.DestinationCIDR) {
+			change.PortID = port.ID
+			p.Changes = append(p.Changes, change)
+		}
+	}
+}
+
+// serveRoutePlan serves the plan of the route changes as JSON. The
+// cluster-name query parameter selects the routes of the cluster when the
+// routes are tagged, as the --cluster-name flag of the controller manager.
+func (os *OpenStack) serveRoutePlan(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r, ok := os.Routes()
+	if !ok {
+		http.Error(w, "routes are not supported", http.StatusNotFound)
+		return
+	}
+	nodes, err := os.kclient.CoreV1().Nodes().List(req.Context(), metav1.ListOptions{})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list nodes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	clusterName := req.URL.Query().Get("cluster-name")
+	if clusterName == "" {
+		clusterName = defaultRoutePlanClusterName
+	}
+	p, err := r.(*Routes).plan(req.Context(), clusterName, nodes.Items)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to plan the routes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		klog.Errorf("Failed to write route plan: %v", err)
+	}
+}
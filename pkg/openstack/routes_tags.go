@@ -0,0 +1,103 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	neutrontags "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"k8s.io/cloud-provider-openstack/pkg/metrics"
+	cpoutil "k8s.io/cloud-provider-openstack/pkg/util"
+)
+
+// routeTagPrefix is the prefix of the tags marking the routes created for a
+// cluster on the routers or subnets holding them
+const routeTagPrefix = "k8s-route-"
+
+// routeTag returns the tag marking the route as created for the cluster.
+// The route is hashed, as the Neutron tags are limited to 60 characters.
+func routeTag(clusterName string, route routers.Route) string {
+	sum := sha256.Sum256([]byte(clusterName + "," + route.DestinationCIDR + "," + route.NextHop))
+	return routeTagPrefix + hex.EncodeToString(sum[:])[:40]
+}
+
+// tagsRoutes reports whether the routes created for the cluster are marked
+// with tags, and the other routes ignored. The noop-audit backend only holds
+// the routes it created.
+func (r *Routes) tagsRoutes() bool {
+	return r.opts.TagRoutes && r.backend.name() != routeBackendNoopAudit
+}
+
+// listRoutesAndTags returns the routes, and the tags of the resources
+// holding them if the routes are tagged.
+func (r *Routes) listRoutesAndTags() ([]routers.Route, sets.String, error) {
+	if !r.tagsRoutes() {
+		routes, err := r.backend.listRoutes()
+		return routes, nil, err
+	}
+
+	resources, err := r.backend.listResources()
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := sets.NewString()
+	for _, res := range resources {
+		tags.Insert(res.tags...)
+	}
+	return resourceRoutes(resources), tags, nil
+}
+
+// tagRoute adds the tag of the route for the cluster to the resource holding
+// the route, or removes it from the resources having it.
+func (r *Routes) tagRoute(clusterName string, route routers.Route, remove bool) error {
+	resources, err := r.backend.listResources()
+	if err != nil {
+		return err
+	}
+
+	tag := routeTag(clusterName, route)
+	for _, res := range resources {
+		tagged := cpoutil.Contains(res.tags, tag)
+		switch {
+		case remove && tagged:
+			klog.V(4).Infof("Removing tag %s of the route to %s through %s from %s %s", tag, route.DestinationCIDR, route.NextHop, res.resourceType, res.id)
+			mc := metrics.NewMetricContext("tag", "delete")
+			if err := mc.ObserveRequest(neutrontags.Delete(r.network, res.resourceType, res.id, tag).ExtractErr()); err != nil {
+				return err
+			}
+		case !remove && !tagged && routesContain(res.routes, route):
+			klog.V(4).Infof("Tagging the route to %s through %s on %s %s with %s", route.DestinationCIDR, route.NextHop, res.resourceType, res.id, tag)
+			mc := metrics.NewMetricContext("tag", "add")
+			return mc.ObserveRequest(neutrontags.Add(r.network, res.resourceType, res.id, tag).ExtractErr())
+		}
+	}
+	return nil
+}
+
+func routesContain(routes []routers.Route, route routers.Route) bool {
+	for _, item := range routes {
+		if item == route {
+			return true
+		}
+	}
+	return false
+}
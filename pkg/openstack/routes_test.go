@@ -18,14 +18,32 @@ package openstack
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	neutronports "github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	th "github.com/gophercloud/gophercloud/testhelper"
+	fakeclient "github.com/gophercloud/gophercloud/testhelper/client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/cloud-provider-openstack/pkg/client"
+	"k8s.io/cloud-provider-openstack/pkg/util"
 )
 
 func TestRoutes(t *testing.T) {
@@ -125,3 +143,1377 @@ func getRouters(os *OpenStack) []routers.Router {
 	}
 	return allRouters
 }
+
+func TestAuditNode(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("fixed_ips") {
+		case "ip_address=10.0.0.5":
+			fmt.Fprint(w, `{"ports": [{"id": "port1", "allowed_address_pairs": [{"ip_address": "10.244.1.0/24"}]}]}`)
+		case "ip_address=fd00::5":
+			fmt.Fprint(w, `{"ports": [{"id": "port1", "allowed_address_pairs": []}]}`)
+		default:
+			fmt.Fprint(w, `{"ports": []}`)
+		}
+	})
+
+	r := &Routes{network: fakeclient.ServiceClient(), opts: RouterOpts{ManageAllowedAddressPairs: true}}
+	r.backend = &extraRouteBackend{r: r}
+	node := &corev1.Node{
+		Spec: corev1.NodeSpec{PodCIDRs: []string{"10.244.1.0/24", "fd00:244:1::/64"}},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeExternalIP, Address: "172.24.4.5"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+			{Type: corev1.NodeInternalIP, Address: "fd00::5"},
+		}},
+	}
+	nextHops := map[string][]string{"10.244.1.0/24": {"10.0.0.5"}}
+
+	d, err := r.auditNode(node, nextHops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the IPv6 pod CIDR has neither a route nor an address pair
+	if d != (routeDivergence{routes: 1, addressPairs: 1}) {
+		t.Errorf("unexpected divergence %+v", d)
+	}
+
+	// A route through another next hop does not count
+	nextHops["10.244.1.0/24"] = []string{"10.0.0.6"}
+	node.Spec.PodCIDRs = []string{"10.244.1.0/24"}
+	d, err = r.auditNode(node, nextHops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != (routeDivergence{routes: 1}) {
+		t.Errorf("unexpected divergence %+v", d)
+	}
+
+	// The address pairs are not audited when they are not managed
+	r.opts.ManageAllowedAddressPairs = false
+	node.Spec.PodCIDRs = []string{"10.244.1.0/24", "fd00:244:1::/64"}
+	d, err = r.auditNode(node, nextHops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != (routeDivergence{routes: 2}) {
+		t.Errorf("unexpected divergence %+v", d)
+	}
+}
+
+func TestRouteBackendNetworkExtensions(t *testing.T) {
+	tests := []struct {
+		opts     RouterOpts
+		expected []string
+	}{
+		{RouterOpts{ManageAllowedAddressPairs: true}, []string{"router", "extraroute", "allowed-address-pairs"}},
+		{RouterOpts{}, []string{"router", "extraroute"}},
+		{RouterOpts{Backend: routeBackendSubnetHostRoutes, ManageAllowedAddressPairs: true}, []string{"allowed-address-pairs"}},
+		{RouterOpts{Backend: routeBackendSubnetHostRoutes}, nil},
+		{RouterOpts{Backend: routeBackendNoopAudit, ManageAllowedAddressPairs: true}, nil},
+		{RouterOpts{TagRoutes: true}, []string{"router", "extraroute", "standard-attr-tag"}},
+		{RouterOpts{Backend: routeBackendNoopAudit, TagRoutes: true}, nil},
+	}
+	for _, test := range tests {
+		if exts := routeBackendNetworkExtensions(test.opts); !reflect.DeepEqual(exts, test.expected) {
+			t.Errorf("expected the extensions %v for %+v, got %v", test.expected, test.opts, exts)
+		}
+	}
+}
+
+func TestRouteBackendName(t *testing.T) {
+	tests := []struct {
+		backend  string
+		expected string
+		ok       bool
+	}{
+		{"", routeBackendExtraRoute, true},
+		{routeBackendExtraRoute, routeBackendExtraRoute, true},
+		{routeBackendSubnetHostRoutes, routeBackendSubnetHostRoutes, true},
+		{routeBackendNoopAudit, routeBackendNoopAudit, true},
+		{"unknown", "", false},
+	}
+	for _, test := range tests {
+		name, ok := routeBackendName(RouterOpts{Backend: test.backend})
+		if name != test.expected || ok != test.ok {
+			t.Errorf("expected the name %q, %v for the backend %q, got %q, %v", test.expected, test.ok, test.backend, name, ok)
+		}
+	}
+}
+
+func TestGetRouterIDForSubnet(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("device_id") {
+		case "router-a":
+			fmt.Fprint(w, `{"ports": [{"id": "port-a", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.1"}]}]}`)
+		case "router-b":
+			fmt.Fprint(w, `{"ports": [{"id": "port-b", "fixed_ips": [{"subnet_id": "subnet-b", "ip_address": "10.0.1.1"}]}]}`)
+		default:
+			fmt.Fprint(w, `{"ports": []}`)
+		}
+	})
+
+	r := &Routes{network: fakeclient.ServiceClient(), opts: RouterOpts{RouterID: "router-a", AdditionalRouterIDs: []string{"router-b"}}}
+	for subnetID, expected := range map[string]string{
+		"subnet-a": "router-a",
+		"subnet-b": "router-b",
+		// No router has an interface on the subnet
+		"subnet-c": "router-a",
+	} {
+		routerID, err := r.getRouterIDForSubnet(subnetID, "10.0.0.5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if routerID != expected {
+			t.Errorf("expected router %s for subnet %s, got %s", expected, subnetID, routerID)
+		}
+	}
+
+	if ids := r.routerIDs(); len(ids) != 2 || ids[1] != "router-b" {
+		t.Errorf("unexpected router IDs %v", ids)
+	}
+}
+
+func TestGetRouterIDForPortOnSegment(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	subnetSegments := map[string]string{
+		"subnet-1a": "segment-1",
+		"subnet-1b": "segment-1",
+		"subnet-2":  "segment-2",
+		"subnet-3":  "segment-3",
+		"subnet-4":  "segment-4",
+		"subnet-5":  "",
+	}
+	subnetGets := make(map[string]int)
+	th.Mux.HandleFunc("/subnets/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/subnets/")
+		segmentID, ok := subnetSegments[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		subnetGets[id]++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"subnet": {"id": %q, "segment_id": %q}}`, id, segmentID)
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("device_id") {
+		case "router-main":
+			// On segment 1 through another subnet than the next hops
+			fmt.Fprint(w, `{"ports": [{"id": "port-main", "fixed_ips": [{"subnet_id": "subnet-1b", "ip_address": "10.1.1.1"}]}]}`)
+		case "router-2":
+			fmt.Fprint(w, `{"ports": [{"id": "port-2", "fixed_ips": [{"subnet_id": "subnet-2", "ip_address": "10.2.0.1"}]}]}`)
+		case "router-3":
+			// Not on segment 3 it is configured for
+			fmt.Fprint(w, `{"ports": [{"id": "port-3", "fixed_ips": [{"subnet_id": "subnet-5", "ip_address": "10.5.0.1"}]}]}`)
+		default:
+			fmt.Fprint(w, `{"ports": []}`)
+		}
+	})
+
+	r := &Routes{network: fakeclient.ServiceClient(), opts: RouterOpts{
+		RouterID: "router-main",
+		SegmentRouters: map[string]*RouteSegment{
+			"segment-2": {RouterID: "router-2"},
+			"segment-3": {RouterID: "router-3"},
+		},
+	}}
+	nodePort := func(subnetID, addr string) *neutronports.Port {
+		return &neutronports.Port{ID: "node-port", FixedIPs: []neutronports.IP{{SubnetID: subnetID, IPAddress: addr}}}
+	}
+
+	tests := []struct {
+		name          string
+		port          *neutronports.Port
+		addr          string
+		expected      string
+		expectedError bool
+	}{
+		{name: "router on another subnet of the segment", port: nodePort("subnet-1a", "10.1.0.5"), addr: "10.1.0.5", expected: "router-main"},
+		{name: "router of the segment", port: nodePort("subnet-2", "10.2.0.5"), addr: "10.2.0.5", expected: "router-2"},
+		{name: "router of the segment without interface on it", port: nodePort("subnet-3", "10.3.0.5"), addr: "10.3.0.5", expectedError: true},
+		{name: "next hop on another segment than the router", port: nodePort("subnet-4", "10.4.0.5"), addr: "10.4.0.5", expectedError: true},
+		{name: "next hop not on a routed provider network", port: nodePort("subnet-5", "10.5.0.5"), addr: "10.5.0.5", expected: "router-main"},
+		{name: "next hop not on the port", port: nodePort("subnet-4", "10.4.0.5"), addr: "10.9.0.5", expected: "router-main"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routerID, err := r.getRouterIDForPort(test.port, test.addr)
+			if test.expectedError {
+				if err == nil {
+					t.Errorf("expected an error, got router %s", routerID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if routerID != test.expected {
+				t.Errorf("expected router %s, got %s", test.expected, routerID)
+			}
+		})
+	}
+
+	// The segments of the subnets are cached
+	segments := make(map[string]string)
+	before := subnetGets["subnet-1a"]
+	for i := 0; i < 2; i++ {
+		segmentID, err := getSubnetSegmentID(r.network, "subnet-1a", segments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if segmentID != "segment-1" {
+			t.Errorf("expected segment-1, got %s", segmentID)
+		}
+	}
+	if subnetGets["subnet-1a"] != before+1 {
+		t.Errorf("expected the segment of subnet-1a to be cached, got %d requests", subnetGets["subnet-1a"]-before)
+	}
+
+	attached, err := isRouterOnSegment(r.network, "router-2", "subnet-1a", "segment-1", segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attached {
+		t.Errorf("expected router-2 not to be on segment-1")
+	}
+}
+
+func TestDiscoverRouterIDs(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var requests []string
+	// The servers of the project which are not nodes, e.g. server-c behind
+	// router-c, are not looked up
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("name") != "^node-b$" {
+			fmt.Fprint(w, `{"servers": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"servers": [{"id": "server-b", "name": "node-b"}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"server": {"id": "server-a", "name": "node-a"}}`)
+	})
+	for id, addr := range map[string]string{"server-a": "10.0.0.5", "server-b": "10.0.0.6"} {
+		id, addr := id, addr
+		th.Mux.HandleFunc("/servers/"+id+"/os-interface", func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"interfaceAttachments": [{"port_id": "port-%s", "port_state": "ACTIVE", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": %q}]}]}`, id, addr)
+		})
+	}
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("fixed_ips") != "subnet_id=subnet-a" {
+			fmt.Fprint(w, `{"ports": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"ports": [
+			{"id": "port-a", "device_id": "server-a", "device_owner": "compute:nova"},
+			{"id": "port-b", "device_id": "router-a", "device_owner": "network:router_interface"},
+			{"id": "port-c", "device_id": "router-b", "device_owner": "network:router_gateway"}
+		]}`)
+	})
+
+	// node-b is not initialized yet, its server is found by name
+	kclient := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}, Spec: corev1.NodeSpec{ProviderID: "openstack:///server-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+	)
+	d := &routerDiscovery{}
+	routerID, err := d.resolve(fakeclient.ServiceClient(), fakeclient.ServiceClient(), kclient, nil, RouterOpts{DiscoverRouter: true}, NetworkingOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routerID != "router-a" {
+		t.Errorf("expected router-a to be discovered, got %s", routerID)
+	}
+	expected := []string{"/servers/server-a", "/servers/server-a/os-interface", "/servers/detail?name=%5Enode-b%24", "/servers/server-b/os-interface"}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected the requests %v, got %v", expected, requests)
+	}
+
+	// The router is only discovered once
+	requests = nil
+	routerID, err = d.resolve(fakeclient.ServiceClient(), fakeclient.ServiceClient(), kclient, nil, RouterOpts{DiscoverRouter: true}, NetworkingOpts{})
+	if err != nil || routerID != "router-a" {
+		t.Errorf("expected router-a to be kept, got %s: %v", routerID, err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no request, got %v", requests)
+	}
+}
+
+func TestDiscoverRouterIDsWithoutNodes(t *testing.T) {
+	d := &routerDiscovery{}
+	routerID, err := d.resolve(fakeclient.ServiceClient(), fakeclient.ServiceClient(), fake.NewSimpleClientset(), nil, RouterOpts{DiscoverRouter: true, RouterID: "router-z"}, NetworkingOpts{})
+	if err != nil || routerID != "router-z" {
+		t.Errorf("expected router-id to be used, got %s: %v", routerID, err)
+	}
+	// router-id is not kept, the router is discovered once the nodes are registered
+	if d.routerID != "" {
+		t.Errorf("expected no router to be kept, got %s", d.routerID)
+	}
+}
+
+func TestCheckRouterProjects(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"router": {"id": "router-a", "project_id": "admin-project"}}`)
+	})
+	th.Mux.HandleFunc("/routers/router-b", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	r := &Routes{network: fakeclient.ServiceClient(), opts: RouterOpts{RouterID: "router-a", RouterProjectID: "admin-project"}}
+	if err := r.checkRouterProjects(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	r.opts.RouterProjectID = "other-project"
+	if err := r.checkRouterProjects(); err == nil {
+		t.Error("expected an error for a router of another project")
+	}
+
+	r.opts.RouterID = "router-b"
+	if err := r.checkRouterProjects(); err == nil || !strings.Contains(err.Error(), "admin credentials") {
+		t.Errorf("expected an error requiring admin credentials, got %v", err)
+	}
+
+	r.projectID = "cluster-project"
+	if opts := r.addressPortsOpts("10.0.0.5"); opts.ProjectID != "cluster-project" {
+		t.Errorf("expected the port lookups to be scoped to the project of the nodes, got %q", opts.ProjectID)
+	}
+}
+
+func TestRoutesWithContext(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request with a cancelled context")
+	})
+
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{RouterID: "router-a", RequestTimeout: util.MyDuration{Duration: time.Minute}},
+		batcher: newRouteBatcher(fakeclient.ServiceClient(), time.Hour),
+	}
+	backend, err := newRouteBackend(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.backend = backend
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := r.ListRoutes(ctx, "kubernetes"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the routes not to be listed with a cancelled context, got %v", err)
+	}
+	if _, err := r.batcher.apply(ctx, "router-a", routers.Route{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}, false); err != context.Canceled {
+		t.Errorf("expected the route change not to be waited for with a cancelled context, got %v", err)
+	}
+
+	rc := r.withContext(ctx)
+	if rc.network.Context != ctx || rc.network.HTTPClient.Timeout != time.Minute {
+		t.Errorf("expected the network client to be bound to the context with a timeout")
+	}
+	if r.network.Context != nil || r.network.HTTPClient.Timeout != 0 {
+		t.Errorf("expected the network client of the routes to be unchanged")
+	}
+	if rc.backend.(*extraRouteBackend).r != rc {
+		t.Errorf("expected the backend to use the bound routes")
+	}
+}
+
+func TestRouteBatcher(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var mu sync.Mutex
+	revision := 1
+	routes := []routers.Route{{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}}
+	updates, conflicts := 0, 1
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodPut {
+			if r.Header.Get("If-Match") != fmt.Sprintf("revision_number=%d", revision) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			var body struct {
+				Router struct {
+					Routes []routers.Route `json:"routes"`
+				} `json:"router"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			routes = body.Router.Routes
+			revision++
+			updates++
+		}
+
+		// Another client updates the router before the first update
+		rev := revision
+		if conflicts > 0 && r.Method == http.MethodGet {
+			conflicts--
+			revision++
+		}
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": routes, "revision_number": rev}})
+		w.Write(data)
+	})
+
+	b := newRouteBatcher(fakeclient.ServiceClient(), 50*time.Millisecond)
+	added := []routers.Route{
+		{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"},
+		{DestinationCIDR: "10.244.2.0/24", NextHop: "10.0.0.6"},
+	}
+
+	var wg sync.WaitGroup
+	for _, route := range added {
+		wg.Add(1)
+		go func(route routers.Route) {
+			defer wg.Done()
+			if unchanged, err := b.apply(context.TODO(), "router-a", route, false); err != nil || unchanged {
+				t.Errorf("failed to add route %v: %v, %v", route, unchanged, err)
+			}
+		}(route)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := b.apply(context.TODO(), "router-a", routers.Route{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}, true); err != nil {
+			t.Errorf("failed to remove route: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	// The changes are applied by a single update, after the conflict
+	if updates != 1 {
+		t.Errorf("expected a single router update, got %d", updates)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].DestinationCIDR < routes[j].DestinationCIDR })
+	if !reflect.DeepEqual(routes, added) {
+		t.Errorf("unexpected routes %v", routes)
+	}
+
+	// An existing route is not added again
+	if unchanged, err := b.apply(context.TODO(), "router-a", added[0], false); err != nil || !unchanged {
+		t.Errorf("expected the existing route to be left unchanged, got %v, %v", unchanged, err)
+	}
+}
+
+func TestRouteBatcherConcurrentUpdates(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var mu sync.Mutex
+	routes := []routers.Route{{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}}
+	// A route added by another client
+	other := routers.Route{DestinationCIDR: "10.244.9.0/24", NextHop: "10.0.0.9"}
+	overwrites := 0
+	decode := func(r *http.Request) []routers.Route {
+		var body struct {
+			Router struct {
+				Routes []routers.Route `json:"routes"`
+			} `json:"router"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		return body.Router.Routes
+	}
+	write := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": routes}})
+		w.Write(data)
+	}
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPut {
+			// Neutron ignores If-Match, and another client overwrites the
+			// update with the routes it read before
+			routes = decode(r)
+			if overwrites > 0 {
+				overwrites--
+				routes = []routers.Route{{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}, other}
+			}
+		}
+		write(w)
+	})
+	th.Mux.HandleFunc("/routers/router-a/add_extraroutes", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		routes = append(routes, decode(r)...)
+		write(w)
+	})
+	th.Mux.HandleFunc("/routers/router-a/remove_extraroutes", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		removed := decode(r)
+		var kept []routers.Route
+		for _, route := range routes {
+			if !routesContain(removed, route) {
+				kept = append(kept, route)
+			}
+		}
+		routes = kept
+		write(w)
+	})
+
+	added := routers.Route{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}
+	b := newRouteBatcher(fakeclient.ServiceClient(), 0)
+	b.verify = true
+	overwrites = 1
+	if unchanged, err := b.apply(context.TODO(), "router-a", added, false); err != nil || unchanged {
+		t.Fatalf("failed to add route %v: %v, %v", added, unchanged, err)
+	}
+	if !routesContain(routes, added) || !routesContain(routes, other) {
+		t.Errorf("expected the overwritten route to be added again, got %v", routes)
+	}
+
+	// The routes of the other clients are left as they are
+	b = newRouteBatcher(fakeclient.ServiceClient(), 0)
+	b.atomic = true
+	if _, err := b.apply(context.TODO(), "router-a", added, true); err != nil {
+		t.Fatal(err)
+	}
+	expected := []routers.Route{{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}, other}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected the routes %v, got %v", expected, routes)
+	}
+}
+
+func TestRouteBatcherAtomicUnsupported(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	routes := []routers.Route{{DestinationCIDR: "10.244.0.0/24", NextHop: "10.0.0.4"}}
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var body struct {
+				Router struct {
+					Routes []routers.Route `json:"routes"`
+				} `json:"router"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			routes = body.Router.Routes
+		}
+		w.Header().Set("Content-Type", "application/json")
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": routes}})
+		w.Write(data)
+	})
+	// The extension is listed, but the actions are unknown to the backend
+	th.Mux.HandleFunc("/routers/router-a/add_extraroutes", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"NeutronError": {"type": "HTTPNotFound", "message": "The resource could not be found."}}`)
+	})
+
+	added := routers.Route{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}
+	b := newRouteBatcher(fakeclient.ServiceClient(), 0)
+	b.atomic = true
+	if _, err := b.apply(context.TODO(), "router-a", added, false); err != nil {
+		t.Fatal(err)
+	}
+	if !routesContain(routes, added) {
+		t.Errorf("expected the route %v to be added, got %v", added, routes)
+	}
+	if b.isAtomic() {
+		t.Error("expected the atomic updates to be disabled")
+	}
+
+	// A missing router does not disable them
+	notFound := gophercloud.ErrDefault404{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Body: []byte(`{"NeutronError": {"type": "RouterNotFound"}}`)}}
+	if isExtraRoutesUnsupported(notFound) {
+		t.Error("expected a missing router not to be reported")
+	}
+}
+
+func TestRouteNodeCache(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	lists := 0
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		lists++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "server-a", "name": "node-a", "addresses": {"net-b-private": [{"addr": "10.0.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": [{"port_id": "port-a", "port_state": "ACTIVE", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.5"}]}]}`)
+	})
+	th.Mux.HandleFunc("/ports/port-a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"port": {"id": "port-a", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.5"}]}}`)
+	})
+
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		cache:   newRouteNodeCache(time.Hour),
+	}
+
+	sa, err := r.getServerAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa.nodeNames["10.0.0.5"] != "node-a" || sa.subnetIDs["10.0.0.5"] != "subnet-a" {
+		t.Errorf("unexpected server addresses %v", sa)
+	}
+
+	addr, err := r.getNextHop("node-a", false)
+	if err != nil || addr != "10.0.0.5" {
+		t.Errorf("unexpected address of node-a %q: %v", addr, err)
+	}
+	port, err := r.getPortByIP("node-a", addr)
+	if err != nil || port.ID != "port-a" {
+		t.Errorf("unexpected port of node-a %v: %v", port, err)
+	}
+	if lists != 1 {
+		t.Errorf("expected the servers to be listed once, got %d", lists)
+	}
+
+	r.cache.invalidate()
+	if _, err := r.getServerAddresses(); err != nil {
+		t.Fatal(err)
+	}
+	if lists != 2 {
+		t.Errorf("expected the servers to be listed again after the invalidation, got %d", lists)
+	}
+}
+
+func TestRouteNextHop(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "server-a", "name": "node-a", "addresses": {"net-a-storage": [{"addr": "192.168.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}], "net-b-private": [{"addr": "10.0.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": []}`)
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("device_id") != "router-a" {
+			fmt.Fprint(w, `{"ports": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"ports": [{"id": "port-r", "fixed_ips": [{"subnet_id": "subnet-private", "ip_address": "10.0.0.1"}]}]}`)
+	})
+	th.Mux.HandleFunc("/subnets/subnet-private", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"subnet": {"id": "subnet-private", "cidr": "10.0.0.0/24"}}`)
+	})
+
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{RouterID: "router-a"},
+	}
+	r.backend = &extraRouteBackend{r: r}
+
+	// The address on the subnet of the router is the next hop, whatever the
+	// order of the networks
+	addr, err := r.getNextHop("node-a", false)
+	if err != nil || addr != "10.0.0.5" {
+		t.Errorf("unexpected next hop of node-a %q: %v", addr, err)
+	}
+
+	// The annotation of the node overrides it
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node-a",
+		Annotations: map[string]string{AnnotationRouteNextHop: "fd00::5, 192.168.0.5"},
+	}}
+	r.kclient = fake.NewSimpleClientset(node)
+	addr, err = r.getNextHop("node-a", false)
+	if err != nil || addr != "192.168.0.5" {
+		t.Errorf("unexpected next hop of node-a %q: %v", addr, err)
+	}
+
+	// An annotation which is not an address of the node is refused
+	node.Annotations[AnnotationRouteNextHop] = "10.0.0.6"
+	r.kclient = fake.NewSimpleClientset(node)
+	if addr, err = r.getNextHop("node-a", false); err == nil {
+		t.Errorf("expected an error for the next hop %q not of the node", addr)
+	}
+
+	if hops := nodeNextHops(node, false); !reflect.DeepEqual(hops, []string{"10.0.0.6"}) {
+		t.Errorf("unexpected next hops %v", hops)
+	}
+}
+
+func TestGetPortByIPNeutronLookup(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "server-a", "name": "node-a"}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to list the interfaces of the server")
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("device_id") != "server-a" || q.Get("fixed_ips") != "ip_address=10.0.0.5" {
+			t.Errorf("unexpected ports query %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ports": [{"id": "port-a", "device_id": "server-a", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.5"}]}]}`)
+	})
+
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{NeutronPortLookup: true},
+	}
+
+	port, err := r.getPortByIP("node-a", "10.0.0.5")
+	if err != nil || port.ID != "port-a" {
+		t.Errorf("unexpected port of node-a %v: %v", port, err)
+	}
+}
+
+func TestUpdateAllowedAddressPairDualStack(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var mu sync.Mutex
+	revision := 1
+	pairs := []neutronports.AddressPair{}
+	th.Mux.HandleFunc("/ports/port-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodPut {
+			if r.Header.Get("If-Match") != fmt.Sprintf("revision_number=%d", revision) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			var body struct {
+				Port struct {
+					AllowedAddressPairs []neutronports.AddressPair `json:"allowed_address_pairs"`
+				} `json:"port"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			pairs = body.Port.AllowedAddressPairs
+			revision++
+		}
+
+		data, _ := json.Marshal(map[string]interface{}{"port": map[string]interface{}{"id": "port-a", "allowed_address_pairs": pairs, "revision_number": revision}})
+		w.Write(data)
+	})
+
+	// The address pairs of the IPv4 and IPv6 pod CIDRs of the node are
+	// added concurrently
+	r := &Routes{network: fakeclient.ServiceClient()}
+	cidrs := []string{"10.244.1.0/24", "fd00:10:244:1::/64"}
+	var wg sync.WaitGroup
+	for _, cidr := range cidrs {
+		wg.Add(1)
+		go func(cidr string) {
+			defer wg.Done()
+			if unwind, err := r.updateAllowedAddressPair("port-a", cidr, false); err != nil || unwind == nil {
+				t.Errorf("failed to add the address pair of %s: %v", cidr, err)
+			}
+		}(cidr)
+	}
+	wg.Wait()
+
+	var got []string
+	for _, pair := range pairs {
+		got = append(got, pair.IPAddress)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, cidrs) {
+		t.Errorf("expected the address pairs %v, got %v", cidrs, got)
+	}
+
+	// The unwind of the IPv6 pair keeps the IPv4 pair
+	unwind, err := r.updateAllowedAddressPair("port-a", "fd00:10:244:2::/64", false)
+	if err != nil || unwind == nil {
+		t.Fatalf("failed to add the address pair: %v", err)
+	}
+	unwind()
+	if len(pairs) != 2 {
+		t.Errorf("unexpected address pairs after the unwind %v", pairs)
+	}
+}
+
+func TestSubnetHostRoutesBackend(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "server-a", "name": "node-a", "addresses": {"net-b-private": [{"addr": "10.0.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": [{"port_id": "port-a", "port_state": "ACTIVE", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.5"}]}]}`)
+	})
+	hostRoutes := []subnets.HostRoute{{DestinationCIDR: "192.168.0.0/16", NextHop: "10.0.0.1"}}
+	th.Mux.HandleFunc("/subnets/subnet-a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			var body struct {
+				Subnet struct {
+					HostRoutes []subnets.HostRoute `json:"host_routes"`
+				} `json:"subnet"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			hostRoutes = body.Subnet.HostRoutes
+		}
+		data, _ := json.Marshal(map[string]interface{}{"subnet": map[string]interface{}{"id": "subnet-a", "host_routes": hostRoutes}})
+		w.Write(data)
+	})
+
+	r := &Routes{compute: fakeclient.ServiceClient(), network: fakeclient.ServiceClient(), opts: RouterOpts{Backend: routeBackendSubnetHostRoutes}}
+	backend, err := newRouteBackend(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.backend = backend
+
+	route := routers.Route{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}
+	port := &neutronports.Port{ID: "port-a", FixedIPs: []neutronports.IP{{SubnetID: "subnet-a", IPAddress: "10.0.0.5"}}}
+	unwind, err := backend.addRoute(route, port)
+	if err != nil || unwind == nil {
+		t.Fatalf("failed to add the route: %v", err)
+	}
+
+	routes, err := backend.listRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []routers.Route{{DestinationCIDR: "192.168.0.0/16", NextHop: "10.0.0.1"}, route}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected the routes %v, got %v", expected, routes)
+	}
+
+	// The host routes not managed by Kubernetes are kept
+	if unwind, err := backend.removeRoute(route); err != nil || unwind == nil {
+		t.Fatalf("failed to remove the route: %v", err)
+	}
+	if len(hostRoutes) != 1 || hostRoutes[0].NextHop != "10.0.0.1" {
+		t.Errorf("unexpected host routes %v", hostRoutes)
+	}
+}
+
+func TestNoopAuditBackend(t *testing.T) {
+	r := &Routes{opts: RouterOpts{Backend: routeBackendNoopAudit}}
+	backend, err := newRouteBackend(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := routers.Route{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}
+	if unwind, err := backend.addRoute(route, nil); err != nil || unwind == nil {
+		t.Fatalf("failed to add the route: %v", err)
+	}
+	if unwind, _ := backend.addRoute(route, nil); unwind != nil {
+		t.Errorf("expected the existing route to be left unchanged")
+	}
+	routes, _ := backend.listRoutes()
+	if !reflect.DeepEqual(routes, []routers.Route{route}) {
+		t.Errorf("unexpected routes %v", routes)
+	}
+	if backend.managesAddressPairs() {
+		t.Errorf("expected the address pairs to be left unchanged")
+	}
+
+	r.opts.Backend = "unknown"
+	if _, err := newRouteBackend(r); err == nil {
+		t.Errorf("expected an error for an unknown backend")
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var mu sync.Mutex
+	// node-a is a node of the cluster, the server of node-b is left
+	// after its node was deleted, 10.0.0.7 was the address of a deleted
+	// server, 10.0.0.8 is a node of another cluster sharing the router and
+	// 10.0.0.9 is an appliance
+	routes := []routers.Route{
+		{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"},
+		{DestinationCIDR: "10.244.2.0/24", NextHop: "10.0.0.6"},
+		{DestinationCIDR: "10.244.3.0/24", NextHop: "10.0.0.7"},
+		{DestinationCIDR: "10.245.1.0/24", NextHop: "10.0.0.8"},
+		{DestinationCIDR: "192.168.0.0/16", NextHop: "10.0.0.9"},
+	}
+	ports := map[string]*neutronports.Port{
+		"port-a": {ID: "port-a", FixedIPs: []neutronports.IP{{IPAddress: "10.0.0.5"}}, AllowedAddressPairs: []neutronports.AddressPair{
+			{IPAddress: "10.244.1.0/24"}, {IPAddress: "10.244.9.0/24"}, {IPAddress: "10.0.0.100"}, {IPAddress: "172.16.0.0/24"},
+		}},
+		"port-b": {ID: "port-b", FixedIPs: []neutronports.IP{{IPAddress: "10.0.0.6"}}, AllowedAddressPairs: []neutronports.AddressPair{{IPAddress: "10.244.2.0/24"}}},
+		"port-c": {ID: "port-c", FixedIPs: []neutronports.IP{{IPAddress: "10.0.0.9"}}},
+		"port-d": {ID: "port-d", FixedIPs: []neutronports.IP{{IPAddress: "10.0.0.8"}}, AllowedAddressPairs: []neutronports.AddressPair{{IPAddress: "10.245.1.0/24"}}},
+	}
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [
+			{"id": "server-a", "name": "node-a", "addresses": {"private": [{"addr": "10.0.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}]}},
+			{"id": "server-b", "name": "node-b", "addresses": {"private": [{"addr": "10.0.0.6", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}
+		]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": [{"port_id": "port-a", "port_state": "ACTIVE", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.5"}]}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-b/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": [{"port_id": "port-b", "port_state": "ACTIVE", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.6"}]}]}`)
+	})
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			var body struct {
+				Router struct {
+					Routes []routers.Route `json:"routes"`
+				} `json:"router"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			routes = body.Router.Routes
+		}
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": routes}})
+		w.Write(data)
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		found := []*neutronports.Port{}
+		for _, port := range ports {
+			if r.URL.Query().Get("fixed_ips") == "ip_address="+port.FixedIPs[0].IPAddress {
+				found = append(found, port)
+			}
+		}
+		data, _ := json.Marshal(map[string]interface{}{"ports": found})
+		w.Write(data)
+	})
+	th.Mux.HandleFunc("/ports/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		port := ports[r.URL.Path[len("/ports/"):]]
+		if r.Method == http.MethodPut {
+			var body struct {
+				Port struct {
+					AllowedAddressPairs []neutronports.AddressPair `json:"allowed_address_pairs"`
+				} `json:"port"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			port.AllowedAddressPairs = body.Port.AllowedAddressPairs
+		}
+		data, _ := json.Marshal(map[string]interface{}{"port": port})
+		w.Write(data)
+	})
+
+	kclient := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Spec:       corev1.NodeSpec{PodCIDR: "10.244.1.0/24"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}}},
+	})
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{RouterID: "router-a", ManageAllowedAddressPairs: true, ClusterCIDRs: []string{"10.244.0.0/16"}},
+		batcher: newRouteBatcher(fakeclient.ServiceClient(), 0),
+	}
+	r.backend = &extraRouteBackend{r: r}
+
+	r.collectGarbage(context.TODO(), kclient)
+
+	expected := []routers.Route{
+		{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"},
+		{DestinationCIDR: "10.245.1.0/24", NextHop: "10.0.0.8"},
+		{DestinationCIDR: "192.168.0.0/16", NextHop: "10.0.0.9"},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected the routes %v, got %v", expected, routes)
+	}
+	expectedPairs := []neutronports.AddressPair{{IPAddress: "10.244.1.0/24"}, {IPAddress: "10.0.0.100"}, {IPAddress: "172.16.0.0/24"}}
+	if !reflect.DeepEqual(ports["port-a"].AllowedAddressPairs, expectedPairs) {
+		t.Errorf("expected the address pairs %v of port-a, got %v", expectedPairs, ports["port-a"].AllowedAddressPairs)
+	}
+	if len(ports["port-b"].AllowedAddressPairs) != 0 {
+		t.Errorf("expected the address pair of port-b to be removed, got %v", ports["port-b"].AllowedAddressPairs)
+	}
+	if len(ports["port-d"].AllowedAddressPairs) != 1 {
+		t.Errorf("expected the address pair of the other cluster to be kept, got %v", ports["port-d"].AllowedAddressPairs)
+	}
+}
+
+func TestRouteQuotaExceeded(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	var mu sync.Mutex
+	// 10.0.0.7 was the address of a deleted server, 10.0.0.254 is the
+	// address of a VPN gateway outside of Neutron
+	operator := routers.Route{DestinationCIDR: "192.168.50.0/24", NextHop: "10.0.0.254"}
+	routes := []routers.Route{
+		{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"},
+		{DestinationCIDR: "10.244.3.0/24", NextHop: "10.0.0.7"},
+		operator,
+	}
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			var body struct {
+				Router struct {
+					Routes []routers.Route `json:"routes"`
+				} `json:"router"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			if len(body.Router.Routes) > 3 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"NeutronError": {"type": "RoutesExhausted", "message": "Unable to complete operation for router-a. The number of routes exceeds the maximum 3.", "detail": ""}}`)
+				return
+			}
+			routes = body.Router.Routes
+		}
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": routes}})
+		w.Write(data)
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("fixed_ips") == "ip_address=10.0.0.5" {
+			fmt.Fprint(w, `{"ports": [{"id": "port-a", "fixed_ips": [{"ip_address": "10.0.0.5"}]}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"ports": []}`)
+	})
+
+	r := &Routes{
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{RouterID: "router-a", ClusterCIDRs: []string{"10.244.0.0/16"}},
+		batcher: newRouteBatcher(fakeclient.ServiceClient(), 0),
+		ctx:     context.TODO(),
+	}
+	r.backend = &extraRouteBackend{r: r}
+
+	added := routers.Route{DestinationCIDR: "10.244.2.0/24", NextHop: "10.0.0.6"}
+	if _, err := r.updateRoutes("router-a", added, false); !isRouteQuotaExceeded(err) {
+		t.Fatalf("expected the route quota to be exceeded, got %v", err)
+	}
+
+	// The route of the operator is not within the pod CIDRs of the cluster
+	pruned, err := r.pruneBlackholeRoutes("kubernetes")
+	if err != nil || pruned != 1 {
+		t.Fatalf("expected 1 blackhole route to be pruned, got %d: %v", pruned, err)
+	}
+	if _, err := r.updateRoutes("router-a", added, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []routers.Route{{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}, operator, added}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected the routes %v, got %v", expected, routes)
+	}
+}
+
+func TestTagRoutes(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	owned := routers.Route{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}
+	static := routers.Route{DestinationCIDR: "10.244.9.0/24", NextHop: "10.0.0.9"}
+
+	var mu sync.Mutex
+	tags := []string{"other", routeTag("kubernetes", owned)}
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": []routers.Route{owned, static}, "tags": tags}})
+		w.Write(data)
+	})
+	th.Mux.HandleFunc("/routers/router-a/tags/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tag := r.URL.Path[len("/routers/router-a/tags/"):]
+		switch r.Method {
+		case http.MethodPut:
+			tags = append(tags, tag)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			var kept []string
+			for _, t := range tags {
+				if t != tag {
+					kept = append(kept, t)
+				}
+			}
+			tags = kept
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	r := &Routes{network: fakeclient.ServiceClient(), opts: RouterOpts{RouterID: "router-a", TagRoutes: true}}
+	r.backend = &extraRouteBackend{r: r}
+
+	routes, found, err := r.listRoutesAndTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || !found.Has(routeTag("kubernetes", owned)) || found.Has(routeTag("kubernetes", static)) || found.Has(routeTag("other-cluster", owned)) {
+		t.Errorf("unexpected routes %v and tags %v", routes, found.List())
+	}
+
+	// The static route is adopted, then released
+	if err := r.tagRoute("kubernetes", static, false); err != nil {
+		t.Fatal(err)
+	}
+	if !util.Contains(tags, routeTag("kubernetes", static)) {
+		t.Errorf("expected the static route to be tagged, got the tags %v", tags)
+	}
+	if err := r.tagRoute("kubernetes", static, true); err != nil {
+		t.Fatal(err)
+	}
+	if util.Contains(tags, routeTag("kubernetes", static)) || len(tags) != 2 {
+		t.Errorf("expected the tag of the static route to be removed, got the tags %v", tags)
+	}
+}
+
+func TestRoutePodCIDRTrigger(t *testing.T) {
+	unassigned := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	assigned := unassigned.DeepCopy()
+	assigned.Spec.PodCIDRs = []string{"10.244.1.0/24"}
+	legacy := unassigned.DeepCopy()
+	legacy.Spec.PodCIDR = "10.244.1.0/24"
+
+	if !podCIDRsAssigned(unassigned, assigned) || !podCIDRsAssigned(unassigned, legacy) {
+		t.Errorf("expected the pod CIDRs to be assigned")
+	}
+	if podCIDRsAssigned(assigned, assigned) || podCIDRsAssigned(unassigned, unassigned) {
+		t.Errorf("expected the pod CIDRs not to be assigned")
+	}
+
+	// The routes are not created until the cluster name is known
+	kclient := fake.NewSimpleClientset(assigned)
+	trigger := newRoutePodCIDRTrigger(kclient, &Routes{})
+	trigger.createRoutes(context.TODO(), assigned)
+	if actions := kclient.Actions(); len(actions) != 0 {
+		t.Errorf("expected no change of the node, got %v", actions)
+	}
+
+	var disabled *routePodCIDRTrigger
+	disabled.setClusterName("kubernetes")
+	trigger.setClusterName("kubernetes")
+	if name := trigger.getClusterName(); name != "kubernetes" {
+		t.Errorf("expected the cluster name kubernetes, got %q", name)
+	}
+}
+
+func TestRoutePlan(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		servers := map[string]string{
+			"node-a": `{"id": "server-a", "name": "node-a", "addresses": {"private": [{"addr": "10.0.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}`,
+			"node-b": `{"id": "server-b", "name": "node-b", "addresses": {"private": [{"addr": "10.0.0.6", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}`,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if name := strings.Trim(r.URL.Query().Get("name"), "^$"); name != "" {
+			fmt.Fprintf(w, `{"servers": [%s]}`, servers[name])
+			return
+		}
+		fmt.Fprintf(w, `{"servers": [%s, %s]}`, servers["node-a"], servers["node-b"])
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": []}`)
+	})
+	th.Mux.HandleFunc("/servers/server-b/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": []}`)
+	})
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"router": {"id": "router-a", "routes": [
+			{"destination": "10.244.1.0/24", "nexthop": "10.0.0.5"},
+			{"destination": "10.244.9.0/24", "nexthop": "10.0.0.9"}
+		]}}`)
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("fixed_ips") {
+		case "ip_address=10.0.0.5":
+			fmt.Fprint(w, `{"ports": [{"id": "port-a", "allowed_address_pairs": [{"ip_address": "10.244.1.0/24"}]}]}`)
+		case "ip_address=10.0.0.6":
+			fmt.Fprint(w, `{"ports": [{"id": "port-b", "allowed_address_pairs": []}]}`)
+		case "ip_address=10.0.0.9":
+			fmt.Fprint(w, `{"ports": [{"id": "port-c", "allowed_address_pairs": [{"ip_address": "10.244.9.0/24"}]}]}`)
+		default:
+			fmt.Fprint(w, `{"ports": []}`)
+		}
+	})
+
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{RouterID: "router-a", ManageAllowedAddressPairs: true},
+		ctx:     context.Background(),
+	}
+	r.backend = &extraRouteBackend{r: r}
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}, Spec: corev1.NodeSpec{PodCIDR: "10.244.1.0/24"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}, Spec: corev1.NodeSpec{PodCIDR: "10.244.2.0/24"}},
+	}
+
+	p, err := r.plan(context.TODO(), "kubernetes", nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The route of node-a is kept, the route of node-b is added and the
+	// route through an address which is not of a node removed, with their
+	// allowed address pairs.
+	expected := []RoutePlanChange{
+		{Action: routePlanActionAdd, Kind: routePlanKindRoute, DestinationCIDR: "10.244.2.0/24", NextHop: "10.0.0.6", Node: "node-b"},
+		{Action: routePlanActionAdd, Kind: routePlanKindAddressPair, DestinationCIDR: "10.244.2.0/24", NextHop: "10.0.0.6", Node: "node-b", PortID: "port-b"},
+		{Action: routePlanActionRemove, Kind: routePlanKindRoute, DestinationCIDR: "10.244.9.0/24", NextHop: "10.0.0.9"},
+		{Action: routePlanActionRemove, Kind: routePlanKindAddressPair, DestinationCIDR: "10.244.9.0/24", NextHop: "10.0.0.9", PortID: "port-c"},
+	}
+	if !reflect.DeepEqual(p.Changes, expected) || len(p.Errors) > 0 {
+		t.Errorf("unexpected plan %+v", p)
+	}
+}
+
+func TestCollectGarbageTaggedRoutes(t *testing.T) {
+	th.SetupHTTP()
+	defer th.TeardownHTTP()
+
+	// 10.0.0.7 was the address of a deleted node of the cluster, 10.0.0.8
+	// the one of a deleted node of another cluster sharing the router
+	node := routers.Route{DestinationCIDR: "10.244.1.0/24", NextHop: "10.0.0.5"}
+	orphan := routers.Route{DestinationCIDR: "10.244.3.0/24", NextHop: "10.0.0.7"}
+	other := routers.Route{DestinationCIDR: "10.244.3.0/24", NextHop: "10.0.0.8"}
+
+	var mu sync.Mutex
+	routes := []routers.Route{node, orphan, other}
+	tags := []string{routeTag("kubernetes", node), routeTag("kubernetes", orphan), routeTag("other-cluster", other)}
+	th.Mux.HandleFunc("/servers/detail", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"servers": [{"id": "server-a", "name": "node-a", "addresses": {"private": [{"addr": "10.0.0.5", "version": 4, "OS-EXT-IPS:type": "fixed"}]}}]}`)
+	})
+	th.Mux.HandleFunc("/servers/server-a/os-interface", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"interfaceAttachments": [{"port_id": "port-a", "port_state": "ACTIVE", "fixed_ips": [{"subnet_id": "subnet-a", "ip_address": "10.0.0.5"}]}]}`)
+	})
+	th.Mux.HandleFunc("/ports", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ports": []}`)
+	})
+	th.Mux.HandleFunc("/routers/router-a", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			var body struct {
+				Router struct {
+					Routes []routers.Route `json:"routes"`
+				} `json:"router"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			routes = body.Router.Routes
+		}
+		data, _ := json.Marshal(map[string]interface{}{"router": map[string]interface{}{"id": "router-a", "routes": routes, "tags": tags}})
+		w.Write(data)
+	})
+	th.Mux.HandleFunc("/routers/router-a/tags/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tag := r.URL.Path[len("/routers/router-a/tags/"):]
+		var kept []string
+		for _, t := range tags {
+			if t != tag {
+				kept = append(kept, t)
+			}
+		}
+		tags = kept
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	kclient := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Spec:       corev1.NodeSpec{PodCIDR: "10.244.1.0/24"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}}},
+	})
+	r := &Routes{
+		compute: fakeclient.ServiceClient(),
+		network: fakeclient.ServiceClient(),
+		opts:    RouterOpts{RouterID: "router-a", TagRoutes: true},
+		batcher: newRouteBatcher(fakeclient.ServiceClient(), 0),
+		gc:      &routeGarbageCollector{},
+	}
+	r.backend = &extraRouteBackend{r: r}
+
+	// The tags of the cluster are not known until the routes are listed
+	r.collectGarbage(context.TODO(), kclient)
+	if len(routes) != 3 {
+		t.Errorf("expected the routes to be kept, got %v", routes)
+	}
+
+	r.gc.setClusterName("kubernetes")
+	r.collectGarbage(context.TODO(), kclient)
+	expected := []routers.Route{node, other}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected the routes %v, got %v", expected, routes)
+	}
+	expectedTags := []string{routeTag("kubernetes", node), routeTag("other-cluster", other)}
+	if !reflect.DeepEqual(tags, expectedTags) {
+		t.Errorf("expected the tags %v, got %v", expectedTags, tags)
+	}
+}
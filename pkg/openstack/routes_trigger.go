@@ -0,0 +1,119 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
+	nodeutil "k8s.io/component-helpers/node/util"
+	"k8s.io/klog/v2"
+)
+
+// routePodCIDRTrigger creates the routes of a node as soon as its pod CIDRs
+// are assigned, instead of waiting for the next reconciliation of the route
+// controller.
+type routePodCIDRTrigger struct {
+	kclient kubernetes.Interface
+	routes  *Routes
+
+	mu sync.Mutex
+	// clusterName is the name passed by the route controller, learnt from
+	// ListRoutes as the cloud provider is not told about it otherwise
+	clusterName string
+}
+
+func newRoutePodCIDRTrigger(kclient kubernetes.Interface, routes *Routes) *routePodCIDRTrigger {
+	return &routePodCIDRTrigger{kclient: kclient, routes: routes}
+}
+
+// setClusterName records the name of the cluster of the routes. It is safe
+// to call on a nil trigger.
+func (t *routePodCIDRTrigger) setClusterName(clusterName string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clusterName = clusterName
+}
+
+func (t *routePodCIDRTrigger) getClusterName() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clusterName
+}
+
+// onNodeUpdate is the node informer handler creating the routes of the nodes
+// which were just assigned pod CIDRs.
+func (t *routePodCIDRTrigger) onNodeUpdate(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	if podCIDRsAssigned(oldNode, newNode) {
+		go t.createRoutes(context.TODO(), newNode)
+	}
+}
+
+// podCIDRsAssigned reports whether the update of the node assigned its pod
+// CIDRs.
+func podCIDRsAssigned(oldNode, newNode *corev1.Node) bool {
+	return len(nodePodCIDRs(oldNode)) == 0 && len(nodePodCIDRs(newNode)) > 0
+}
+
+// createRoutes creates the routes to the pod CIDRs of the node and clears
+// its NodeNetworkUnavailable condition, as the route controller would.
+func (t *routePodCIDRTrigger) createRoutes(ctx context.Context, node *corev1.Node) {
+	clusterName := t.getClusterName()
+	if clusterName == "" {
+		klog.V(4).Infof("Not creating the routes of node %s yet, the routes were not listed by the route controller", node.Name)
+		return
+	}
+
+	for _, cidr := range nodePodCIDRs(node) {
+		route := &cloudprovider.Route{
+			TargetNode:      types.NodeName(node.Name),
+			DestinationCIDR: cidr,
+		}
+		if err := t.routes.CreateRoute(ctx, clusterName, string(node.UID), route); err != nil {
+			klog.Warningf("Failed to create the route to %s of node %s, the route controller will retry: %v", cidr, node.Name, err)
+			return
+		}
+	}
+	klog.V(2).Infof("Created the routes of node %s as its pod CIDRs were assigned", node.Name)
+
+	err := nodeutil.SetNodeCondition(t.kclient, types.NodeName(node.Name), corev1.NodeCondition{
+		Type:               corev1.NodeNetworkUnavailable,
+		Status:             corev1.ConditionFalse,
+		Reason:             "RouteCreated",
+		Message:            "RouteController created a route",
+		LastTransitionTime: metav1.Now(),
+	})
+	if err != nil {
+		klog.Warningf("Failed to update the network condition of node %s: %v", node.Name, err)
+	}
+}
@@ -21,6 +21,8 @@ package blockdevice
 
 import (
 	"errors"
+
+	"k8s.io/utils/exec"
 )
 
 func IsBlockDevice(path string) (bool, error) {
@@ -34,3 +36,7 @@ func GetBlockDeviceSize(path string) (int64, error) {
 func RescanBlockDeviceGeometry(devicePath string, deviceMountPath string, newSize int64) error {
 	return errors.New("RescanBlockDeviceGeometry is not implemented for this OS")
 }
+
+func ResizeDeviceStack(exec exec.Interface, devicePath string, deviceMountPath string, newSize int64, rescan bool) error {
+	return errors.New("ResizeDeviceStack is not implemented for this OS")
+}
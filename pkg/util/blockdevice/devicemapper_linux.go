@@ -0,0 +1,107 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blockdevice
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/exec"
+)
+
+// sysBlockPath is the sysfs directory of the block devices, changed by the tests
+var sysBlockPath = "/sys/block"
+
+// ResizeDeviceStack grows the device of the path to the size of the devices it
+// is stacked on. The device mapper devices, e.g. dm-crypt devices opened by
+// cryptsetup or multipath maps, do not grow with their underlying devices:
+// they are resized after them, with cryptsetup for dm-crypt and multipathd for
+// multipath. If rescan is set, the geometry of the underlying devices is
+// rescanned and checked to be at least newSize, as RescanBlockDeviceGeometry
+// does for a device which is not a device mapper device. The paths of the
+// multipath maps are always rescanned.
+func ResizeDeviceStack(exec exec.Interface, devicePath string, deviceMountPath string, newSize int64, rescan bool) error {
+	resolved, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		// Handled as a device which is not a device mapper device
+		klog.V(4).Infof("Failed to resolve device path %q: %v", devicePath, err)
+		resolved = devicePath
+	}
+	return resizeDevice(exec, filepath.Base(resolved), devicePath, deviceMountPath, newSize, rescan)
+}
+
+// resizeDevice resizes the block device of the kernel name, e.g. dm-0 or sdb,
+// after the devices it is stacked on.
+func resizeDevice(exec exec.Interface, name string, devicePath string, deviceMountPath string, newSize int64, rescan bool) error {
+	dmName, err := readSysBlockFile(name, "dm", "name")
+	if os.IsNotExist(err) {
+		if !rescan {
+			return nil
+		}
+		return RescanBlockDeviceGeometry(devicePath, deviceMountPath, newSize)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the device mapper name of %s: %v", name, err)
+	}
+	dmUUID, err := readSysBlockFile(name, "dm", "uuid")
+	if err != nil {
+		return fmt.Errorf("failed to read the device mapper UUID of %s: %v", name, err)
+	}
+
+	var cmd []string
+	switch {
+	case strings.HasPrefix(dmUUID, "CRYPT-"):
+		cmd = []string{"cryptsetup", "resize", dmName}
+	case strings.HasPrefix(dmUUID, "mpath-"):
+		cmd = []string{"multipathd", "resize", "map", dmName}
+	default:
+		// The filesystem would not grow, e.g. on an LVM logical volume
+		return fmt.Errorf("cannot resize device mapper device %s (%s), only dm-crypt and multipath devices are supported", dmName, dmUUID)
+	}
+
+	// The paths of a multipath map are always rescanned, multipathd only
+	// resizes the map to the size of its paths
+	rescanSlaves := rescan || strings.HasPrefix(dmUUID, "mpath-")
+	slaves, err := ioutil.ReadDir(filepath.Join(sysBlockPath, name, "slaves"))
+	if err != nil {
+		return fmt.Errorf("failed to list the devices under %s: %v", dmName, err)
+	}
+	for _, slave := range slaves {
+		if err := resizeDevice(exec, slave.Name(), filepath.Join("/dev", slave.Name()), deviceMountPath, newSize, rescanSlaves); err != nil {
+			return err
+		}
+	}
+
+	klog.V(3).Infof("Resizing device mapper device %s of %q", dmName, deviceMountPath)
+	out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to resize device mapper device %s with %s: %v, output: %s", dmName, strings.Join(cmd, " "), err, string(out))
+	}
+	return nil
+}
+
+func readSysBlockFile(name string, path ...string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(append([]string{sysBlockPath, name}, path...)...))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package blockdevice
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+// fakeSysBlock creates the sysfs directory of a device mapper device with its
+// underlying devices.
+func fakeSysBlock(t *testing.T, root, name, dmName, dmUUID string, slaves ...string) {
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Join(dir, "dm"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "slaves"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"name": dmName, "uuid": dmUUID} {
+		if err := os.WriteFile(filepath.Join(dir, "dm", file), []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, slave := range slaves {
+		if err := os.MkdirAll(filepath.Join(dir, "slaves", slave), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResizeDeviceStack(t *testing.T) {
+	root := t.TempDir()
+	sysBlockPath = root
+	defer func() { sysBlockPath = "/sys/block" }()
+
+	// A LUKS device opened on a multipath map of two paths
+	fakeSysBlock(t, root, "dm-1", "luks-pv", "CRYPT-LUKS2-0123-luks-pv", "dm-0")
+	fakeSysBlock(t, root, "dm-0", "mpatha", "mpath-3600a0980", "sda", "sdb")
+	fakeSysBlock(t, root, "dm-2", "vg-lv", "LVM-abcd", "vdb")
+
+	var commands []string
+	fakeExec := &testingexec.FakeExec{}
+	for i := 0; i < 2; i++ {
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) exec.Cmd {
+			commands = append(commands, strings.Join(append([]string{cmd}, args...), " "))
+			return &testingexec.FakeCmd{CombinedOutputScript: []testingexec.FakeAction{
+				func() ([]byte, []byte, error) { return nil, nil, nil },
+			}}
+		})
+	}
+
+	// The paths are not found in the fake sysfs and not rescanned without new size
+	if err := ResizeDeviceStack(fakeExec, "dm-1", "/mnt", 0, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"multipathd resize map mpatha", "cryptsetup resize luks-pv"}
+	if strings.Join(commands, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected commands %v, got %v", expected, commands)
+	}
+
+	if err := ResizeDeviceStack(fakeExec, "dm-2", "/mnt", 0, false); err == nil {
+		t.Error("expected an error resizing an LVM logical volume")
+	}
+
+	// Not a device mapper device
+	if err := ResizeDeviceStack(fakeExec, "vdb", "/mnt", 0, false); err != nil {
+		t.Error(err)
+	}
+}
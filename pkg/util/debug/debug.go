@@ -0,0 +1,210 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debug serves the pprof profiles and the log verbosity of the
+// long-running binaries on an endpoint authenticated by a bearer token, and
+// changes the log verbosity on SIGUSR1 and SIGUSR2.
+package debug
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+	"k8s.io/component-base/logs"
+	"k8s.io/klog/v2"
+)
+
+// maxVerbosity is the highest verbosity SIGUSR1 raises the log verbosity to.
+const maxVerbosity = 10
+
+// Options configures the debug endpoint.
+type Options struct {
+	// Address is the address of the debug endpoint, which is disabled if empty.
+	Address string
+	// TokenFile is the path to the file of the bearer token of the requests
+	// to the debug endpoint.
+	TokenFile string
+}
+
+// AddFlags adds the flags of the debug endpoint to the flag set.
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Address, "debug-address", "", "The address to serve the pprof profiles and the log verbosity on, e.g. 127.0.0.1:6060. Not served if empty.")
+	fs.StringVar(&o.TokenFile, "debug-token-file", "", "Path to the file of the bearer token the requests to --debug-address must be authenticated with. Required by --debug-address.")
+}
+
+var (
+	hooksMtx sync.Mutex
+	hooks    []func(level int)
+)
+
+// OnVerbosityChange registers a function called with the new log verbosity
+// when it is changed at runtime, e.g. to adjust the level of another logger.
+func OnVerbosityChange(f func(level int)) {
+	hooksMtx.Lock()
+	defer hooksMtx.Unlock()
+	hooks = append(hooks, f)
+}
+
+// Verbosity returns the current log verbosity.
+func Verbosity() int {
+	level := 0
+	for level < maxVerbosity && klog.V(klog.Level(level+1)).Enabled() {
+		level++
+	}
+	return level
+}
+
+// SetVerbosity sets the log verbosity.
+func SetVerbosity(level int) error {
+	if _, err := logs.GlogSetter(strconv.Itoa(level)); err != nil {
+		return err
+	}
+	klog.Infof("Log verbosity set to %d", level)
+
+	hooksMtx.Lock()
+	defer hooksMtx.Unlock()
+	for _, f := range hooks {
+		f(level)
+	}
+	return nil
+}
+
+// Start handles the verbosity signals, and serves the debug endpoint in the
+// background if an address is set.
+func Start(o Options) error {
+	HandleVerbositySignals()
+
+	if o.Address == "" {
+		return nil
+	}
+	if o.TokenFile == "" {
+		return fmt.Errorf("--debug-token-file is required by --debug-address")
+	}
+	token, err := ReadTokenFile(o.TokenFile)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		klog.Infof("Serving debug endpoint on %s", o.Address)
+		if err := http.ListenAndServe(o.Address, Handler(token)); err != nil {
+			klog.Errorf("Failed to serve debug endpoint: %v", err)
+		}
+	}()
+	return nil
+}
+
+// HandleVerbositySignals raises the log verbosity by one on SIGUSR1, and
+// restores the initial log verbosity on SIGUSR2.
+func HandleVerbositySignals() {
+	initial := Verbosity()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, unix.SIGUSR1, unix.SIGUSR2)
+
+	go func() {
+		for sig := range sigs {
+			level := initial
+			if sig == unix.SIGUSR1 {
+				if level = Verbosity() + 1; level > maxVerbosity {
+					continue
+				}
+			}
+			if err := SetVerbosity(level); err != nil {
+				klog.Errorf("Failed to set log verbosity to %d: %v", level, err)
+			}
+		}
+	}()
+}
+
+// Handler returns the handler of the debug endpoint, which requires the
+// bearer token:
+//
+//   - /debug/pprof/: the pprof profiles, including the execution trace.
+//   - /debug/flags/v: the log verbosity, GET to read it and PUT to set it.
+func Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/flags/v", verbosityHandler)
+
+	return WithBearerToken(mux, token)
+}
+
+// ReadTokenFile reads a bearer token from a file.
+func ReadTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file %s: %v", path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// WithBearerToken returns a handler serving the requests with handler only if
+// they are authenticated by the bearer token.
+func WithBearerToken(handler http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func verbosityHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		fmt.Fprintf(w, "%d\n", Verbosity())
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := strconv.Atoi(strings.TrimSpace(string(body)))
+		if err != nil || level < 0 {
+			http.Error(w, fmt.Sprintf("invalid log verbosity %q", body), http.StatusBadRequest)
+			return
+		}
+		if err := SetVerbosity(level); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "successfully set log verbosity to %d\n", level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
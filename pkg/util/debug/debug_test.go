@@ -0,0 +1,62 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	initial := Verbosity()
+	defer func() { _ = SetVerbosity(initial) }()
+
+	var hooked int
+	OnVerbosityChange(func(level int) { hooked = level })
+
+	handler := Handler("secret")
+	do := func(method, path, auth, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/debug/pprof/", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/debug/pprof/", "Bearer wrong", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/debug/pprof/", "Bearer secret", "").Code)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/debug/flags/v", "Bearer secret", "4").Code)
+	assert.Equal(t, 4, Verbosity())
+	assert.Equal(t, 4, hooked)
+	assert.Equal(t, "4\n", do(http.MethodGet, "/debug/flags/v", "Bearer secret", "").Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/debug/flags/v", "Bearer secret", "high").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPost, "/debug/flags/v", "Bearer secret", "").Code)
+}
+
+func TestStart(t *testing.T) {
+	assert.NoError(t, Start(Options{}))
+	assert.Error(t, Start(Options{Address: "127.0.0.1:0"}))
+}
@@ -39,6 +39,10 @@ var ErrIPv6SupportDisabled = errors.New("IPv6 support is disabled")
 //ErrNoRouterID is used when router-id is not set
 var ErrNoRouterID = errors.New("router-id not set in cloud provider config")
 
+// ErrRouteQuotaExceeded is used when a router has the maximum number of routes
+// allowed by Neutron
+var ErrRouteQuotaExceeded = errors.New("route quota of the router exceeded")
+
 func IsNotFound(err error) bool {
 	if _, ok := err.(gophercloud.ErrDefault404); ok {
 		return true
@@ -26,7 +26,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
 
 	"k8s.io/cloud-provider-openstack/pkg/util"
@@ -40,7 +42,6 @@ const (
 	// section "Metadata service
 	// https://docs.openstack.org/nova/latest/user/metadata-service.html
 	defaultMetadataVersion = "latest"
-	metadataURLTemplate    = "http://169.254.169.254/openstack/%s/meta_data.json"
 
 	// MetadataID is used as an identifier on the metadata search order configuration.
 	MetadataID = "metadataService"
@@ -53,6 +54,18 @@ const (
 
 	// ConfigDriveID is used as an identifier on the metadata search order configuration.
 	ConfigDriveID = "configDrive"
+
+	defaultRequestTimeout = 10 * time.Second
+	defaultRequestRetries = 3
+)
+
+var (
+	// requestRetryDelay is the delay before the first retry, doubled at each retry
+	requestRetryDelay   = time.Second
+	metadataURLTemplate = "http://169.254.169.254/openstack/%s/meta_data.json"
+	// productUUIDPath is the SMBIOS system UUID, which Nova sets to the UUID
+	// of the instance
+	productUUIDPath = "/sys/class/dmi/id/product_uuid"
 )
 
 // ErrBadMetadata is used to indicate a problem parsing data from metadata server
@@ -64,6 +77,9 @@ var MetadataService IMetadata
 // Metadata is fixed for the current host, so cache the value process-wide
 var metadataCache *Metadata
 
+// opts configure how the metadata is fetched, see SetOpts
+var opts Opts
+
 // revive:disable:exported
 // Deprecated: use Opts instead
 type MetadataOpts = Opts
@@ -73,6 +89,12 @@ type MetadataOpts = Opts
 type Opts struct {
 	SearchOrder    string          `gcfg:"search-order"`
 	RequestTimeout util.MyDuration `gcfg:"request-timeout"`
+	// RequestRetries is the number of times a failed request to the
+	// metadata service is retried, 3 if not set
+	RequestRetries int `gcfg:"request-retries"`
+	// VerifyInstanceIdentity checks that the UUID of the instance in the
+	// metadata is the SMBIOS system UUID of the host
+	VerifyInstanceIdentity bool `gcfg:"verify-instance-identity"`
 }
 
 // DeviceMetadata is a single/simplified data structure for all kinds of device metadata types.
@@ -113,6 +135,13 @@ func GetMetadataProvider(order string) IMetadata {
 	return MetadataService
 }
 
+// SetOpts sets the timeout and the retries of the requests to the metadata
+// service, and whether the instance identity is verified. It must be called
+// before the metadata is fetched.
+func SetOpts(o Opts) {
+	opts = o
+}
+
 // Set sets the value of metadatacache
 func Set(value *Metadata) {
 	metadataCache = value
@@ -196,19 +225,61 @@ func getFromConfigDrive(metadataVersion string) (*Metadata, error) {
 func getFromMetadataService(metadataVersion string) (*Metadata, error) {
 	// Try to get JSON from metadata server.
 	metadataURL := getMetadataURL(metadataVersion)
-	klog.V(4).Infof("Attempting to fetch metadata from %s", metadataURL)
-	resp, err := http.Get(metadataURL)
+
+	timeout := opts.RequestTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	retries := opts.RequestRetries
+	if retries <= 0 {
+		retries = defaultRequestRetries
+	}
+	client := &http.Client{Timeout: timeout}
+
+	delay := requestRetryDelay
+	for attempt := 0; ; attempt++ {
+		klog.V(4).Infof("Attempting to fetch metadata from %s", metadataURL)
+		md, retriable, err := fetchMetadata(client, metadataURL)
+		if err == nil || !retriable || attempt >= retries {
+			return md, err
+		}
+		klog.V(3).Infof("Failed to fetch metadata, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fetchMetadata gets the metadata from the URL. It reports whether a failed
+// request may be retried, i.e. whether the error is transient.
+func fetchMetadata(client *http.Client, metadataURL string) (*Metadata, bool, error) {
+	resp, err := client.Get(metadataURL)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching %s: %v", metadataURL, err)
+		return nil, true, fmt.Errorf("error fetching %s: %v", metadataURL, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		err = fmt.Errorf("unexpected status code when reading metadata from %s: %s", metadataURL, resp.Status)
-		return nil, err
+		return nil, resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests, err
 	}
 
-	return parseMetadata(resp.Body)
+	md, err := parseMetadata(resp.Body)
+	return md, false, err
+}
+
+// verifyInstanceIdentity checks that the UUID of the instance in the
+// metadata is the SMBIOS system UUID of the host, so metadata served for
+// another instance, e.g. by a spoofed metadata service, is not trusted.
+func verifyInstanceIdentity(md *Metadata) error {
+	data, err := ioutil.ReadFile(productUUIDPath)
+	if err != nil {
+		return fmt.Errorf("unable to read the system UUID to verify the instance identity: %v", err)
+	}
+	systemUUID := strings.TrimSpace(string(data))
+	if !strings.EqualFold(systemUUID, md.UUID) {
+		return fmt.Errorf("instance UUID %s in the metadata is not the system UUID %s", md.UUID, systemUUID)
+	}
+	return nil
 }
 
 // GetDevicePath retrieves device path from metadata service
@@ -262,10 +333,11 @@ func GetDevicePath(volumeID string) (string, error) {
 func Get(order string) (*Metadata, error) {
 	if metadataCache == nil {
 		var md *Metadata
-		var err error
+		var errs []error
 
 		elements := strings.Split(order, ",")
 		for _, id := range elements {
+			var err error
 			id = strings.TrimSpace(id)
 			switch id {
 			case ConfigDriveID:
@@ -275,14 +347,20 @@ func Get(order string) (*Metadata, error) {
 			default:
 				err = fmt.Errorf("%s is not a valid metadata search order option. Supported options are %s and %s", id, ConfigDriveID, MetadataID)
 			}
+			if err == nil && opts.VerifyInstanceIdentity {
+				err = verifyInstanceIdentity(md)
+			}
 
 			if err == nil {
 				break
 			}
+			klog.V(3).Infof("Failed to get metadata from %s: %v", id, err)
+			errs = append(errs, fmt.Errorf("%s: %v", id, err))
+			md = nil
 		}
 
-		if err != nil {
-			return nil, err
+		if md == nil {
+			return nil, utilerrors.NewAggregate(errs)
 		}
 		metadataCache = md
 	}
@@ -18,8 +18,13 @@ package metadata
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 var FakeMetadata = Metadata{
@@ -148,3 +153,53 @@ func TestCheckMetaDataOpts(t *testing.T) {
 		}
 	}
 }
+
+func TestGetFromMetadataService(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// The metadata service is overloaded at first
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"uuid": %q, "name": "test", "availability_zone": "nova"}`, FakeMetadata.UUID)
+	}))
+	defer server.Close()
+
+	defer func(template, path string, delay time.Duration) {
+		metadataURLTemplate, productUUIDPath, requestRetryDelay = template, path, delay
+		SetOpts(Opts{})
+		Clear()
+	}(metadataURLTemplate, productUUIDPath, requestRetryDelay)
+	metadataURLTemplate = server.URL + "/openstack/%s/meta_data.json"
+	productUUIDPath = filepath.Join(t.TempDir(), "product_uuid")
+	requestRetryDelay = 0
+
+	SetOpts(Opts{RequestRetries: 1})
+	md, err := Get(MetadataID)
+	if err != nil {
+		t.Fatalf("failed to get the metadata: %v", err)
+	}
+	if md.UUID != FakeMetadata.UUID || requests != 2 {
+		t.Errorf("unexpected metadata %v after %d requests", md, requests)
+	}
+
+	// The metadata of another instance is rejected
+	Clear()
+	SetOpts(Opts{VerifyInstanceIdentity: true})
+	if err := os.WriteFile(productUUIDPath, []byte("1B4E28BA-2FA1-11D2-883F-0016D3CCA427\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(MetadataID); err == nil {
+		t.Errorf("expected the metadata of another instance to be rejected")
+	}
+
+	// The system UUID may be upper case
+	if err := os.WriteFile(productUUIDPath, []byte(strings.ToUpper(FakeMetadata.UUID)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get(MetadataID); err != nil {
+		t.Errorf("failed to verify the instance identity: %v", err)
+	}
+}
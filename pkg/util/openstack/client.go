@@ -0,0 +1,52 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// WithContext returns a copy of the client whose requests are cancelled with
+// ctx, and each time out after timeout if positive. The copy reauthenticates
+// through the client, so the token renewed by either is used by both.
+func WithContext(ctx context.Context, client *gophercloud.ServiceClient, timeout time.Duration) *gophercloud.ServiceClient {
+	parent := client.ProviderClient
+	provider := *parent
+	provider.Context = ctx
+	if timeout > 0 {
+		provider.HTTPClient.Timeout = timeout
+	}
+	if parent.ReauthFunc != nil {
+		provider.ReauthFunc = func() error {
+			// The token may have been renewed through the client already
+			if parent.Token() == provider.Token() {
+				if err := parent.ReauthFunc(); err != nil {
+					return err
+				}
+			}
+			provider.CopyTokenFrom(parent)
+			return nil
+		}
+	}
+
+	sc := *client
+	sc.ProviderClient = &provider
+	return &sc
+}
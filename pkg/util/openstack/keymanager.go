@@ -17,11 +17,18 @@ limitations under the License.
 package openstack
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"strings"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+
 	"k8s.io/cloud-provider-openstack/pkg/metrics"
 )
 
@@ -126,3 +133,77 @@ func DeleteSecrets(client *gophercloud.ServiceClient, partName string) error {
 
 	return nil
 }
+
+// EncodePKCS12 converts a PEM certificate bundle and its PEM private key into
+// the base64 encoded PKCS#12 bundle Octavia accepts as TLS container. The rest
+// of the certificate bundle is assumed to be the intermediate certificates.
+func EncodePKCS12(certPEM []byte, keyPEM []byte) (string, error) {
+	pk, err := privateKeyFromPEM(keyPEM)
+	if err != nil {
+		return "", err
+	}
+	cb, err := ParsePEMBundle(certPEM)
+	if err != nil {
+		return "", err
+	}
+
+	var caCerts []*x509.Certificate
+	if len(cb) > 1 {
+		caCerts = append(caCerts, cb[1:]...)
+	}
+
+	pfxData, err := pkcs12.Encode(rand.Reader, pk, cb[0], caCerts, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to create PKCS#12 bundle: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(pfxData), nil
+}
+
+// privateKeyFromPEM converts a PEM block into a crypto.PrivateKey.
+func privateKeyFromPEM(pemData []byte) (crypto.PrivateKey, error) {
+	var result *pem.Block
+	rest := pemData
+	for {
+		result, rest = pem.Decode(rest)
+		if result == nil {
+			return nil, fmt.Errorf("cannot decode supplied PEM data")
+		}
+
+		switch result.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(result.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(result.Bytes)
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(result.Bytes)
+		}
+	}
+}
+
+// ParsePEMBundle parses a certificate bundle from top to bottom and returns
+// a slice of x509 certificates. This function will error if no certificates are found.
+func ParsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	var certDERBlock *pem.Block
+
+	for {
+		certDERBlock, bundle = pem.Decode(bundle)
+		if certDERBlock == nil {
+			break
+		}
+
+		if certDERBlock.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(certDERBlock.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, cert)
+		}
+	}
+
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificates were found while parsing the bundle")
+	}
+
+	return certificates, nil
+}
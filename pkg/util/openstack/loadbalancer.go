@@ -19,6 +19,7 @@ package openstack
 import (
 	"errors"
 	"fmt"
+	neturl "net/url"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
@@ -43,6 +44,9 @@ const (
 	OctaviaFeatureFlavors           = 2
 	OctaviaFeatureTimeout           = 3
 	OctaviaFeatureAvailabilityZones = 4
+	OctaviaFeatureSCTP              = 5
+	OctaviaFeaturePROXYV2           = 6
+	OctaviaFeatureAdditionalVIPs    = 7
 
 	waitLoadbalancerInitDelay   = 1 * time.Second
 	waitLoadbalancerFactor      = 1.2
@@ -140,6 +144,24 @@ func IsOctaviaFeatureSupported(client *gophercloud.ServiceClient, feature int, l
 		if currentVer.GreaterThanOrEqual(verAvailabilityZones) {
 			return true
 		}
+	case OctaviaFeatureSCTP:
+		verSCTP, _ := version.NewVersion("v2.23")
+		if currentVer.GreaterThanOrEqual(verSCTP) {
+			return true
+		}
+	case OctaviaFeaturePROXYV2:
+		verPROXYV2, _ := version.NewVersion("v2.22")
+		if currentVer.GreaterThanOrEqual(verPROXYV2) {
+			return true
+		}
+	case OctaviaFeatureAdditionalVIPs:
+		if lbProvider == "ovn" {
+			return false
+		}
+		verAdditionalVIPs, _ := version.NewVersion("v2.26")
+		if currentVer.GreaterThanOrEqual(verAdditionalVIPs) {
+			return true
+		}
 	default:
 		klog.Warningf("Feature %d not recognized", feature)
 	}
@@ -179,6 +201,44 @@ func WaitLoadbalancerActive(client *gophercloud.ServiceClient, loadbalancerID st
 	return err
 }
 
+// AdditionalVIP is an additional VIP of a load balancer, in another subnet than
+// its VIP. New in Octavia API v2.26.
+type AdditionalVIP struct {
+	SubnetID  string `json:"subnet_id"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// LoadBalancerCreateOpts adds the additional VIPs to loadbalancers.CreateOpts.
+type LoadBalancerCreateOpts struct {
+	loadbalancers.CreateOpts
+	AdditionalVIPs []AdditionalVIP `json:"additional_vips,omitempty"`
+}
+
+// ToLoadBalancerCreateMap builds a request body from LoadBalancerCreateOpts.
+func (opts LoadBalancerCreateOpts) ToLoadBalancerCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToLoadBalancerCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.AdditionalVIPs) > 0 {
+		b["loadbalancer"].(map[string]interface{})["additional_vips"] = opts.AdditionalVIPs
+	}
+	return b, nil
+}
+
+// GetAdditionalVIPs returns the additional VIPs of a load balancer.
+func GetAdditionalVIPs(client *gophercloud.ServiceClient, lbID string) ([]AdditionalVIP, error) {
+	var lb struct {
+		AdditionalVIPs []AdditionalVIP `json:"additional_vips"`
+	}
+	mc := metrics.NewMetricContext("loadbalancer", "get")
+	err := loadbalancers.Get(client, lbID).ExtractIntoStructPtr(&lb, "loadbalancer")
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+	return lb.AdditionalVIPs, nil
+}
+
 // GetLoadBalancers returns all the filtered load balancer.
 func GetLoadBalancers(client *gophercloud.ServiceClient, opts loadbalancers.ListOpts) ([]loadbalancers.LoadBalancer, error) {
 	mc := metrics.NewMetricContext("loadbalancer", "list")
@@ -205,6 +265,38 @@ func GetLoadbalancerByID(client *gophercloud.ServiceClient, lbID string) (*loadb
 	return lb, nil
 }
 
+// GetFlavorIDByName returns the ID of the enabled load balancer flavor of the given name.
+func GetFlavorIDByName(client *gophercloud.ServiceClient, name string) (string, error) {
+	var body struct {
+		Flavors []struct {
+			ID      string `json:"id"`
+			Name    string `json:"name"`
+			Enabled bool   `json:"enabled"`
+		} `json:"flavors"`
+	}
+	url := client.ServiceURL("lbaas", "flavors") + "?" + neturl.Values{"name": []string{name}}.Encode()
+	mc := metrics.NewMetricContext("flavor", "list")
+	_, err := client.Get(url, &body, nil)
+	if mc.ObserveRequest(err) != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, flavor := range body.Flavors {
+		if flavor.Name == name && flavor.Enabled {
+			ids = append(ids, flavor.ID)
+		}
+	}
+	if len(ids) > 1 {
+		return "", ErrMultipleResults
+	}
+	if len(ids) == 0 {
+		return "", ErrNotFound
+	}
+
+	return ids[0], nil
+}
+
 // GetLoadbalancerByName retrieves loadbalancer object
 func GetLoadbalancerByName(client *gophercloud.ServiceClient, name string) (*loadbalancers.LoadBalancer, error) {
 	opts := loadbalancers.ListOpts{
@@ -399,6 +491,29 @@ func GetListenersByLoadBalancerID(client *gophercloud.ServiceClient, lbID string
 	return lbListeners, nil
 }
 
+// GetListenerStats gets the statistics of the given listener.
+func GetListenerStats(client *gophercloud.ServiceClient, listenerID string) (*listeners.Stats, error) {
+	mc := metrics.NewMetricContext("loadbalancer_listener_stats", "get")
+	stats, err := listeners.GetStats(client, listenerID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// GetLoadBalancerStats gets the statistics of the given load balancer, the
+// sums of the statistics of its listeners.
+func GetLoadBalancerStats(client *gophercloud.ServiceClient, lbID string) (*loadbalancers.Stats, error) {
+	mc := metrics.NewMetricContext("loadbalancer_stats", "get")
+	stats, err := loadbalancers.GetStats(client, lbID).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
 // CreatePool creates a new pool.
 func CreatePool(client *gophercloud.ServiceClient, opts pools.CreateOptsBuilder, lbID string) (*pools.Pool, error) {
 	mc := metrics.NewMetricContext("loadbalancer_pool", "create")
@@ -414,6 +529,21 @@ func CreatePool(client *gophercloud.ServiceClient, opts pools.CreateOptsBuilder,
 	return pool, nil
 }
 
+// UpdatePool updates a pool and wait for the lb active
+func UpdatePool(client *gophercloud.ServiceClient, lbID string, poolID string, opts pools.UpdateOptsBuilder) error {
+	mc := metrics.NewMetricContext("loadbalancer_pool", "update")
+	_, err := pools.Update(client, poolID, opts).Extract()
+	if mc.ObserveRequest(err) != nil {
+		return err
+	}
+
+	if err := WaitLoadbalancerActive(client, lbID); err != nil {
+		return fmt.Errorf("failed to wait for load balancer %s ACTIVE after updating pool: %v", lbID, err)
+	}
+
+	return nil
+}
+
 // GetPoolByName gets a pool by its name, raise error if not found or get multiple ones.
 func GetPoolByName(client *gophercloud.ServiceClient, name string, lbID string) (*pools.Pool, error) {
 	var listenerPools []pools.Pool
@@ -0,0 +1,693 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeopenstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pborman/uuid"
+)
+
+// ProjectID is the project of the resources created through the API.
+const ProjectID = "fake-project"
+
+// collection is a collection of resources of an API, e.g. the Neutron ports.
+type collection struct {
+	// path is the path of the collection, {parent} standing for the ID of the
+	// parent resource, e.g. load-balancer/v2.0/lbaas/pools/{parent}/members
+	path []string
+	// name is the key of the lists of the collection, unique across the APIs
+	name string
+	// singular is the key of the resources of the collection
+	singular string
+	// parent is the field of the resources referencing their parent resource,
+	// a resource of collection parentCollection
+	parent           string
+	parentCollection string
+	// unwrapped is set when the resources are not wrapped in an object
+	// keyed by singular, as in Designate
+	unwrapped bool
+	// neutron is set for the Neutron collections, which support tags
+	neutron bool
+
+	createCode, updateCode, deleteCode int
+	// defaults are the default values of the fields of the new resources
+	defaults Resource
+	// created, updated and deleted are called after the resource is created,
+	// updated or deleted, to maintain the resources referencing it
+	created, updated, deleted func(s *Server, r Resource)
+}
+
+// match returns the ID of the parent resource and the segments following the
+// path of the collection if the path belongs to the collection.
+func (c *collection) match(segments []string) (string, []string, bool) {
+	if len(segments) < len(c.path) {
+		return "", nil, false
+	}
+	parentID := ""
+	for i, segment := range c.path {
+		switch {
+		case segment == "{parent}":
+			parentID = segments[i]
+		case segment != segments[i]:
+			return "", nil, false
+		}
+	}
+	return parentID, segments[len(c.path):], true
+}
+
+// decode returns the resource of the body of the request.
+func (c *collection) decode(r *http.Request) (Resource, error) {
+	var body Resource
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode the request body: %v", err)
+	}
+	if c.unwrapped {
+		return body, nil
+	}
+	res, ok := body[c.singular].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the request body has no %s", c.singular)
+	}
+	return res, nil
+}
+
+// encode returns the response body of the resource.
+func (c *collection) encode(r Resource) interface{} {
+	if c.unwrapped {
+		return r
+	}
+	return map[string]interface{}{c.singular: r}
+}
+
+func newCollection(path, name, singular string, createCode, updateCode, deleteCode int, defaults Resource) *collection {
+	return &collection{
+		path:       strings.Split(path, "/"),
+		name:       name,
+		singular:   singular,
+		createCode: createCode,
+		updateCode: updateCode,
+		deleteCode: deleteCode,
+		defaults:   defaults,
+	}
+}
+
+func neutron(path, name, singular string, defaults Resource) *collection {
+	defaults["project_id"], defaults["tenant_id"], defaults["tags"] = ProjectID, ProjectID, []string{}
+	c := newCollection("network/v2.0/"+path, name, singular, http.StatusCreated, http.StatusOK, http.StatusNoContent, defaults)
+	c.neutron = true
+	return c
+}
+
+func octavia(path, name, singular string, defaults Resource) *collection {
+	defaults["project_id"], defaults["tags"], defaults["admin_state_up"] = ProjectID, []string{}, true
+	defaults["provisioning_status"], defaults["operating_status"] = "ACTIVE", "ONLINE"
+	return newCollection("load-balancer/v2.0/lbaas/"+path, name, singular, http.StatusCreated, http.StatusOK, http.StatusNoContent, defaults)
+}
+
+func cinder(path, name, singular string, defaults Resource) *collection {
+	return newCollection("volumev3/"+path, name, singular, http.StatusAccepted, http.StatusOK, http.StatusAccepted, defaults)
+}
+
+func designate(path, name string, defaults Resource) *collection {
+	defaults["project_id"], defaults["status"] = ProjectID, "ACTIVE"
+	c := newCollection("dns/v2/"+path, name, "", http.StatusCreated, http.StatusAccepted, http.StatusAccepted, defaults)
+	c.unwrapped = true
+	return c
+}
+
+// withParent sets the parent of the resources of the collection.
+func withParent(c *collection, parent, parentCollection string) *collection {
+	c.parent, c.parentCollection = parent, parentCollection
+	return c
+}
+
+// withHooks sets the hooks of the collection.
+func withHooks(c *collection, created, updated, deleted func(s *Server, r Resource)) *collection {
+	c.created, c.updated, c.deleted = created, updated, deleted
+	return c
+}
+
+// collections are the collections served, the nested collections first.
+var collections []*collection
+
+func init() {
+	collections = []*collection{
+		withHooks(withParent(octavia("pools/{parent}/members", "members", "member", Resource{"weight": 1, "backup": false}), "pool_id", "pools"),
+			(*Server).memberCreated, nil, (*Server).memberDeleted),
+		withHooks(withParent(octavia("l7policies/{parent}/rules", "rules", "rule", Resource{"invert": false}), "l7policy_id", "l7policies"),
+			(*Server).ruleCreated, nil, (*Server).ruleDeleted),
+		withHooks(withParent(designate("zones/{parent}/recordsets", "recordsets", Resource{"records": []string{}}), "zone_id", "zones"),
+			(*Server).recordSetCreated, nil, nil),
+
+		neutron("networks", "networks", "network", Resource{"status": "ACTIVE", "admin_state_up": true, "subnets": []string{}, "router:external": false}),
+		withHooks(neutron("subnets", "subnets", "subnet", Resource{"ip_version": 4, "enable_dhcp": true}),
+			(*Server).subnetCreated, nil, (*Server).subnetDeleted),
+		withHooks(neutron("ports", "ports", "port", Resource{"status": "ACTIVE", "admin_state_up": true, "fixed_ips": []interface{}{}, "security_groups": []string{}, "allowed_address_pairs": []interface{}{}, "device_id": "", "device_owner": ""}),
+			(*Server).portCreated, nil, (*Server).portDeleted),
+		withHooks(neutron("floatingips", "floatingips", "floatingip", Resource{"port_id": nil, "fixed_ip_address": nil, "description": ""}),
+			(*Server).floatingIPCreated, (*Server).floatingIPUpdated, nil),
+		withHooks(neutron("security-groups", "security_groups", "security_group", Resource{"description": "", "security_group_rules": []interface{}{}}),
+			nil, nil, (*Server).securityGroupDeleted),
+		withHooks(neutron("security-group-rules", "security_group_rules", "security_group_rule", Resource{"remote_ip_prefix": nil, "remote_group_id": nil}),
+			(*Server).securityGroupRuleCreated, nil, (*Server).securityGroupRuleDeleted),
+		neutron("routers", "routers", "router", Resource{"status": "ACTIVE", "admin_state_up": true, "routes": []interface{}{}}),
+
+		withHooks(octavia("loadbalancers", "loadbalancers", "loadbalancer", Resource{"provider": "amphora", "listeners": []interface{}{}, "pools": []interface{}{}, "description": ""}),
+			(*Server).loadBalancerCreated, nil, (*Server).loadBalancerDeleted),
+		withHooks(octavia("listeners", "listeners", "listener", Resource{"default_pool_id": "", "l7policies": []interface{}{}, "allowed_cidrs": nil, "insert_headers": map[string]interface{}{}}),
+			(*Server).listenerCreated, nil, (*Server).listenerDeleted),
+		withHooks(octavia("pools", "pools", "pool", Resource{"members": []interface{}{}, "healthmonitor_id": "", "description": ""}),
+			(*Server).poolCreated, nil, (*Server).poolDeleted),
+		withHooks(octavia("healthmonitors", "healthmonitors", "healthmonitor", Resource{"http_method": "GET", "url_path": "/", "expected_codes": "200"}),
+			(*Server).healthMonitorCreated, nil, (*Server).healthMonitorDeleted),
+		withHooks(octavia("l7policies", "l7policies", "l7policy", Resource{"rules": []interface{}{}, "description": ""}),
+			(*Server).l7PolicyCreated, nil, (*Server).l7PolicyDeleted),
+
+		cinder("volumes", "volumes", "volume", Resource{"status": "available", "attachments": []interface{}{}, "availability_zone": "nova", "metadata": map[string]interface{}{}, "multiattach": false, "bootable": "false"}),
+		withHooks(cinder("snapshots", "snapshots", "snapshot", Resource{"status": "available", "metadata": map[string]interface{}{}}),
+			(*Server).snapshotCreated, nil, nil),
+
+		newCollection("compute/servers", "servers", "server", http.StatusAccepted, http.StatusOK, http.StatusNoContent,
+			Resource{"status": "ACTIVE", "addresses": map[string]interface{}{}, "metadata": map[string]interface{}{}, "OS-EXT-AZ:availability_zone": "nova", "tenant_id": ProjectID}),
+
+		designate("zones", "zones", Resource{"type": "PRIMARY", "ttl": 3600}),
+	}
+}
+
+// findCollection returns the collection of the name, nil if not found.
+func findCollection(name string) *collection {
+	for _, c := range collections {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// staticResponses are the responses of the paths which do not serve resources.
+var staticResponses = map[string]interface{}{
+	"network/v2.0/extensions": map[string]interface{}{"extensions": []interface{}{
+		map[string]interface{}{"alias": "security-group", "name": "security-group"},
+		map[string]interface{}{"alias": "standard-attr-tag", "name": "Tag support for resources with standard attribute"},
+		map[string]interface{}{"alias": "allowed-address-pairs", "name": "Allowed Address Pairs"},
+		map[string]interface{}{"alias": "extraroute", "name": "Neutron Extra Route"},
+	}},
+	"load-balancer": map[string]interface{}{"versions": []interface{}{
+		map[string]interface{}{"id": "v2.25", "status": "CURRENT"},
+	}},
+	"load-balancer/v2.0/lbaas/providers": map[string]interface{}{"providers": []interface{}{
+		map[string]interface{}{"name": "amphora", "description": "Amphora driver"},
+		map[string]interface{}{"name": "octavia", "description": "Deprecated alias of the Amphora driver"},
+		map[string]interface{}{"name": "ovn", "description": "OVN driver"},
+	}},
+	"load-balancer/v2.0/lbaas/flavors":           map[string]interface{}{"flavors": []interface{}{}},
+	"load-balancer/v2.0/lbaas/availabilityzones": map[string]interface{}{"availability_zones": []interface{}{}},
+	"compute/os-availability-zone": map[string]interface{}{"availabilityZoneInfo": []interface{}{
+		map[string]interface{}{"zoneName": "nova", "zoneState": map[string]interface{}{"available": true}},
+	}},
+	"volumev3/os-availability-zone": map[string]interface{}{"availabilityZoneInfo": []interface{}{
+		map[string]interface{}{"zoneName": "nova", "zoneState": map[string]interface{}{"available": true}},
+	}},
+}
+
+// serveAction serves the requests of the paths below a resource, e.g. the
+// tags of a Neutron resource or the actions of a Cinder volume.
+func (s *Server) serveAction(w http.ResponseWriter, r *http.Request, c *collection, res Resource, action []string) {
+	switch {
+	case c.neutron && action[0] == "tags":
+		s.serveTags(w, r, res, action[1:])
+	case c.name == "volumes" && len(action) == 1 && action[0] == "action" && r.Method == http.MethodPost:
+		var body map[string]map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "failed to decode the request body: %v", err)
+			return
+		}
+		if extend, ok := body["os-extend"]; ok {
+			res["size"] = extend["new_size"]
+		}
+		if reset, ok := body["os-reset_status"]; ok {
+			res["status"] = reset["status"]
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case c.name == "servers" && action[0] == "os-volume_attachments":
+		s.serveVolumeAttachments(w, r, res, action[1:])
+	case c.name == "servers" && len(action) == 1 && action[0] == "os-interface" && r.Method == http.MethodGet:
+		interfaces := []interface{}{}
+		for _, port := range s.where("ports", "device_id", res["id"].(string)) {
+			interfaces = append(interfaces, map[string]interface{}{
+				"port_id": port["id"], "net_id": port["network_id"], "mac_addr": port["mac_address"],
+				"fixed_ips": port["fixed_ips"], "port_state": port["status"],
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"interfaceAttachments": interfaces})
+	case (c.name == "loadbalancers" || c.name == "listeners") && len(action) == 1 && action[0] == "stats" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"stats": map[string]interface{}{
+			"active_connections": 0, "bytes_in": 0, "bytes_out": 0, "request_errors": 0, "total_connections": 0,
+		}})
+	default:
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request, res Resource, tag []string) {
+	tags, _ := res["tags"].([]interface{})
+	switch {
+	case len(tag) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tags": tags})
+	case len(tag) == 0 && r.Method == http.MethodPut:
+		var body struct {
+			Tags []interface{} `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "failed to decode the request body: %v", err)
+			return
+		}
+		res["tags"] = body.Tags
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tags": body.Tags})
+	case len(tag) == 0 && r.Method == http.MethodDelete:
+		res["tags"] = []interface{}{}
+		w.WriteHeader(http.StatusNoContent)
+	case len(tag) == 1 && r.Method == http.MethodGet:
+		if !sets(tags)[tag[0]] {
+			writeError(w, http.StatusNotFound, "tag %s not found", tag[0])
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(tag) == 1 && r.Method == http.MethodPut:
+		if !sets(tags)[tag[0]] {
+			res["tags"] = append(tags, tag[0])
+		}
+		w.WriteHeader(http.StatusCreated)
+	case len(tag) == 1 && r.Method == http.MethodDelete:
+		kept := []interface{}{}
+		for _, t := range tags {
+			if t != tag[0] {
+				kept = append(kept, t)
+			}
+		}
+		res["tags"] = kept
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+// serveVolumeAttachments serves the Nova volume attachments of the server,
+// stored in the attachments of the Cinder volumes.
+func (s *Server) serveVolumeAttachments(w http.ResponseWriter, r *http.Request, server Resource, volumeID []string) {
+	serverID := server["id"].(string)
+	switch {
+	case len(volumeID) == 0 && r.Method == http.MethodGet:
+		attachments := []interface{}{}
+		for _, id := range s.order["volumes"] {
+			if a := volumeAttachment(s.resources["volumes"][id], serverID); a != nil {
+				attachments = append(attachments, a)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"volumeAttachments": attachments})
+	case len(volumeID) == 0 && r.Method == http.MethodPost:
+		var body struct {
+			VolumeAttachment struct {
+				VolumeID string `json:"volumeId"`
+				Device   string `json:"device"`
+			} `json:"volumeAttachment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "failed to decode the request body: %v", err)
+			return
+		}
+		volume := s.resources["volumes"][body.VolumeAttachment.VolumeID]
+		if volume == nil {
+			writeError(w, http.StatusNotFound, "volume %s not found", body.VolumeAttachment.VolumeID)
+			return
+		}
+		if volumeAttachment(volume, serverID) != nil {
+			writeError(w, http.StatusConflict, "volume %s is already attached to server %s", volume["id"], serverID)
+			return
+		}
+		device := body.VolumeAttachment.Device
+		if device == "" {
+			attached := 0
+			for _, id := range s.order["volumes"] {
+				if volumeAttachment(s.resources["volumes"][id], serverID) != nil {
+					attached++
+				}
+			}
+			device = fmt.Sprintf("/dev/vd%c", 'b'+attached)
+		}
+		attachments, _ := volume["attachments"].([]interface{})
+		volume["attachments"] = append(attachments, map[string]interface{}{
+			"id": volume["id"], "attachment_id": uuid.New(), "server_id": serverID, "volume_id": volume["id"], "device": device,
+		})
+		volume["status"] = "in-use"
+		writeJSON(w, http.StatusOK, map[string]interface{}{"volumeAttachment": volumeAttachment(volume, serverID)})
+	case len(volumeID) == 1:
+		volume := s.resources["volumes"][volumeID[0]]
+		attachment := volumeAttachment(volume, serverID)
+		if attachment == nil {
+			writeError(w, http.StatusNotFound, "volume %s is not attached to server %s", volumeID[0], serverID)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"volumeAttachment": attachment})
+		case http.MethodDelete:
+			attachments, _ := volume["attachments"].([]interface{})
+			kept := []interface{}{}
+			for _, a := range attachments {
+				if m, ok := a.(map[string]interface{}); !ok || m["server_id"] != serverID {
+					kept = append(kept, a)
+				}
+			}
+			volume["attachments"] = kept
+			if len(kept) == 0 {
+				volume["status"] = "available"
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+	}
+}
+
+// volumeAttachment returns the Nova attachment of the volume to the server,
+// nil if the volume is not attached to the server.
+func volumeAttachment(volume Resource, serverID string) map[string]interface{} {
+	attachments, _ := volume["attachments"].([]interface{})
+	for _, a := range attachments {
+		if m, ok := a.(map[string]interface{}); ok && m["server_id"] == serverID {
+			return map[string]interface{}{"id": m["attachment_id"], "volumeId": m["volume_id"], "serverId": serverID, "device": m["device"]}
+		}
+	}
+	return nil
+}
+
+// batchUpdateMembers replaces the members of the pool.
+func (s *Server) batchUpdateMembers(w http.ResponseWriter, r *http.Request, poolID string) {
+	var body struct {
+		Members []Resource `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "failed to decode the request body: %v", err)
+		return
+	}
+	members := findCollection("members")
+	s.deleteWhere("members", "pool_id", poolID)
+	for _, m := range body.Members {
+		m["pool_id"] = poolID
+		s.create(members, m)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func (s *Server) subnetCreated(r Resource) {
+	if str(r["gateway_ip"]) == "" {
+		if cidr := str(r["cidr"]); strings.Count(cidr, ".") == 3 {
+			r["gateway_ip"] = cidr[:strings.LastIndex(cidr, ".")] + ".1"
+		}
+	}
+	if network := s.resources["networks"][str(r["network_id"])]; network != nil {
+		subnets, _ := network["subnets"].([]interface{})
+		network["subnets"] = append(subnets, r["id"])
+	}
+}
+
+func (s *Server) subnetDeleted(r Resource) {
+	if network := s.resources["networks"][str(r["network_id"])]; network != nil {
+		subnets, _ := network["subnets"].([]interface{})
+		kept := []interface{}{}
+		for _, id := range subnets {
+			if id != r["id"] {
+				kept = append(kept, id)
+			}
+		}
+		network["subnets"] = kept
+	}
+}
+
+func (s *Server) portCreated(r Resource) {
+	if str(r["mac_address"]) == "" {
+		s.lastIP++
+		r["mac_address"] = fmt.Sprintf("fa:16:3e:00:%02x:%02x", s.lastIP/256%256, s.lastIP%256)
+	}
+	fixedIPs, _ := r["fixed_ips"].([]interface{})
+	if len(fixedIPs) == 0 {
+		for _, id := range s.order["subnets"] {
+			if subnet := s.resources["subnets"][id]; subnet["network_id"] == r["network_id"] {
+				fixedIPs = append(fixedIPs, map[string]interface{}{"subnet_id": id})
+				break
+			}
+		}
+	}
+	for _, ip := range fixedIPs {
+		if m, ok := ip.(map[string]interface{}); ok && str(m["ip_address"]) == "" {
+			m["ip_address"] = s.allocateIP("10.0.0")
+		}
+	}
+	r["fixed_ips"] = fixedIPs
+}
+
+func (s *Server) portDeleted(r Resource) {
+	// The floating IPs are disassociated from the deleted port
+	for _, fip := range s.where("floatingips", "port_id", r["id"].(string)) {
+		fip["port_id"] = nil
+		s.floatingIPUpdated(fip)
+	}
+}
+
+func (s *Server) floatingIPCreated(r Resource) {
+	if str(r["floating_ip_address"]) == "" {
+		r["floating_ip_address"] = s.allocateIP("172.24.4")
+	}
+	s.floatingIPUpdated(r)
+}
+
+func (s *Server) floatingIPUpdated(r Resource) {
+	port := s.resources["ports"][str(r["port_id"])]
+	if port == nil {
+		r["port_id"], r["fixed_ip_address"], r["status"] = nil, nil, "DOWN"
+		return
+	}
+	if str(r["fixed_ip_address"]) == "" {
+		if fixedIPs, _ := port["fixed_ips"].([]interface{}); len(fixedIPs) > 0 {
+			r["fixed_ip_address"] = fixedIPs[0].(map[string]interface{})["ip_address"]
+		}
+	}
+	r["status"] = "ACTIVE"
+}
+
+func (s *Server) securityGroupDeleted(r Resource) {
+	s.deleteWhere("security_group_rules", "security_group_id", r["id"].(string))
+}
+
+func (s *Server) securityGroupRuleCreated(r Resource) {
+	if sg := s.resources["security_groups"][str(r["security_group_id"])]; sg != nil {
+		rules, _ := sg["security_group_rules"].([]interface{})
+		sg["security_group_rules"] = append(rules, map[string]interface{}(r))
+	}
+}
+
+func (s *Server) securityGroupRuleDeleted(r Resource) {
+	s.removeRef("security_groups", str(r["security_group_id"]), "security_group_rules", r["id"].(string))
+}
+
+// loadBalancerCreated allocates the VIP of the load balancer and creates its
+// listeners, pools, members and health monitors when it is fully populated.
+func (s *Server) loadBalancerCreated(r Resource) {
+	id := r["id"].(string)
+	subnetID := str(r["vip_subnet_id"])
+	if str(r["vip_network_id"]) == "" {
+		if subnet := s.resources["subnets"][subnetID]; subnet != nil {
+			r["vip_network_id"] = subnet["network_id"]
+		}
+	}
+	if str(r["vip_address"]) == "" {
+		r["vip_address"] = s.allocateIP("10.0.0")
+	}
+	port := s.create(findCollection("ports"), Resource{
+		"name":         "octavia-lb-" + id,
+		"network_id":   r["vip_network_id"],
+		"device_id":    "lb-" + id,
+		"device_owner": "Octavia",
+		"fixed_ips":    []interface{}{map[string]interface{}{"subnet_id": subnetID, "ip_address": r["vip_address"]}},
+	})
+	r["vip_port_id"] = port["id"]
+
+	listeners, _ := r["listeners"].([]interface{})
+	pools, _ := r["pools"].([]interface{})
+	r["listeners"], r["pools"] = []interface{}{}, []interface{}{}
+	for _, l := range listeners {
+		listener, ok := l.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pool, _ := listener["default_pool"].(map[string]interface{})
+		delete(listener, "default_pool")
+		listener["loadbalancer_id"] = id
+		created := s.create(findCollection("listeners"), listener)
+		if pool != nil {
+			pool["listener_id"], pool["loadbalancer_id"] = created["id"], id
+			s.create(findCollection("pools"), pool)
+		}
+	}
+	for _, p := range pools {
+		if pool, ok := p.(map[string]interface{}); ok {
+			pool["loadbalancer_id"] = id
+			s.create(findCollection("pools"), pool)
+		}
+	}
+}
+
+func (s *Server) loadBalancerDeleted(r Resource) {
+	id := r["id"].(string)
+	s.deleteWhere("listeners", "loadbalancer_id", id)
+	s.deleteWhere("pools", "loadbalancer_id", id)
+	if port := s.resources["ports"][str(r["vip_port_id"])]; port != nil {
+		s.delete(findCollection("ports"), port)
+	}
+}
+
+func (s *Server) listenerCreated(r Resource) {
+	lbID := str(r["loadbalancer_id"])
+	r["loadbalancers"] = []interface{}{map[string]interface{}{"id": lbID}}
+	s.addRef("loadbalancers", lbID, "listeners", r["id"].(string))
+	if poolID := str(r["default_pool_id"]); poolID != "" {
+		s.addRef("pools", poolID, "listeners", r["id"].(string))
+	}
+}
+
+func (s *Server) listenerDeleted(r Resource) {
+	id := r["id"].(string)
+	s.removeRef("loadbalancers", str(r["loadbalancer_id"]), "listeners", id)
+	s.deleteWhere("l7policies", "listener_id", id)
+	for _, poolID := range s.order["pools"] {
+		s.removeRef("pools", poolID, "listeners", id)
+	}
+}
+
+// poolCreated makes the pool the default pool of its listener, and creates
+// its members and health monitor.
+func (s *Server) poolCreated(r Resource) {
+	id := r["id"].(string)
+	r["listeners"] = []interface{}{}
+	if listener := s.resources["listeners"][str(r["listener_id"])]; listener != nil {
+		if str(r["loadbalancer_id"]) == "" {
+			r["loadbalancer_id"] = listener["loadbalancer_id"]
+		}
+		listener["default_pool_id"] = id
+		r["listeners"] = []interface{}{map[string]interface{}{"id": listener["id"]}}
+	}
+	lbID := str(r["loadbalancer_id"])
+	r["loadbalancers"] = []interface{}{map[string]interface{}{"id": lbID}}
+	s.addRef("loadbalancers", lbID, "pools", id)
+
+	members, _ := r["members"].([]interface{})
+	r["members"] = []interface{}{}
+	for _, m := range members {
+		if member, ok := m.(map[string]interface{}); ok {
+			member["pool_id"] = id
+			s.create(findCollection("members"), member)
+		}
+	}
+	if monitor, ok := r["healthmonitor"].(map[string]interface{}); ok {
+		delete(r, "healthmonitor")
+		monitor["pool_id"] = id
+		s.create(findCollection("healthmonitors"), monitor)
+	}
+}
+
+func (s *Server) poolDeleted(r Resource) {
+	id := r["id"].(string)
+	s.removeRef("loadbalancers", str(r["loadbalancer_id"]), "pools", id)
+	for _, listener := range s.where("listeners", "default_pool_id", id) {
+		listener["default_pool_id"] = ""
+	}
+	s.deleteWhere("healthmonitors", "pool_id", id)
+}
+
+func (s *Server) memberCreated(r Resource) {
+	s.addRef("pools", str(r["pool_id"]), "members", r["id"].(string))
+}
+
+func (s *Server) memberDeleted(r Resource) {
+	s.removeRef("pools", str(r["pool_id"]), "members", r["id"].(string))
+}
+
+func (s *Server) healthMonitorCreated(r Resource) {
+	if pool := s.resources["pools"][str(r["pool_id"])]; pool != nil {
+		pool["healthmonitor_id"] = r["id"]
+		r["pools"] = []interface{}{map[string]interface{}{"id": pool["id"]}}
+	}
+}
+
+func (s *Server) healthMonitorDeleted(r Resource) {
+	if pool := s.resources["pools"][str(r["pool_id"])]; pool != nil {
+		pool["healthmonitor_id"] = ""
+	}
+}
+
+func (s *Server) l7PolicyCreated(r Resource) {
+	id := r["id"].(string)
+	if listener := s.resources["listeners"][str(r["listener_id"])]; listener != nil {
+		r["loadbalancer_id"] = listener["loadbalancer_id"]
+	}
+	s.addRef("listeners", str(r["listener_id"]), "l7policies", id)
+
+	rules, _ := r["rules"].([]interface{})
+	r["rules"] = []interface{}{}
+	for _, rule := range rules {
+		if rule, ok := rule.(map[string]interface{}); ok {
+			rule["l7policy_id"] = id
+			s.create(findCollection("rules"), rule)
+		}
+	}
+}
+
+func (s *Server) l7PolicyDeleted(r Resource) {
+	s.removeRef("listeners", str(r["listener_id"]), "l7policies", r["id"].(string))
+}
+
+func (s *Server) ruleCreated(r Resource) {
+	s.addRef("l7policies", str(r["l7policy_id"]), "rules", r["id"].(string))
+}
+
+func (s *Server) ruleDeleted(r Resource) {
+	s.removeRef("l7policies", str(r["l7policy_id"]), "rules", r["id"].(string))
+}
+
+func (s *Server) snapshotCreated(r Resource) {
+	if volume := s.resources["volumes"][str(r["volume_id"])]; volume != nil {
+		r["size"] = volume["size"]
+	}
+}
+
+func (s *Server) recordSetCreated(r Resource) {
+	if zone := s.resources["zones"][str(r["zone_id"])]; zone != nil {
+		r["zone_name"] = zone["name"]
+	}
+}
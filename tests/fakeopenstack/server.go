@@ -0,0 +1,389 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeopenstack is an in-memory fake of the subsets of the OpenStack
+// APIs used by the controllers and the CSI drivers, to integration test and
+// develop them without a cloud.
+package fakeopenstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pborman/uuid"
+	"k8s.io/klog/v2"
+)
+
+// Resource is an OpenStack resource, as its JSON object.
+type Resource map[string]interface{}
+
+// Server serves the Neutron, Octavia, Cinder, Nova and Designate APIs under
+// the path of their service type, e.g. /network/ for Neutron and
+// /load-balancer/ for Octavia, the layout expected by the
+// --cloud-api-endpoint-override flag. The resources are stored in memory, and
+// are ACTIVE or available as soon as they are created. The requests are not
+// authenticated.
+type Server struct {
+	mu sync.Mutex
+	// resources are the resources by collection and ID
+	resources map[string]map[string]Resource
+	// order is the creation order of the IDs of each collection, the order of the lists
+	order map[string][]string
+	// lastIP is the last octet of the last address allocated
+	lastIP int
+}
+
+// NewServer returns a server without any resource.
+func NewServer() *Server {
+	return &Server{
+		resources: make(map[string]map[string]Resource),
+		order:     make(map[string][]string),
+	}
+}
+
+// Create creates a resource of the collection, e.g. servers or networks, as if
+// it was created through the API, and returns it.
+func (s *Server) Create(collection string, r Resource) (Resource, error) {
+	c := findCollection(collection)
+	if c == nil {
+		return nil, fmt.Errorf("unknown collection %s", collection)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return clone(s.create(c, clone(r))), nil
+}
+
+// Get returns the resource of the collection, nil if not found.
+func (s *Server) Get(collection, id string) Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.resources[collection][id]; r != nil {
+		return clone(r)
+	}
+	return nil
+}
+
+// List returns the resources of the collection in their creation order.
+func (s *Server) List(collection string) []Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Resource
+	for _, id := range s.order[collection] {
+		list = append(list, clone(s.resources[collection][id]))
+	}
+	return list
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	klog.V(4).Infof("%s %s", r.Method, r.URL)
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if body, ok := staticResponses[strings.Join(segments, "/")]; ok && r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, body)
+		return
+	}
+
+	for _, c := range collections {
+		parentID, rest, ok := c.match(segments)
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.serveCollection(w, r, c, parentID, rest)
+		return
+	}
+	writeError(w, http.StatusNotFound, "unknown path %s", r.URL.Path)
+}
+
+func (s *Server) serveCollection(w http.ResponseWriter, r *http.Request, c *collection, parentID string, rest []string) {
+	if parentID != "" && s.resources[c.parentCollection][parentID] == nil {
+		writeError(w, http.StatusNotFound, "%s %s not found", c.parentCollection, parentID)
+		return
+	}
+
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet, len(rest) == 1 && rest[0] == "detail" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{c.name: s.list(c, parentID, r)})
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		body, err := c.decode(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if c.parent != "" {
+			body[c.parent] = parentID
+		}
+		writeJSON(w, c.createCode, c.encode(s.create(c, body)))
+	case len(rest) == 0 && r.Method == http.MethodPut && c.name == "members":
+		s.batchUpdateMembers(w, r, parentID)
+	case len(rest) == 0:
+		writeError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+	default:
+		res := s.resources[c.name][rest[0]]
+		if res == nil || (c.parent != "" && res[c.parent] != parentID) {
+			writeError(w, http.StatusNotFound, "%s %s not found", c.singular, rest[0])
+			return
+		}
+		if len(rest) > 1 {
+			s.serveAction(w, r, c, res, rest[1:])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, c.encode(res))
+		case http.MethodPut, http.MethodPatch:
+			body, err := c.decode(r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "%v", err)
+				return
+			}
+			for k, v := range body {
+				if k != "id" {
+					res[k] = v
+				}
+			}
+			if c.updated != nil {
+				c.updated(s, res)
+			}
+			writeJSON(w, c.updateCode, c.encode(res))
+		case http.MethodDelete:
+			s.delete(c, res)
+			w.WriteHeader(c.deleteCode)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "unsupported method %s", r.Method)
+		}
+	}
+}
+
+// create stores the new resource of the collection with its defaults.
+func (s *Server) create(c *collection, r Resource) Resource {
+	if id, _ := r["id"].(string); id == "" {
+		r["id"] = uuid.New()
+	}
+	for k, v := range c.defaults {
+		if _, ok := r[k]; !ok {
+			r[k] = clone(Resource{"v": v})["v"]
+		}
+	}
+	id := r["id"].(string)
+	if s.resources[c.name] == nil {
+		s.resources[c.name] = make(map[string]Resource)
+	}
+	if s.resources[c.name][id] == nil {
+		s.order[c.name] = append(s.order[c.name], id)
+	}
+	s.resources[c.name][id] = r
+	if c.created != nil {
+		c.created(s, r)
+	}
+	return r
+}
+
+// delete removes the resource and its children.
+func (s *Server) delete(c *collection, r Resource) {
+	id := r["id"].(string)
+	if _, ok := s.resources[c.name][id]; !ok {
+		return
+	}
+	delete(s.resources[c.name], id)
+	for i, oid := range s.order[c.name] {
+		if oid == id {
+			s.order[c.name] = append(s.order[c.name][:i:i], s.order[c.name][i+1:]...)
+			break
+		}
+	}
+	if c.deleted != nil {
+		c.deleted(s, r)
+	}
+	for _, child := range collections {
+		if child.parentCollection == c.name {
+			s.deleteWhere(child.name, child.parent, id)
+		}
+	}
+}
+
+// deleteWhere deletes the resources of the collection whose field is value.
+func (s *Server) deleteWhere(collection, field, value string) {
+	c := findCollection(collection)
+	for _, r := range s.where(collection, field, value) {
+		s.delete(c, r)
+	}
+}
+
+// where returns the resources of the collection whose field is value.
+func (s *Server) where(collection, field, value string) []Resource {
+	var list []Resource
+	for _, id := range s.order[collection] {
+		if r := s.resources[collection][id]; r[field] == value {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// list returns the resources of the collection matching the query.
+func (s *Server) list(c *collection, parentID string, r *http.Request) []Resource {
+	list := []Resource{}
+	for _, id := range s.order[c.name] {
+		res := s.resources[c.name][id]
+		if c.parent != "" && res[c.parent] != parentID {
+			continue
+		}
+		if matchesQuery(res, r.URL.Query()) {
+			list = append(list, res)
+		}
+	}
+	return list
+}
+
+// ignoredFilters are the query parameters of the lists which are not filters.
+var ignoredFilters = map[string]bool{
+	"limit": true, "marker": true, "sort": true, "sort_key": true, "sort_dir": true,
+	"fields": true, "page_reverse": true, "all_tenants": true, "all_projects": true,
+}
+
+// matchesQuery returns whether the resource matches the filters of the query.
+func matchesQuery(r Resource, query map[string][]string) bool {
+	for key, values := range query {
+		if ignoredFilters[key] {
+			continue
+		}
+		matched := false
+		for _, value := range values {
+			if matchesFilter(r, key, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesFilter(r Resource, key, value string) bool {
+	switch key {
+	case "tags", "tags-any":
+		tags := sets(r["tags"])
+		for _, tag := range strings.Split(value, ",") {
+			if key == "tags" && !tags[tag] {
+				return false
+			}
+			if key == "tags-any" && tags[tag] {
+				return true
+			}
+		}
+		return key == "tags"
+	}
+
+	field, ok := r[key]
+	if !ok {
+		// e.g. the loadbalancer_id filter of the Octavia pools, which
+		// reference their load balancers in loadbalancers
+		return sets(ids(r[strings.TrimSuffix(key, "_id")+"s"]))[value]
+	}
+	if s, ok := field.(string); ok && strings.HasPrefix(value, "^") {
+		// The Nova servers are filtered by a regular expression of their name
+		re, err := regexp.Compile(value)
+		return err == nil && re.MatchString(s)
+	}
+	return fmt.Sprint(field) == value
+}
+
+func (s *Server) allocateIP(prefix string) string {
+	s.lastIP++
+	return fmt.Sprintf("%s.%d", prefix, s.lastIP%250+2)
+}
+
+// addRef adds the reference {"id": refID} to the list field of the resource.
+func (s *Server) addRef(collection, id, field, refID string) {
+	if r := s.resources[collection][id]; r != nil {
+		refs, _ := r[field].([]interface{})
+		r[field] = append(refs, map[string]interface{}{"id": refID})
+	}
+}
+
+// removeRef removes the reference {"id": refID} from the list field of the resource.
+func (s *Server) removeRef(collection, id, field, refID string) {
+	if r := s.resources[collection][id]; r != nil {
+		refs, _ := r[field].([]interface{})
+		kept := []interface{}{}
+		for _, ref := range refs {
+			if m, ok := ref.(map[string]interface{}); !ok || m["id"] != refID {
+				kept = append(kept, ref)
+			}
+		}
+		r[field] = kept
+	}
+}
+
+// ids returns the IDs of a list of references.
+func ids(v interface{}) []string {
+	var list []string
+	refs, _ := v.([]interface{})
+	for _, ref := range refs {
+		if m, ok := ref.(map[string]interface{}); ok {
+			if id, ok := m["id"].(string); ok {
+				list = append(list, id)
+			}
+		}
+	}
+	return list
+}
+
+// sets returns the set of the strings of a list.
+func sets(v interface{}) map[string]bool {
+	set := make(map[string]bool)
+	switch list := v.(type) {
+	case []interface{}:
+		for _, s := range list {
+			if s, ok := s.(string); ok {
+				set[s] = true
+			}
+		}
+	case []string:
+		for _, s := range list {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+func clone(r Resource) Resource {
+	data, _ := json.Marshal(r)
+	var c Resource
+	_ = json.Unmarshal(data, &c)
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		klog.Errorf("Failed to write the response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJSON(w, code, map[string]interface{}{"error": map[string]interface{}{"code": code, "message": fmt.Sprintf(format, args...)}})
+}
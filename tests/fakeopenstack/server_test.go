@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeopenstack
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/cloud-provider-openstack/pkg/client"
+)
+
+func newProvider(t *testing.T) (*Server, *gophercloud.ProviderClient) {
+	server := NewServer()
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	provider, err := client.NewEndpointOverrideClient(ts.URL)
+	require.NoError(t, err)
+	_, err = server.Create("networks", Resource{"id": "net1", "name": "private"})
+	require.NoError(t, err)
+	_, err = server.Create("subnets", Resource{"id": "subnet1", "network_id": "net1", "cidr": "10.0.0.0/24"})
+	require.NoError(t, err)
+	return server, provider
+}
+
+func TestLoadBalancer(t *testing.T) {
+	server, provider := newProvider(t)
+	lbClient, err := openstack.NewLoadBalancerV2(provider, gophercloud.EndpointOpts{})
+	require.NoError(t, err)
+
+	// A fully populated load balancer
+	lb, err := loadbalancers.Create(lbClient, loadbalancers.CreateOpts{
+		Name:        "lb",
+		VipSubnetID: "subnet1",
+		Listeners: []listeners.CreateOpts{{
+			Name:         "listener",
+			Protocol:     listeners.ProtocolTCP,
+			ProtocolPort: 80,
+			DefaultPool: &pools.CreateOpts{
+				Name:     "pool",
+				Protocol: pools.ProtocolTCP,
+				LBMethod: pools.LBMethodRoundRobin,
+				Members:  []pools.BatchUpdateMemberOpts{{Address: "10.0.0.10", ProtocolPort: 30080}},
+			},
+		}},
+	}).Extract()
+	require.NoError(t, err)
+	assert.Equal(t, "ACTIVE", lb.ProvisioningStatus)
+	assert.NotEmpty(t, lb.VipAddress)
+	assert.NotNil(t, server.Get("ports", lb.VipPortID))
+
+	allPages, err := listeners.List(lbClient, listeners.ListOpts{LoadbalancerID: lb.ID}).AllPages()
+	require.NoError(t, err)
+	allListeners, err := listeners.ExtractListeners(allPages)
+	require.NoError(t, err)
+	require.Len(t, allListeners, 1)
+	assert.Equal(t, 80, allListeners[0].ProtocolPort)
+
+	allPages, err = pools.ListMembers(lbClient, allListeners[0].DefaultPoolID, pools.ListMembersOpts{}).AllPages()
+	require.NoError(t, err)
+	members, err := pools.ExtractMembers(allPages)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "10.0.0.10", members[0].Address)
+
+	// The members are replaced
+	err = pools.BatchUpdateMembers(lbClient, allListeners[0].DefaultPoolID, []pools.BatchUpdateMemberOpts{
+		{Address: "10.0.0.11", ProtocolPort: 30080},
+		{Address: "10.0.0.12", ProtocolPort: 30080},
+	}).ExtractErr()
+	require.NoError(t, err)
+	pool, err := pools.Get(lbClient, allListeners[0].DefaultPoolID).Extract()
+	require.NoError(t, err)
+	assert.Len(t, pool.Members, 2)
+
+	// The load balancer is deleted with its resources
+	err = loadbalancers.Delete(lbClient, lb.ID, loadbalancers.DeleteOpts{Cascade: true}).ExtractErr()
+	require.NoError(t, err)
+	assert.Empty(t, server.List("listeners"))
+	assert.Empty(t, server.List("pools"))
+	assert.Empty(t, server.List("members"))
+	assert.Nil(t, server.Get("ports", lb.VipPortID))
+}
+
+func TestFloatingIP(t *testing.T) {
+	_, provider := newProvider(t)
+	network, err := openstack.NewNetworkV2(provider, gophercloud.EndpointOpts{})
+	require.NoError(t, err)
+
+	port, err := ports.Create(network, ports.CreateOpts{NetworkID: "net1", Name: "port"}).Extract()
+	require.NoError(t, err)
+	require.Len(t, port.FixedIPs, 1)
+
+	fip, err := floatingips.Create(network, floatingips.CreateOpts{FloatingNetworkID: "public", PortID: port.ID}).Extract()
+	require.NoError(t, err)
+	assert.Equal(t, port.FixedIPs[0].IPAddress, fip.FixedIP)
+
+	allPages, err := floatingips.List(network, floatingips.ListOpts{PortID: port.ID}).AllPages()
+	require.NoError(t, err)
+	fips, err := floatingips.ExtractFloatingIPs(allPages)
+	require.NoError(t, err)
+	assert.Len(t, fips, 1)
+
+	portID := ""
+	fip, err = floatingips.Update(network, fip.ID, floatingips.UpdateOpts{PortID: &portID}).Extract()
+	require.NoError(t, err)
+	assert.Empty(t, fip.PortID)
+	assert.Empty(t, fip.FixedIP)
+
+	err = attributestags.Add(network, "floatingips", fip.ID, "kube_service_cluster").ExtractErr()
+	require.NoError(t, err)
+	allPages, err = floatingips.List(network, floatingips.ListOpts{Tags: "kube_service_cluster"}).AllPages()
+	require.NoError(t, err)
+	fips, err = floatingips.ExtractFloatingIPs(allPages)
+	require.NoError(t, err)
+	assert.Len(t, fips, 1)
+}
+
+func TestVolumeAttachment(t *testing.T) {
+	server, provider := newProvider(t)
+	_, err := server.Create("servers", Resource{"id": "server1", "name": "node-1"})
+	require.NoError(t, err)
+	blockStorage, err := openstack.NewBlockStorageV3(provider, gophercloud.EndpointOpts{})
+	require.NoError(t, err)
+	compute, err := openstack.NewComputeV2(provider, gophercloud.EndpointOpts{})
+	require.NoError(t, err)
+
+	vol, err := volumes.Create(blockStorage, volumes.CreateOpts{Name: "pv", Size: 1}).Extract()
+	require.NoError(t, err)
+	assert.Equal(t, "available", vol.Status)
+
+	attachment, err := volumeattach.Create(compute, "server1", volumeattach.CreateOpts{VolumeID: vol.ID}).Extract()
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/vdb", attachment.Device)
+	vol, err = volumes.Get(blockStorage, vol.ID).Extract()
+	require.NoError(t, err)
+	assert.Equal(t, "in-use", vol.Status)
+	require.Len(t, vol.Attachments, 1)
+	assert.Equal(t, "server1", vol.Attachments[0].ServerID)
+
+	err = volumeattach.Delete(compute, "server1", vol.ID).ExtractErr()
+	require.NoError(t, err)
+	vol, err = volumes.Get(blockStorage, vol.ID).Extract()
+	require.NoError(t, err)
+	assert.Equal(t, "available", vol.Status)
+	assert.Empty(t, vol.Attachments)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/schedulerhints"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
@@ -32,7 +33,7 @@ func getfakecloud() *cloud {
 var _ openstack.IOpenStack = &cloud{}
 
 // Fake Cloud
-func (cloud *cloud) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, tags *map[string]string) (*volumes.Volume, error) {
+func (cloud *cloud) CreateVolume(name string, size int, vtype, availability string, snapshotID string, sourceVolID string, tags *map[string]string, schedulerHints *schedulerhints.SchedulerHints) (*volumes.Volume, error) {
 
 	vol := &volumes.Volume{
 		ID:               randString(10),
@@ -263,3 +264,11 @@ func (cloud *cloud) GetMetadataOpts() metadata.Opts {
 func (cloud *cloud) GetBlockStorageOpts() openstack.BlockStorageOpts {
 	return openstack.BlockStorageOpts{}
 }
+
+func (cloud *cloud) ForProject(projectID string) (openstack.IOpenStack, error) {
+	return cloud, nil
+}
+
+func (cloud *cloud) ForSecrets(secrets map[string]string) (openstack.IOpenStack, error) {
+	return cloud, nil
+}
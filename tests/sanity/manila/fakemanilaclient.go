@@ -21,7 +21,9 @@ import (
 	"strconv"
 
 	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/messages"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharetypes"
 	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/snapshots"
@@ -118,6 +120,33 @@ func (c fakeManilaClient) DeleteShare(shareID string) error {
 	return nil
 }
 
+func (c fakeManilaClient) UpdateShare(shareID string, opts shares.UpdateOptsBuilder) (*shares.Share, error) {
+	share, err := c.GetShareByID(shareID)
+	if err != nil {
+		return nil, err
+	}
+
+	updateMap, err := opts.ToShareUpdateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	if name, ok := updateMap["share"].(map[string]interface{})["display_name"].(string); ok {
+		share.Name = name
+	}
+
+	return share, nil
+}
+
+func (c fakeManilaClient) ListShares(opts shares.ListOptsBuilder) ([]shares.Share, error) {
+	var res []shares.Share
+	for _, share := range fakeShares {
+		res = append(res, *share)
+	}
+
+	return res, nil
+}
+
 func (c fakeManilaClient) ExtendShare(shareID string, opts shares.ExtendOptsBuilder) error {
 	share, err := c.GetShareByID(shareID)
 	if err != nil {
@@ -147,6 +176,14 @@ func (c fakeManilaClient) GetExportLocations(shareID string) ([]shares.ExportLoc
 	return []shares.ExportLocation{{Path: "fake-server:/fake-path"}}, nil
 }
 
+func (c fakeManilaClient) GetShareReplicas(shareID string) ([]manilaclient.ShareReplica, error) {
+	if !shareExists(shareID) {
+		return nil, gophercloud.ErrResourceNotFound{}
+	}
+
+	return nil, nil
+}
+
 func (c fakeManilaClient) SetShareMetadata(shareID string, opts shares.SetMetadataOptsBuilder) (map[string]string, error) {
 	return nil, nil
 }
@@ -269,3 +306,15 @@ func (c fakeManilaClient) DeleteSnapshot(snapID string) error {
 func (c fakeManilaClient) GetUserMessages(opts messages.ListOptsBuilder) ([]messages.Message, error) {
 	return nil, nil
 }
+
+func (c fakeManilaClient) GetShareNetworks(opts sharenetworks.ListOptsBuilder) ([]sharenetworks.ShareNetwork, error) {
+	return nil, nil
+}
+
+func (c fakeManilaClient) CreateShareNetwork(opts sharenetworks.CreateOptsBuilder) (*sharenetworks.ShareNetwork, error) {
+	return nil, fmt.Errorf("share networks are not supported")
+}
+
+func (c fakeManilaClient) GetInstancePorts(instanceID string) ([]ports.Port, error) {
+	return nil, nil
+}